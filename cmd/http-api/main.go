@@ -3,14 +3,14 @@ package http_api
 import (
 	"OMEGA3-IOT/internal/config"
 	"OMEGA3-IOT/internal/handler"
-	"OMEGA3-IOT/internal/handler/MiddleWares"
-	"OMEGA3-IOT/internal/logger"
-	"OMEGA3-IOT/internal/push"
 	"OMEGA3-IOT/internal/service"
 	"OMEGA3-IOT/internal/utils"
+	"crypto/tls"
+	"errors"
 	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
 	"log"
+	"net/http"
 )
 
 // @title IOT HTTP API
@@ -19,7 +19,9 @@ import (
 // @host localhost:1222
 // @BasePath /api/v1
 
-func Run(mqttService *service.MQTTService, userHandler *handler.UserHandler, deviceHandler *handler.DeviceHandler, logHandler *logger.LogHandler, config config.Config, deviceService *service.DeviceService, deviceShareService *service.DeviceShareService, deviceFolderHandler *handler.DeviceFolderHandler, jwtAuth *MiddleWares.JWTAuth, pushHandler *push.PushHandler, userGroupHandler *handler.UserGroupHandler, adminHandler *handler.AdminHandler, publicInstanceService *service.PublicInstanceService) error {
+// Run serves the HTTP API. deviceCertService is nil when pki.enabled is off; otherwise devices may
+// authenticate with a client certificate of the built-in CA.
+func Run(config config.Config, deps handler.Dependencies, deviceCertService *service.DeviceCertificateService) error {
 
 	log.Println("[HTTP_API] Run function called")
 
@@ -32,7 +34,12 @@ func Run(mqttService *service.MQTTService, userHandler *handler.UserHandler, dev
 		AllowHeaders: []string{"Origin", "Content-Type", "Authorization"},
 	}))
 
-	handler.RegRoutes(r, userHandler, deviceHandler, logHandler, deviceService, deviceShareService, deviceFolderHandler, mqttService, jwtAuth, pushHandler, userGroupHandler, adminHandler, publicInstanceService)
+	if config.Server.RequireClientCertForDevices && (!config.Server.TLSEnabled || deviceCertService == nil) {
+		return errors.New("server.require_client_cert_for_devices needs server.tls_enabled and pki.enabled")
+	}
+
+	deps.RequireDeviceCert = config.Server.RequireClientCertForDevices
+	handler.RegRoutes(r, deps)
 
 	log.Println("Starting server on :" + config.Server.Port)
 
@@ -42,7 +49,22 @@ func Run(mqttService *service.MQTTService, userHandler *handler.UserHandler, dev
 			log.Fatalf("Failed to prepare TLS certificates: %v", err)
 		}
 		log.Printf("TLS enabled: cert=%s, key=%s", certFile, keyFile)
-		return r.RunTLS(":"+config.Server.Port, certFile, keyFile)
+		if deviceCertService == nil {
+			return r.RunTLS(":"+config.Server.Port, certFile, keyFile)
+		}
+		// Devices may present a client certificate of the built-in CA; routes that require one
+		// check it with MiddleWares.DeviceCertificateAuth (all device routes with
+		// server.require_client_cert_for_devices). Users connect to the same listener without one.
+		server := &http.Server{
+			Addr:    ":" + config.Server.Port,
+			Handler: r.Handler(),
+			TLSConfig: &tls.Config{
+				ClientCAs:  deviceCertService.CertPool(),
+				ClientAuth: tls.VerifyClientCertIfGiven,
+				MinVersion: tls.VersionTLS12,
+			},
+		}
+		return server.ListenAndServeTLS(certFile, keyFile)
 	}
 
 	return r.Run(":" + config.Server.Port)
@@ -21,11 +21,19 @@ type Config struct {
 		TLSEnabled bool   `mapstructure:"tls_enabled"`
 		CertFile   string `mapstructure:"cert_file"`
 		KeyFile    string `mapstructure:"key_file"`
+		// RequireClientCertForDevices makes every /api/v1/device route require a device client
+		// certificate of the built-in CA; needs tls_enabled and pki
+		RequireClientCertForDevices bool `mapstructure:"require_client_cert_for_devices"`
 	} `mapstructure:"server"`
 	MQTT struct {
-		Broker Broker `mapstructure:"broker"`
-		Client Client `mapstructure:"client"`
-		TLS    TLS    `mapstructure:"tls"`
+		Broker   Broker         `mapstructure:"broker"`
+		Client   Client         `mapstructure:"client"`
+		TLS      TLS            `mapstructure:"tls"`
+		Embedded EmbeddedBroker `mapstructure:"embedded"`
+		AuthHook AuthHook       `mapstructure:"auth_hook"`
+		// LegacyVerifyCode keeps accepting the deprecated verify_code in message bodies, for
+		// firmware that does not authenticate its connection
+		LegacyVerifyCode bool `mapstructure:"legacy_verify_code"`
 	}
 	IoTDB struct {
 		Host           string `mapstructure:"host"`
@@ -35,16 +43,73 @@ type Config struct {
 		QueryTimeoutMs int64  `mapstructure:"queryTimeoutMs"`
 		Pool           Pool   `mapstructure:"pool"`
 	}
+	TimeSeries struct {
+		Backend     string `mapstructure:"backend"` // iotdb (default) or embedded
+		EmbeddedDir string `mapstructure:"embedded_dir"`
+	} `mapstructure:"timeseries"`
 	Redis struct {
 		Host     string `mapstructure:"host"`
 		Port     int    `mapstructure:"port"`
 		Password string `mapstructure:"password"`
 		DB       int    `mapstructure:"db"`
 	} `mapstructure:"redis"`
+	EventBus struct {
+		Backend         string `mapstructure:"backend"` // memory (default) or redis
+		StreamPrefix    string `mapstructure:"stream_prefix"`
+		StreamMaxLen    int64  `mapstructure:"stream_max_len"`
+		ClaimIdleSec    int    `mapstructure:"claim_idle_sec"`
+		MaxDeliveries   int64  `mapstructure:"max_deliveries"`
+		QueueSize       int    `mapstructure:"queue_size"`
+		Workers         int    `mapstructure:"workers"`
+		BlockTimeoutMs  int    `mapstructure:"block_timeout_ms"`
+		MaxAttempts     int    `mapstructure:"max_attempts"`
+		RetryBackoffMs  int    `mapstructure:"retry_backoff_ms"`
+		DrainTimeoutSec int    `mapstructure:"drain_timeout_sec"`
+	} `mapstructure:"event_bus"`
 	DevicePresence struct {
 		OfflineTimeoutSec int `mapstructure:"offline_timeout_sec"`
 		CheckIntervalSec  int `mapstructure:"check_interval_sec"`
 	} `mapstructure:"device_presence"`
+	DeviceTypes struct {
+		File             string `mapstructure:"file"`
+		WatchIntervalSec int    `mapstructure:"watch_interval_sec"`
+	} `mapstructure:"device_types"`
+	Webhook struct {
+		MaxAttempts          int `mapstructure:"max_attempts"`
+		TimeoutSec           int `mapstructure:"timeout_sec"`
+		DisableAfterFailures int `mapstructure:"disable_after_failures"`
+	} `mapstructure:"webhook"`
+	TelemetryExport struct {
+		Dir               string `mapstructure:"dir"`
+		MaxSyncRangeHours int    `mapstructure:"max_sync_range_hours"`
+		RetentionHours    int    `mapstructure:"retention_hours"`
+		MaxPendingJobs    int    `mapstructure:"max_pending_jobs"`
+	} `mapstructure:"telemetry_export"`
+	TelemetryIngest struct {
+		BatchSize             int    `mapstructure:"batch_size"`
+		FlushIntervalMs       int    `mapstructure:"flush_interval_ms"`
+		BufferSize            int    `mapstructure:"buffer_size"`
+		EnqueueTimeoutMs      int    `mapstructure:"enqueue_timeout_ms"`
+		MaxTimestampAgeSec    int    `mapstructure:"max_timestamp_age_sec"`
+		MaxTimestampFutureSec int    `mapstructure:"max_timestamp_future_sec"`
+		OutOfWindow           string `mapstructure:"out_of_window"`
+	} `mapstructure:"telemetry_ingest"`
+	Retention struct {
+		DefaultDays    int `mapstructure:"default_days"`
+		UserLogDays    int `mapstructure:"user_log_days"`
+		QuarantineDays int `mapstructure:"quarantine_days"`
+		IntervalHours  int `mapstructure:"interval_hours"`
+	} `mapstructure:"retention"`
+	// 内置 CA，为设备签发客户端证书（CN / URI SAN 为设备 UUID）
+	PKI struct {
+		Enabled      bool   `mapstructure:"enabled"`
+		Dir          string `mapstructure:"dir"` // ca.crt / ca.key，首次启动时生成
+		ValidityDays int    `mapstructure:"validity_days"`
+	} `mapstructure:"pki"`
+	DeviceCredentials struct {
+		GracePeriodHours  int `mapstructure:"grace_period_hours"`
+		ResetCodeTTLHours int `mapstructure:"reset_code_ttl_hours"`
+	} `mapstructure:"device_credentials"`
 }
 
 type Broker struct {
@@ -71,6 +136,26 @@ type Client struct {
 	QoS           byte   `mapstructure:"qos"` // 注意：Viper 默认可能解析为 int，需要处理
 }
 
+// 内置 MQTT Broker 配置。启用后设备直接连接本服务，无需外部 Broker
+type EmbeddedBroker struct {
+	Enabled       bool   `mapstructure:"enabled"`
+	Listen        string `mapstructure:"listen"`          // e.g. ":1883"
+	MaxPacketSize int    `mapstructure:"max_packet_size"` // bytes, 0 uses the broker default
+	// TLS 监听，设置 client_ca_file 时设备可用客户端证书（CN 为设备 UUID）登录
+	TLSListen    string `mapstructure:"tls_listen"` // e.g. ":8883", empty disables TLS
+	CertFile     string `mapstructure:"cert_file"`  // 留空则自动生成自签名证书
+	KeyFile      string `mapstructure:"key_file"`
+	ClientCAFile string `mapstructure:"client_ca_file"` // 启用 pki 且留空时使用内置 CA
+	// 要求 TLS 连接出示有效的设备证书（mTLS），不再接受用户名密码登录
+	RequireClientCert bool `mapstructure:"require_client_cert"`
+}
+
+// 外部 MQTT Broker 的 HTTP 鉴权钩子（如 EMQX HTTP 认证 / 授权）
+type AuthHook struct {
+	Enabled bool   `mapstructure:"enabled"`
+	Secret  string `mapstructure:"secret"` // Broker 以 Authorization: Bearer <secret> 调用钩子
+}
+
 // TLS 配置（用于 MQTT Broker 连接）
 type TLS struct {
 	Enabled        bool   `mapstructure:"enabled"`
@@ -81,10 +166,6 @@ type TLS struct {
 var RequiredFlags = []string{
 	"database.mysqldsn",
 	"server.port",
-	"iotdb.host",
-	"iotdb.port",
-	"mqtt.broker.host",
-	"mqtt.broker.port",
 	"mqtt.client.id",
 }
 
@@ -219,10 +300,6 @@ func checkRequiredFlags() {
 	requiredFlags := []string{
 		"database.mysqldsn",
 		"server.port",
-		"iotdb.host",
-		"iotdb.port",
-		"mqtt.broker.host",
-		"mqtt.broker.port",
 		"mqtt.client.id",
 	}
 
@@ -251,10 +328,15 @@ func defineFlags() {
 	pflag.Bool("server.tls_enabled", false, "Enable HTTPS (optional, auto-generates self-signed cert if cert_file/key_file empty)")
 	pflag.String("server.cert_file", "", "TLS certificate file path (optional, auto-generated if empty)")
 	pflag.String("server.key_file", "", "TLS private key file path (optional, auto-generated if empty)")
+	pflag.Bool("server.require_client_cert_for_devices", false, "Require a device client certificate (mTLS) on every device route (needs server.tls_enabled and pki)")
+
+	// 时序存储后端
+	pflag.String("timeseries.backend", "iotdb", "时序存储后端 (iotdb/embedded)")
+	pflag.String("timeseries.embedded_dir", "./data/tsdb", "内置时序存储目录 (backend=embedded)")
 
 	// IoTDB配置
-	pflag.String("iotdb.host", "", "IoTDB Host (Required)")
-	pflag.String("iotdb.port", "", "IoTDB Port (Required)")
+	pflag.String("iotdb.host", "", "IoTDB Host (Required when timeseries.backend=iotdb)")
+	pflag.String("iotdb.port", "", "IoTDB Port (Required when timeseries.backend=iotdb)")
 	pflag.String("iotdb.username", "root", "IoTDB Username (Required)")
 	pflag.String("iotdb.password", "root", "IoTDB Password (Required)")
 	pflag.Int64("iotdb.querytimeoutms", 30000, "IoTDB query timeout (Required)")
@@ -265,8 +347,8 @@ func defineFlags() {
 	pflag.Bool("iotdb.pool.fetchmetadataauto", true, "IoTDB 自动获取元数据")
 
 	// MQTT Broker配置
-	pflag.String("mqtt.broker.host", "", "MQTT Broker 主机 (必需)")
-	pflag.Int("mqtt.broker.port", 0, "MQTT Broker 端口 (必需)")
+	pflag.String("mqtt.broker.host", "", "MQTT Broker 主机 (未启用内置 Broker 时必需)")
+	pflag.Int("mqtt.broker.port", 0, "MQTT Broker 端口 (未启用内置 Broker 时必需)")
 	pflag.String("mqtt.broker.protocol", "tcp", "MQTT Broker 协议 (tcp/ssl)")
 
 	// MQTT Client配置
@@ -283,12 +365,50 @@ func defineFlags() {
 	pflag.String("mqtt.tls.client_cert_file", "", "MQTT client certificate file")
 	pflag.String("mqtt.tls.client_key_file", "", "MQTT client key file")
 
+	// 内置 MQTT Broker
+	pflag.Bool("mqtt.embedded.enabled", false, "启用内置 MQTT Broker (无需外部 Broker)")
+	pflag.String("mqtt.embedded.listen", ":1883", "内置 MQTT Broker 监听地址")
+	pflag.Int("mqtt.embedded.max_packet_size", 262144, "内置 MQTT Broker 最大报文字节数")
+	pflag.String("mqtt.embedded.tls_listen", "", "内置 MQTT Broker TLS 监听地址 (留空不启用)")
+	pflag.String("mqtt.embedded.cert_file", "", "内置 MQTT Broker TLS 证书 (留空自动生成)")
+	pflag.String("mqtt.embedded.key_file", "", "内置 MQTT Broker TLS 私钥 (留空自动生成)")
+	pflag.String("mqtt.embedded.client_ca_file", "", "设备客户端证书的 CA (留空时使用内置 CA，未启用 pki 则不接受客户端证书)")
+	pflag.Bool("mqtt.embedded.require_client_cert", false, "内置 MQTT Broker TLS 连接要求设备证书 (mTLS)")
+
+	// 设备连接鉴权
+	pflag.Bool("mqtt.auth_hook.enabled", false, "启用外部 MQTT Broker 的 HTTP 鉴权钩子")
+	pflag.String("mqtt.auth_hook.secret", "", "鉴权钩子的 Bearer 密钥")
+	pflag.Bool("mqtt.legacy_verify_code", false, "兼容旧固件：接受消息体中的 verify_code (已废弃)")
+
+	// 设备证书 (内置 CA)
+	pflag.Bool("pki.enabled", false, "启用内置 CA，为设备签发客户端证书")
+	pflag.String("pki.dir", "./data/pki", "内置 CA 证书与私钥目录")
+	pflag.Int("pki.validity_days", 365, "设备证书有效期 (天)")
+
+	// 设备凭据轮换
+	pflag.Int("device_credentials.grace_period_hours", 24, "验证码轮换后旧验证码的宽限期 (小时)")
+	pflag.Int("device_credentials.reset_code_ttl_hours", 24, "硬重置后重置码的有效期 (小时)")
+
 	// Redis配置
 	pflag.String("redis.host", "localhost", "Redis Host")
 	pflag.Int("redis.port", 22251, "Redis Port")
 	pflag.String("redis.password", "", "Redis Password")
 	pflag.Int("redis.db", 0, "Redis DB number")
 
+	// 事件总线
+	pflag.String("event_bus.backend", "memory", "事件总线后端 (memory, redis)")
+	pflag.String("event_bus.stream_prefix", "eventbus:", "Redis Streams 键前缀")
+	pflag.Int64("event_bus.stream_max_len", 100000, "每个事件类型的 Stream 最大长度 (近似)")
+	pflag.Int("event_bus.claim_idle_sec", 60, "未确认事件空闲多久后重新投递 (秒)")
+	pflag.Int64("event_bus.max_deliveries", 10, "事件最多投递次数，超过后转入死信")
+	pflag.Int("event_bus.queue_size", 1024, "每个订阅的事件队列长度")
+	pflag.Int("event_bus.workers", 4, "每个订阅并发处理事件的 worker 数")
+	pflag.String("event_bus.overflow", "drop_oldest", "队列满时的策略 (drop_oldest, drop_newest, block)")
+	pflag.Int("event_bus.block_timeout_ms", 5000, "block 策略下发布方最长等待时间 (毫秒)，超时丢弃事件")
+	pflag.Int("event_bus.max_attempts", 3, "处理失败的事件最多尝试次数，超过后转入死信")
+	pflag.Int("event_bus.retry_backoff_ms", 500, "首次重试前的等待时间 (毫秒)，之后每次翻倍")
+	pflag.Int("event_bus.drain_timeout_sec", 10, "关闭时处理剩余队列的最长时间 (秒)")
+
 	pflag.StringP("config", "c", "", "配置文件路径 (可选)")
 
 	pflag.BoolP("help", "h", false, "显示帮助信息")
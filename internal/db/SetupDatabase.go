@@ -38,6 +38,20 @@ func InitDB(config config.Config) {
 		&model.GroupInvite{},
 		&model.GroupDeviceShare{},
 		&model.AdminLog{},
+		&model.ActionRecord{},
+		&model.DeviceShadow{},
+		&model.AlertRule{},
+		&model.Webhook{},
+		&model.WebhookDelivery{},
+		&model.DeviceTypeRecord{},
+		&model.TelemetryExportJob{},
+		&model.RetentionOverride{},
+		&model.DeviceEventRecord{},
+		&model.DeviceEventParam{},
+		&model.QuarantinedEvent{},
+		&model.DeviceCertificate{},
+		&model.EventDeadLetter{},
+		&model.OfflineMessage{},
 	); err != nil {
 		log.Fatal(err)
 	}
@@ -0,0 +1,540 @@
+package db
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/apache/iotdb-client-go/client"
+)
+
+const (
+	embeddedSchemaFile = "schema.json"
+	embeddedDayLayout  = "2006-01-02"
+	embeddedDataSuffix = ".ndjson"
+	embeddedDayMs      = int64(24 * time.Hour / time.Millisecond)
+)
+
+// EmbeddedPoint is one row of a series: the values written at a timestamp (ms), keyed by
+// measurement.
+type EmbeddedPoint struct {
+	Timestamp int64                  `json:"t"`
+	Values    map[string]interface{} `json:"v"`
+}
+
+// EmbeddedTSDB is a file based time-series store for deployments without IoTDB. Each series is
+// a directory holding one newline-delimited JSON file per UTC day and a schema.json with the
+// type of each measurement, so values read back with the Go types the IoTDB client returns.
+// Series names are slash separated, e.g. "device_data/<uuid>".
+type EmbeddedTSDB struct {
+	dir     string
+	mu      sync.Mutex
+	schemas map[string]map[string]client.TSDataType
+}
+
+// NewEmbeddedTSDB opens the store rooted at dir, creating the directory if needed.
+func NewEmbeddedTSDB(dir string) (*EmbeddedTSDB, error) {
+	if dir == "" {
+		return nil, errors.New("embedded time-series directory is not configured")
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create embedded time-series directory: %w", err)
+	}
+	return &EmbeddedTSDB{dir: dir, schemas: make(map[string]map[string]client.TSDataType)}, nil
+}
+
+// Close releases the store. Files are closed after every operation, so there is nothing to flush.
+func (s *EmbeddedTSDB) Close() error {
+	return nil
+}
+
+// DefineSeries records the types of measurements, replacing the types already recorded.
+func (s *EmbeddedTSDB) DefineSeries(series string, dataTypes map[string]client.TSDataType) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	schema, err := s.schema(series)
+	if err != nil {
+		return err
+	}
+	for measurement, dataType := range dataTypes {
+		schema[measurement] = dataType
+	}
+	return s.saveSchema(series, schema)
+}
+
+// Append writes points to a series. Measurements not defined yet are defined with the given
+// types, like IoTDB creates timeseries on first write. Nil, NaN and infinite values are skipped.
+func (s *EmbeddedTSDB) Append(series string, points []EmbeddedPoint, dataTypes map[string]client.TSDataType) error {
+	if len(points) == 0 {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	dir, err := s.seriesDir(series)
+	if err != nil {
+		return err
+	}
+	schema, err := s.schema(series)
+	if err != nil {
+		return err
+	}
+	changed := false
+	for measurement, dataType := range dataTypes {
+		if _, ok := schema[measurement]; !ok {
+			schema[measurement] = dataType
+			changed = true
+		}
+	}
+	if changed {
+		if err := s.saveSchema(series, schema); err != nil {
+			return err
+		}
+	}
+
+	days := make(map[string][]EmbeddedPoint)
+	var order []string
+	for _, point := range points {
+		values := make(map[string]interface{}, len(point.Values))
+		for measurement, value := range point.Values {
+			if storableValue(value) {
+				values[measurement] = value
+			}
+		}
+		if len(values) == 0 {
+			continue
+		}
+		day := embeddedDayFile(point.Timestamp)
+		if _, ok := days[day]; !ok {
+			order = append(order, day)
+		}
+		days[day] = append(days[day], EmbeddedPoint{Timestamp: point.Timestamp, Values: values})
+	}
+	if len(order) == 0 {
+		return nil
+	}
+
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return fmt.Errorf("failed to create series %s: %w", series, err)
+	}
+	for _, day := range order {
+		if err := appendPoints(filepath.Join(dir, day), days[day]); err != nil {
+			return fmt.Errorf("failed to write series %s: %w", series, err)
+		}
+	}
+	return nil
+}
+
+// Range returns the points of a series in [start, end), in ascending time order. Points written
+// at the same timestamp are merged, later writes winning.
+func (s *EmbeddedTSDB) Range(series string, start, end int64) ([]EmbeddedPoint, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	files, schema, err := s.dayFiles(series)
+	if err != nil {
+		return nil, err
+	}
+	var points []EmbeddedPoint
+	for _, file := range files {
+		if file.start+embeddedDayMs <= start || file.start >= end {
+			continue
+		}
+		dayPoints, err := readPoints(file.path, schema)
+		if err != nil {
+			return nil, err
+		}
+		for _, point := range dayPoints {
+			if point.Timestamp >= start && point.Timestamp < end {
+				points = append(points, point)
+			}
+		}
+	}
+	return mergePoints(points), nil
+}
+
+// Latest returns the newest point of a series, or nil when it holds no data.
+func (s *EmbeddedTSDB) Latest(series string) (*EmbeddedPoint, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	files, schema, err := s.dayFiles(series)
+	if err != nil {
+		return nil, err
+	}
+	for i := len(files) - 1; i >= 0; i-- {
+		points, err := readPoints(files[i].path, schema)
+		if err != nil {
+			return nil, err
+		}
+		points = mergePoints(points)
+		if len(points) > 0 {
+			return &points[len(points)-1], nil
+		}
+	}
+	return nil, nil
+}
+
+// DeleteBefore removes values older than before (ms). With nil measurements whole points are
+// removed, otherwise only the listed measurements.
+func (s *EmbeddedTSDB) DeleteBefore(series string, measurements []string, before int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	files, schema, err := s.dayFiles(series)
+	if err != nil {
+		return err
+	}
+	for _, file := range files {
+		if file.start >= before {
+			break
+		}
+		if measurements == nil && file.start+embeddedDayMs <= before {
+			if err := os.Remove(file.path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+				return fmt.Errorf("failed to delete %s: %w", file.path, err)
+			}
+			continue
+		}
+		err := rewritePoints(file.path, schema, func(point *EmbeddedPoint) {
+			if point.Timestamp >= before {
+				return
+			}
+			if measurements == nil {
+				point.Values = nil
+				return
+			}
+			for _, measurement := range measurements {
+				delete(point.Values, measurement)
+			}
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// DropMeasurements removes measurements from a series, data and definition.
+func (s *EmbeddedTSDB) DropMeasurements(series string, measurements []string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	files, schema, err := s.dayFiles(series)
+	if err != nil {
+		return err
+	}
+	for _, file := range files {
+		err := rewritePoints(file.path, schema, func(point *EmbeddedPoint) {
+			for _, measurement := range measurements {
+				delete(point.Values, measurement)
+			}
+		})
+		if err != nil {
+			return err
+		}
+	}
+	for _, measurement := range measurements {
+		delete(schema, measurement)
+	}
+	return s.saveSchema(series, schema)
+}
+
+// RenameMeasurement moves the data of a measurement to a new name with the same type. The target
+// is defined before the data is moved and the source is removed last, so an interrupted rename
+// can be repeated; a measurement that is not defined is left alone.
+func (s *EmbeddedTSDB) RenameMeasurement(series, from, to string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	files, schema, err := s.dayFiles(series)
+	if err != nil {
+		return err
+	}
+	dataType, ok := schema[from]
+	if !ok {
+		return nil
+	}
+	if existing, ok := schema[to]; ok && existing != dataType {
+		return fmt.Errorf("measurement %s of series %s already exists with another type", to, series)
+	}
+	schema[to] = dataType
+	if err := s.saveSchema(series, schema); err != nil {
+		return err
+	}
+	for _, file := range files {
+		err := rewritePoints(file.path, schema, func(point *EmbeddedPoint) {
+			if value, ok := point.Values[from]; ok {
+				point.Values[to] = value
+				delete(point.Values, from)
+			}
+		})
+		if err != nil {
+			return err
+		}
+	}
+	delete(schema, from)
+	return s.saveSchema(series, schema)
+}
+
+func (s *EmbeddedTSDB) seriesDir(series string) (string, error) {
+	for _, part := range strings.Split(series, "/") {
+		if part == "" || part == "." || part == ".." || strings.ContainsAny(part, `\:`) {
+			return "", fmt.Errorf("invalid series name %q", series)
+		}
+	}
+	return filepath.Join(s.dir, filepath.FromSlash(series)), nil
+}
+
+// schema returns the measurement types of a series, loading them on first use. Callers hold mu.
+func (s *EmbeddedTSDB) schema(series string) (map[string]client.TSDataType, error) {
+	if schema, ok := s.schemas[series]; ok {
+		return schema, nil
+	}
+	dir, err := s.seriesDir(series)
+	if err != nil {
+		return nil, err
+	}
+	schema := make(map[string]client.TSDataType)
+	data, err := os.ReadFile(filepath.Join(dir, embeddedSchemaFile))
+	if err == nil {
+		if err := json.Unmarshal(data, &schema); err != nil {
+			return nil, fmt.Errorf("invalid schema of series %s: %w", series, err)
+		}
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("failed to read schema of series %s: %w", series, err)
+	}
+	s.schemas[series] = schema
+	return schema, nil
+}
+
+func (s *EmbeddedTSDB) saveSchema(series string, schema map[string]client.TSDataType) error {
+	dir, err := s.seriesDir(series)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return fmt.Errorf("failed to create series %s: %w", series, err)
+	}
+	data, err := json.Marshal(schema)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(filepath.Join(dir, embeddedSchemaFile), data)
+}
+
+type embeddedDay struct {
+	path  string
+	start int64
+}
+
+// dayFiles lists the data files of a series in ascending day order.
+func (s *EmbeddedTSDB) dayFiles(series string) ([]embeddedDay, map[string]client.TSDataType, error) {
+	dir, err := s.seriesDir(series)
+	if err != nil {
+		return nil, nil, err
+	}
+	schema, err := s.schema(series)
+	if err != nil {
+		return nil, nil, err
+	}
+	entries, err := os.ReadDir(dir)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, schema, nil
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to list series %s: %w", series, err)
+	}
+
+	var files []embeddedDay
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasSuffix(name, embeddedDataSuffix) {
+			continue
+		}
+		day, err := time.Parse(embeddedDayLayout, strings.TrimSuffix(name, embeddedDataSuffix))
+		if err != nil {
+			continue
+		}
+		files = append(files, embeddedDay{path: filepath.Join(dir, name), start: day.UnixMilli()})
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].start < files[j].start })
+	return files, schema, nil
+}
+
+func embeddedDayFile(timestamp int64) string {
+	return time.UnixMilli(timestamp).UTC().Format(embeddedDayLayout) + embeddedDataSuffix
+}
+
+func storableValue(value interface{}) bool {
+	switch v := value.(type) {
+	case nil:
+		return false
+	case float32:
+		return !math.IsNaN(float64(v)) && !math.IsInf(float64(v), 0)
+	case float64:
+		return !math.IsNaN(v) && !math.IsInf(v, 0)
+	}
+	return true
+}
+
+func appendPoints(path string, points []EmbeddedPoint) error {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+	writer := bufio.NewWriter(file)
+	encoder := json.NewEncoder(writer)
+	for _, point := range points {
+		if err := encoder.Encode(point); err != nil {
+			file.Close()
+			return err
+		}
+	}
+	if err := writer.Flush(); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// readPoints decodes a day file, converting values to the types of the schema.
+func readPoints(path string, schema map[string]client.TSDataType) ([]EmbeddedPoint, error) {
+	file, err := os.Open(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var points []EmbeddedPoint
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		if len(line) == 0 {
+			continue
+		}
+		decoder := json.NewDecoder(strings.NewReader(string(line)))
+		decoder.UseNumber()
+		var point EmbeddedPoint
+		if err := decoder.Decode(&point); err != nil {
+			// A torn last line from an interrupted write; the rest of the file is intact.
+			continue
+		}
+		for measurement, value := range point.Values {
+			point.Values[measurement] = embeddedValue(value, schema[measurement])
+		}
+		points = append(points, point)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	return points, nil
+}
+
+// rewritePoints applies edit to every point of a day file and rewrites it, dropping points left
+// without values and the file when none remain.
+func rewritePoints(path string, schema map[string]client.TSDataType, edit func(point *EmbeddedPoint)) error {
+	points, err := readPoints(path, schema)
+	if err != nil {
+		return err
+	}
+	kept := points[:0]
+	for _, point := range points {
+		edit(&point)
+		if len(point.Values) > 0 {
+			kept = append(kept, point)
+		}
+	}
+	if len(kept) == 0 {
+		if err := os.Remove(path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return fmt.Errorf("failed to delete %s: %w", path, err)
+		}
+		return nil
+	}
+
+	var data strings.Builder
+	encoder := json.NewEncoder(&data)
+	for _, point := range kept {
+		if err := encoder.Encode(point); err != nil {
+			return err
+		}
+	}
+	return writeFileAtomic(path, []byte(data.String()))
+}
+
+func writeFileAtomic(path string, data []byte) error {
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// mergePoints sorts points by time and merges those sharing a timestamp.
+func mergePoints(points []EmbeddedPoint) []EmbeddedPoint {
+	sort.SliceStable(points, func(i, j int) bool { return points[i].Timestamp < points[j].Timestamp })
+	merged := points[:0]
+	for _, point := range points {
+		if n := len(merged); n > 0 && merged[n-1].Timestamp == point.Timestamp {
+			for measurement, value := range point.Values {
+				merged[n-1].Values[measurement] = value
+			}
+			continue
+		}
+		merged = append(merged, point)
+	}
+	return merged
+}
+
+// embeddedValue converts a decoded JSON value to the Go type IoTDB returns for dataType.
+// Values that do not fit the type are returned as decoded.
+func embeddedValue(value interface{}, dataType client.TSDataType) interface{} {
+	number, isNumber := value.(json.Number)
+	switch dataType {
+	case client.INT32:
+		if isNumber {
+			if n, err := number.Int64(); err == nil {
+				return int32(n)
+			}
+		}
+	case client.INT64, client.TIMESTAMP:
+		if isNumber {
+			if n, err := number.Int64(); err == nil {
+				return n
+			}
+		}
+	case client.FLOAT:
+		if isNumber {
+			if f, err := number.Float64(); err == nil {
+				return float32(f)
+			}
+		}
+	case client.DOUBLE:
+		if isNumber {
+			if f, err := number.Float64(); err == nil {
+				return f
+			}
+		}
+	}
+	if isNumber {
+		if n, err := number.Int64(); err == nil {
+			return n
+		}
+		f, _ := number.Float64()
+		return f
+	}
+	return value
+}
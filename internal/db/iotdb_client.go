@@ -91,6 +91,18 @@ func (i *IOTDBClient) InsertRecordTyped(deviceId string, measurements []string,
 	return i.CheckError(status, err)
 }
 
+// InsertTablets writes tablets of several devices in one request.
+func (i *IOTDBClient) InsertTablets(tablets []*client.Tablet) error {
+	session, err := i.SessionPool.GetSession()
+	if err != nil {
+		return fmt.Errorf("failed to get session from pool: %w", err)
+	}
+	defer i.SessionPool.PutBack(session)
+
+	status, err := session.InsertTablets(tablets, false)
+	return i.CheckError(status, err)
+}
+
 func (i *IOTDBClient) InitializeSchema() error {
 	session, err := i.SessionPool.GetSession()
 	if err != nil {
@@ -107,6 +119,12 @@ func (i *IOTDBClient) InitializeSchema() error {
 	return nil
 }
 func (i *IOTDBClient) MapConvertToIotDBType(meta model.PropertyMeta) (dataType client.TSDataType, encoding client.TSEncoding, compression client.TSCompressionType) {
+	return MapPropertyType(meta)
+}
+
+// MapPropertyType maps a property format to its time-series type. It does not depend on the
+// backend, the embedded store uses the same types.
+func MapPropertyType(meta model.PropertyMeta) (dataType client.TSDataType, encoding client.TSEncoding, compression client.TSCompressionType) {
 	switch meta.Format {
 	case "int", "integer":
 		dataType = client.INT32
@@ -0,0 +1,63 @@
+package eventbus
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sync"
+)
+
+// Durable transports store events as JSON together with the name their Go type was registered
+// under, so subscribers receive the same concrete type the publisher used.
+var (
+	kindsMu      sync.RWMutex
+	kindNames    = make(map[reflect.Type]string)
+	kindDecoders = make(map[string]func(data []byte) (Event, error))
+)
+
+func init() {
+	RegisterType[BaseEvent]("base")
+}
+
+// RegisterType makes events of type T publishable on a durable EventBus. name is stored with
+// every persisted event and must not change once events of the type have been published.
+func RegisterType[T Event](name string) {
+	kindsMu.Lock()
+	defer kindsMu.Unlock()
+
+	kindNames[reflect.TypeOf((*T)(nil)).Elem()] = name
+	kindDecoders[name] = func(data []byte) (Event, error) {
+		var event T
+		if err := json.Unmarshal(data, &event); err != nil {
+			return nil, err
+		}
+		return event, nil
+	}
+}
+
+// encodeEvent returns the registered name of the event's type and its JSON encoding.
+func encodeEvent(event Event) (string, []byte, error) {
+	kindsMu.RLock()
+	kind, ok := kindNames[reflect.TypeOf(event)]
+	kindsMu.RUnlock()
+	if !ok {
+		return "", nil, fmt.Errorf("event type %s is not registered", reflect.TypeOf(event))
+	}
+
+	data, err := json.Marshal(event)
+	if err != nil {
+		return "", nil, err
+	}
+	return kind, data, nil
+}
+
+// decodeEvent restores an event encoded by encodeEvent.
+func decodeEvent(kind string, data []byte) (Event, error) {
+	kindsMu.RLock()
+	decode, ok := kindDecoders[kind]
+	kindsMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown event kind %q", kind)
+	}
+	return decode(data)
+}
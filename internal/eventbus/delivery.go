@@ -0,0 +1,396 @@
+package eventbus
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// OverflowPolicy decides what happens to an event published while a subscription's queue is full
+type OverflowPolicy string
+
+const (
+	OverflowBlock      OverflowPolicy = "block"       // the publisher waits for room, at most BlockTimeout, then the event is dead-lettered
+	OverflowDropOldest OverflowPolicy = "drop_oldest" // the oldest queued event is dropped
+	OverflowDropNewest OverflowPolicy = "drop_newest" // the published event is dropped
+)
+
+// ErrQueueFull is recorded for events dead-lettered because their queue stayed full for BlockTimeout
+var ErrQueueFull = errors.New("subscription queue stayed full")
+
+// ErrDrainTimeout is recorded for events still queued when Close gave up draining
+var ErrDrainTimeout = errors.New("event bus closed before the event was handled")
+
+// DeliveryOptions bound how the handler of a subscription is run. Zero values use the defaults.
+type DeliveryOptions struct {
+	QueueSize    int            // events buffered per subscription (default 1024)
+	Workers      int            // handlers running concurrently per subscription (default 4)
+	Overflow     OverflowPolicy // default OverflowBlock; dropping is only set per subscription with WithDelivery
+	BlockTimeout time.Duration  // with OverflowBlock, how long a publish waits before the event is dead-lettered (default 5s)
+	MaxAttempts  int            // handler attempts before the event is dead-lettered (default 3)
+	RetryBackoff time.Duration  // before the second attempt, doubled for each further one (default 500ms)
+}
+
+// Options configures an EventBus. Zero values use the defaults.
+type Options struct {
+	Delivery     DeliveryOptions // defaults of every subscription, except Overflow which is always OverflowBlock
+	DrainTimeout time.Duration   // how long Close keeps handling queued events (default 10s)
+}
+
+func (d DeliveryOptions) withDefaults(defaults DeliveryOptions) DeliveryOptions {
+	if d.QueueSize <= 0 {
+		d.QueueSize = defaults.QueueSize
+	}
+	if d.Workers <= 0 {
+		d.Workers = defaults.Workers
+	}
+	if d.Overflow == "" {
+		d.Overflow = defaults.Overflow
+	}
+	if d.MaxAttempts <= 0 {
+		d.MaxAttempts = defaults.MaxAttempts
+	}
+	if d.RetryBackoff <= 0 {
+		d.RetryBackoff = defaults.RetryBackoff
+	}
+	if d.BlockTimeout <= 0 {
+		d.BlockTimeout = defaults.BlockTimeout
+	}
+	return d
+}
+
+var defaultDelivery = DeliveryOptions{
+	QueueSize:    1024,
+	Workers:      4,
+	Overflow:     OverflowBlock,
+	MaxAttempts:  3,
+	RetryBackoff: 500 * time.Millisecond,
+	BlockTimeout: 5 * time.Second,
+}
+
+// WithDelivery overrides the bus's delivery defaults for a subscription. It is the only way to make
+// a subscription drop events when its queue is full. On a durable bus the queues of consumer group
+// subscriptions always block; the stream buffers the backlog.
+func WithDelivery(delivery DeliveryOptions) SubscribeOption {
+	return func(s *Subscription) {
+		s.Delivery = delivery.withDefaults(s.Delivery)
+	}
+}
+
+// DeadLetter is an event whose handler kept failing
+type DeadLetter struct {
+	Group     string
+	EventType EventType
+	Kind      string // registered type name, empty if the event's type is not registered
+	Payload   []byte // JSON encoding of the event
+	Error     string
+	Attempts  int
+	FailedAt  time.Time
+}
+
+// DeadLetterSink stores dead letters so they can be inspected and replayed
+type DeadLetterSink interface {
+	StoreDeadLetter(letter DeadLetter) error
+}
+
+// SubscriptionStats describes the queue and counters of a subscription
+type SubscriptionStats struct {
+	ID           string         `json:"id"`
+	EventType    EventType      `json:"event_type"`
+	Group        string         `json:"group"`
+	Broadcast    bool           `json:"broadcast"`
+	Workers      int            `json:"workers"`
+	QueueSize    int            `json:"queue_size"`
+	Queued       int            `json:"queued"`
+	Overflow     OverflowPolicy `json:"overflow"`
+	Delivered    int64          `json:"delivered"`
+	Failed       int64          `json:"failed"`
+	Dropped      int64          `json:"dropped"`
+	DeadLettered int64          `json:"dead_lettered"`
+}
+
+// delivery is one event queued for a subscription. done is set for entries of a durable consumer
+// group: it acknowledges the entry or leaves it pending for redelivery instead of dead-lettering.
+type delivery struct {
+	ctx   context.Context
+	event Event
+	done  func(err error)
+}
+
+// subscriptionQueue is the bounded queue of a subscription and the counters of its workers.
+type subscriptionQueue struct {
+	ch      chan delivery
+	mu      sync.RWMutex // held for reading while pushing, for writing while closing
+	closed  bool
+	workers sync.WaitGroup
+
+	delivered    atomic.Int64
+	failed       atomic.Int64
+	dropped      atomic.Int64
+	deadLettered atomic.Int64
+}
+
+// start creates the queue of sub and its workers.
+func (eb *EventBus) start(sub *Subscription) {
+	sub.queue = &subscriptionQueue{ch: make(chan delivery, sub.Delivery.QueueSize)}
+	sub.queue.workers.Add(sub.Delivery.Workers)
+	for i := 0; i < sub.Delivery.Workers; i++ {
+		go eb.work(sub)
+	}
+}
+
+// enqueue queues an event for sub according to its overflow policy. block forces waiting for room
+// until ctx ends, without the BlockTimeout of OverflowBlock; it reports whether the event was queued.
+func (eb *EventBus) enqueue(ctx context.Context, sub *Subscription, d delivery, block bool) bool {
+	q := sub.queue
+	q.mu.RLock()
+	defer q.mu.RUnlock()
+	if q.closed {
+		return false
+	}
+
+	eb.pending.Add(1)
+	policy := sub.Delivery.Overflow
+	if block {
+		policy = OverflowBlock
+	}
+	switch policy {
+	case OverflowDropNewest:
+		select {
+		case q.ch <- d:
+			return true
+		default:
+			eb.drop(sub)
+			return false
+		}
+	case OverflowDropOldest:
+		for {
+			select {
+			case q.ch <- d:
+				return true
+			default:
+			}
+			select {
+			case <-q.ch:
+				eb.drop(sub)
+			default:
+			}
+		}
+	default:
+		if block {
+			select {
+			case q.ch <- d:
+				return true
+			case <-ctx.Done():
+				eb.pending.Done()
+				return false
+			}
+		}
+		timer := time.NewTimer(sub.Delivery.BlockTimeout)
+		defer timer.Stop()
+		select {
+		case q.ch <- d:
+			return true
+		case <-timer.C:
+			eb.pending.Done()
+			log.Printf("[EventBus] Queue of %s stayed full for %s, dead-lettering %s", sub.ID, sub.Delivery.BlockTimeout, d.event.GetType())
+			eb.deadLetter(DeadLetter{Group: sub.Group, Error: ErrQueueFull.Error()}, d.event)
+			q.deadLettered.Add(1)
+			return false
+		case <-ctx.Done():
+			eb.pending.Done()
+			return false
+		}
+	}
+}
+
+func (eb *EventBus) drop(sub *Subscription) {
+	eb.pending.Done()
+	if n := sub.queue.dropped.Add(1); n == 1 || n%1000 == 0 {
+		log.Printf("[EventBus] Queue of %s is full, %d events dropped (%s)", sub.ID, n, sub.Delivery.Overflow)
+	}
+}
+
+// closeQueue stops accepting events for sub; its workers exit once the queue is empty.
+func (sub *Subscription) closeQueue() {
+	q := sub.queue
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if !q.closed {
+		q.closed = true
+		close(q.ch)
+	}
+}
+
+func (eb *EventBus) work(sub *Subscription) {
+	defer sub.queue.workers.Done()
+	for d := range sub.queue.ch {
+		eb.process(sub, d)
+		eb.pending.Done()
+	}
+}
+
+// process runs the handler, retrying with exponential backoff. An event that still fails is
+// dead-lettered, or left pending in its stream when it came from a consumer group.
+func (eb *EventBus) process(sub *Subscription, d delivery) {
+	q := sub.queue
+	var err error
+	attempts := 0
+	backoff := sub.Delivery.RetryBackoff
+	for {
+		if eb.aborted() {
+			if err == nil {
+				err = ErrDrainTimeout
+			}
+			break
+		}
+		attempts++
+		if err = callHandler(d.ctx, sub, d.event); err == nil {
+			q.delivered.Add(1)
+			if d.done != nil {
+				d.done(nil)
+			}
+			return
+		}
+		if attempts >= sub.Delivery.MaxAttempts || !eb.sleep(backoff) {
+			break
+		}
+		backoff *= 2
+	}
+
+	q.failed.Add(1)
+	log.Printf("[EventBus] Handler %s failed for %s after %d attempts: %v", sub.ID, d.event.GetType(), attempts, err)
+	if d.done != nil {
+		d.done(err)
+		return
+	}
+	eb.deadLetter(DeadLetter{Group: sub.Group, Error: err.Error(), Attempts: attempts}, d.event)
+	q.deadLettered.Add(1)
+}
+
+// aborted reports whether Close gave up draining the queues.
+func (eb *EventBus) aborted() bool {
+	select {
+	case <-eb.abort:
+		return true
+	default:
+		return false
+	}
+}
+
+// sleep waits between attempts; it returns false if Close gave up draining meanwhile.
+func (eb *EventBus) sleep(d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-eb.abort:
+		return false
+	}
+}
+
+// deadLetter hands a failed event to the dead-letter sink.
+func (eb *EventBus) deadLetter(letter DeadLetter, event Event) {
+	letter.EventType = event.GetType()
+	letter.FailedAt = time.Now()
+	kind, payload, err := encodeEvent(event)
+	if err != nil {
+		payload, _ = json.Marshal(event)
+	}
+	letter.Kind = kind
+	letter.Payload = payload
+	eb.storeDeadLetter(letter)
+}
+
+func (eb *EventBus) storeDeadLetter(letter DeadLetter) {
+	eb.mu.RLock()
+	sink := eb.deadLetters
+	eb.mu.RUnlock()
+
+	if sink == nil {
+		log.Printf("[EventBus] Dead letter for %s (group %s) discarded, no dead-letter store: %s", letter.EventType, letter.Group, letter.Error)
+		return
+	}
+	if err := sink.StoreDeadLetter(letter); err != nil {
+		log.Printf("[EventBus] Failed to store dead letter for %s (group %s): %v", letter.EventType, letter.Group, err)
+	}
+}
+
+// SetDeadLetterSink sets where events that keep failing are stored. Without a sink they are only
+// logged.
+func (eb *EventBus) SetDeadLetterSink(sink DeadLetterSink) {
+	eb.mu.Lock()
+	defer eb.mu.Unlock()
+	eb.deadLetters = sink
+}
+
+// ErrNoSubscription is returned when replaying to a group without a subscription in this process
+var ErrNoSubscription = errors.New("no subscription of the group handles the event")
+
+// Replay decodes a dead letter and runs the handlers of its group in this process synchronously,
+// without retries. It returns the first handler error.
+func (eb *EventBus) Replay(ctx context.Context, group string, kind string, payload []byte) error {
+	event, err := decodeEvent(kind, payload)
+	if err != nil {
+		return err
+	}
+
+	eb.mu.RLock()
+	var subs []*Subscription
+	for _, sub := range eb.subscriptionsFor(event.GetType()) {
+		if sub.Group == group {
+			subs = append(subs, sub)
+		}
+	}
+	eb.mu.RUnlock()
+
+	if len(subs) == 0 {
+		return ErrNoSubscription
+	}
+	for _, sub := range subs {
+		if err := callHandler(ctx, sub, event); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Stats returns the queue state and counters of every subscription.
+func (eb *EventBus) Stats() []SubscriptionStats {
+	eb.mu.RLock()
+	defer eb.mu.RUnlock()
+
+	var stats []SubscriptionStats
+	for _, subs := range eb.handlers {
+		for _, sub := range subs {
+			q := sub.queue
+			stats = append(stats, SubscriptionStats{
+				ID:           sub.ID,
+				EventType:    sub.EventType,
+				Group:        sub.Group,
+				Broadcast:    sub.Broadcast,
+				Workers:      sub.Delivery.Workers,
+				QueueSize:    sub.Delivery.QueueSize,
+				Queued:       len(q.ch),
+				Overflow:     sub.Delivery.Overflow,
+				Delivered:    q.delivered.Load(),
+				Failed:       q.failed.Load(),
+				Dropped:      q.dropped.Load(),
+				DeadLettered: q.deadLettered.Load(),
+			})
+		}
+	}
+	sort.Slice(stats, func(i, j int) bool {
+		if stats[i].EventType != stats[j].EventType {
+			return stats[i].EventType < stats[j].EventType
+		}
+		return stats[i].ID < stats[j].ID
+	})
+	return stats
+}
@@ -6,6 +6,7 @@ import (
 	"log"
 	"reflect"
 	"sync"
+	"time"
 )
 
 // EventType represents the type of an event
@@ -25,107 +26,218 @@ type BaseEvent struct {
 	Source    string    `json:"source"`
 }
 
-func (e BaseEvent) GetType() EventType    { return e.Type }
-func (e BaseEvent) GetTimestamp() int64   { return e.Timestamp }
-func (e BaseEvent) GetSource() string     { return e.Source }
+func (e BaseEvent) GetType() EventType  { return e.Type }
+func (e BaseEvent) GetTimestamp() int64 { return e.Timestamp }
+func (e BaseEvent) GetSource() string   { return e.Source }
 
 // EventHandler is a function that handles events
 type EventHandler func(ctx context.Context, event Event) error
 
-// Subscription represents an event subscription
+// Subscription represents an event subscription. EventType may be a pattern such as "device.*".
 type Subscription struct {
 	ID        string
 	EventType EventType
 	Handler   EventHandler
+	Filter    func(Event) bool // events it rejects are skipped
+	Group     string           // consumer group on a durable bus
+	Broadcast bool             // delivered in every server process rather than once per group
+	Delivery  DeliveryOptions
+
+	queue *subscriptionQueue
+}
+
+// SubscribeOption configures a subscription
+type SubscribeOption func(*Subscription)
+
+// WithGroup sets the consumer group of a subscription. On a durable bus the name identifies the
+// group's position in the streams and must not change once deployed. A subscription without a
+// group is a Broadcast one.
+func WithGroup(name string) SubscribeOption {
+	return func(s *Subscription) { s.Group = name }
+}
+
+// WithFilter only delivers events accepted by filter. Several filters must all accept the event.
+func WithFilter(filter func(Event) bool) SubscribeOption {
+	return func(s *Subscription) {
+		if previous := s.Filter; previous != nil {
+			s.Filter = func(event Event) bool { return previous(event) && filter(event) }
+			return
+		}
+		s.Filter = filter
+	}
+}
+
+// WithSource only delivers events published by one of sources, e.g. a device or user UUID.
+func WithSource(sources ...string) SubscribeOption {
+	return WithFilter(func(event Event) bool {
+		for _, source := range sources {
+			if event.GetSource() == source {
+				return true
+			}
+		}
+		return false
+	})
+}
+
+// Broadcast delivers every event to the subscription in each server process instead of once per
+// consumer group. Use it for handlers acting on process-local state such as WebSocket or MQTT
+// connections. On a durable bus broadcast subscriptions only see events published while they are
+// subscribed, and failed events are not retried.
+func Broadcast() SubscribeOption {
+	return func(s *Subscription) { s.Broadcast = true }
 }
 
-// EventBus is the central event distribution system
+// EventBus is the central event distribution system. Every subscription has a bounded queue
+// served by its own workers, which retry failed handlers and dead-letter events that keep failing.
 type EventBus struct {
-	handlers map[EventType][]*Subscription
-	mu       sync.RWMutex
-	wg       sync.WaitGroup
+	handlers     map[EventType][]*Subscription
+	mu           sync.RWMutex
+	pending      sync.WaitGroup   // queued and running deliveries
+	streams      *streamTransport // nil for the in-memory bus
+	delivery     DeliveryOptions
+	drainTimeout time.Duration
+	deadLetters  DeadLetterSink
+	closed       bool
+	abort        chan struct{} // closed when Close stops draining
 }
 
-// New creates a new EventBus instance
+// New creates a new in-memory EventBus instance with the default options. Events are lost on
+// restart and only reach subscribers of the same process; use NewRedisStreams for a durable bus.
 func New() *EventBus {
+	return NewWithOptions(Options{})
+}
+
+// NewWithOptions creates a new in-memory EventBus instance.
+func NewWithOptions(opts Options) *EventBus {
+	if opts.DrainTimeout <= 0 {
+		opts.DrainTimeout = 10 * time.Second
+	}
+	opts.Delivery.Overflow = OverflowBlock
 	return &EventBus{
-		handlers: make(map[EventType][]*Subscription),
+		handlers:     make(map[EventType][]*Subscription),
+		delivery:     opts.Delivery.withDefaults(defaultDelivery),
+		drainTimeout: opts.DrainTimeout,
+		abort:        make(chan struct{}),
 	}
 }
 
-// Subscribe registers a handler for a specific event type
+// Subscribe registers a handler for a specific event type or pattern (see IsPattern). Pass
+// WithGroup for durable, at-least-once delivery; without it the subscription is Broadcast.
 // Returns a subscription ID that can be used to unsubscribe
-func (eb *EventBus) Subscribe(eventType EventType, handler EventHandler) string {
-	eb.mu.Lock()
-	defer eb.mu.Unlock()
+func (eb *EventBus) Subscribe(eventType EventType, handler EventHandler, opts ...SubscribeOption) string {
+	return eb.subscribe(eventType, handler, opts)
+}
 
+// subscribe registers the subscription in memory under eb.mu, then attaches it to the streams of a
+// durable bus outside the lock, so publishers are not held up by Redis.
+func (eb *EventBus) subscribe(eventType EventType, handler EventHandler, opts []SubscribeOption) string {
 	subID := generateSubscriptionID(eventType)
 	sub := &Subscription{
 		ID:        subID,
 		EventType: eventType,
 		Handler:   handler,
+		Delivery:  eb.delivery,
+	}
+	for _, opt := range opts {
+		opt(sub)
+	}
+	if sub.Group == "" && !sub.Broadcast {
+		sub.Broadcast = true
+		if eb.streams != nil {
+			log.Printf("[EventBus] Subscription %s has no consumer group, events are broadcast and not retried", subID)
+		}
 	}
 
+	eb.mu.Lock()
+	eb.start(sub)
 	eb.handlers[eventType] = append(eb.handlers[eventType], sub)
+	eb.mu.Unlock()
+
+	if eb.streams != nil {
+		if err := eb.streams.subscribe(sub); err != nil {
+			// Published events still reach the subscription through the in-memory fallback
+			log.Printf("[EventBus] Failed to subscribe %s to stream of %s: %v", subID, eventType, err)
+		}
+	}
 	log.Printf("[EventBus] Subscribed %s to event type %s", subID, eventType)
 	return subID
 }
 
-// SubscribeAsync registers an async handler that runs in a goroutine
-func (eb *EventBus) SubscribeAsync(eventType EventType, handler EventHandler) string {
-	wrappedHandler := func(ctx context.Context, event Event) error {
-		eb.wg.Add(1)
-		go func() {
-			defer eb.wg.Done()
-			if err := handler(ctx, event); err != nil {
-				log.Printf("[EventBus] Async handler error for %s: %v", eventType, err)
-			}
-		}()
-		return nil
-	}
-	return eb.Subscribe(eventType, wrappedHandler)
+// SubscribeAll registers a handler for every event published, e.g. for auditing or debugging.
+func (eb *EventBus) SubscribeAll(handler EventHandler, opts ...SubscribeOption) string {
+	return eb.subscribe(AllEvents, handler, opts)
+}
+
+// SubscribeAsync registers a handler like Subscribe.
+//
+// Deprecated: every subscription runs on its own workers; use Subscribe.
+func (eb *EventBus) SubscribeAsync(eventType EventType, handler EventHandler, opts ...SubscribeOption) string {
+	return eb.Subscribe(eventType, handler, opts...)
 }
 
 // Unsubscribe removes a subscription by ID
 func (eb *EventBus) Unsubscribe(subscriptionID string) error {
 	eb.mu.Lock()
-	defer eb.mu.Unlock()
-
+	var found *Subscription
 	for eventType, subs := range eb.handlers {
 		for i, sub := range subs {
 			if sub.ID == subscriptionID {
 				eb.handlers[eventType] = append(subs[:i], subs[i+1:]...)
-				log.Printf("[EventBus] Unsubscribed %s from %s", subscriptionID, eventType)
-				return nil
+				found = sub
+				break
 			}
 		}
+		if found != nil {
+			break
+		}
 	}
-	return fmt.Errorf("subscription %s not found", subscriptionID)
+	eb.mu.Unlock()
+	if found == nil {
+		return fmt.Errorf("subscription %s not found", subscriptionID)
+	}
+
+	if eb.streams != nil {
+		eb.streams.unsubscribe(found)
+	}
+	// Queued events are still handled
+	found.closeQueue()
+	log.Printf("[EventBus] Unsubscribed %s from %s", subscriptionID, found.EventType)
+	return nil
 }
 
-// Publish distributes an event to all registered handlers
+// Publish queues an event for all registered handlers, applying each subscription's overflow
+// policy when its queue is full. A durable bus appends it to the event type's stream; if that
+// fails the event is delivered in memory to this process only.
 func (eb *EventBus) Publish(ctx context.Context, event Event) {
+	if eb.streams != nil {
+		err := eb.streams.publish(ctx, event)
+		if err == nil {
+			return
+		}
+		log.Printf("[EventBus] Failed to persist %s, delivering in memory: %v", event.GetType(), err)
+	}
+
 	eb.mu.RLock()
-	handlers := eb.handlers[event.GetType()]
+	handlers := eb.subscriptionsFor(event.GetType())
+	closed := eb.closed
 	eb.mu.RUnlock()
 
-	if len(handlers) == 0 {
+	if closed {
+		log.Printf("[EventBus] Closed, dropping %s", event.GetType())
 		return
 	}
-
+	// Handlers run after Publish returns, so they must not see the publisher's cancellation
+	ctx = context.WithoutCancel(ctx)
 	for _, sub := range handlers {
-		go func(s *Subscription) {
-			if err := s.Handler(ctx, event); err != nil {
-				log.Printf("[EventBus] Handler error for %s: %v", s.EventType, err)
-			}
-		}(sub)
+		eb.enqueue(ctx, sub, delivery{ctx: ctx, event: event}, false)
 	}
 }
 
-// PublishSync distributes an event synchronously
+// PublishSync distributes an event synchronously to the handlers of this process, also on a
+// durable bus. It bypasses the queues and does not retry.
 func (eb *EventBus) PublishSync(ctx context.Context, event Event) error {
 	eb.mu.RLock()
-	handlers := eb.handlers[event.GetType()]
+	handlers := eb.subscriptionsFor(event.GetType())
 	eb.mu.RUnlock()
 
 	if len(handlers) == 0 {
@@ -134,7 +246,7 @@ func (eb *EventBus) PublishSync(ctx context.Context, event Event) error {
 
 	var errs []error
 	for _, sub := range handlers {
-		if err := sub.Handler(ctx, event); err != nil {
+		if err := callHandler(ctx, sub, event); err != nil {
 			errs = append(errs, fmt.Errorf("handler %s: %w", sub.ID, err))
 		}
 	}
@@ -145,16 +257,79 @@ func (eb *EventBus) PublishSync(ctx context.Context, event Event) error {
 	return nil
 }
 
-// Wait waits for all async handlers to complete
+// Wait waits until every queued event has been handled
 func (eb *EventBus) Wait() {
-	eb.wg.Wait()
+	eb.pending.Wait()
 }
 
-// GetSubscribersCount returns the number of subscribers for an event type
+// Close stops consuming the streams of a durable bus, then drains the queues: queued events are
+// still handled for up to the drain timeout, the rest are dead-lettered (entries of a durable
+// consumer group stay pending and are redelivered instead). Events published afterwards are
+// dropped.
+func (eb *EventBus) Close() {
+	eb.mu.Lock()
+	if eb.closed {
+		eb.mu.Unlock()
+		return
+	}
+	eb.closed = true
+	eb.mu.Unlock()
+
+	if eb.streams != nil {
+		eb.streams.close()
+		log.Println("[EventBus] Stopped consuming streams")
+	}
+
+	eb.mu.RLock()
+	var subs []*Subscription
+	for _, eventSubs := range eb.handlers {
+		subs = append(subs, eventSubs...)
+	}
+	eb.mu.RUnlock()
+
+	timer := time.AfterFunc(eb.drainTimeout, func() { close(eb.abort) })
+	defer timer.Stop()
+	for _, sub := range subs {
+		sub.closeQueue()
+	}
+	for _, sub := range subs {
+		sub.queue.workers.Wait()
+	}
+	log.Println("[EventBus] Queues drained")
+}
+
+// GetSubscribersCount returns the number of subscribers for an event type, including pattern
+// subscriptions matching it
 func (eb *EventBus) GetSubscribersCount(eventType EventType) int {
 	eb.mu.RLock()
 	defer eb.mu.RUnlock()
-	return len(eb.handlers[eventType])
+	return len(eb.subscriptionsFor(eventType))
+}
+
+// subscriptionsFor returns the subscriptions to an event type and the patterns matching it.
+// The caller holds eb.mu.
+func (eb *EventBus) subscriptionsFor(eventType EventType) []*Subscription {
+	subs := append([]*Subscription(nil), eb.handlers[eventType]...)
+	for pattern, patternSubs := range eb.handlers {
+		if IsPattern(pattern) && MatchEventType(pattern, eventType) {
+			subs = append(subs, patternSubs...)
+		}
+	}
+	return subs
+}
+
+// callHandler runs a subscription's handler if its filter accepts the event, turning a panic into
+// an error so a failed event is retried on a durable bus instead of taking the process down.
+func callHandler(ctx context.Context, sub *Subscription, event Event) (err error) {
+	if sub.Filter != nil && !sub.Filter(event) {
+		return nil
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("handler %s panicked: %v", sub.ID, r)
+		}
+	}()
+	return sub.Handler(ctx, event)
 }
 
 // generateSubscriptionID creates a unique subscription ID
@@ -171,15 +346,20 @@ func generateSubscriptionID(eventType EventType) string {
 // TypedEventHandler is a type-safe wrapper for event handlers
 type TypedEventHandler[T Event] func(ctx context.Context, event T) error
 
-// SubscribeTyped registers a typed handler for a specific event type
-func SubscribeTyped[T Event](eb *EventBus, eventType EventType, handler TypedEventHandler[T]) string {
+// SubscribeTyped registers a typed handler for a specific event type. With a pattern, matching
+// events of other types are skipped rather than reported as errors.
+func SubscribeTyped[T Event](eb *EventBus, eventType EventType, handler TypedEventHandler[T], opts ...SubscribeOption) string {
+	pattern := IsPattern(eventType)
 	wrappedHandler := func(ctx context.Context, event Event) error {
 		typedEvent, ok := event.(T)
 		if !ok {
+			if pattern {
+				return nil
+			}
 			return fmt.Errorf("event type mismatch: expected %s, got %s",
 				reflect.TypeOf((*T)(nil)).Elem(), reflect.TypeOf(event))
 		}
 		return handler(ctx, typedEvent)
 	}
-	return eb.Subscribe(eventType, wrappedHandler)
+	return eb.subscribe(eventType, wrappedHandler, opts)
 }
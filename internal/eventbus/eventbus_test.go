@@ -0,0 +1,304 @@
+package eventbus
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+type testEvent struct {
+	BaseEvent
+	Payload map[string]interface{} `json:"payload"`
+}
+
+func init() {
+	RegisterType[testEvent]("eventbus.test")
+}
+
+type testService struct{}
+
+func (testService) handle(ctx context.Context, event testEvent) error { return nil }
+
+func TestEventCodecRoundTrip(t *testing.T) {
+	event := testEvent{
+		BaseEvent: BaseEvent{Type: "device.test", Timestamp: 42, Source: "dev-1"},
+		Payload:   map[string]interface{}{"status": "online"},
+	}
+	kind, data, err := encodeEvent(event)
+	if err != nil {
+		t.Fatalf("encodeEvent: %v", err)
+	}
+	if kind != "eventbus.test" {
+		t.Fatalf("kind = %q", kind)
+	}
+
+	decoded, err := decodeEvent(kind, data)
+	if err != nil {
+		t.Fatalf("decodeEvent: %v", err)
+	}
+	got, ok := decoded.(testEvent)
+	if !ok {
+		t.Fatalf("decoded %T, want testEvent", decoded)
+	}
+	if got.GetType() != "device.test" || got.GetSource() != "dev-1" || got.Payload["status"] != "online" {
+		t.Fatalf("decoded %+v", got)
+	}
+
+	type unregistered struct{ BaseEvent }
+	if _, _, err := encodeEvent(unregistered{}); err == nil {
+		t.Fatal("expected an error for an unregistered event type")
+	}
+	if _, err := decodeEvent("nope", data); err == nil {
+		t.Fatal("expected an error for an unknown kind")
+	}
+}
+
+func TestSubscribeOptions(t *testing.T) {
+	eb := New()
+	SubscribeTyped(eb, "device.test", testService{}.handle, WithGroup("custom"), Broadcast())
+
+	sub := eb.handlers["device.test"][0]
+	if sub.Group != "custom" || !sub.Broadcast {
+		t.Fatalf("subscription = %+v", sub)
+	}
+
+	// Without a group the subscription is a broadcast one
+	eb.Subscribe("device.test", func(ctx context.Context, event Event) error { return nil })
+	if sub := eb.handlers["device.test"][1]; sub.Group != "" || !sub.Broadcast {
+		t.Errorf("default subscription = %+v", sub)
+	}
+}
+
+func TestPublishRecoversPanic(t *testing.T) {
+	eb := New()
+	done := make(chan struct{})
+	eb.Subscribe("device.test", func(ctx context.Context, event Event) error {
+		panic("boom")
+	}, WithGroup("panics"))
+	eb.Subscribe("device.test", func(ctx context.Context, event Event) error {
+		close(done)
+		return nil
+	}, WithGroup("closes"))
+
+	eb.Publish(context.Background(), BaseEvent{Type: "device.test"})
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("second handler not called")
+	}
+
+	err := callHandler(context.Background(), &Subscription{ID: "s", Handler: func(ctx context.Context, event Event) error { panic("boom") }}, BaseEvent{})
+	if err == nil || !strings.Contains(err.Error(), "panicked") {
+		t.Fatalf("callHandler = %v", err)
+	}
+}
+
+func TestMatchEventType(t *testing.T) {
+	cases := []struct {
+		pattern, eventType EventType
+		want               bool
+	}{
+		{"device.*", "device.status.change", true},
+		{"device.*", "device.error", true},
+		{"device.*", "device", false},
+		{"*.error", "device.error", true},
+		{"*.error", "system.error", true},
+		{"*.error", "device.action.error", true},
+		{"*.error", "device.errors", false},
+		{"device.*.folder", "device.added.folder", true},
+		{"*", "user.login", true},
+		{"user.login", "user.login", true},
+		{"user.login", "user.logout", false},
+	}
+	for _, c := range cases {
+		if got := MatchEventType(c.pattern, c.eventType); got != c.want {
+			t.Errorf("MatchEventType(%q, %q) = %v, want %v", c.pattern, c.eventType, got, c.want)
+		}
+	}
+
+	if IsPattern("device.status.change") || !IsPattern("device.*") || !IsPattern(AllEvents) {
+		t.Fatal("IsPattern misclassifies event types")
+	}
+}
+
+func TestPatternAndFilteredSubscriptions(t *testing.T) {
+	eb := New()
+	var mu sync.Mutex
+	got := make(map[string][]EventType)
+	record := func(name string) EventHandler {
+		return func(ctx context.Context, event Event) error {
+			mu.Lock()
+			defer mu.Unlock()
+			got[name] = append(got[name], event.GetType())
+			return nil
+		}
+	}
+	eb.Subscribe("device.*", record("device"), WithGroup("device"))
+	eb.Subscribe("*.error", record("errors"), WithSource("dev-1"), WithGroup("errors"))
+	eb.SubscribeAll(record("all"), WithGroup("all"))
+	SubscribeTyped(eb, AllEvents, func(ctx context.Context, event testEvent) error {
+		return record("typed")(ctx, event)
+	}, WithGroup("typed"))
+
+	if n := eb.GetSubscribersCount("device.error"); n != 4 {
+		t.Fatalf("GetSubscribersCount(device.error) = %d, want 4", n)
+	}
+
+	events := []Event{
+		BaseEvent{Type: "device.status.change", Source: "dev-1"},
+		BaseEvent{Type: "device.error", Source: "dev-2"},
+		testEvent{BaseEvent: BaseEvent{Type: "system.error", Source: "dev-1"}},
+	}
+	for _, event := range events {
+		if err := eb.PublishSync(context.Background(), event); err != nil {
+			t.Fatalf("PublishSync(%s): %v", event.GetType(), err)
+		}
+	}
+
+	want := map[string][]EventType{
+		"device": {"device.status.change", "device.error"},
+		"errors": {"system.error"},
+		"all":    {"device.status.change", "device.error", "system.error"},
+		"typed":  {"system.error"},
+	}
+	for name, types := range want {
+		if fmt.Sprint(got[name]) != fmt.Sprint(types) {
+			t.Errorf("%s received %v, want %v", name, got[name], types)
+		}
+	}
+}
+
+type testSink struct {
+	mu      sync.Mutex
+	letters []DeadLetter
+}
+
+func (s *testSink) StoreDeadLetter(letter DeadLetter) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.letters = append(s.letters, letter)
+	return nil
+}
+
+func TestOverflowPolicies(t *testing.T) {
+	cases := []struct {
+		policy  OverflowPolicy
+		want    string
+		dropped int64
+		letters int
+	}{
+		{OverflowDropNewest, "[e1 e2]", 1, 0},
+		{OverflowDropOldest, "[e1 e3]", 1, 0},
+		{"", "[e1 e2]", 0, 1}, // the default blocks, then e3 is dead-lettered after BlockTimeout
+	}
+	for _, c := range cases {
+		eb := NewWithOptions(Options{Delivery: DeliveryOptions{QueueSize: 1, Workers: 1, Overflow: OverflowDropNewest, BlockTimeout: 20 * time.Millisecond}})
+		sink := &testSink{}
+		eb.SetDeadLetterSink(sink)
+		started := make(chan struct{}, 3)
+		release := make(chan struct{})
+		var mu sync.Mutex
+		var handled []string
+		opts := []SubscribeOption{WithGroup("slow")}
+		if c.policy != "" {
+			opts = append(opts, WithDelivery(DeliveryOptions{Overflow: c.policy}))
+		}
+		eb.Subscribe("device.test", func(ctx context.Context, event Event) error {
+			started <- struct{}{}
+			<-release
+			mu.Lock()
+			defer mu.Unlock()
+			handled = append(handled, event.GetSource())
+			return nil
+		}, opts...)
+
+		// e1 occupies the only worker, e2 fills the queue and e3 overflows it
+		eb.Publish(context.Background(), BaseEvent{Type: "device.test", Source: "e1"})
+		<-started
+		eb.Publish(context.Background(), BaseEvent{Type: "device.test", Source: "e2"})
+		eb.Publish(context.Background(), BaseEvent{Type: "device.test", Source: "e3"})
+		close(release)
+		eb.Wait()
+
+		if fmt.Sprint(handled) != c.want {
+			t.Errorf("%q: handled %v, want %s", c.policy, handled, c.want)
+		}
+		stats := eb.Stats()
+		if len(stats) != 1 || stats[0].Dropped != c.dropped || stats[0].DeadLettered != int64(c.letters) || stats[0].Delivered != 2 {
+			t.Errorf("%q: stats = %+v", c.policy, stats)
+		}
+		if len(sink.letters) != c.letters {
+			t.Fatalf("%q: %d dead letters, want %d", c.policy, len(sink.letters), c.letters)
+		}
+		if c.letters > 0 && (sink.letters[0].Error != ErrQueueFull.Error() || sink.letters[0].Group != "slow") {
+			t.Errorf("%q: dead letter = %+v", c.policy, sink.letters[0])
+		}
+	}
+}
+
+func TestRetryDeadLetterAndReplay(t *testing.T) {
+	eb := NewWithOptions(Options{Delivery: DeliveryOptions{MaxAttempts: 3, RetryBackoff: time.Millisecond}})
+	sink := &testSink{}
+	eb.SetDeadLetterSink(sink)
+
+	var calls int
+	SubscribeTyped(eb, "device.test", func(ctx context.Context, event testEvent) error {
+		calls++
+		if calls <= 3 {
+			return errors.New("unavailable")
+		}
+		return nil
+	}, WithGroup("flaky"))
+
+	eb.Publish(context.Background(), testEvent{BaseEvent: BaseEvent{Type: "device.test", Source: "dev-1"}})
+	eb.Wait()
+
+	if calls != 3 || len(sink.letters) != 1 {
+		t.Fatalf("calls = %d, dead letters = %d", calls, len(sink.letters))
+	}
+	letter := sink.letters[0]
+	if letter.Group != "flaky" || letter.EventType != "device.test" || letter.Kind != "eventbus.test" || letter.Attempts != 3 || letter.Error != "unavailable" {
+		t.Fatalf("dead letter = %+v", letter)
+	}
+	if stats := eb.Stats(); stats[0].Failed != 1 || stats[0].DeadLettered != 1 {
+		t.Fatalf("stats = %+v", stats[0])
+	}
+
+	if err := eb.Replay(context.Background(), letter.Group, letter.Kind, letter.Payload); err != nil {
+		t.Fatalf("Replay: %v", err)
+	}
+	if calls != 4 {
+		t.Fatalf("calls after replay = %d", calls)
+	}
+	if err := eb.Replay(context.Background(), "other", letter.Kind, letter.Payload); !errors.Is(err, ErrNoSubscription) {
+		t.Fatalf("Replay to an unknown group = %v", err)
+	}
+}
+
+func TestCloseDrainsQueues(t *testing.T) {
+	eb := NewWithOptions(Options{Delivery: DeliveryOptions{Workers: 1}})
+	var handled int
+	eb.Subscribe("device.test", func(ctx context.Context, event Event) error {
+		time.Sleep(time.Millisecond)
+		handled++
+		return nil
+	}, WithGroup("counter"))
+
+	for i := 0; i < 20; i++ {
+		eb.Publish(context.Background(), BaseEvent{Type: "device.test"})
+	}
+	eb.Close()
+	if handled != 20 {
+		t.Fatalf("handled %d events before Close returned, want 20", handled)
+	}
+
+	eb.Publish(context.Background(), BaseEvent{Type: "device.test"})
+	eb.Close()
+	if handled != 20 {
+		t.Fatalf("event published after Close was handled")
+	}
+}
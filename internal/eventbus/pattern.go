@@ -0,0 +1,39 @@
+package eventbus
+
+import "strings"
+
+// AllEvents is the pattern matching every event type
+const AllEvents EventType = "*"
+
+// IsPattern reports whether an event type used for subscribing is a pattern. A "*" segment
+// matches one or more segments of the dot-separated event type: "device.*" matches
+// "device.status.change" and "*.error" matches "device.error" and "system.error".
+func IsPattern(eventType EventType) bool {
+	for _, segment := range strings.Split(string(eventType), ".") {
+		if segment == "*" {
+			return true
+		}
+	}
+	return false
+}
+
+// MatchEventType reports whether eventType matches pattern. A pattern without "*" segments
+// matches only the identical event type.
+func MatchEventType(pattern, eventType EventType) bool {
+	return matchSegments(strings.Split(string(pattern), "."), strings.Split(string(eventType), "."))
+}
+
+func matchSegments(pattern, segments []string) bool {
+	if len(pattern) == 0 {
+		return len(segments) == 0
+	}
+	if pattern[0] == "*" {
+		for i := 1; i <= len(segments); i++ {
+			if matchSegments(pattern[1:], segments[i:]) {
+				return true
+			}
+		}
+		return false
+	}
+	return len(segments) > 0 && pattern[0] == segments[0] && matchSegments(pattern[1:], segments[1:])
+}
@@ -0,0 +1,629 @@
+package eventbus
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// RedisStreamsOptions configures the Redis Streams backend. Zero values use the defaults.
+type RedisStreamsOptions struct {
+	Options                     // delivery defaults and drain timeout of the bus
+	Prefix        string        // stream key prefix, one stream per event type (default "eventbus:")
+	MaxLen        int64         // approximate length cap of each stream (default 100000)
+	ClaimIdle     time.Duration // unacknowledged entries idle this long are redelivered (default 1m)
+	MaxDeliveries int64         // entries delivered this often without an ack are dead-lettered (default 10)
+	Consumer      string        // consumer name of this process (default host-pid)
+}
+
+const (
+	streamReadCount    = 100
+	streamBlock        = 2 * time.Second
+	streamRetryBackoff = time.Second
+	// streamMaxLastErrors bounds the handler errors remembered for dead letters
+	streamMaxLastErrors = 10000
+	// streamDiscoverInterval is how often pattern subscriptions look for streams of new event types
+	streamDiscoverInterval = 10 * time.Second
+)
+
+// NewRedisStreams creates an EventBus that persists events to Redis Streams.
+//
+// Every subscription that is not Broadcast belongs to the consumer group given by WithGroup, so the
+// server processes sharing a Redis share the work and each event is handled once per group.
+// Entries are acknowledged when all handlers of the group returned without error; failed entries
+// and entries left by a stopped process are claimed again after ClaimIdle, so delivery is
+// at-least-once and handlers should be idempotent. Broadcast subscriptions read the streams
+// without a group and are not retried. The published event types are kept in a set, so pattern
+// subscriptions also pick up the streams of event types first published after they subscribed.
+func NewRedisStreams(client *redis.Client, opts RedisStreamsOptions) *EventBus {
+	if opts.Prefix == "" {
+		opts.Prefix = "eventbus:"
+	}
+	if opts.MaxLen <= 0 {
+		opts.MaxLen = 100000
+	}
+	if opts.ClaimIdle <= 0 {
+		opts.ClaimIdle = time.Minute
+	}
+	if opts.MaxDeliveries <= 0 {
+		opts.MaxDeliveries = 10
+	}
+	if opts.Consumer == "" {
+		host, _ := os.Hostname()
+		opts.Consumer = fmt.Sprintf("%s-%d", host, os.Getpid())
+	}
+
+	eb := NewWithOptions(opts.Options)
+	ctx, cancel := context.WithCancel(context.Background())
+	eb.streams = &streamTransport{
+		bus:    eb,
+		client: client,
+		opts:   opts,
+		groups: make(map[string]*streamConsumer),
+		ctx:    ctx,
+		cancel: cancel,
+	}
+	log.Printf("[EventBus] Using Redis Streams (prefix: %s, consumer: %s)", opts.Prefix, opts.Consumer)
+	return eb
+}
+
+// streamTransport publishes events with XADD and runs one reader per consumer group, plus one
+// group-less reader for the broadcast subscriptions of this process.
+type streamTransport struct {
+	bus    *EventBus
+	client *redis.Client
+	opts   RedisStreamsOptions
+	known  sync.Map // event types this process has added to the type set
+
+	mu        sync.Mutex
+	groups    map[string]*streamConsumer
+	broadcast *streamConsumer
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// streamConsumer reads the streams of its subscriptions. group is empty for the broadcast reader.
+type streamConsumer struct {
+	t     *streamTransport
+	group string
+
+	mu           sync.Mutex
+	subs         map[string][]*Subscription // by stream key
+	patterns     []*Subscription
+	lastIDs      map[string]string // broadcast reader: last entry read per stream
+	inflight     map[string]bool   // group entries queued or being handled here
+	lastErrors   map[string]string // group entries whose handling failed here
+	lastClaim    time.Time
+	lastDiscover time.Time
+}
+
+func (t *streamTransport) streamKey(eventType EventType) string {
+	return t.opts.Prefix + string(eventType)
+}
+
+func (t *streamTransport) typesKey() string {
+	return t.opts.Prefix + "types"
+}
+
+// eventTypes returns the event types ever published on the bus.
+func (t *streamTransport) eventTypes() ([]EventType, error) {
+	members, err := t.client.SMembers(t.ctx, t.typesKey()).Result()
+	if err != nil {
+		return nil, err
+	}
+	eventTypes := make([]EventType, 0, len(members))
+	for _, member := range members {
+		eventTypes = append(eventTypes, EventType(member))
+	}
+	return eventTypes, nil
+}
+
+func (t *streamTransport) publish(ctx context.Context, event Event) error {
+	kind, data, err := encodeEvent(event)
+	if err != nil {
+		return err
+	}
+	// The type is recorded before the first entry is added, so a pattern subscription that
+	// discovers the stream later reads it from the start without missing that entry
+	if _, ok := t.known.Load(event.GetType()); !ok {
+		if err := t.client.SAdd(ctx, t.typesKey(), string(event.GetType())).Err(); err != nil {
+			return err
+		}
+		t.known.Store(event.GetType(), struct{}{})
+	}
+	return t.client.XAdd(ctx, &redis.XAddArgs{
+		Stream: t.streamKey(event.GetType()),
+		MaxLen: t.opts.MaxLen,
+		Approx: true,
+		Values: map[string]interface{}{"kind": kind, "data": data},
+	}).Err()
+}
+
+func (t *streamTransport) subscribe(sub *Subscription) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	var consumer *streamConsumer
+	if sub.Broadcast {
+		if t.broadcast == nil {
+			t.broadcast = t.startConsumer("")
+		}
+		consumer = t.broadcast
+	} else {
+		consumer = t.groups[sub.Group]
+		if consumer == nil {
+			consumer = t.startConsumer(sub.Group)
+			t.groups[sub.Group] = consumer
+		}
+	}
+
+	if !IsPattern(sub.EventType) {
+		return consumer.attach(t.streamKey(sub.EventType), sub, false)
+	}
+	eventTypes, err := t.eventTypes()
+	if err != nil {
+		return err
+	}
+	consumer.addPattern(sub)
+	for _, eventType := range eventTypes {
+		if MatchEventType(sub.EventType, eventType) {
+			if err := consumer.attach(t.streamKey(eventType), sub, false); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// createGroup creates the consumer group of a stream starting after entry start ("$" for the end
+// of the stream). An existing group resumes where it left off.
+func (t *streamTransport) createGroup(stream, group, start string) error {
+	err := t.client.XGroupCreateMkStream(t.ctx, stream, group, start).Err()
+	if err != nil && !strings.HasPrefix(err.Error(), "BUSYGROUP") {
+		return err
+	}
+	return nil
+}
+
+// unsubscribe stops delivering to sub in this process. The consumer group stays in Redis, so a
+// later subscription with the same group resumes from its position.
+func (t *streamTransport) unsubscribe(sub *Subscription) {
+	t.mu.Lock()
+	consumer := t.groups[sub.Group]
+	if sub.Broadcast {
+		consumer = t.broadcast
+	}
+	t.mu.Unlock()
+
+	if consumer != nil {
+		consumer.remove(sub)
+	}
+}
+
+func (t *streamTransport) startConsumer(group string) *streamConsumer {
+	consumer := &streamConsumer{
+		t:            t,
+		group:        group,
+		subs:         make(map[string][]*Subscription),
+		lastIDs:      make(map[string]string),
+		inflight:     make(map[string]bool),
+		lastErrors:   make(map[string]string),
+		lastClaim:    time.Now(),
+		lastDiscover: time.Now(),
+	}
+	t.wg.Add(1)
+	go consumer.run()
+	return consumer
+}
+
+// close stops the readers and waits for the handlers they are running. Entries read but not yet
+// acknowledged are redelivered after ClaimIdle.
+func (t *streamTransport) close() {
+	t.cancel()
+	t.wg.Wait()
+}
+
+// attach adds sub to a stream. fromStart reads a stream discovered after the subscription from its
+// first entry; otherwise a new group or the broadcast reader starts after the stream's last entry.
+func (c *streamConsumer) attach(stream string, sub *Subscription, fromStart bool) error {
+	if c.group != "" {
+		start := "$"
+		if fromStart {
+			start = "0"
+		}
+		if err := c.t.createGroup(stream, c.group, start); err != nil {
+			return err
+		}
+		c.add(stream, sub, "")
+		return nil
+	}
+
+	lastID := "0-0"
+	if !fromStart {
+		entries, err := c.t.client.XRevRangeN(c.t.ctx, stream, "+", "-", 1).Result()
+		if err != nil {
+			return err
+		}
+		if len(entries) > 0 {
+			lastID = entries[0].ID
+		}
+	}
+	c.add(stream, sub, lastID)
+	return nil
+}
+
+func (c *streamConsumer) add(stream string, sub *Subscription, lastID string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.subs[stream] = append(c.subs[stream], sub)
+	if _, ok := c.lastIDs[stream]; !ok && c.group == "" {
+		c.lastIDs[stream] = lastID
+	}
+}
+
+func (c *streamConsumer) addPattern(sub *Subscription) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.patterns = append(c.patterns, sub)
+}
+
+func (c *streamConsumer) remove(sub *Subscription) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for stream, subs := range c.subs {
+		c.subs[stream] = removeSubscription(subs, sub)
+		if len(c.subs[stream]) == 0 {
+			delete(c.subs, stream)
+			delete(c.lastIDs, stream)
+		}
+	}
+	c.patterns = removeSubscription(c.patterns, sub)
+}
+
+func removeSubscription(subs []*Subscription, sub *Subscription) []*Subscription {
+	for i, s := range subs {
+		if s.ID == sub.ID {
+			return append(subs[:i:i], subs[i+1:]...)
+		}
+	}
+	return subs
+}
+
+// discover attaches the pattern subscriptions to the streams of event types published since they
+// subscribed.
+func (c *streamConsumer) discover() {
+	c.mu.Lock()
+	patterns := append([]*Subscription(nil), c.patterns...)
+	c.mu.Unlock()
+	if len(patterns) == 0 {
+		return
+	}
+
+	eventTypes, err := c.t.eventTypes()
+	if err != nil {
+		if c.t.ctx.Err() == nil {
+			log.Printf("[EventBus] Failed to list event types: %v", err)
+		}
+		return
+	}
+	for _, sub := range patterns {
+		for _, eventType := range eventTypes {
+			stream := c.t.streamKey(eventType)
+			if !MatchEventType(sub.EventType, eventType) || c.attached(stream, sub) {
+				continue
+			}
+			if err := c.attach(stream, sub, true); err != nil {
+				log.Printf("[EventBus] Failed to attach %s to %s: %v", sub.ID, stream, err)
+				continue
+			}
+			log.Printf("[EventBus] Pattern %s now reads %s", sub.EventType, stream)
+		}
+	}
+}
+
+func (c *streamConsumer) attached(stream string, sub *Subscription) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, s := range c.subs[stream] {
+		if s.ID == sub.ID {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *streamConsumer) subscriptions(stream string) []*Subscription {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]*Subscription(nil), c.subs[stream]...)
+}
+
+// readArgs returns the XREAD / XREADGROUP stream arguments: the keys followed by their IDs.
+func (c *streamConsumer) readArgs() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	keys := make([]string, 0, len(c.subs))
+	ids := make([]string, 0, len(c.subs))
+	for stream := range c.subs {
+		keys = append(keys, stream)
+		if c.group == "" {
+			ids = append(ids, c.lastIDs[stream])
+		} else {
+			ids = append(ids, ">")
+		}
+	}
+	return append(keys, ids...)
+}
+
+func (c *streamConsumer) run() {
+	defer c.t.wg.Done()
+	ctx := c.t.ctx
+
+	for ctx.Err() == nil {
+		if time.Since(c.lastDiscover) >= streamDiscoverInterval {
+			c.discover()
+			c.lastDiscover = time.Now()
+		}
+
+		args := c.readArgs()
+		if len(args) == 0 {
+			c.sleep(streamBlock)
+			continue
+		}
+
+		var streams []redis.XStream
+		var err error
+		if c.group == "" {
+			streams, err = c.t.client.XRead(ctx, &redis.XReadArgs{Streams: args, Count: streamReadCount, Block: streamBlock}).Result()
+		} else {
+			streams, err = c.t.client.XReadGroup(ctx, &redis.XReadGroupArgs{
+				Group:    c.group,
+				Consumer: c.t.opts.Consumer,
+				Streams:  args,
+				Count:    streamReadCount,
+				Block:    streamBlock,
+			}).Result()
+		}
+		if err != nil && !errors.Is(err, redis.Nil) {
+			if ctx.Err() != nil {
+				return
+			}
+			if strings.HasPrefix(err.Error(), "NOGROUP") {
+				// The stream or group was deleted, e.g. by FLUSHDB
+				c.recreateGroups()
+			} else {
+				log.Printf("[EventBus] Failed to read streams (group %q): %v", c.group, err)
+			}
+			c.sleep(streamRetryBackoff)
+			continue
+		}
+
+		for _, stream := range streams {
+			for _, msg := range stream.Messages {
+				c.handle(stream.Stream, msg)
+			}
+		}
+
+		if c.group != "" && time.Since(c.lastClaim) >= c.t.opts.ClaimIdle/2 {
+			c.reclaim()
+			c.lastClaim = time.Now()
+		}
+	}
+}
+
+func (c *streamConsumer) sleep(d time.Duration) {
+	select {
+	case <-c.t.ctx.Done():
+	case <-time.After(d):
+	}
+}
+
+func (c *streamConsumer) recreateGroups() {
+	c.mu.Lock()
+	streams := make([]string, 0, len(c.subs))
+	for stream := range c.subs {
+		streams = append(streams, stream)
+	}
+	c.mu.Unlock()
+
+	for _, stream := range streams {
+		if err := c.t.createGroup(stream, c.group, "$"); err != nil {
+			log.Printf("[EventBus] Failed to recreate group %s on %s: %v", c.group, stream, err)
+		}
+	}
+}
+
+// handle queues one entry for the subscriptions of its stream. A group entry is acknowledged once
+// all of them handled it; if one failed it stays pending and is redelivered by reclaim. Entries
+// that cannot be decoded are acknowledged and dropped.
+func (c *streamConsumer) handle(stream string, msg redis.XMessage) {
+	if c.group == "" {
+		c.mu.Lock()
+		if _, ok := c.lastIDs[stream]; ok {
+			c.lastIDs[stream] = msg.ID
+		}
+		c.mu.Unlock()
+	}
+
+	kind, _ := msg.Values["kind"].(string)
+	data, _ := msg.Values["data"].(string)
+	event, err := decodeEvent(kind, []byte(data))
+	if err != nil {
+		log.Printf("[EventBus] Dropping undecodable entry %s on %s: %v", msg.ID, stream, err)
+		c.ack(stream, msg.ID)
+		return
+	}
+
+	subs := c.subscriptions(stream)
+	if c.group == "" {
+		for _, sub := range subs {
+			c.t.bus.enqueue(c.t.ctx, sub, delivery{ctx: context.Background(), event: event}, false)
+		}
+		return
+	}
+	if len(subs) == 0 {
+		c.ack(stream, msg.ID)
+		return
+	}
+
+	c.mu.Lock()
+	if c.inflight[msg.ID] {
+		c.mu.Unlock()
+		return
+	}
+	c.inflight[msg.ID] = true
+	c.mu.Unlock()
+
+	var mu sync.Mutex
+	remaining := len(subs)
+	var failure error
+	done := func(err error) {
+		mu.Lock()
+		defer mu.Unlock()
+		if err != nil {
+			failure = err
+		}
+		if remaining--; remaining > 0 {
+			return
+		}
+		c.mu.Lock()
+		delete(c.inflight, msg.ID)
+		if len(c.lastErrors) >= streamMaxLastErrors {
+			// Entries handled successfully by another process are never cleared here
+			c.lastErrors = make(map[string]string)
+		}
+		if failure != nil {
+			c.lastErrors[msg.ID] = failure.Error()
+		} else {
+			delete(c.lastErrors, msg.ID)
+		}
+		c.mu.Unlock()
+		if failure == nil {
+			c.ack(stream, msg.ID)
+		}
+	}
+	for _, sub := range subs {
+		if !c.t.bus.enqueue(c.t.ctx, sub, delivery{ctx: context.Background(), event: event, done: done}, true) {
+			// Closing: the entry stays pending
+			done(ErrDrainTimeout)
+		}
+	}
+}
+
+func (c *streamConsumer) ack(stream string, ids ...string) {
+	if c.group == "" {
+		return
+	}
+	if err := c.t.client.XAck(context.Background(), stream, c.group, ids...).Err(); err != nil {
+		log.Printf("[EventBus] Failed to ack %v on %s: %v", ids, stream, err)
+	}
+}
+
+// deadLetter stores an entry that was delivered MaxDeliveries times without being acknowledged.
+func (c *streamConsumer) deadLetter(stream string, entry redis.XPendingExt) {
+	c.mu.Lock()
+	lastError := c.lastErrors[entry.ID]
+	delete(c.lastErrors, entry.ID)
+	c.mu.Unlock()
+	if lastError == "" {
+		lastError = fmt.Sprintf("not acknowledged after %d deliveries", entry.RetryCount)
+	}
+	log.Printf("[EventBus] Dead-lettering entry %s on %s for group %s after %d deliveries", entry.ID, stream, c.group, entry.RetryCount)
+
+	letter := DeadLetter{
+		Group:     c.group,
+		EventType: EventType(strings.TrimPrefix(stream, c.t.opts.Prefix)),
+		Error:     lastError,
+		Attempts:  int(entry.RetryCount),
+		FailedAt:  time.Now(),
+	}
+	msgs, err := c.t.client.XRangeN(c.t.ctx, stream, entry.ID, entry.ID, 1).Result()
+	if err == nil && len(msgs) > 0 {
+		letter.Kind, _ = msgs[0].Values["kind"].(string)
+		data, _ := msgs[0].Values["data"].(string)
+		letter.Payload = []byte(data)
+	}
+	c.t.bus.storeDeadLetter(letter)
+	for _, sub := range c.subscriptions(stream) {
+		sub.queue.deadLettered.Add(1)
+	}
+}
+
+// reclaim claims the group's entries that were not acknowledged within ClaimIdle, whether they
+// failed here or were read by a process that stopped, and handles them again. Entries delivered
+// MaxDeliveries times are dead-lettered.
+func (c *streamConsumer) reclaim() {
+	ctx := c.t.ctx
+	c.mu.Lock()
+	streams := make([]string, 0, len(c.subs))
+	for stream := range c.subs {
+		streams = append(streams, stream)
+	}
+	c.mu.Unlock()
+
+	for _, stream := range streams {
+		pending, err := c.t.client.XPendingExt(ctx, &redis.XPendingExtArgs{
+			Stream: stream,
+			Group:  c.group,
+			Idle:   c.t.opts.ClaimIdle,
+			Start:  "-",
+			End:    "+",
+			Count:  streamReadCount,
+		}).Result()
+		if err != nil {
+			if ctx.Err() == nil {
+				log.Printf("[EventBus] Failed to list pending entries of %s on %s: %v", c.group, stream, err)
+			}
+			continue
+		}
+
+		var ids []string
+		for _, entry := range pending {
+			c.mu.Lock()
+			inflight := c.inflight[entry.ID]
+			c.mu.Unlock()
+			if inflight {
+				continue // still queued here
+			}
+			if entry.RetryCount >= c.t.opts.MaxDeliveries {
+				c.deadLetter(stream, entry)
+				c.ack(stream, entry.ID)
+				continue
+			}
+			ids = append(ids, entry.ID)
+		}
+		if len(ids) == 0 {
+			continue
+		}
+
+		msgs, err := c.t.client.XClaim(ctx, &redis.XClaimArgs{
+			Stream:   stream,
+			Group:    c.group,
+			Consumer: c.t.opts.Consumer,
+			MinIdle:  c.t.opts.ClaimIdle,
+			Messages: ids,
+		}).Result()
+		if err != nil {
+			if ctx.Err() == nil {
+				log.Printf("[EventBus] Failed to claim pending entries of %s on %s: %v", c.group, stream, err)
+			}
+			continue
+		}
+		if len(msgs) > 0 {
+			log.Printf("[EventBus] Reclaimed %d entries on %s for group %s", len(msgs), stream, c.group)
+		}
+		for _, msg := range msgs {
+			c.handle(stream, msg)
+		}
+	}
+}
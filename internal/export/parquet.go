@@ -0,0 +1,204 @@
+package export
+
+import (
+	"context"
+	"encoding/binary"
+	"io"
+
+	"github.com/apache/thrift/lib/go/thrift"
+	"github.com/xitongsys/parquet-go/encoding"
+	"github.com/xitongsys/parquet-go/parquet"
+)
+
+// The Parquet writer produces a flat schema of OPTIONAL columns, PLAIN encoded and
+// uncompressed, with one data page per column chunk. Rows are buffered and written as a row
+// group every parquetRowGroupSize rows; the footer is written on Close. Page headers and the
+// footer are the thrift structs of parquet-go, values and definition levels use its encoders.
+
+const parquetRowGroupSize = 10000
+
+var parquetMagic = []byte("PAR1")
+
+type parquetWriter struct {
+	w         io.Writer
+	columns   []Column
+	offset    int64
+	buffered  [][]interface{} // per column, coerced values or nil
+	rowGroups []*parquet.RowGroup
+	rows      int64
+	err       error
+}
+
+func newParquetWriter(w io.Writer, columns []Column) *parquetWriter {
+	return &parquetWriter{w: w, columns: columns, buffered: make([][]interface{}, len(columns))}
+}
+
+func (pw *parquetWriter) Write(values []interface{}) error {
+	if pw.err != nil {
+		return pw.err
+	}
+	if pw.offset == 0 {
+		pw.write(parquetMagic)
+	}
+	for i, column := range pw.columns {
+		v, ok := coerce(column.Type, values[i])
+		if !ok {
+			v = nil
+		}
+		pw.buffered[i] = append(pw.buffered[i], v)
+	}
+	if len(pw.buffered[0]) >= parquetRowGroupSize {
+		pw.flushRowGroup()
+	}
+	return pw.err
+}
+
+func (pw *parquetWriter) Close() error {
+	if pw.offset == 0 {
+		pw.write(parquetMagic)
+	}
+	if len(pw.columns) > 0 && len(pw.buffered[0]) > 0 {
+		pw.flushRowGroup()
+	}
+	if pw.err != nil {
+		return pw.err
+	}
+
+	footer, err := encodeThrift(pw.fileMetaData())
+	if err != nil {
+		return err
+	}
+	pw.write(footer)
+	length := make([]byte, 4)
+	binary.LittleEndian.PutUint32(length, uint32(len(footer)))
+	pw.write(length)
+	pw.write(parquetMagic)
+	return pw.err
+}
+
+func (pw *parquetWriter) write(data []byte) {
+	if pw.err != nil {
+		return
+	}
+	n, err := pw.w.Write(data)
+	pw.offset += int64(n)
+	pw.err = err
+}
+
+func (pw *parquetWriter) flushRowGroup() {
+	rows := len(pw.buffered[0])
+	group := &parquet.RowGroup{NumRows: int64(rows)}
+	for i, column := range pw.columns {
+		physical, _ := parquetColumnType(column.Type)
+		page := encodeParquetPage(physical, pw.buffered[i])
+		header, err := encodeThrift(&parquet.PageHeader{
+			Type:                 parquet.PageType_DATA_PAGE,
+			UncompressedPageSize: int32(len(page)),
+			CompressedPageSize:   int32(len(page)),
+			DataPageHeader: &parquet.DataPageHeader{
+				NumValues:               int32(rows),
+				Encoding:                parquet.Encoding_PLAIN,
+				DefinitionLevelEncoding: parquet.Encoding_RLE,
+				RepetitionLevelEncoding: parquet.Encoding_RLE,
+			},
+		})
+		if err != nil {
+			pw.err = err
+			return
+		}
+
+		offset := pw.offset
+		size := int64(len(header) + len(page))
+		pw.write(header)
+		pw.write(page)
+		group.Columns = append(group.Columns, &parquet.ColumnChunk{
+			FileOffset: offset,
+			MetaData: &parquet.ColumnMetaData{
+				Type:                  physical,
+				Encodings:             []parquet.Encoding{parquet.Encoding_PLAIN, parquet.Encoding_RLE},
+				PathInSchema:          []string{column.Name},
+				Codec:                 parquet.CompressionCodec_UNCOMPRESSED,
+				NumValues:             int64(rows),
+				TotalUncompressedSize: size,
+				TotalCompressedSize:   size,
+				DataPageOffset:        offset,
+			},
+		})
+		group.TotalByteSize += size
+		pw.buffered[i] = pw.buffered[i][:0]
+	}
+	pw.rowGroups = append(pw.rowGroups, group)
+	pw.rows += int64(rows)
+}
+
+// encodeParquetPage encodes the definition levels and the PLAIN values of a data page.
+func encodeParquetPage(physical parquet.Type, values []interface{}) []byte {
+	levels := make([]interface{}, len(values))
+	defined := make([]interface{}, 0, len(values))
+	for i, v := range values {
+		if v == nil {
+			levels[i] = int64(0)
+			continue
+		}
+		levels[i] = int64(1)
+		defined = append(defined, v)
+	}
+	page := encoding.WriteRLEBitPackedHybrid(levels, 1, parquet.Type_INT64)
+	return append(page, encoding.WritePlain(defined, physical)...)
+}
+
+func (pw *parquetWriter) fileMetaData() *parquet.FileMetaData {
+	schema := []*parquet.SchemaElement{{Name: "schema", NumChildren: thrift.Int32Ptr(int32(len(pw.columns)))}}
+	for _, column := range pw.columns {
+		physical, converted := parquetColumnType(column.Type)
+		element := &parquet.SchemaElement{
+			Type:           parquet.TypePtr(physical),
+			RepetitionType: parquet.FieldRepetitionTypePtr(parquet.FieldRepetitionType_OPTIONAL),
+			Name:           column.Name,
+		}
+		if converted >= 0 {
+			element.ConvertedType = parquet.ConvertedTypePtr(converted)
+		}
+		schema = append(schema, element)
+	}
+	return &parquet.FileMetaData{
+		Version:   1,
+		Schema:    schema,
+		NumRows:   pw.rows,
+		RowGroups: pw.rowGroups,
+		CreatedBy: thrift.StringPtr("OMEGA3-IOT"),
+	}
+}
+
+// parquetColumnType returns the physical type and the converted type (-1 for none).
+func parquetColumnType(columnType ColumnType) (parquet.Type, parquet.ConvertedType) {
+	switch columnType {
+	case ColumnBool:
+		return parquet.Type_BOOLEAN, -1
+	case ColumnInt32:
+		return parquet.Type_INT32, -1
+	case ColumnInt64:
+		return parquet.Type_INT64, -1
+	case ColumnTimestamp:
+		return parquet.Type_INT64, parquet.ConvertedType_TIMESTAMP_MILLIS
+	case ColumnFloat:
+		return parquet.Type_FLOAT, -1
+	case ColumnDouble:
+		return parquet.Type_DOUBLE, -1
+	default:
+		return parquet.Type_BYTE_ARRAY, parquet.ConvertedType_UTF8
+	}
+}
+
+// encodeThrift serializes a parquet.thrift struct with the compact protocol.
+func encodeThrift(s thrift.TStruct) ([]byte, error) {
+	buf := thrift.NewTMemoryBuffer()
+	proto := thrift.NewTCompactProtocolConf(buf, nil)
+	if err := s.Write(context.Background(), proto); err != nil {
+		return nil, err
+	}
+	if err := proto.Flush(context.Background()); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
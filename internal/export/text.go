@@ -0,0 +1,110 @@
+package export
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"strconv"
+)
+
+type csvWriter struct {
+	w       *csv.Writer
+	columns []Column
+	record  []string
+}
+
+func newCSVWriter(w io.Writer, columns []Column) (*csvWriter, error) {
+	cw := &csvWriter{w: csv.NewWriter(w), columns: columns, record: make([]string, len(columns))}
+	for i, column := range columns {
+		cw.record[i] = column.Name
+	}
+	if err := cw.w.Write(cw.record); err != nil {
+		return nil, err
+	}
+	return cw, nil
+}
+
+func (cw *csvWriter) Write(values []interface{}) error {
+	for i, column := range cw.columns {
+		cw.record[i] = formatText(column.Type, values[i])
+	}
+	return cw.w.Write(cw.record)
+}
+
+func (cw *csvWriter) Close() error {
+	cw.w.Flush()
+	return cw.w.Error()
+}
+
+// formatText renders a value for CSV; nulls become empty cells.
+func formatText(columnType ColumnType, value interface{}) string {
+	v, ok := coerce(columnType, value)
+	if !ok {
+		return ""
+	}
+	switch v := v.(type) {
+	case string:
+		return v
+	case bool:
+		return strconv.FormatBool(v)
+	case int32:
+		return strconv.FormatInt(int64(v), 10)
+	case int64:
+		if columnType == ColumnTimestamp {
+			return formatTimestamp(v)
+		}
+		return strconv.FormatInt(v, 10)
+	case float32:
+		return strconv.FormatFloat(float64(v), 'f', -1, 32)
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	}
+	return ""
+}
+
+// ndjsonWriter writes one JSON object per line with keys in column order.
+type ndjsonWriter struct {
+	w       *bufio.Writer
+	columns []Column
+	keys    [][]byte
+}
+
+func newNDJSONWriter(w io.Writer, columns []Column) *ndjsonWriter {
+	nw := &ndjsonWriter{w: bufio.NewWriter(w), columns: columns, keys: make([][]byte, len(columns))}
+	for i, column := range columns {
+		nw.keys[i], _ = json.Marshal(column.Name)
+	}
+	return nw
+}
+
+func (nw *ndjsonWriter) Write(values []interface{}) error {
+	nw.w.WriteByte('{')
+	for i, column := range nw.columns {
+		if i > 0 {
+			nw.w.WriteByte(',')
+		}
+		nw.w.Write(nw.keys[i])
+		nw.w.WriteByte(':')
+
+		v, ok := coerce(column.Type, values[i])
+		switch {
+		case !ok:
+			nw.w.WriteString("null")
+		case column.Type == ColumnTimestamp:
+			nw.w.WriteString(strconv.Quote(formatTimestamp(v.(int64))))
+		default:
+			data, err := json.Marshal(v)
+			if err != nil {
+				data = []byte("null") // NaN and Inf have no JSON form
+			}
+			nw.w.Write(data)
+		}
+	}
+	nw.w.WriteByte('}')
+	return nw.w.WriteByte('\n')
+}
+
+func (nw *ndjsonWriter) Close() error {
+	return nw.w.Flush()
+}
@@ -0,0 +1,159 @@
+// Package export encodes tabular telemetry as CSV, NDJSON or Parquet.
+package export
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"time"
+)
+
+// Supported formats
+const (
+	FormatCSV     = "csv"
+	FormatNDJSON  = "ndjson"
+	FormatParquet = "parquet"
+)
+
+// ColumnType is the type of the values in a column.
+type ColumnType int
+
+const (
+	ColumnString ColumnType = iota
+	ColumnBool
+	ColumnInt32
+	ColumnInt64
+	ColumnFloat
+	ColumnDouble
+	ColumnTimestamp // milliseconds since the epoch
+)
+
+// Column describes one output column.
+type Column struct {
+	Name string
+	Type ColumnType
+}
+
+// Writer writes rows in one format.
+type Writer interface {
+	// Write appends a row. Values are in column order; nil is written as null or empty.
+	// Values that cannot be converted to the column type are written as null.
+	Write(values []interface{}) error
+	// Close writes buffered rows and any trailer. The underlying io.Writer is not closed.
+	Close() error
+}
+
+// NewWriter creates a Writer for the given format.
+func NewWriter(format string, w io.Writer, columns []Column) (Writer, error) {
+	switch format {
+	case FormatCSV:
+		return newCSVWriter(w, columns)
+	case FormatNDJSON:
+		return newNDJSONWriter(w, columns), nil
+	case FormatParquet:
+		return newParquetWriter(w, columns), nil
+	default:
+		return nil, fmt.Errorf("unsupported export format: %s", format)
+	}
+}
+
+// IsFormat reports whether format is supported.
+func IsFormat(format string) bool {
+	return format == FormatCSV || format == FormatNDJSON || format == FormatParquet
+}
+
+// ContentType returns the MIME type of a format.
+func ContentType(format string) string {
+	switch format {
+	case FormatCSV:
+		return "text/csv; charset=utf-8"
+	case FormatNDJSON:
+		return "application/x-ndjson"
+	default:
+		return "application/vnd.apache.parquet"
+	}
+}
+
+// formatTimestamp renders a millisecond timestamp for text formats.
+func formatTimestamp(ms int64) string {
+	return time.UnixMilli(ms).UTC().Format("2006-01-02T15:04:05.000Z07:00")
+}
+
+// coerce converts a value to the Go type used for the column: string, bool, int32, int64
+// (also for timestamps), float32 or float64. ok is false for nil and unconvertible values.
+func coerce(columnType ColumnType, value interface{}) (interface{}, bool) {
+	if value == nil {
+		return nil, false
+	}
+	switch columnType {
+	case ColumnString:
+		if s, ok := value.(string); ok {
+			return s, true
+		}
+		return fmt.Sprintf("%v", value), true
+	case ColumnBool:
+		switch v := value.(type) {
+		case bool:
+			return v, true
+		case string:
+			b, err := strconv.ParseBool(v)
+			return b, err == nil
+		}
+		if f, ok := toFloat64(value); ok {
+			return f != 0, true
+		}
+		return nil, false
+	case ColumnInt32:
+		f, ok := toFloat64(value)
+		if !ok || f < math.MinInt32 || f > math.MaxInt32 {
+			return nil, false
+		}
+		return int32(f), true
+	case ColumnInt64, ColumnTimestamp:
+		if i, ok := value.(int64); ok {
+			return i, true
+		}
+		f, ok := toFloat64(value)
+		if !ok {
+			return nil, false
+		}
+		return int64(f), true
+	case ColumnFloat:
+		f, ok := toFloat64(value)
+		return float32(f), ok
+	case ColumnDouble:
+		if f, ok := value.(float32); ok {
+			// Keep the shortest decimal form rather than the widened binary value.
+			d, _ := strconv.ParseFloat(strconv.FormatFloat(float64(f), 'g', -1, 32), 64)
+			return d, true
+		}
+		f, ok := toFloat64(value)
+		return f, ok
+	}
+	return nil, false
+}
+
+func toFloat64(value interface{}) (float64, bool) {
+	switch v := value.(type) {
+	case int:
+		return float64(v), true
+	case int32:
+		return float64(v), true
+	case int64:
+		return float64(v), true
+	case float32:
+		return float64(v), true
+	case float64:
+		return v, true
+	case bool:
+		if v {
+			return 1, true
+		}
+		return 0, true
+	case string:
+		f, err := strconv.ParseFloat(v, 64)
+		return f, err == nil
+	}
+	return 0, false
+}
@@ -0,0 +1,181 @@
+package export
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/apache/thrift/lib/go/thrift"
+	"github.com/xitongsys/parquet-go/encoding"
+	"github.com/xitongsys/parquet-go/parquet"
+)
+
+var testColumns = []Column{
+	{Name: "timestamp", Type: ColumnTimestamp},
+	{Name: "temperature (°C)", Type: ColumnFloat},
+	{Name: "count", Type: ColumnInt32},
+	{Name: "online", Type: ColumnBool},
+	{Name: "mode", Type: ColumnString},
+}
+
+var testRows = [][]interface{}{
+	{int64(1700000000000), float32(21.5), int32(3), true, "eco"},
+	{int64(1700000001000), nil, int64(4), false, nil},
+	{int64(1700000002500), 22.25, nil, "true", "boost, fast"},
+}
+
+func writeAll(t *testing.T, format string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	w, err := NewWriter(format, &buf, testColumns)
+	if err != nil {
+		t.Fatalf("new writer: %v", err)
+	}
+	for _, row := range testRows {
+		if err := w.Write(row); err != nil {
+			t.Fatalf("write: %v", err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("close: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func TestCSVWriter(t *testing.T) {
+	want := "timestamp,temperature (°C),count,online,mode\n" +
+		"2023-11-14T22:13:20.000Z,21.5,3,true,eco\n" +
+		"2023-11-14T22:13:21.000Z,,4,false,\n" +
+		"2023-11-14T22:13:22.500Z,22.25,,true,\"boost, fast\"\n"
+	if got := string(writeAll(t, FormatCSV)); got != want {
+		t.Errorf("csv:\n got  %q\n want %q", got, want)
+	}
+}
+
+func TestNDJSONWriter(t *testing.T) {
+	lines := strings.Split(strings.TrimSpace(string(writeAll(t, FormatNDJSON))), "\n")
+	want := `{"timestamp":"2023-11-14T22:13:21.000Z","temperature (°C)":null,"count":4,"online":false,"mode":null}`
+	if len(lines) != 3 || lines[1] != want {
+		t.Errorf("ndjson line 2:\n got  %v\n want %s", lines, want)
+	}
+}
+
+// The Parquet tests decode the output with the readers of parquet-go: the footer and page headers
+// are read back as parquet.thrift structs, the definition levels and values with its decoders.
+
+func TestParquetWriter(t *testing.T) {
+	data := writeAll(t, FormatParquet)
+	if string(data[:4]) != "PAR1" || string(data[len(data)-4:]) != "PAR1" {
+		t.Fatalf("missing magic bytes")
+	}
+	meta := readParquetFooter(t, data)
+
+	if meta.NumRows != 3 {
+		t.Errorf("num_rows = %d, want 3", meta.NumRows)
+	}
+	if len(meta.Schema) != len(testColumns)+1 || meta.Schema[0].GetNumChildren() != int32(len(testColumns)) {
+		t.Fatalf("unexpected schema: %v", meta.Schema)
+	}
+	wantTypes := []string{"INT64/TIMESTAMP_MILLIS", "FLOAT/<nil>", "INT32/<nil>", "BOOLEAN/<nil>", "BYTE_ARRAY/UTF8"}
+	for i, column := range testColumns {
+		element := meta.Schema[i+1]
+		if element.Name != column.Name || element.GetRepetitionType() != parquet.FieldRepetitionType_OPTIONAL {
+			t.Errorf("column %d = %q (%v), want optional %q", i, element.Name, element.GetRepetitionType(), column.Name)
+		}
+		if got := fmt.Sprintf("%v/%v", element.GetType(), element.ConvertedType); got != wantTypes[i] {
+			t.Errorf("column %q type = %s, want %s", column.Name, got, wantTypes[i])
+		}
+	}
+
+	if len(meta.RowGroups) != 1 || meta.RowGroups[0].NumRows != 3 {
+		t.Fatalf("unexpected row groups: %v", meta.RowGroups)
+	}
+	want := [][]string{
+		{"1700000000000", "1700000001000", "1700000002500"},
+		{"21.5", "<nil>", "22.25"},
+		{"3", "4", "<nil>"},
+		{"true", "false", "true"},
+		{"eco", "<nil>", "boost, fast"},
+	}
+	for i, column := range testColumns {
+		got := readParquetColumn(t, data, meta.RowGroups[0].Columns[i].MetaData)
+		if fmt.Sprint(got) != fmt.Sprint(want[i]) {
+			t.Errorf("column %q = %v, want %v", column.Name, got, want[i])
+		}
+	}
+}
+
+func TestParquetWriterEmpty(t *testing.T) {
+	var buf bytes.Buffer
+	w, _ := NewWriter(FormatParquet, &buf, testColumns)
+	if err := w.Close(); err != nil {
+		t.Fatalf("close: %v", err)
+	}
+	if meta := readParquetFooter(t, buf.Bytes()); meta.NumRows != 0 || len(meta.RowGroups) != 0 {
+		t.Errorf("num_rows = %d with %d row groups, want none", meta.NumRows, len(meta.RowGroups))
+	}
+}
+
+func readParquetFooter(t *testing.T, data []byte) *parquet.FileMetaData {
+	t.Helper()
+	footerLen := int(binary.LittleEndian.Uint32(data[len(data)-8:]))
+	meta := parquet.NewFileMetaData()
+	readThrift(t, data[len(data)-8-footerLen:len(data)-8], meta.Read)
+	return meta
+}
+
+// readParquetColumn decodes every data page of a column chunk.
+func readParquetColumn(t *testing.T, data []byte, meta *parquet.ColumnMetaData) []string {
+	t.Helper()
+	if meta.Codec != parquet.CompressionCodec_UNCOMPRESSED {
+		t.Fatalf("column %v codec = %v", meta.PathInSchema, meta.Codec)
+	}
+	var out []string
+	chunk := data[meta.DataPageOffset : meta.DataPageOffset+meta.TotalCompressedSize]
+	for len(chunk) > 0 {
+		header := parquet.NewPageHeader()
+		n := readThrift(t, chunk, header.Read)
+		if header.Type != parquet.PageType_DATA_PAGE || header.DataPageHeader.Encoding != parquet.Encoding_PLAIN {
+			t.Fatalf("unexpected page header: %v", header)
+		}
+		page := bytes.NewReader(chunk[n : n+int(header.CompressedPageSize)])
+		chunk = chunk[n+int(header.CompressedPageSize):]
+
+		count := uint64(header.DataPageHeader.NumValues)
+		levels, err := encoding.ReadRLEBitPackedHybrid(page, 1, 0)
+		if err != nil {
+			t.Fatalf("definition levels: %v", err)
+		}
+		var defined uint64
+		for _, level := range levels[:count] {
+			defined += uint64(level.(int64))
+		}
+		values, err := encoding.ReadPlain(page, meta.Type, defined, 0)
+		if err != nil {
+			t.Fatalf("values: %v", err)
+		}
+		for _, level := range levels[:count] {
+			if level.(int64) == 0 {
+				out = append(out, "<nil>")
+				continue
+			}
+			out = append(out, fmt.Sprint(values[0]))
+			values = values[1:]
+		}
+	}
+	return out
+}
+
+// readThrift decodes a compact protocol struct and returns the number of bytes it used.
+func readThrift(t *testing.T, data []byte, read func(context.Context, thrift.TProtocol) error) int {
+	t.Helper()
+	trans := thrift.NewTMemoryBuffer()
+	trans.Write(data)
+	if err := read(context.Background(), thrift.NewTCompactProtocolConf(trans, nil)); err != nil {
+		t.Fatalf("decode thrift: %v", err)
+	}
+	return len(data) - trans.Len()
+}
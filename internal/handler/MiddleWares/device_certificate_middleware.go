@@ -0,0 +1,34 @@
+package MiddleWares
+
+import (
+	"OMEGA3-IOT/internal/pki"
+	"OMEGA3-IOT/internal/service"
+	"OMEGA3-IOT/internal/types"
+	"github.com/gin-gonic/gin"
+	"log"
+	"net/http"
+)
+
+// DeviceCertificateAuth authenticates a device by the client certificate of the TLS connection
+// (mTLS). The certificate must have been issued by the built-in CA and not be revoked; the device
+// UUID and certificate serial number are stored as device_uuid and certificate_serial.
+func DeviceCertificateAuth(certService *service.DeviceCertificateService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if c.Request.TLS == nil || len(c.Request.TLS.VerifiedChains) == 0 {
+			c.JSON(http.StatusUnauthorized, types.NewErrorResponse(http.StatusUnauthorized, "Device client certificate required"))
+			c.Abort()
+			return
+		}
+		cert := c.Request.TLS.VerifiedChains[0][0]
+		deviceUUID, err := certService.VerifyCertificate(cert)
+		if err != nil {
+			log.Printf("[DeviceCertificateAuth] Refused certificate %s: %v", pki.SerialNumber(cert), err)
+			c.JSON(http.StatusUnauthorized, types.NewErrorResponse(http.StatusUnauthorized, "Invalid device client certificate"))
+			c.Abort()
+			return
+		}
+		c.Set("device_uuid", deviceUUID)
+		c.Set("certificate_serial", pki.SerialNumber(cert))
+		c.Next()
+	}
+}
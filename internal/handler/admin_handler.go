@@ -14,12 +14,13 @@ import (
 
 // AdminHandler handles HTTP requests for admin management.
 type AdminHandler struct {
-	adminService *service.AdminService
+	adminService  *service.AdminService
+	ingestService *service.TelemetryIngestService
 }
 
 // NewAdminHandler creates a new AdminHandler.
-func NewAdminHandler(adminService *service.AdminService) *AdminHandler {
-	return &AdminHandler{adminService: adminService}
+func NewAdminHandler(adminService *service.AdminService, ingestService *service.TelemetryIngestService) *AdminHandler {
+	return &AdminHandler{adminService: adminService, ingestService: ingestService}
 }
 
 // ==================== Admin Login ====================
@@ -508,6 +509,11 @@ func (h *AdminHandler) GetStats(c *gin.Context) {
 	c.JSON(http.StatusOK, types.NewSuccessResponseWithCode(stats, http.StatusOK, "OK"))
 }
 
+// GetIngestStats handles GET /admin/stats/ingestion
+func (h *AdminHandler) GetIngestStats(c *gin.Context) {
+	c.JSON(http.StatusOK, types.NewSuccessResponseWithCode(h.ingestService.Stats(), http.StatusOK, "OK"))
+}
+
 // ==================== Admin Logs ====================
 
 // GetLogs handles GET /admin/logs
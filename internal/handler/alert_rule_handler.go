@@ -0,0 +1,206 @@
+package handler
+
+import (
+	"OMEGA3-IOT/internal/service"
+	"OMEGA3-IOT/internal/types"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// AlertRuleHandler handles HTTP requests for property alert rules.
+type AlertRuleHandler struct {
+	alertRuleService *service.AlertRuleService
+}
+
+// NewAlertRuleHandler creates a new AlertRuleHandler.
+func NewAlertRuleHandler(alertRuleService *service.AlertRuleService) *AlertRuleHandler {
+	return &AlertRuleHandler{alertRuleService: alertRuleService}
+}
+
+// CreateRule handles POST /alerts/rules
+func (h *AlertRuleHandler) CreateRule(c *gin.Context) {
+	userUUID, exists := c.Get("user_uuid")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, types.NewErrorResponse(http.StatusUnauthorized, "User not authenticated"))
+		return
+	}
+
+	var input service.AlertRuleInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, types.NewErrorResponse(http.StatusBadRequest, "Invalid request parameters", err.Error()))
+		return
+	}
+
+	rule, err := h.alertRuleService.CreateRule(userUUID.(string), input)
+	if err != nil {
+		respondAlertRuleError(c, "Failed to create alert rule", err)
+		return
+	}
+	c.JSON(http.StatusOK, types.NewSuccessResponseWithCode(rule, http.StatusOK, "Alert rule created successfully"))
+}
+
+// ListRules handles GET /alerts/rules
+func (h *AlertRuleHandler) ListRules(c *gin.Context) {
+	userUUID, exists := c.Get("user_uuid")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, types.NewErrorResponse(http.StatusUnauthorized, "User not authenticated"))
+		return
+	}
+
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	pageSize, _ := strconv.Atoi(c.DefaultQuery("page_size", "10"))
+	if page < 1 {
+		page = 1
+	}
+	if pageSize < 1 || pageSize > 100 {
+		pageSize = 10
+	}
+
+	rules, total, err := h.alertRuleService.ListRules(userUUID.(string), pageSize, (page-1)*pageSize)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, types.NewErrorResponse(http.StatusInternalServerError, "Failed to get alert rules", err.Error()))
+		return
+	}
+
+	c.JSON(http.StatusOK, types.NewSuccessResponseWithCode(gin.H{
+		"rules":     rules,
+		"total":     total,
+		"page":      page,
+		"page_size": pageSize,
+	}, http.StatusOK, "OK"))
+}
+
+// GetRule handles GET /alerts/rules/:rule_uuid
+func (h *AlertRuleHandler) GetRule(c *gin.Context) {
+	userUUID, exists := c.Get("user_uuid")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, types.NewErrorResponse(http.StatusUnauthorized, "User not authenticated"))
+		return
+	}
+
+	rule, err := h.alertRuleService.GetRule(userUUID.(string), c.Param("rule_uuid"))
+	if err != nil {
+		respondAlertRuleError(c, "Failed to get alert rule", err)
+		return
+	}
+	c.JSON(http.StatusOK, types.NewSuccessResponseWithCode(rule, http.StatusOK, "OK"))
+}
+
+// UpdateRule handles PUT /alerts/rules/:rule_uuid
+func (h *AlertRuleHandler) UpdateRule(c *gin.Context) {
+	userUUID, exists := c.Get("user_uuid")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, types.NewErrorResponse(http.StatusUnauthorized, "User not authenticated"))
+		return
+	}
+
+	var input service.AlertRuleInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, types.NewErrorResponse(http.StatusBadRequest, "Invalid request parameters", err.Error()))
+		return
+	}
+
+	rule, err := h.alertRuleService.UpdateRule(userUUID.(string), c.Param("rule_uuid"), input)
+	if err != nil {
+		respondAlertRuleError(c, "Failed to update alert rule", err)
+		return
+	}
+	c.JSON(http.StatusOK, types.NewSuccessResponseWithCode(rule, http.StatusOK, "Alert rule updated successfully"))
+}
+
+// DeleteRule handles DELETE /alerts/rules/:rule_uuid
+func (h *AlertRuleHandler) DeleteRule(c *gin.Context) {
+	userUUID, exists := c.Get("user_uuid")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, types.NewErrorResponse(http.StatusUnauthorized, "User not authenticated"))
+		return
+	}
+
+	if err := h.alertRuleService.DeleteRule(userUUID.(string), c.Param("rule_uuid")); err != nil {
+		respondAlertRuleError(c, "Failed to delete alert rule", err)
+		return
+	}
+	c.JSON(http.StatusOK, types.NewSuccessResponseWithCode(nil, http.StatusOK, "Alert rule deleted successfully"))
+}
+
+// SetEnabled handles PUT /alerts/rules/:rule_uuid/enabled
+func (h *AlertRuleHandler) SetEnabled(c *gin.Context) {
+	userUUID, exists := c.Get("user_uuid")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, types.NewErrorResponse(http.StatusUnauthorized, "User not authenticated"))
+		return
+	}
+
+	var input struct {
+		Enabled *bool `json:"enabled" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, types.NewErrorResponse(http.StatusBadRequest, "Invalid request parameters", err.Error()))
+		return
+	}
+
+	rule, err := h.alertRuleService.SetEnabled(userUUID.(string), c.Param("rule_uuid"), *input.Enabled)
+	if err != nil {
+		respondAlertRuleError(c, "Failed to update alert rule", err)
+		return
+	}
+	c.JSON(http.StatusOK, types.NewSuccessResponseWithCode(rule, http.StatusOK, "OK"))
+}
+
+// Mute handles PUT /alerts/rules/:rule_uuid/mute
+func (h *AlertRuleHandler) Mute(c *gin.Context) {
+	userUUID, exists := c.Get("user_uuid")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, types.NewErrorResponse(http.StatusUnauthorized, "User not authenticated"))
+		return
+	}
+
+	var input struct {
+		DurationSec int64 `json:"duration_sec"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, types.NewErrorResponse(http.StatusBadRequest, "Invalid request parameters", err.Error()))
+		return
+	}
+
+	rule, err := h.alertRuleService.Mute(userUUID.(string), c.Param("rule_uuid"), input.DurationSec)
+	if err != nil {
+		respondAlertRuleError(c, "Failed to mute alert rule", err)
+		return
+	}
+	c.JSON(http.StatusOK, types.NewSuccessResponseWithCode(rule, http.StatusOK, "Alert rule muted"))
+}
+
+// Unmute handles DELETE /alerts/rules/:rule_uuid/mute
+func (h *AlertRuleHandler) Unmute(c *gin.Context) {
+	userUUID, exists := c.Get("user_uuid")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, types.NewErrorResponse(http.StatusUnauthorized, "User not authenticated"))
+		return
+	}
+
+	rule, err := h.alertRuleService.Unmute(userUUID.(string), c.Param("rule_uuid"))
+	if err != nil {
+		respondAlertRuleError(c, "Failed to unmute alert rule", err)
+		return
+	}
+	c.JSON(http.StatusOK, types.NewSuccessResponseWithCode(rule, http.StatusOK, "Alert rule unmuted"))
+}
+
+func respondAlertRuleError(c *gin.Context, message string, err error) {
+	switch {
+	case errors.Is(err, service.ErrAlertRuleNotFound):
+		c.JSON(http.StatusNotFound, types.NewErrorResponse(http.StatusNotFound, "Alert rule not found", err.Error()))
+	case errors.Is(err, service.ErrDeviceNotFound):
+		c.JSON(http.StatusNotFound, types.NewErrorResponse(http.StatusNotFound, "Device not found", err.Error()))
+	case errors.Is(err, service.ErrAlertRuleAccess):
+		c.JSON(http.StatusForbidden, types.NewErrorResponse(http.StatusForbidden, "Access denied", err.Error()))
+	case errors.Is(err, service.ErrInvalidAlertRule), errors.Is(err, service.ErrUnknownDeviceType):
+		c.JSON(http.StatusBadRequest, types.NewErrorResponse(http.StatusBadRequest, "Invalid alert rule", err.Error()))
+	default:
+		c.JSON(http.StatusInternalServerError, types.NewErrorResponse(http.StatusInternalServerError, message, err.Error()))
+	}
+}
@@ -0,0 +1,110 @@
+package handler
+
+import (
+	"OMEGA3-IOT/internal/model"
+	"OMEGA3-IOT/internal/pki"
+	"OMEGA3-IOT/internal/service"
+	"OMEGA3-IOT/internal/types"
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// DeviceCertificateHandler handles device client certificates issued by the built-in CA.
+type DeviceCertificateHandler struct {
+	certService *service.DeviceCertificateService
+}
+
+// NewDeviceCertificateHandler creates a new DeviceCertificateHandler.
+func NewDeviceCertificateHandler(certService *service.DeviceCertificateService) *DeviceCertificateHandler {
+	return &DeviceCertificateHandler{certService: certService}
+}
+
+// ListCertificates handles GET /devices/:instance_uuid/certificates
+func (h *DeviceCertificateHandler) ListCertificates(c *gin.Context) {
+	certs, err := h.certService.List(c.Param("instance_uuid"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, types.NewErrorResponse(http.StatusInternalServerError, "Failed to list certificates", err.Error()))
+		return
+	}
+	c.JSON(http.StatusOK, types.NewSuccessResponseWithCode(gin.H{"certificates": certs}, http.StatusOK, "Certificates retrieved"))
+}
+
+// IssueCertificate handles POST /devices/:instance_uuid/certificates
+func (h *DeviceCertificateHandler) IssueCertificate(c *gin.Context) {
+	var input struct {
+		CSR            string `json:"csr"`
+		RevokePrevious bool   `json:"revoke_previous"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, types.NewErrorResponse(http.StatusBadRequest, "Invalid request body", err.Error()))
+		return
+	}
+
+	issued, err := h.certService.Issue(c.Param("instance_uuid"), input.CSR, c.GetString("user_uuid"), input.RevokePrevious)
+	if err != nil {
+		h.respondIssueError(c, err)
+		return
+	}
+	c.JSON(http.StatusCreated, types.NewSuccessResponseWithCode(issued, http.StatusCreated, "Certificate issued"))
+}
+
+// RevokeCertificate handles DELETE /devices/:instance_uuid/certificates/:serial
+func (h *DeviceCertificateHandler) RevokeCertificate(c *gin.Context) {
+	err := h.certService.Revoke(c.Param("instance_uuid"), c.Param("serial"), model.CertificateRevokedByOwner)
+	if err != nil {
+		switch {
+		case errors.Is(err, service.ErrCertificateNotFound):
+			c.JSON(http.StatusNotFound, types.NewErrorResponse(http.StatusNotFound, "Certificate not found"))
+		case errors.Is(err, service.ErrCertificateRevoked):
+			c.JSON(http.StatusConflict, types.NewErrorResponse(http.StatusConflict, "Certificate already revoked"))
+		default:
+			c.JSON(http.StatusInternalServerError, types.NewErrorResponse(http.StatusInternalServerError, "Failed to revoke certificate", err.Error()))
+		}
+		return
+	}
+	c.JSON(http.StatusOK, types.NewSuccessResponseWithCode(nil, http.StatusOK, "Certificate revoked"))
+}
+
+// RotateCertificate handles POST /device/certificate/rotate. The device authenticates with its
+// current client certificate, which is revoked once the new one is issued.
+func (h *DeviceCertificateHandler) RotateCertificate(c *gin.Context) {
+	var input struct {
+		CSR string `json:"csr"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, types.NewErrorResponse(http.StatusBadRequest, "Invalid request body", err.Error()))
+		return
+	}
+
+	issued, err := h.certService.Rotate(c.GetString("device_uuid"), c.GetString("certificate_serial"), input.CSR)
+	if err != nil {
+		h.respondIssueError(c, err)
+		return
+	}
+	c.JSON(http.StatusCreated, types.NewSuccessResponseWithCode(issued, http.StatusCreated, "Certificate rotated"))
+}
+
+// CACertificate handles GET /pki/ca.pem
+func (h *DeviceCertificateHandler) CACertificate(c *gin.Context) {
+	c.Data(http.StatusOK, "application/x-pem-file", h.certService.CACertificatePEM())
+}
+
+// CRL handles GET /pki/crl.pem
+func (h *DeviceCertificateHandler) CRL(c *gin.Context) {
+	crl, err := h.certService.CRL()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, types.NewErrorResponse(http.StatusInternalServerError, "Failed to generate CRL", err.Error()))
+		return
+	}
+	c.Data(http.StatusOK, "application/pkix-crl", crl)
+}
+
+func (h *DeviceCertificateHandler) respondIssueError(c *gin.Context, err error) {
+	if errors.Is(err, pki.ErrInvalidCSR) {
+		c.JSON(http.StatusBadRequest, types.NewErrorResponse(http.StatusBadRequest, "Invalid certificate signing request", err.Error()))
+		return
+	}
+	c.JSON(http.StatusInternalServerError, types.NewErrorResponse(http.StatusInternalServerError, "Failed to issue certificate", err.Error()))
+}
@@ -0,0 +1,105 @@
+package handler
+
+import (
+	"OMEGA3-IOT/internal/pki"
+	"OMEGA3-IOT/internal/service"
+	"OMEGA3-IOT/internal/types"
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// DeviceCredentialHandler handles verify code rotation, hard resets and re-provisioning.
+type DeviceCredentialHandler struct {
+	credentialService *service.DeviceCredentialService
+	adminService      *service.AdminService
+}
+
+// NewDeviceCredentialHandler creates a new DeviceCredentialHandler.
+func NewDeviceCredentialHandler(credentialService *service.DeviceCredentialService, adminService *service.AdminService) *DeviceCredentialHandler {
+	return &DeviceCredentialHandler{credentialService: credentialService, adminService: adminService}
+}
+
+// RotateCredentials handles POST /devices/:instance_uuid/credentials/rotate (owner only)
+func (h *DeviceCredentialHandler) RotateCredentials(c *gin.Context) {
+	userUUID := c.GetString("user_uuid")
+	rotation, err := h.credentialService.Rotate(c.Param("instance_uuid"), userUUID, userUUID)
+	if err != nil {
+		respondCredentialError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, types.NewSuccessResponseWithCode(rotation, http.StatusOK, "Credentials rotated"))
+}
+
+// ResetCredentials handles POST /devices/:instance_uuid/credentials/reset (owner only)
+func (h *DeviceCredentialHandler) ResetCredentials(c *gin.Context) {
+	userUUID := c.GetString("user_uuid")
+	reset, err := h.credentialService.Reset(c.Param("instance_uuid"), userUUID, userUUID)
+	if err != nil {
+		respondCredentialError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, types.NewSuccessResponseWithCode(reset, http.StatusOK, "Credentials reset"))
+}
+
+// AdminRotateCredentials handles POST /admin/devices/:instance_uuid/credentials/rotate
+func (h *DeviceCredentialHandler) AdminRotateCredentials(c *gin.Context) {
+	adminUUID := c.GetString("user_uuid")
+	instanceUUID := c.Param("instance_uuid")
+	rotation, err := h.credentialService.Rotate(instanceUUID, "", adminUUID)
+	if err != nil {
+		respondCredentialError(c, err)
+		return
+	}
+	h.adminService.LogAction(adminUUID, "device.credentials.rotate", "device", instanceUUID, "", c.ClientIP())
+	c.JSON(http.StatusOK, types.NewSuccessResponseWithCode(rotation, http.StatusOK, "Credentials rotated"))
+}
+
+// AdminResetCredentials handles POST /admin/devices/:instance_uuid/credentials/reset
+func (h *DeviceCredentialHandler) AdminResetCredentials(c *gin.Context) {
+	adminUUID := c.GetString("user_uuid")
+	instanceUUID := c.Param("instance_uuid")
+	reset, err := h.credentialService.Reset(instanceUUID, "", adminUUID)
+	if err != nil {
+		respondCredentialError(c, err)
+		return
+	}
+	h.adminService.LogAction(adminUUID, "device.credentials.reset", "device", instanceUUID, "", c.ClientIP())
+	c.JSON(http.StatusOK, types.NewSuccessResponseWithCode(reset, http.StatusOK, "Credentials reset"))
+}
+
+// Reprovision handles POST /device/reprovision, called by a reset device with its reset code.
+func (h *DeviceCredentialHandler) Reprovision(c *gin.Context) {
+	var input struct {
+		DeviceUUID string `json:"device_uuid" binding:"required"`
+		ResetCode  string `json:"reset_code" binding:"required"`
+		CSR        string `json:"csr"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, types.NewErrorResponse(http.StatusBadRequest, "Invalid request body", err.Error()))
+		return
+	}
+
+	credentials, err := h.credentialService.Reprovision(input.DeviceUUID, input.ResetCode, input.CSR)
+	if err != nil {
+		respondCredentialError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, types.NewSuccessResponseWithCode(credentials, http.StatusOK, "Device re-provisioned"))
+}
+
+func respondCredentialError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, service.ErrCredentialDeviceNotFound):
+		c.JSON(http.StatusNotFound, types.NewErrorResponse(http.StatusNotFound, "Device not found"))
+	case errors.Is(err, service.ErrCredentialRotationActive), errors.Is(err, service.ErrCredentialDeviceReset), errors.Is(err, service.ErrCredentialNoSecureChannel):
+		c.JSON(http.StatusConflict, types.NewErrorResponse(http.StatusConflict, "Credentials cannot be rotated", err.Error()))
+	case errors.Is(err, service.ErrInvalidResetCode):
+		c.JSON(http.StatusUnauthorized, types.NewErrorResponse(http.StatusUnauthorized, "Invalid or expired reset code"))
+	case errors.Is(err, pki.ErrInvalidCSR):
+		c.JSON(http.StatusBadRequest, types.NewErrorResponse(http.StatusBadRequest, "Invalid certificate signing request", err.Error()))
+	default:
+		c.JSON(http.StatusInternalServerError, types.NewErrorResponse(http.StatusInternalServerError, "Failed to update device credentials", err.Error()))
+	}
+}
@@ -0,0 +1,121 @@
+package handler
+
+import (
+	"OMEGA3-IOT/internal/service"
+	"OMEGA3-IOT/internal/types"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// DeviceEventHandler handles requests for recorded and quarantined device events.
+type DeviceEventHandler struct {
+	eventService *service.DeviceEventService
+}
+
+// NewDeviceEventHandler creates a new DeviceEventHandler.
+func NewDeviceEventHandler(eventService *service.DeviceEventService) *DeviceEventHandler {
+	return &DeviceEventHandler{eventService: eventService}
+}
+
+// deviceEventQueryInput is shared by the event and quarantine listings.
+type deviceEventQueryInput struct {
+	EventKey       string `form:"event_key"`
+	Severity       string `form:"severity"`
+	Reason         string `form:"reason"`
+	InstanceUUID   string `form:"instance_uuid"`
+	StartTimestamp int64  `form:"start_timestamp"`
+	EndTimestamp   int64  `form:"end_timestamp"`
+	Limit          int    `form:"limit" binding:"omitempty,min=1,max=100"`
+	Offset         int    `form:"offset" binding:"omitempty,min=0"`
+}
+
+func (input deviceEventQueryInput) query() service.DeviceEventQuery {
+	query := service.DeviceEventQuery{
+		InstanceUUID: input.InstanceUUID,
+		Reason:       input.Reason,
+		StartTime:    input.StartTimestamp,
+		EndTime:      input.EndTimestamp,
+		Limit:        input.Limit,
+		Offset:       input.Offset,
+	}
+	if query.Limit == 0 {
+		query.Limit = 20
+	}
+	if input.EventKey != "" {
+		query.EventKeys = strings.Split(input.EventKey, ",")
+	}
+	if input.Severity != "" {
+		query.Severities = strings.Split(input.Severity, ",")
+	}
+	return query
+}
+
+// ListEvents handles GET /devices/:instance_uuid/events
+func (h *DeviceEventHandler) ListEvents(c *gin.Context) {
+	var input deviceEventQueryInput
+	if err := c.ShouldBindQuery(&input); err != nil {
+		c.JSON(http.StatusBadRequest, types.NewErrorResponse(http.StatusBadRequest, "Invalid request parameters", err.Error()))
+		return
+	}
+	query := input.query()
+	query.InstanceUUID = c.Param("instance_uuid")
+
+	events, total, err := h.eventService.ListEvents(query)
+	if err != nil {
+		respondDeviceEventError(c, "Failed to get device events", err)
+		return
+	}
+	c.JSON(http.StatusOK, types.NewSuccessResponseWithCode(gin.H{
+		"instance_uuid": query.InstanceUUID,
+		"events":        events,
+		"total":         total,
+		"limit":         query.Limit,
+		"offset":        query.Offset,
+	}, http.StatusOK, "OK"))
+}
+
+// ListDeviceQuarantine handles GET /devices/:instance_uuid/events/quarantine
+func (h *DeviceEventHandler) ListDeviceQuarantine(c *gin.Context) {
+	var input deviceEventQueryInput
+	if err := c.ShouldBindQuery(&input); err != nil {
+		c.JSON(http.StatusBadRequest, types.NewErrorResponse(http.StatusBadRequest, "Invalid request parameters", err.Error()))
+		return
+	}
+	input.InstanceUUID = c.Param("instance_uuid")
+	h.listQuarantine(c, input.query())
+}
+
+// ListQuarantine handles GET /admin/events/quarantine
+func (h *DeviceEventHandler) ListQuarantine(c *gin.Context) {
+	var input deviceEventQueryInput
+	if err := c.ShouldBindQuery(&input); err != nil {
+		c.JSON(http.StatusBadRequest, types.NewErrorResponse(http.StatusBadRequest, "Invalid request parameters", err.Error()))
+		return
+	}
+	h.listQuarantine(c, input.query())
+}
+
+func (h *DeviceEventHandler) listQuarantine(c *gin.Context, query service.DeviceEventQuery) {
+	events, total, err := h.eventService.ListQuarantined(query)
+	if err != nil {
+		respondDeviceEventError(c, "Failed to get quarantined events", err)
+		return
+	}
+	c.JSON(http.StatusOK, types.NewSuccessResponseWithCode(gin.H{
+		"events": events,
+		"total":  total,
+		"limit":  query.Limit,
+		"offset": query.Offset,
+	}, http.StatusOK, "OK"))
+}
+
+func respondDeviceEventError(c *gin.Context, message string, err error) {
+	if errors.Is(err, service.ErrInvalidEventQuery) {
+		c.JSON(http.StatusBadRequest, types.NewErrorResponse(http.StatusBadRequest, "Invalid request parameters", err.Error()))
+		return
+	}
+	c.JSON(http.StatusInternalServerError, types.NewErrorResponse(http.StatusInternalServerError, message, err.Error()))
+}
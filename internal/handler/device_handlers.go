@@ -2,16 +2,19 @@ package handler
 
 import (
 	"OMEGA3-IOT/internal/model"
+	"OMEGA3-IOT/internal/pki"
 	"OMEGA3-IOT/internal/repository"
 	"OMEGA3-IOT/internal/service"
-	"OMEGA3-IOT/internal/spec"
 	"OMEGA3-IOT/internal/types"
 	"OMEGA3-IOT/internal/utils"
 	"errors"
+	"fmt"
 	"github.com/gin-gonic/gin"
 	"gorm.io/gorm"
 	"log"
 	"net/http"
+	"strconv"
+	"strings"
 	"time"
 )
 
@@ -20,15 +23,17 @@ type DeviceHandler struct {
 	deviceRegistrationRepo repository.DeviceRegistrationRecordRepository
 	deviceShareRepo        repository.DeviceShareRepository
 	mqttService            *service.MQTTService
+	certService            *service.DeviceCertificateService // nil when the built-in CA is disabled
 	db                     *gorm.DB
 }
 
-func NewDeviceHandler(db *gorm.DB, mqttService *service.MQTTService) *DeviceHandler {
+func NewDeviceHandler(db *gorm.DB, mqttService *service.MQTTService, certService *service.DeviceCertificateService) *DeviceHandler {
 	return &DeviceHandler{
 		instanceRepo:           repository.NewInstanceRepository(db),
 		deviceRegistrationRepo: repository.NewDeviceRegistrationRecordRepository(db),
 		deviceShareRepo:        repository.NewDeviceShareRepository(db),
 		mqttService:            mqttService,
+		certService:            certService,
 		db:                     db,
 	}
 }
@@ -58,6 +63,11 @@ func (d *DeviceHandler) AddDevice(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, response)
 		return
 	}
+	if deviceType.Deprecated {
+		response := types.NewErrorResponse(http.StatusBadRequest, "Device type is deprecated", "")
+		c.JSON(http.StatusBadRequest, response)
+		return
+	}
 
 	instance, err := model.NewInstanceFromConfig(input.Name, userUUID.(string), deviceType, "", input.Description, utils.GenerateUUID().String(), model.BindByWiFi)
 	if err != nil {
@@ -84,6 +94,9 @@ func (d *DeviceHandler) AddDevice(c *gin.Context) {
 func (d *DeviceHandler) DeviceRegisterAnonymously(c *gin.Context) {
 	var input struct {
 		DeviceTypeID int `form:"device_type_id" binding:"required"`
+		// CSR is an optional PEM certificate signing request; without it the key pair of the
+		// device certificate is generated
+		CSR string `form:"csr"`
 	}
 
 	if err := c.ShouldBind(&input); err != nil {
@@ -100,12 +113,17 @@ func (d *DeviceHandler) DeviceRegisterAnonymously(c *gin.Context) {
 		return
 	}
 
-	_, valid := model.GlobalDeviceTypeManager.GetById(input.DeviceTypeID)
+	deviceType, valid := model.GlobalDeviceTypeManager.GetById(input.DeviceTypeID)
 	if !valid {
 		response := types.NewErrorResponse(http.StatusBadRequest, "Unsupported device type", "")
 		c.JSON(http.StatusBadRequest, response)
 		return
 	}
+	if deviceType.Deprecated {
+		response := types.NewErrorResponse(http.StatusBadRequest, "Device type is deprecated", "")
+		c.JSON(http.StatusBadRequest, response)
+		return
+	}
 
 	hashedVerifyCode := utils.HashVerifyCode(verifyCode)
 	record, err := model.NewRegistrationRecord(input.DeviceTypeID, hashedVerifyCode)
@@ -126,7 +144,7 @@ func (d *DeviceHandler) DeviceRegisterAnonymously(c *gin.Context) {
 		return
 	}
 
-	response := types.NewSuccessResponseWithCode(gin.H{
+	data := gin.H{
 		"device": gin.H{
 			"id":          record.ID,
 			"uuid":        record.DeviceUUID,
@@ -135,7 +153,24 @@ func (d *DeviceHandler) DeviceRegisterAnonymously(c *gin.Context) {
 			"expires_at":  record.ExpiresAt,
 			"verify_code": verifyCode,
 		},
-	}, http.StatusOK, "Device Registered successfully")
+	}
+	if d.certService != nil {
+		certificate, err := d.certService.Issue(record.DeviceUUID, input.CSR, "", false)
+		if err != nil {
+			log.Printf("Failed to issue certificate to device %s: %v", record.DeviceUUID, err)
+			if errors.Is(err, pki.ErrInvalidCSR) {
+				response := types.NewErrorResponse(http.StatusBadRequest, "Invalid certificate signing request", err.Error())
+				c.JSON(http.StatusBadRequest, response)
+				return
+			}
+			response := types.NewErrorResponse(http.StatusInternalServerError, "Failed to issue device certificate", err.Error())
+			c.JSON(http.StatusInternalServerError, response)
+			return
+		}
+		data["certificate"] = certificate
+	}
+
+	response := types.NewSuccessResponseWithCode(data, http.StatusOK, "Device Registered successfully")
 	c.JSON(http.StatusOK, response)
 }
 
@@ -229,7 +264,7 @@ func DeviceRegisterAnonymouslyHandlerFactory(deviceService *service.DeviceServic
 	}
 }
 
-func SendActionHandlerFactory(mqttService *service.MQTTService, deviceService *service.DeviceService) gin.HandlerFunc {
+func SendActionHandlerFactory(actionService *service.ActionService) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		instanceUUID := c.Param("instance_uuid")
 		if instanceUUID == "" {
@@ -240,6 +275,7 @@ func SendActionHandlerFactory(mqttService *service.MQTTService, deviceService *s
 		var input struct {
 			Command string                 `json:"command" binding:"required"`
 			Params  map[string]interface{} `json:"params,omitempty"`
+			TTLSec  int                    `json:"ttl_sec,omitempty" binding:"min=0"`
 		}
 
 		if err := c.ShouldBindJSON(&input); err != nil {
@@ -248,44 +284,68 @@ func SendActionHandlerFactory(mqttService *service.MQTTService, deviceService *s
 			return
 		}
 
-		// Look up the device to get its type, then validate the action against the spec
-		instance, err := deviceService.GetDeviceByInstanceUUID(instanceUUID)
+		// ActionService validates the action against the spec, records it and publishes it
+		record, err := actionService.SendAction(instanceUUID, input.Command, input.Params, c.GetString("user_uuid"), input.TTLSec)
 		if err != nil {
-			response := types.NewErrorResponse(http.StatusNotFound, "Device not found", err.Error())
-			c.JSON(http.StatusNotFound, response)
+			switch {
+			case errors.Is(err, service.ErrDeviceNotFound):
+				c.JSON(http.StatusNotFound, types.NewErrorResponse(http.StatusNotFound, "Device not found", err.Error()))
+			case errors.Is(err, service.ErrInvalidAction):
+				c.JSON(http.StatusBadRequest, types.NewErrorResponse(http.StatusBadRequest, "Action validation failed", err.Error()))
+			case errors.Is(err, service.ErrUnknownDeviceType):
+				c.JSON(http.StatusInternalServerError, types.NewErrorResponse(http.StatusInternalServerError, "Unknown device type", err.Error()))
+			default:
+				c.JSON(http.StatusInternalServerError, types.NewErrorResponse(http.StatusInternalServerError, "Failed to send action", err.Error()))
+			}
 			return
 		}
-
-		typeDef, ok := model.GlobalDeviceTypeManager.GetByName(instance.Type)
-		if !ok {
-			response := types.NewErrorResponse(http.StatusInternalServerError, "Unknown device type", instance.Type)
+		if record.Status == model.ActionStatusFailed {
+			response := types.NewErrorResponse(http.StatusInternalServerError, "Failed to send action", record.Error)
 			c.JSON(http.StatusInternalServerError, response)
 			return
 		}
 
-		if err := spec.ValidateAction(typeDef, input.Command, input.Params); err != nil {
-			response := types.NewErrorResponse(http.StatusBadRequest, "Action validation failed", err.Error())
-			c.JSON(http.StatusBadRequest, response)
+		data := gin.H{
+			"action_id":     record.ActionID,
+			"instance_uuid": instanceUUID,
+			"command":       input.Command,
+			"status":        record.Status,
+			"expires_at":    record.ExpiresAt,
+		}
+		if record.Status == model.ActionStatusQueued {
+			c.JSON(http.StatusAccepted, types.NewSuccessResponseWithCode(data, http.StatusAccepted, "Device offline, action queued"))
 			return
 		}
+		c.JSON(http.StatusOK, types.NewSuccessResponseWithCode(data, http.StatusOK, "Action sent successfully"))
+	}
+}
 
-		actionPayload := model.Action{
-			Command:   input.Command,
-			Params:    input.Params,
-			Timestamp: time.Now().Unix(),
+// GetActionHistoryHandlerFactory handles GET /devices/:instance_uuid/actions/history
+func GetActionHistoryHandlerFactory(actionService *service.ActionService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		instanceUUID := c.Param("instance_uuid")
+		limit, _ := strconv.Atoi(c.DefaultQuery("limit", "20"))
+		offset, _ := strconv.Atoi(c.DefaultQuery("offset", "0"))
+		if limit <= 0 || limit > 100 {
+			limit = 20
+		}
+		if offset < 0 {
+			offset = 0
 		}
 
-		err = mqttService.PublishActionToDevice(instanceUUID, actionPayload.Command, actionPayload)
+		records, total, err := actionService.GetHistory(instanceUUID, limit, offset)
 		if err != nil {
-			response := types.NewErrorResponse(http.StatusInternalServerError, "Failed to send action", err.Error())
-			c.JSON(http.StatusInternalServerError, response)
+			c.JSON(http.StatusInternalServerError, types.NewErrorResponse(http.StatusInternalServerError, "Failed to get action history", err.Error()))
 			return
 		}
-		response := types.NewSuccessResponseWithCode(gin.H{
+
+		c.JSON(http.StatusOK, types.NewSuccessResponseWithCode(gin.H{
 			"instance_uuid": instanceUUID,
-			"command":       input.Command,
-		}, http.StatusOK, "Action sent successfully")
-		c.JSON(http.StatusOK, response)
+			"actions":       records,
+			"total":         total,
+			"limit":         limit,
+			"offset":        offset,
+		}, http.StatusOK, "OK"))
 	}
 }
 
@@ -404,6 +464,11 @@ func GetDeviceHistoryHandlerFactory(deviceService *service.DeviceService) gin.Ha
 				c.JSON(http.StatusForbidden, response)
 				return
 			}
+			if errors.Is(err, service.ErrInvalidTelemetryQuery) {
+				response := types.NewErrorResponse(http.StatusBadRequest, "Invalid request parameters", err.Error())
+				c.JSON(http.StatusBadRequest, response)
+				return
+			}
 			response := types.NewErrorResponse(http.StatusInternalServerError, "Internal server error", err.Error())
 			c.JSON(http.StatusInternalServerError, response)
 			return
@@ -423,6 +488,80 @@ func GetDeviceHistoryHandlerFactory(deviceService *service.DeviceService) gin.Ha
 	}
 }
 
+// GetDeviceTelemetryHandlerFactory handles GET /devices/:instance_uuid/telemetry
+func GetDeviceTelemetryHandlerFactory(deviceService *service.DeviceService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var input struct {
+			StartTimestamp int64  `form:"start_timestamp" binding:"required"`
+			EndTimestamp   int64  `form:"end_timestamp" binding:"required"`
+			Properties     string `form:"properties"`
+			Aggregation    string `form:"aggregation"`
+			Interval       string `form:"interval"`
+			Limit          int    `form:"limit" binding:"omitempty,min=1,max=5000"`
+			Cursor         string `form:"cursor"`
+		}
+		if err := c.ShouldBindQuery(&input); err != nil {
+			response := types.NewErrorResponse(http.StatusBadRequest, "Invalid request parameters", err.Error())
+			c.JSON(http.StatusBadRequest, response)
+			return
+		}
+
+		query := service.TelemetryHistoryQuery{
+			StartTime:   input.StartTimestamp,
+			EndTime:     input.EndTimestamp,
+			Aggregation: input.Aggregation,
+			Limit:       input.Limit,
+			Cursor:      input.Cursor,
+		}
+		if input.Properties != "" {
+			query.Properties = strings.Split(input.Properties, ",")
+		}
+		if input.Aggregation != "" {
+			interval, err := parseTelemetryInterval(input.Interval)
+			if err != nil {
+				response := types.NewErrorResponse(http.StatusBadRequest, "Invalid interval", err.Error())
+				c.JSON(http.StatusBadRequest, response)
+				return
+			}
+			query.Interval = interval
+		}
+
+		history, err := deviceService.QueryTelemetryHistory(c.Param("instance_uuid"), query)
+		if err != nil {
+			switch {
+			case errors.Is(err, service.ErrDeviceNotFound):
+				c.JSON(http.StatusNotFound, types.NewErrorResponse(http.StatusNotFound, "Device not found", ""))
+			case errors.Is(err, service.ErrInvalidTelemetryQuery):
+				c.JSON(http.StatusBadRequest, types.NewErrorResponse(http.StatusBadRequest, "Invalid request parameters", err.Error()))
+			default:
+				c.JSON(http.StatusInternalServerError, types.NewErrorResponse(http.StatusInternalServerError, "Failed to query telemetry", err.Error()))
+			}
+			return
+		}
+
+		c.JSON(http.StatusOK, types.NewSuccessResponseWithCode(history, http.StatusOK, "OK"))
+	}
+}
+
+// parseTelemetryInterval accepts Go durations ("15m", "1h") and whole days ("1d").
+func parseTelemetryInterval(value string) (time.Duration, error) {
+	if value == "" {
+		return 0, errors.New("interval is required with an aggregation")
+	}
+	if days, ok := strings.CutSuffix(value, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil || n <= 0 {
+			return 0, fmt.Errorf("invalid interval '%s'", value)
+		}
+		return time.Duration(n) * 24 * time.Hour, nil
+	}
+	interval, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid interval '%s'", value)
+	}
+	return interval, nil
+}
+
 func ShareDeviceHandlerFactory(deviceShareService *service.DeviceShareService) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		instanceUUID := c.Param("instance_uuid")
@@ -0,0 +1,90 @@
+package handler
+
+import (
+	"OMEGA3-IOT/internal/model"
+	"OMEGA3-IOT/internal/pki"
+	"OMEGA3-IOT/internal/repository"
+	"OMEGA3-IOT/internal/service"
+	"crypto/tls"
+	"crypto/x509"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+type fakeCertRepo struct {
+	repository.DeviceCertificateRepository
+	certs map[string]*model.DeviceCertificate
+}
+
+func (r *fakeCertRepo) FindBySerial(serial string) (*model.DeviceCertificate, error) {
+	if cert, ok := r.certs[serial]; ok {
+		return cert, nil
+	}
+	return nil, gorm.ErrRecordNotFound
+}
+
+func TestDeviceRoutesRequireClientCertificate(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	ca, err := pki.LoadOrCreateCA(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	issue := func(deviceUUID string) *x509.Certificate {
+		issued, err := ca.IssueDeviceCertificate(deviceUUID, nil, time.Hour)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return issued.Certificate
+	}
+	valid, revoked, unknown := issue("device-1"), issue("device-2"), issue("device-3")
+	repo := &fakeCertRepo{certs: map[string]*model.DeviceCertificate{
+		pki.SerialNumber(valid):   {DeviceUUID: "device-1", SerialNumber: pki.SerialNumber(valid)},
+		pki.SerialNumber(revoked): {DeviceUUID: "device-2", SerialNumber: pki.SerialNumber(revoked), RevokedAt: time.Now().Unix()},
+	}}
+	certHandler := NewDeviceCertificateHandler(service.NewDeviceCertificateService(repo, ca, 365, nil))
+
+	route := func(requireDeviceCert bool) *gin.Engine {
+		r := gin.New()
+		regDeviceRoutes(r.Group("/api/v1"), &DeviceHandler{}, &DeviceCredentialHandler{}, certHandler, requireDeviceCert)
+		return r
+	}
+	post := func(r *gin.Engine, path string, cert *x509.Certificate) int {
+		req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(""))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		if cert != nil {
+			req.TLS = &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{cert}}}
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	// The handlers answer an empty body with 400, so 401 means the middleware refused the request
+	cases := []struct {
+		name    string
+		require bool
+		path    string
+		cert    *x509.Certificate
+		want    int
+	}{
+		{"register without certificate", true, "/api/v1/device/deviceRegisterAnon", nil, http.StatusUnauthorized},
+		{"register with revoked certificate", true, "/api/v1/device/deviceRegisterAnon", revoked, http.StatusUnauthorized},
+		{"register with unknown certificate", true, "/api/v1/device/deviceRegisterAnon", unknown, http.StatusUnauthorized},
+		{"register with valid certificate", true, "/api/v1/device/deviceRegisterAnon", valid, http.StatusBadRequest},
+		{"rotate without certificate", true, "/api/v1/device/certificate/rotate", nil, http.StatusUnauthorized},
+		{"reprovision is authenticated by the reset code", true, "/api/v1/device/reprovision", nil, http.StatusBadRequest},
+		{"register when not required", false, "/api/v1/device/deviceRegisterAnon", nil, http.StatusBadRequest},
+		{"rotate when not required", false, "/api/v1/device/certificate/rotate", nil, http.StatusUnauthorized},
+	}
+	for _, c := range cases {
+		if got := post(route(c.require), c.path, c.cert); got != c.want {
+			t.Errorf("%s: status %d, want %d", c.name, got, c.want)
+		}
+	}
+}
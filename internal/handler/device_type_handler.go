@@ -0,0 +1,245 @@
+package handler
+
+import (
+	"OMEGA3-IOT/internal/model"
+	"OMEGA3-IOT/internal/service"
+	"OMEGA3-IOT/internal/types"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// DeviceTypeHandler handles admin requests for device type definitions.
+type DeviceTypeHandler struct {
+	deviceTypeService *service.DeviceTypeService
+	adminService      *service.AdminService
+}
+
+// NewDeviceTypeHandler creates a new DeviceTypeHandler.
+func NewDeviceTypeHandler(deviceTypeService *service.DeviceTypeService, adminService *service.AdminService) *DeviceTypeHandler {
+	return &DeviceTypeHandler{deviceTypeService: deviceTypeService, adminService: adminService}
+}
+
+// ListDeviceTypes handles GET /admin/device-types
+func (h *DeviceTypeHandler) ListDeviceTypes(c *gin.Context) {
+	snapshot := model.GlobalDeviceTypeManager.Snapshot()
+	deviceTypes := make([]*model.DeviceType, 0, len(snapshot))
+	for _, dt := range snapshot {
+		deviceTypes = append(deviceTypes, dt)
+	}
+	sort.Slice(deviceTypes, func(i, j int) bool { return deviceTypes[i].ID < deviceTypes[j].ID })
+
+	c.JSON(http.StatusOK, types.NewSuccessResponseWithCode(gin.H{
+		"device_types": deviceTypes,
+		"total":        len(deviceTypes),
+	}, http.StatusOK, "OK"))
+}
+
+// GetDeviceType handles GET /admin/device-types/:type_name
+func (h *DeviceTypeHandler) GetDeviceType(c *gin.Context) {
+	deviceType, ok := model.GlobalDeviceTypeManager.GetByName(c.Param("type_name"))
+	if !ok {
+		c.JSON(http.StatusNotFound, types.NewErrorResponse(http.StatusNotFound, "Device type not found"))
+		return
+	}
+	c.JSON(http.StatusOK, types.NewSuccessResponseWithCode(deviceType, http.StatusOK, "OK"))
+}
+
+// CreateDeviceType handles POST /admin/device-types
+func (h *DeviceTypeHandler) CreateDeviceType(c *gin.Context) {
+	var input model.DeviceType
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, types.NewErrorResponse(http.StatusBadRequest, "Invalid request parameters", err.Error()))
+		return
+	}
+
+	deviceType, err := h.deviceTypeService.Create(input)
+	if err != nil {
+		respondDeviceTypeError(c, "Failed to create device type", err)
+		return
+	}
+	h.logChange(c, "device_type.create", deviceType, nil)
+	c.JSON(http.StatusCreated, types.NewSuccessResponseWithCode(deviceType, http.StatusCreated, "Device type created"))
+}
+
+// UpdateDeviceType handles PUT /admin/device-types/:type_name
+func (h *DeviceTypeHandler) UpdateDeviceType(c *gin.Context) {
+	var input model.DeviceType
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, types.NewErrorResponse(http.StatusBadRequest, "Invalid request parameters", err.Error()))
+		return
+	}
+
+	deviceType, err := h.deviceTypeService.Update(c.Param("type_name"), input)
+	if err != nil {
+		respondDeviceTypeError(c, "Failed to update device type", err)
+		return
+	}
+	h.logChange(c, "device_type.update", deviceType, nil)
+	c.JSON(http.StatusOK, types.NewSuccessResponseWithCode(deviceType, http.StatusOK, "Device type updated"))
+}
+
+// CloneDeviceType handles POST /admin/device-types/:type_name/clone
+func (h *DeviceTypeHandler) CloneDeviceType(c *gin.Context) {
+	var input struct {
+		Name    string `json:"name" binding:"required"`
+		ID      int    `json:"id" binding:"required"`
+		Version string `json:"version"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, types.NewErrorResponse(http.StatusBadRequest, "Invalid request parameters", err.Error()))
+		return
+	}
+
+	source := c.Param("type_name")
+	deviceType, err := h.deviceTypeService.Clone(source, input.Name, input.ID, input.Version)
+	if err != nil {
+		respondDeviceTypeError(c, "Failed to clone device type", err)
+		return
+	}
+	h.logChange(c, "device_type.clone", deviceType, gin.H{"source": source})
+	c.JSON(http.StatusCreated, types.NewSuccessResponseWithCode(deviceType, http.StatusCreated, "Device type cloned"))
+}
+
+// SetDeviceTypeDeprecated handles PUT /admin/device-types/:type_name/deprecated
+func (h *DeviceTypeHandler) SetDeviceTypeDeprecated(c *gin.Context) {
+	var input struct {
+		Deprecated *bool `json:"deprecated" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, types.NewErrorResponse(http.StatusBadRequest, "Invalid request parameters", err.Error()))
+		return
+	}
+
+	deviceType, err := h.deviceTypeService.SetDeprecated(c.Param("type_name"), *input.Deprecated)
+	if err != nil {
+		respondDeviceTypeError(c, "Failed to update device type", err)
+		return
+	}
+	h.logChange(c, "device_type.deprecate", deviceType, gin.H{"deprecated": deviceType.Deprecated})
+	c.JSON(http.StatusOK, types.NewSuccessResponseWithCode(deviceType, http.StatusOK, "Device type updated"))
+}
+
+// ExportDeviceTypes handles GET /admin/device-types/export
+func (h *DeviceTypeHandler) ExportDeviceTypes(c *gin.Context) {
+	var names []string
+	if raw := c.Query("names"); raw != "" {
+		names = strings.Split(raw, ",")
+	}
+
+	data, err := h.deviceTypeService.Export(names)
+	if err != nil {
+		respondDeviceTypeError(c, "Failed to export device types", err)
+		return
+	}
+	c.Header("Content-Disposition", `attachment; filename="device_types.yaml"`)
+	c.Data(http.StatusOK, "application/yaml", data)
+}
+
+// ImportDeviceTypes handles POST /admin/device-types/import
+// The body is a YAML document in the format of the definition file.
+func (h *DeviceTypeHandler) ImportDeviceTypes(c *gin.Context) {
+	data, err := c.GetRawData()
+	if err != nil || len(data) == 0 {
+		c.JSON(http.StatusBadRequest, types.NewErrorResponse(http.StatusBadRequest, "Request body must be a YAML document"))
+		return
+	}
+
+	diff, err := h.deviceTypeService.Import(data)
+	if err != nil {
+		respondDeviceTypeError(c, "Failed to import device types", err)
+		return
+	}
+
+	if !diff.IsEmpty() {
+		detail, _ := json.Marshal(diff)
+		h.adminService.LogAction(c.GetString("user_uuid"), "device_type.import", "device_type", "", string(detail), c.ClientIP())
+	}
+
+	c.JSON(http.StatusOK, types.NewSuccessResponseWithCode(gin.H{
+		"changed": !diff.IsEmpty(),
+		"diff":    diff,
+	}, http.StatusOK, "Device types imported"))
+}
+
+// ReloadDeviceTypes handles POST /admin/device-types/reload
+func (h *DeviceTypeHandler) ReloadDeviceTypes(c *gin.Context) {
+	diff, err := h.deviceTypeService.Reload(service.DeviceTypeReloadByAdmin)
+	if err != nil {
+		respondDeviceTypeError(c, "Failed to reload device types", err)
+		return
+	}
+
+	if !diff.IsEmpty() {
+		detail, _ := json.Marshal(diff)
+		h.adminService.LogAction(c.GetString("user_uuid"), "device_type.reload", "device_type", "", string(detail), c.ClientIP())
+	}
+
+	c.JSON(http.StatusOK, types.NewSuccessResponseWithCode(gin.H{
+		"changed": !diff.IsEmpty(),
+		"diff":    diff,
+	}, http.StatusOK, "Device types reloaded"))
+}
+
+// MigrateDeviceType handles POST /admin/device-types/:type_name/migrate
+func (h *DeviceTypeHandler) MigrateDeviceType(c *gin.Context) {
+	var input struct {
+		DryRun        *bool    `json:"dry_run"`
+		InstanceUUIDs []string `json:"instance_uuids"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil && !errors.Is(err, io.EOF) {
+		c.JSON(http.StatusBadRequest, types.NewErrorResponse(http.StatusBadRequest, "Invalid request parameters", err.Error()))
+		return
+	}
+	// Without an explicit "dry_run": false nothing is written.
+	dryRun := input.DryRun == nil || *input.DryRun
+
+	typeName := c.Param("type_name")
+	report, err := h.deviceTypeService.MigrateInstances(typeName, input.InstanceUUIDs, dryRun)
+	if err != nil {
+		switch {
+		case errors.Is(err, service.ErrUnknownDeviceType):
+			c.JSON(http.StatusNotFound, types.NewErrorResponse(http.StatusNotFound, "Device type not found", err.Error()))
+		case errors.Is(err, service.ErrDeviceNotFound):
+			c.JSON(http.StatusNotFound, types.NewErrorResponse(http.StatusNotFound, "Device not found", err.Error()))
+		default:
+			c.JSON(http.StatusInternalServerError, types.NewErrorResponse(http.StatusInternalServerError, "Failed to migrate devices", err.Error()))
+		}
+		return
+	}
+
+	if !dryRun && report.Migrated > 0 {
+		detail, _ := json.Marshal(gin.H{"version": report.TargetVersion, "migrated": report.Migrated, "failed": report.Failed})
+		h.adminService.LogAction(c.GetString("user_uuid"), "device_type.migrate", "device_type", typeName, string(detail), c.ClientIP())
+	}
+
+	c.JSON(http.StatusOK, types.NewSuccessResponseWithCode(report, http.StatusOK, "OK"))
+}
+
+// logChange records a change to a single device type in the admin log.
+func (h *DeviceTypeHandler) logChange(c *gin.Context, action string, deviceType *model.DeviceType, extra gin.H) {
+	fields := gin.H{"id": deviceType.ID, "version": deviceType.Version}
+	for key, value := range extra {
+		fields[key] = value
+	}
+	detail, _ := json.Marshal(fields)
+	h.adminService.LogAction(c.GetString("user_uuid"), action, "device_type", deviceType.Name, string(detail), c.ClientIP())
+}
+
+func respondDeviceTypeError(c *gin.Context, message string, err error) {
+	switch {
+	case errors.Is(err, service.ErrUnknownDeviceType):
+		c.JSON(http.StatusNotFound, types.NewErrorResponse(http.StatusNotFound, "Device type not found", err.Error()))
+	case errors.Is(err, service.ErrDeviceTypeExists):
+		c.JSON(http.StatusConflict, types.NewErrorResponse(http.StatusConflict, "Device type already exists", err.Error()))
+	case errors.Is(err, service.ErrInvalidDeviceTypes):
+		c.JSON(http.StatusUnprocessableEntity, types.NewErrorResponse(http.StatusUnprocessableEntity, "Device type definitions rejected", err.Error()))
+	default:
+		c.JSON(http.StatusInternalServerError, types.NewErrorResponse(http.StatusInternalServerError, message, err.Error()))
+	}
+}
@@ -0,0 +1,114 @@
+package handler
+
+import (
+	"OMEGA3-IOT/internal/service"
+	"OMEGA3-IOT/internal/types"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// EventBusHandler handles admin requests for event bus subscriptions and dead letters.
+type EventBusHandler struct {
+	deadLetterService *service.EventDeadLetterService
+	adminService      *service.AdminService
+}
+
+// NewEventBusHandler creates a new EventBusHandler.
+func NewEventBusHandler(deadLetterService *service.EventDeadLetterService, adminService *service.AdminService) *EventBusHandler {
+	return &EventBusHandler{deadLetterService: deadLetterService, adminService: adminService}
+}
+
+// ListSubscriptions handles GET /admin/event-bus/subscriptions
+func (h *EventBusHandler) ListSubscriptions(c *gin.Context) {
+	c.JSON(http.StatusOK, types.NewSuccessResponseWithCode(gin.H{"subscriptions": h.deadLetterService.Subscriptions()}, http.StatusOK, "OK"))
+}
+
+// ListDeadLetters handles GET /admin/event-bus/dead-letters
+func (h *EventBusHandler) ListDeadLetters(c *gin.Context) {
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "20"))
+	offset, _ := strconv.Atoi(c.DefaultQuery("offset", "0"))
+	if limit <= 0 || limit > 100 {
+		limit = 20
+	}
+	if offset < 0 {
+		offset = 0
+	}
+
+	letters, total, err := h.deadLetterService.List(c.Query("event_type"), c.Query("group"), limit, offset)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, types.NewErrorResponse(http.StatusInternalServerError, "Failed to list dead letters", err.Error()))
+		return
+	}
+	c.JSON(http.StatusOK, types.NewSuccessResponseWithCode(gin.H{
+		"dead_letters": letters,
+		"total":        total,
+		"limit":        limit,
+		"offset":       offset,
+	}, http.StatusOK, "OK"))
+}
+
+// GetDeadLetter handles GET /admin/event-bus/dead-letters/:id
+func (h *EventBusHandler) GetDeadLetter(c *gin.Context) {
+	id, ok := parseDeadLetterID(c)
+	if !ok {
+		return
+	}
+	letter, err := h.deadLetterService.Get(id)
+	if err != nil {
+		respondDeadLetterError(c, "Failed to get dead letter", err)
+		return
+	}
+	c.JSON(http.StatusOK, types.NewSuccessResponseWithCode(letter, http.StatusOK, "OK"))
+}
+
+// ReplayDeadLetter handles POST /admin/event-bus/dead-letters/:id/replay
+func (h *EventBusHandler) ReplayDeadLetter(c *gin.Context) {
+	id, ok := parseDeadLetterID(c)
+	if !ok {
+		return
+	}
+	letter, err := h.deadLetterService.Replay(id)
+	if err != nil {
+		respondDeadLetterError(c, "Failed to replay dead letter", err)
+		return
+	}
+	h.adminService.LogAction(c.GetString("user_uuid"), "event.dead_letter.replay", "event_dead_letter", c.Param("id"), letter.EventType, c.ClientIP())
+	c.JSON(http.StatusOK, types.NewSuccessResponseWithCode(letter, http.StatusOK, "Dead letter replayed"))
+}
+
+// DeleteDeadLetter handles DELETE /admin/event-bus/dead-letters/:id
+func (h *EventBusHandler) DeleteDeadLetter(c *gin.Context) {
+	id, ok := parseDeadLetterID(c)
+	if !ok {
+		return
+	}
+	if err := h.deadLetterService.Delete(id); err != nil {
+		respondDeadLetterError(c, "Failed to delete dead letter", err)
+		return
+	}
+	h.adminService.LogAction(c.GetString("user_uuid"), "event.dead_letter.delete", "event_dead_letter", c.Param("id"), "", c.ClientIP())
+	c.JSON(http.StatusOK, types.NewSuccessResponseWithCode(gin.H{"id": id}, http.StatusOK, "Dead letter deleted"))
+}
+
+func parseDeadLetterID(c *gin.Context) (uint, bool) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, types.NewErrorResponse(http.StatusBadRequest, "Invalid dead letter ID", err.Error()))
+		return 0, false
+	}
+	return uint(id), true
+}
+
+func respondDeadLetterError(c *gin.Context, message string, err error) {
+	switch {
+	case errors.Is(err, service.ErrDeadLetterNotFound):
+		c.JSON(http.StatusNotFound, types.NewErrorResponse(http.StatusNotFound, "Dead letter not found"))
+	case errors.Is(err, service.ErrDeadLetterNotReplayable), errors.Is(err, service.ErrDeadLetterReplayFailed):
+		c.JSON(http.StatusConflict, types.NewErrorResponse(http.StatusConflict, message, err.Error()))
+	default:
+		c.JSON(http.StatusInternalServerError, types.NewErrorResponse(http.StatusInternalServerError, message, err.Error()))
+	}
+}
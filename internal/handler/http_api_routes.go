@@ -29,7 +29,41 @@ func Cors() gin.HandlerFunc {
 	}
 }
 
-func RegRoutes(router *gin.Engine, userHandler *UserHandler, deviceHandler *DeviceHandler, logHandler *logger.LogHandler, deviceService *service.DeviceService, deviceShareService *service.DeviceShareService, deviceFolderHandler *DeviceFolderHandler, mqttService *service.MQTTService, jwtAuth *MiddleWares.JWTAuth, pushHandler *push.PushHandler, userGroupHandler *UserGroupHandler, adminHandler *AdminHandler, publicInstanceService *service.PublicInstanceService) {
+// Dependencies are the handlers and services RegRoutes wires into the router.
+type Dependencies struct {
+	JWTAuth *MiddleWares.JWTAuth
+
+	UserHandler              *UserHandler
+	UserGroupHandler         *UserGroupHandler
+	AdminHandler             *AdminHandler
+	DeviceHandler            *DeviceHandler
+	DeviceFolderHandler      *DeviceFolderHandler
+	DeviceTypeHandler        *DeviceTypeHandler
+	DeviceEventHandler       *DeviceEventHandler
+	DeviceCertificateHandler *DeviceCertificateHandler
+	DeviceCredentialHandler  *DeviceCredentialHandler
+	AlertRuleHandler         *AlertRuleHandler
+	WebhookHandler           *WebhookHandler
+	AdminWebhookHandler      *WebhookHandler
+	TelemetryExportHandler   *TelemetryExportHandler
+	RetentionHandler         *RetentionHandler
+	EventBusHandler          *EventBusHandler
+	LogHandler               *logger.LogHandler
+	PushHandler              *push.PushHandler
+	MQTTAuthHookHandler      *MQTTAuthHookHandler // nil unless mqtt.auth_hook is enabled
+
+	DeviceService         *service.DeviceService
+	DeviceShareService    *service.DeviceShareService
+	PublicInstanceService *service.PublicInstanceService
+	ActionService         *service.ActionService
+	ShadowService         *service.ShadowService
+
+	// RequireDeviceCert puts every device route behind a client certificate
+	// (server.require_client_cert_for_devices).
+	RequireDeviceCert bool
+}
+
+func RegRoutes(router *gin.Engine, deps Dependencies) {
 	// Avatar files: use versioned URLs (?t=updatedAt), so each version
 	// is immutable. Aggressive caching is safe — new uploads get new timestamps.
 	router.Use(func(c *gin.Context) {
@@ -41,6 +75,16 @@ func RegRoutes(router *gin.Engine, userHandler *UserHandler, deviceHandler *Devi
 	router.StaticFile("/debugger", "./debugger/index.html")
 	router.Static("/debugger/assets", "./debugger/assets")
 
+	// External MQTT broker hooks, called for every connection and checked topic, so they are
+	// not rate limited; registered only when mqtt.auth_hook is enabled
+	if deps.MQTTAuthHookHandler != nil {
+		hookGroup := router.Group("/api/v1/mqtt")
+		{
+			hookGroup.POST("/auth", deps.MQTTAuthHookHandler.Authenticate)
+			hookGroup.POST("/acl", deps.MQTTAuthHookHandler.Authorize)
+		}
+	}
+
 	v1 := router.Group("/api/v1", Cors(), MiddleWares.NewRateLimiter(15, 60).RateLimitMiddleware())
 
 	v1.GET("/test", func(c *gin.Context) {
@@ -53,165 +97,304 @@ func RegRoutes(router *gin.Engine, userHandler *UserHandler, deviceHandler *Devi
 
 	userGroup := v1.Group("/users")
 	{
-		userGroup.POST("/challenge", userHandler.Challenge)
-		userGroup.POST("/register", userHandler.Register)
-		userGroup.POST("/login", userHandler.Login)
+		userGroup.POST("/challenge", deps.UserHandler.Challenge)
+		userGroup.POST("/register", deps.UserHandler.Register)
+		userGroup.POST("/login", deps.UserHandler.Login)
 
 		userProtected := userGroup.Group("")
-		userProtected.Use(jwtAuth.JwtAuthMiddleWare())
+		userProtected.Use(deps.JWTAuth.JwtAuthMiddleWare())
 		{
-			userProtected.POST("/logout", userHandler.Logout)
-			userProtected.GET("/getUserAllDevices", userHandler.GetUserAllDevices)
-			userProtected.GET("/info", userHandler.GetUserInfo)
-			userProtected.PUT("/profile", userHandler.UpdateProfile)
-			userProtected.POST("/avatar", userHandler.UploadAvatar)
-			userProtected.DELETE("/avatar", userHandler.ResetAvatar)
-			userProtected.POST("/addDevice", deviceHandler.AddDevice)
-			userProtected.POST("/bindDeviceByRegCode", userHandler.BindDeviceByRegCode)
+			userProtected.POST("/logout", deps.UserHandler.Logout)
+			userProtected.GET("/getUserAllDevices", deps.UserHandler.GetUserAllDevices)
+			userProtected.GET("/info", deps.UserHandler.GetUserInfo)
+			userProtected.PUT("/profile", deps.UserHandler.UpdateProfile)
+			userProtected.POST("/avatar", deps.UserHandler.UploadAvatar)
+			userProtected.DELETE("/avatar", deps.UserHandler.ResetAvatar)
+			userProtected.POST("/addDevice", deps.DeviceHandler.AddDevice)
+			userProtected.POST("/bindDeviceByRegCode", deps.UserHandler.BindDeviceByRegCode)
 		}
 	}
 
 	protected := v1.Group("/")
-	protected.Use(jwtAuth.JwtAuthMiddleWare())
+	protected.Use(deps.JWTAuth.JwtAuthMiddleWare())
 	{
-		protected.POST("/devices/:instance_uuid/getHistoryData", MiddleWares.DeviceAccessMiddleware(*deviceShareService, "read"), GetDeviceHistoryHandlerFactory(deviceService))
-		protected.POST("/devices/:instance_uuid/actions", MiddleWares.DeviceAccessMiddleware(*deviceShareService, "write"), SendActionHandlerFactory(mqttService, deviceService))
-		protected.GET("/devices/:instance_uuid/actions", MiddleWares.DeviceAccessMiddleware(*deviceShareService, "read"), GetDeviceActionsHandlerFactory(deviceService))
-		protected.GET("/devices/accessible", GetAccessibleDevicesHandlerFactory(deviceShareService))
-		protected.POST("/devices/:instance_uuid/share", MiddleWares.DeviceAccessMiddleware(*deviceShareService, "write"), ShareDeviceHandlerFactory(deviceShareService))
+		protected.POST("/devices/:instance_uuid/getHistoryData", MiddleWares.DeviceAccessMiddleware(*deps.DeviceShareService, "read"), GetDeviceHistoryHandlerFactory(deps.DeviceService))
+		protected.GET("/devices/:instance_uuid/telemetry", MiddleWares.DeviceAccessMiddleware(*deps.DeviceShareService, "read"), GetDeviceTelemetryHandlerFactory(deps.DeviceService))
+		protected.POST("/devices/:instance_uuid/actions", MiddleWares.DeviceAccessMiddleware(*deps.DeviceShareService, "write"), SendActionHandlerFactory(deps.ActionService))
+		protected.GET("/devices/:instance_uuid/actions", MiddleWares.DeviceAccessMiddleware(*deps.DeviceShareService, "read"), GetDeviceActionsHandlerFactory(deps.DeviceService))
+		protected.GET("/devices/:instance_uuid/actions/history", MiddleWares.DeviceAccessMiddleware(*deps.DeviceShareService, "read"), GetActionHistoryHandlerFactory(deps.ActionService))
+		protected.GET("/devices/:instance_uuid/events", MiddleWares.DeviceAccessMiddleware(*deps.DeviceShareService, "read"), deps.DeviceEventHandler.ListEvents)
+		protected.GET("/devices/:instance_uuid/events/quarantine", MiddleWares.DeviceAccessMiddleware(*deps.DeviceShareService, "read"), deps.DeviceEventHandler.ListDeviceQuarantine)
+		protected.GET("/devices/:instance_uuid/shadow", MiddleWares.DeviceAccessMiddleware(*deps.DeviceShareService, "read"), GetShadowHandlerFactory(deps.ShadowService))
+		protected.PUT("/devices/:instance_uuid/shadow/desired", MiddleWares.DeviceAccessMiddleware(*deps.DeviceShareService, "write"), SetDesiredShadowHandlerFactory(deps.ShadowService))
+		protected.GET("/devices/accessible", GetAccessibleDevicesHandlerFactory(deps.DeviceShareService))
+		protected.POST("/devices/:instance_uuid/share", MiddleWares.DeviceAccessMiddleware(*deps.DeviceShareService, "write"), ShareDeviceHandlerFactory(deps.DeviceShareService))
+		// Credentials may only be changed by the owner, which the service checks
+		protected.POST("/devices/:instance_uuid/credentials/rotate", deps.DeviceCredentialHandler.RotateCredentials)
+		protected.POST("/devices/:instance_uuid/credentials/reset", deps.DeviceCredentialHandler.ResetCredentials)
 
 		// Device Folder routes (organizational grouping of devices)
-		protected.POST("/devices/folders", deviceFolderHandler.CreateFolder)
-		protected.POST("/devices/:instance_uuid/folders", deviceFolderHandler.AddDeviceToFolder)
-		protected.DELETE("/devices/:instance_uuid/folders/:folder_uuid", deviceFolderHandler.RemoveDeviceFromFolder)
-		protected.GET("/devices/folders/:folder_uuid/devices", deviceFolderHandler.GetFolderDevices)
-		protected.DELETE("/devices/folders/:folder_uuid", deviceFolderHandler.DeleteFolder)
+		protected.POST("/devices/folders", deps.DeviceFolderHandler.CreateFolder)
+		protected.POST("/devices/:instance_uuid/folders", deps.DeviceFolderHandler.AddDeviceToFolder)
+		protected.DELETE("/devices/:instance_uuid/folders/:folder_uuid", deps.DeviceFolderHandler.RemoveDeviceFromFolder)
+		protected.GET("/devices/folders/:folder_uuid/devices", deps.DeviceFolderHandler.GetFolderDevices)
+		protected.DELETE("/devices/folders/:folder_uuid", deps.DeviceFolderHandler.DeleteFolder)
 	}
 
 	usersMe := v1.Group("/users/me")
-	usersMe.Use(jwtAuth.JwtAuthMiddleWare())
+	usersMe.Use(deps.JWTAuth.JwtAuthMiddleWare())
 	{
-		usersMe.GET("/device_folders", deviceFolderHandler.GetFolders)
+		usersMe.GET("/device_folders", deps.DeviceFolderHandler.GetFolders)
 	}
 
-	deviceGroup := v1.Group("/device")
-	{
-		deviceGroup.POST("/deviceRegisterAnon", deviceHandler.DeviceRegisterAnonymously)
+	// Device certificates, registered only when the built-in CA (pki) is enabled
+	if deps.DeviceCertificateHandler != nil {
+		pkiGroup := v1.Group("/pki")
+		{
+			pkiGroup.GET("/ca.pem", deps.DeviceCertificateHandler.CACertificate)
+			pkiGroup.GET("/crl.pem", deps.DeviceCertificateHandler.CRL)
+		}
+
+		certGroup := v1.Group("/devices/:instance_uuid/certificates")
+		certGroup.Use(deps.JWTAuth.JwtAuthMiddleWare())
+		{
+			certGroup.GET("", MiddleWares.DeviceAccessMiddleware(*deps.DeviceShareService, "read"), deps.DeviceCertificateHandler.ListCertificates)
+			certGroup.POST("", MiddleWares.DeviceAccessMiddleware(*deps.DeviceShareService, "write"), deps.DeviceCertificateHandler.IssueCertificate)
+			certGroup.DELETE("/:serial", MiddleWares.DeviceAccessMiddleware(*deps.DeviceShareService, "write"), deps.DeviceCertificateHandler.RevokeCertificate)
+		}
 	}
 
+	regDeviceRoutes(v1, deps.DeviceHandler, deps.DeviceCredentialHandler, deps.DeviceCertificateHandler, deps.RequireDeviceCert)
+
 	// Public Instance routes (no auth required for listing)
 	publicGroup := v1.Group("/public")
 	{
-		publicGroup.GET("/instances", GetPublicInstancesHandlerFactory(publicInstanceService))
-		publicGroup.GET("/instances/:instance_uuid", GetPublicInstanceDetailHandlerFactory(publicInstanceService))
+		publicGroup.GET("/instances", GetPublicInstancesHandlerFactory(deps.PublicInstanceService))
+		publicGroup.GET("/instances/:instance_uuid", GetPublicInstanceDetailHandlerFactory(deps.PublicInstanceService))
 	}
 
 	// User favorites (JWT required)
 	favGroup := v1.Group("/users/me/favorites")
-	favGroup.Use(jwtAuth.JwtAuthMiddleWare())
+	favGroup.Use(deps.JWTAuth.JwtAuthMiddleWare())
+	{
+		favGroup.GET("", GetFavoritesHandlerFactory(deps.PublicInstanceService))
+		favGroup.POST("/:instance_uuid", AddFavoriteHandlerFactory(deps.PublicInstanceService))
+		favGroup.DELETE("/:instance_uuid", RemoveFavoriteHandlerFactory(deps.PublicInstanceService))
+	}
+
+	// Alert rules (property threshold alerts)
+	alertGroup := v1.Group("/alerts/rules")
+	alertGroup.Use(deps.JWTAuth.JwtAuthMiddleWare())
 	{
-		favGroup.GET("", GetFavoritesHandlerFactory(publicInstanceService))
-		favGroup.POST("/:instance_uuid", AddFavoriteHandlerFactory(publicInstanceService))
-		favGroup.DELETE("/:instance_uuid", RemoveFavoriteHandlerFactory(publicInstanceService))
+		alertGroup.POST("", deps.AlertRuleHandler.CreateRule)
+		alertGroup.GET("", deps.AlertRuleHandler.ListRules)
+		alertGroup.GET("/:rule_uuid", deps.AlertRuleHandler.GetRule)
+		alertGroup.PUT("/:rule_uuid", deps.AlertRuleHandler.UpdateRule)
+		alertGroup.DELETE("/:rule_uuid", deps.AlertRuleHandler.DeleteRule)
+		alertGroup.PUT("/:rule_uuid/enabled", deps.AlertRuleHandler.SetEnabled)
+		alertGroup.PUT("/:rule_uuid/mute", deps.AlertRuleHandler.Mute)
+		alertGroup.DELETE("/:rule_uuid/mute", deps.AlertRuleHandler.Unmute)
+	}
+
+	// Outbound webhooks (user scope)
+	webhookGroup := v1.Group("/webhooks")
+	webhookGroup.Use(deps.JWTAuth.JwtAuthMiddleWare())
+	{
+		webhookGroup.GET("/event-types", deps.WebhookHandler.ListEventTypes)
+		webhookGroup.POST("", deps.WebhookHandler.CreateWebhook)
+		webhookGroup.GET("", deps.WebhookHandler.ListWebhooks)
+		webhookGroup.GET("/:webhook_uuid", deps.WebhookHandler.GetWebhook)
+		webhookGroup.PUT("/:webhook_uuid", deps.WebhookHandler.UpdateWebhook)
+		webhookGroup.DELETE("/:webhook_uuid", deps.WebhookHandler.DeleteWebhook)
+		webhookGroup.PUT("/:webhook_uuid/enabled", deps.WebhookHandler.SetEnabled)
+		webhookGroup.POST("/:webhook_uuid/secret", deps.WebhookHandler.RotateSecret)
+		webhookGroup.POST("/:webhook_uuid/ping", deps.WebhookHandler.Ping)
+		webhookGroup.GET("/:webhook_uuid/deliveries", deps.WebhookHandler.ListDeliveries)
+	}
+
+	// Telemetry export (device, folder or group share)
+	exportGroup := v1.Group("/telemetry")
+	exportGroup.Use(deps.JWTAuth.JwtAuthMiddleWare())
+	{
+		exportGroup.GET("/export", deps.TelemetryExportHandler.Export)
+		exportGroup.POST("/exports", deps.TelemetryExportHandler.CreateJob)
+		exportGroup.GET("/exports", deps.TelemetryExportHandler.ListJobs)
+		exportGroup.GET("/exports/:job_uuid", deps.TelemetryExportHandler.GetJob)
+		exportGroup.GET("/exports/:job_uuid/download", deps.TelemetryExportHandler.DownloadJob)
+		exportGroup.DELETE("/exports/:job_uuid", deps.TelemetryExportHandler.DeleteJob)
 	}
 
 	// WebSocket push channel
 	wsGroup := v1.Group("/ws")
-	wsGroup.Use(jwtAuth.JwtAuthMiddleWare())
+	wsGroup.Use(deps.JWTAuth.JwtAuthMiddleWare())
 	{
-		wsGroup.GET("", pushHandler.HandleWebSocket)
+		wsGroup.GET("", deps.PushHandler.HandleWebSocket)
+	}
+
+	// Inbox of warning/critical device events pushed over WebSocket
+	inboxGroup := v1.Group("/users/me/inbox")
+	inboxGroup.Use(deps.JWTAuth.JwtAuthMiddleWare())
+	{
+		inboxGroup.GET("", deps.PushHandler.ListInbox)
+		inboxGroup.POST("/read", deps.PushHandler.MarkInboxRead)
 	}
 
 	// User Group routes
 	groupRoutes := v1.Group("/groups")
-	groupRoutes.Use(jwtAuth.JwtAuthMiddleWare())
+	groupRoutes.Use(deps.JWTAuth.JwtAuthMiddleWare())
 	{
 		// Group CRUD
-		groupRoutes.POST("", userGroupHandler.CreateGroup)
-		groupRoutes.GET("", userGroupHandler.GetMyGroups)
-		groupRoutes.GET("/:group_uuid", userGroupHandler.GetGroup)
-		groupRoutes.PUT("/:group_uuid", userGroupHandler.UpdateGroup)
-		groupRoutes.DELETE("/:group_uuid", userGroupHandler.DissolveGroup)
+		groupRoutes.POST("", deps.UserGroupHandler.CreateGroup)
+		groupRoutes.GET("", deps.UserGroupHandler.GetMyGroups)
+		groupRoutes.GET("/:group_uuid", deps.UserGroupHandler.GetGroup)
+		groupRoutes.PUT("/:group_uuid", deps.UserGroupHandler.UpdateGroup)
+		groupRoutes.DELETE("/:group_uuid", deps.UserGroupHandler.DissolveGroup)
 
 		// Member management
-		groupRoutes.GET("/:group_uuid/members", userGroupHandler.GetMembers)
-		groupRoutes.POST("/:group_uuid/invite/search", userGroupHandler.SearchInvite)
-		groupRoutes.POST("/:group_uuid/invite/link", userGroupHandler.CreateLinkInvite)
-		groupRoutes.POST("/:group_uuid/members/:user_uuid/approve", userGroupHandler.ApproveMember)
-		groupRoutes.POST("/:group_uuid/members/:user_uuid/reject", userGroupHandler.RejectMember)
-		groupRoutes.DELETE("/:group_uuid/members/:user_uuid", userGroupHandler.RemoveMember)
-		groupRoutes.POST("/:group_uuid/leave", userGroupHandler.LeaveGroup)
-		groupRoutes.PUT("/:group_uuid/members/:user_uuid/role", userGroupHandler.UpdateMemberRole)
+		groupRoutes.GET("/:group_uuid/members", deps.UserGroupHandler.GetMembers)
+		groupRoutes.POST("/:group_uuid/invite/search", deps.UserGroupHandler.SearchInvite)
+		groupRoutes.POST("/:group_uuid/invite/link", deps.UserGroupHandler.CreateLinkInvite)
+		groupRoutes.POST("/:group_uuid/members/:user_uuid/approve", deps.UserGroupHandler.ApproveMember)
+		groupRoutes.POST("/:group_uuid/members/:user_uuid/reject", deps.UserGroupHandler.RejectMember)
+		groupRoutes.DELETE("/:group_uuid/members/:user_uuid", deps.UserGroupHandler.RemoveMember)
+		groupRoutes.POST("/:group_uuid/leave", deps.UserGroupHandler.LeaveGroup)
+		groupRoutes.PUT("/:group_uuid/members/:user_uuid/role", deps.UserGroupHandler.UpdateMemberRole)
 
 		// Device management
-		groupRoutes.GET("/:group_uuid/devices", userGroupHandler.GetGroupDevices)
-		groupRoutes.POST("/:group_uuid/devices/share", userGroupHandler.ShareDeviceToGroup)
-		groupRoutes.DELETE("/:group_uuid/devices/:instance_uuid", userGroupHandler.RevokeGroupDeviceShare)
+		groupRoutes.GET("/:group_uuid/devices", deps.UserGroupHandler.GetGroupDevices)
+		groupRoutes.POST("/:group_uuid/devices/share", deps.UserGroupHandler.ShareDeviceToGroup)
+		groupRoutes.DELETE("/:group_uuid/devices/:instance_uuid", deps.UserGroupHandler.RevokeGroupDeviceShare)
 
 		// Policy management
-		groupRoutes.GET("/:group_uuid/policy", userGroupHandler.GetPolicy)
-		groupRoutes.PUT("/:group_uuid/policy", userGroupHandler.UpdatePolicy)
+		groupRoutes.GET("/:group_uuid/policy", deps.UserGroupHandler.GetPolicy)
+		groupRoutes.PUT("/:group_uuid/policy", deps.UserGroupHandler.UpdatePolicy)
 
 		// Invites
-		groupRoutes.GET("/:group_uuid/invites", userGroupHandler.GetPendingInvites)
+		groupRoutes.GET("/:group_uuid/invites", deps.UserGroupHandler.GetPendingInvites)
 	}
 	// Accept invite (no group_uuid in path)
-	groupRoutes.POST("/invite/:invite_code/accept", userGroupHandler.AcceptInvite)
+	groupRoutes.POST("/invite/:invite_code/accept", deps.UserGroupHandler.AcceptInvite)
 
 	// Admin routes
 	adminGroup := v1.Group("/admin")
 	{
 		// Public: admin challenge and login
-		adminGroup.POST("/challenge", adminHandler.Challenge)
-		adminGroup.POST("/login", adminHandler.Login)
+		adminGroup.POST("/challenge", deps.AdminHandler.Challenge)
+		adminGroup.POST("/login", deps.AdminHandler.Login)
 
 		// Protected: all admin endpoints require JWT + admin role
 		adminProtected := adminGroup.Group("")
-		adminProtected.Use(jwtAuth.JwtAuthMiddleWare(), MiddleWares.AdminAuthMiddleware())
+		adminProtected.Use(deps.JWTAuth.JwtAuthMiddleWare(), MiddleWares.AdminAuthMiddleware())
 		{
-			adminProtected.POST("/logout", adminHandler.Logout)
+			adminProtected.POST("/logout", deps.AdminHandler.Logout)
 
 			// Admin management (super_admin only)
-			adminProtected.GET("/admins", MiddleWares.RequirePermission(model.PermAdminView), adminHandler.GetAdmins)
-			adminProtected.POST("/admins", MiddleWares.RequirePermission(model.PermAdminManage), adminHandler.PromoteUser)
-			adminProtected.PUT("/admins/:user_uuid", MiddleWares.RequirePermission(model.PermAdminManage), adminHandler.UpdateAdminRole)
-			adminProtected.DELETE("/admins/:user_uuid", MiddleWares.RequirePermission(model.PermAdminManage), adminHandler.DemoteAdmin)
+			adminProtected.GET("/admins", MiddleWares.RequirePermission(model.PermAdminView), deps.AdminHandler.GetAdmins)
+			adminProtected.POST("/admins", MiddleWares.RequirePermission(model.PermAdminManage), deps.AdminHandler.PromoteUser)
+			adminProtected.PUT("/admins/:user_uuid", MiddleWares.RequirePermission(model.PermAdminManage), deps.AdminHandler.UpdateAdminRole)
+			adminProtected.DELETE("/admins/:user_uuid", MiddleWares.RequirePermission(model.PermAdminManage), deps.AdminHandler.DemoteAdmin)
 
 			// User management
-			adminProtected.GET("/users", MiddleWares.RequirePermission(model.PermUserView), adminHandler.ListUsers)
-			adminProtected.GET("/users/:user_uuid", MiddleWares.RequirePermission(model.PermUserView), adminHandler.GetUser)
-			adminProtected.PUT("/users/:user_uuid", MiddleWares.RequirePermission(model.PermUserEdit), adminHandler.EditUser)
-			adminProtected.PUT("/users/:user_uuid/status", MiddleWares.RequirePermission(model.PermUserStatus), adminHandler.UpdateUserStatus)
-			adminProtected.DELETE("/users/:user_uuid", MiddleWares.RequirePermission(model.PermUserDelete), adminHandler.DeleteUser)
-			adminProtected.POST("/users/:user_uuid/reset-password", MiddleWares.RequirePermission(model.PermUserReset), adminHandler.ResetPassword)
+			adminProtected.GET("/users", MiddleWares.RequirePermission(model.PermUserView), deps.AdminHandler.ListUsers)
+			adminProtected.GET("/users/:user_uuid", MiddleWares.RequirePermission(model.PermUserView), deps.AdminHandler.GetUser)
+			adminProtected.PUT("/users/:user_uuid", MiddleWares.RequirePermission(model.PermUserEdit), deps.AdminHandler.EditUser)
+			adminProtected.PUT("/users/:user_uuid/status", MiddleWares.RequirePermission(model.PermUserStatus), deps.AdminHandler.UpdateUserStatus)
+			adminProtected.DELETE("/users/:user_uuid", MiddleWares.RequirePermission(model.PermUserDelete), deps.AdminHandler.DeleteUser)
+			adminProtected.POST("/users/:user_uuid/reset-password", MiddleWares.RequirePermission(model.PermUserReset), deps.AdminHandler.ResetPassword)
 
 			// Device management
-			adminProtected.GET("/devices", MiddleWares.RequirePermission(model.PermDeviceView), adminHandler.ListDevices)
-			adminProtected.GET("/devices/:instance_uuid", MiddleWares.RequirePermission(model.PermDeviceView), adminHandler.GetDevice)
-			adminProtected.PUT("/devices/:instance_uuid", MiddleWares.RequirePermission(model.PermDeviceEdit), adminHandler.EditDevice)
-			adminProtected.DELETE("/devices/:instance_uuid", MiddleWares.RequirePermission(model.PermDeviceDelete), adminHandler.DeleteDevice)
-			adminProtected.POST("/devices/:instance_uuid/transfer", MiddleWares.RequirePermission(model.PermDeviceTransfer), adminHandler.TransferDevice)
-			adminProtected.PUT("/devices/:instance_uuid/public", MiddleWares.RequirePermission(model.PermDeviceEdit), TogglePublicHandlerFactory(publicInstanceService))
+			adminProtected.GET("/devices", MiddleWares.RequirePermission(model.PermDeviceView), deps.AdminHandler.ListDevices)
+			adminProtected.GET("/devices/:instance_uuid", MiddleWares.RequirePermission(model.PermDeviceView), deps.AdminHandler.GetDevice)
+			adminProtected.PUT("/devices/:instance_uuid", MiddleWares.RequirePermission(model.PermDeviceEdit), deps.AdminHandler.EditDevice)
+			adminProtected.DELETE("/devices/:instance_uuid", MiddleWares.RequirePermission(model.PermDeviceDelete), deps.AdminHandler.DeleteDevice)
+			adminProtected.POST("/devices/:instance_uuid/transfer", MiddleWares.RequirePermission(model.PermDeviceTransfer), deps.AdminHandler.TransferDevice)
+			adminProtected.PUT("/devices/:instance_uuid/public", MiddleWares.RequirePermission(model.PermDeviceEdit), TogglePublicHandlerFactory(deps.PublicInstanceService))
+			adminProtected.POST("/devices/:instance_uuid/credentials/rotate", MiddleWares.RequirePermission(model.PermDeviceEdit), deps.DeviceCredentialHandler.AdminRotateCredentials)
+			adminProtected.POST("/devices/:instance_uuid/credentials/reset", MiddleWares.RequirePermission(model.PermDeviceEdit), deps.DeviceCredentialHandler.AdminResetCredentials)
+			adminProtected.GET("/events/quarantine", MiddleWares.RequirePermission(model.PermDeviceView), deps.DeviceEventHandler.ListQuarantine)
 
 			// Group management
-			adminProtected.GET("/groups", MiddleWares.RequirePermission(model.PermGroupView), adminHandler.ListGroups)
-			adminProtected.GET("/groups/:group_uuid", MiddleWares.RequirePermission(model.PermGroupView), adminHandler.GetGroup)
-			adminProtected.GET("/groups/:group_uuid/members", MiddleWares.RequirePermission(model.PermGroupView), adminHandler.GetGroupMembers)
-			adminProtected.DELETE("/groups/:group_uuid", MiddleWares.RequirePermission(model.PermGroupManage), adminHandler.DissolveGroup)
-			adminProtected.DELETE("/groups/:group_uuid/members/:user_uuid", MiddleWares.RequirePermission(model.PermGroupManage), adminHandler.RemoveGroupMember)
+			adminProtected.GET("/groups", MiddleWares.RequirePermission(model.PermGroupView), deps.AdminHandler.ListGroups)
+			adminProtected.GET("/groups/:group_uuid", MiddleWares.RequirePermission(model.PermGroupView), deps.AdminHandler.GetGroup)
+			adminProtected.GET("/groups/:group_uuid/members", MiddleWares.RequirePermission(model.PermGroupView), deps.AdminHandler.GetGroupMembers)
+			adminProtected.DELETE("/groups/:group_uuid", MiddleWares.RequirePermission(model.PermGroupManage), deps.AdminHandler.DissolveGroup)
+			adminProtected.DELETE("/groups/:group_uuid/members/:user_uuid", MiddleWares.RequirePermission(model.PermGroupManage), deps.AdminHandler.RemoveGroupMember)
 
 			// System
-			adminProtected.GET("/stats/overview", MiddleWares.RequirePermission(model.PermSystemStats), adminHandler.GetStats)
-			adminProtected.GET("/logs", MiddleWares.RequirePermission(model.PermSystemLogs), adminHandler.GetLogs)
+			adminProtected.GET("/stats/overview", MiddleWares.RequirePermission(model.PermSystemStats), deps.AdminHandler.GetStats)
+			adminProtected.GET("/stats/ingestion", MiddleWares.RequirePermission(model.PermSystemStats), deps.AdminHandler.GetIngestStats)
+			adminProtected.GET("/logs", MiddleWares.RequirePermission(model.PermSystemLogs), deps.AdminHandler.GetLogs)
+
+			// Global webhooks (receive events of all users)
+			adminProtected.GET("/webhooks", MiddleWares.RequirePermission(model.PermSystemWebhooks), deps.AdminWebhookHandler.ListWebhooks)
+			adminProtected.POST("/webhooks", MiddleWares.RequirePermission(model.PermSystemWebhooks), deps.AdminWebhookHandler.CreateWebhook)
+			adminProtected.GET("/webhooks/:webhook_uuid", MiddleWares.RequirePermission(model.PermSystemWebhooks), deps.AdminWebhookHandler.GetWebhook)
+			adminProtected.PUT("/webhooks/:webhook_uuid", MiddleWares.RequirePermission(model.PermSystemWebhooks), deps.AdminWebhookHandler.UpdateWebhook)
+			adminProtected.DELETE("/webhooks/:webhook_uuid", MiddleWares.RequirePermission(model.PermSystemWebhooks), deps.AdminWebhookHandler.DeleteWebhook)
+			adminProtected.PUT("/webhooks/:webhook_uuid/enabled", MiddleWares.RequirePermission(model.PermSystemWebhooks), deps.AdminWebhookHandler.SetEnabled)
+			adminProtected.POST("/webhooks/:webhook_uuid/secret", MiddleWares.RequirePermission(model.PermSystemWebhooks), deps.AdminWebhookHandler.RotateSecret)
+			adminProtected.POST("/webhooks/:webhook_uuid/ping", MiddleWares.RequirePermission(model.PermSystemWebhooks), deps.AdminWebhookHandler.Ping)
+			adminProtected.GET("/webhooks/:webhook_uuid/deliveries", MiddleWares.RequirePermission(model.PermSystemWebhooks), deps.AdminWebhookHandler.ListDeliveries)
+
+			// Device type definitions
+			adminProtected.GET("/device-types", MiddleWares.RequirePermission(model.PermSystemDeviceTypes), deps.DeviceTypeHandler.ListDeviceTypes)
+			adminProtected.POST("/device-types/reload", MiddleWares.RequirePermission(model.PermSystemDeviceTypes), deps.DeviceTypeHandler.ReloadDeviceTypes)
+			adminProtected.POST("/device-types", MiddleWares.RequirePermission(model.PermSystemDeviceTypes), deps.DeviceTypeHandler.CreateDeviceType)
+			adminProtected.GET("/device-types/export", MiddleWares.RequirePermission(model.PermSystemDeviceTypes), deps.DeviceTypeHandler.ExportDeviceTypes)
+			adminProtected.POST("/device-types/import", MiddleWares.RequirePermission(model.PermSystemDeviceTypes), deps.DeviceTypeHandler.ImportDeviceTypes)
+			adminProtected.GET("/device-types/:type_name", MiddleWares.RequirePermission(model.PermSystemDeviceTypes), deps.DeviceTypeHandler.GetDeviceType)
+			adminProtected.PUT("/device-types/:type_name", MiddleWares.RequirePermission(model.PermSystemDeviceTypes), deps.DeviceTypeHandler.UpdateDeviceType)
+			adminProtected.POST("/device-types/:type_name/clone", MiddleWares.RequirePermission(model.PermSystemDeviceTypes), deps.DeviceTypeHandler.CloneDeviceType)
+			adminProtected.PUT("/device-types/:type_name/deprecated", MiddleWares.RequirePermission(model.PermSystemDeviceTypes), deps.DeviceTypeHandler.SetDeviceTypeDeprecated)
+			adminProtected.POST("/device-types/:type_name/migrate", MiddleWares.RequirePermission(model.PermSystemDeviceTypes), deps.DeviceTypeHandler.MigrateDeviceType)
+
+			// Data retention
+			adminProtected.GET("/retention/policies", MiddleWares.RequirePermission(model.PermSystemRetention), deps.RetentionHandler.GetPolicies)
+			adminProtected.PUT("/retention/overrides", MiddleWares.RequirePermission(model.PermSystemRetention), deps.RetentionHandler.SetOverride)
+			adminProtected.DELETE("/retention/overrides/:id", MiddleWares.RequirePermission(model.PermSystemRetention), deps.RetentionHandler.DeleteOverride)
+			adminProtected.GET("/retention/projection", MiddleWares.RequirePermission(model.PermSystemRetention), deps.RetentionHandler.GetProjection)
+			adminProtected.GET("/retention/run", MiddleWares.RequirePermission(model.PermSystemRetention), deps.RetentionHandler.GetLastRun)
+			adminProtected.POST("/retention/run", MiddleWares.RequirePermission(model.PermSystemRetention), deps.RetentionHandler.TriggerRun)
+
+			// Event bus
+			adminProtected.GET("/event-bus/subscriptions", MiddleWares.RequirePermission(model.PermSystemEvents), deps.EventBusHandler.ListSubscriptions)
+			adminProtected.GET("/event-bus/dead-letters", MiddleWares.RequirePermission(model.PermSystemEvents), deps.EventBusHandler.ListDeadLetters)
+			adminProtected.GET("/event-bus/dead-letters/:id", MiddleWares.RequirePermission(model.PermSystemEvents), deps.EventBusHandler.GetDeadLetter)
+			adminProtected.POST("/event-bus/dead-letters/:id/replay", MiddleWares.RequirePermission(model.PermSystemEvents), deps.EventBusHandler.ReplayDeadLetter)
+			adminProtected.DELETE("/event-bus/dead-letters/:id", MiddleWares.RequirePermission(model.PermSystemEvents), deps.EventBusHandler.DeleteDeadLetter)
 		}
 	}
 
 	logGroup := v1.Group("/logs")
-	logGroup.Use(jwtAuth.JwtAuthMiddleWare())
+	logGroup.Use(deps.JWTAuth.JwtAuthMiddleWare())
 	{
-		logGroup.GET("/device", logHandler.QueryDeviceLogs)
-		logGroup.POST("/device/upload", logHandler.UploadDeviceLog)
-		logGroup.GET("/user", logHandler.QueryUserLogs)
+		logGroup.GET("/device", deps.LogHandler.QueryDeviceLogs)
+		logGroup.POST("/device/upload", deps.LogHandler.UploadDeviceLog)
+		logGroup.GET("/user", deps.LogHandler.QueryUserLogs)
 	}
 
 }
+
+// regDeviceRoutes registers the routes called by devices themselves. With requireDeviceCert
+// (server.require_client_cert_for_devices) they all need a valid client certificate of the built-in
+// CA, so anonymous registration is closed and devices are added by users and issued a certificate
+// beforehand; otherwise only certificate rotation checks it. Re-provisioning is authenticated by
+// the one-time reset code: the hard reset revoked every certificate of the device.
+func regDeviceRoutes(v1 *gin.RouterGroup, deviceHandler *DeviceHandler, deviceCredentialHandler *DeviceCredentialHandler, deviceCertificateHandler *DeviceCertificateHandler, requireDeviceCert bool) {
+	v1.POST("/device/reprovision", deviceCredentialHandler.Reprovision)
+
+	deviceGroup := v1.Group("/device")
+	if requireDeviceCert {
+		deviceGroup.Use(MiddleWares.DeviceCertificateAuth(deviceCertificateHandler.certService))
+	}
+	deviceGroup.POST("/deviceRegisterAnon", deviceHandler.DeviceRegisterAnonymously)
+	if deviceCertificateHandler == nil {
+		return
+	}
+	// Authenticated by the device's client certificate (mTLS)
+	if requireDeviceCert {
+		deviceGroup.POST("/certificate/rotate", deviceCertificateHandler.RotateCertificate)
+	} else {
+		deviceGroup.POST("/certificate/rotate", MiddleWares.DeviceCertificateAuth(deviceCertificateHandler.certService), deviceCertificateHandler.RotateCertificate)
+	}
+}
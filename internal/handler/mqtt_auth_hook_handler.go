@@ -0,0 +1,85 @@
+package handler
+
+import (
+	"OMEGA3-IOT/internal/service"
+	"crypto/subtle"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// MQTTAuthHookHandler serves the HTTP authentication and ACL hooks of an external MQTT broker,
+// such as the EMQX HTTP authenticator and authorizer. Responses use the {"result": ...} body
+// brokers expect rather than the API envelope; denials also use a 4xx status for brokers that
+// only look at the status code.
+type MQTTAuthHookHandler struct {
+	hook   *service.MQTTAuthHook
+	secret string
+}
+
+// NewMQTTAuthHookHandler creates a new MQTTAuthHookHandler. Requests must carry the secret as a
+// bearer token.
+func NewMQTTAuthHookHandler(hook *service.MQTTAuthHook, secret string) *MQTTAuthHookHandler {
+	return &MQTTAuthHookHandler{hook: hook, secret: secret}
+}
+
+// Authenticate handles POST /mqtt/auth
+func (h *MQTTAuthHookHandler) Authenticate(c *gin.Context) {
+	if !h.authorized(c) {
+		return
+	}
+	var input struct {
+		ClientID       string `json:"clientid"`
+		Username       string `json:"username"`
+		Password       string `json:"password"`
+		CertCommonName string `json:"cert_common_name"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"result": "deny"})
+		return
+	}
+
+	principal, err := h.hook.Authenticate(input.ClientID, input.Username, input.Password, input.CertCommonName)
+	if err != nil {
+		log.Printf("[MQTTAuthHook] Refused client '%s' (user '%s'): %v", input.ClientID, input.Username, err)
+		c.JSON(http.StatusForbidden, gin.H{"result": "deny"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"result": "allow", "is_superuser": principal.Superuser})
+}
+
+// Authorize handles POST /mqtt/acl
+func (h *MQTTAuthHookHandler) Authorize(c *gin.Context) {
+	if !h.authorized(c) {
+		return
+	}
+	var input struct {
+		ClientID       string `json:"clientid"`
+		Username       string `json:"username"`
+		CertCommonName string `json:"cert_common_name"`
+		Topic          string `json:"topic" binding:"required"`
+		Action         string `json:"action" binding:"required,oneof=publish subscribe"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"result": "deny"})
+		return
+	}
+
+	if !h.hook.Authorize(input.Username, input.CertCommonName, input.Topic, input.Action == "subscribe") {
+		log.Printf("[MQTTAuthHook] Client '%s' (user '%s') may not %s '%s'", input.ClientID, input.Username, input.Action, input.Topic)
+		c.JSON(http.StatusForbidden, gin.H{"result": "deny"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"result": "allow"})
+}
+
+func (h *MQTTAuthHookHandler) authorized(c *gin.Context) bool {
+	token := strings.TrimPrefix(c.GetHeader("Authorization"), "Bearer ")
+	if h.secret == "" || subtle.ConstantTimeCompare([]byte(token), []byte(h.secret)) != 1 {
+		c.JSON(http.StatusUnauthorized, gin.H{"result": "deny"})
+		return false
+	}
+	return true
+}
@@ -0,0 +1,132 @@
+package handler
+
+import (
+	"OMEGA3-IOT/internal/model"
+	"OMEGA3-IOT/internal/service"
+	"OMEGA3-IOT/internal/types"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// RetentionHandler handles admin requests for data retention.
+type RetentionHandler struct {
+	retentionService *service.RetentionService
+	adminService     *service.AdminService
+}
+
+// NewRetentionHandler creates a new RetentionHandler.
+func NewRetentionHandler(retentionService *service.RetentionService, adminService *service.AdminService) *RetentionHandler {
+	return &RetentionHandler{retentionService: retentionService, adminService: adminService}
+}
+
+// GetPolicies handles GET /admin/retention/policies
+func (h *RetentionHandler) GetPolicies(c *gin.Context) {
+	policies, err := h.retentionService.Policies()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, types.NewErrorResponse(http.StatusInternalServerError, "Failed to get retention policies", err.Error()))
+		return
+	}
+	overrides, err := h.retentionService.ListOverrides()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, types.NewErrorResponse(http.StatusInternalServerError, "Failed to get retention overrides", err.Error()))
+		return
+	}
+
+	defaultDays, userLogDays, quarantineDays := h.retentionService.DefaultDays()
+	c.JSON(http.StatusOK, types.NewSuccessResponseWithCode(gin.H{
+		"default_days":    defaultDays,
+		"user_log_days":   userLogDays,
+		"quarantine_days": quarantineDays,
+		"policies":        policies,
+		"overrides":       overrides,
+	}, http.StatusOK, "OK"))
+}
+
+// SetOverride handles PUT /admin/retention/overrides
+func (h *RetentionHandler) SetOverride(c *gin.Context) {
+	var input struct {
+		DeviceType    string `json:"device_type" binding:"required"`
+		Scope         string `json:"scope" binding:"required"`
+		Key           string `json:"key"`
+		RetentionDays *int   `json:"retention_days" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, types.NewErrorResponse(http.StatusBadRequest, "Invalid request parameters", err.Error()))
+		return
+	}
+
+	adminUUID := c.GetString("user_uuid")
+	override, err := h.retentionService.SetOverride(adminUUID, model.RetentionOverride{
+		DeviceType:    input.DeviceType,
+		Scope:         input.Scope,
+		Key:           input.Key,
+		RetentionDays: *input.RetentionDays,
+	})
+	if err != nil {
+		respondRetentionError(c, "Failed to set retention override", err)
+		return
+	}
+	h.logChange(c, "retention.override.set", override)
+	c.JSON(http.StatusOK, types.NewSuccessResponseWithCode(override, http.StatusOK, "Retention override saved"))
+}
+
+// DeleteOverride handles DELETE /admin/retention/overrides/:id
+func (h *RetentionHandler) DeleteOverride(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, types.NewErrorResponse(http.StatusBadRequest, "Invalid override ID", err.Error()))
+		return
+	}
+
+	override, err := h.retentionService.DeleteOverride(uint(id))
+	if err != nil {
+		respondRetentionError(c, "Failed to delete retention override", err)
+		return
+	}
+	h.logChange(c, "retention.override.delete", override)
+	c.JSON(http.StatusOK, types.NewSuccessResponseWithCode(gin.H{"id": override.ID}, http.StatusOK, "Retention override deleted"))
+}
+
+// GetProjection handles GET /admin/retention/projection
+func (h *RetentionHandler) GetProjection(c *gin.Context) {
+	projections, err := h.retentionService.Projection()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, types.NewErrorResponse(http.StatusInternalServerError, "Failed to project storage", err.Error()))
+		return
+	}
+	c.JSON(http.StatusOK, types.NewSuccessResponseWithCode(gin.H{"device_types": projections}, http.StatusOK, "OK"))
+}
+
+// GetLastRun handles GET /admin/retention/run
+func (h *RetentionHandler) GetLastRun(c *gin.Context) {
+	c.JSON(http.StatusOK, types.NewSuccessResponseWithCode(gin.H{"last_run": h.retentionService.LastRun()}, http.StatusOK, "OK"))
+}
+
+// TriggerRun handles POST /admin/retention/run
+func (h *RetentionHandler) TriggerRun(c *gin.Context) {
+	h.retentionService.Trigger()
+	h.adminService.LogAction(c.GetString("user_uuid"), "retention.run", "retention", "", "", c.ClientIP())
+	c.JSON(http.StatusAccepted, types.NewSuccessResponseWithCode(nil, http.StatusAccepted, "Retention run queued"))
+}
+
+func (h *RetentionHandler) logChange(c *gin.Context, action string, override *model.RetentionOverride) {
+	detail, _ := json.Marshal(gin.H{"scope": override.Scope, "key": override.Key, "retention_days": override.RetentionDays})
+	h.adminService.LogAction(c.GetString("user_uuid"), action, "device_type", override.DeviceType, string(detail), c.ClientIP())
+}
+
+func respondRetentionError(c *gin.Context, message string, err error) {
+	switch {
+	case errors.Is(err, service.ErrUnknownDeviceType):
+		c.JSON(http.StatusNotFound, types.NewErrorResponse(http.StatusNotFound, "Device type not found", err.Error()))
+	case errors.Is(err, service.ErrRetentionOverrideNotFound):
+		c.JSON(http.StatusNotFound, types.NewErrorResponse(http.StatusNotFound, "Retention override not found", err.Error()))
+	case errors.Is(err, service.ErrInvalidRetentionOverride):
+		c.JSON(http.StatusBadRequest, types.NewErrorResponse(http.StatusBadRequest, "Invalid request parameters", err.Error()))
+	default:
+		c.JSON(http.StatusInternalServerError, types.NewErrorResponse(http.StatusInternalServerError, message, err.Error()))
+	}
+}
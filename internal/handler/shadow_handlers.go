@@ -0,0 +1,77 @@
+package handler
+
+import (
+	"OMEGA3-IOT/internal/service"
+	"OMEGA3-IOT/internal/types"
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// GetShadowHandlerFactory returns a handler that retrieves the shadow of a device,
+// including the delta between desired and reported state.
+func GetShadowHandlerFactory(shadowService *service.ShadowService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		instanceUUID := c.Param("instance_uuid")
+
+		shadow, err := shadowService.GetShadow(instanceUUID)
+		if err != nil {
+			if errors.Is(err, service.ErrDeviceNotFound) {
+				c.JSON(http.StatusNotFound, types.NewErrorResponse(http.StatusNotFound, "Device not found", err.Error()))
+				return
+			}
+			c.JSON(http.StatusInternalServerError, types.NewErrorResponse(http.StatusInternalServerError, "Failed to get shadow", err.Error()))
+			return
+		}
+
+		c.JSON(http.StatusOK, types.NewSuccessResponseWithCode(gin.H{
+			"instance_uuid": shadow.InstanceUUID,
+			"version":       shadow.Version,
+			"desired":       shadow.Desired,
+			"reported":      shadow.Reported,
+			"delta":         shadow.Delta(),
+			"updated_at":    shadow.UpdatedAt,
+		}, http.StatusOK, "OK"))
+	}
+}
+
+// SetDesiredShadowHandlerFactory returns a handler that sets desired values of writable properties.
+func SetDesiredShadowHandlerFactory(shadowService *service.ShadowService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		instanceUUID := c.Param("instance_uuid")
+
+		var input struct {
+			Desired map[string]interface{} `json:"desired" binding:"required"`
+			Version *int64                 `json:"version,omitempty"`
+		}
+		if err := c.ShouldBindJSON(&input); err != nil {
+			c.JSON(http.StatusBadRequest, types.NewErrorResponse(http.StatusBadRequest, "Invalid request body", err.Error()))
+			return
+		}
+
+		shadow, err := shadowService.SetDesired(instanceUUID, input.Desired, input.Version, c.GetString("user_uuid"))
+		if err != nil {
+			switch {
+			case errors.Is(err, service.ErrDeviceNotFound):
+				c.JSON(http.StatusNotFound, types.NewErrorResponse(http.StatusNotFound, "Device not found", err.Error()))
+			case errors.Is(err, service.ErrInvalidShadow):
+				c.JSON(http.StatusBadRequest, types.NewErrorResponse(http.StatusBadRequest, "Invalid desired state", err.Error()))
+			case errors.Is(err, service.ErrShadowVersionConflict):
+				c.JSON(http.StatusConflict, types.NewErrorResponse(http.StatusConflict, "Shadow version conflict", err.Error()))
+			default:
+				c.JSON(http.StatusInternalServerError, types.NewErrorResponse(http.StatusInternalServerError, "Failed to update shadow", err.Error()))
+			}
+			return
+		}
+
+		c.JSON(http.StatusOK, types.NewSuccessResponseWithCode(gin.H{
+			"instance_uuid": shadow.InstanceUUID,
+			"version":       shadow.Version,
+			"desired":       shadow.Desired,
+			"reported":      shadow.Reported,
+			"delta":         shadow.Delta(),
+			"updated_at":    shadow.UpdatedAt,
+		}, http.StatusOK, "Desired state updated"))
+	}
+}
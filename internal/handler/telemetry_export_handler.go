@@ -0,0 +1,161 @@
+package handler
+
+import (
+	"OMEGA3-IOT/internal/export"
+	"OMEGA3-IOT/internal/service"
+	"OMEGA3-IOT/internal/types"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// TelemetryExportHandler handles HTTP requests for telemetry exports.
+type TelemetryExportHandler struct {
+	exportService *service.TelemetryExportService
+}
+
+func NewTelemetryExportHandler(exportService *service.TelemetryExportService) *TelemetryExportHandler {
+	return &TelemetryExportHandler{exportService: exportService}
+}
+
+// Export handles GET /telemetry/export and streams the file directly.
+func (h *TelemetryExportHandler) Export(c *gin.Context) {
+	var input struct {
+		Scope          string `form:"scope" binding:"required,oneof=device folder group"`
+		TargetUUID     string `form:"target_uuid" binding:"required"`
+		StartTimestamp int64  `form:"start_timestamp" binding:"required"`
+		EndTimestamp   int64  `form:"end_timestamp" binding:"required"`
+		Properties     string `form:"properties"`
+		Format         string `form:"format"`
+	}
+	if err := c.ShouldBindQuery(&input); err != nil {
+		c.JSON(http.StatusBadRequest, types.NewErrorResponse(http.StatusBadRequest, "Invalid request parameters", err.Error()))
+		return
+	}
+
+	request := service.TelemetryExportRequest{
+		Scope:      input.Scope,
+		TargetUUID: input.TargetUUID,
+		StartTime:  input.StartTimestamp,
+		EndTime:    input.EndTimestamp,
+		Format:     input.Format,
+	}
+	if input.Properties != "" {
+		request.Properties = strings.Split(input.Properties, ",")
+	}
+
+	prepared, err := h.exportService.PrepareExport(c.GetString("user_uuid"), request)
+	if err != nil {
+		respondTelemetryExportError(c, "Failed to export telemetry", err)
+		return
+	}
+
+	// Once streaming has started the status can no longer change; errors end the response early.
+	c.Header("Content-Type", prepared.ContentType())
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q", prepared.Filename()))
+	c.Status(http.StatusOK)
+	if _, err := prepared.WriteTo(c.Writer); err != nil {
+		log.Printf("[TelemetryExportHandler] Export %s %s aborted: %v", request.Scope, request.TargetUUID, err)
+	}
+}
+
+// CreateJob handles POST /telemetry/exports
+func (h *TelemetryExportHandler) CreateJob(c *gin.Context) {
+	var request service.TelemetryExportRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, types.NewErrorResponse(http.StatusBadRequest, "Invalid request parameters", err.Error()))
+		return
+	}
+
+	job, err := h.exportService.CreateJob(c.GetString("user_uuid"), request)
+	if err != nil {
+		respondTelemetryExportError(c, "Failed to create export job", err)
+		return
+	}
+	c.JSON(http.StatusAccepted, types.NewSuccessResponseWithCode(job, http.StatusAccepted, "Export job created"))
+}
+
+// ListJobs handles GET /telemetry/exports
+func (h *TelemetryExportHandler) ListJobs(c *gin.Context) {
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	pageSize, _ := strconv.Atoi(c.DefaultQuery("page_size", "10"))
+	if page < 1 {
+		page = 1
+	}
+	if pageSize < 1 || pageSize > 100 {
+		pageSize = 10
+	}
+
+	jobs, total, err := h.exportService.ListJobs(c.GetString("user_uuid"), pageSize, (page-1)*pageSize)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, types.NewErrorResponse(http.StatusInternalServerError, "Failed to get export jobs", err.Error()))
+		return
+	}
+
+	c.JSON(http.StatusOK, types.NewSuccessResponseWithCode(gin.H{
+		"jobs":      jobs,
+		"total":     total,
+		"page":      page,
+		"page_size": pageSize,
+	}, http.StatusOK, "OK"))
+}
+
+// GetJob handles GET /telemetry/exports/:job_uuid
+func (h *TelemetryExportHandler) GetJob(c *gin.Context) {
+	job, err := h.exportService.GetJob(c.GetString("user_uuid"), c.Param("job_uuid"))
+	if err != nil {
+		respondTelemetryExportError(c, "Failed to get export job", err)
+		return
+	}
+	c.JSON(http.StatusOK, types.NewSuccessResponseWithCode(job, http.StatusOK, "OK"))
+}
+
+// DownloadJob handles GET /telemetry/exports/:job_uuid/download
+func (h *TelemetryExportHandler) DownloadJob(c *gin.Context) {
+	job, file, err := h.exportService.OpenResult(c.GetString("user_uuid"), c.Param("job_uuid"))
+	if err != nil {
+		respondTelemetryExportError(c, "Failed to download export", err)
+		return
+	}
+	defer file.Close()
+
+	c.DataFromReader(http.StatusOK, job.SizeBytes, export.ContentType(job.Format), file, map[string]string{
+		"Content-Disposition": fmt.Sprintf("attachment; filename=%q", service.JobFilename(job)),
+	})
+}
+
+// DeleteJob handles DELETE /telemetry/exports/:job_uuid
+func (h *TelemetryExportHandler) DeleteJob(c *gin.Context) {
+	jobUUID := c.Param("job_uuid")
+	if err := h.exportService.DeleteJob(c.GetString("user_uuid"), jobUUID); err != nil {
+		respondTelemetryExportError(c, "Failed to delete export job", err)
+		return
+	}
+	c.JSON(http.StatusOK, types.NewSuccessResponseWithCode(gin.H{"job_uuid": jobUUID}, http.StatusOK, "Export job deleted successfully"))
+}
+
+func respondTelemetryExportError(c *gin.Context, message string, err error) {
+	switch {
+	case errors.Is(err, service.ErrExportJobNotFound):
+		c.JSON(http.StatusNotFound, types.NewErrorResponse(http.StatusNotFound, "Export job not found", err.Error()))
+	case errors.Is(err, service.ErrExportTargetNotFound):
+		c.JSON(http.StatusNotFound, types.NewErrorResponse(http.StatusNotFound, "Export target not found", err.Error()))
+	case errors.Is(err, service.ErrInvalidTelemetryQuery):
+		c.JSON(http.StatusBadRequest, types.NewErrorResponse(http.StatusBadRequest, "Invalid request parameters", err.Error()))
+	case errors.Is(err, service.ErrExportRangeTooLarge):
+		c.JSON(http.StatusUnprocessableEntity, types.NewErrorResponse(http.StatusUnprocessableEntity, "Range too large for a direct export", err.Error()))
+	case errors.Is(err, service.ErrTooManyExportJobs):
+		c.JSON(http.StatusTooManyRequests, types.NewErrorResponse(http.StatusTooManyRequests, "Too many export jobs", err.Error()))
+	case errors.Is(err, service.ErrExportNotReady), errors.Is(err, service.ErrExportJobRunning):
+		c.JSON(http.StatusConflict, types.NewErrorResponse(http.StatusConflict, "Export job not finished", err.Error()))
+	case errors.Is(err, service.ErrExportExpired):
+		c.JSON(http.StatusGone, types.NewErrorResponse(http.StatusGone, "Export expired", err.Error()))
+	default:
+		c.JSON(http.StatusInternalServerError, types.NewErrorResponse(http.StatusInternalServerError, message, err.Error()))
+	}
+}
@@ -0,0 +1,217 @@
+package handler
+
+import (
+	"OMEGA3-IOT/internal/model"
+	"OMEGA3-IOT/internal/service"
+	"OMEGA3-IOT/internal/types"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// WebhookHandler handles HTTP requests for outbound webhooks. The same handler serves
+// user webhooks under /webhooks and global webhooks under /admin/webhooks.
+type WebhookHandler struct {
+	webhookService *service.WebhookService
+	adminService   *service.AdminService // set for the admin variant, used for audit logging
+	global         bool
+}
+
+// NewWebhookHandler creates a handler for the current user's webhooks.
+func NewWebhookHandler(webhookService *service.WebhookService) *WebhookHandler {
+	return &WebhookHandler{webhookService: webhookService}
+}
+
+// NewAdminWebhookHandler creates a handler for global webhooks; changes are written to the admin log.
+func NewAdminWebhookHandler(webhookService *service.WebhookService, adminService *service.AdminService) *WebhookHandler {
+	return &WebhookHandler{webhookService: webhookService, adminService: adminService, global: true}
+}
+
+// CreateWebhook handles POST /webhooks
+func (h *WebhookHandler) CreateWebhook(c *gin.Context) {
+	userUUID := c.GetString("user_uuid")
+
+	var input service.WebhookInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, types.NewErrorResponse(http.StatusBadRequest, "Invalid request parameters", err.Error()))
+		return
+	}
+
+	webhook, err := h.webhookService.CreateWebhook(userUUID, h.global, input)
+	if err != nil {
+		respondWebhookError(c, "Failed to create webhook", err)
+		return
+	}
+	h.audit(c, "webhook.create", webhook.WebhookUUID, webhook.URL)
+
+	c.JSON(http.StatusOK, types.NewSuccessResponseWithCode(webhookWithSecret(webhook), http.StatusOK, "Webhook created successfully"))
+}
+
+// ListWebhooks handles GET /webhooks
+func (h *WebhookHandler) ListWebhooks(c *gin.Context) {
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	pageSize, _ := strconv.Atoi(c.DefaultQuery("page_size", "10"))
+	if page < 1 {
+		page = 1
+	}
+	if pageSize < 1 || pageSize > 100 {
+		pageSize = 10
+	}
+
+	webhooks, total, err := h.webhookService.ListWebhooks(c.GetString("user_uuid"), h.global, pageSize, (page-1)*pageSize)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, types.NewErrorResponse(http.StatusInternalServerError, "Failed to get webhooks", err.Error()))
+		return
+	}
+
+	c.JSON(http.StatusOK, types.NewSuccessResponseWithCode(gin.H{
+		"webhooks":  webhooks,
+		"total":     total,
+		"page":      page,
+		"page_size": pageSize,
+	}, http.StatusOK, "OK"))
+}
+
+// GetWebhook handles GET /webhooks/:webhook_uuid
+func (h *WebhookHandler) GetWebhook(c *gin.Context) {
+	webhook, err := h.webhookService.GetWebhook(c.GetString("user_uuid"), h.global, c.Param("webhook_uuid"))
+	if err != nil {
+		respondWebhookError(c, "Failed to get webhook", err)
+		return
+	}
+	c.JSON(http.StatusOK, types.NewSuccessResponseWithCode(webhook, http.StatusOK, "OK"))
+}
+
+// UpdateWebhook handles PUT /webhooks/:webhook_uuid
+func (h *WebhookHandler) UpdateWebhook(c *gin.Context) {
+	var input service.WebhookInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, types.NewErrorResponse(http.StatusBadRequest, "Invalid request parameters", err.Error()))
+		return
+	}
+
+	webhook, err := h.webhookService.UpdateWebhook(c.GetString("user_uuid"), h.global, c.Param("webhook_uuid"), input)
+	if err != nil {
+		respondWebhookError(c, "Failed to update webhook", err)
+		return
+	}
+	h.audit(c, "webhook.update", webhook.WebhookUUID, webhook.URL)
+
+	c.JSON(http.StatusOK, types.NewSuccessResponseWithCode(webhook, http.StatusOK, "Webhook updated successfully"))
+}
+
+// DeleteWebhook handles DELETE /webhooks/:webhook_uuid
+func (h *WebhookHandler) DeleteWebhook(c *gin.Context) {
+	webhookUUID := c.Param("webhook_uuid")
+	if err := h.webhookService.DeleteWebhook(c.GetString("user_uuid"), h.global, webhookUUID); err != nil {
+		respondWebhookError(c, "Failed to delete webhook", err)
+		return
+	}
+	h.audit(c, "webhook.delete", webhookUUID, "")
+
+	c.JSON(http.StatusOK, types.NewSuccessResponseWithCode(gin.H{"webhook_uuid": webhookUUID}, http.StatusOK, "Webhook deleted successfully"))
+}
+
+// SetEnabled handles PUT /webhooks/:webhook_uuid/enabled
+func (h *WebhookHandler) SetEnabled(c *gin.Context) {
+	var input struct {
+		Enabled *bool `json:"enabled" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, types.NewErrorResponse(http.StatusBadRequest, "Invalid request parameters", err.Error()))
+		return
+	}
+
+	webhook, err := h.webhookService.SetEnabled(c.GetString("user_uuid"), h.global, c.Param("webhook_uuid"), *input.Enabled)
+	if err != nil {
+		respondWebhookError(c, "Failed to update webhook", err)
+		return
+	}
+	h.audit(c, "webhook.enable", webhook.WebhookUUID, strconv.FormatBool(*input.Enabled))
+
+	c.JSON(http.StatusOK, types.NewSuccessResponseWithCode(webhook, http.StatusOK, "OK"))
+}
+
+// RotateSecret handles POST /webhooks/:webhook_uuid/secret
+func (h *WebhookHandler) RotateSecret(c *gin.Context) {
+	webhook, err := h.webhookService.RotateSecret(c.GetString("user_uuid"), h.global, c.Param("webhook_uuid"))
+	if err != nil {
+		respondWebhookError(c, "Failed to rotate secret", err)
+		return
+	}
+	h.audit(c, "webhook.rotate_secret", webhook.WebhookUUID, "")
+
+	c.JSON(http.StatusOK, types.NewSuccessResponseWithCode(webhookWithSecret(webhook), http.StatusOK, "Secret rotated"))
+}
+
+// Ping handles POST /webhooks/:webhook_uuid/ping
+func (h *WebhookHandler) Ping(c *gin.Context) {
+	delivery, err := h.webhookService.Ping(c.GetString("user_uuid"), h.global, c.Param("webhook_uuid"))
+	if err != nil {
+		respondWebhookError(c, "Failed to ping webhook", err)
+		return
+	}
+	c.JSON(http.StatusAccepted, types.NewSuccessResponseWithCode(delivery, http.StatusAccepted, "Ping queued"))
+}
+
+// ListDeliveries handles GET /webhooks/:webhook_uuid/deliveries
+func (h *WebhookHandler) ListDeliveries(c *gin.Context) {
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "20"))
+	offset, _ := strconv.Atoi(c.DefaultQuery("offset", "0"))
+	if limit <= 0 || limit > 100 {
+		limit = 20
+	}
+	if offset < 0 {
+		offset = 0
+	}
+
+	deliveries, total, err := h.webhookService.ListDeliveries(c.GetString("user_uuid"), h.global, c.Param("webhook_uuid"), limit, offset)
+	if err != nil {
+		respondWebhookError(c, "Failed to get deliveries", err)
+		return
+	}
+
+	c.JSON(http.StatusOK, types.NewSuccessResponseWithCode(gin.H{
+		"deliveries": deliveries,
+		"total":      total,
+		"limit":      limit,
+		"offset":     offset,
+	}, http.StatusOK, "OK"))
+}
+
+// ListEventTypes handles GET /webhooks/event-types
+func (h *WebhookHandler) ListEventTypes(c *gin.Context) {
+	eventTypes := make([]string, 0, len(service.WebhookEventTypes))
+	for _, t := range service.WebhookEventTypes {
+		eventTypes = append(eventTypes, string(t))
+	}
+	c.JSON(http.StatusOK, types.NewSuccessResponseWithCode(gin.H{"event_types": eventTypes}, http.StatusOK, "OK"))
+}
+
+func (h *WebhookHandler) audit(c *gin.Context, action, webhookUUID, detail string) {
+	if h.adminService == nil {
+		return
+	}
+	h.adminService.LogAction(c.GetString("user_uuid"), action, "webhook", webhookUUID, detail, c.ClientIP())
+}
+
+// webhookWithSecret is the only response that includes the signing secret.
+func webhookWithSecret(webhook *model.Webhook) gin.H {
+	return gin.H{
+		"webhook": webhook,
+		"secret":  webhook.Secret,
+	}
+}
+
+func respondWebhookError(c *gin.Context, message string, err error) {
+	switch {
+	case errors.Is(err, service.ErrWebhookNotFound):
+		c.JSON(http.StatusNotFound, types.NewErrorResponse(http.StatusNotFound, "Webhook not found", err.Error()))
+	case errors.Is(err, service.ErrInvalidWebhook):
+		c.JSON(http.StatusBadRequest, types.NewErrorResponse(http.StatusBadRequest, "Invalid webhook", err.Error()))
+	default:
+		c.JSON(http.StatusInternalServerError, types.NewErrorResponse(http.StatusInternalServerError, message, err.Error()))
+	}
+}
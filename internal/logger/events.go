@@ -22,10 +22,17 @@ const (
 	// Device Events
 	LogEventDeviceActionReceived LogEventType = "device.action.received"
 	LogEventDeviceActionResult   LogEventType = "device.action.result"
+	LogEventDeviceActionTimeout  LogEventType = "device.action.timeout"
+	LogEventDeviceActionComplete LogEventType = "device.action.complete"
+	LogEventDeviceShadowUpdate   LogEventType = "device.shadow.update"
 	LogEventDeviceStatusChange   LogEventType = "device.status.change"
 	LogEventDevicePropertyUpdate LogEventType = "device.property.update"
 	LogEventDeviceLogUpload      LogEventType = "device.log.upload"
 	LogEventDeviceError          LogEventType = "device.error"
+	LogEventDeviceEventReceived  LogEventType = "device.event.received"
+	// LogEventDeviceCredentialsRevoked disconnects the device from the embedded MQTT broker
+	LogEventDeviceCredentialsRevoked LogEventType = "device.credentials.revoked"
+	LogEventDeviceCredentialsRotated LogEventType = "device.credentials.rotated"
 
 	// User Events
 	LogEventUserLogin           LogEventType = "user.login"
@@ -41,8 +48,23 @@ const (
 	LogEventDeviceRemovedFromFolder LogEventType = "device.removed.folder"
 	LogEventFolderDeleted           LogEventType = "folder.deleted"
 
+	// User Group Events
+	LogEventGroupCreated        LogEventType = "group.created"
+	LogEventGroupDissolved      LogEventType = "group.dissolved"
+	LogEventGroupMemberJoined   LogEventType = "group.member.joined"
+	LogEventGroupMemberLeft     LogEventType = "group.member.left"
+	LogEventGroupMemberRemoved  LogEventType = "group.member.removed"
+	LogEventGroupDeviceShared   LogEventType = "group.device.shared"
+	LogEventGroupDeviceUnshared LogEventType = "group.device.unshared"
+
+	// Alert Events (rule engine)
+	LogEventAlertFired    LogEventType = "alert.fired"
+	LogEventAlertResolved LogEventType = "alert.resolved"
+
 	// System Events
-	LogEventSystemError LogEventType = "system.error"
+	LogEventSystemError              LogEventType = "system.error"
+	LogEventSystemDeviceTypeReloaded LogEventType = "system.devicetype.reloaded"
+	LogEventSystemWebhooksChanged    LogEventType = "system.webhooks.changed"
 )
 
 // DeviceLogEvent represents a log entry from a device
@@ -165,3 +187,27 @@ type LogQueryResponse struct {
 	Total   int        `json:"total"`
 	Entries []LogEntry `json:"entries"`
 }
+
+// The log events are the events published on the bus; registering them lets a durable bus
+// persist them.
+func init() {
+	eventbus.RegisterType[DeviceLogEvent]("logger.device")
+	eventbus.RegisterType[UserLogEvent]("logger.user")
+	eventbus.RegisterType[SystemLogEvent]("logger.system")
+}
+
+// ForDevice only delivers device log events of the given devices.
+func ForDevice(deviceUUIDs ...string) eventbus.SubscribeOption {
+	return eventbus.WithFilter(func(event eventbus.Event) bool {
+		deviceEvent, ok := event.(DeviceLogEvent)
+		if !ok {
+			return false
+		}
+		for _, deviceUUID := range deviceUUIDs {
+			if deviceEvent.DeviceUUID == deviceUUID {
+				return true
+			}
+		}
+		return false
+	})
+}
@@ -0,0 +1,49 @@
+package logger
+
+import (
+	"encoding/json"
+)
+
+// LogRecord is one stored log line: its time in milliseconds and its string fields, such as
+// level, message, event_type and metadata.
+type LogRecord struct {
+	Timestamp int64
+	Fields    map[string]string
+}
+
+// LogStore persists device logs and user logs (including system logs) in the time-series
+// backend. Queries return records in [start, end] (ms), newest first.
+type LogStore interface {
+	InitializeSchema() error
+	WriteDeviceLog(deviceUUID string, record LogRecord) error
+	WriteUserLog(record LogRecord) error
+	QueryDeviceLogs(deviceUUID string, start, end int64, limit, offset int) ([]LogRecord, error)
+	QueryUserLogs(start, end int64, limit, offset int) ([]LogRecord, error)
+	DeleteDeviceLogsBefore(deviceUUID string, before int64) error
+	DeleteUserLogsBefore(before int64) error
+}
+
+// logEntryFromRecord converts a stored record to a LogEntry. Fields other than level, message,
+// event_type and metadata are added to the metadata.
+func logEntryFromRecord(record LogRecord) LogEntry {
+	entry := LogEntry{
+		Timestamp: record.Timestamp / 1000, // Convert to seconds
+		Level:     LogLevel(record.Fields["level"]),
+		Message:   record.Fields["message"],
+		EventType: LogEventType(record.Fields["event_type"]),
+	}
+	if metadata := record.Fields["metadata"]; metadata != "" {
+		json.Unmarshal([]byte(metadata), &entry.Metadata)
+	}
+	for name, value := range record.Fields {
+		switch name {
+		case "level", "message", "event_type", "metadata":
+			continue
+		}
+		if entry.Metadata == nil {
+			entry.Metadata = make(map[string]interface{})
+		}
+		entry.Metadata[name] = value
+	}
+	return entry
+}
@@ -0,0 +1,81 @@
+package logger
+
+import (
+	"OMEGA3-IOT/internal/db"
+
+	"github.com/apache/iotdb-client-go/client"
+)
+
+const embeddedUserLogSeries = "user_data/log"
+
+// embeddedLogStore keeps logs in the embedded store, in series laid out like the IoTDB paths.
+type embeddedLogStore struct {
+	store *db.EmbeddedTSDB
+}
+
+// NewEmbeddedLogStore creates a LogStore on the embedded time-series store.
+func NewEmbeddedLogStore(store *db.EmbeddedTSDB) LogStore {
+	return &embeddedLogStore{store: store}
+}
+
+func embeddedDeviceLogSeries(deviceUUID string) string {
+	return "device_data/" + deviceUUID + "/log"
+}
+
+// InitializeSchema has nothing to create; series are created on first write.
+func (s *embeddedLogStore) InitializeSchema() error {
+	return nil
+}
+
+func (s *embeddedLogStore) WriteDeviceLog(deviceUUID string, record LogRecord) error {
+	return s.write(embeddedDeviceLogSeries(deviceUUID), record)
+}
+
+func (s *embeddedLogStore) WriteUserLog(record LogRecord) error {
+	return s.write(embeddedUserLogSeries, record)
+}
+
+func (s *embeddedLogStore) write(series string, record LogRecord) error {
+	point := db.EmbeddedPoint{Timestamp: record.Timestamp, Values: make(map[string]interface{}, len(record.Fields))}
+	dataTypes := make(map[string]client.TSDataType, len(record.Fields))
+	for name, value := range record.Fields {
+		point.Values[name] = value
+		dataTypes[name] = client.STRING
+	}
+	return s.store.Append(series, []db.EmbeddedPoint{point}, dataTypes)
+}
+
+func (s *embeddedLogStore) QueryDeviceLogs(deviceUUID string, start, end int64, limit, offset int) ([]LogRecord, error) {
+	return s.query(embeddedDeviceLogSeries(deviceUUID), start, end, limit, offset)
+}
+
+func (s *embeddedLogStore) QueryUserLogs(start, end int64, limit, offset int) ([]LogRecord, error) {
+	return s.query(embeddedUserLogSeries, start, end, limit, offset)
+}
+
+func (s *embeddedLogStore) query(series string, start, end int64, limit, offset int) ([]LogRecord, error) {
+	points, err := s.store.Range(series, start, end+1)
+	if err != nil {
+		return nil, err
+	}
+
+	var records []LogRecord
+	for i := len(points) - 1 - offset; i >= 0 && len(records) < limit; i-- {
+		record := LogRecord{Timestamp: points[i].Timestamp, Fields: make(map[string]string, len(points[i].Values))}
+		for name, value := range points[i].Values {
+			if text, ok := value.(string); ok {
+				record.Fields[name] = text
+			}
+		}
+		records = append(records, record)
+	}
+	return records, nil
+}
+
+func (s *embeddedLogStore) DeleteDeviceLogsBefore(deviceUUID string, before int64) error {
+	return s.store.DeleteBefore(embeddedDeviceLogSeries(deviceUUID), nil, before)
+}
+
+func (s *embeddedLogStore) DeleteUserLogsBefore(before int64) error {
+	return s.store.DeleteBefore(embeddedUserLogSeries, nil, before)
+}
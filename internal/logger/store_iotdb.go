@@ -0,0 +1,153 @@
+package logger
+
+import (
+	"OMEGA3-IOT/internal/db"
+	"fmt"
+	"github.com/apache/iotdb-client-go/client"
+	"log"
+	"sort"
+	"strings"
+)
+
+const iotdbUserLogPath = "root.mm1.user_data.log"
+
+// iotdbLogStore keeps device logs under root.mm1.device_data.<uuid>.log and user logs under
+// root.mm1.user_data.log, one STRING measurement per field.
+type iotdbLogStore struct {
+	client *db.IOTDBClient
+}
+
+// NewIoTDBLogStore creates a LogStore on IoTDB.
+func NewIoTDBLogStore(client *db.IOTDBClient) LogStore {
+	return &iotdbLogStore{client: client}
+}
+
+func iotdbDeviceLogPath(deviceUUID string) string {
+	return fmt.Sprintf("root.mm1.device_data.%s.log", deviceUUID)
+}
+
+func (s *iotdbLogStore) WriteDeviceLog(deviceUUID string, record LogRecord) error {
+	return s.write(iotdbDeviceLogPath(deviceUUID), record)
+}
+
+func (s *iotdbLogStore) WriteUserLog(record LogRecord) error {
+	return s.write(iotdbUserLogPath, record)
+}
+
+func (s *iotdbLogStore) write(path string, record LogRecord) error {
+	measurements := make([]string, 0, len(record.Fields))
+	for name := range record.Fields {
+		measurements = append(measurements, name)
+	}
+	sort.Strings(measurements)
+	dataTypes := make([]client.TSDataType, len(measurements))
+	values := make([]interface{}, len(measurements))
+	for i, name := range measurements {
+		dataTypes[i] = client.STRING
+		values[i] = record.Fields[name]
+	}
+
+	session, err := s.client.SessionPool.GetSession()
+	if err != nil {
+		return fmt.Errorf("failed to get session: %w", err)
+	}
+	defer s.client.SessionPool.PutBack(session)
+
+	_, err = session.InsertRecord(path, measurements, dataTypes, values, record.Timestamp)
+	return err
+}
+
+func (s *iotdbLogStore) QueryDeviceLogs(deviceUUID string, start, end int64, limit, offset int) ([]LogRecord, error) {
+	return s.query(iotdbDeviceLogPath(deviceUUID), start, end, limit, offset)
+}
+
+func (s *iotdbLogStore) QueryUserLogs(start, end int64, limit, offset int) ([]LogRecord, error) {
+	return s.query(iotdbUserLogPath, start, end, limit, offset)
+}
+
+func (s *iotdbLogStore) query(path string, start, end int64, limit, offset int) ([]LogRecord, error) {
+	session, err := s.client.SessionPool.GetSession()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get session: %w", err)
+	}
+	defer s.client.SessionPool.PutBack(session)
+
+	sql := fmt.Sprintf("SELECT * FROM %s WHERE time >= %d AND time <= %d ORDER BY time DESC LIMIT %d OFFSET %d",
+		path, start, end, limit, offset)
+	dataSet, err := session.ExecuteQueryStatement(sql, &s.client.Config.IoTDB.QueryTimeoutMs)
+	if err != nil {
+		return nil, fmt.Errorf("failed to execute query: %w", err)
+	}
+	defer dataSet.Close()
+
+	var records []LogRecord
+	for {
+		hasNext, err := dataSet.Next()
+		if err != nil {
+			return nil, fmt.Errorf("failed to iterate result: %w", err)
+		}
+		if !hasNext {
+			break
+		}
+
+		row, err := dataSet.GetRowRecord()
+		if err != nil {
+			return nil, fmt.Errorf("failed to get row record: %w", err)
+		}
+
+		record := LogRecord{Timestamp: row.GetTimestamp(), Fields: make(map[string]string)}
+		for i := 0; i < dataSet.GetColumnCount(); i++ {
+			columnName := dataSet.GetColumnName(i)
+			if columnName == client.TimestampColumnName {
+				continue
+			}
+			if value, ok := dataSet.GetValue(columnName).(string); ok {
+				// Columns are full paths such as root.mm1.user_data.log.level
+				record.Fields[columnName[strings.LastIndex(columnName, ".")+1:]] = value
+			}
+		}
+		records = append(records, record)
+	}
+	return records, nil
+}
+
+func (s *iotdbLogStore) DeleteDeviceLogsBefore(deviceUUID string, before int64) error {
+	return s.deleteBefore(iotdbDeviceLogPath(deviceUUID), before)
+}
+
+func (s *iotdbLogStore) DeleteUserLogsBefore(before int64) error {
+	return s.deleteBefore(iotdbUserLogPath, before)
+}
+
+func (s *iotdbLogStore) deleteBefore(path string, before int64) error {
+	status, err := s.client.ExecuteNonQuery(fmt.Sprintf("DELETE FROM %s.* WHERE time < %d", path, before))
+	if err == nil && status != nil && strings.Contains(status.GetMessage(), "does not exist") {
+		return nil // nothing logged yet
+	}
+	return s.client.CheckError(status, err)
+}
+
+// InitializeSchema creates the user_data storage group and the user log timeseries. Device log
+// timeseries are created on first write.
+func (s *iotdbLogStore) InitializeSchema() error {
+	session, err := s.client.SessionPool.GetSession()
+	if err != nil {
+		return fmt.Errorf("failed to get session: %w", err)
+	}
+	defer s.client.SessionPool.PutBack(session)
+
+	// Create user_data storage group if not exists
+	status, err := session.SetStorageGroup("root.mm1.user_data")
+	if checkErr := s.client.CheckError(status, err); checkErr != nil {
+		log.Printf("[LoggerService] Storage group may already exist: %v", checkErr)
+	}
+
+	for _, name := range []string{"user_uuid", "level", "message", "event_type", "metadata", "ip_address", "user_agent"} {
+		sql := fmt.Sprintf("CREATE TIMESERIES %s.%s WITH DATATYPE=STRING, ENCODING=PLAIN, COMPRESSION=SNAPPY", iotdbUserLogPath, name)
+		status, err := session.ExecuteNonQueryStatement(sql)
+		if checkErr := s.client.CheckError(status, err); checkErr != nil {
+			log.Printf("[LoggerService] Timeseries %s may already exist: %v", name, checkErr)
+		}
+	}
+	return nil
+}
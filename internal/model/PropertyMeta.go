@@ -11,4 +11,9 @@ type PropertyMeta struct {
 	Required             bool      `yaml:"required,omitempty" json:"required,omitempty"`
 	Pattern              string    `yaml:"pattern,omitempty" json:"pattern,omitempty"`
 	RequiredCapabilities []string  `yaml:"required_capabilities,omitempty" json:"required_capabilities,omitempty"`
+	// Default is the initial value given to the property when an existing instance is migrated
+	// to a type version that adds it.
+	Default interface{} `yaml:"default,omitempty" json:"default,omitempty"`
+	// RetentionDays is how long the property's telemetry is kept; 0 uses the type's retention.
+	RetentionDays int `yaml:"retention_days,omitempty" json:"retention_days,omitempty"`
 }
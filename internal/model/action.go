@@ -3,6 +3,7 @@ package model
 import "time"
 
 type Action struct {
+	ActionID  string      `json:"action_id,omitempty"`
 	Command   string      `json:"command"`
 	Params    interface{} `json:"params"`
 	Timestamp int64       `json:"timestamp,omitempty"`
@@ -0,0 +1,70 @@
+package model
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// ActionRecord status constants
+const (
+	ActionStatusQueued    = "queued"
+	ActionStatusPending   = "pending"
+	ActionStatusDelivered = "delivered"
+	ActionStatusSucceeded = "succeeded"
+	ActionStatusFailed    = "failed"
+	ActionStatusTimedOut  = "timed_out"
+	ActionStatusExpired   = "expired"
+)
+
+// DefaultActionTimeoutSec is used when the device type does not declare a timeout for an action.
+const DefaultActionTimeoutSec = 30
+
+// ActionRecord tracks a single action sent to a device, from dispatch to its final result.
+// ExpiresAt is the queue TTL deadline while queued (0 means no TTL), and the result deadline once pending or delivered.
+type ActionRecord struct {
+	ID           uint            `gorm:"primaryKey;autoIncrement" json:"id"`
+	ActionID     string          `gorm:"type:varchar(36);not null;uniqueIndex" json:"action_id"`
+	InstanceUUID string          `gorm:"type:varchar(36);not null;index" json:"instance_uuid"`
+	Command      string          `gorm:"type:varchar(64);not null" json:"command"`
+	Params       json.RawMessage `gorm:"type:json" json:"params,omitempty"`
+	RequestedBy  string          `gorm:"type:varchar(36);index" json:"requested_by"`
+	Status       string          `gorm:"type:varchar(20);not null;default:'pending';index" json:"status"`
+	Error        string          `gorm:"type:varchar(500)" json:"error,omitempty"`
+	TimeoutSec   int             `gorm:"not null" json:"timeout_sec"`
+	Idempotent   bool            `gorm:"not null;default:false" json:"idempotent"`
+	Attempts     int             `gorm:"not null;default:0" json:"attempts"`
+	CreatedAt    int64           `gorm:"not null" json:"created_at"`
+	DeliveredAt  int64           `json:"delivered_at,omitempty"`
+	CompletedAt  int64           `json:"completed_at,omitempty"`
+	ExpiresAt    int64           `gorm:"index" json:"expires_at,omitempty"`
+}
+
+// NewActionRecord creates a pending action record.
+func NewActionRecord(actionID, instanceUUID, command string, params json.RawMessage, requestedBy string, timeoutSec int, idempotent bool) *ActionRecord {
+	if timeoutSec <= 0 {
+		timeoutSec = DefaultActionTimeoutSec
+	}
+	return &ActionRecord{
+		ActionID:     actionID,
+		InstanceUUID: instanceUUID,
+		Command:      command,
+		Params:       params,
+		RequestedBy:  requestedBy,
+		Status:       ActionStatusPending,
+		TimeoutSec:   timeoutSec,
+		Idempotent:   idempotent,
+		CreatedAt:    time.Now().Unix(),
+	}
+}
+
+// ActionOutstandingStatuses are the non-final states an action can be in.
+var ActionOutstandingStatuses = []string{ActionStatusQueued, ActionStatusPending, ActionStatusDelivered}
+
+// IsFinal reports whether the action has reached a terminal state.
+func (a *ActionRecord) IsFinal() bool {
+	switch a.Status {
+	case ActionStatusSucceeded, ActionStatusFailed, ActionStatusTimedOut, ActionStatusExpired:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,194 @@
+package model
+
+import (
+	"OMEGA3-IOT/internal/utils"
+	"fmt"
+	"time"
+)
+
+// Alert rule scope types
+const (
+	AlertScopeDevice = "device"
+	AlertScopeFolder = "folder"
+	AlertScopeType   = "type"
+)
+
+// Alert rule comparison operators
+const (
+	AlertOpGt  = "gt"
+	AlertOpGte = "gte"
+	AlertOpLt  = "lt"
+	AlertOpLte = "lte"
+	AlertOpEq  = "eq"
+	AlertOpNeq = "neq"
+)
+
+// Alert severities
+const (
+	AlertSeverityInfo     = "info"
+	AlertSeverityWarning  = "warning"
+	AlertSeverityCritical = "critical"
+)
+
+// AlertRule fires when a numeric property of a device in its scope satisfies the condition
+// for at least DurationSec seconds. A firing alert resolves once the value crosses back past
+// the threshold by more than Hysteresis. DebounceSec is the minimum time between two fires of
+// the same rule on the same device. Type-scoped rules only apply to devices owned by the rule owner.
+type AlertRule struct {
+	ID          uint    `gorm:"primaryKey;autoIncrement" json:"-"`
+	RuleUUID    string  `gorm:"type:varchar(36);not null;uniqueIndex" json:"rule_uuid"`
+	OwnerUUID   string  `gorm:"type:varchar(36);not null;index" json:"owner_uuid"`
+	Name        string  `gorm:"type:varchar(128);not null" json:"name"`
+	ScopeType   string  `gorm:"type:varchar(16);not null;index:idx_alert_rule_scope" json:"scope_type"`
+	ScopeValue  string  `gorm:"type:varchar(128);not null;index:idx_alert_rule_scope" json:"scope_value"`
+	Property    string  `gorm:"type:varchar(64);not null" json:"property"`
+	Operator    string  `gorm:"type:varchar(8);not null" json:"operator"`
+	Threshold   float64 `gorm:"not null" json:"threshold"`
+	Hysteresis  float64 `gorm:"not null;default:0" json:"hysteresis"`
+	DurationSec int64   `gorm:"not null;default:0" json:"duration_sec"`
+	DebounceSec int64   `gorm:"not null;default:0" json:"debounce_sec"`
+	Severity    string  `gorm:"type:varchar(16);not null;default:'warning'" json:"severity"`
+	Enabled     bool    `gorm:"not null;default:true;index" json:"enabled"`
+	Muted       bool    `gorm:"not null;default:false" json:"muted"`
+	MutedUntil  int64   `gorm:"not null;default:0" json:"muted_until,omitempty"`
+	CreatedAt   int64   `gorm:"not null" json:"created_at"`
+	UpdatedAt   int64   `gorm:"not null" json:"updated_at"`
+}
+
+// NewAlertRule creates an enabled rule with a fresh UUID.
+func NewAlertRule(ownerUUID, name, scopeType, scopeValue, property, operator string, threshold float64) *AlertRule {
+	now := time.Now().Unix()
+	return &AlertRule{
+		RuleUUID:   utils.GenerateUUID().String(),
+		OwnerUUID:  ownerUUID,
+		Name:       name,
+		ScopeType:  scopeType,
+		ScopeValue: scopeValue,
+		Property:   property,
+		Operator:   operator,
+		Threshold:  threshold,
+		Severity:   AlertSeverityWarning,
+		Enabled:    true,
+		CreatedAt:  now,
+		UpdatedAt:  now,
+	}
+}
+
+// Validate checks the fields that do not depend on other data.
+func (r *AlertRule) Validate() error {
+	switch r.ScopeType {
+	case AlertScopeDevice, AlertScopeFolder, AlertScopeType:
+	default:
+		return fmt.Errorf("invalid scope_type '%s'", r.ScopeType)
+	}
+	if r.ScopeValue == "" {
+		return fmt.Errorf("scope_value is required")
+	}
+	if r.Property == "" {
+		return fmt.Errorf("property is required")
+	}
+	switch r.Operator {
+	case AlertOpGt, AlertOpGte, AlertOpLt, AlertOpLte, AlertOpEq, AlertOpNeq:
+	default:
+		return fmt.Errorf("invalid operator '%s'", r.Operator)
+	}
+	switch r.Severity {
+	case AlertSeverityInfo, AlertSeverityWarning, AlertSeverityCritical:
+	default:
+		return fmt.Errorf("invalid severity '%s'", r.Severity)
+	}
+	if r.Hysteresis < 0 || r.DurationSec < 0 || r.DebounceSec < 0 {
+		return fmt.Errorf("hysteresis, duration_sec and debounce_sec must not be negative")
+	}
+	return nil
+}
+
+// IsMuted reports whether notifications of the rule are suppressed at the given time.
+// A muted rule with MutedUntil == 0 stays muted until explicitly unmuted.
+func (r *AlertRule) IsMuted(now int64) bool {
+	return r.Muted && (r.MutedUntil == 0 || now < r.MutedUntil)
+}
+
+// Matches reports whether the value satisfies the firing condition.
+func (r *AlertRule) Matches(value float64) bool {
+	return compareAlertValue(r.Operator, value, r.Threshold)
+}
+
+// Cleared reports whether a firing alert may resolve. For ordering operators the threshold
+// is shifted by Hysteresis so that values hovering around it do not flap.
+func (r *AlertRule) Cleared(value float64) bool {
+	threshold := r.Threshold
+	switch r.Operator {
+	case AlertOpGt, AlertOpGte:
+		threshold -= r.Hysteresis
+	case AlertOpLt, AlertOpLte:
+		threshold += r.Hysteresis
+	}
+	return !compareAlertValue(r.Operator, value, threshold)
+}
+
+func compareAlertValue(op string, value, threshold float64) bool {
+	switch op {
+	case AlertOpGt:
+		return value > threshold
+	case AlertOpGte:
+		return value >= threshold
+	case AlertOpLt:
+		return value < threshold
+	case AlertOpLte:
+		return value <= threshold
+	case AlertOpEq:
+		return value == threshold
+	case AlertOpNeq:
+		return value != threshold
+	}
+	return false
+}
+
+// AlertTransition is the outcome of evaluating a rule against a new value.
+type AlertTransition int
+
+const (
+	AlertTransitionNone AlertTransition = iota
+	AlertTransitionFire
+	AlertTransitionResolve
+)
+
+// AlertState is the evaluation state of one rule on one device.
+type AlertState struct {
+	PendingSince int64 // when the condition started to hold, 0 if it does not
+	Firing       bool
+	FiredAt      int64
+	LastFiredAt  int64 // last fire, kept across resolves for debouncing
+	Notified     bool  // whether the current fire was announced (not muted)
+}
+
+// Evaluate advances the state with a new value observed at now and returns the transition.
+func (r *AlertRule) Evaluate(state *AlertState, value float64, now int64) AlertTransition {
+	if state.Firing {
+		if !r.Cleared(value) {
+			return AlertTransitionNone
+		}
+		state.Firing = false
+		state.PendingSince = 0
+		return AlertTransitionResolve
+	}
+
+	if !r.Matches(value) {
+		state.PendingSince = 0
+		return AlertTransitionNone
+	}
+	if state.PendingSince == 0 {
+		state.PendingSince = now
+	}
+	if now-state.PendingSince < r.DurationSec {
+		return AlertTransitionNone
+	}
+	if state.LastFiredAt > 0 && now-state.LastFiredAt < r.DebounceSec {
+		return AlertTransitionNone
+	}
+	state.Firing = true
+	state.FiredAt = now
+	state.LastFiredAt = now
+	return AlertTransitionFire
+}
@@ -0,0 +1,58 @@
+package model
+
+import "testing"
+
+func TestAlertRuleEvaluate_Duration(t *testing.T) {
+	rule := &AlertRule{Operator: AlertOpGt, Threshold: 30, DurationSec: 300}
+	state := &AlertState{}
+
+	if tr := rule.Evaluate(state, 31, 1000); tr != AlertTransitionNone {
+		t.Fatalf("expected no transition at start of duration, got %v", tr)
+	}
+	if tr := rule.Evaluate(state, 32, 1200); tr != AlertTransitionNone {
+		t.Fatalf("expected no transition before duration elapsed, got %v", tr)
+	}
+	if tr := rule.Evaluate(state, 33, 1300); tr != AlertTransitionFire {
+		t.Fatalf("expected fire after duration elapsed, got %v", tr)
+	}
+}
+
+func TestAlertRuleEvaluate_DurationReset(t *testing.T) {
+	rule := &AlertRule{Operator: AlertOpGt, Threshold: 30, DurationSec: 300}
+	state := &AlertState{}
+
+	rule.Evaluate(state, 31, 1000)
+	rule.Evaluate(state, 29, 1100)
+	if tr := rule.Evaluate(state, 31, 1300); tr != AlertTransitionNone {
+		t.Fatalf("expected duration to restart after the condition broke, got %v", tr)
+	}
+}
+
+func TestAlertRuleEvaluate_Hysteresis(t *testing.T) {
+	rule := &AlertRule{Operator: AlertOpLt, Threshold: 15, Hysteresis: 5}
+	state := &AlertState{}
+
+	if tr := rule.Evaluate(state, 14, 1000); tr != AlertTransitionFire {
+		t.Fatalf("expected fire, got %v", tr)
+	}
+	if tr := rule.Evaluate(state, 18, 1010); tr != AlertTransitionNone {
+		t.Fatalf("expected alert to keep firing inside the hysteresis band, got %v", tr)
+	}
+	if tr := rule.Evaluate(state, 21, 1020); tr != AlertTransitionResolve {
+		t.Fatalf("expected resolve past the hysteresis band, got %v", tr)
+	}
+}
+
+func TestAlertRuleEvaluate_Debounce(t *testing.T) {
+	rule := &AlertRule{Operator: AlertOpGte, Threshold: 30, DebounceSec: 60}
+	state := &AlertState{}
+
+	rule.Evaluate(state, 30, 1000)
+	rule.Evaluate(state, 20, 1010)
+	if tr := rule.Evaluate(state, 35, 1020); tr != AlertTransitionNone {
+		t.Fatalf("expected refire inside debounce window to be suppressed, got %v", tr)
+	}
+	if tr := rule.Evaluate(state, 35, 1070); tr != AlertTransitionFire {
+		t.Fatalf("expected fire after debounce window, got %v", tr)
+	}
+}
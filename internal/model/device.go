@@ -2,9 +2,13 @@ package model
 
 import (
 	"OMEGA3-IOT/internal/utils"
+	"bytes"
 	"fmt"
+	"github.com/go-viper/mapstructure/v2"
 	"github.com/spf13/viper"
+	"gopkg.in/yaml.v3"
 	"log"
+	"os"
 	"sync"
 	"time"
 )
@@ -36,6 +40,7 @@ type Instance struct {
 	InstanceUUID string     `gorm:"uniqueIndex;type:varchar(36)" json:"instance_uuid"`
 	Name         string     `gorm:"type:varchar(100);not null" json:"name"`
 	Type         string     `gorm:"type:varchar(50);not null;index" json:"type"`
+	TypeVersion  string     `gorm:"type:varchar(32)" json:"type_version"` // device type version the property set was built from
 	Online       bool       `gorm:"default:false" json:"online"`
 	OwnerUUID    string     `gorm:"type:varchar(36);not null;index" json:"owner_uuid"`
 	Description  string     `gorm:"type:text" json:"description,omitempty"`
@@ -45,6 +50,12 @@ type Instance struct {
 	CreatedAt    time.Time  `gorm:"autoCreateTime" json:"created_at"`
 	UpdatedAt    time.Time  `gorm:"autoUpdateTime" json:"updated_at"`
 	VerifyHash   string     `gorm:"type:varchar(255)" json:"verify_hash"`
+	// 凭据轮换后，旧验证码在宽限期内仍被接受，到期后清除，见 AcceptsVerifyHash
+	PreviousVerifyHash      string `gorm:"type:varchar(255)" json:"-"`
+	PreviousVerifyExpiresAt int64  `gorm:"not null;default:0;index" json:"credentials_grace_expires_at,omitempty"`
+	// 硬重置后设备凭据作废，须以一次性重置码重新配网
+	ResetCodeHash      string `gorm:"type:varchar(255)" json:"-"`
+	ResetCodeExpiresAt int64  `gorm:"not null;default:0" json:"-"`
 	//IsActivated  bool       `gorm:"default:false" json:"is_activated"`不需要，因为有DeviceRegistrationRecord的机制，出现在这个库里的肯定是激活绑定了的
 	SN     string    `gorm:"type:varchar(100);null" json:"sn,omitempty"`
 	BindBy BindMethod `gorm:"type:tinyint;not null;default:0" json:"bind_by"` // 绑定方式：0=Bluetooth, 1=Cellular, 2=WiFi
@@ -99,11 +110,17 @@ type DeviceRegistrationRecord struct {
 type DeviceType struct {
 	ID           int                     `mapstructure:"id" yaml:"id" json:"id"`
 	Name         string                  `mapstructure:"name" yaml:"name" json:"name"`
+	Version      string                  `mapstructure:"version" yaml:"version" json:"version"`
 	Description  string                  `mapstructure:"description" yaml:"description" json:"description"`
 	Properties   map[string]PropertyMeta `mapstructure:"properties" yaml:"properties" json:"properties"`
 	Capabilities map[string]Capability   `mapstructure:"capabilities" yaml:"capabilities" json:"capabilities"`
 	Actions      map[string]ActionMeta   `mapstructure:"actions" yaml:"actions" json:"actions"`
 	Events       map[string]EventMeta    `mapstructure:"events" yaml:"events" json:"events"`
+	// Deprecated types stay usable by existing devices but accept no new registrations.
+	Deprecated bool `mapstructure:"deprecated" yaml:"deprecated,omitempty" json:"deprecated"`
+	// RetentionDays is the default retention of the type's telemetry and events; 0 falls back
+	// to the global default.
+	RetentionDays int `mapstructure:"retention_days" yaml:"retention_days,omitempty" json:"retention_days,omitempty"`
 }
 
 type DeviceShare struct {
@@ -148,57 +165,110 @@ var GlobalDeviceTypeManager = &DeviceTypeManager{
 func (dtm *DeviceTypeManager) LoadDeviceTypeFromYAML(filePath string) error {
 	//TODO 也许这里可以封装起来，让其可以load any?
 
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		return fmt.Errorf("could not load config: %v", err)
+	}
+	deviceTypes, err := ParseDeviceTypes(data)
+	if err != nil {
+		return err
+	}
+
+	valid := make([]DeviceType, 0, len(deviceTypes))
+	for i, dt := range deviceTypes {
+		if dt.Name == "" {
+			log.Printf("Warning: Device type %d has empty name\n", i)
+			continue
+		}
+		if dt.ID <= 0 {
+			log.Printf("Warning: Device type %s has invalid ID\n", dt.Name)
+			continue
+		}
+		valid = append(valid, dt)
+	}
+	dtm.Replace(valid)
+	return nil
+}
+
+// ParseDeviceTypes decodes the device_types list of a device type YAML document.
+func ParseDeviceTypes(data []byte) ([]DeviceType, error) {
 	v := viper.New()
-	v.SetConfigFile(filePath)
 	v.SetConfigType("yaml")
-	if err := v.ReadInConfig(); err != nil {
-		return fmt.Errorf("could not load config: %v", err)
+	if err := v.ReadConfig(bytes.NewReader(data)); err != nil {
+		return nil, fmt.Errorf("could not load config: %v", err)
 	}
 
 	//var deviceTypes []*DeviceType不适用指针数组
 	var deviceTypesConfig struct {
+		Version     string       `mapstructure:"version" yaml:"version"`
 		DeviceTypes []DeviceType `mapstructure:"device_types" yaml:"device_types"`
 	}
-	if err := v.Unmarshal(&deviceTypesConfig); err != nil {
-		return fmt.Errorf("could not unmarshal config: %v", err)
+	// Decode by yaml tags so snake_case keys such as timeout_sec and input_params are mapped.
+	if err := v.Unmarshal(&deviceTypesConfig, func(dc *mapstructure.DecoderConfig) { dc.TagName = "yaml" }); err != nil {
+		return nil, fmt.Errorf("could not unmarshal config: %v", err)
 	}
+	// Types without their own version inherit the file-level version.
+	for i := range deviceTypesConfig.DeviceTypes {
+		if deviceTypesConfig.DeviceTypes[i].Version == "" {
+			deviceTypesConfig.DeviceTypes[i].Version = deviceTypesConfig.Version
+		}
+	}
+	return deviceTypesConfig.DeviceTypes, nil
+}
 
-	dtm.mu.Lock()
-	defer dtm.mu.Unlock()
-
-	dtm.types = make(map[string]*DeviceType)
-	dtm.ids = make(map[int]*DeviceType)
+// MarshalDeviceTypes encodes device types as a YAML document that ParseDeviceTypes reads back.
+func MarshalDeviceTypes(deviceTypes []DeviceType) ([]byte, error) {
+	return yaml.Marshal(struct {
+		DeviceTypes []DeviceType `yaml:"device_types"`
+	}{DeviceTypes: deviceTypes})
+}
 
-	for i, dt := range deviceTypesConfig.DeviceTypes {
-		deviceType := &dt
+// Replace atomically swaps the loaded definitions for the given ones. Readers see
+// either the old or the new set, never a mix.
+func (dtm *DeviceTypeManager) Replace(deviceTypes []DeviceType) {
+	types := make(map[string]*DeviceType, len(deviceTypes))
+	ids := make(map[int]*DeviceType, len(deviceTypes))
+	for i := range deviceTypes {
+		deviceType := &deviceTypes[i]
+		ids[deviceType.ID] = deviceType
+		types[deviceType.Name] = deviceType
+	}
 
-		if deviceType.Name == "" {
-			log.Printf("Warning: Device type %d has empty name\n", i)
-			continue
-		}
-		if deviceType.ID <= 0 {
-			log.Printf("Warning: Device type %s has invalid ID\n", deviceType.Name)
-			continue
-		}
+	dtm.mu.Lock()
+	dtm.types = types
+	dtm.ids = ids
+	dtm.mu.Unlock()
+}
 
-		dtm.ids[deviceType.ID] = deviceType
-		dtm.types[deviceType.Name] = deviceType
+// Snapshot returns the currently loaded definitions keyed by name.
+func (dtm *DeviceTypeManager) Snapshot() map[string]*DeviceType {
+	dtm.mu.RLock()
+	defer dtm.mu.RUnlock()
+	snapshot := make(map[string]*DeviceType, len(dtm.types))
+	for name, dt := range dtm.types {
+		snapshot[name] = dt
 	}
-	return nil
+	return snapshot
 }
 
 func (dtm *DeviceTypeManager) GetByName(name string) (*DeviceType, bool) {
+	dtm.mu.RLock()
+	defer dtm.mu.RUnlock()
 	dt, exists := dtm.types[name]
 	return dt, exists
 }
 
 func (dtm *DeviceTypeManager) GetById(id int) (*DeviceType, bool) {
+	dtm.mu.RLock()
+	defer dtm.mu.RUnlock()
 	dt, exists := dtm.ids[id]
 	return dt, exists
 }
 
 // IsValidType 是验证设备类型
 func (dtm *DeviceTypeManager) IsValidType(name string) bool {
+	dtm.mu.RLock()
+	defer dtm.mu.RUnlock()
 	_, exists := dtm.types[name]
 	return exists
 }
@@ -237,6 +307,7 @@ func NewInstanceFromConfig(name string, ownerUuid string, deviceType *DeviceType
 		Name:         name,
 		Remark:       remark,
 		Type:         deviceType.Name,
+		TypeVersion:  deviceType.Version,
 		Online:       false,
 		OwnerUUID:    ownerUuid,
 		Description:  deviceType.Description,
@@ -245,7 +316,7 @@ func NewInstanceFromConfig(name string, ownerUuid string, deviceType *DeviceType
 		Properties:   props,
 		VerifyHash:   verifyHash,
 		BindBy:       bindBy,
-		Status:       "active",
+		Status:       InstanceStatusActive,
 		IsShared:     false,
 		SharedCount:  0,
 		CreatedAt:    time.Now(),
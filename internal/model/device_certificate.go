@@ -0,0 +1,30 @@
+package model
+
+// Revocation reasons of device certificates
+const (
+	CertificateRevokedByOwner  = "revoked"
+	CertificateSuperseded      = "superseded"
+	CertificateRevokedByDevice = "rotated_by_device"
+	CertificateDeviceReset     = "device_reset"
+)
+
+// DeviceCertificate is a client certificate issued to a device by the built-in CA. Revoked
+// certificates make up the revocation list.
+type DeviceCertificate struct {
+	ID               uint   `gorm:"primaryKey;autoIncrement" json:"id"`
+	DeviceUUID       string `gorm:"type:varchar(36);not null;index" json:"device_uuid"`
+	SerialNumber     string `gorm:"type:varchar(64);not null;uniqueIndex" json:"serial_number"`
+	Fingerprint      string `gorm:"type:varchar(64);not null" json:"fingerprint"` // SHA-256 of the DER certificate
+	CertificatePEM   string `gorm:"type:text;not null" json:"certificate_pem"`
+	IssuedBy         string `gorm:"type:varchar(36)" json:"issued_by,omitempty"` // user UUID, empty at registration
+	NotBefore        int64  `gorm:"not null" json:"not_before"`
+	NotAfter         int64  `gorm:"not null;index" json:"not_after"`
+	RevokedAt        int64  `gorm:"not null;default:0;index" json:"revoked_at,omitempty"`
+	RevocationReason string `gorm:"type:varchar(32)" json:"revocation_reason,omitempty"`
+	CreatedAt        int64  `gorm:"not null" json:"created_at"`
+}
+
+// Revoked reports whether the certificate has been revoked.
+func (c *DeviceCertificate) Revoked() bool {
+	return c.RevokedAt != 0
+}
@@ -0,0 +1,30 @@
+package model
+
+import "crypto/subtle"
+
+// 设备实例状态
+const (
+	InstanceStatusActive = "active"
+	// InstanceStatusReset 表示设备已硬重置，凭据作废，等待以重置码重新配网
+	InstanceStatusReset = "reset"
+)
+
+// AcceptsVerifyHash reports whether verifyHash authenticates the device at now: the current
+// verify hash, or the previous one during the grace period after a rotation.
+func (i *Instance) AcceptsVerifyHash(verifyHash string, now int64) bool {
+	if verifyHash == "" || i.VerifyHash == "" {
+		return false
+	}
+	if subtle.ConstantTimeCompare([]byte(i.VerifyHash), []byte(verifyHash)) == 1 {
+		return true
+	}
+	return i.PreviousVerifyHash != "" && now < i.PreviousVerifyExpiresAt &&
+		subtle.ConstantTimeCompare([]byte(i.PreviousVerifyHash), []byte(verifyHash)) == 1
+}
+
+// DeviceCredentialsMessage 为轮换后下发给设备的新验证码，经 data/device/{uuid}/credentials
+// 以 retained 消息发布，宽限期结束后清除
+type DeviceCredentialsMessage struct {
+	VerifyCode        string `json:"verify_code"`
+	PreviousExpiresAt int64  `json:"previous_expires_at"` // 旧验证码失效时间
+}
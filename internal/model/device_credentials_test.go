@@ -0,0 +1,31 @@
+package model
+
+import "testing"
+
+func TestInstanceAcceptsVerifyHash(t *testing.T) {
+	instance := Instance{VerifyHash: "new", PreviousVerifyHash: "old", PreviousVerifyExpiresAt: 100}
+
+	cases := []struct {
+		hash string
+		now  int64
+		want bool
+	}{
+		{"new", 50, true},
+		{"old", 50, true},
+		{"old", 100, false}, // grace period over
+		{"new", 200, true},
+		{"other", 50, false},
+		{"", 50, false},
+	}
+	for _, c := range cases {
+		if got := instance.AcceptsVerifyHash(c.hash, c.now); got != c.want {
+			t.Errorf("AcceptsVerifyHash(%q, %d) = %v, want %v", c.hash, c.now, got, c.want)
+		}
+	}
+
+	// A reset device has no valid credentials, not even the previous ones
+	reset := Instance{PreviousVerifyHash: "old", PreviousVerifyExpiresAt: 100}
+	if reset.AcceptsVerifyHash("old", 50) {
+		t.Error("reset device accepted a verify hash")
+	}
+}
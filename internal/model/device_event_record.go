@@ -0,0 +1,131 @@
+package model
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// Device event severities
+const (
+	EventSeverityInfo     = "info"
+	EventSeverityWarning  = "warning"
+	EventSeverityCritical = "critical"
+)
+
+// Reasons a device event is quarantined instead of recorded
+const (
+	EventQuarantineUnknownType      = "unknown_device_type" // the device type is not registered
+	EventQuarantineUnknownEvent     = "unknown_event"       // the event key is not defined by the device type
+	EventQuarantineInvalidPayload   = "invalid_payload"     // content is not a JSON object or fails the output params
+	EventQuarantineInvalidTimestamp = "invalid_timestamp"   // the device timestamp is outside the accepted window
+)
+
+// DeviceEventRecord is a validated event reported by a device. Its output params are stored as
+// typed rows in Params; Data holds their values keyed by name for API responses. Fields the
+// definition does not declare are kept as JSON in Extra. Times are Unix milliseconds.
+type DeviceEventRecord struct {
+	ID           uint64                 `gorm:"primaryKey;autoIncrement" json:"id"`
+	InstanceUUID string                 `gorm:"type:varchar(36);not null;index:idx_device_event_time,priority:1" json:"instance_uuid"`
+	DeviceType   string                 `gorm:"type:varchar(100);not null;index:idx_device_event_type_key,priority:1" json:"device_type"`
+	EventKey     string                 `gorm:"type:varchar(64);not null;index:idx_device_event_type_key,priority:2" json:"event_key"`
+	Severity     string                 `gorm:"type:varchar(20);not null;index" json:"severity"`
+	Timestamp    int64                  `gorm:"not null;index:idx_device_event_time,priority:2" json:"timestamp"`
+	ReceivedAt   int64                  `gorm:"not null" json:"received_at"`
+	Extra        json.RawMessage        `gorm:"type:json" json:"extra,omitempty"`
+	Params       []DeviceEventParam     `gorm:"foreignKey:EventID" json:"-"`
+	Data         map[string]interface{} `gorm:"-" json:"data"`
+}
+
+// DeviceEventParam is one output param of a recorded event. Exactly one value column is set,
+// chosen by the Go type of the converted value: int and time params use IntValue, json params
+// JSONValue.
+type DeviceEventParam struct {
+	ID          uint64          `gorm:"primaryKey;autoIncrement" json:"-"`
+	EventID     uint64          `gorm:"not null;index" json:"-"`
+	Name        string          `gorm:"type:varchar(64);not null" json:"name"`
+	Type        string          `gorm:"type:varchar(20);not null" json:"type"`
+	IntValue    *int64          `json:"int_value,omitempty"`
+	FloatValue  *float64        `json:"float_value,omitempty"`
+	BoolValue   *bool           `json:"bool_value,omitempty"`
+	StringValue *string         `gorm:"type:text" json:"string_value,omitempty"`
+	JSONValue   json.RawMessage `gorm:"type:json" json:"json_value,omitempty"`
+}
+
+// NewDeviceEventParam stores a value already converted to the param type.
+func NewDeviceEventParam(name, paramType string, value interface{}) (DeviceEventParam, error) {
+	param := DeviceEventParam{Name: name, Type: paramType}
+	switch v := value.(type) {
+	case int64:
+		param.IntValue = &v
+	case float64:
+		param.FloatValue = &v
+	case bool:
+		param.BoolValue = &v
+	case string:
+		param.StringValue = &v
+	default:
+		raw, err := json.Marshal(v)
+		if err != nil {
+			return param, err
+		}
+		param.JSONValue = raw
+	}
+	return param, nil
+}
+
+// Value returns the stored value of the param.
+func (p DeviceEventParam) Value() interface{} {
+	switch {
+	case p.IntValue != nil:
+		return *p.IntValue
+	case p.FloatValue != nil:
+		return *p.FloatValue
+	case p.BoolValue != nil:
+		return *p.BoolValue
+	case p.StringValue != nil:
+		return *p.StringValue
+	case p.JSONValue != nil:
+		return p.JSONValue
+	}
+	return nil
+}
+
+// FillData sets Data from the stored params.
+func (r *DeviceEventRecord) FillData() {
+	r.Data = make(map[string]interface{}, len(r.Params))
+	for _, param := range r.Params {
+		r.Data[param.Name] = param.Value()
+	}
+}
+
+// QuarantinedEvent is a device event that could not be recorded, kept as received so firmware
+// can be debugged. Payload is the event as the device sent it.
+type QuarantinedEvent struct {
+	ID           uint64 `gorm:"primaryKey;autoIncrement" json:"id"`
+	InstanceUUID string `gorm:"type:varchar(36);not null;index" json:"instance_uuid"`
+	DeviceType   string `gorm:"type:varchar(100)" json:"device_type"`
+	EventKey     string `gorm:"type:varchar(64);index" json:"event_key"`
+	Reason       string `gorm:"type:varchar(40);not null;index" json:"reason"`
+	Error        string `gorm:"type:varchar(500)" json:"error,omitempty"`
+	Payload      string `gorm:"type:text" json:"payload"`
+	ReceivedAt   int64  `gorm:"not null;index" json:"received_at"`
+}
+
+// NewQuarantinedEvent creates a quarantine entry received now.
+func NewQuarantinedEvent(instanceUUID, deviceType, eventKey, reason, errMsg, payload string) *QuarantinedEvent {
+	if len(errMsg) > 500 {
+		errMsg = errMsg[:500]
+	}
+	if len(payload) > 65535 {
+		payload = payload[:65535]
+	}
+	return &QuarantinedEvent{
+		InstanceUUID: instanceUUID,
+		DeviceType:   deviceType,
+		EventKey:     eventKey,
+		Reason:       reason,
+		Error:        errMsg,
+		Payload:      payload,
+		ReceivedAt:   time.Now().UnixMilli(),
+	}
+}
@@ -0,0 +1,43 @@
+package model
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestDeviceEventParam_TypedColumns(t *testing.T) {
+	values := map[string]interface{}{
+		"level":   int64(12),
+		"accel":   1.5,
+		"tripped": true,
+		"reason":  "fall_detected",
+	}
+	record := DeviceEventRecord{}
+	for name, value := range values {
+		param, err := NewDeviceEventParam(name, "", value)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		record.Params = append(record.Params, param)
+	}
+	if record.Params[0].JSONValue != nil {
+		t.Errorf("scalar values should not use the json column")
+	}
+	record.FillData()
+	for name, want := range values {
+		if got := record.Data[name]; got != want {
+			t.Errorf("%s: got %v (%T), want %v (%T)", name, got, got, want, want)
+		}
+	}
+
+	param, err := NewDeviceEventParam("location", "json", map[string]interface{}{"lat": 1.0})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if param.IntValue != nil || param.StringValue != nil || string(param.JSONValue) != `{"lat":1}` {
+		t.Errorf("json value stored as %+v", param)
+	}
+	if raw, ok := param.Value().(json.RawMessage); !ok || string(raw) != `{"lat":1}` {
+		t.Errorf("json value read back as %v", param.Value())
+	}
+}
@@ -0,0 +1,83 @@
+package model
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// ShadowDocument is a flat property key → value map stored as JSON.
+type ShadowDocument map[string]interface{}
+
+func (d ShadowDocument) Value() (driver.Value, error) {
+	if d == nil {
+		return "{}", nil
+	}
+	return json.Marshal(d)
+}
+
+func (d *ShadowDocument) Scan(value interface{}) error {
+	*d = make(ShadowDocument)
+	if value == nil {
+		return nil
+	}
+	var bytes []byte
+	switch v := value.(type) {
+	case []byte:
+		bytes = v
+	case string:
+		bytes = []byte(v)
+	default:
+		return fmt.Errorf("cannot scan %T into ShadowDocument", value)
+	}
+	return json.Unmarshal(bytes, d)
+}
+
+// DeviceShadow keeps the desired and reported state of an instance's writable properties.
+// Version increases on every change to either document.
+type DeviceShadow struct {
+	ID           uint           `gorm:"primaryKey;autoIncrement" json:"-"`
+	InstanceUUID string         `gorm:"type:varchar(36);not null;uniqueIndex" json:"instance_uuid"`
+	Desired      ShadowDocument `gorm:"type:json" json:"desired"`
+	Reported     ShadowDocument `gorm:"type:json" json:"reported"`
+	Version      int64          `gorm:"not null;default:0" json:"version"`
+	UpdatedAt    int64          `gorm:"not null" json:"updated_at"`
+}
+
+// NewDeviceShadow creates an empty shadow for an instance.
+func NewDeviceShadow(instanceUUID string) *DeviceShadow {
+	return &DeviceShadow{
+		InstanceUUID: instanceUUID,
+		Desired:      make(ShadowDocument),
+		Reported:     make(ShadowDocument),
+		UpdatedAt:    time.Now().Unix(),
+	}
+}
+
+// Delta returns the desired values that the device has not reported yet.
+func (s *DeviceShadow) Delta() ShadowDocument {
+	delta := make(ShadowDocument)
+	for key, desired := range s.Desired {
+		reported, ok := s.Reported[key]
+		if !ok || !ShadowValuesEqual(desired, reported) {
+			delta[key] = desired
+		}
+	}
+	return delta
+}
+
+// ShadowValuesEqual compares two values by their JSON encoding, so that e.g. int64(5)
+// and a float64(5) read back from the database are considered equal.
+func ShadowValuesEqual(a, b interface{}) bool {
+	aj, errA := json.Marshal(a)
+	bj, errB := json.Marshal(b)
+	return errA == nil && errB == nil && string(aj) == string(bj)
+}
+
+// ShadowDeltaMessage is published to data/device/{uuid}/shadow/delta.
+type ShadowDeltaMessage struct {
+	Version   int64          `json:"version"`
+	State     ShadowDocument `json:"state"`
+	Timestamp int64          `json:"timestamp"`
+}
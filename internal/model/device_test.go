@@ -0,0 +1,29 @@
+package model
+
+import "testing"
+
+func TestLoadDeviceTypeFromYAML(t *testing.T) {
+	manager := &DeviceTypeManager{}
+	if err := manager.LoadDeviceTypeFromYAML("../config/device_type_list.yaml"); err != nil {
+		t.Fatalf("load: %v", err)
+	}
+
+	tracker, ok := manager.GetByName("BaseTracker")
+	if !ok {
+		t.Fatal("BaseTracker not loaded")
+	}
+	if action := tracker.Actions["sos_trigger"]; action.TimeoutSec != 10 || action.Idempotent {
+		t.Errorf("sos_trigger = %+v, want a 10s non-idempotent action", action)
+	}
+	if action := tracker.Actions["update_config"]; action.TimeoutSec != 60 || !action.Idempotent || len(action.InputParams) != 2 || !action.InputParams[0].Required {
+		t.Errorf("update_config = %+v", action)
+	}
+
+	reporter, ok := manager.GetByName("NewsReporter")
+	if !ok {
+		t.Fatal("NewsReporter not loaded")
+	}
+	if timeout := reporter.Actions["execute_now"].TimeoutSec; timeout != 600 {
+		t.Errorf("execute_now timeout = %d, want 600", timeout)
+	}
+}
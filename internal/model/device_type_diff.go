@@ -0,0 +1,112 @@
+package model
+
+import (
+	"reflect"
+	"sort"
+)
+
+// DeviceTypeDiff describes what changed between two sets of device type definitions.
+type DeviceTypeDiff struct {
+	AddedTypes    []string           `json:"added_types,omitempty"`
+	RemovedTypes  []string           `json:"removed_types,omitempty"`
+	ModifiedTypes []DeviceTypeChange `json:"modified_types,omitempty"`
+}
+
+// DeviceTypeChange lists the changed members of one device type that exists in both sets.
+type DeviceTypeChange struct {
+	Name                string   `json:"name"`
+	FromVersion         string   `json:"from_version,omitempty"` // set only when the version changed
+	ToVersion           string   `json:"to_version,omitempty"`
+	DescriptionChanged  bool     `json:"description_changed,omitempty"`
+	CapabilitiesChanged bool     `json:"capabilities_changed,omitempty"`
+	DeprecatedChanged   bool     `json:"deprecated_changed,omitempty"`
+	AddedProperties     []string `json:"added_properties,omitempty"`
+	RemovedProperties   []string `json:"removed_properties,omitempty"`
+	ModifiedProperties  []string `json:"modified_properties,omitempty"`
+	AddedActions        []string `json:"added_actions,omitempty"`
+	RemovedActions      []string `json:"removed_actions,omitempty"`
+	ModifiedActions     []string `json:"modified_actions,omitempty"`
+	AddedEvents         []string `json:"added_events,omitempty"`
+	RemovedEvents       []string `json:"removed_events,omitempty"`
+	ModifiedEvents      []string `json:"modified_events,omitempty"`
+}
+
+// IsEmpty reports whether the two sets were identical.
+func (d DeviceTypeDiff) IsEmpty() bool {
+	return len(d.AddedTypes) == 0 && len(d.RemovedTypes) == 0 && len(d.ModifiedTypes) == 0
+}
+
+// VersionChanged reports whether the type got a new version. Instances of such a type keep
+// their property set until they are migrated explicitly.
+func (c DeviceTypeChange) VersionChanged() bool {
+	return c.FromVersion != c.ToVersion
+}
+
+// PropertiesChanged reports whether instances of the type need their property set refreshed.
+func (c DeviceTypeChange) PropertiesChanged() bool {
+	return len(c.AddedProperties) > 0 || len(c.RemovedProperties) > 0 || len(c.ModifiedProperties) > 0
+}
+
+// DiffDeviceTypes compares the currently loaded definitions with a new set. All name lists are sorted.
+func DiffDeviceTypes(current map[string]*DeviceType, next []DeviceType) DeviceTypeDiff {
+	var diff DeviceTypeDiff
+	seen := make(map[string]bool, len(next))
+
+	for i := range next {
+		dt := &next[i]
+		seen[dt.Name] = true
+		old, ok := current[dt.Name]
+		if !ok {
+			diff.AddedTypes = append(diff.AddedTypes, dt.Name)
+			continue
+		}
+		change := DeviceTypeChange{
+			Name:                dt.Name,
+			DescriptionChanged:  old.Description != dt.Description,
+			CapabilitiesChanged: !reflect.DeepEqual(old.Capabilities, dt.Capabilities),
+			DeprecatedChanged:   old.Deprecated != dt.Deprecated,
+		}
+		if old.Version != dt.Version {
+			change.FromVersion, change.ToVersion = old.Version, dt.Version
+		}
+		change.AddedProperties, change.RemovedProperties, change.ModifiedProperties = diffMembers(old.Properties, dt.Properties)
+		change.AddedActions, change.RemovedActions, change.ModifiedActions = diffMembers(old.Actions, dt.Actions)
+		change.AddedEvents, change.RemovedEvents, change.ModifiedEvents = diffMembers(old.Events, dt.Events)
+		if !reflect.DeepEqual(change, DeviceTypeChange{Name: dt.Name}) {
+			diff.ModifiedTypes = append(diff.ModifiedTypes, change)
+		}
+	}
+
+	for name := range current {
+		if !seen[name] {
+			diff.RemovedTypes = append(diff.RemovedTypes, name)
+		}
+	}
+
+	sort.Strings(diff.AddedTypes)
+	sort.Strings(diff.RemovedTypes)
+	sort.Slice(diff.ModifiedTypes, func(i, j int) bool {
+		return diff.ModifiedTypes[i].Name < diff.ModifiedTypes[j].Name
+	})
+	return diff
+}
+
+func diffMembers[T any](old, next map[string]T) (added, removed, modified []string) {
+	for key, value := range next {
+		oldValue, ok := old[key]
+		if !ok {
+			added = append(added, key)
+		} else if !reflect.DeepEqual(oldValue, value) {
+			modified = append(modified, key)
+		}
+	}
+	for key := range old {
+		if _, ok := next[key]; !ok {
+			removed = append(removed, key)
+		}
+	}
+	sort.Strings(added)
+	sort.Strings(removed)
+	sort.Strings(modified)
+	return added, removed, modified
+}
@@ -0,0 +1,65 @@
+package model
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestDiffDeviceTypes(t *testing.T) {
+	current := map[string]*DeviceType{
+		"Tracker": {
+			ID:   1,
+			Name: "Tracker",
+			Properties: map[string]PropertyMeta{
+				"battery": {Format: "int"},
+				"signal":  {Format: "int"},
+			},
+			Actions: map[string]ActionMeta{"reboot": {TimeoutSec: 30}},
+		},
+		"Lamp": {ID: 2, Name: "Lamp"},
+		"Old":  {ID: 3, Name: "Old"},
+	}
+	next := []DeviceType{
+		{
+			ID:   1,
+			Name: "Tracker",
+			Properties: map[string]PropertyMeta{
+				"battery":     {Format: "int", Range: []float64{0, 100}},
+				"temperature": {Format: "float"},
+			},
+			Actions: map[string]ActionMeta{"reboot": {TimeoutSec: 30}},
+			Events:  map[string]EventMeta{"sos": {Severity: "critical"}},
+		},
+		{ID: 2, Name: "Lamp"},
+		{ID: 4, Name: "New"},
+	}
+
+	diff := DiffDeviceTypes(current, next)
+
+	if !reflect.DeepEqual(diff.AddedTypes, []string{"New"}) {
+		t.Errorf("added types = %v", diff.AddedTypes)
+	}
+	if !reflect.DeepEqual(diff.RemovedTypes, []string{"Old"}) {
+		t.Errorf("removed types = %v", diff.RemovedTypes)
+	}
+	if len(diff.ModifiedTypes) != 1 {
+		t.Fatalf("expected only Tracker to be modified, got %+v", diff.ModifiedTypes)
+	}
+	want := DeviceTypeChange{
+		Name:               "Tracker",
+		AddedProperties:    []string{"temperature"},
+		RemovedProperties:  []string{"signal"},
+		ModifiedProperties: []string{"battery"},
+		AddedEvents:        []string{"sos"},
+	}
+	if !reflect.DeepEqual(diff.ModifiedTypes[0], want) {
+		t.Errorf("change = %+v, want %+v", diff.ModifiedTypes[0], want)
+	}
+}
+
+func TestDiffDeviceTypes_NoChanges(t *testing.T) {
+	current := map[string]*DeviceType{"Lamp": {ID: 2, Name: "Lamp"}}
+	if diff := DiffDeviceTypes(current, []DeviceType{{ID: 2, Name: "Lamp"}}); !diff.IsEmpty() {
+		t.Errorf("expected empty diff, got %+v", diff)
+	}
+}
@@ -0,0 +1,97 @@
+package model
+
+import (
+	"bytes"
+	"encoding/json"
+	"sort"
+)
+
+// PropertyRetype describes a property whose format differs between an instance and its type.
+type PropertyRetype struct {
+	Key        string `json:"key"`
+	FromFormat string `json:"from_format"`
+	ToFormat   string `json:"to_format"`
+	// ValueKept is false when the current value cannot be converted and is replaced by the default.
+	ValueKept bool `json:"value_kept"`
+	// HistoryArchivedAs is set when the storage type changes: the recorded points are moved to
+	// this series before the property's timeseries is recreated with the new type.
+	HistoryArchivedAs string `json:"history_archived_as,omitempty"`
+}
+
+// InstanceMigration is the migration of one instance to the loaded version of its device type.
+type InstanceMigration struct {
+	InstanceUUID      string           `json:"instance_uuid"`
+	FromVersion       string           `json:"from_version"`
+	ToVersion         string           `json:"to_version"`
+	AddedProperties   []string         `json:"added_properties,omitempty"`
+	RemovedProperties []string         `json:"removed_properties,omitempty"`
+	RetypedProperties []PropertyRetype `json:"retyped_properties,omitempty"`
+	UpdatedProperties []string         `json:"updated_properties,omitempty"` // meta changes that keep the format
+	Migrated          bool             `json:"migrated"`
+	Error             string           `json:"error,omitempty"`
+}
+
+// UpToDate reports whether the instance already matches the type version.
+func (m InstanceMigration) UpToDate() bool {
+	return m.FromVersion == m.ToVersion && len(m.AddedProperties) == 0 && len(m.RemovedProperties) == 0 &&
+		len(m.RetypedProperties) == 0 && len(m.UpdatedProperties) == 0
+}
+
+// DeviceTypeMigrationReport summarizes a migration run over the instances of one device type.
+type DeviceTypeMigrationReport struct {
+	TypeName      string              `json:"type_name"`
+	TargetVersion string              `json:"target_version"`
+	DryRun        bool                `json:"dry_run"`
+	Total         int                 `json:"total"`
+	UpToDate      int                 `json:"up_to_date"`
+	Migrated      int                 `json:"migrated"`
+	Failed        int                 `json:"failed"`
+	Instances     []InstanceMigration `json:"instances"`
+}
+
+// PlanInstanceMigration compares the property snapshot of an instance with a device type.
+// Retype details that depend on value conversion and storage are filled in by the caller.
+func PlanInstanceMigration(instance *Instance, deviceType *DeviceType) InstanceMigration {
+	plan := InstanceMigration{
+		InstanceUUID: instance.InstanceUUID,
+		FromVersion:  instance.TypeVersion,
+		ToVersion:    deviceType.Version,
+	}
+
+	for key, meta := range deviceType.Properties {
+		item := instance.Properties.Items[key]
+		switch {
+		case item == nil:
+			plan.AddedProperties = append(plan.AddedProperties, key)
+		case item.Meta.Format != meta.Format:
+			plan.RetypedProperties = append(plan.RetypedProperties, PropertyRetype{
+				Key:        key,
+				FromFormat: item.Meta.Format,
+				ToFormat:   meta.Format,
+			})
+		case !samePropertyMeta(item.Meta, meta):
+			plan.UpdatedProperties = append(plan.UpdatedProperties, key)
+		}
+	}
+	for key := range instance.Properties.Items {
+		if _, ok := deviceType.Properties[key]; !ok {
+			plan.RemovedProperties = append(plan.RemovedProperties, key)
+		}
+	}
+
+	sort.Strings(plan.AddedProperties)
+	sort.Strings(plan.RemovedProperties)
+	sort.Strings(plan.UpdatedProperties)
+	sort.Slice(plan.RetypedProperties, func(i, j int) bool {
+		return plan.RetypedProperties[i].Key < plan.RetypedProperties[j].Key
+	})
+	return plan
+}
+
+// samePropertyMeta compares metas by their JSON form, since the instance snapshot has been
+// through a JSON round trip (e.g. an int default comes back as float64).
+func samePropertyMeta(a, b PropertyMeta) bool {
+	aJSON, errA := json.Marshal(a)
+	bJSON, errB := json.Marshal(b)
+	return errA == nil && errB == nil && bytes.Equal(aJSON, bJSON)
+}
@@ -0,0 +1,70 @@
+package model
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestPlanInstanceMigration(t *testing.T) {
+	instance := &Instance{
+		InstanceUUID: "dev-1",
+		TypeVersion:  "1.0.0",
+		Properties: Properties{Items: map[string]*TypedInstancePropertyItem{
+			"battery": {Meta: PropertyMeta{Format: "int", Default: float64(100)}},
+			"signal":  {Meta: PropertyMeta{Format: "int"}},
+			"status":  {Meta: PropertyMeta{Format: "string", Description: "old"}},
+			"mode":    {Meta: PropertyMeta{Format: "string"}},
+		}},
+	}
+	deviceType := &DeviceType{
+		Name:    "Tracker",
+		Version: "2.0.0",
+		Properties: map[string]PropertyMeta{
+			"battery":     {Format: "int", Default: 100},
+			"signal":      {Format: "float"},
+			"status":      {Format: "string", Description: "new"},
+			"temperature": {Format: "float"},
+		},
+	}
+
+	plan := PlanInstanceMigration(instance, deviceType)
+
+	if plan.FromVersion != "1.0.0" || plan.ToVersion != "2.0.0" {
+		t.Errorf("versions = %s -> %s", plan.FromVersion, plan.ToVersion)
+	}
+	if !reflect.DeepEqual(plan.AddedProperties, []string{"temperature"}) {
+		t.Errorf("added = %v", plan.AddedProperties)
+	}
+	if !reflect.DeepEqual(plan.RemovedProperties, []string{"mode"}) {
+		t.Errorf("removed = %v", plan.RemovedProperties)
+	}
+	if !reflect.DeepEqual(plan.UpdatedProperties, []string{"status"}) {
+		t.Errorf("updated = %v (an int default read back as float64 must not count as a change)", plan.UpdatedProperties)
+	}
+	want := []PropertyRetype{{Key: "signal", FromFormat: "int", ToFormat: "float"}}
+	if !reflect.DeepEqual(plan.RetypedProperties, want) {
+		t.Errorf("retyped = %+v", plan.RetypedProperties)
+	}
+	if plan.UpToDate() {
+		t.Error("expected plan not to be up to date")
+	}
+}
+
+func TestParseDeviceTypes_InheritsFileVersion(t *testing.T) {
+	data := []byte(`
+version: "1.2.0"
+device_types:
+  - id: 1
+    name: "Tracker"
+  - id: 2
+    name: "Lamp"
+    version: "2.0.0"
+`)
+	types, err := ParseDeviceTypes(data)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if types[0].Version != "1.2.0" || types[1].Version != "2.0.0" {
+		t.Errorf("versions = %q, %q", types[0].Version, types[1].Version)
+	}
+}
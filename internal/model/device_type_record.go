@@ -0,0 +1,115 @@
+package model
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// DeviceTypeRecord is a device type definition stored in MySQL, which is the source of truth
+// for the loaded types. The columns mirror the identifying fields of the definition so they
+// can be indexed; Definition holds the full type as JSON.
+type DeviceTypeRecord struct {
+	ID         uint            `gorm:"primaryKey;autoIncrement" json:"-"`
+	TypeID     int             `gorm:"not null;uniqueIndex" json:"type_id"`
+	Name       string          `gorm:"type:varchar(50);not null;uniqueIndex" json:"name"`
+	Version    string          `gorm:"type:varchar(32)" json:"version"`
+	Deprecated bool            `gorm:"not null;default:false" json:"deprecated"`
+	Definition json.RawMessage `gorm:"type:json" json:"definition"`
+	CreatedAt  int64           `gorm:"not null" json:"created_at"`
+	UpdatedAt  int64           `gorm:"not null" json:"updated_at"`
+}
+
+func (DeviceTypeRecord) TableName() string {
+	return "device_types"
+}
+
+// NewDeviceTypeRecord creates a record holding the given definition.
+func NewDeviceTypeRecord(dt DeviceType) (*DeviceTypeRecord, error) {
+	record := &DeviceTypeRecord{CreatedAt: time.Now().Unix()}
+	if err := record.SetDefinition(dt); err != nil {
+		return nil, err
+	}
+	return record, nil
+}
+
+// SetDefinition replaces the stored definition and the mirrored columns.
+func (r *DeviceTypeRecord) SetDefinition(dt DeviceType) error {
+	definition, err := json.Marshal(dt)
+	if err != nil {
+		return err
+	}
+	r.TypeID = dt.ID
+	r.Name = dt.Name
+	r.Version = dt.Version
+	r.Deprecated = dt.Deprecated
+	r.Definition = definition
+	r.UpdatedAt = time.Now().Unix()
+	return nil
+}
+
+// DeviceType decodes the stored definition.
+func (r *DeviceTypeRecord) DeviceType() (DeviceType, error) {
+	return decodeDeviceType(r.Definition)
+}
+
+// CloneDeviceType returns a deep copy of dt in the form it has after a round trip through the
+// store, so that definitions from YAML compare equal to the ones read back from MySQL
+// (e.g. an int default becomes float64).
+func CloneDeviceType(dt DeviceType) (DeviceType, error) {
+	data, err := json.Marshal(dt)
+	if err != nil {
+		return DeviceType{}, err
+	}
+	return decodeDeviceType(data)
+}
+
+// decodeDeviceType decodes a JSON definition and drops empty collections, which YAML and
+// JSON input leave either nil or empty depending on how they were written.
+func decodeDeviceType(data []byte) (DeviceType, error) {
+	var dt DeviceType
+	if err := json.Unmarshal(data, &dt); err != nil {
+		return DeviceType{}, err
+	}
+
+	dt.Properties = nilIfEmpty(dt.Properties)
+	dt.Capabilities = nilIfEmpty(dt.Capabilities)
+	dt.Actions = nilIfEmpty(dt.Actions)
+	dt.Events = nilIfEmpty(dt.Events)
+	for key, meta := range dt.Properties {
+		meta.Range = nilIfNone(meta.Range)
+		meta.Enum = nilIfNone(meta.Enum)
+		meta.RequiredCapabilities = nilIfNone(meta.RequiredCapabilities)
+		dt.Properties[key] = meta
+	}
+	for key, action := range dt.Actions {
+		action.InputParams = nilIfNone(action.InputParams)
+		for i := range action.InputParams {
+			action.InputParams[i].Range = nilIfNone(action.InputParams[i].Range)
+			action.InputParams[i].Enum = nilIfNone(action.InputParams[i].Enum)
+		}
+		dt.Actions[key] = action
+	}
+	for key, event := range dt.Events {
+		event.OutputParams = nilIfNone(event.OutputParams)
+		event.RequiredCapabilities = nilIfNone(event.RequiredCapabilities)
+		for i := range event.OutputParams {
+			event.OutputParams[i].Enum = nilIfNone(event.OutputParams[i].Enum)
+		}
+		dt.Events[key] = event
+	}
+	return dt, nil
+}
+
+func nilIfEmpty[T any](m map[string]T) map[string]T {
+	if len(m) == 0 {
+		return nil
+	}
+	return m
+}
+
+func nilIfNone[T any](s []T) []T {
+	if len(s) == 0 {
+		return nil
+	}
+	return s
+}
@@ -0,0 +1,65 @@
+package model
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestDeviceTypeYAMLRoundTrip(t *testing.T) {
+	original := []DeviceType{
+		{
+			ID:          7,
+			Name:        "Lamp",
+			Version:     "2.0.0",
+			Description: "dimmable lamp",
+			Properties: map[string]PropertyMeta{
+				"brightness": {Format: "int", Writable: true, Range: []float64{0, 100}, Default: 50},
+			},
+			Actions: map[string]ActionMeta{
+				"blink": {TimeoutSec: 10, InputParams: []InputParam{{Name: "times", Type: "int", Required: true}}},
+			},
+			Events:     map[string]EventMeta{"overheat": {Severity: "warning", RetentionDays: 30}},
+			Deprecated: true,
+		},
+	}
+
+	data, err := MarshalDeviceTypes(original)
+	if err != nil {
+		t.Fatalf("marshal: %v", err)
+	}
+	parsed, err := ParseDeviceTypes(data)
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	if len(parsed) != 1 {
+		t.Fatalf("expected 1 device type, got %d", len(parsed))
+	}
+
+	want, _ := CloneDeviceType(original[0])
+	got, _ := CloneDeviceType(parsed[0])
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("round trip mismatch:\n got  %+v\n want %+v", got, want)
+	}
+}
+
+func TestDeviceTypeRecordDefinition(t *testing.T) {
+	dt := DeviceType{ID: 3, Name: "Meter", Version: "1.1", Deprecated: true,
+		Properties: map[string]PropertyMeta{"reading": {Format: "float", Default: 0}}}
+
+	record, err := NewDeviceTypeRecord(dt)
+	if err != nil {
+		t.Fatalf("new record: %v", err)
+	}
+	if record.TypeID != 3 || record.Name != "Meter" || record.Version != "1.1" || !record.Deprecated {
+		t.Errorf("mirrored columns not set: %+v", record)
+	}
+
+	stored, err := record.DeviceType()
+	if err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	want, _ := CloneDeviceType(dt)
+	if !reflect.DeepEqual(stored, want) {
+		t.Errorf("stored definition = %+v, want %+v", stored, want)
+	}
+}
@@ -0,0 +1,17 @@
+package model
+
+import "encoding/json"
+
+// EventDeadLetter is an event bus event whose handler kept failing. Once the cause is fixed it
+// can be replayed to the consumer group that failed.
+type EventDeadLetter struct {
+	ID         uint            `gorm:"primaryKey;autoIncrement" json:"id"`
+	GroupName  string          `gorm:"type:varchar(191);not null;index" json:"group"`
+	EventType  string          `gorm:"type:varchar(64);not null;index" json:"event_type"`
+	Kind       string          `gorm:"type:varchar(64)" json:"kind,omitempty"` // empty if the event cannot be replayed
+	Payload    json.RawMessage `gorm:"type:json" json:"payload"`
+	Error      string          `gorm:"type:varchar(1000)" json:"error"`
+	Attempts   int             `gorm:"not null" json:"attempts"`
+	FailedAt   int64           `gorm:"not null;index" json:"failed_at"`
+	ReplayedAt int64           `gorm:"not null;default:0" json:"replayed_at,omitempty"`
+}
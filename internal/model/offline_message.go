@@ -0,0 +1,19 @@
+package model
+
+import "encoding/json"
+
+// OfflineMessage is a warning or critical device event pushed to a user over WebSocket. It is kept
+// in the user's inbox and pushed again on the next connection until a socket acknowledges it.
+// Times are Unix seconds; DeliveredAt and ReadAt are 0 until set.
+type OfflineMessage struct {
+	ID          uint64          `gorm:"primaryKey;autoIncrement" json:"id"`
+	UserUUID    string          `gorm:"type:varchar(36);not null;uniqueIndex:idx_offline_message_event,priority:1" json:"-"`
+	EventID     uint64          `gorm:"not null;uniqueIndex:idx_offline_message_event,priority:2" json:"event_id"` // DeviceEventRecord.ID
+	DeviceUUID  string          `gorm:"type:varchar(36);not null" json:"device_uuid"`
+	EventKey    string          `gorm:"type:varchar(64);not null" json:"event_key"`
+	Severity    string          `gorm:"type:varchar(20);not null" json:"severity"`
+	Data        json.RawMessage `gorm:"type:json" json:"data,omitempty"`
+	CreatedAt   int64           `gorm:"not null;index" json:"created_at"`
+	DeliveredAt int64           `gorm:"not null;default:0" json:"delivered_at,omitempty"`
+	ReadAt      int64           `gorm:"not null;default:0" json:"read_at,omitempty"`
+}
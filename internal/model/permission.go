@@ -29,8 +29,12 @@ const (
 	PermAdminManage Permission = "admin:manage" // promote / demote admins
 
 	// System
-	PermSystemStats Permission = "system:stats" // view system statistics
-	PermSystemLogs  Permission = "system:logs"  // view admin operation logs
+	PermSystemStats       Permission = "system:stats"        // view system statistics
+	PermSystemLogs        Permission = "system:logs"         // view admin operation logs
+	PermSystemWebhooks    Permission = "system:webhooks"     // manage global webhooks
+	PermSystemDeviceTypes Permission = "system:device_types" // reload device type definitions
+	PermSystemRetention   Permission = "system:retention"    // view and override data retention
+	PermSystemEvents      Permission = "system:events"       // inspect the event bus and replay dead letters
 )
 
 // rolePermissions defines which permissions each role has.
@@ -45,7 +49,8 @@ var rolePermissions = map[Role]map[Permission]bool{
 		PermUserView: true, PermUserEdit: true, PermUserStatus: true, PermUserReset: true,
 		PermDeviceView: true, PermDeviceEdit: true, PermDeviceDelete: true, PermDeviceTransfer: true,
 		PermGroupView: true, PermGroupManage: true,
-		PermSystemStats: true, PermSystemLogs: true,
+		PermSystemStats: true, PermSystemLogs: true, PermSystemWebhooks: true, PermSystemDeviceTypes: true,
+		PermSystemRetention: true, PermSystemEvents: true,
 	},
 	RoleSuperAdmin: {
 		// all permissions
@@ -54,7 +59,8 @@ var rolePermissions = map[Role]map[Permission]bool{
 		PermDeviceView: true, PermDeviceEdit: true, PermDeviceDelete: true, PermDeviceTransfer: true,
 		PermGroupView: true, PermGroupManage: true,
 		PermAdminView: true, PermAdminManage: true,
-		PermSystemStats: true, PermSystemLogs: true,
+		PermSystemStats: true, PermSystemLogs: true, PermSystemWebhooks: true, PermSystemDeviceTypes: true,
+		PermSystemRetention: true, PermSystemEvents: true,
 	},
 }
 
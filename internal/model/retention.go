@@ -0,0 +1,106 @@
+package model
+
+// Retention override scopes
+const (
+	RetentionScopeType     = "type"     // default of all properties and events of a device type
+	RetentionScopeProperty = "property" // one property's telemetry
+	RetentionScopeEvent    = "event"    // one event
+	RetentionScopeLog      = "log"      // the device log of a type's devices
+)
+
+// Sources of a resolved retention period, from most to least specific
+const (
+	RetentionSourceOverride       = "override"        // admin override of the property, event or log
+	RetentionSourceDefinition     = "definition"      // retention_days of the property or event
+	RetentionSourceTypeOverride   = "type_override"   // admin override of the device type
+	RetentionSourceTypeDefinition = "type_definition" // retention_days of the device type
+	RetentionSourceDefault        = "default"         // global default
+)
+
+// RetentionOverride is an admin override of a retention period. It takes precedence over the
+// device type definition. Key names the property or event and is empty for the type and log
+// scopes. RetentionDays of 0 keeps the data forever.
+type RetentionOverride struct {
+	ID            uint   `gorm:"primaryKey;autoIncrement" json:"id"`
+	DeviceType    string `gorm:"type:varchar(100);not null;uniqueIndex:idx_retention_target" json:"device_type"`
+	Scope         string `gorm:"type:varchar(20);not null;uniqueIndex:idx_retention_target" json:"scope"`
+	Key           string `gorm:"type:varchar(100);not null;default:'';uniqueIndex:idx_retention_target" json:"key,omitempty"`
+	RetentionDays int    `gorm:"not null" json:"retention_days"`
+	UpdatedBy     string `gorm:"type:varchar(36)" json:"updated_by"`
+	CreatedAt     int64  `json:"created_at"`
+	UpdatedAt     int64  `json:"updated_at"`
+}
+
+// ResolvedRetention is the retention period in effect for some data and where it came from.
+// Days of 0 keeps the data forever.
+type ResolvedRetention struct {
+	Days   int    `json:"days"`
+	Source string `json:"source"`
+}
+
+// RetentionPolicy holds the resolved retention periods of one device type.
+type RetentionPolicy struct {
+	DeviceType string                       `json:"device_type"`
+	Type       ResolvedRetention            `json:"type"`
+	Properties map[string]ResolvedRetention `json:"properties"`
+	Events     map[string]ResolvedRetention `json:"events"`
+	Log        ResolvedRetention            `json:"log"`
+}
+
+// PropertyDays returns the retention of a property. Properties no longer in the definition,
+// kept by instances that were not migrated, use the type's retention.
+func (p RetentionPolicy) PropertyDays(key string) int {
+	if resolved, ok := p.Properties[key]; ok {
+		return resolved.Days
+	}
+	return p.Type.Days
+}
+
+// ResolveRetention resolves the retention periods of a device type. For each property and
+// event the first set value wins: its override, its definition, the type override, the type
+// definition, the global default. The device log follows the type unless it is overridden.
+func ResolveRetention(dt *DeviceType, overrides []RetentionOverride, defaultDays int) RetentionPolicy {
+	find := func(scope, key string) *RetentionOverride {
+		for i := range overrides {
+			o := &overrides[i]
+			if o.DeviceType == dt.Name && o.Scope == scope && o.Key == key {
+				return o
+			}
+		}
+		return nil
+	}
+
+	policy := RetentionPolicy{
+		DeviceType: dt.Name,
+		Type:       ResolvedRetention{Days: defaultDays, Source: RetentionSourceDefault},
+		Properties: make(map[string]ResolvedRetention, len(dt.Properties)),
+		Events:     make(map[string]ResolvedRetention, len(dt.Events)),
+	}
+	if o := find(RetentionScopeType, ""); o != nil {
+		policy.Type = ResolvedRetention{Days: o.RetentionDays, Source: RetentionSourceTypeOverride}
+	} else if dt.RetentionDays > 0 {
+		policy.Type = ResolvedRetention{Days: dt.RetentionDays, Source: RetentionSourceTypeDefinition}
+	}
+
+	member := func(scope, key string, definitionDays int) ResolvedRetention {
+		if o := find(scope, key); o != nil {
+			return ResolvedRetention{Days: o.RetentionDays, Source: RetentionSourceOverride}
+		}
+		if definitionDays > 0 {
+			return ResolvedRetention{Days: definitionDays, Source: RetentionSourceDefinition}
+		}
+		return policy.Type
+	}
+	for key, meta := range dt.Properties {
+		policy.Properties[key] = member(RetentionScopeProperty, key, meta.RetentionDays)
+	}
+	for key, meta := range dt.Events {
+		policy.Events[key] = member(RetentionScopeEvent, key, meta.RetentionDays)
+	}
+
+	policy.Log = policy.Type
+	if o := find(RetentionScopeLog, ""); o != nil {
+		policy.Log = ResolvedRetention{Days: o.RetentionDays, Source: RetentionSourceOverride}
+	}
+	return policy
+}
@@ -0,0 +1,68 @@
+package model
+
+import "testing"
+
+func retentionTestType() *DeviceType {
+	return &DeviceType{
+		Name: "Sensor",
+		Properties: map[string]PropertyMeta{
+			"temperature": {Format: "float", RetentionDays: 90},
+			"humidity":    {Format: "float"},
+		},
+		Events: map[string]EventMeta{
+			"overheat": {RetentionDays: 365},
+			"reboot":   {},
+		},
+	}
+}
+
+func TestResolveRetention_Precedence(t *testing.T) {
+	dt := retentionTestType()
+	policy := ResolveRetention(dt, nil, 30)
+
+	want := map[string]ResolvedRetention{
+		"temperature": {Days: 90, Source: RetentionSourceDefinition},
+		"humidity":    {Days: 30, Source: RetentionSourceDefault},
+	}
+	for key, w := range want {
+		if got := policy.Properties[key]; got != w {
+			t.Errorf("property %s: got %+v, want %+v", key, got, w)
+		}
+	}
+	if got := policy.Log; got != (ResolvedRetention{Days: 30, Source: RetentionSourceDefault}) {
+		t.Errorf("log should follow the type, got %+v", got)
+	}
+
+	dt.RetentionDays = 180
+	overrides := []RetentionOverride{
+		{DeviceType: "Sensor", Scope: RetentionScopeProperty, Key: "temperature", RetentionDays: 7},
+		{DeviceType: "Other", Scope: RetentionScopeProperty, Key: "humidity", RetentionDays: 1},
+	}
+	policy = ResolveRetention(dt, overrides, 30)
+	if got := policy.Properties["temperature"]; got != (ResolvedRetention{Days: 7, Source: RetentionSourceOverride}) {
+		t.Errorf("override should win, got %+v", got)
+	}
+	if got := policy.Properties["humidity"]; got != (ResolvedRetention{Days: 180, Source: RetentionSourceTypeDefinition}) {
+		t.Errorf("type definition should apply, got %+v", got)
+	}
+	if got := policy.PropertyDays("removed_property"); got != 180 {
+		t.Errorf("unknown property should use the type retention, got %d", got)
+	}
+
+	overrides = append(overrides, RetentionOverride{DeviceType: "Sensor", Scope: RetentionScopeType, RetentionDays: 0})
+	policy = ResolveRetention(dt, overrides, 30)
+	if got := policy.Events["reboot"]; got != (ResolvedRetention{Days: 0, Source: RetentionSourceTypeOverride}) {
+		t.Errorf("type override should apply to events, got %+v", got)
+	}
+	if got := policy.Log; got != (ResolvedRetention{Days: 0, Source: RetentionSourceTypeOverride}) {
+		t.Errorf("log should follow the type override, got %+v", got)
+	}
+}
+
+func TestResolveRetention_LogOverride(t *testing.T) {
+	overrides := []RetentionOverride{{DeviceType: "Sensor", Scope: RetentionScopeLog, RetentionDays: 14}}
+	policy := ResolveRetention(retentionTestType(), overrides, 30)
+	if got := policy.Log; got != (ResolvedRetention{Days: 14, Source: RetentionSourceOverride}) {
+		t.Errorf("log override should win over the type, got %+v", got)
+	}
+}
@@ -0,0 +1,92 @@
+package model
+
+import (
+	"OMEGA3-IOT/internal/utils"
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// TelemetryExportJob status constants
+const (
+	TelemetryExportPending   = "pending"
+	TelemetryExportRunning   = "running"
+	TelemetryExportSucceeded = "succeeded"
+	TelemetryExportFailed    = "failed"
+	TelemetryExportExpired   = "expired"
+)
+
+// TelemetryExport scope constants
+const (
+	TelemetryExportScopeDevice = "device"
+	TelemetryExportScopeFolder = "folder"
+	TelemetryExportScopeGroup  = "group"
+)
+
+// TelemetryExportProperties is the requested property list stored as a JSON array.
+type TelemetryExportProperties []string
+
+func (p TelemetryExportProperties) Value() (driver.Value, error) {
+	if p == nil {
+		return "[]", nil
+	}
+	return json.Marshal(p)
+}
+
+func (p *TelemetryExportProperties) Scan(value interface{}) error {
+	*p = nil
+	if value == nil {
+		return nil
+	}
+	var bytes []byte
+	switch v := value.(type) {
+	case []byte:
+		bytes = v
+	case string:
+		bytes = []byte(v)
+	default:
+		return fmt.Errorf("cannot scan %T into TelemetryExportProperties", value)
+	}
+	return json.Unmarshal(bytes, p)
+}
+
+// TelemetryExportJob is a background export of a device, folder or group share. The result
+// file is written to the export directory and deleted once the job expires.
+// StartTime and EndTime are Unix seconds.
+type TelemetryExportJob struct {
+	ID          uint                      `gorm:"primaryKey;autoIncrement" json:"-"`
+	JobUUID     string                    `gorm:"type:varchar(36);not null;uniqueIndex" json:"job_uuid"`
+	OwnerUUID   string                    `gorm:"type:varchar(36);not null;index" json:"owner_uuid"`
+	Scope       string                    `gorm:"type:varchar(20);not null" json:"scope"`
+	TargetUUID  string                    `gorm:"type:varchar(36);not null" json:"target_uuid"`
+	StartTime   int64                     `gorm:"not null" json:"start_timestamp"`
+	EndTime     int64                     `gorm:"not null" json:"end_timestamp"`
+	Properties  TelemetryExportProperties `gorm:"type:json" json:"properties"`
+	Format      string                    `gorm:"type:varchar(20);not null" json:"format"`
+	Status      string                    `gorm:"type:varchar(20);not null;default:'pending';index" json:"status"`
+	Rows        int64                     `gorm:"not null;default:0" json:"rows"`
+	SizeBytes   int64                     `gorm:"not null;default:0" json:"size_bytes"`
+	FilePath    string                    `gorm:"type:varchar(512)" json:"-"`
+	Error       string                    `gorm:"type:varchar(500)" json:"error,omitempty"`
+	CreatedAt   int64                     `gorm:"not null;index" json:"created_at"`
+	StartedAt   int64                     `json:"started_at,omitempty"`
+	CompletedAt int64                     `json:"completed_at,omitempty"`
+	ExpiresAt   int64                     `gorm:"index" json:"expires_at,omitempty"`
+}
+
+// NewTelemetryExportJob creates a pending export job with a fresh UUID.
+func NewTelemetryExportJob(ownerUUID, scope, targetUUID string, startTime, endTime int64, properties []string, format string) *TelemetryExportJob {
+	return &TelemetryExportJob{
+		JobUUID:    utils.GenerateUUID().String(),
+		OwnerUUID:  ownerUUID,
+		Scope:      scope,
+		TargetUUID: targetUUID,
+		StartTime:  startTime,
+		EndTime:    endTime,
+		Properties: properties,
+		Format:     format,
+		Status:     TelemetryExportPending,
+		CreatedAt:  time.Now().Unix(),
+	}
+}
@@ -0,0 +1,120 @@
+package model
+
+import (
+	"OMEGA3-IOT/internal/utils"
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// WebhookDelivery status constants
+const (
+	WebhookDeliveryPending   = "pending"
+	WebhookDeliverySucceeded = "succeeded"
+	WebhookDeliveryFailed    = "failed"
+)
+
+// WebhookEventList is a list of subscribed event types stored as a JSON array.
+type WebhookEventList []string
+
+func (l WebhookEventList) Value() (driver.Value, error) {
+	if l == nil {
+		return "[]", nil
+	}
+	return json.Marshal(l)
+}
+
+func (l *WebhookEventList) Scan(value interface{}) error {
+	*l = nil
+	if value == nil {
+		return nil
+	}
+	var bytes []byte
+	switch v := value.(type) {
+	case []byte:
+		bytes = v
+	case string:
+		bytes = []byte(v)
+	default:
+		return fmt.Errorf("cannot scan %T into WebhookEventList", value)
+	}
+	return json.Unmarshal(bytes, l)
+}
+
+// Webhook is an HTTP endpoint that receives signed POSTs for the subscribed event types.
+// User webhooks only receive events of devices and groups the owner can see; global webhooks
+// are managed by admins and receive every subscribed event.
+type Webhook struct {
+	ID                  uint             `gorm:"primaryKey;autoIncrement" json:"-"`
+	WebhookUUID         string           `gorm:"type:varchar(36);not null;uniqueIndex" json:"webhook_uuid"`
+	OwnerUUID           string           `gorm:"type:varchar(36);not null;index" json:"owner_uuid"`
+	Global              bool             `gorm:"not null;default:false;index" json:"global"`
+	Name                string           `gorm:"type:varchar(128);not null" json:"name"`
+	URL                 string           `gorm:"type:varchar(512);not null" json:"url"`
+	Secret              string           `gorm:"type:varchar(64);not null" json:"-"`
+	EventTypes          WebhookEventList `gorm:"type:json" json:"event_types"`
+	Enabled             bool             `gorm:"not null;default:true;index" json:"enabled"`
+	ConsecutiveFailures int              `gorm:"not null;default:0" json:"consecutive_failures"`
+	DisabledReason      string           `gorm:"type:varchar(255)" json:"disabled_reason,omitempty"`
+	CreatedAt           int64            `gorm:"not null" json:"created_at"`
+	UpdatedAt           int64            `gorm:"not null" json:"updated_at"`
+}
+
+// NewWebhook creates an enabled webhook with a fresh UUID.
+func NewWebhook(ownerUUID string, global bool, name, url, secret string, eventTypes []string) *Webhook {
+	now := time.Now().Unix()
+	return &Webhook{
+		WebhookUUID: utils.GenerateUUID().String(),
+		OwnerUUID:   ownerUUID,
+		Global:      global,
+		Name:        name,
+		URL:         url,
+		Secret:      secret,
+		EventTypes:  eventTypes,
+		Enabled:     true,
+		CreatedAt:   now,
+		UpdatedAt:   now,
+	}
+}
+
+// Subscribes reports whether the webhook wants events of the given type.
+func (w *Webhook) Subscribes(eventType string) bool {
+	for _, t := range w.EventTypes {
+		if t == eventType {
+			return true
+		}
+	}
+	return false
+}
+
+// WebhookDelivery is one event sent to one webhook, including its retries.
+// NextAttemptAt is when the next attempt is due while pending; it is pushed forward
+// while an attempt is in flight so that only one worker sends it.
+type WebhookDelivery struct {
+	ID            uint            `gorm:"primaryKey;autoIncrement" json:"-"`
+	DeliveryUUID  string          `gorm:"type:varchar(36);not null;uniqueIndex" json:"delivery_uuid"`
+	WebhookUUID   string          `gorm:"type:varchar(36);not null;index" json:"webhook_uuid"`
+	EventType     string          `gorm:"type:varchar(64);not null" json:"event_type"`
+	Payload       json.RawMessage `gorm:"type:json" json:"payload"`
+	Status        string          `gorm:"type:varchar(20);not null;default:'pending';index:idx_webhook_delivery_due" json:"status"`
+	Attempts      int             `gorm:"not null;default:0" json:"attempts"`
+	ResponseCode  int             `json:"response_code,omitempty"`
+	Error         string          `gorm:"type:varchar(500)" json:"error,omitempty"`
+	CreatedAt     int64           `gorm:"not null;index" json:"created_at"`
+	NextAttemptAt int64           `gorm:"index:idx_webhook_delivery_due" json:"next_attempt_at,omitempty"`
+	CompletedAt   int64           `json:"completed_at,omitempty"`
+}
+
+// NewWebhookDelivery creates a pending delivery whose first attempt is leased until leaseUntil.
+func NewWebhookDelivery(deliveryUUID, webhookUUID, eventType string, payload json.RawMessage, leaseUntil int64) *WebhookDelivery {
+	return &WebhookDelivery{
+		DeliveryUUID:  deliveryUUID,
+		WebhookUUID:   webhookUUID,
+		EventType:     eventType,
+		Payload:       payload,
+		Status:        WebhookDeliveryPending,
+		CreatedAt:     time.Now().Unix(),
+		NextAttemptAt: leaseUntil,
+	}
+}
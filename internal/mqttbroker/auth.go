@@ -0,0 +1,77 @@
+package mqttbroker
+
+import (
+	"crypto/x509"
+	"errors"
+	"strings"
+)
+
+var (
+	ErrBadCredentials = errors.New("bad user name or password")
+	ErrNotAuthorized  = errors.New("not authorized")
+)
+
+// Principal is the identity of an authenticated connection.
+type Principal struct {
+	// DeviceUUID is the device a device connection belongs to.
+	DeviceUUID string
+	// Superuser connections, such as the server's own client, may use every topic.
+	Superuser bool
+}
+
+// identified reports whether the connection is known. Authenticators implementing
+// PayloadAuthenticator may admit connections that are not.
+func (p *Principal) identified() bool {
+	return p.Superuser || p.DeviceUUID != ""
+}
+
+// Authenticator verifies the credentials of a CONNECT. It returns ErrBadCredentials or
+// ErrNotAuthorized to refuse the connection.
+type Authenticator interface {
+	Authenticate(clientID, username string, password []byte) (*Principal, error)
+}
+
+// CertificateAuthenticator is implemented by authenticators that identify connections by their
+// TLS client certificate. The broker calls it instead of Authenticate when the client presented
+// a certificate that verified against the listener's client CAs.
+type CertificateAuthenticator interface {
+	AuthenticateCertificate(clientID string, cert *x509.Certificate) (*Principal, error)
+}
+
+// PayloadAuthenticator is implemented by authenticators that admit connections without an
+// identity, for firmware that authenticates in the message body. The first message such a
+// connection publishes identifies it and the connection keeps that identity, so later messages
+// are not checked again. Subscriptions made before take effect once the connection is
+// identified, as far as the ACL allows.
+type PayloadAuthenticator interface {
+	AuthenticatePayload(topic string, payload []byte) (*Principal, error)
+}
+
+// ACL decides which topics a connection may publish and subscribe to.
+type ACL interface {
+	CanPublish(principal *Principal, topic string) bool
+	CanSubscribe(principal *Principal, filter string) bool
+}
+
+// DeviceTopicACL confines each device to its own topics under data/device/<uuid>/. Filters may
+// use wildcards below that prefix only, so a device cannot subscribe to other devices.
+type DeviceTopicACL struct{}
+
+func deviceTopicPrefix(principal *Principal) string {
+	return "data/device/" + principal.DeviceUUID + "/"
+}
+
+func (DeviceTopicACL) CanPublish(principal *Principal, topic string) bool {
+	if principal.Superuser {
+		return true
+	}
+	return principal.DeviceUUID != "" && strings.HasPrefix(topic, deviceTopicPrefix(principal))
+}
+
+func (DeviceTopicACL) CanSubscribe(principal *Principal, filter string) bool {
+	if principal.Superuser {
+		return true
+	}
+	prefix := deviceTopicPrefix(principal)
+	return principal.DeviceUUID != "" && strings.HasPrefix(filter, prefix) && len(filter) > len(prefix)
+}
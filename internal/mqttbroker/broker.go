@@ -0,0 +1,677 @@
+// Package mqttbroker is a small in-process MQTT 3.1.1 and 5 broker for single-binary
+// deployments. Connections are authenticated on CONNECT, by credentials or by TLS client
+// certificate, and checked against an ACL on every PUBLISH and SUBSCRIBE. A client that
+// connects without a clean start keeps its subscriptions and unacknowledged QoS 1 and 2
+// messages in memory for an hour after it disconnects; they are sent again when it reconnects.
+// Sessions do not survive a restart. MQTT 5 properties are accepted and ignored.
+package mqttbroker
+
+import (
+	"bufio"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	defaultMaxPacketSize  = 256 * 1024
+	defaultConnectTimeout = 10 * time.Second
+	clientQueueSize       = 256
+)
+
+// Broker routes messages between connected clients.
+type Broker struct {
+	auth           Authenticator
+	acl            ACL
+	maxPacketSize  int
+	connectTimeout time.Duration
+
+	sessionExpiry time.Duration
+
+	mu        sync.RWMutex
+	clients   map[string]*client  // by client identifier
+	sessions  map[string]*session // sessions of disconnected clients, by client identifier
+	lastSweep time.Time
+	reserved  map[string]bool    // client identifiers only internal connections may use
+	retained  map[string]message // by topic
+	listeners []net.Listener
+	closed    bool
+	wg        sync.WaitGroup
+}
+
+// New creates a Broker. A maxPacketSize of 0 uses 256 KiB.
+func New(auth Authenticator, acl ACL, maxPacketSize int) *Broker {
+	if maxPacketSize <= 0 {
+		maxPacketSize = defaultMaxPacketSize
+	}
+	return &Broker{
+		auth:           auth,
+		acl:            acl,
+		maxPacketSize:  maxPacketSize,
+		connectTimeout: defaultConnectTimeout,
+		sessionExpiry:  defaultSessionExpiry,
+		clients:        make(map[string]*client),
+		sessions:       make(map[string]*session),
+		reserved:       make(map[string]bool),
+		retained:       make(map[string]message),
+	}
+}
+
+// ReserveClientID refuses connections other than ConnectInternal that use one of the client
+// identifiers, so devices cannot take over the server's own client.
+func (b *Broker) ReserveClientID(ids ...string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, id := range ids {
+		b.reserved[id] = true
+	}
+}
+
+// Serve accepts connections on l until the broker is closed.
+func (b *Broker) Serve(l net.Listener) error {
+	b.mu.Lock()
+	if b.closed {
+		b.mu.Unlock()
+		return errBrokerClosed
+	}
+	b.listeners = append(b.listeners, l)
+	b.mu.Unlock()
+
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			b.mu.RLock()
+			closed := b.closed
+			b.mu.RUnlock()
+			if closed {
+				return nil
+			}
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Timeout() {
+				time.Sleep(100 * time.Millisecond)
+				continue
+			}
+			return err
+		}
+		b.wg.Add(1)
+		go func() {
+			defer b.wg.Done()
+			b.handle(conn, nil)
+		}()
+	}
+}
+
+// ConnectInternal returns an in-memory connection for a client of the server itself. It is
+// served as a superuser without checking the CONNECT credentials, and is never disconnected for
+// falling behind: publishers wait until it has room again.
+func (b *Broker) ConnectInternal() net.Conn {
+	serverSide, clientSide := net.Pipe()
+	b.wg.Add(1)
+	go func() {
+		defer b.wg.Done()
+		b.handle(serverSide, &Principal{Superuser: true})
+	}()
+	return clientSide
+}
+
+// Close stops the listeners and disconnects every client.
+func (b *Broker) Close() error {
+	b.mu.Lock()
+	b.closed = true
+	listeners := b.listeners
+	clients := make([]*client, 0, len(b.clients))
+	for _, c := range b.clients {
+		clients = append(clients, c)
+	}
+	b.mu.Unlock()
+
+	for _, l := range listeners {
+		l.Close()
+	}
+	for _, c := range clients {
+		c.close()
+	}
+	b.wg.Wait()
+	return nil
+}
+
+// DisconnectDevice closes the connections authenticated as deviceUUID, e.g. after its
+// credentials were revoked, and returns how many were closed. Their wills are published.
+func (b *Broker) DisconnectDevice(deviceUUID string) int {
+	b.mu.RLock()
+	var matched []*client
+	for _, c := range b.clients {
+		if c.currentPrincipal().DeviceUUID == deviceUUID {
+			matched = append(matched, c)
+		}
+	}
+	b.mu.RUnlock()
+
+	for _, c := range matched {
+		log.Printf("[MQTTBroker] Disconnecting client '%s' of device %s", c.id, deviceUUID)
+		c.close()
+	}
+	return len(matched)
+}
+
+// client is one connection.
+type client struct {
+	broker    *Broker
+	conn      net.Conn
+	id        string
+	version   byte
+	internal  bool
+	principal *Principal
+	will      *message
+	session   *session
+
+	mu       sync.Mutex
+	pending  map[string]byte // subscriptions awaiting identification, see PayloadAuthenticator
+	received map[uint16]bool // inbound QoS 2 packet ids awaiting PUBREL
+
+	out       chan []byte
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// handle runs a connection: the CONNECT exchange, then the read loop. trusted skips the
+// credential check for internal connections.
+func (b *Broker) handle(conn net.Conn, trusted *Principal) {
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+
+	conn.SetReadDeadline(time.Now().Add(b.connectTimeout))
+	connect, err := readPacket(reader, 0, b.maxPacketSize)
+	if err != nil || connect.Type != packetConnect {
+		return
+	}
+	version := connect.ProtocolLevel
+	if connect.ProtocolName != "MQTT" || (version != protocol311 && version != protocol5) {
+		conn.Write(encodeConnack(protocol311, connackBadProtocol, "", false))
+		return
+	}
+	refuse := func(code311, code5 byte) {
+		if version == protocol5 {
+			conn.Write(encodeConnack(version, code5, "", false))
+		} else {
+			conn.Write(encodeConnack(version, code311, "", false))
+		}
+	}
+
+	clientID, assignedID := connect.ClientID, ""
+	if clientID == "" {
+		if version == protocol311 && !connect.CleanStart {
+			refuse(connackIdentifierRejected, reasonInvalidClientID)
+			return
+		}
+		clientID = generateClientID()
+		assignedID = clientID
+	}
+
+	b.mu.RLock()
+	reserved := b.reserved[clientID]
+	b.mu.RUnlock()
+	if reserved && trusted == nil {
+		refuse(connackIdentifierRejected, reasonInvalidClientID)
+		log.Printf("[MQTTBroker] Refused client '%s' from %s: client identifier is reserved", clientID, conn.RemoteAddr())
+		return
+	}
+
+	principal := trusted
+	if principal == nil {
+		principal, err = b.authenticate(conn, clientID, connect)
+		if err != nil {
+			if errors.Is(err, ErrBadCredentials) {
+				refuse(connackBadCredentials, reasonBadCredentials)
+			} else {
+				refuse(connackNotAuthorized, reasonNotAuthorized)
+			}
+			log.Printf("[MQTTBroker] Refused client '%s' (user '%s') from %s: %v", clientID, connect.Username, conn.RemoteAddr(), err)
+			return
+		}
+	}
+
+	c := &client{
+		broker:    b,
+		conn:      conn,
+		id:        clientID,
+		version:   version,
+		internal:  trusted != nil,
+		principal: principal,
+		will:      connect.Will,
+		pending:   make(map[string]byte),
+		received:  make(map[uint16]bool),
+		out:       make(chan []byte, clientQueueSize),
+		done:      make(chan struct{}),
+	}
+	// The will of a connection that is not identified yet is checked when it is published
+	if c.will != nil && (!validTopicName(c.will.Topic) || (principal.identified() && !b.acl.CanPublish(principal, c.will.Topic))) {
+		refuse(connackNotAuthorized, reasonNotAuthorized)
+		return
+	}
+	sessionPresent, err := b.register(c, !connect.CleanStart)
+	if err != nil {
+		if errors.Is(err, errClientIDInUse) {
+			refuse(connackIdentifierRejected, reasonInvalidClientID)
+			log.Printf("[MQTTBroker] Refused client '%s' from %s: %v", clientID, conn.RemoteAddr(), err)
+		}
+		return
+	}
+	c.send(encodeConnack(version, connackAccepted, assignedID, sessionPresent))
+	c.session.attach(c)
+
+	writerDone := make(chan struct{})
+	go func() {
+		defer close(writerDone)
+		c.writeLoop()
+	}()
+
+	graceful := c.readLoop(reader, connect.KeepAlive)
+	b.unregister(c)
+	c.close()
+	<-writerDone
+	if !graceful && c.will != nil && b.acl.CanPublish(c.currentPrincipal(), c.will.Topic) {
+		b.route(c, *c.will)
+	}
+}
+
+// authenticate checks the credentials of a CONNECT, or the client certificate of a TLS
+// connection when the authenticator supports certificates.
+func (b *Broker) authenticate(conn net.Conn, clientID string, connect *packet) (*Principal, error) {
+	if tlsConn, ok := conn.(*tls.Conn); ok {
+		state := tlsConn.ConnectionState()
+		if certAuth, ok := b.auth.(CertificateAuthenticator); ok && len(state.VerifiedChains) > 0 {
+			return certAuth.AuthenticateCertificate(clientID, state.PeerCertificates[0])
+		}
+	}
+	return b.auth.Authenticate(clientID, connect.Username, connect.Password)
+}
+
+var (
+	errBrokerClosed  = errors.New("broker closed")
+	errClientIDInUse = errors.New("client identifier in use by another principal")
+)
+
+// register adds a client. An existing client with the same identifier is disconnected if the new
+// connection has the same principal; otherwise the new connection is refused. A client that asks
+// to resume takes over the session of the previous connection or of its stored session, provided
+// it has the same principal, and register reports whether it did.
+func (b *Broker) register(c *client, resume bool) (bool, error) {
+	b.mu.Lock()
+	if b.closed {
+		b.mu.Unlock()
+		return false, errBrokerClosed
+	}
+	b.sweepSessions()
+	previous := b.clients[c.id]
+	if previous != nil && !mayTakeOver(previous.currentPrincipal(), c.principal) {
+		b.mu.Unlock()
+		return false, errClientIDInUse
+	}
+
+	var resumed *session
+	if previous != nil {
+		// The previous connection no longer owns its session, so it does not store it on close
+		if previous.session.detach(previous, 0) && resume {
+			resumed = previous.session
+		}
+	} else if stored := b.sessions[c.id]; stored != nil && resume && mayTakeOver(stored.owner(), c.principal) {
+		resumed = stored
+	}
+	delete(b.sessions, c.id)
+	if resumed != nil {
+		c.session = resumed
+	} else {
+		c.session = newSession(c.principal, resume)
+	}
+	b.clients[c.id] = c
+	b.mu.Unlock()
+
+	if previous != nil {
+		log.Printf("[MQTTBroker] Client '%s' connected again, closing the previous connection", c.id)
+		previous.close()
+	}
+	return resumed != nil, nil
+}
+
+// sweepSessions drops stored sessions that expired, at most once a minute. Callers hold b.mu.
+func (b *Broker) sweepSessions() {
+	now := time.Now()
+	if now.Sub(b.lastSweep) < time.Minute {
+		return
+	}
+	b.lastSweep = now
+	for id, s := range b.sessions {
+		if s.expired(now) {
+			delete(b.sessions, id)
+		}
+	}
+}
+
+// mayTakeOver reports whether a connection of next may replace one of previous with the same
+// client identifier: the server's own clients may, devices only their own connections, and
+// connections that are not identified never.
+func mayTakeOver(previous, next *Principal) bool {
+	if next.Superuser {
+		return true
+	}
+	return !previous.Superuser && next.DeviceUUID != "" && next.DeviceUUID == previous.DeviceUUID
+}
+
+// unregister removes a client and keeps its session, unless it started clean or another
+// connection has taken the session over.
+func (b *Broker) unregister(c *client) {
+	b.mu.Lock()
+	if b.clients[c.id] == c {
+		delete(b.clients, c.id)
+	}
+	if c.session.detach(c, b.sessionExpiry) && c.session.persistent && !b.closed {
+		b.sessions[c.id] = c.session
+	}
+	b.mu.Unlock()
+}
+
+// readLoop handles packets until the connection ends. It returns true when the client sent
+// DISCONNECT, in which case its will is discarded.
+func (c *client) readLoop(reader *bufio.Reader, keepAlive uint16) bool {
+	for {
+		if keepAlive > 0 {
+			c.conn.SetReadDeadline(time.Now().Add(time.Duration(keepAlive) * 1500 * time.Millisecond))
+		} else {
+			c.conn.SetReadDeadline(time.Time{})
+		}
+		p, err := readPacket(reader, c.version, c.broker.maxPacketSize)
+		if err != nil {
+			if !errors.Is(err, io.EOF) && !errors.Is(err, net.ErrClosed) {
+				log.Printf("[MQTTBroker] Client '%s' disconnected: %v", c.id, err)
+			}
+			return false
+		}
+
+		switch p.Type {
+		case packetPublish:
+			if !c.handlePublish(p) {
+				return false
+			}
+		case packetPubrel:
+			c.mu.Lock()
+			delete(c.received, p.PacketID)
+			c.mu.Unlock()
+			c.send(encodeAck(c.version, packetPubcomp, p.PacketID, 0))
+		case packetPubrec:
+			if c.session.acknowledge(p.Type, p.PacketID) {
+				c.send(encodeAck(c.version, packetPubrel, p.PacketID, 0))
+			}
+		case packetPuback, packetPubcomp:
+			c.session.acknowledge(p.Type, p.PacketID)
+		case packetSubscribe:
+			c.handleSubscribe(p)
+		case packetUnsubscribe:
+			c.mu.Lock()
+			for _, sub := range p.Filters {
+				delete(c.pending, sub.Filter)
+			}
+			c.mu.Unlock()
+			c.session.mu.Lock()
+			for _, sub := range p.Filters {
+				delete(c.session.subs, sub.Filter)
+			}
+			c.session.mu.Unlock()
+			c.send(encodeUnsuback(c.version, p.PacketID, len(p.Filters)))
+		case packetPingreq:
+			c.send(encodePingresp())
+		case packetDisconnect:
+			return true
+		default:
+			return false
+		}
+	}
+}
+
+// handlePublish routes an inbound message. Messages the ACL refuses are acknowledged and
+// dropped; MQTT 5 clients get the reason. It returns false to close the connection.
+func (c *client) handlePublish(p *packet) bool {
+	msg := p.Message
+	if !validTopicName(msg.Topic) {
+		if c.version == protocol5 {
+			c.send(encodeDisconnect(reasonTopicNameInvalid))
+		}
+		return false
+	}
+
+	principal := c.currentPrincipal()
+	if !principal.identified() {
+		principal = c.identify(msg)
+	}
+	allowed := c.broker.acl.CanPublish(principal, msg.Topic)
+	if !allowed {
+		log.Printf("[MQTTBroker] Client '%s' may not publish to '%s', message dropped", c.id, msg.Topic)
+	}
+	var reason byte
+	if !allowed {
+		reason = reasonNotAuthorized
+	}
+
+	switch msg.QoS {
+	case 0:
+		if allowed {
+			c.broker.route(c, msg)
+		}
+	case 1:
+		if allowed {
+			c.broker.route(c, msg)
+		}
+		c.send(encodeAck(c.version, packetPuback, p.PacketID, reason))
+	case 2:
+		c.mu.Lock()
+		duplicate := c.received[p.PacketID]
+		c.received[p.PacketID] = true
+		c.mu.Unlock()
+		if allowed && !duplicate {
+			c.broker.route(c, msg)
+		}
+		c.send(encodeAck(c.version, packetPubrec, p.PacketID, reason))
+	}
+	return true
+}
+
+func (c *client) currentPrincipal() *Principal {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.principal
+}
+
+// identify asks the PayloadAuthenticator who published msg. On success the connection keeps the
+// identity and its pending subscriptions are granted as far as the ACL allows.
+func (c *client) identify(msg message) *Principal {
+	c.mu.Lock()
+	principal := c.principal
+	c.mu.Unlock()
+	payloadAuth, ok := c.broker.auth.(PayloadAuthenticator)
+	if !ok {
+		return principal
+	}
+	identified, err := payloadAuth.AuthenticatePayload(msg.Topic, msg.Payload)
+	if err != nil || !identified.identified() {
+		log.Printf("[MQTTBroker] Client '%s' not identified by its message to '%s': %v", c.id, msg.Topic, err)
+		return principal
+	}
+	log.Printf("[MQTTBroker] Client '%s' identified as device %s by its message", c.id, identified.DeviceUUID)
+
+	var granted []subscription
+	c.mu.Lock()
+	c.principal = identified
+	for filter, qos := range c.pending {
+		if c.broker.acl.CanSubscribe(identified, filter) {
+			granted = append(granted, subscription{Filter: filter, QoS: qos})
+		} else {
+			log.Printf("[MQTTBroker] Client '%s' may not subscribe to '%s'", c.id, filter)
+		}
+	}
+	c.pending = make(map[string]byte)
+	c.mu.Unlock()
+	c.session.subscribe(identified, granted)
+	c.sendRetained(granted)
+	return identified
+}
+
+func (c *client) handleSubscribe(p *packet) {
+	principal := c.currentPrincipal()
+	_, payloadAuth := c.broker.auth.(PayloadAuthenticator)
+	deferred := !principal.identified() && payloadAuth
+
+	codes := make([]byte, len(p.Filters))
+	var granted, pending []subscription
+	for i, sub := range p.Filters {
+		switch {
+		case !validTopicFilter(sub.Filter):
+			codes[i] = subackFailure
+			if c.version == protocol5 {
+				codes[i] = reasonTopicFilterInvalid
+			}
+		case deferred:
+			codes[i] = sub.QoS
+			pending = append(pending, sub)
+		case !c.broker.acl.CanSubscribe(principal, sub.Filter):
+			codes[i] = subackFailure
+			if c.version == protocol5 {
+				codes[i] = reasonNotAuthorized
+			}
+			log.Printf("[MQTTBroker] Client '%s' may not subscribe to '%s'", c.id, sub.Filter)
+		default:
+			codes[i] = sub.QoS
+			granted = append(granted, sub)
+		}
+	}
+
+	c.mu.Lock()
+	for _, sub := range pending {
+		c.pending[sub.Filter] = sub.QoS
+	}
+	c.mu.Unlock()
+	c.session.subscribe(principal, granted)
+	c.send(encodeSuback(c.version, p.PacketID, codes))
+
+	// Retained messages are sent after the SUBACK
+	c.sendRetained(granted)
+}
+
+// sendRetained delivers the retained messages matching granted subscriptions.
+func (c *client) sendRetained(granted []subscription) {
+	if len(granted) == 0 {
+		return
+	}
+	c.broker.mu.RLock()
+	var retained []message
+	for _, msg := range c.broker.retained {
+		for _, sub := range granted {
+			if MatchTopic(sub.Filter, msg.Topic) {
+				msg.QoS = minQoS(msg.QoS, sub.QoS)
+				retained = append(retained, msg)
+				break
+			}
+		}
+	}
+	c.broker.mu.RUnlock()
+	for _, msg := range retained {
+		c.session.deliver(msg)
+	}
+}
+
+// route stores a retained message and delivers msg to every matching subscription, at the
+// lower of the published and granted QoS. Sessions of disconnected clients keep QoS 1 and 2
+// messages until they reconnect.
+func (b *Broker) route(from *client, msg message) {
+	b.mu.Lock()
+	if msg.Retain {
+		if len(msg.Payload) == 0 {
+			delete(b.retained, msg.Topic)
+		} else {
+			b.retained[msg.Topic] = msg
+		}
+	}
+	sessions := make([]*session, 0, len(b.clients)+len(b.sessions))
+	for _, c := range b.clients {
+		sessions = append(sessions, c.session)
+	}
+	if msg.QoS > 0 {
+		for _, s := range b.sessions {
+			sessions = append(sessions, s)
+		}
+	}
+	b.mu.Unlock()
+
+	msg.Retain = false
+	for _, s := range sessions {
+		qos, ok := s.matchingQoS(msg.Topic)
+		if !ok {
+			continue
+		}
+		delivery := msg
+		delivery.QoS = minQoS(msg.QoS, qos)
+		s.deliver(delivery)
+	}
+}
+
+// send queues a packet. A device that does not keep up is disconnected rather than allowed
+// to hold back the others; its session keeps the unacknowledged messages. The server's own
+// client is not: the sender waits, which holds back the publishing device instead of losing
+// its messages.
+func (c *client) send(data []byte) {
+	if c.internal {
+		select {
+		case <-c.done:
+		case c.out <- data:
+		}
+		return
+	}
+	select {
+	case <-c.done:
+	case c.out <- data:
+	default:
+		log.Printf("[MQTTBroker] Client '%s' is not reading, disconnecting", c.id)
+		c.close()
+	}
+}
+
+func (c *client) writeLoop() {
+	for {
+		select {
+		case data := <-c.out:
+			c.conn.SetWriteDeadline(time.Now().Add(30 * time.Second))
+			if _, err := c.conn.Write(data); err != nil {
+				c.close()
+				return
+			}
+		case <-c.done:
+			return
+		}
+	}
+}
+
+func (c *client) close() {
+	c.closeOnce.Do(func() {
+		close(c.done)
+		c.conn.Close()
+	})
+}
+
+func minQoS(a, b byte) byte {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func generateClientID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return fmt.Sprintf("auto-%s", hex.EncodeToString(b))
+}
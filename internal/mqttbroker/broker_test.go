@@ -0,0 +1,561 @@
+package mqttbroker
+
+import (
+	"bufio"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"io"
+	"math/big"
+	"net"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+func TestMatchTopic(t *testing.T) {
+	cases := []struct {
+		filter, topic string
+		want          bool
+	}{
+		{"data/device/+/properties", "data/device/a/properties", true},
+		{"data/device/+/properties", "data/device/a/b/properties", false},
+		{"data/device/a/#", "data/device/a/shadow/delta", true},
+		{"data/device/a/#", "data/device/a", true},
+		{"data/device/a/#", "data/device/b/action", false},
+		{"#", "$SYS/uptime", false},
+		{"+/device", "$SYS/device", false},
+	}
+	for _, c := range cases {
+		if got := MatchTopic(c.filter, c.topic); got != c.want {
+			t.Errorf("MatchTopic(%q, %q) = %v, want %v", c.filter, c.topic, got, c.want)
+		}
+	}
+
+	for filter, want := range map[string]bool{"a/+/b": true, "a/#": true, "a/#/b": false, "a+/b": false, "": false} {
+		if got := validTopicFilter(filter); got != want {
+			t.Errorf("validTopicFilter(%q) = %v, want %v", filter, got, want)
+		}
+	}
+}
+
+func TestDeviceTopicACL(t *testing.T) {
+	acl := DeviceTopicACL{}
+	device := &Principal{DeviceUUID: "dev-1"}
+	if !acl.CanPublish(device, "data/device/dev-1/properties") || acl.CanPublish(device, "data/device/dev-2/properties") {
+		t.Error("devices may only publish to their own topics")
+	}
+	if !acl.CanSubscribe(device, "data/device/dev-1/#") || acl.CanSubscribe(device, "data/device/+/action") || acl.CanSubscribe(device, "#") {
+		t.Error("devices may only subscribe below their own prefix")
+	}
+	if !acl.CanSubscribe(&Principal{Superuser: true}, "data/device/+/properties") {
+		t.Error("superusers may subscribe to every topic")
+	}
+}
+
+type testAuthenticator map[string]string // password by device UUID
+
+func (a testAuthenticator) Authenticate(clientID, username string, password []byte) (*Principal, error) {
+	if expected, ok := a[username]; ok && expected == string(password) {
+		return &Principal{DeviceUUID: username}, nil
+	}
+	return nil, ErrBadCredentials
+}
+
+func startTestBroker(t *testing.T) (*Broker, string) {
+	t.Helper()
+	broker := New(testAuthenticator{"dev-1": "secret", "dev-2": "other"}, DeviceTopicACL{}, 0)
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go broker.Serve(listener)
+	t.Cleanup(func() { broker.Close() })
+	return broker, "tcp://" + listener.Addr().String()
+}
+
+func connectTestClient(t *testing.T, addr, clientID, username, password string) (mqtt.Client, error) {
+	t.Helper()
+	options := mqtt.NewClientOptions().AddBroker(addr).SetClientID(clientID).SetUsername(username).SetPassword(password)
+	options.SetAutoReconnect(false)
+	client := mqtt.NewClient(options)
+	token := client.Connect()
+	if !token.WaitTimeout(5 * time.Second) {
+		t.Fatal("connect timed out")
+	}
+	if token.Error() == nil {
+		t.Cleanup(func() { client.Disconnect(0) })
+	}
+	return client, token.Error()
+}
+
+func TestBrokerRoutesWithinACL(t *testing.T) {
+	broker, addr := startTestBroker(t)
+
+	if _, err := connectTestClient(t, addr, "bad", "dev-1", "wrong"); err == nil {
+		t.Fatal("connect with a wrong password succeeded")
+	}
+
+	// The server client connects in memory as a superuser
+	options := mqtt.NewClientOptions().AddBroker("tcp://internal").SetClientID("server")
+	options.SetCustomOpenConnectionFn(func(*url.URL, mqtt.ClientOptions) (net.Conn, error) {
+		return broker.ConnectInternal(), nil
+	})
+	server := mqtt.NewClient(options)
+	if token := server.Connect(); !token.WaitTimeout(5*time.Second) || token.Error() != nil {
+		t.Fatalf("internal connect failed: %v", token.Error())
+	}
+	defer server.Disconnect(0)
+
+	received := make(chan mqtt.Message, 10)
+	if token := server.Subscribe("data/device/+/properties", 1, func(_ mqtt.Client, msg mqtt.Message) { received <- msg }); !token.WaitTimeout(5*time.Second) || token.Error() != nil {
+		t.Fatalf("subscribe failed: %v", token.Error())
+	}
+
+	device, err := connectTestClient(t, addr, "dev-1", "dev-1", "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	device.Publish("data/device/dev-2/properties", 1, false, "spoofed").WaitTimeout(5 * time.Second)
+	device.Publish("data/device/dev-1/properties", 1, false, "own").WaitTimeout(5 * time.Second)
+
+	select {
+	case msg := <-received:
+		if msg.Topic() != "data/device/dev-1/properties" || string(msg.Payload()) != "own" {
+			t.Fatalf("received %s %q, the spoofed message must be dropped", msg.Topic(), msg.Payload())
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("message not delivered")
+	}
+
+	// Retained messages reach devices that subscribe later
+	server.Publish("data/device/dev-1/shadow/delta", 1, true, "delta").WaitTimeout(5 * time.Second)
+	deltas := make(chan string, 1)
+	token := device.Subscribe("data/device/dev-1/shadow/delta", 1, func(_ mqtt.Client, msg mqtt.Message) { deltas <- string(msg.Payload()) })
+	token.WaitTimeout(5 * time.Second)
+	select {
+	case payload := <-deltas:
+		if payload != "delta" {
+			t.Errorf("retained payload = %q", payload)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("retained message not delivered")
+	}
+
+	token = device.Subscribe("data/device/+/action", 1, nil)
+	token.WaitTimeout(5 * time.Second)
+	if sub, ok := token.(*mqtt.SubscribeToken); ok && sub.Result()["data/device/+/action"] != subackFailure {
+		t.Errorf("wildcard subscription across devices granted %d", sub.Result()["data/device/+/action"])
+	}
+}
+
+func TestBrokerDisconnectDevice(t *testing.T) {
+	broker, addr := startTestBroker(t)
+	device, err := connectTestClient(t, addr, "dev-1", "dev-1", "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := connectTestClient(t, addr, "dev-2", "dev-2", "other")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if n := broker.DisconnectDevice("dev-1"); n != 1 {
+		t.Fatalf("DisconnectDevice closed %d connections", n)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for device.IsConnectionOpen() && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if device.IsConnectionOpen() {
+		t.Error("connection of the device still open")
+	}
+	if !other.IsConnectionOpen() {
+		t.Error("connection of another device closed")
+	}
+}
+
+func TestBrokerMQTT5Connect(t *testing.T) {
+	_, addr := startTestBroker(t)
+	conn, err := net.Dial("tcp", addr[len("tcp://"):])
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	e := &encoder{}
+	e.string("MQTT")
+	e.byte(protocol5)
+	e.byte(0xC2) // username, password, clean start
+	e.uint16(30)
+	e.byte(0) // no properties
+	e.string("")
+	e.string("dev-1")
+	e.string("secret")
+	conn.Write(e.frame(packetConnect << 4))
+
+	reader := bufio.NewReader(conn)
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	header, _ := reader.ReadByte()
+	length, _ := readVarInt(reader)
+	body := make([]byte, length)
+	reader.Read(body)
+	if header != packetConnack<<4 || len(body) < 3 || body[1] != connackAccepted {
+		t.Fatalf("connack = %x %x", header, body)
+	}
+	d := &decoder{buf: body[2:]}
+	propsLength, used, _ := varInt(d.buf)
+	if propsLength == 0 || d.buf[used] != 0x12 {
+		t.Fatalf("expected an assigned client identifier, properties %x", d.buf)
+	}
+}
+
+// legacyAuthenticator admits connections without credentials and identifies them by a
+// "<uuid>:<password>" payload.
+type legacyAuthenticator struct{ testAuthenticator }
+
+func (a legacyAuthenticator) Authenticate(clientID, username string, password []byte) (*Principal, error) {
+	if username == "" && len(password) == 0 {
+		return &Principal{}, nil
+	}
+	return a.testAuthenticator.Authenticate(clientID, username, password)
+}
+
+func (a legacyAuthenticator) AuthenticatePayload(topic string, payload []byte) (*Principal, error) {
+	uuid, password, _ := strings.Cut(string(payload), ":")
+	return a.testAuthenticator.Authenticate("", uuid, []byte(password))
+}
+
+func TestBrokerIdentifiesByPayload(t *testing.T) {
+	broker := New(legacyAuthenticator{testAuthenticator{"dev-1": "secret"}}, DeviceTopicACL{}, 0)
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go broker.Serve(listener)
+	defer broker.Close()
+	addr := "tcp://" + listener.Addr().String()
+
+	options := mqtt.NewClientOptions().AddBroker("tcp://internal").SetClientID("server")
+	options.SetCustomOpenConnectionFn(func(*url.URL, mqtt.ClientOptions) (net.Conn, error) {
+		return broker.ConnectInternal(), nil
+	})
+	server := mqtt.NewClient(options)
+	if token := server.Connect(); !token.WaitTimeout(5*time.Second) || token.Error() != nil {
+		t.Fatalf("internal connect failed: %v", token.Error())
+	}
+	defer server.Disconnect(0)
+	received := make(chan string, 10)
+	server.Subscribe("data/device/+/properties", 1, func(_ mqtt.Client, msg mqtt.Message) { received <- string(msg.Payload()) }).WaitTimeout(5 * time.Second)
+
+	device, err := connectTestClient(t, addr, "legacy", "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	actions := make(chan string, 10)
+	token := device.Subscribe("data/device/dev-1/action", 1, func(_ mqtt.Client, msg mqtt.Message) { actions <- string(msg.Payload()) })
+	if !token.WaitTimeout(5*time.Second) || token.Error() != nil {
+		t.Fatalf("subscribe failed: %v", token.Error())
+	}
+
+	device.Publish("data/device/dev-1/properties", 1, false, "dev-1:wrong").WaitTimeout(5 * time.Second)
+	device.Publish("data/device/dev-1/properties", 1, false, "dev-1:secret").WaitTimeout(5 * time.Second)
+	select {
+	case payload := <-received:
+		if payload != "dev-1:secret" {
+			t.Fatalf("received %q before the connection was identified", payload)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("message not delivered")
+	}
+
+	// The subscription made before identification is active now
+	server.Publish("data/device/dev-1/action", 1, false, "run").WaitTimeout(5 * time.Second)
+	select {
+	case payload := <-actions:
+		if payload != "run" {
+			t.Errorf("action payload = %q", payload)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("pending subscription not activated")
+	}
+}
+
+type certAuthenticator struct{ testAuthenticator }
+
+func (certAuthenticator) AuthenticateCertificate(clientID string, cert *x509.Certificate) (*Principal, error) {
+	return &Principal{DeviceUUID: cert.Subject.CommonName}, nil
+}
+
+func TestBrokerAuthenticatesClientCertificate(t *testing.T) {
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "dev-1"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth, x509.ExtKeyUsageServerAuth},
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+	pair := tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+
+	broker := New(certAuthenticator{testAuthenticator{}}, DeviceTopicACL{}, 0)
+	listener, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{
+		Certificates: []tls.Certificate{pair},
+		ClientCAs:    pool,
+		ClientAuth:   tls.VerifyClientCertIfGiven,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	go broker.Serve(listener)
+	defer broker.Close()
+
+	options := mqtt.NewClientOptions().AddBroker("ssl://" + listener.Addr().String()).SetClientID("dev-1")
+	options.SetTLSConfig(&tls.Config{RootCAs: pool, Certificates: []tls.Certificate{pair}})
+	options.SetAutoReconnect(false)
+	client := mqtt.NewClient(options)
+	if token := client.Connect(); !token.WaitTimeout(5*time.Second) || token.Error() != nil {
+		t.Fatalf("certificate login failed: %v", token.Error())
+	}
+	defer client.Disconnect(0)
+	token := client.Subscribe("data/device/dev-1/action", 1, nil)
+	token.WaitTimeout(5 * time.Second)
+	if sub, ok := token.(*mqtt.SubscribeToken); !ok || sub.Result()["data/device/dev-1/action"] == subackFailure {
+		t.Error("certificate connection not identified as its device")
+	}
+}
+
+func TestBrokerClientIDTakeover(t *testing.T) {
+	broker, addr := startTestBroker(t)
+	broker.ReserveClientID("server")
+
+	if _, err := connectTestClient(t, addr, "server", "dev-1", "secret"); err == nil {
+		t.Fatal("device connected with the reserved client identifier")
+	}
+
+	first, err := connectTestClient(t, addr, "shared", "dev-1", "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := connectTestClient(t, addr, "shared", "dev-2", "other"); err == nil {
+		t.Fatal("another device took over the client identifier")
+	}
+	time.Sleep(50 * time.Millisecond)
+	if !first.IsConnectionOpen() {
+		t.Fatal("connection closed by another device's connect")
+	}
+
+	if _, err := connectTestClient(t, addr, "shared", "dev-1", "secret"); err != nil {
+		t.Fatalf("device could not reconnect with its own client identifier: %v", err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for first.IsConnectionOpen() && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if first.IsConnectionOpen() {
+		t.Error("previous connection of the device still open")
+	}
+}
+
+// rawConnect opens an MQTT 3.1.1 connection without a client library, so a test controls every
+// acknowledgement. It returns the session present flag of the CONNACK.
+func rawConnect(t *testing.T, conn net.Conn, clientID, username, password string, clean bool) (*bufio.Reader, bool) {
+	t.Helper()
+	e := &encoder{}
+	e.string("MQTT")
+	e.byte(protocol311)
+	flags := byte(0xC0) // username, password
+	if clean {
+		flags |= 0x02
+	}
+	e.byte(flags)
+	e.uint16(30)
+	e.string(clientID)
+	e.string(username)
+	e.string(password)
+	conn.Write(e.frame(packetConnect << 4))
+
+	reader := bufio.NewReader(conn)
+	header, body := readFrame(t, conn, reader)
+	if header != packetConnack<<4 || len(body) < 2 || body[1] != connackAccepted {
+		t.Fatalf("connack = %x %x", header, body)
+	}
+	return reader, body[0]&0x01 != 0
+}
+
+func rawSubscribe(t *testing.T, conn net.Conn, reader *bufio.Reader, filter string, qos byte) {
+	t.Helper()
+	e := &encoder{}
+	e.uint16(1)
+	e.string(filter)
+	e.byte(qos)
+	conn.Write(e.frame(packetSubscribe<<4 | 0x02))
+	if header, body := readFrame(t, conn, reader); header != packetSuback<<4 || body[len(body)-1] != qos {
+		t.Fatalf("suback = %x %x", header, body)
+	}
+}
+
+func readFrame(t *testing.T, conn net.Conn, reader *bufio.Reader) (byte, []byte) {
+	t.Helper()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	header, err := reader.ReadByte()
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	length, _ := readVarInt(reader)
+	body := make([]byte, length)
+	if _, err := io.ReadFull(reader, body); err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	return header, body
+}
+
+func readPublish(t *testing.T, conn net.Conn, reader *bufio.Reader) (*packet, bool) {
+	t.Helper()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	p, err := readPacket(reader, protocol311, 0)
+	if err != nil || p.Type != packetPublish {
+		t.Fatalf("expected PUBLISH, got %+v (%v)", p, err)
+	}
+	return p, p.Flags&0x08 != 0
+}
+
+func connectInternalClient(t *testing.T, broker *Broker) mqtt.Client {
+	t.Helper()
+	options := mqtt.NewClientOptions().AddBroker("tcp://internal").SetClientID("server")
+	options.SetCustomOpenConnectionFn(func(*url.URL, mqtt.ClientOptions) (net.Conn, error) {
+		return broker.ConnectInternal(), nil
+	})
+	server := mqtt.NewClient(options)
+	if token := server.Connect(); !token.WaitTimeout(5*time.Second) || token.Error() != nil {
+		t.Fatalf("internal connect failed: %v", token.Error())
+	}
+	t.Cleanup(func() { server.Disconnect(0) })
+	return server
+}
+
+func TestBrokerResendsUnacknowledgedMessages(t *testing.T) {
+	broker, addr := startTestBroker(t)
+	server := connectInternalClient(t, broker)
+	dial := func() net.Conn {
+		conn, err := net.Dial("tcp", addr[len("tcp://"):])
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { conn.Close() })
+		return conn
+	}
+
+	conn := dial()
+	reader, present := rawConnect(t, conn, "dev-1", "dev-1", "secret", false)
+	if present {
+		t.Fatal("new session reported as present")
+	}
+	rawSubscribe(t, conn, reader, "data/device/dev-1/action", 1)
+
+	// The first action reaches the device but is never acknowledged
+	server.Publish("data/device/dev-1/action", 1, false, "first").WaitTimeout(5 * time.Second)
+	if p, _ := readPublish(t, conn, reader); string(p.Message.Payload) != "first" {
+		t.Fatalf("payload = %q", p.Message.Payload)
+	}
+	conn.Close()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		broker.mu.RLock()
+		stored := broker.sessions["dev-1"] != nil
+		broker.mu.RUnlock()
+		if stored {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// The second is sent while the device is away
+	server.Publish("data/device/dev-1/action", 1, false, "second").WaitTimeout(5 * time.Second)
+	server.Publish("data/device/dev-1/action", 0, false, "dropped").WaitTimeout(5 * time.Second)
+
+	conn = dial()
+	reader, present = rawConnect(t, conn, "dev-1", "dev-1", "secret", false)
+	if !present {
+		t.Fatal("session not resumed")
+	}
+	first, dup := readPublish(t, conn, reader)
+	if string(first.Message.Payload) != "first" || !dup {
+		t.Fatalf("first resend = %q (dup %v)", first.Message.Payload, dup)
+	}
+	second, dup := readPublish(t, conn, reader)
+	if string(second.Message.Payload) != "second" || dup {
+		t.Fatalf("second resend = %q (dup %v)", second.Message.Payload, dup)
+	}
+	conn.Write(encodeAck(protocol311, packetPuback, first.PacketID, 0))
+	conn.Write(encodeAck(protocol311, packetPuback, second.PacketID, 0))
+
+	// The subscription survived, and acknowledged messages are not sent again
+	server.Publish("data/device/dev-1/action", 1, false, "third").WaitTimeout(5 * time.Second)
+	if p, _ := readPublish(t, conn, reader); string(p.Message.Payload) != "third" {
+		t.Fatalf("payload = %q", p.Message.Payload)
+	}
+	conn.Close()
+
+	conn = dial()
+	reader, _ = rawConnect(t, conn, "dev-1", "dev-1", "secret", false)
+	if p, _ := readPublish(t, conn, reader); string(p.Message.Payload) != "third" {
+		t.Fatalf("only the unacknowledged message should be resent, got %q", p.Message.Payload)
+	}
+	conn.Close()
+
+	// A clean start discards the session
+	conn = dial()
+	if _, present := rawConnect(t, conn, "dev-1", "dev-1", "secret", true); present {
+		t.Error("clean start resumed the session")
+	}
+}
+
+func TestBrokerBackpressuresInternalClient(t *testing.T) {
+	broker, addr := startTestBroker(t)
+
+	// The server's client stops reading while devices publish more than its queue holds
+	conn := broker.ConnectInternal()
+	defer conn.Close()
+	reader, _ := rawConnect(t, conn, "server", "", "", true)
+	rawSubscribe(t, conn, reader, "data/device/+/properties", 0)
+
+	device, err := connectTestClient(t, addr, "dev-1", "dev-1", "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	const n = clientQueueSize * 2
+	go func() {
+		for i := 0; i < n; i++ {
+			device.Publish("data/device/dev-1/properties", 0, false, "sample")
+		}
+	}()
+	time.Sleep(200 * time.Millisecond)
+
+	broker.mu.RLock()
+	_, connected := broker.clients["server"]
+	broker.mu.RUnlock()
+	if !connected {
+		t.Fatal("internal client disconnected for falling behind")
+	}
+	for i := 0; i < n; i++ {
+		readPublish(t, conn, reader)
+	}
+}
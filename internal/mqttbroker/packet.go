@@ -0,0 +1,431 @@
+package mqttbroker
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Control packet types
+const (
+	packetConnect     byte = 1
+	packetConnack     byte = 2
+	packetPublish     byte = 3
+	packetPuback      byte = 4
+	packetPubrec      byte = 5
+	packetPubrel      byte = 6
+	packetPubcomp     byte = 7
+	packetSubscribe   byte = 8
+	packetSuback      byte = 9
+	packetUnsubscribe byte = 10
+	packetUnsuback    byte = 11
+	packetPingreq     byte = 12
+	packetPingresp    byte = 13
+	packetDisconnect  byte = 14
+)
+
+// Protocol levels
+const (
+	protocol311 byte = 4
+	protocol5   byte = 5
+)
+
+// CONNACK return codes (3.1.1) and the matching MQTT 5 reason codes
+const (
+	connackAccepted           byte = 0x00
+	connackBadProtocol        byte = 0x01
+	connackIdentifierRejected byte = 0x02
+	connackBadCredentials     byte = 0x04
+	connackNotAuthorized      byte = 0x05
+
+	reasonInvalidClientID    byte = 0x85
+	reasonBadCredentials     byte = 0x86
+	reasonNotAuthorized      byte = 0x87
+	reasonTopicFilterInvalid byte = 0x8F
+	reasonTopicNameInvalid   byte = 0x90
+)
+
+// subackFailure is the SUBACK code of a refused subscription in 3.1.1; MQTT 5 uses
+// reasonNotAuthorized or reasonTopicFilterInvalid instead.
+const subackFailure byte = 0x80
+
+var errMalformedPacket = errors.New("malformed packet")
+
+// packet is a decoded control packet. Only the fields of its type are set.
+type packet struct {
+	Type  byte
+	Flags byte
+
+	// CONNECT
+	ProtocolName  string
+	ProtocolLevel byte
+	CleanStart    bool
+	KeepAlive     uint16
+	ClientID      string
+	Will          *message
+	Username      string
+	HasUsername   bool
+	Password      []byte
+	HasPassword   bool
+
+	// PUBLISH, PUBACK and the QoS 2 flow, SUBSCRIBE, UNSUBSCRIBE
+	PacketID uint16
+	Message  message
+
+	// SUBSCRIBE and UNSUBSCRIBE
+	Filters []subscription
+}
+
+// message is an application message.
+type message struct {
+	Topic   string
+	Payload []byte
+	QoS     byte
+	Retain  bool
+}
+
+// subscription is a topic filter with its requested QoS.
+type subscription struct {
+	Filter string
+	QoS    byte
+}
+
+// readPacket reads one control packet. version selects the MQTT 5 layout once the CONNECT
+// has been read; properties are skipped, the broker does not act on them.
+func readPacket(r *bufio.Reader, version byte, maxSize int) (*packet, error) {
+	header, err := r.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+	length, err := readVarInt(r)
+	if err != nil {
+		return nil, err
+	}
+	if maxSize > 0 && length > maxSize {
+		return nil, fmt.Errorf("packet of %d bytes exceeds the limit of %d", length, maxSize)
+	}
+	body := make([]byte, length)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return nil, err
+	}
+
+	p := &packet{Type: header >> 4, Flags: header & 0x0F}
+	d := &decoder{buf: body}
+	switch p.Type {
+	case packetConnect:
+		err = p.decodeConnect(d)
+	case packetPublish:
+		err = p.decodePublish(d, version)
+	case packetPuback, packetPubrec, packetPubrel, packetPubcomp:
+		p.PacketID = d.uint16()
+	case packetSubscribe:
+		err = p.decodeSubscribe(d, version, true)
+	case packetUnsubscribe:
+		err = p.decodeSubscribe(d, version, false)
+	case packetPingreq, packetDisconnect:
+	default:
+		return nil, fmt.Errorf("%w: unexpected packet type %d", errMalformedPacket, p.Type)
+	}
+	if err == nil && d.err != nil {
+		err = d.err
+	}
+	if err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+func (p *packet) decodeConnect(d *decoder) error {
+	p.ProtocolName = d.string()
+	p.ProtocolLevel = d.byte()
+	if d.err != nil {
+		return d.err
+	}
+	if p.ProtocolName != "MQTT" || (p.ProtocolLevel != protocol311 && p.ProtocolLevel != protocol5) {
+		// The caller answers with the unsupported protocol code
+		return nil
+	}
+	flags := d.byte()
+	p.KeepAlive = d.uint16()
+	if p.ProtocolLevel == protocol5 {
+		d.skipProperties()
+	}
+	if flags&0x01 != 0 {
+		return fmt.Errorf("%w: reserved connect flag set", errMalformedPacket)
+	}
+	p.CleanStart = flags&0x02 != 0
+	p.ClientID = d.string()
+	if flags&0x04 != 0 {
+		if p.ProtocolLevel == protocol5 {
+			d.skipProperties()
+		}
+		p.Will = &message{
+			Topic:   d.string(),
+			Payload: d.binary(),
+			QoS:     (flags >> 3) & 0x03,
+			Retain:  flags&0x20 != 0,
+		}
+	}
+	if flags&0x80 != 0 {
+		p.Username, p.HasUsername = d.string(), true
+	}
+	if flags&0x40 != 0 {
+		p.Password, p.HasPassword = d.binary(), true
+	}
+	return d.err
+}
+
+func (p *packet) decodePublish(d *decoder, version byte) error {
+	p.Message.QoS = (p.Flags >> 1) & 0x03
+	p.Message.Retain = p.Flags&0x01 != 0
+	if p.Message.QoS > 2 {
+		return fmt.Errorf("%w: invalid QoS", errMalformedPacket)
+	}
+	p.Message.Topic = d.string()
+	if p.Message.QoS > 0 {
+		p.PacketID = d.uint16()
+	}
+	if version == protocol5 {
+		d.skipProperties()
+	}
+	p.Message.Payload = d.rest()
+	return d.err
+}
+
+func (p *packet) decodeSubscribe(d *decoder, version byte, withQoS bool) error {
+	if p.Flags != 0x02 {
+		return fmt.Errorf("%w: invalid subscribe flags", errMalformedPacket)
+	}
+	p.PacketID = d.uint16()
+	if version == protocol5 {
+		d.skipProperties()
+	}
+	for d.err == nil && len(d.buf) > 0 {
+		sub := subscription{Filter: d.string()}
+		if withQoS {
+			// MQTT 5 packs retain handling and no-local into the same byte
+			sub.QoS = d.byte() & 0x03
+		}
+		p.Filters = append(p.Filters, sub)
+	}
+	if d.err == nil && len(p.Filters) == 0 {
+		return fmt.Errorf("%w: no topic filters", errMalformedPacket)
+	}
+	return d.err
+}
+
+// encoder builds the variable header and payload of an outgoing packet.
+type encoder struct {
+	buf []byte
+}
+
+func (e *encoder) byte(b byte)     { e.buf = append(e.buf, b) }
+func (e *encoder) uint16(v uint16) { e.buf = binary.BigEndian.AppendUint16(e.buf, v) }
+func (e *encoder) string(s string) { e.uint16(uint16(len(s))); e.buf = append(e.buf, s...) }
+func (e *encoder) raw(b []byte)    { e.buf = append(e.buf, b...) }
+func (e *encoder) noProperties(v byte) {
+	if v == protocol5 {
+		e.byte(0)
+	}
+}
+
+// frame prefixes the fixed header.
+func (e *encoder) frame(header byte) []byte {
+	out := []byte{header}
+	out = appendVarInt(out, len(e.buf))
+	return append(out, e.buf...)
+}
+
+// encodeConnack encodes a CONNACK. assignedID is returned to MQTT 5 clients that connected
+// with an empty client identifier.
+func encodeConnack(version byte, code byte, assignedID string, sessionPresent bool) []byte {
+	e := &encoder{}
+	if sessionPresent {
+		e.byte(0x01)
+	} else {
+		e.byte(0x00)
+	}
+	e.byte(code)
+	if version == protocol5 {
+		props := &encoder{}
+		if assignedID != "" {
+			props.byte(0x12)
+			props.string(assignedID)
+		}
+		e.buf = appendVarInt(e.buf, len(props.buf))
+		e.raw(props.buf)
+	}
+	return e.frame(packetConnack << 4)
+}
+
+func encodePublish(version byte, msg message, packetID uint16) []byte {
+	e := &encoder{}
+	e.string(msg.Topic)
+	if msg.QoS > 0 {
+		e.uint16(packetID)
+	}
+	e.noProperties(version)
+	e.raw(msg.Payload)
+	header := packetPublish<<4 | msg.QoS<<1
+	if msg.Retain {
+		header |= 0x01
+	}
+	return e.frame(header)
+}
+
+// encodeAck encodes PUBACK, PUBREC, PUBREL and PUBCOMP. A zero reason is omitted, which MQTT 5
+// reads as success; other reasons are only sent to MQTT 5 clients.
+func encodeAck(version byte, packetType byte, packetID uint16, reason byte) []byte {
+	e := &encoder{}
+	e.uint16(packetID)
+	if version == protocol5 && reason != 0 {
+		e.byte(reason)
+	}
+	header := packetType << 4
+	if packetType == packetPubrel {
+		header |= 0x02
+	}
+	return e.frame(header)
+}
+
+func encodeSuback(version byte, packetID uint16, codes []byte) []byte {
+	e := &encoder{}
+	e.uint16(packetID)
+	e.noProperties(version)
+	e.raw(codes)
+	return e.frame(packetSuback << 4)
+}
+
+func encodeUnsuback(version byte, packetID uint16, count int) []byte {
+	e := &encoder{}
+	e.uint16(packetID)
+	e.noProperties(version)
+	if version == protocol5 {
+		for i := 0; i < count; i++ {
+			e.byte(0x00)
+		}
+	}
+	return e.frame(packetUnsuback << 4)
+}
+
+func encodePingresp() []byte {
+	return []byte{packetPingresp << 4, 0}
+}
+
+// encodeDisconnect is only sent to MQTT 5 clients, 3.1.1 has no server DISCONNECT.
+func encodeDisconnect(reason byte) []byte {
+	e := &encoder{}
+	e.byte(reason)
+	e.byte(0)
+	return e.frame(packetDisconnect << 4)
+}
+
+// decoder reads fields of a packet body, recording the first error.
+type decoder struct {
+	buf []byte
+	err error
+}
+
+func (d *decoder) fail() {
+	if d.err == nil {
+		d.err = errMalformedPacket
+	}
+}
+
+func (d *decoder) byte() byte {
+	if d.err != nil || len(d.buf) < 1 {
+		d.fail()
+		return 0
+	}
+	b := d.buf[0]
+	d.buf = d.buf[1:]
+	return b
+}
+
+func (d *decoder) uint16() uint16 {
+	if d.err != nil || len(d.buf) < 2 {
+		d.fail()
+		return 0
+	}
+	v := binary.BigEndian.Uint16(d.buf)
+	d.buf = d.buf[2:]
+	return v
+}
+
+func (d *decoder) binary() []byte {
+	n := int(d.uint16())
+	if d.err != nil || len(d.buf) < n {
+		d.fail()
+		return nil
+	}
+	b := append([]byte(nil), d.buf[:n]...)
+	d.buf = d.buf[n:]
+	return b
+}
+
+func (d *decoder) string() string {
+	return string(d.binary())
+}
+
+func (d *decoder) rest() []byte {
+	b := append([]byte(nil), d.buf...)
+	d.buf = nil
+	return b
+}
+
+func (d *decoder) skipProperties() {
+	if d.err != nil {
+		return
+	}
+	n, used, ok := varInt(d.buf)
+	if !ok || len(d.buf) < used+n {
+		d.fail()
+		return
+	}
+	d.buf = d.buf[used+n:]
+}
+
+// readVarInt reads a variable byte integer, as used for the remaining length.
+func readVarInt(r io.ByteReader) (int, error) {
+	value, multiplier := 0, 1
+	for i := 0; i < 4; i++ {
+		b, err := r.ReadByte()
+		if err != nil {
+			return 0, err
+		}
+		value += int(b&0x7F) * multiplier
+		if b&0x80 == 0 {
+			return value, nil
+		}
+		multiplier *= 128
+	}
+	return 0, fmt.Errorf("%w: remaining length too long", errMalformedPacket)
+}
+
+func varInt(buf []byte) (value, used int, ok bool) {
+	multiplier := 1
+	for i := 0; i < 4 && i < len(buf); i++ {
+		value += int(buf[i]&0x7F) * multiplier
+		if buf[i]&0x80 == 0 {
+			return value, i + 1, true
+		}
+		multiplier *= 128
+	}
+	return 0, 0, false
+}
+
+func appendVarInt(out []byte, value int) []byte {
+	for {
+		b := byte(value % 128)
+		value /= 128
+		if value > 0 {
+			b |= 0x80
+		}
+		out = append(out, b)
+		if value == 0 {
+			return out
+		}
+	}
+}
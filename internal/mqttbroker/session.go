@@ -0,0 +1,203 @@
+package mqttbroker
+
+import (
+	"log"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	defaultSessionExpiry = time.Hour
+	maxInflight          = 1000
+)
+
+// session is the state of a client identifier: its subscriptions and the QoS 1 and 2 messages
+// it has not acknowledged yet. The session of a client that connected without a clean start
+// outlives the connection; messages for it are kept while it is away and sent again, in order,
+// when it reconnects.
+type session struct {
+	principal  *Principal
+	persistent bool
+
+	mu       sync.Mutex
+	client   *client // nil while disconnected
+	subs     map[string]byte
+	inflight map[uint16]*inflightMessage
+	seq      uint64
+	nextID   uint16
+	expires  time.Time
+}
+
+// inflightMessage is an outbound QoS 1 or 2 message awaiting PUBACK, or PUBREC and PUBCOMP.
+type inflightMessage struct {
+	msg      message
+	seq      uint64
+	sent     bool // sent at least once, so a retransmission carries the DUP flag
+	released bool // PUBREC received, PUBREL outstanding
+}
+
+func newSession(principal *Principal, persistent bool) *session {
+	return &session{
+		principal:  principal,
+		persistent: persistent,
+		subs:       make(map[string]byte),
+		inflight:   make(map[uint16]*inflightMessage),
+	}
+}
+
+// matchingQoS returns the highest QoS granted to a subscription matching topic.
+func (s *session) matchingQoS(topic string) (byte, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var qos byte
+	found := false
+	for filter, granted := range s.subs {
+		if MatchTopic(filter, topic) {
+			if !found || granted > qos {
+				qos = granted
+			}
+			found = true
+		}
+	}
+	return qos, found
+}
+
+// subscribe records granted subscriptions.
+func (s *session) subscribe(principal *Principal, granted []subscription) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.principal = principal
+	for _, sub := range granted {
+		s.subs[sub.Filter] = sub.QoS
+	}
+}
+
+// deliver sends msg to the connected client, or keeps it for the next connection when it is
+// QoS 1 or 2. QoS 0 messages for a disconnected session are dropped.
+func (s *session) deliver(msg message) {
+	s.mu.Lock()
+	c := s.client
+	var packetID uint16
+	if msg.QoS > 0 {
+		packetID = s.track(msg, c != nil)
+	}
+	s.mu.Unlock()
+	if c != nil {
+		c.send(encodePublish(c.version, msg, packetID))
+	}
+}
+
+// track adds an inflight message under a free packet identifier. The oldest message is dropped
+// when the session already holds maxInflight. Callers hold s.mu.
+func (s *session) track(msg message, sent bool) uint16 {
+	if len(s.inflight) >= maxInflight {
+		var oldestID uint16
+		var oldest *inflightMessage
+		for id, m := range s.inflight {
+			if oldest == nil || m.seq < oldest.seq {
+				oldestID, oldest = id, m
+			}
+		}
+		delete(s.inflight, oldestID)
+		log.Printf("[MQTTBroker] Session of '%s' holds %d unacknowledged messages, dropped the oldest to '%s'", s.principalName(), maxInflight, oldest.msg.Topic)
+	}
+	for {
+		s.nextID++
+		if s.nextID == 0 {
+			s.nextID = 1
+		}
+		if _, used := s.inflight[s.nextID]; !used {
+			break
+		}
+	}
+	s.seq++
+	s.inflight[s.nextID] = &inflightMessage{msg: msg, seq: s.seq, sent: sent}
+	return s.nextID
+}
+
+// owner returns the principal the session belongs to.
+func (s *session) owner() *Principal {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.principal
+}
+
+func (s *session) principalName() string {
+	if s.principal.DeviceUUID != "" {
+		return s.principal.DeviceUUID
+	}
+	return "anonymous"
+}
+
+// acknowledge handles PUBACK, PUBREC and PUBCOMP for an outbound message. It reports whether a
+// PUBREL is due.
+func (s *session) acknowledge(packetType byte, packetID uint16) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	m, ok := s.inflight[packetID]
+	switch packetType {
+	case packetPubrec:
+		if ok {
+			m.released = true
+		}
+		// A PUBREC for an unknown identifier is still answered, so the client can finish
+		return true
+	default:
+		delete(s.inflight, packetID)
+		return false
+	}
+}
+
+// attach binds the session to a new connection and sends the messages it holds again, oldest
+// first: PUBLISH with the DUP flag when it was sent before, PUBREL when it was received.
+func (s *session) attach(c *client) {
+	s.mu.Lock()
+	s.client = c
+	s.expires = time.Time{}
+	ids := make([]uint16, 0, len(s.inflight))
+	for id := range s.inflight {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return s.inflight[ids[i]].seq < s.inflight[ids[j]].seq })
+	packets := make([][]byte, 0, len(ids))
+	for _, id := range ids {
+		m := s.inflight[id]
+		if m.released {
+			packets = append(packets, encodeAck(c.version, packetPubrel, id, 0))
+			continue
+		}
+		data := encodePublish(c.version, m.msg, id)
+		if m.sent {
+			data[0] |= 0x08 // DUP
+		}
+		m.sent = true
+		packets = append(packets, data)
+	}
+	s.mu.Unlock()
+
+	if len(packets) > 0 {
+		log.Printf("[MQTTBroker] Resending %d unacknowledged message(s) to client '%s'", len(packets), c.id)
+	}
+	for _, data := range packets {
+		c.send(data)
+	}
+}
+
+// detach unbinds the session from c, if c still holds it, and starts its expiry.
+func (s *session) detach(c *client, expiry time.Duration) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.client != c {
+		return false
+	}
+	s.client = nil
+	s.expires = time.Now().Add(expiry)
+	return true
+}
+
+func (s *session) expired(now time.Time) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.client == nil && now.After(s.expires)
+}
@@ -0,0 +1,48 @@
+package mqttbroker
+
+import "strings"
+
+// validTopicName reports whether name may be published to: not empty and without wildcards.
+func validTopicName(name string) bool {
+	return name != "" && !strings.ContainsAny(name, "+#\x00")
+}
+
+// validTopicFilter reports whether filter is well formed: '+' fills a whole level and '#' is
+// the whole last level.
+func validTopicFilter(filter string) bool {
+	if filter == "" || strings.ContainsRune(filter, '\x00') {
+		return false
+	}
+	levels := strings.Split(filter, "/")
+	for i, level := range levels {
+		if strings.ContainsAny(level, "+#") && len(level) > 1 {
+			return false
+		}
+		if level == "#" && i != len(levels)-1 {
+			return false
+		}
+	}
+	return true
+}
+
+// MatchTopic reports whether topic matches filter. Wildcards in the first level do not match
+// topics starting with '$'.
+func MatchTopic(filter, topic string) bool {
+	if strings.HasPrefix(topic, "$") && (strings.HasPrefix(filter, "+") || strings.HasPrefix(filter, "#")) {
+		return false
+	}
+	filterLevels := strings.Split(filter, "/")
+	topicLevels := strings.Split(topic, "/")
+	for i, level := range filterLevels {
+		if level == "#" {
+			return true
+		}
+		if i >= len(topicLevels) {
+			return false
+		}
+		if level != "+" && level != topicLevels[i] {
+			return false
+		}
+	}
+	return len(filterLevels) == len(topicLevels)
+}
@@ -0,0 +1,276 @@
+// Package pki is the built-in certificate authority that issues device client certificates. The
+// device UUID is the certificate's common name and a urn:omega3:device URI SAN.
+package pki
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	caCertFile = "ca.crt"
+	caKeyFile  = "ca.key"
+
+	caValidity = 10 * 365 * 24 * time.Hour
+
+	deviceURIPrefix = "urn:omega3:device:"
+)
+
+var ErrInvalidCSR = errors.New("invalid certificate signing request")
+
+// CA signs device certificates and revocation lists.
+type CA struct {
+	cert    *x509.Certificate
+	certPEM []byte
+	key     *ecdsa.PrivateKey
+}
+
+// LoadOrCreateCA loads the CA from dir, creating a new self-signed CA on first use.
+func LoadOrCreateCA(dir string) (*CA, error) {
+	certPath := filepath.Join(dir, caCertFile)
+	keyPath := filepath.Join(dir, caKeyFile)
+
+	certPEM, certErr := os.ReadFile(certPath)
+	keyPEM, keyErr := os.ReadFile(keyPath)
+	if certErr == nil && keyErr == nil {
+		return parseCA(certPEM, keyPEM)
+	}
+	if !os.IsNotExist(certErr) && certErr != nil {
+		return nil, certErr
+	}
+	if !os.IsNotExist(keyErr) && keyErr != nil {
+		return nil, keyErr
+	}
+
+	log.Printf("[PKI] Creating device CA in %s", dir)
+	certPEM, keyPEM, err := generateCA()
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("failed to create CA directory: %w", err)
+	}
+	if err := os.WriteFile(keyPath, keyPEM, 0600); err != nil {
+		return nil, fmt.Errorf("failed to write CA key: %w", err)
+	}
+	if err := os.WriteFile(certPath, certPEM, 0644); err != nil {
+		return nil, fmt.Errorf("failed to write CA certificate: %w", err)
+	}
+	return parseCA(certPEM, keyPEM)
+}
+
+func generateCA() (certPEM, keyPEM []byte, err error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to generate CA key: %w", err)
+	}
+	serial, err := randomSerial()
+	if err != nil {
+		return nil, nil, err
+	}
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{Organization: []string{"OMEGA3-IOT"}, CommonName: "OMEGA3-IOT Device CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(caValidity),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		MaxPathLenZero:        true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to create CA certificate: %w", err)
+	}
+	keyPEM, err = encodeKey(key)
+	if err != nil {
+		return nil, nil, err
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), keyPEM, nil
+}
+
+func parseCA(certPEM, keyPEM []byte) (*CA, error) {
+	certBlock, _ := pem.Decode(certPEM)
+	keyBlock, _ := pem.Decode(keyPEM)
+	if certBlock == nil || keyBlock == nil {
+		return nil, errors.New("CA certificate or key is not PEM encoded")
+	}
+	cert, err := x509.ParseCertificate(certBlock.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse CA certificate: %w", err)
+	}
+	key, err := x509.ParseECPrivateKey(keyBlock.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse CA key: %w", err)
+	}
+	return &CA{cert: cert, certPEM: certPEM, key: key}, nil
+}
+
+// Certificate returns the CA certificate.
+func (ca *CA) Certificate() *x509.Certificate {
+	return ca.cert
+}
+
+// CertificatePEM returns the PEM encoded CA certificate, for devices and brokers to trust.
+func (ca *CA) CertificatePEM() []byte {
+	return ca.certPEM
+}
+
+// CertPool returns a pool holding the CA certificate, for verifying client certificates.
+func (ca *CA) CertPool() *x509.CertPool {
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.cert)
+	return pool
+}
+
+// Issued reports whether cert was signed by this CA.
+func (ca *CA) Issued(cert *x509.Certificate) bool {
+	return cert.CheckSignatureFrom(ca.cert) == nil
+}
+
+// IssuedCertificate is a device certificate. PrivateKeyPEM is only set when the CA generated
+// the key pair.
+type IssuedCertificate struct {
+	Certificate    *x509.Certificate
+	CertificatePEM []byte
+	PrivateKeyPEM  []byte
+}
+
+// IssueDeviceCertificate issues a client certificate for deviceUUID. With a CSR the device keeps
+// its private key; otherwise a key pair is generated and returned. Only the public key of the CSR
+// is used, the subject is always the device.
+func (ca *CA) IssueDeviceCertificate(deviceUUID string, csrPEM []byte, validity time.Duration) (*IssuedCertificate, error) {
+	issued := &IssuedCertificate{}
+	var publicKey crypto.PublicKey
+	if len(csrPEM) > 0 {
+		block, _ := pem.Decode(csrPEM)
+		if block == nil || block.Type != "CERTIFICATE REQUEST" {
+			return nil, ErrInvalidCSR
+		}
+		csr, err := x509.ParseCertificateRequest(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidCSR, err)
+		}
+		if err := csr.CheckSignature(); err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidCSR, err)
+		}
+		publicKey = csr.PublicKey
+	} else {
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			return nil, fmt.Errorf("failed to generate device key: %w", err)
+		}
+		if issued.PrivateKeyPEM, err = encodeKey(key); err != nil {
+			return nil, err
+		}
+		publicKey = &key.PublicKey
+	}
+
+	serial, err := randomSerial()
+	if err != nil {
+		return nil, err
+	}
+	deviceURI, err := url.Parse(deviceURIPrefix + deviceUUID)
+	if err != nil {
+		return nil, err
+	}
+	notAfter := time.Now().Add(validity)
+	if notAfter.After(ca.cert.NotAfter) {
+		notAfter = ca.cert.NotAfter
+	}
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{Organization: []string{"OMEGA3-IOT"}, CommonName: deviceUUID},
+		URIs:         []*url.URL{deviceURI},
+		NotBefore:    time.Now().Add(-5 * time.Minute),
+		NotAfter:     notAfter,
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, publicKey, ca.key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create device certificate: %w", err)
+	}
+	if issued.Certificate, err = x509.ParseCertificate(der); err != nil {
+		return nil, err
+	}
+	issued.CertificatePEM = pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	return issued, nil
+}
+
+// RevokedCertificate is an entry of the revocation list.
+type RevokedCertificate struct {
+	SerialNumber string // hex, see SerialNumber
+	RevokedAt    time.Time
+}
+
+// CreateCRL signs a revocation list. number must increase with every list published.
+func (ca *CA) CreateCRL(revoked []RevokedCertificate, number int64, validity time.Duration) ([]byte, error) {
+	entries := make([]x509.RevocationListEntry, 0, len(revoked))
+	for _, r := range revoked {
+		serial, ok := new(big.Int).SetString(r.SerialNumber, 16)
+		if !ok {
+			return nil, fmt.Errorf("invalid serial number %q", r.SerialNumber)
+		}
+		entries = append(entries, x509.RevocationListEntry{SerialNumber: serial, RevocationTime: r.RevokedAt})
+	}
+	now := time.Now()
+	der, err := x509.CreateRevocationList(rand.Reader, &x509.RevocationList{
+		RevokedCertificateEntries: entries,
+		Number:                    big.NewInt(number),
+		ThisUpdate:                now,
+		NextUpdate:                now.Add(validity),
+	}, ca.cert, ca.key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create CRL: %w", err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "X509 CRL", Bytes: der}), nil
+}
+
+// SerialNumber formats the serial number of cert as stored with issued certificates.
+func SerialNumber(cert *x509.Certificate) string {
+	return hex.EncodeToString(cert.SerialNumber.Bytes())
+}
+
+// DeviceUUID returns the device a certificate was issued to: the urn:omega3:device URI SAN, or
+// else the common name.
+func DeviceUUID(cert *x509.Certificate) string {
+	for _, uri := range cert.URIs {
+		if s := uri.String(); strings.HasPrefix(s, deviceURIPrefix) {
+			return strings.TrimPrefix(s, deviceURIPrefix)
+		}
+	}
+	return cert.Subject.CommonName
+}
+
+func randomSerial() (*big.Int, error) {
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 127))
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate serial number: %w", err)
+	}
+	// Keep the hex form free of a leading zero byte
+	return serial.SetBit(serial, 126, 1), nil
+}
+
+func encodeKey(key *ecdsa.PrivateKey) ([]byte, error) {
+	der, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal private key: %w", err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der}), nil
+}
@@ -0,0 +1,74 @@
+package pki
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"testing"
+	"time"
+)
+
+func TestIssueAndRevokeDeviceCertificate(t *testing.T) {
+	dir := t.TempDir()
+	ca, err := LoadOrCreateCA(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reloaded, err := LoadOrCreateCA(dir)
+	if err != nil || !reloaded.Certificate().Equal(ca.Certificate()) {
+		t.Fatalf("reloading the CA: %v", err)
+	}
+
+	const deviceUUID = "550e8400-e29b-41d4-a716-446655440000"
+	issued, err := ca.IssueDeviceCertificate(deviceUUID, nil, 24*time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(issued.PrivateKeyPEM) == 0 {
+		t.Error("generated key pair not returned")
+	}
+	if got := DeviceUUID(issued.Certificate); got != deviceUUID {
+		t.Errorf("DeviceUUID = %q", got)
+	}
+	_, err = issued.Certificate.Verify(x509.VerifyOptions{
+		Roots:     ca.CertPool(),
+		KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	})
+	if err != nil {
+		t.Errorf("device certificate does not verify: %v", err)
+	}
+
+	// With a CSR only its public key is used
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	csrDER, _ := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{Subject: pkix.Name{CommonName: "other-device"}}, key)
+	csrPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: csrDER})
+	fromCSR, err := ca.IssueDeviceCertificate(deviceUUID, csrPEM, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fromCSR.PrivateKeyPEM != nil || fromCSR.Certificate.Subject.CommonName != deviceUUID || !key.PublicKey.Equal(fromCSR.Certificate.PublicKey) {
+		t.Error("certificate from CSR must carry the device subject and the CSR key")
+	}
+	if _, err := ca.IssueDeviceCertificate(deviceUUID, []byte("garbage"), time.Hour); err == nil {
+		t.Error("invalid CSR accepted")
+	}
+
+	crlPEM, err := ca.CreateCRL([]RevokedCertificate{{SerialNumber: SerialNumber(issued.Certificate), RevokedAt: time.Now()}}, 1, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	block, _ := pem.Decode(crlPEM)
+	crl, err := x509.ParseRevocationList(block.Bytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := crl.CheckSignatureFrom(ca.Certificate()); err != nil {
+		t.Errorf("CRL signature: %v", err)
+	}
+	if len(crl.RevokedCertificateEntries) != 1 || crl.RevokedCertificateEntries[0].SerialNumber.Cmp(issued.Certificate.SerialNumber) != 0 {
+		t.Errorf("CRL entries = %+v", crl.RevokedCertificateEntries)
+	}
+}
@@ -24,6 +24,8 @@ type Client struct {
 	SendCh   chan []byte
 	mu       sync.Mutex
 	closed   bool
+
+	resumeSeq int64 // last sequence number of the user's stream when the client registered
 }
 
 // NewClient creates a new Client.
@@ -1,8 +1,10 @@
 package push
 
 import (
+	"OMEGA3-IOT/internal/types"
 	"log"
 	"net/http"
+	"strconv"
 
 	"github.com/gin-gonic/gin"
 	"github.com/gorilla/websocket"
@@ -49,3 +51,68 @@ func (h *PushHandler) HandleWebSocket(c *gin.Context) {
 	go client.WritePump()
 	go client.ReadPump(h.pushService)
 }
+
+// ListInbox handles GET /users/me/inbox
+func (h *PushHandler) ListInbox(c *gin.Context) {
+	userUUID, exists := c.Get("user_uuid")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, types.NewErrorResponse(http.StatusUnauthorized, "User not authenticated"))
+		return
+	}
+
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	pageSize, _ := strconv.Atoi(c.DefaultQuery("page_size", "20"))
+	if page < 1 {
+		page = 1
+	}
+	if pageSize < 1 || pageSize > 100 {
+		pageSize = 20
+	}
+	unreadOnly := c.Query("unread") == "true"
+
+	msgs, total, unread, err := h.pushService.ListInbox(userUUID.(string), unreadOnly, pageSize, (page-1)*pageSize)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, types.NewErrorResponse(http.StatusInternalServerError, "Failed to get inbox", err.Error()))
+		return
+	}
+
+	c.JSON(http.StatusOK, types.NewSuccessResponseWithCode(gin.H{
+		"messages":  msgs,
+		"total":     total,
+		"unread":    unread,
+		"page":      page,
+		"page_size": pageSize,
+	}, http.StatusOK, "OK"))
+}
+
+// MarkInboxRead handles POST /users/me/inbox/read
+func (h *PushHandler) MarkInboxRead(c *gin.Context) {
+	userUUID, exists := c.Get("user_uuid")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, types.NewErrorResponse(http.StatusUnauthorized, "User not authenticated"))
+		return
+	}
+
+	var input struct {
+		IDs []uint64 `json:"ids"`
+		All bool     `json:"all"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, types.NewErrorResponse(http.StatusBadRequest, "Invalid request parameters", err.Error()))
+		return
+	}
+	if len(input.IDs) == 0 && !input.All {
+		c.JSON(http.StatusBadRequest, types.NewErrorResponse(http.StatusBadRequest, "Invalid request parameters", "ids or all is required"))
+		return
+	}
+	if input.All {
+		input.IDs = nil
+	}
+
+	marked, err := h.pushService.MarkInboxRead(userUUID.(string), input.IDs)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, types.NewErrorResponse(http.StatusInternalServerError, "Failed to mark messages read", err.Error()))
+		return
+	}
+	c.JSON(http.StatusOK, types.NewSuccessResponseWithCode(gin.H{"marked": marked}, http.StatusOK, "OK"))
+}
@@ -7,23 +7,32 @@ import (
 
 // Message types — server → client
 const (
-	TypeEventPush       = "event.push"
-	TypeDeviceStatus    = "device.status"
-	TypePropertyUpdate  = "property.update"
-	TypeActionResult    = "action.result"
-	TypeSystemNotice    = "system.notice"
-	TypePong            = "pong"
-	TypeActionResponse  = "action.response"
+	TypeEventPush      = "event.push"
+	TypeDeviceStatus   = "device.status"
+	TypePropertyUpdate = "property.update"
+	TypeActionResult   = "action.result"
+	TypeSystemNotice   = "system.notice"
+	TypePong           = "pong"
+	TypeActionResponse = "action.response"
+	TypeShadowUpdate   = "shadow.update"
+	TypeShadowResponse = "shadow.response"
+	TypeAlert          = "alert"
+	TypeSession        = "session"
+	TypeResumed        = "resumed"
+	TypeResync         = "resync"
 )
 
 // Message types — client → server
 const (
 	TypeACK        = "ack"
 	TypeActionSend = "action.send"
+	TypeShadowSet  = "shadow.set"
 	TypePing       = "ping"
+	TypeResume     = "resume"
 )
 
-// Message is the envelope for all WebSocket communication.
+// Message is the envelope for all WebSocket communication. Messages pushed to a user carry the
+// next sequence number of the user's stream; replies to a client message have none.
 type Message struct {
 	Type    string      `json:"type"`
 	Seq     int64       `json:"seq,omitempty"`
@@ -52,8 +61,10 @@ func NewSequencedMessage(msgType string, seq int64, payload interface{}) *Messag
 
 // ─── Server → Client Payloads ───
 
-// EventPushPayload is sent when a device reports an event.
+// EventPushPayload is sent when a device reports an event. MessageID is the inbox message of a
+// warning or critical event, for marking it read.
 type EventPushPayload struct {
+	MessageID  uint64      `json:"message_id,omitempty"`
 	EventKey   string      `json:"event_key"`
 	DeviceUUID string      `json:"device_uuid"`
 	Severity   string      `json:"severity"`
@@ -75,8 +86,10 @@ type PropertyUpdatePayload struct {
 
 // ActionResultPayload is sent when an action execution result arrives.
 type ActionResultPayload struct {
+	ActionID   string `json:"action_id,omitempty"`
 	DeviceUUID string `json:"device_uuid"`
 	Command    string `json:"command"`
+	Status     string `json:"status"`
 	Success    bool   `json:"success"`
 	Error      string `json:"error,omitempty"`
 }
@@ -88,9 +101,70 @@ type SystemNoticePayload struct {
 }
 
 // ActionResponsePayload is sent in response to an action.send from the client.
+// RequestID echoes the client's request_id; ActionID identifies the later action.result.
 type ActionResponsePayload struct {
-	Success bool   `json:"success"`
-	Error   string `json:"error,omitempty"`
+	RequestID string `json:"request_id,omitempty"`
+	ActionID  string `json:"action_id,omitempty"`
+	Status    string `json:"status,omitempty"`
+	Success   bool   `json:"success"`
+	Error     string `json:"error,omitempty"`
+}
+
+// ShadowUpdatePayload is sent when a device shadow changes.
+type ShadowUpdatePayload struct {
+	DeviceUUID string                 `json:"device_uuid"`
+	Version    int64                  `json:"version"`
+	Desired    map[string]interface{} `json:"desired"`
+	Reported   map[string]interface{} `json:"reported"`
+	Delta      map[string]interface{} `json:"delta"`
+}
+
+// ShadowResponsePayload is sent in response to a shadow.set from the client.
+type ShadowResponsePayload struct {
+	RequestID string `json:"request_id,omitempty"`
+	Version   int64  `json:"version,omitempty"`
+	Success   bool   `json:"success"`
+	Error     string `json:"error,omitempty"`
+}
+
+// AlertPayload is sent to the rule owner when an alert fires or resolves.
+// State is "firing" or "resolved"; Reason is set when a rule change resolved the alert.
+type AlertPayload struct {
+	RuleUUID   string  `json:"rule_uuid"`
+	RuleName   string  `json:"rule_name"`
+	DeviceUUID string  `json:"device_uuid"`
+	Property   string  `json:"property"`
+	Operator   string  `json:"operator"`
+	Threshold  float64 `json:"threshold"`
+	Value      float64 `json:"value"`
+	Severity   string  `json:"severity"`
+	State      string  `json:"state"`
+	FiredAt    int64   `json:"fired_at"`
+	ResolvedAt int64   `json:"resolved_at,omitempty"`
+	Reason     string  `json:"reason,omitempty"`
+}
+
+// SessionPayload is sent when a socket connects: the epoch and last sequence number of the
+// user's message stream.
+type SessionPayload struct {
+	Epoch string `json:"epoch"`
+	Seq   int64  `json:"seq"`
+}
+
+// ResumedPayload is sent after the messages requested by resume have been replayed.
+type ResumedPayload struct {
+	Epoch    string `json:"epoch"`
+	FromSeq  int64  `json:"from_seq"`
+	ToSeq    int64  `json:"to_seq"`
+	Replayed int    `json:"replayed"`
+}
+
+// ResyncPayload is sent when the messages requested by resume cannot be replayed; the client
+// reloads its state over REST and continues from Seq.
+type ResyncPayload struct {
+	Epoch  string `json:"epoch"`
+	Seq    int64  `json:"seq"`
+	Reason string `json:"reason"`
 }
 
 // ─── Client → Server Payloads ───
@@ -100,11 +174,28 @@ type ACKPayload struct {
 	ACKSeq int64 `json:"ack_seq"`
 }
 
+// ResumePayload is sent by a reconnecting client with the epoch and last sequence number it saw.
+type ResumePayload struct {
+	Epoch   string `json:"epoch"`
+	LastSeq int64  `json:"last_seq"`
+}
+
 // ActionSendPayload is sent by the client to trigger a device action.
 type ActionSendPayload struct {
+	RequestID  string                 `json:"request_id,omitempty"`
 	DeviceUUID string                 `json:"device_uuid"`
 	Command    string                 `json:"command"`
 	Params     map[string]interface{} `json:"params,omitempty"`
+	TTLSec     int                    `json:"ttl_sec,omitempty"`
+}
+
+// ShadowSetPayload is sent by the client to set desired property values of a device.
+// Version, if given, must match the current shadow version.
+type ShadowSetPayload struct {
+	RequestID  string                 `json:"request_id,omitempty"`
+	DeviceUUID string                 `json:"device_uuid"`
+	Desired    map[string]interface{} `json:"desired"`
+	Version    *int64                 `json:"version,omitempty"`
 }
 
 // IncomingMessage is the raw message received from the client.
@@ -3,39 +3,76 @@ package push
 import (
 	"OMEGA3-IOT/internal/eventbus"
 	"OMEGA3-IOT/internal/logger"
+	"OMEGA3-IOT/internal/model"
 	"OMEGA3-IOT/internal/repository"
+	"OMEGA3-IOT/internal/utils"
 	"context"
 	"encoding/json"
 	"log"
 	"sync"
-	"sync/atomic"
 	"time"
 )
 
 const (
-	ackTimeout     = 10 * time.Second
-	maxRetransmit  = 1
-	cleanInterval  = 30 * time.Second
+	ackTimeout       = 10 * time.Second
+	maxRetransmit    = 1
+	ackCheckInterval = 5 * time.Second
+	maxOfflineBatch  = 100       // inbox messages pushed when a socket connects
+	actionOriginTTL  = time.Hour // how long a socket waits for the result of an action without a deadline
 )
 
-// pendingMessage tracks an unacknowledged push message.
+// ackKey identifies a message awaiting an ACK; sequence numbers are per user.
+type ackKey struct {
+	userUUID string
+	seq      int64
+}
+
+// pendingMessage tracks an unacknowledged push message. messageID is the inbox message marked
+// delivered by the ACK, 0 if the message is not kept in the inbox.
 type pendingMessage struct {
-	seq        int64
-	data       []byte
+	userUUID   string
+	msg        *Message
+	messageID  uint64
 	sentAt     time.Time
 	retransmit int
 }
 
+// ActionSender dispatches a validated action to a device (implemented by service.ActionService).
+type ActionSender interface {
+	SendActionWithID(actionID, instanceUUID, command string, params map[string]interface{}, requestedBy string, queueTTLSec int) (*model.ActionRecord, error)
+}
+
+// actionOrigin is the socket an action was sent from, waiting for its result until expires.
+type actionOrigin struct {
+	client  *Client
+	expires time.Time
+}
+
+// ShadowSetter updates the desired state of a device shadow (implemented by service.ShadowService).
+type ShadowSetter interface {
+	SetDesired(instanceUUID string, desired map[string]interface{}, expectedVersion *int64, requestedBy string) (*model.DeviceShadow, error)
+}
+
+// AccessChecker checks a user's permission on a device (implemented by service.DeviceShareService).
+type AccessChecker interface {
+	CheckDeviceAccess(instanceUUID string, userUUID string, requiredPermission string) (bool, error)
+}
+
 // PushService manages WebSocket clients and routes EventBus events to them.
 type PushService struct {
-	clients      sync.Map // userUUID → []*Client
-	eventBus     *eventbus.EventBus
-	instanceRepo repository.InstanceRepository
-	userRepo     repository.UserRepository
-	seqCounter   int64
-	pendingACKs  sync.Map // int64 → *pendingMessage
-	stopCh       chan struct{}
-	wg           sync.WaitGroup
+	clients       sync.Map // userUUID → []*Client
+	eventBus      *eventbus.EventBus
+	instanceRepo  repository.InstanceRepository
+	userRepo      repository.UserRepository
+	actionSender  ActionSender
+	shadowSetter  ShadowSetter
+	accessChecker AccessChecker
+	messageRepo   repository.OfflineMessageRepository
+	actionOrigins sync.Map // actionID → *actionOrigin
+	sessions      sync.Map // userUUID → *session
+	pendingACKs   sync.Map // ackKey → *pendingMessage
+	stopCh        chan struct{}
+	wg            sync.WaitGroup
 }
 
 // NewPushService creates a new PushService.
@@ -43,22 +80,34 @@ func NewPushService(
 	eventBus *eventbus.EventBus,
 	instanceRepo repository.InstanceRepository,
 	userRepo repository.UserRepository,
+	actionSender ActionSender,
+	shadowSetter ShadowSetter,
+	accessChecker AccessChecker,
+	messageRepo repository.OfflineMessageRepository,
 ) *PushService {
 	return &PushService{
-		eventBus:     eventBus,
-		instanceRepo: instanceRepo,
-		userRepo:     userRepo,
-		stopCh:       make(chan struct{}),
+		eventBus:      eventBus,
+		instanceRepo:  instanceRepo,
+		userRepo:      userRepo,
+		actionSender:  actionSender,
+		shadowSetter:  shadowSetter,
+		accessChecker: accessChecker,
+		messageRepo:   messageRepo,
+		stopCh:        make(chan struct{}),
 	}
 }
 
-// Start subscribes to EventBus events and starts the ACK retransmit checker.
+// Start subscribes to EventBus events and starts the ACK retransmit checker. The subscriptions are
+// broadcast: every server process pushes to the WebSocket clients connected to it.
 func (ps *PushService) Start() {
-	eventbus.SubscribeTyped(ps.eventBus, eventbus.EventType(logger.LogEventDeviceStatusChange), ps.handleStatusChange)
-	eventbus.SubscribeTyped(ps.eventBus, eventbus.EventType(logger.LogEventDevicePropertyUpdate), ps.handlePropertyUpdate)
-	eventbus.SubscribeTyped(ps.eventBus, eventbus.EventType("device.event.received"), ps.handleEventPush)
-	eventbus.SubscribeTyped(ps.eventBus, eventbus.EventType(logger.LogEventDeviceActionResult), ps.handleActionResult)
-	log.Println("[PushService] Subscribed to device.status.change, device.property.update, device.event.received, device.action.result")
+	eventbus.SubscribeTyped(ps.eventBus, eventbus.EventType(logger.LogEventDeviceStatusChange), ps.handleStatusChange, eventbus.Broadcast())
+	eventbus.SubscribeTyped(ps.eventBus, eventbus.EventType(logger.LogEventDevicePropertyUpdate), ps.handlePropertyUpdate, eventbus.Broadcast())
+	eventbus.SubscribeTyped(ps.eventBus, eventbus.EventType(logger.LogEventDeviceEventReceived), ps.handleEventPush, eventbus.Broadcast())
+	eventbus.SubscribeTyped(ps.eventBus, eventbus.EventType(logger.LogEventDeviceActionComplete), ps.handleActionComplete, eventbus.Broadcast())
+	eventbus.SubscribeTyped(ps.eventBus, eventbus.EventType(logger.LogEventDeviceShadowUpdate), ps.handleShadowUpdate, eventbus.Broadcast())
+	eventbus.SubscribeTyped(ps.eventBus, eventbus.EventType(logger.LogEventAlertFired), ps.handleAlert, eventbus.Broadcast())
+	eventbus.SubscribeTyped(ps.eventBus, eventbus.EventType(logger.LogEventAlertResolved), ps.handleAlert, eventbus.Broadcast())
+	log.Println("[PushService] Subscribed to device.status.change, device.property.update, device.event.received, device.action.complete, device.shadow.update, alert.fired, alert.resolved")
 
 	// Background ACK retransmit checker
 	ps.wg.Add(1)
@@ -82,15 +131,22 @@ func (ps *PushService) Stop() {
 	log.Println("[PushService] Stopped")
 }
 
-// Register adds a client to the push service.
+// Register adds a client to the push service and tells it the position of the user's message
+// stream, so a reconnecting client can resume from its last seen sequence number.
 func (ps *PushService) Register(client *Client) {
+	sess := ps.attachSession(client.UserUUID)
 	val, _ := ps.clients.LoadOrStore(client.UserUUID, make([]*Client, 0))
 	clients := val.([]*Client)
 	clients = append(clients, client)
 	ps.clients.Store(client.UserUUID, clients)
 	log.Printf("[PushService] Client registered: user=%s, total connections=%d", client.UserUUID, len(clients))
 
-	// Deliver offline critical messages
+	// Messages after this position are sent to the client live, earlier ones by resume
+	epoch, seq := sess.position()
+	client.resumeSeq = seq
+	client.Send(NewMessage(TypeSession, SessionPayload{Epoch: epoch, Seq: seq}))
+
+	// Deliver warning/critical events no socket has acknowledged yet
 	ps.deliverOfflineMessages(client)
 }
 
@@ -109,15 +165,33 @@ func (ps *PushService) Unregister(client *Client) {
 	}
 	if len(clients) == 0 {
 		ps.clients.Delete(client.UserUUID)
+		ps.detachSession(client.UserUUID)
 	} else {
 		ps.clients.Store(client.UserUUID, clients)
 	}
 	client.Close()
+
+	// Results for actions sent from this socket fall back to the user's other connections
+	ps.actionOrigins.Range(func(key, value interface{}) bool {
+		if value.(*actionOrigin).client == client {
+			ps.actionOrigins.Delete(key)
+		}
+		return true
+	})
 	log.Printf("[PushService] Client unregistered: user=%s", client.UserUUID)
 }
 
-// PushToUser sends a message to all connections of a specific user.
+// PushToUser sends a message to all connections of a specific user. The message gets the next
+// sequence number of the user's stream and is buffered for resume, also while the user is briefly
+// disconnected.
 func (ps *PushService) PushToUser(userUUID string, msg *Message) {
+	if sequenced := ps.sequence(userUUID, msg); sequenced != nil {
+		ps.sendToClients(userUUID, sequenced)
+	}
+}
+
+// sendToClients sends an already sequenced message to all connections of a user.
+func (ps *PushService) sendToClients(userUUID string, msg *Message) {
 	val, ok := ps.clients.Load(userUUID)
 	if !ok {
 		return
@@ -137,37 +211,22 @@ func (ps *PushService) PushToDeviceOwner(deviceUUID string, msg *Message) {
 	ps.PushToUser(instance.OwnerUUID, msg)
 }
 
-// nextSeq returns the next sequence number.
-func (ps *PushService) nextSeq() int64 {
-	return atomic.AddInt64(&ps.seqCounter, 1)
-}
-
-// sendWithACK sends a message and registers it for ACK tracking.
-func (ps *PushService) sendWithACK(userUUID string, msg *Message) {
-	msg.Seq = ps.nextSeq()
-
-	val, ok := ps.clients.Load(userUUID)
-	if !ok {
+// sendWithACK sends a message to all connections of a user and registers it for ACK tracking.
+// messageID is the inbox message the ACK marks delivered, 0 for none.
+func (ps *PushService) sendWithACK(userUUID string, msg *Message, messageID uint64) {
+	sequenced := ps.sequence(userUUID, msg)
+	if sequenced == nil {
 		return
 	}
-
-	data, err := json.Marshal(msg)
-	if err != nil {
-		log.Printf("[PushService] Failed to marshal message: %v", err)
-		return
-	}
-
-	pm := &pendingMessage{
-		seq:    msg.Seq,
-		data:   data,
-		sentAt: time.Now(),
-	}
-	ps.pendingACKs.Store(msg.Seq, pm)
-
-	clients := val.([]*Client)
-	for _, c := range clients {
-		c.Send(msg)
+	if _, ok := ps.clients.Load(userUUID); ok {
+		ps.pendingACKs.Store(ackKey{userUUID: userUUID, seq: sequenced.Seq}, &pendingMessage{
+			userUUID:  userUUID,
+			msg:       sequenced,
+			messageID: messageID,
+			sentAt:    time.Now(),
+		})
 	}
+	ps.sendToClients(userUUID, sequenced)
 }
 
 // ─── EventBus Handlers ───
@@ -198,54 +257,135 @@ func (ps *PushService) handlePropertyUpdate(ctx context.Context, event logger.De
 	return nil
 }
 
+// handleEventPush pushes a device event to the device owner. Warning and critical events are
+// kept in the owner's inbox and tracked for ACK, so they reach the owner even without a socket.
 func (ps *PushService) handleEventPush(ctx context.Context, event logger.DeviceLogEvent) error {
 	eventKey, _ := event.Metadata["event_key"].(string)
 	severity, _ := event.Metadata["severity"].(string)
 	data := event.Metadata["data"]
 
+	instance, err := ps.instanceRepo.FindByUUID(event.DeviceUUID)
+	if err != nil {
+		return nil
+	}
 	payload := EventPushPayload{
 		EventKey:   eventKey,
 		DeviceUUID: event.DeviceUUID,
 		Severity:   severity,
 		Data:       data,
 	}
-	// Use sendWithACK for warning/critical events
-	msg := NewSequencedMessage(TypeEventPush, ps.nextSeq(), payload)
-
-	// Store for ACK tracking
-	dataBytes, _ := json.Marshal(msg)
-	pm := &pendingMessage{
-		seq:    msg.Seq,
-		data:   dataBytes,
-		sentAt: time.Now(),
+	if severity != model.EventSeverityWarning && severity != model.EventSeverityCritical {
+		ps.PushToUser(instance.OwnerUUID, NewMessage(TypeEventPush, payload))
+		return nil
 	}
-	ps.pendingACKs.Store(msg.Seq, pm)
 
-	// Push to device owner
-	instance, err := ps.instanceRepo.FindByUUID(event.DeviceUUID)
-	if err != nil {
-		return nil
+	if eventID := metadataUint64(event.Metadata["event_id"]); eventID != 0 {
+		var dataJSON json.RawMessage
+		if data != nil {
+			dataJSON, _ = json.Marshal(data)
+		}
+		stored, err := ps.messageRepo.Store(&model.OfflineMessage{
+			UserUUID:   instance.OwnerUUID,
+			EventID:    eventID,
+			DeviceUUID: event.DeviceUUID,
+			EventKey:   eventKey,
+			Severity:   severity,
+			Data:       dataJSON,
+			CreatedAt:  event.Timestamp,
+		})
+		if err != nil {
+			// Still pushed to connected sockets, but lost for offline ones
+			log.Printf("[PushService] Failed to store event %d in the inbox of user %s: %v", eventID, instance.OwnerUUID, err)
+		} else {
+			payload.MessageID = stored.ID
+		}
 	}
-	ps.PushToUser(instance.OwnerUUID, msg)
+	ps.sendWithACK(instance.OwnerUUID, NewMessage(TypeEventPush, payload), payload.MessageID)
 	return nil
 }
 
-func (ps *PushService) handleActionResult(ctx context.Context, event logger.DeviceLogEvent) error {
+// handleActionComplete pushes the final state of an action. An action sent over WebSocket gets its
+// result on the socket that sent it; the device owner and the requesting user get it on their
+// streams unless that socket already reached them.
+func (ps *PushService) handleActionComplete(ctx context.Context, event logger.DeviceLogEvent) error {
+	actionID, _ := event.Metadata["action_id"].(string)
 	command, _ := event.Metadata["command"].(string)
+	status, _ := event.Metadata["status"].(string)
 	success, _ := event.Metadata["success"].(bool)
 	errMsg, _ := event.Metadata["error"].(string)
+	requestedBy, _ := event.Metadata["requested_by"].(string)
 
 	payload := ActionResultPayload{
+		ActionID:   actionID,
 		DeviceUUID: event.DeviceUUID,
 		Command:    command,
+		Status:     status,
 		Success:    success,
 		Error:      errMsg,
 	}
-	msg := NewMessage(TypeActionResult, payload)
+
+	recipients := make(map[string]bool)
+	if requestedBy != "" {
+		recipients[requestedBy] = true
+	}
+	if instance, err := ps.instanceRepo.FindByUUID(event.DeviceUUID); err == nil {
+		recipients[instance.OwnerUUID] = true
+	}
+	if val, ok := ps.actionOrigins.LoadAndDelete(actionID); ok {
+		origin := val.(*actionOrigin).client
+		origin.Send(NewMessage(TypeActionResult, payload))
+		delete(recipients, origin.UserUUID)
+	}
+	for userUUID := range recipients {
+		ps.PushToUser(userUUID, NewMessage(TypeActionResult, payload))
+	}
+	return nil
+}
+
+func (ps *PushService) handleShadowUpdate(ctx context.Context, event logger.DeviceLogEvent) error {
+	version := metadataInt64(event.Metadata["version"])
+	desired, _ := event.Metadata["desired"].(map[string]interface{})
+	reported, _ := event.Metadata["reported"].(map[string]interface{})
+	delta, _ := event.Metadata["delta"].(map[string]interface{})
+
+	payload := ShadowUpdatePayload{
+		DeviceUUID: event.DeviceUUID,
+		Version:    version,
+		Desired:    desired,
+		Reported:   reported,
+		Delta:      delta,
+	}
+	msg := NewMessage(TypeShadowUpdate, payload)
 	ps.PushToDeviceOwner(event.DeviceUUID, msg)
 	return nil
 }
 
+// handleAlert pushes fired and resolved alerts to the rule owner, tracked for ACK.
+func (ps *PushService) handleAlert(ctx context.Context, event logger.DeviceLogEvent) error {
+	ownerUUID, _ := event.Metadata["owner_uuid"].(string)
+	if ownerUUID == "" {
+		return nil
+	}
+	state := "firing"
+	if event.EventType == logger.LogEventAlertResolved {
+		state = "resolved"
+	}
+	payload := AlertPayload{DeviceUUID: event.DeviceUUID, State: state}
+	payload.RuleUUID, _ = event.Metadata["rule_uuid"].(string)
+	payload.RuleName, _ = event.Metadata["rule_name"].(string)
+	payload.Property, _ = event.Metadata["property"].(string)
+	payload.Operator, _ = event.Metadata["operator"].(string)
+	payload.Threshold, _ = event.Metadata["threshold"].(float64)
+	payload.Value, _ = event.Metadata["value"].(float64)
+	payload.Severity, _ = event.Metadata["severity"].(string)
+	payload.FiredAt = metadataInt64(event.Metadata["fired_at"])
+	payload.ResolvedAt = metadataInt64(event.Metadata["resolved_at"])
+	payload.Reason, _ = event.Metadata["reason"].(string)
+
+	ps.sendWithACK(ownerUUID, NewMessage(TypeAlert, payload), 0)
+	return nil
+}
+
 // ─── Client Message Handler ───
 
 // OnMessage implements MessageHandler.
@@ -257,7 +397,15 @@ func (ps *PushService) OnMessage(client *Client, msg *IncomingMessage) {
 			log.Printf("[PushService] Invalid ACK payload from user %s: %v", client.UserUUID, err)
 			return
 		}
-		ps.pendingACKs.Delete(payload.ACKSeq)
+		ps.acknowledge(client, payload.ACKSeq)
+
+	case TypeResume:
+		var payload ResumePayload
+		if err := json.Unmarshal(msg.Payload, &payload); err != nil {
+			log.Printf("[PushService] Invalid resume payload from user %s: %v", client.UserUUID, err)
+			return
+		}
+		ps.handleResume(client, &payload)
 
 	case TypePing:
 		pong := NewMessage(TypePong, nil)
@@ -272,6 +420,15 @@ func (ps *PushService) OnMessage(client *Client, msg *IncomingMessage) {
 		}
 		ps.handleActionSend(client, &payload)
 
+	case TypeShadowSet:
+		var payload ShadowSetPayload
+		if err := json.Unmarshal(msg.Payload, &payload); err != nil {
+			log.Printf("[PushService] Invalid shadow.set payload from user %s: %v", client.UserUUID, err)
+			client.Send(NewMessage(TypeShadowResponse, ShadowResponsePayload{Success: false, Error: "invalid payload"}))
+			return
+		}
+		ps.handleShadowSet(client, &payload)
+
 	default:
 		log.Printf("[PushService] Unknown message type '%s' from user %s", msg.Type, client.UserUUID)
 	}
@@ -282,30 +439,95 @@ func (ps *PushService) OnDisconnect(client *Client) {
 	ps.Unregister(client)
 }
 
+// handleActionSend forwards a WebSocket action through the same path as the REST action route.
 func (ps *PushService) handleActionSend(client *Client, payload *ActionSendPayload) {
-	// Verify the user has access to this device
-	instance, err := ps.instanceRepo.FindByUUID(payload.DeviceUUID)
+	reply := func(resp ActionResponsePayload) {
+		resp.RequestID = payload.RequestID
+		client.Send(NewMessage(TypeActionResponse, resp))
+	}
+
+	if payload.DeviceUUID == "" || payload.Command == "" {
+		reply(ActionResponsePayload{Success: false, Error: "device_uuid and command are required"})
+		return
+	}
+
+	hasAccess, err := ps.accessChecker.CheckDeviceAccess(payload.DeviceUUID, client.UserUUID, repository.PermissionWrite)
+	if err != nil || !hasAccess {
+		reply(ActionResponsePayload{Success: false, Error: "access denied"})
+		return
+	}
+
+	// Remember the originating socket before the device can answer, so the action.result goes
+	// back to it even when the device completes the action right away
+	actionID := utils.GenerateUUID().String()
+	origin := &actionOrigin{client: client, expires: time.Now().Add(actionOriginTTL)}
+	ps.actionOrigins.Store(actionID, origin)
+
+	record, err := ps.actionSender.SendActionWithID(actionID, payload.DeviceUUID, payload.Command, payload.Params, client.UserUUID, payload.TTLSec)
 	if err != nil {
-		client.Send(NewMessage(TypeActionResponse, ActionResponsePayload{Success: false, Error: "device not found"}))
+		ps.actionOrigins.Delete(actionID)
+		log.Printf("[PushService] Action '%s' for device %s from user %s rejected: %v", payload.Command, payload.DeviceUUID, client.UserUUID, err)
+		reply(ActionResponsePayload{Success: false, Error: err.Error()})
+		return
+	}
+	if record.Status == model.ActionStatusFailed {
+		ps.actionOrigins.Delete(actionID)
+		reply(ActionResponsePayload{Success: false, ActionID: record.ActionID, Status: record.Status, Error: record.Error})
+		return
+	}
+	if record.ExpiresAt > 0 {
+		// Pending actions time out at ExpiresAt, queued ones expire then; the completion event
+		// follows shortly after
+		ps.setOriginExpiry(actionID, origin, time.Unix(record.ExpiresAt, 0).Add(ackTimeout))
+	}
+	log.Printf("[PushService] Action '%s' (%s) sent to device %s by user %s", payload.Command, record.ActionID, payload.DeviceUUID, client.UserUUID)
+	reply(ActionResponsePayload{Success: true, ActionID: record.ActionID, Status: record.Status})
+}
+
+// handleShadowSet sets desired shadow values, with the same checks as the REST shadow route.
+func (ps *PushService) handleShadowSet(client *Client, payload *ShadowSetPayload) {
+	reply := func(resp ShadowResponsePayload) {
+		resp.RequestID = payload.RequestID
+		client.Send(NewMessage(TypeShadowResponse, resp))
+	}
+
+	if payload.DeviceUUID == "" {
+		reply(ShadowResponsePayload{Success: false, Error: "device_uuid is required"})
 		return
 	}
-	if instance.OwnerUUID != client.UserUUID {
-		client.Send(NewMessage(TypeActionResponse, ActionResponsePayload{Success: false, Error: "access denied"}))
+	hasAccess, err := ps.accessChecker.CheckDeviceAccess(payload.DeviceUUID, client.UserUUID, repository.PermissionWrite)
+	if err != nil || !hasAccess {
+		reply(ShadowResponsePayload{Success: false, Error: "access denied"})
 		return
 	}
 
-	// Forward to MQTT via the existing MQTTService would be ideal,
-	// but for now we publish an event that can be picked up.
-	// The actual MQTT publish should be done by a service that has access to the MQTT broker.
-	log.Printf("[PushService] Action '%s' requested for device %s by user %s", payload.Command, payload.DeviceUUID, client.UserUUID)
-	client.Send(NewMessage(TypeActionResponse, ActionResponsePayload{Success: true}))
+	shadow, err := ps.shadowSetter.SetDesired(payload.DeviceUUID, payload.Desired, payload.Version, client.UserUUID)
+	if err != nil {
+		reply(ShadowResponsePayload{Success: false, Error: err.Error()})
+		return
+	}
+	reply(ShadowResponsePayload{Success: true, Version: shadow.Version})
 }
 
 // ─── ACK Retransmit ───
 
+// acknowledge stops retransmitting a message and marks its inbox message delivered.
+func (ps *PushService) acknowledge(client *Client, seq int64) {
+	val, ok := ps.pendingACKs.LoadAndDelete(ackKey{userUUID: client.UserUUID, seq: seq})
+	if !ok {
+		return
+	}
+	pm := val.(*pendingMessage)
+	if pm.messageID != 0 {
+		if err := ps.messageRepo.MarkDelivered(pm.messageID, time.Now().Unix()); err != nil {
+			log.Printf("[PushService] Failed to mark inbox message %d delivered: %v", pm.messageID, err)
+		}
+	}
+}
+
 func (ps *PushService) retransmitLoop() {
 	defer ps.wg.Done()
-	ticker := time.NewTicker(cleanInterval)
+	ticker := time.NewTicker(ackCheckInterval)
 	defer ticker.Stop()
 
 	for {
@@ -314,25 +536,52 @@ func (ps *PushService) retransmitLoop() {
 			return
 		case <-ticker.C:
 			ps.checkPendingACKs()
+			ps.expireSessions(time.Now())
+			ps.expireActionOrigins(time.Now())
 		}
 	}
 }
 
+// setOriginExpiry moves the deadline of an origin that is still waiting for its result.
+func (ps *PushService) setOriginExpiry(actionID string, origin *actionOrigin, expires time.Time) {
+	ps.actionOrigins.CompareAndSwap(actionID, origin, &actionOrigin{client: origin.client, expires: expires})
+}
+
+// expireActionOrigins forgets origins whose action result did not arrive in time; a late result
+// goes to the streams of the requesting user and the device owner.
+func (ps *PushService) expireActionOrigins(now time.Time) {
+	ps.actionOrigins.Range(func(key, value interface{}) bool {
+		if now.After(value.(*actionOrigin).expires) {
+			ps.actionOrigins.Delete(key)
+		}
+		return true
+	})
+}
+
+// checkPendingACKs retransmits unacknowledged messages to the connections of their user. Inbox
+// messages that are still unacknowledged after the last retransmit stay undelivered and are
+// pushed again on the user's next connection.
 func (ps *PushService) checkPendingACKs() {
 	now := time.Now()
 	ps.pendingACKs.Range(func(key, value interface{}) bool {
 		pm := value.(*pendingMessage)
-		if now.Sub(pm.sentAt) > ackTimeout {
-			if pm.retransmit < maxRetransmit {
-				pm.retransmit++
-				pm.sentAt = now
-				// Retransmit to all clients of the user (we'd need to track userUUID)
-				// For simplicity, just delete — the message is lost after max retransmit
-				log.Printf("[PushService] ACK timeout for seq %d, retransmit #%d", pm.seq, pm.retransmit)
-			} else {
-				ps.pendingACKs.Delete(key)
-				log.Printf("[PushService] ACK timeout for seq %d, giving up", pm.seq)
-			}
+		if now.Sub(pm.sentAt) <= ackTimeout {
+			return true
+		}
+		_, connected := ps.clients.Load(pm.userUUID)
+		if connected && pm.retransmit < maxRetransmit {
+			pm.retransmit++
+			pm.sentAt = now
+			ps.sendToClients(pm.userUUID, pm.msg)
+			log.Printf("[PushService] ACK timeout for seq %d (user %s), retransmit #%d", pm.msg.Seq, pm.userUUID, pm.retransmit)
+			return true
+		}
+
+		ps.pendingACKs.Delete(key)
+		if pm.messageID != 0 {
+			log.Printf("[PushService] ACK timeout for seq %d (user %s), kept in inbox as message %d", pm.msg.Seq, pm.userUUID, pm.messageID)
+		} else {
+			log.Printf("[PushService] ACK timeout for seq %d (user %s), giving up", pm.msg.Seq, pm.userUUID)
 		}
 		return true
 	})
@@ -340,9 +589,96 @@ func (ps *PushService) checkPendingACKs() {
 
 // ─── Offline Messages ───
 
+// deliverOfflineMessages pushes the oldest inbox messages of the user that no socket has
+// acknowledged when a client registers. They go to all of the user's connections, as every
+// message of the user's stream does. Messages already awaiting an ACK are skipped.
 func (ps *PushService) deliverOfflineMessages(client *Client) {
-	// This would query the offline_messages table for undelivered critical messages
-	// and send them to the client. Implementation depends on the repository.
-	// For now, this is a placeholder.
-	log.Printf("[PushService] Checking offline messages for user %s", client.UserUUID)
+	msgs, err := ps.messageRepo.ListUndelivered(client.UserUUID, maxOfflineBatch)
+	if err != nil {
+		log.Printf("[PushService] Failed to load offline messages for user %s: %v", client.UserUUID, err)
+		return
+	}
+	if len(msgs) == 0 {
+		return
+	}
+
+	inflight := make(map[uint64]bool)
+	ps.pendingACKs.Range(func(key, value interface{}) bool {
+		if pm := value.(*pendingMessage); pm.messageID != 0 && pm.userUUID == client.UserUUID {
+			inflight[pm.messageID] = true
+		}
+		return true
+	})
+
+	delivered := 0
+	for _, m := range msgs {
+		if inflight[m.ID] {
+			continue
+		}
+		payload := EventPushPayload{
+			MessageID:  m.ID,
+			EventKey:   m.EventKey,
+			DeviceUUID: m.DeviceUUID,
+			Severity:   m.Severity,
+		}
+		if len(m.Data) > 0 {
+			payload.Data = m.Data
+		}
+		msg := NewMessage(TypeEventPush, payload)
+		msg.TS = m.CreatedAt
+		ps.sendWithACK(client.UserUUID, msg, m.ID)
+		delivered++
+	}
+	log.Printf("[PushService] Delivered %d offline messages to user %s", delivered, client.UserUUID)
+}
+
+// ListInbox returns the inbox messages of a user newest first, with the total and unread counts.
+func (ps *PushService) ListInbox(userUUID string, unreadOnly bool, limit, offset int) ([]model.OfflineMessage, int64, int64, error) {
+	return ps.messageRepo.List(userUUID, unreadOnly, limit, offset)
+}
+
+// MarkInboxRead marks inbox messages of a user read, all of them if ids is empty. Read messages
+// are no longer pushed on connect.
+func (ps *PushService) MarkInboxRead(userUUID string, ids []uint64) (int64, error) {
+	return ps.messageRepo.MarkRead(userUUID, ids, time.Now().Unix())
+}
+
+// metadataInt64 reads an integer such as a version or Unix time from event metadata, which holds
+// a float64 once the event has passed through a durable event bus.
+func metadataInt64(v interface{}) int64 {
+	switch n := v.(type) {
+	case int64:
+		return n
+	case int:
+		return int64(n)
+	case uint64:
+		return int64(n)
+	case float64:
+		return int64(n)
+	}
+	return 0
+}
+
+// metadataUint64 reads an ID from event metadata, which holds a float64 once the event has
+// passed through a durable event bus.
+func metadataUint64(v interface{}) uint64 {
+	switch n := v.(type) {
+	case uint64:
+		return n
+	case uint:
+		return uint64(n)
+	case int64:
+		if n > 0 {
+			return uint64(n)
+		}
+	case int:
+		if n > 0 {
+			return uint64(n)
+		}
+	case float64:
+		if n > 0 {
+			return uint64(n)
+		}
+	}
+	return 0
 }
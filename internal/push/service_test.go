@@ -0,0 +1,375 @@
+package push
+
+import (
+	"OMEGA3-IOT/internal/eventbus"
+	"OMEGA3-IOT/internal/logger"
+	"OMEGA3-IOT/internal/model"
+	"OMEGA3-IOT/internal/repository"
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+type fakeInstanceRepo struct {
+	repository.InstanceRepository
+	owner string
+}
+
+func (r fakeInstanceRepo) FindByUUID(instanceUUID string) (*model.Instance, error) {
+	return &model.Instance{InstanceUUID: instanceUUID, OwnerUUID: r.owner}, nil
+}
+
+type fakeMessageRepo struct {
+	repository.OfflineMessageRepository
+	msgs []*model.OfflineMessage
+}
+
+func (r *fakeMessageRepo) Store(msg *model.OfflineMessage) (*model.OfflineMessage, error) {
+	for _, m := range r.msgs {
+		if m.UserUUID == msg.UserUUID && m.EventID == msg.EventID {
+			return m, nil
+		}
+	}
+	msg.ID = uint64(len(r.msgs) + 1)
+	r.msgs = append(r.msgs, msg)
+	return msg, nil
+}
+
+func (r *fakeMessageRepo) ListUndelivered(userUUID string, limit int) ([]model.OfflineMessage, error) {
+	var msgs []model.OfflineMessage
+	for _, m := range r.msgs {
+		if m.UserUUID == userUUID && m.DeliveredAt == 0 {
+			msgs = append(msgs, *m)
+		}
+	}
+	return msgs, nil
+}
+
+func (r *fakeMessageRepo) MarkDelivered(id uint64, deliveredAt int64) error {
+	r.msgs[id-1].DeliveredAt = deliveredAt
+	return nil
+}
+
+func newTestService() (*PushService, *fakeMessageRepo) {
+	repo := &fakeMessageRepo{}
+	return NewPushService(nil, fakeInstanceRepo{owner: "user-1"}, nil, nil, nil, nil, repo), repo
+}
+
+func criticalEvent(eventID interface{}) logger.DeviceLogEvent {
+	event := logger.NewDeviceLogEvent("dev-1", logger.LogLevelInfo, "Event: smoke", logger.LogEventDeviceEventReceived)
+	event.Metadata["event_id"] = eventID
+	event.Metadata["event_key"] = "smoke"
+	event.Metadata["severity"] = model.EventSeverityCritical
+	return event
+}
+
+func receive(t *testing.T, c *Client) Message {
+	t.Helper()
+	select {
+	case data := <-c.SendCh:
+		var msg Message
+		if err := json.Unmarshal(data, &msg); err != nil {
+			t.Fatalf("invalid message: %v", err)
+		}
+		return msg
+	default:
+		t.Fatal("no message sent")
+		return Message{}
+	}
+}
+
+// connect registers a client and consumes its session message.
+func connect(t *testing.T, ps *PushService, userUUID string) (*Client, SessionPayload) {
+	t.Helper()
+	client := NewClient(userUUID, nil)
+	ps.Register(client)
+	msg := receive(t, client)
+	if msg.Type != TypeSession {
+		t.Fatalf("first message %+v, want session", msg)
+	}
+	var payload SessionPayload
+	data, _ := json.Marshal(msg.Payload)
+	json.Unmarshal(data, &payload)
+	return client, payload
+}
+
+func TestEventPushStoredWithoutSocketAndDeliveredOnRegister(t *testing.T) {
+	ps, repo := newTestService()
+
+	// A durable event bus delivers the event ID as a float64
+	ps.handleEventPush(context.Background(), criticalEvent(float64(7)))
+	ps.handleEventPush(context.Background(), criticalEvent(float64(7)))
+	if len(repo.msgs) != 1 || repo.msgs[0].EventID != 7 || repo.msgs[0].UserUUID != "user-1" {
+		t.Fatalf("stored %+v", repo.msgs)
+	}
+
+	client, _ := connect(t, ps, "user-1")
+	msg := receive(t, client)
+	if msg.Type != TypeEventPush || msg.Seq != 1 {
+		t.Fatalf("delivered %+v", msg)
+	}
+
+	// A second connection does not get the message again while it awaits the ACK
+	second, _ := connect(t, ps, "user-1")
+	if len(second.SendCh) != 0 {
+		t.Fatal("message awaiting an ACK pushed again")
+	}
+	if n := len(repo.msgs); n != 1 || repo.msgs[0].DeliveredAt != 0 {
+		t.Fatalf("delivered before ACK: %+v", repo.msgs[0])
+	}
+
+	ps.acknowledge(NewClient("user-2", nil), msg.Seq)
+	if repo.msgs[0].DeliveredAt != 0 {
+		t.Fatal("ACK from another user marked the message delivered")
+	}
+	ps.acknowledge(client, msg.Seq)
+	if repo.msgs[0].DeliveredAt == 0 {
+		t.Fatal("ACK did not mark the message delivered")
+	}
+}
+
+func TestCheckPendingACKsRetransmitsToUser(t *testing.T) {
+	ps, repo := newTestService()
+	client, _ := connect(t, ps, "user-1")
+
+	ps.handleEventPush(context.Background(), criticalEvent(uint64(9)))
+	first := receive(t, client)
+
+	expire := func() {
+		ps.pendingACKs.Range(func(key, value interface{}) bool {
+			value.(*pendingMessage).sentAt = time.Now().Add(-2 * ackTimeout)
+			return true
+		})
+	}
+	expire()
+	ps.checkPendingACKs()
+	if again := receive(t, client); again.Seq != first.Seq {
+		t.Fatalf("retransmitted seq %d, want %d", again.Seq, first.Seq)
+	}
+
+	expire()
+	ps.checkPendingACKs()
+	if _, ok := ps.pendingACKs.Load(ackKey{userUUID: "user-1", seq: first.Seq}); ok {
+		t.Fatal("message still pending after the last retransmit")
+	}
+	if repo.msgs[0].DeliveredAt != 0 {
+		t.Fatal("unacknowledged message marked delivered")
+	}
+}
+
+func TestSequencesArePerUser(t *testing.T) {
+	ps, _ := newTestService()
+	alice, _ := connect(t, ps, "alice")
+	bob, _ := connect(t, ps, "bob")
+
+	ps.PushToUser("alice", NewMessage(TypeSystemNotice, nil))
+	ps.PushToUser("alice", NewMessage(TypeSystemNotice, nil))
+	ps.PushToUser("bob", NewMessage(TypeSystemNotice, nil))
+	if a1, a2, b1 := receive(t, alice), receive(t, alice), receive(t, bob); a1.Seq != 1 || a2.Seq != 2 || b1.Seq != 1 {
+		t.Fatalf("seqs alice %d, %d, bob %d", a1.Seq, a2.Seq, b1.Seq)
+	}
+
+	// Users who have not connected recently have no stream
+	ps.PushToUser("carol", NewMessage(TypeSystemNotice, nil))
+	if _, ok := ps.sessions.Load("carol"); ok {
+		t.Fatal("stream started for a user without sockets")
+	}
+}
+
+func TestResumeReplaysGap(t *testing.T) {
+	ps, _ := newTestService()
+	client, session := connect(t, ps, "user-1")
+	for i := 0; i < 3; i++ {
+		ps.PushToUser("user-1", NewMessage(TypeSystemNotice, nil))
+		receive(t, client)
+	}
+
+	// Disconnected after seq 1; seqs 4 and 5 are pushed while no socket is connected
+	ps.clients.Delete("user-1")
+	ps.detachSession("user-1")
+	ps.PushToUser("user-1", NewMessage(TypeSystemNotice, nil))
+	ps.PushToUser("user-1", NewMessage(TypeSystemNotice, nil))
+
+	resumed, _ := connect(t, ps, "user-1")
+	resume := func(epoch string, lastSeq int64) {
+		payload, _ := json.Marshal(ResumePayload{Epoch: epoch, LastSeq: lastSeq})
+		ps.OnMessage(resumed, &IncomingMessage{Type: TypeResume, Payload: payload})
+	}
+	resume(session.Epoch, 1)
+	for want := int64(2); want <= 5; want++ {
+		if msg := receive(t, resumed); msg.Seq != want {
+			t.Fatalf("replayed seq %d, want %d", msg.Seq, want)
+		}
+	}
+	if msg := receive(t, resumed); msg.Type != TypeResumed {
+		t.Fatalf("got %+v, want resumed", msg)
+	}
+
+	resume("other-epoch", 1)
+	if msg := receive(t, resumed); msg.Type != TypeResync || msg.Payload.(map[string]interface{})["reason"] != ResyncUnknownEpoch {
+		t.Fatalf("got %+v, want resync", msg)
+	}
+
+	for i := 0; i < replayBufferSize; i++ {
+		ps.PushToUser("user-1", NewMessage(TypeSystemNotice, nil))
+	}
+	for len(resumed.SendCh) > 0 {
+		<-resumed.SendCh
+	}
+	resume(session.Epoch, 1)
+	if msg := receive(t, resumed); msg.Type != TypeResync || msg.Payload.(map[string]interface{})["reason"] != ResyncGapTooLarge {
+		t.Fatalf("got %+v, want resync", msg)
+	}
+}
+
+func TestExpiredSessionStartsNewEpoch(t *testing.T) {
+	ps, _ := newTestService()
+	_, first := connect(t, ps, "user-1")
+	ps.clients.Delete("user-1")
+	ps.detachSession("user-1")
+
+	ps.expireSessions(time.Now().Add(sessionIdleTTL + time.Second))
+	if _, ok := ps.sessions.Load("user-1"); ok {
+		t.Fatal("idle stream not expired")
+	}
+	if _, second := connect(t, ps, "user-1"); second.Epoch == first.Epoch || second.Seq != 0 {
+		t.Fatalf("new stream %+v after %+v", second, first)
+	}
+}
+
+func TestIntegerMetadataSurvivesCodec(t *testing.T) {
+	ps, _ := newTestService()
+	client, _ := connect(t, ps, "user-1")
+
+	// Replay decodes the event like a durable event bus, turning integers into float64
+	eb := eventbus.New()
+	eventbus.SubscribeTyped(eb, eventbus.EventType(logger.LogEventAlertResolved), ps.handleAlert, eventbus.WithGroup("push.alert"))
+	eventbus.SubscribeTyped(eb, eventbus.EventType(logger.LogEventDeviceShadowUpdate), ps.handleShadowUpdate, eventbus.WithGroup("push.shadow"))
+	replay := func(group string, event logger.DeviceLogEvent) Message {
+		t.Helper()
+		data, _ := json.Marshal(event)
+		if err := eb.Replay(context.Background(), group, "logger.device", data); err != nil {
+			t.Fatal(err)
+		}
+		return receive(t, client)
+	}
+
+	alert := logger.NewDeviceLogEvent("dev-1", logger.LogLevelInfo, "Alert resolved", logger.LogEventAlertResolved)
+	alert.Metadata["owner_uuid"] = "user-1"
+	alert.Metadata["fired_at"] = int64(1717000000)
+	alert.Metadata["resolved_at"] = int64(1717000300)
+	var alertPayload AlertPayload
+	data, _ := json.Marshal(replay("push.alert", alert).Payload)
+	json.Unmarshal(data, &alertPayload)
+	if alertPayload.FiredAt != 1717000000 || alertPayload.ResolvedAt != 1717000300 {
+		t.Errorf("alert times %d, %d", alertPayload.FiredAt, alertPayload.ResolvedAt)
+	}
+
+	shadow := logger.NewDeviceLogEvent("dev-1", logger.LogLevelInfo, "Shadow updated", logger.LogEventDeviceShadowUpdate)
+	shadow.Metadata["version"] = int64(12)
+	var shadowPayload ShadowUpdatePayload
+	data, _ = json.Marshal(replay("push.shadow", shadow).Payload)
+	json.Unmarshal(data, &shadowPayload)
+	if shadowPayload.Version != 12 {
+		t.Errorf("shadow version %d", shadowPayload.Version)
+	}
+}
+
+type allowAll struct{}
+
+func (allowAll) CheckDeviceAccess(instanceUUID, userUUID, requiredPermission string) (bool, error) {
+	return true, nil
+}
+
+// fakeActionSender hands every action to send, which may complete it before returning.
+type fakeActionSender struct {
+	send func(record *model.ActionRecord)
+}
+
+func (s fakeActionSender) SendActionWithID(actionID, instanceUUID, command string, params map[string]interface{}, requestedBy string, queueTTLSec int) (*model.ActionRecord, error) {
+	record := model.NewActionRecord(actionID, instanceUUID, command, nil, requestedBy, 0, false)
+	record.ExpiresAt = record.CreatedAt + int64(record.TimeoutSec)
+	s.send(record)
+	return record, nil
+}
+
+func completedEvent(record *model.ActionRecord) logger.DeviceLogEvent {
+	event := logger.NewDeviceLogEvent(record.InstanceUUID, logger.LogLevelInfo, "Action complete", logger.LogEventDeviceActionComplete)
+	event.Metadata["action_id"] = record.ActionID
+	event.Metadata["command"] = record.Command
+	event.Metadata["status"] = model.ActionStatusSucceeded
+	event.Metadata["success"] = true
+	event.Metadata["requested_by"] = record.RequestedBy
+	return event
+}
+
+func sendAction(ps *PushService, client *Client) {
+	payload, _ := json.Marshal(ActionSendPayload{RequestID: "r-1", DeviceUUID: "dev-1", Command: "on"})
+	ps.OnMessage(client, &IncomingMessage{Type: TypeActionSend, Payload: payload})
+}
+
+func TestActionResultGoesToOriginSocket(t *testing.T) {
+	ps, _ := newTestService()
+	ps.accessChecker = allowAll{}
+	origin, _ := connect(t, ps, "user-1")
+	other, _ := connect(t, ps, "user-1")
+
+	// The device answers before SendActionWithID returns
+	ps.actionSender = fakeActionSender{send: func(record *model.ActionRecord) {
+		ps.handleActionComplete(context.Background(), completedEvent(record))
+	}}
+	sendAction(ps, origin)
+
+	if msg := receive(t, origin); msg.Type != TypeActionResult || msg.Seq != 0 {
+		t.Fatalf("origin got %+v, want an unsequenced action.result", msg)
+	}
+	if msg := receive(t, origin); msg.Type != TypeActionResponse {
+		t.Fatalf("origin got %+v, want action.response", msg)
+	}
+	if len(other.SendCh) != 0 {
+		t.Fatal("action.result pushed to another socket of the user")
+	}
+	if n := countOrigins(ps); n != 0 {
+		t.Fatalf("%d origins left after the result", n)
+	}
+}
+
+func TestActionOriginExpires(t *testing.T) {
+	ps, _ := newTestService()
+	ps.accessChecker = allowAll{}
+	origin, _ := connect(t, ps, "user-1")
+	other, _ := connect(t, ps, "user-1")
+
+	var sent *model.ActionRecord
+	ps.actionSender = fakeActionSender{send: func(record *model.ActionRecord) { sent = record }}
+	sendAction(ps, origin)
+	receive(t, origin) // action.response
+
+	deadline := time.Unix(sent.ExpiresAt, 0).Add(ackTimeout)
+	ps.expireActionOrigins(deadline.Add(-time.Second))
+	if n := countOrigins(ps); n != 1 {
+		t.Fatalf("%d origins before the action deadline, want 1", n)
+	}
+	ps.expireActionOrigins(deadline.Add(time.Second))
+	if n := countOrigins(ps); n != 0 {
+		t.Fatalf("%d origins after the action deadline, want 0", n)
+	}
+
+	// A late result falls back to the user's stream
+	ps.handleActionComplete(context.Background(), completedEvent(sent))
+	for _, c := range []*Client{origin, other} {
+		if msg := receive(t, c); msg.Type != TypeActionResult || msg.Seq == 0 {
+			t.Fatalf("got %+v, want a sequenced action.result", msg)
+		}
+	}
+}
+
+func countOrigins(ps *PushService) int {
+	n := 0
+	ps.actionOrigins.Range(func(key, value interface{}) bool {
+		n++
+		return true
+	})
+	return n
+}
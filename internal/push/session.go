@@ -0,0 +1,143 @@
+package push
+
+import (
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	replayBufferSize = 128              // messages kept per user for resume, below the client send buffer
+	sessionIdleTTL   = 10 * time.Minute // a user's stream is dropped this long after the last socket closed
+)
+
+// Reasons sent with resync
+const (
+	ResyncUnknownEpoch = "unknown_epoch" // the stream was restarted or the client reconnected to another server
+	ResyncGapTooLarge  = "gap_too_large" // messages after last_seq are no longer buffered
+)
+
+// session is the message stream of a user, shared by all of the user's sockets on this server.
+// Every message pushed to the user gets the next sequence number and is kept in a ring buffer, so
+// a reconnecting client can ask for what it missed. The epoch changes whenever the stream starts
+// over, since sequence numbers of different streams are unrelated.
+type session struct {
+	mu        sync.Mutex
+	epoch     string
+	seq       int64
+	buffer    [replayBufferSize]*Message // message seq s is at s % replayBufferSize
+	idleSince time.Time                  // zero while the user has sockets
+	expired   bool
+}
+
+func newSession() *session {
+	return &session{epoch: uuid.NewString()}
+}
+
+// append returns a copy of msg with the next sequence number and buffers it.
+func (s *session) append(msg *Message) *Message {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.seq++
+	sequenced := *msg
+	sequenced.Seq = s.seq
+	s.buffer[s.seq%replayBufferSize] = &sequenced
+	return &sequenced
+}
+
+// position returns the epoch and the last sequence number of the stream.
+func (s *session) position() (string, int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.epoch, s.seq
+}
+
+// replay returns the messages after lastSeq up to upTo. If the client's epoch does not match
+// or the gap is no longer buffered, it returns the reason the client has to resync instead.
+func (s *session) replay(epoch string, lastSeq, upTo int64) ([]*Message, string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if epoch != s.epoch || lastSeq < 0 || lastSeq > s.seq {
+		return nil, ResyncUnknownEpoch
+	}
+	if lastSeq < s.seq-replayBufferSize {
+		return nil, ResyncGapTooLarge
+	}
+	var msgs []*Message
+	for seq := lastSeq + 1; seq <= upTo; seq++ {
+		msgs = append(msgs, s.buffer[seq%replayBufferSize])
+	}
+	return msgs, ""
+}
+
+// attachSession returns the stream of a user for a new socket, starting one if needed.
+func (ps *PushService) attachSession(userUUID string) *session {
+	for {
+		val, _ := ps.sessions.LoadOrStore(userUUID, newSession())
+		s := val.(*session)
+		s.mu.Lock()
+		if !s.expired {
+			s.idleSince = time.Time{}
+			s.mu.Unlock()
+			return s
+		}
+		s.mu.Unlock()
+		// Expired concurrently; start over with a new stream
+		ps.sessions.CompareAndDelete(userUUID, s)
+	}
+}
+
+// detachSession starts the idle timeout of a user's stream after the last socket closed.
+func (ps *PushService) detachSession(userUUID string) {
+	if val, ok := ps.sessions.Load(userUUID); ok {
+		s := val.(*session)
+		s.mu.Lock()
+		s.idleSince = time.Now()
+		s.mu.Unlock()
+	}
+}
+
+// expireSessions drops the streams of users without sockets for longer than sessionIdleTTL.
+func (ps *PushService) expireSessions(now time.Time) {
+	ps.sessions.Range(func(key, value interface{}) bool {
+		s := value.(*session)
+		s.mu.Lock()
+		if !s.idleSince.IsZero() && now.Sub(s.idleSince) > sessionIdleTTL {
+			s.expired = true
+			ps.sessions.CompareAndDelete(key, s)
+		}
+		s.mu.Unlock()
+		return true
+	})
+}
+
+// sequence assigns msg the next sequence number of the user's stream. It returns nil if the user
+// has no stream on this server, i.e. has not been connected recently.
+func (ps *PushService) sequence(userUUID string, msg *Message) *Message {
+	val, ok := ps.sessions.Load(userUUID)
+	if !ok {
+		return nil
+	}
+	return val.(*session).append(msg)
+}
+
+// handleResume replays the messages a reconnecting client missed since payload.LastSeq, up to the
+// position of the stream when the socket registered (later messages were sent to it live).
+func (ps *PushService) handleResume(client *Client, payload *ResumePayload) {
+	val, ok := ps.sessions.Load(client.UserUUID)
+	if !ok {
+		return
+	}
+	s := val.(*session)
+	msgs, reason := s.replay(payload.Epoch, payload.LastSeq, client.resumeSeq)
+	epoch, seq := s.position()
+	if reason != "" {
+		client.Send(NewMessage(TypeResync, ResyncPayload{Epoch: epoch, Seq: seq, Reason: reason}))
+		return
+	}
+	for _, msg := range msgs {
+		client.Send(msg)
+	}
+	client.Send(NewMessage(TypeResumed, ResumedPayload{Epoch: epoch, FromSeq: payload.LastSeq + 1, ToSeq: client.resumeSeq, Replayed: len(msgs)}))
+}
@@ -0,0 +1,97 @@
+package repository
+
+import (
+	"OMEGA3-IOT/internal/model"
+
+	"gorm.io/gorm"
+)
+
+// ActionRecordRepository defines the interface for action record data access.
+type ActionRecordRepository interface {
+	Create(record *model.ActionRecord) error
+	FindByActionID(actionID string) (*model.ActionRecord, error)
+	FindByInstanceUUID(instanceUUID string, limit, offset int) ([]model.ActionRecord, error)
+	CountByInstanceUUID(instanceUUID string) (int64, error)
+	FindOldestOutstandingByCommand(instanceUUID, command string) (*model.ActionRecord, error)
+	FindExpired(now int64, limit int) ([]model.ActionRecord, error)
+	FindQueuedByInstanceUUID(instanceUUID string) ([]model.ActionRecord, error)
+	// ClaimQueued moves a queued action to pending, counts the delivery attempt and starts its
+	// timeout from now. Non-idempotent actions can only be claimed while they have never been attempted.
+	ClaimQueued(actionID string, now int64) (bool, error)
+	// UpdateStatusIf applies fields only while the record is still in one of fromStatuses.
+	// It reports whether a row was updated, so concurrent transitions cannot overwrite each other.
+	UpdateStatusIf(actionID string, fromStatuses []string, fields map[string]interface{}) (bool, error)
+	WithTx(tx *gorm.DB) ActionRecordRepository
+}
+
+type gormActionRecordRepository struct {
+	db *gorm.DB
+}
+
+// NewActionRecordRepository creates a new ActionRecordRepository.
+func NewActionRecordRepository(db *gorm.DB) ActionRecordRepository {
+	return &gormActionRecordRepository{db: db}
+}
+
+func (r *gormActionRecordRepository) Create(record *model.ActionRecord) error {
+	return r.db.Create(record).Error
+}
+
+func (r *gormActionRecordRepository) FindByActionID(actionID string) (*model.ActionRecord, error) {
+	var record model.ActionRecord
+	err := r.db.Where("action_id = ?", actionID).First(&record).Error
+	return &record, err
+}
+
+func (r *gormActionRecordRepository) FindByInstanceUUID(instanceUUID string, limit, offset int) ([]model.ActionRecord, error) {
+	var records []model.ActionRecord
+	err := r.db.Where("instance_uuid = ?", instanceUUID).Order("created_at DESC, id DESC").Limit(limit).Offset(offset).Find(&records).Error
+	return records, err
+}
+
+func (r *gormActionRecordRepository) CountByInstanceUUID(instanceUUID string) (int64, error) {
+	var count int64
+	err := r.db.Model(&model.ActionRecord{}).Where("instance_uuid = ?", instanceUUID).Count(&count).Error
+	return count, err
+}
+
+func (r *gormActionRecordRepository) FindOldestOutstandingByCommand(instanceUUID, command string) (*model.ActionRecord, error) {
+	var record model.ActionRecord
+	err := r.db.Where("instance_uuid = ? AND command = ? AND status IN ?", instanceUUID, command,
+		[]string{model.ActionStatusPending, model.ActionStatusDelivered}).
+		Order("created_at ASC, id ASC").First(&record).Error
+	return &record, err
+}
+
+func (r *gormActionRecordRepository) FindExpired(now int64, limit int) ([]model.ActionRecord, error) {
+	var records []model.ActionRecord
+	err := r.db.Where("status IN ? AND expires_at > 0 AND expires_at <= ?", model.ActionOutstandingStatuses, now).
+		Order("expires_at ASC").Limit(limit).Find(&records).Error
+	return records, err
+}
+
+func (r *gormActionRecordRepository) FindQueuedByInstanceUUID(instanceUUID string) ([]model.ActionRecord, error) {
+	var records []model.ActionRecord
+	err := r.db.Where("instance_uuid = ? AND status = ?", instanceUUID, model.ActionStatusQueued).Order("created_at ASC, id ASC").Find(&records).Error
+	return records, err
+}
+
+func (r *gormActionRecordRepository) ClaimQueued(actionID string, now int64) (bool, error) {
+	result := r.db.Model(&model.ActionRecord{}).
+		Where("action_id = ? AND status = ? AND (idempotent = ? OR attempts = 0)", actionID, model.ActionStatusQueued, true).
+		Updates(map[string]interface{}{
+			"status":     model.ActionStatusPending,
+			"attempts":   gorm.Expr("attempts + 1"),
+			"expires_at": gorm.Expr("? + timeout_sec", now),
+		})
+	return result.RowsAffected > 0, result.Error
+}
+
+func (r *gormActionRecordRepository) UpdateStatusIf(actionID string, fromStatuses []string, fields map[string]interface{}) (bool, error) {
+	result := r.db.Model(&model.ActionRecord{}).Where("action_id = ? AND status IN ?", actionID, fromStatuses).Updates(fields)
+	return result.RowsAffected > 0, result.Error
+}
+
+func (r *gormActionRecordRepository) WithTx(tx *gorm.DB) ActionRecordRepository {
+	return &gormActionRecordRepository{db: tx}
+}
@@ -0,0 +1,28 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// AlertNotificationRepository deduplicates alert notifications across server processes, which
+// all evaluate every property update.
+type AlertNotificationRepository interface {
+	// Claim reports whether the caller is the first to announce the notification identified by key.
+	Claim(ctx context.Context, key string, ttl time.Duration) (bool, error)
+}
+
+type alertNotificationRepo struct {
+	client *redis.Client
+}
+
+func NewAlertNotificationRepository(client *redis.Client) AlertNotificationRepository {
+	return &alertNotificationRepo{client: client}
+}
+
+func (r *alertNotificationRepo) Claim(ctx context.Context, key string, ttl time.Duration) (bool, error) {
+	return r.client.SetNX(ctx, fmt.Sprintf("alert:notified:%s", key), "1", ttl).Result()
+}
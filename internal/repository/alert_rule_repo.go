@@ -0,0 +1,77 @@
+package repository
+
+import (
+	"OMEGA3-IOT/internal/model"
+
+	"gorm.io/gorm"
+)
+
+// AlertRuleRepository defines the interface for alert rule data access.
+type AlertRuleRepository interface {
+	Create(rule *model.AlertRule) error
+	FindByRuleUUID(ruleUUID string) (*model.AlertRule, error)
+	FindByOwner(ownerUUID string, limit, offset int) ([]model.AlertRule, int64, error)
+	// FindEnabledForDevice returns the enabled rules on the given properties whose scope covers
+	// the device: the device itself, a folder containing it, or its type for rules of its owner.
+	FindEnabledForDevice(instanceUUID, deviceType, ownerUUID string, properties []string) ([]model.AlertRule, error)
+	Update(rule *model.AlertRule) error
+	Delete(ruleUUID string) error
+	WithTx(tx *gorm.DB) AlertRuleRepository
+}
+
+type gormAlertRuleRepository struct {
+	db *gorm.DB
+}
+
+// NewAlertRuleRepository creates a new AlertRuleRepository.
+func NewAlertRuleRepository(db *gorm.DB) AlertRuleRepository {
+	return &gormAlertRuleRepository{db: db}
+}
+
+func (r *gormAlertRuleRepository) Create(rule *model.AlertRule) error {
+	return r.db.Create(rule).Error
+}
+
+func (r *gormAlertRuleRepository) FindByRuleUUID(ruleUUID string) (*model.AlertRule, error) {
+	var rule model.AlertRule
+	err := r.db.Where("rule_uuid = ?", ruleUUID).First(&rule).Error
+	return &rule, err
+}
+
+func (r *gormAlertRuleRepository) FindByOwner(ownerUUID string, limit, offset int) ([]model.AlertRule, int64, error) {
+	var rules []model.AlertRule
+	var total int64
+	query := r.db.Model(&model.AlertRule{}).Where("owner_uuid = ?", ownerUUID)
+	if err := query.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+	err := query.Order("created_at DESC").Limit(limit).Offset(offset).Find(&rules).Error
+	return rules, total, err
+}
+
+func (r *gormAlertRuleRepository) FindEnabledForDevice(instanceUUID, deviceType, ownerUUID string, properties []string) ([]model.AlertRule, error) {
+	var rules []model.AlertRule
+	folders := r.db.Model(&model.DeviceFolderItem{}).
+		Select("folder_uuid").
+		Where("device_uuid = ? AND valid = 1", instanceUUID)
+	err := r.db.
+		Where("enabled = ? AND property IN ?", true, properties).
+		Where(r.db.
+			Where("scope_type = ? AND scope_value = ?", model.AlertScopeDevice, instanceUUID).
+			Or("scope_type = ? AND scope_value IN (?)", model.AlertScopeFolder, folders).
+			Or("scope_type = ? AND scope_value = ? AND owner_uuid = ?", model.AlertScopeType, deviceType, ownerUUID)).
+		Find(&rules).Error
+	return rules, err
+}
+
+func (r *gormAlertRuleRepository) Update(rule *model.AlertRule) error {
+	return r.db.Save(rule).Error
+}
+
+func (r *gormAlertRuleRepository) Delete(ruleUUID string) error {
+	return r.db.Where("rule_uuid = ?", ruleUUID).Delete(&model.AlertRule{}).Error
+}
+
+func (r *gormAlertRuleRepository) WithTx(tx *gorm.DB) AlertRuleRepository {
+	return &gormAlertRuleRepository{db: tx}
+}
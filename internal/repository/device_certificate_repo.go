@@ -0,0 +1,87 @@
+package repository
+
+import (
+	"OMEGA3-IOT/internal/model"
+
+	"gorm.io/gorm"
+)
+
+// DeviceCertificateRepository defines the interface for device certificate access.
+type DeviceCertificateRepository interface {
+	Create(cert *model.DeviceCertificate) error
+	FindBySerial(serial string) (*model.DeviceCertificate, error)
+	ListByDevice(deviceUUID string) ([]model.DeviceCertificate, error)
+	// ListRevoked returns the revoked certificates that have not expired before notAfter.
+	ListRevoked(notAfter int64) ([]model.DeviceCertificate, error)
+	Revoke(serial string, reason string, revokedAt int64) error
+	// RevokeByDevice revokes the valid certificates of a device except exceptSerial and returns
+	// the serial numbers revoked.
+	RevokeByDevice(deviceUUID string, exceptSerial string, reason string, revokedAt int64) ([]string, error)
+	WithTx(tx *gorm.DB) DeviceCertificateRepository
+}
+
+type gormDeviceCertificateRepository struct {
+	db *gorm.DB
+}
+
+// NewDeviceCertificateRepository creates a new DeviceCertificateRepository.
+func NewDeviceCertificateRepository(db *gorm.DB) DeviceCertificateRepository {
+	return &gormDeviceCertificateRepository{db: db}
+}
+
+func (r *gormDeviceCertificateRepository) Create(cert *model.DeviceCertificate) error {
+	return r.db.Create(cert).Error
+}
+
+func (r *gormDeviceCertificateRepository) FindBySerial(serial string) (*model.DeviceCertificate, error) {
+	var cert model.DeviceCertificate
+	err := r.db.Where("serial_number = ?", serial).First(&cert).Error
+	return &cert, err
+}
+
+func (r *gormDeviceCertificateRepository) ListByDevice(deviceUUID string) ([]model.DeviceCertificate, error) {
+	var certs []model.DeviceCertificate
+	err := r.db.Where("device_uuid = ?", deviceUUID).Order("created_at DESC").Find(&certs).Error
+	return certs, err
+}
+
+func (r *gormDeviceCertificateRepository) ListRevoked(notAfter int64) ([]model.DeviceCertificate, error) {
+	var certs []model.DeviceCertificate
+	err := r.db.Where("revoked_at <> 0 AND not_after >= ?", notAfter).Order("revoked_at ASC").Find(&certs).Error
+	return certs, err
+}
+
+func (r *gormDeviceCertificateRepository) Revoke(serial string, reason string, revokedAt int64) error {
+	result := r.db.Model(&model.DeviceCertificate{}).
+		Where("serial_number = ? AND revoked_at = 0", serial).
+		Updates(map[string]interface{}{"revoked_at": revokedAt, "revocation_reason": reason})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
+func (r *gormDeviceCertificateRepository) RevokeByDevice(deviceUUID string, exceptSerial string, reason string, revokedAt int64) ([]string, error) {
+	var serials []string
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&model.DeviceCertificate{}).
+			Where("device_uuid = ? AND serial_number <> ? AND revoked_at = 0", deviceUUID, exceptSerial).
+			Pluck("serial_number", &serials).Error; err != nil {
+			return err
+		}
+		if len(serials) == 0 {
+			return nil
+		}
+		return tx.Model(&model.DeviceCertificate{}).
+			Where("serial_number IN ?", serials).
+			Updates(map[string]interface{}{"revoked_at": revokedAt, "revocation_reason": reason}).Error
+	})
+	return serials, err
+}
+
+func (r *gormDeviceCertificateRepository) WithTx(tx *gorm.DB) DeviceCertificateRepository {
+	return &gormDeviceCertificateRepository{db: tx}
+}
@@ -0,0 +1,179 @@
+package repository
+
+import (
+	"OMEGA3-IOT/internal/model"
+
+	"gorm.io/gorm"
+)
+
+// deviceEventDeleteBatch bounds the rows removed by one DELETE so retention does not hold
+// long locks on large tables.
+const deviceEventDeleteBatch = 5000
+
+// DeviceEventFilter narrows an event query. Empty fields match everything; times are Unix
+// milliseconds and End is exclusive.
+type DeviceEventFilter struct {
+	InstanceUUID string
+	EventKeys    []string
+	Severities   []string
+	Start        int64
+	End          int64
+}
+
+// EventQuarantineFilter narrows a quarantine query. Times are Unix milliseconds of receipt.
+type EventQuarantineFilter struct {
+	InstanceUUID string
+	EventKey     string
+	Reason       string
+	Start        int64
+	End          int64
+}
+
+// DeviceEventRepository defines the interface for device event and quarantine access.
+type DeviceEventRepository interface {
+	Create(record *model.DeviceEventRecord) error
+	// Find returns matching events newest first, with their params.
+	Find(filter DeviceEventFilter, limit, offset int) ([]model.DeviceEventRecord, error)
+	Count(filter DeviceEventFilter) (int64, error)
+	// DeleteBefore removes the events with eventKey of a device type older than before.
+	DeleteBefore(deviceType, eventKey string, before int64) (int64, error)
+	// DeleteUndefinedBefore removes the events of a device type older than before whose key
+	// is not one of definedKeys.
+	DeleteUndefinedBefore(deviceType string, definedKeys []string, before int64) (int64, error)
+
+	CreateQuarantined(event *model.QuarantinedEvent) error
+	FindQuarantined(filter EventQuarantineFilter, limit, offset int) ([]model.QuarantinedEvent, error)
+	CountQuarantined(filter EventQuarantineFilter) (int64, error)
+	DeleteQuarantinedBefore(before int64) (int64, error)
+	WithTx(tx *gorm.DB) DeviceEventRepository
+}
+
+type gormDeviceEventRepository struct {
+	db *gorm.DB
+}
+
+// NewDeviceEventRepository creates a new DeviceEventRepository.
+func NewDeviceEventRepository(db *gorm.DB) DeviceEventRepository {
+	return &gormDeviceEventRepository{db: db}
+}
+
+func (r *gormDeviceEventRepository) Create(record *model.DeviceEventRecord) error {
+	return r.db.Create(record).Error
+}
+
+func (r *gormDeviceEventRepository) filtered(filter DeviceEventFilter) *gorm.DB {
+	query := r.db.Model(&model.DeviceEventRecord{})
+	if filter.InstanceUUID != "" {
+		query = query.Where("instance_uuid = ?", filter.InstanceUUID)
+	}
+	if len(filter.EventKeys) > 0 {
+		query = query.Where("event_key IN ?", filter.EventKeys)
+	}
+	if len(filter.Severities) > 0 {
+		query = query.Where("severity IN ?", filter.Severities)
+	}
+	if filter.Start > 0 {
+		query = query.Where("timestamp >= ?", filter.Start)
+	}
+	if filter.End > 0 {
+		query = query.Where("timestamp < ?", filter.End)
+	}
+	return query
+}
+
+func (r *gormDeviceEventRepository) Find(filter DeviceEventFilter, limit, offset int) ([]model.DeviceEventRecord, error) {
+	var records []model.DeviceEventRecord
+	err := r.filtered(filter).Preload("Params").Order("timestamp DESC, id DESC").Limit(limit).Offset(offset).Find(&records).Error
+	return records, err
+}
+
+func (r *gormDeviceEventRepository) Count(filter DeviceEventFilter) (int64, error) {
+	var count int64
+	err := r.filtered(filter).Count(&count).Error
+	return count, err
+}
+
+func (r *gormDeviceEventRepository) DeleteBefore(deviceType, eventKey string, before int64) (int64, error) {
+	return r.deleteBatched(func() *gorm.DB {
+		return r.db.Model(&model.DeviceEventRecord{}).Where("device_type = ? AND event_key = ? AND timestamp < ?", deviceType, eventKey, before)
+	})
+}
+
+func (r *gormDeviceEventRepository) DeleteUndefinedBefore(deviceType string, definedKeys []string, before int64) (int64, error) {
+	return r.deleteBatched(func() *gorm.DB {
+		query := r.db.Model(&model.DeviceEventRecord{}).Where("device_type = ? AND timestamp < ?", deviceType, before)
+		if len(definedKeys) > 0 {
+			query = query.Where("event_key NOT IN ?", definedKeys)
+		}
+		return query
+	})
+}
+
+// deleteBatched deletes the events selected by query, with their params, in batches.
+func (r *gormDeviceEventRepository) deleteBatched(query func() *gorm.DB) (int64, error) {
+	var deleted int64
+	for {
+		var ids []uint64
+		if err := query().Limit(deviceEventDeleteBatch).Pluck("id", &ids).Error; err != nil {
+			return deleted, err
+		}
+		if len(ids) == 0 {
+			return deleted, nil
+		}
+		err := r.db.Transaction(func(tx *gorm.DB) error {
+			if err := tx.Where("event_id IN ?", ids).Delete(&model.DeviceEventParam{}).Error; err != nil {
+				return err
+			}
+			return tx.Where("id IN ?", ids).Delete(&model.DeviceEventRecord{}).Error
+		})
+		if err != nil {
+			return deleted, err
+		}
+		deleted += int64(len(ids))
+	}
+}
+
+func (r *gormDeviceEventRepository) CreateQuarantined(event *model.QuarantinedEvent) error {
+	return r.db.Create(event).Error
+}
+
+func (r *gormDeviceEventRepository) filteredQuarantine(filter EventQuarantineFilter) *gorm.DB {
+	query := r.db.Model(&model.QuarantinedEvent{})
+	if filter.InstanceUUID != "" {
+		query = query.Where("instance_uuid = ?", filter.InstanceUUID)
+	}
+	if filter.EventKey != "" {
+		query = query.Where("event_key = ?", filter.EventKey)
+	}
+	if filter.Reason != "" {
+		query = query.Where("reason = ?", filter.Reason)
+	}
+	if filter.Start > 0 {
+		query = query.Where("received_at >= ?", filter.Start)
+	}
+	if filter.End > 0 {
+		query = query.Where("received_at < ?", filter.End)
+	}
+	return query
+}
+
+func (r *gormDeviceEventRepository) FindQuarantined(filter EventQuarantineFilter, limit, offset int) ([]model.QuarantinedEvent, error) {
+	var events []model.QuarantinedEvent
+	err := r.filteredQuarantine(filter).Order("received_at DESC, id DESC").Limit(limit).Offset(offset).Find(&events).Error
+	return events, err
+}
+
+func (r *gormDeviceEventRepository) CountQuarantined(filter EventQuarantineFilter) (int64, error) {
+	var count int64
+	err := r.filteredQuarantine(filter).Count(&count).Error
+	return count, err
+}
+
+func (r *gormDeviceEventRepository) DeleteQuarantinedBefore(before int64) (int64, error) {
+	result := r.db.Where("received_at < ?", before).Delete(&model.QuarantinedEvent{})
+	return result.RowsAffected, result.Error
+}
+
+func (r *gormDeviceEventRepository) WithTx(tx *gorm.DB) DeviceEventRepository {
+	return &gormDeviceEventRepository{db: tx}
+}
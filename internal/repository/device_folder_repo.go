@@ -22,6 +22,7 @@ type DeviceFolderRepository interface {
 	RemoveItemWithTx(tx *gorm.DB, folderUUID string, deviceUUID string) error
 	RemoveAllItemsWithTx(tx *gorm.DB, folderUUID string) error
 	GetFolderDevices(folderUUID string, page, pageSize int) ([]model.FolderDeviceItem, int64, error)
+	GetFolderDeviceUUIDs(folderUUID string) ([]string, error)
 	GetItemByFolderAndDevice(folderUUID string, deviceUUID string) (*model.DeviceFolderItem, error)
 
 	WithTx(tx *gorm.DB) DeviceFolderRepository
@@ -211,6 +212,16 @@ func (r *gormDeviceFolderRepository) GetFolderDevices(folderUUID string, page, p
 	return devices, total, err
 }
 
+// GetFolderDeviceUUIDs returns the UUIDs of all valid devices in a folder.
+func (r *gormDeviceFolderRepository) GetFolderDeviceUUIDs(folderUUID string) ([]string, error) {
+	var uuids []string
+	err := r.db.Model(&model.DeviceFolderItem{}).
+		Where("folder_uuid = ? AND valid = 1", folderUUID).
+		Order("joined_at ASC").
+		Pluck("device_uuid", &uuids).Error
+	return uuids, err
+}
+
 func (r *gormDeviceFolderRepository) GetItemByFolderAndDevice(folderUUID string, deviceUUID string) (*model.DeviceFolderItem, error) {
 	var item model.DeviceFolderItem
 	err := r.db.Where("folder_uuid = ? AND device_uuid = ?", folderUUID, deviceUUID).First(&item).Error
@@ -3,13 +3,18 @@ package repository
 import (
 	"OMEGA3-IOT/internal/model"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 type InstanceRepository interface {
 	Create(instance *model.Instance) error
 	FindByID(id uint) (*model.Instance, error)
 	FindByUUID(instanceUUID string) (*model.Instance, error)
+	// FindByUUIDsForUpdate loads the given devices and locks their rows until the transaction ends.
+	FindByUUIDsForUpdate(instanceUUIDs []string) ([]model.Instance, error)
 	FindByOwnerUUID(ownerUUID string) ([]model.Instance, error)
+	FindByType(typeName string) ([]model.Instance, error)
+	CountByType(typeName string) (int64, error)
 	Update(instance *model.Instance) error
 	UpdateFields(instanceUUID string, fields map[string]interface{}) error
 	Delete(id uint) error
@@ -17,6 +22,9 @@ type InstanceRepository interface {
 	UpdateProperties(instanceUUID string, properties model.Properties) error
 	UpdateOnlineStatus(instanceUUID string, online bool, lastSeen int64) error
 	Exists(instanceUUID string) (bool, error)
+	// FindCredentialGraceExpired returns the devices whose previous verify code is still stored
+	// although its grace period ended before now
+	FindCredentialGraceExpired(now int64) ([]model.Instance, error)
 
 	// Transaction support
 	WithTx(tx *gorm.DB) InstanceRepository
@@ -51,12 +59,33 @@ func (r *gormInstanceRepository) FindByUUID(instanceUUID string) (*model.Instanc
 	return &instance, err
 }
 
+func (r *gormInstanceRepository) FindByUUIDsForUpdate(instanceUUIDs []string) ([]model.Instance, error) {
+	var instances []model.Instance
+	err := r.db.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("instance_uuid IN ?", instanceUUIDs).
+		Order("instance_uuid").
+		Find(&instances).Error
+	return instances, err
+}
+
 func (r *gormInstanceRepository) FindByOwnerUUID(ownerUUID string) ([]model.Instance, error) {
 	var instances []model.Instance
 	err := r.db.Where("owner_uuid = ?", ownerUUID).Find(&instances).Error
 	return instances, err
 }
 
+func (r *gormInstanceRepository) FindByType(typeName string) ([]model.Instance, error) {
+	var instances []model.Instance
+	err := r.db.Where("type = ?", typeName).Find(&instances).Error
+	return instances, err
+}
+
+func (r *gormInstanceRepository) CountByType(typeName string) (int64, error) {
+	var count int64
+	err := r.db.Model(&model.Instance{}).Where("type = ?", typeName).Count(&count).Error
+	return count, err
+}
+
 func (r *gormInstanceRepository) Update(instance *model.Instance) error {
 	return r.db.Save(instance).Error
 }
@@ -79,6 +108,12 @@ func (r *gormInstanceRepository) Exists(instanceUUID string) (bool, error) {
 	return count > 0, err
 }
 
+func (r *gormInstanceRepository) FindCredentialGraceExpired(now int64) ([]model.Instance, error) {
+	var instances []model.Instance
+	err := r.db.Where("previous_verify_expires_at > 0 AND previous_verify_expires_at <= ?", now).Find(&instances).Error
+	return instances, err
+}
+
 func (r *gormInstanceRepository) UpdateProperties(instanceUUID string, properties model.Properties) error {
 	return r.db.Model(&model.Instance{}).
 		Where("instance_uuid = ?", instanceUUID).
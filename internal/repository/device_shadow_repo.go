@@ -0,0 +1,56 @@
+package repository
+
+import (
+	"OMEGA3-IOT/internal/model"
+
+	"gorm.io/gorm"
+)
+
+// DeviceShadowRepository defines the interface for device shadow data access.
+type DeviceShadowRepository interface {
+	Create(shadow *model.DeviceShadow) error
+	FindByInstanceUUID(instanceUUID string) (*model.DeviceShadow, error)
+	// UpdateIfVersion saves the shadow only if the stored version still equals expectedVersion.
+	UpdateIfVersion(shadow *model.DeviceShadow, expectedVersion int64) (bool, error)
+	DeleteByInstanceUUID(instanceUUID string) error
+	WithTx(tx *gorm.DB) DeviceShadowRepository
+}
+
+type gormDeviceShadowRepository struct {
+	db *gorm.DB
+}
+
+// NewDeviceShadowRepository creates a new DeviceShadowRepository.
+func NewDeviceShadowRepository(db *gorm.DB) DeviceShadowRepository {
+	return &gormDeviceShadowRepository{db: db}
+}
+
+func (r *gormDeviceShadowRepository) Create(shadow *model.DeviceShadow) error {
+	return r.db.Create(shadow).Error
+}
+
+func (r *gormDeviceShadowRepository) FindByInstanceUUID(instanceUUID string) (*model.DeviceShadow, error) {
+	var shadow model.DeviceShadow
+	err := r.db.Where("instance_uuid = ?", instanceUUID).First(&shadow).Error
+	return &shadow, err
+}
+
+func (r *gormDeviceShadowRepository) UpdateIfVersion(shadow *model.DeviceShadow, expectedVersion int64) (bool, error) {
+	result := r.db.Model(&model.DeviceShadow{}).
+		Where("instance_uuid = ? AND version = ?", shadow.InstanceUUID, expectedVersion).
+		Updates(map[string]interface{}{
+			"desired":    shadow.Desired,
+			"reported":   shadow.Reported,
+			"version":    shadow.Version,
+			"updated_at": shadow.UpdatedAt,
+		})
+	return result.RowsAffected > 0, result.Error
+}
+
+func (r *gormDeviceShadowRepository) DeleteByInstanceUUID(instanceUUID string) error {
+	return r.db.Where("instance_uuid = ?", instanceUUID).Delete(&model.DeviceShadow{}).Error
+}
+
+func (r *gormDeviceShadowRepository) WithTx(tx *gorm.DB) DeviceShadowRepository {
+	return &gormDeviceShadowRepository{db: tx}
+}
@@ -0,0 +1,56 @@
+package repository
+
+import (
+	"OMEGA3-IOT/internal/model"
+
+	"gorm.io/gorm"
+)
+
+// DeviceTypeRepository defines the interface for stored device type definitions.
+type DeviceTypeRepository interface {
+	Create(record *model.DeviceTypeRecord) error
+	FindByName(name string) (*model.DeviceTypeRecord, error)
+	FindAll() ([]model.DeviceTypeRecord, error)
+	Count() (int64, error)
+	Update(record *model.DeviceTypeRecord) error
+	WithTx(tx *gorm.DB) DeviceTypeRepository
+}
+
+type gormDeviceTypeRepository struct {
+	db *gorm.DB
+}
+
+// NewDeviceTypeRepository creates a new DeviceTypeRepository.
+func NewDeviceTypeRepository(db *gorm.DB) DeviceTypeRepository {
+	return &gormDeviceTypeRepository{db: db}
+}
+
+func (r *gormDeviceTypeRepository) Create(record *model.DeviceTypeRecord) error {
+	return r.db.Create(record).Error
+}
+
+func (r *gormDeviceTypeRepository) FindByName(name string) (*model.DeviceTypeRecord, error) {
+	var record model.DeviceTypeRecord
+	err := r.db.Where("name = ?", name).First(&record).Error
+	return &record, err
+}
+
+func (r *gormDeviceTypeRepository) FindAll() ([]model.DeviceTypeRecord, error) {
+	var records []model.DeviceTypeRecord
+	err := r.db.Order("type_id ASC").Find(&records).Error
+	return records, err
+}
+
+func (r *gormDeviceTypeRepository) Count() (int64, error) {
+	var count int64
+	err := r.db.Model(&model.DeviceTypeRecord{}).Count(&count).Error
+	return count, err
+}
+
+func (r *gormDeviceTypeRepository) Update(record *model.DeviceTypeRecord) error {
+	return r.db.Save(record).Error
+}
+
+func (r *gormDeviceTypeRepository) WithTx(tx *gorm.DB) DeviceTypeRepository {
+	return &gormDeviceTypeRepository{db: tx}
+}
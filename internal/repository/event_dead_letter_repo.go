@@ -0,0 +1,75 @@
+package repository
+
+import (
+	"OMEGA3-IOT/internal/model"
+
+	"gorm.io/gorm"
+)
+
+// EventDeadLetterRepository defines the interface for dead-lettered event bus events.
+type EventDeadLetterRepository interface {
+	Create(letter *model.EventDeadLetter) error
+	// List returns dead letters newest first; empty filters match everything.
+	List(eventType, group string, limit, offset int) ([]model.EventDeadLetter, int64, error)
+	FindByID(id uint) (*model.EventDeadLetter, error)
+	MarkReplayed(id uint, replayedAt int64) error
+	Delete(id uint) error
+	WithTx(tx *gorm.DB) EventDeadLetterRepository
+}
+
+type gormEventDeadLetterRepository struct {
+	db *gorm.DB
+}
+
+// NewEventDeadLetterRepository creates a new EventDeadLetterRepository.
+func NewEventDeadLetterRepository(db *gorm.DB) EventDeadLetterRepository {
+	return &gormEventDeadLetterRepository{db: db}
+}
+
+func (r *gormEventDeadLetterRepository) Create(letter *model.EventDeadLetter) error {
+	return r.db.Create(letter).Error
+}
+
+func (r *gormEventDeadLetterRepository) List(eventType, group string, limit, offset int) ([]model.EventDeadLetter, int64, error) {
+	var letters []model.EventDeadLetter
+	var total int64
+	query := r.db.Model(&model.EventDeadLetter{})
+	if eventType != "" {
+		query = query.Where("event_type = ?", eventType)
+	}
+	if group != "" {
+		query = query.Where("group_name = ?", group)
+	}
+	if err := query.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+	err := query.Order("failed_at DESC, id DESC").Limit(limit).Offset(offset).Find(&letters).Error
+	return letters, total, err
+}
+
+func (r *gormEventDeadLetterRepository) FindByID(id uint) (*model.EventDeadLetter, error) {
+	var letter model.EventDeadLetter
+	if err := r.db.First(&letter, id).Error; err != nil {
+		return nil, err
+	}
+	return &letter, nil
+}
+
+func (r *gormEventDeadLetterRepository) MarkReplayed(id uint, replayedAt int64) error {
+	return r.db.Model(&model.EventDeadLetter{}).Where("id = ?", id).Update("replayed_at", replayedAt).Error
+}
+
+func (r *gormEventDeadLetterRepository) Delete(id uint) error {
+	result := r.db.Delete(&model.EventDeadLetter{}, id)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
+func (r *gormEventDeadLetterRepository) WithTx(tx *gorm.DB) EventDeadLetterRepository {
+	return &gormEventDeadLetterRepository{db: tx}
+}
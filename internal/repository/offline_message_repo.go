@@ -0,0 +1,92 @@
+package repository
+
+import (
+	"OMEGA3-IOT/internal/model"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// OfflineMessageRepository defines the interface for the WebSocket push inbox.
+type OfflineMessageRepository interface {
+	// Store saves a message unless the user already has one for the event, and returns the stored
+	// message either way. Every server process receives the same event, so each may store it.
+	Store(msg *model.OfflineMessage) (*model.OfflineMessage, error)
+	// ListUndelivered returns a user's messages no socket has acknowledged, oldest first.
+	ListUndelivered(userUUID string, limit int) ([]model.OfflineMessage, error)
+	// List returns a user's messages newest first, with the total and the number of unread ones.
+	List(userUUID string, unreadOnly bool, limit, offset int) ([]model.OfflineMessage, int64, int64, error)
+	MarkDelivered(id uint64, deliveredAt int64) error
+	// MarkRead marks the given messages of a user, or all of them if ids is empty, as read (and
+	// delivered). It returns the number of messages that were unread.
+	MarkRead(userUUID string, ids []uint64, readAt int64) (int64, error)
+	WithTx(tx *gorm.DB) OfflineMessageRepository
+}
+
+type gormOfflineMessageRepository struct {
+	db *gorm.DB
+}
+
+// NewOfflineMessageRepository creates a new OfflineMessageRepository.
+func NewOfflineMessageRepository(db *gorm.DB) OfflineMessageRepository {
+	return &gormOfflineMessageRepository{db: db}
+}
+
+func (r *gormOfflineMessageRepository) Store(msg *model.OfflineMessage) (*model.OfflineMessage, error) {
+	result := r.db.Clauses(clause.OnConflict{DoNothing: true}).Create(msg)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	if result.RowsAffected > 0 {
+		return msg, nil
+	}
+	var existing model.OfflineMessage
+	if err := r.db.Where("user_uuid = ? AND event_id = ?", msg.UserUUID, msg.EventID).First(&existing).Error; err != nil {
+		return nil, err
+	}
+	return &existing, nil
+}
+
+func (r *gormOfflineMessageRepository) ListUndelivered(userUUID string, limit int) ([]model.OfflineMessage, error) {
+	var msgs []model.OfflineMessage
+	err := r.db.Where("user_uuid = ? AND delivered_at = 0", userUUID).
+		Order("created_at ASC, id ASC").Limit(limit).Find(&msgs).Error
+	return msgs, err
+}
+
+func (r *gormOfflineMessageRepository) List(userUUID string, unreadOnly bool, limit, offset int) ([]model.OfflineMessage, int64, int64, error) {
+	var msgs []model.OfflineMessage
+	var total, unread int64
+	if err := r.db.Model(&model.OfflineMessage{}).Where("user_uuid = ? AND read_at = 0", userUUID).Count(&unread).Error; err != nil {
+		return nil, 0, 0, err
+	}
+	query := r.db.Model(&model.OfflineMessage{}).Where("user_uuid = ?", userUUID)
+	if unreadOnly {
+		query = query.Where("read_at = 0")
+	}
+	if err := query.Count(&total).Error; err != nil {
+		return nil, 0, 0, err
+	}
+	err := query.Order("created_at DESC, id DESC").Limit(limit).Offset(offset).Find(&msgs).Error
+	return msgs, total, unread, err
+}
+
+func (r *gormOfflineMessageRepository) MarkDelivered(id uint64, deliveredAt int64) error {
+	return r.db.Model(&model.OfflineMessage{}).Where("id = ? AND delivered_at = 0", id).Update("delivered_at", deliveredAt).Error
+}
+
+func (r *gormOfflineMessageRepository) MarkRead(userUUID string, ids []uint64, readAt int64) (int64, error) {
+	query := r.db.Model(&model.OfflineMessage{}).Where("user_uuid = ? AND read_at = 0", userUUID)
+	if len(ids) > 0 {
+		query = query.Where("id IN ?", ids)
+	}
+	result := query.Updates(map[string]interface{}{
+		"read_at":      readAt,
+		"delivered_at": gorm.Expr("CASE WHEN delivered_at = 0 THEN ? ELSE delivered_at END", readAt),
+	})
+	return result.RowsAffected, result.Error
+}
+
+func (r *gormOfflineMessageRepository) WithTx(tx *gorm.DB) OfflineMessageRepository {
+	return &gormOfflineMessageRepository{db: tx}
+}
@@ -0,0 +1,61 @@
+package repository
+
+import (
+	"OMEGA3-IOT/internal/model"
+
+	"gorm.io/gorm"
+)
+
+// RetentionOverrideRepository defines the interface for retention override access.
+type RetentionOverrideRepository interface {
+	List() ([]model.RetentionOverride, error)
+	FindByID(id uint) (*model.RetentionOverride, error)
+	FindByTarget(deviceType, scope, key string) (*model.RetentionOverride, error)
+	Create(override *model.RetentionOverride) error
+	Update(override *model.RetentionOverride) error
+	Delete(id uint) error
+	WithTx(tx *gorm.DB) RetentionOverrideRepository
+}
+
+type gormRetentionOverrideRepository struct {
+	db *gorm.DB
+}
+
+// NewRetentionOverrideRepository creates a new RetentionOverrideRepository.
+func NewRetentionOverrideRepository(db *gorm.DB) RetentionOverrideRepository {
+	return &gormRetentionOverrideRepository{db: db}
+}
+
+func (r *gormRetentionOverrideRepository) List() ([]model.RetentionOverride, error) {
+	var overrides []model.RetentionOverride
+	err := r.db.Order("device_type ASC, scope ASC, `key` ASC").Find(&overrides).Error
+	return overrides, err
+}
+
+func (r *gormRetentionOverrideRepository) FindByID(id uint) (*model.RetentionOverride, error) {
+	var override model.RetentionOverride
+	err := r.db.First(&override, id).Error
+	return &override, err
+}
+
+func (r *gormRetentionOverrideRepository) FindByTarget(deviceType, scope, key string) (*model.RetentionOverride, error) {
+	var override model.RetentionOverride
+	err := r.db.Where("device_type = ? AND scope = ? AND `key` = ?", deviceType, scope, key).First(&override).Error
+	return &override, err
+}
+
+func (r *gormRetentionOverrideRepository) Create(override *model.RetentionOverride) error {
+	return r.db.Create(override).Error
+}
+
+func (r *gormRetentionOverrideRepository) Update(override *model.RetentionOverride) error {
+	return r.db.Save(override).Error
+}
+
+func (r *gormRetentionOverrideRepository) Delete(id uint) error {
+	return r.db.Delete(&model.RetentionOverride{}, id).Error
+}
+
+func (r *gormRetentionOverrideRepository) WithTx(tx *gorm.DB) RetentionOverrideRepository {
+	return &gormRetentionOverrideRepository{db: tx}
+}
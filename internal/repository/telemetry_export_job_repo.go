@@ -0,0 +1,111 @@
+package repository
+
+import (
+	"OMEGA3-IOT/internal/model"
+
+	"gorm.io/gorm"
+)
+
+// TelemetryExportJobRepository defines the interface for telemetry export job access.
+type TelemetryExportJobRepository interface {
+	Create(job *model.TelemetryExportJob) error
+	FindByJobUUID(jobUUID string) (*model.TelemetryExportJob, error)
+	FindByOwner(ownerUUID string, limit, offset int) ([]model.TelemetryExportJob, int64, error)
+	// CountActive counts the pending and running jobs of an owner.
+	CountActive(ownerUUID string) (int64, error)
+	// FindPending returns pending jobs, oldest first.
+	FindPending(limit int) ([]model.TelemetryExportJob, error)
+	// FindExpired returns finished jobs whose result expired before now.
+	FindExpired(now int64, limit int) ([]model.TelemetryExportJob, error)
+	// Claim marks a pending job as running. It fails if another worker claimed it first.
+	Claim(jobUUID string, startedAt int64) (bool, error)
+	// ResetRunning returns jobs interrupted by a restart to the queue.
+	ResetRunning() (int64, error)
+	UpdateFields(jobUUID string, fields map[string]interface{}) error
+	Delete(jobUUID string) error
+	WithTx(tx *gorm.DB) TelemetryExportJobRepository
+}
+
+type gormTelemetryExportJobRepository struct {
+	db *gorm.DB
+}
+
+// NewTelemetryExportJobRepository creates a new TelemetryExportJobRepository.
+func NewTelemetryExportJobRepository(db *gorm.DB) TelemetryExportJobRepository {
+	return &gormTelemetryExportJobRepository{db: db}
+}
+
+func (r *gormTelemetryExportJobRepository) Create(job *model.TelemetryExportJob) error {
+	return r.db.Create(job).Error
+}
+
+func (r *gormTelemetryExportJobRepository) FindByJobUUID(jobUUID string) (*model.TelemetryExportJob, error) {
+	var job model.TelemetryExportJob
+	err := r.db.Where("job_uuid = ?", jobUUID).First(&job).Error
+	return &job, err
+}
+
+func (r *gormTelemetryExportJobRepository) FindByOwner(ownerUUID string, limit, offset int) ([]model.TelemetryExportJob, int64, error) {
+	var jobs []model.TelemetryExportJob
+	var total int64
+	query := r.db.Model(&model.TelemetryExportJob{}).Where("owner_uuid = ?", ownerUUID)
+	if err := query.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+	err := query.Order("created_at DESC, id DESC").Limit(limit).Offset(offset).Find(&jobs).Error
+	return jobs, total, err
+}
+
+func (r *gormTelemetryExportJobRepository) CountActive(ownerUUID string) (int64, error) {
+	var count int64
+	err := r.db.Model(&model.TelemetryExportJob{}).
+		Where("owner_uuid = ? AND status IN ?", ownerUUID, []string{model.TelemetryExportPending, model.TelemetryExportRunning}).
+		Count(&count).Error
+	return count, err
+}
+
+func (r *gormTelemetryExportJobRepository) FindPending(limit int) ([]model.TelemetryExportJob, error) {
+	var jobs []model.TelemetryExportJob
+	err := r.db.Where("status = ?", model.TelemetryExportPending).
+		Order("created_at ASC, id ASC").
+		Limit(limit).
+		Find(&jobs).Error
+	return jobs, err
+}
+
+func (r *gormTelemetryExportJobRepository) FindExpired(now int64, limit int) ([]model.TelemetryExportJob, error) {
+	var jobs []model.TelemetryExportJob
+	err := r.db.Where("status IN ? AND expires_at > 0 AND expires_at <= ?",
+		[]string{model.TelemetryExportSucceeded, model.TelemetryExportFailed}, now).
+		Limit(limit).
+		Find(&jobs).Error
+	return jobs, err
+}
+
+func (r *gormTelemetryExportJobRepository) Claim(jobUUID string, startedAt int64) (bool, error) {
+	result := r.db.Model(&model.TelemetryExportJob{}).
+		Where("job_uuid = ? AND status = ?", jobUUID, model.TelemetryExportPending).
+		Updates(map[string]interface{}{"status": model.TelemetryExportRunning, "started_at": startedAt})
+	return result.RowsAffected > 0, result.Error
+}
+
+func (r *gormTelemetryExportJobRepository) ResetRunning() (int64, error) {
+	result := r.db.Model(&model.TelemetryExportJob{}).
+		Where("status = ?", model.TelemetryExportRunning).
+		Updates(map[string]interface{}{"status": model.TelemetryExportPending, "started_at": 0})
+	return result.RowsAffected, result.Error
+}
+
+func (r *gormTelemetryExportJobRepository) UpdateFields(jobUUID string, fields map[string]interface{}) error {
+	return r.db.Model(&model.TelemetryExportJob{}).
+		Where("job_uuid = ?", jobUUID).
+		Updates(fields).Error
+}
+
+func (r *gormTelemetryExportJobRepository) Delete(jobUUID string) error {
+	return r.db.Where("job_uuid = ?", jobUUID).Delete(&model.TelemetryExportJob{}).Error
+}
+
+func (r *gormTelemetryExportJobRepository) WithTx(tx *gorm.DB) TelemetryExportJobRepository {
+	return &gormTelemetryExportJobRepository{db: tx}
+}
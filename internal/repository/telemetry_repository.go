@@ -12,6 +12,7 @@ import (
 type TelemetryData struct {
 	DeviceUUID   string
 	Measurements []string
+	DataTypes    []client.TSDataType // aligned with Measurements
 	Values       map[string]interface{}
 	Timestamp    int64
 }
@@ -23,6 +24,34 @@ type TelemetryQueryResult struct {
 	Values     map[string]interface{}
 }
 
+// TelemetryQuery selects the points of one device in [StartTime, EndTime), both in milliseconds.
+// An empty Properties selects all properties (raw queries only).
+type TelemetryQuery struct {
+	DeviceUUID string
+	Properties []string
+	StartTime  int64
+	EndTime    int64
+	Limit      int
+	Offset     int
+}
+
+// Supported aggregations and the IoTDB functions they map to
+var telemetryAggregations = map[string]string{
+	"avg":   "avg",
+	"min":   "min_value",
+	"max":   "max_value",
+	"sum":   "sum",
+	"count": "count",
+	"first": "first_value",
+	"last":  "last_value",
+}
+
+// IsTelemetryAggregation reports whether name is a supported aggregation.
+func IsTelemetryAggregation(name string) bool {
+	_, ok := telemetryAggregations[name]
+	return ok
+}
+
 var tsTypeNames = map[client.TSDataType]string{
 	client.BOOLEAN:   "BOOLEAN",
 	client.INT32:     "INT32",
@@ -64,10 +93,22 @@ func StringToTSDataType(s string) (client.TSDataType, error) {
 
 type TelemetryRepository interface {
 	InsertTelemetry(deviceUUID string, measurements []string, values []interface{}, timestamp int64) error
+	// BatchInsertTelemetry writes points of any number of devices in one request.
 	BatchInsertTelemetry(telemetryData []TelemetryData) error
-	QueryTelemetry(deviceUUID string, startTime, endTime int64) (*TelemetryData, error)
+	// QueryTelemetry returns raw points in ascending time order.
+	QueryTelemetry(query TelemetryQuery) ([]TelemetryQueryResult, error)
+	// AggregateTelemetry returns one row per window of intervalMs, starting at query.StartTime.
+	AggregateTelemetry(query TelemetryQuery, aggregation string, intervalMs int64) ([]TelemetryQueryResult, error)
 	QueryLatestTelemetry(deviceUUID string) (*TelemetryData, error)
 	CreateTimeseries(deviceUUID string, propertyNames []string, dataTypes []client.TSDataType) error
+	DeleteTimeseries(deviceUUID string, propertyNames []string) error
+	// ArchiveTimeseries moves the points of a property to the series archiveName and drops the
+	// property's series. A property without a series is skipped, so an interrupted archive can be retried.
+	ArchiveTimeseries(deviceUUID, propertyName, archiveName string) error
+	// DeleteTelemetryBefore deletes the points of the given properties older than before (ms).
+	DeleteTelemetryBefore(deviceUUID string, propertyNames []string, before int64) error
+	// WriteMetric records one point of an internal metric series such as folder_operations.
+	WriteMetric(name string, measurements []string, dataTypes []client.TSDataType, values []interface{}, timestamp int64) error
 }
 
 type iotdbTelemetryRepository struct {
@@ -84,26 +125,91 @@ func (r *iotdbTelemetryRepository) InsertTelemetry(deviceUUID string, measuremen
 }
 
 func (r *iotdbTelemetryRepository) BatchInsertTelemetry(telemetryData []TelemetryData) error {
-	// Batch insert implementation
-	for _, data := range telemetryData {
-		// Convert map to slice based on measurements order
-		values := make([]interface{}, len(data.Measurements))
-		for i, m := range data.Measurements {
-			values[i] = data.Values[m]
+	if len(telemetryData) == 0 {
+		return nil
+	}
+	tablets, err := buildTelemetryTablets(telemetryData)
+	if err != nil {
+		return err
+	}
+	return r.client.InsertTablets(tablets)
+}
+
+// buildTelemetryTablets groups points into one tablet per device. The tablet holds every
+// measurement reported by any of the device's points; values a point lacks, or whose Go
+// type does not match the measurement type, are written as null.
+func buildTelemetryTablets(telemetryData []TelemetryData) ([]*client.Tablet, error) {
+	type deviceRows struct {
+		schemas []*client.MeasurementSchema
+		columns map[string]int
+		rows    []*TelemetryData
+	}
+	devices := make(map[string]*deviceRows)
+	var order []string
+
+	for i := range telemetryData {
+		point := &telemetryData[i]
+		if len(point.DataTypes) != len(point.Measurements) {
+			return nil, fmt.Errorf("telemetry of %s has %d measurements but %d data types", point.DeviceUUID, len(point.Measurements), len(point.DataTypes))
 		}
-		if err := r.InsertTelemetry(data.DeviceUUID, data.Measurements, values, data.Timestamp); err != nil {
-			return err
+		device, ok := devices[point.DeviceUUID]
+		if !ok {
+			device = &deviceRows{columns: make(map[string]int)}
+			devices[point.DeviceUUID] = device
+			order = append(order, point.DeviceUUID)
 		}
+		for j, measurement := range point.Measurements {
+			if _, ok := device.columns[measurement]; !ok {
+				device.columns[measurement] = len(device.schemas)
+				device.schemas = append(device.schemas, &client.MeasurementSchema{Measurement: measurement, DataType: point.DataTypes[j]})
+			}
+		}
+		device.rows = append(device.rows, point)
 	}
-	return nil
+
+	tablets := make([]*client.Tablet, 0, len(order))
+	for _, deviceUUID := range order {
+		device := devices[deviceUUID]
+		tablet, err := client.NewTablet(telemetryDevicePath(deviceUUID), device.schemas, len(device.rows))
+		if err != nil {
+			return nil, fmt.Errorf("failed to create tablet for %s: %w", deviceUUID, err)
+		}
+		for row, point := range device.rows {
+			tablet.SetTimestamp(point.Timestamp, row)
+			for column, schema := range device.schemas {
+				value := point.Values[schema.Measurement]
+				if value == nil || tablet.SetValueAt(value, column, row) != nil {
+					tablet.SetValueAt(nil, column, row)
+				}
+			}
+		}
+		tablet.RowSize = len(device.rows)
+		tablets = append(tablets, tablet)
+	}
+	return tablets, nil
 }
 
-func (r *iotdbTelemetryRepository) QueryTelemetry(deviceUUID string, startTime, endTime int64) (*TelemetryData, error) {
-	var tc utils.TimeConverter
-	devicePath := utils.ConvertHyphenIntoDash(fmt.Sprintf("root.mm1.device_data.%s", deviceUUID))
-	sql := fmt.Sprintf("SELECT * FROM %s WHERE time >= %s AND time <= %s ORDER BY time DESC",
-		devicePath, tc.SecToISO(startTime), tc.SecToISO(endTime))
+func (r *iotdbTelemetryRepository) QueryTelemetry(query TelemetryQuery) ([]TelemetryQueryResult, error) {
+	return r.query(query.DeviceUUID, buildTelemetrySelect(query))
+}
 
+func (r *iotdbTelemetryRepository) AggregateTelemetry(query TelemetryQuery, aggregation string, intervalMs int64) ([]TelemetryQueryResult, error) {
+	function, ok := telemetryAggregations[aggregation]
+	if !ok {
+		return nil, fmt.Errorf("unsupported aggregation: %s", aggregation)
+	}
+	if intervalMs <= 0 {
+		return nil, fmt.Errorf("aggregation interval must be positive")
+	}
+	if len(query.Properties) == 0 {
+		return nil, fmt.Errorf("aggregation requires at least one property")
+	}
+	return r.query(query.DeviceUUID, buildTelemetryAggregate(query, function, intervalMs))
+}
+
+// query runs a SELECT over one device and returns one result per row. Values of properties
+// without data in a row are nil.
+func (r *iotdbTelemetryRepository) query(deviceUUID, sql string) ([]TelemetryQueryResult, error) {
 	session, err := r.client.SessionPool.GetSession()
 	if err != nil {
 		return nil, err
@@ -116,7 +222,7 @@ func (r *iotdbTelemetryRepository) QueryTelemetry(deviceUUID string, startTime,
 	}
 	defer dataSet.Close()
 
-	var results TelemetryData
+	var results []TelemetryQueryResult
 	for {
 		hasNext, err := dataSet.Next()
 		if err != nil {
@@ -131,30 +237,20 @@ func (r *iotdbTelemetryRepository) QueryTelemetry(deviceUUID string, startTime,
 			return nil, fmt.Errorf("failed to get row record: %w", err)
 		}
 
-		timestamp := record.GetTimestamp()
 		values := make(map[string]interface{})
-		columnCount := dataSet.GetColumnCount()
-		var parts, propNames []string
-		for i := 0; i < columnCount; i++ {
-
-			columnName := dataSet.GetColumnName(i)
-			parts = strings.Split(columnName, ".")
-			propName := parts[len(parts)-1]
-
-			value := dataSet.GetValue(columnName)
-			propNames = append(propNames, propName)
-			values[propName] = value
-		}
-
-		results = TelemetryData{
-			DeviceUUID:   deviceUUID,
-			Timestamp:    timestamp,
-			Values:       values,
-			Measurements: propNames,
+		for _, columnName := range dataSet.GetColumnNames() {
+			if columnName == client.TimestampColumnName {
+				continue
+			}
+			values[telemetryColumnProperty(columnName)] = dataSet.GetValue(columnName)
 		}
+		results = append(results, TelemetryQueryResult{
+			Timestamp:  record.GetTimestamp(),
+			DeviceUUID: deviceUUID,
+			Values:     values,
+		})
 	}
-
-	return &results, nil
+	return results, nil
 }
 
 func (r *iotdbTelemetryRepository) QueryLatestTelemetry(deviceUUID string) (*TelemetryData, error) {
@@ -224,3 +320,127 @@ func (r *iotdbTelemetryRepository) CreateTimeseries(deviceUUID string, propertyN
 	}
 	return nil
 }
+
+func (r *iotdbTelemetryRepository) DeleteTimeseries(deviceUUID string, propertyNames []string) error {
+	session, err := r.client.SessionPool.GetSession()
+	if err != nil {
+		return err
+	}
+	defer r.client.SessionPool.PutBack(session)
+
+	for _, propName := range propertyNames {
+		path := fmt.Sprintf("root.mm1.device_data.%s.%s", deviceUUID, propName)
+		status, err := session.ExecuteNonQueryStatement(fmt.Sprintf("DELETE TIMESERIES %s", path))
+		if err == nil && status != nil && strings.Contains(status.GetMessage(), "does not exist") {
+			continue // already dropped
+		}
+		if checkErr := r.client.CheckError(status, err); checkErr != nil {
+			return checkErr
+		}
+	}
+	return nil
+}
+
+func (r *iotdbTelemetryRepository) ArchiveTimeseries(deviceUUID, propertyName, archiveName string) error {
+	if err := r.copyTimeseries(deviceUUID, propertyName, archiveName); err != nil {
+		return err
+	}
+	return r.DeleteTimeseries(deviceUUID, []string{propertyName})
+}
+
+// copyTimeseries copies the points of a property into a new series of the same data type.
+func (r *iotdbTelemetryRepository) copyTimeseries(deviceUUID, propertyName, target string) error {
+	session, err := r.client.SessionPool.GetSession()
+	if err != nil {
+		return err
+	}
+	defer r.client.SessionPool.PutBack(session)
+
+	dataSet, err := session.ExecuteQueryStatement(buildTelemetryCopy(deviceUUID, propertyName, target), &r.client.Config.IoTDB.QueryTimeoutMs)
+	if err != nil {
+		if strings.Contains(err.Error(), "does not exist") {
+			return nil // archived by an earlier attempt
+		}
+		return fmt.Errorf("failed to copy %s to %s: %w", propertyName, target, err)
+	}
+	return dataSet.Close()
+}
+
+func (r *iotdbTelemetryRepository) DeleteTelemetryBefore(deviceUUID string, propertyNames []string, before int64) error {
+	if len(propertyNames) == 0 {
+		return nil
+	}
+	status, err := r.client.ExecuteNonQuery(buildTelemetryDelete(deviceUUID, propertyNames, before))
+	if err == nil && status != nil && strings.Contains(status.GetMessage(), "does not exist") {
+		return nil // nothing recorded yet
+	}
+	return r.client.CheckError(status, err)
+}
+
+func (r *iotdbTelemetryRepository) WriteMetric(name string, measurements []string, dataTypes []client.TSDataType, values []interface{}, timestamp int64) error {
+	session, err := r.client.SessionPool.GetSession()
+	if err != nil {
+		return err
+	}
+	defer r.client.SessionPool.PutBack(session)
+
+	status, err := session.InsertRecord("root.mm1.metrics."+name, measurements, dataTypes, values, timestamp)
+	return r.client.CheckError(status, err)
+}
+
+func telemetryDevicePath(deviceUUID string) string {
+	return utils.ConvertHyphenIntoDash(fmt.Sprintf("root.mm1.device_data.%s", deviceUUID))
+}
+
+func buildTelemetrySelect(query TelemetryQuery) string {
+	columns := "*"
+	if len(query.Properties) > 0 {
+		columns = strings.Join(query.Properties, ", ")
+	}
+	sql := fmt.Sprintf("SELECT %s FROM %s WHERE time >= %d AND time < %d ORDER BY time ASC",
+		columns, telemetryDevicePath(query.DeviceUUID), query.StartTime, query.EndTime)
+	return sql + telemetryLimit(query)
+}
+
+func buildTelemetryAggregate(query TelemetryQuery, function string, intervalMs int64) string {
+	columns := make([]string, len(query.Properties))
+	for i, property := range query.Properties {
+		columns[i] = fmt.Sprintf("%s(%s)", function, property)
+	}
+	sql := fmt.Sprintf("SELECT %s FROM %s GROUP BY ([%d, %d), %dms)",
+		strings.Join(columns, ", "), telemetryDevicePath(query.DeviceUUID), query.StartTime, query.EndTime, intervalMs)
+	return sql + telemetryLimit(query)
+}
+
+func buildTelemetryDelete(deviceUUID string, propertyNames []string, before int64) string {
+	devicePath := telemetryDevicePath(deviceUUID)
+	paths := make([]string, len(propertyNames))
+	for i, property := range propertyNames {
+		paths[i] = devicePath + "." + property
+	}
+	return fmt.Sprintf("DELETE FROM %s WHERE time < %d", strings.Join(paths, ", "), before)
+}
+
+func buildTelemetryCopy(deviceUUID, propertyName, target string) string {
+	devicePath := telemetryDevicePath(deviceUUID)
+	return fmt.Sprintf("SELECT %s INTO %s(%s) FROM %s", propertyName, devicePath, target, devicePath)
+}
+
+func telemetryLimit(query TelemetryQuery) string {
+	var clause string
+	if query.Limit > 0 {
+		clause += fmt.Sprintf(" LIMIT %d", query.Limit)
+	}
+	if query.Offset > 0 {
+		clause += fmt.Sprintf(" OFFSET %d", query.Offset)
+	}
+	return clause
+}
+
+// telemetryColumnProperty extracts the property name from a result column such as
+// "root.mm1.device_data.x.temperature" or "avg(root.mm1.device_data.x.temperature)".
+func telemetryColumnProperty(columnName string) string {
+	columnName = strings.TrimSuffix(columnName, ")")
+	parts := strings.Split(columnName, ".")
+	return parts[len(parts)-1]
+}
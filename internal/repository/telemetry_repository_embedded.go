@@ -0,0 +1,340 @@
+package repository
+
+import (
+	"OMEGA3-IOT/internal/db"
+	"OMEGA3-IOT/internal/utils"
+	"fmt"
+	"reflect"
+
+	"github.com/apache/iotdb-client-go/client"
+)
+
+type embeddedTelemetryRepository struct {
+	store *db.EmbeddedTSDB
+}
+
+// NewEmbeddedTelemetryRepository creates a TelemetryRepository on the embedded store. Results
+// carry the same Go types and aggregation semantics as the IoTDB repository.
+func NewEmbeddedTelemetryRepository(store *db.EmbeddedTSDB) TelemetryRepository {
+	return &embeddedTelemetryRepository{store: store}
+}
+
+func embeddedTelemetrySeries(deviceUUID string) string {
+	return "device_data/" + utils.ConvertHyphenIntoDash(deviceUUID)
+}
+
+func (r *embeddedTelemetryRepository) InsertTelemetry(deviceUUID string, measurements []string, values []interface{}, timestamp int64) error {
+	if len(values) != len(measurements) {
+		return fmt.Errorf("telemetry of %s has %d measurements but %d values", deviceUUID, len(measurements), len(values))
+	}
+	point := db.EmbeddedPoint{Timestamp: timestamp, Values: make(map[string]interface{}, len(values))}
+	dataTypes := make(map[string]client.TSDataType, len(values))
+	for i, measurement := range measurements {
+		value := indirectValue(values[i])
+		if dataType, ok := inferTSDataType(value); ok {
+			point.Values[measurement] = value
+			dataTypes[measurement] = dataType
+		}
+	}
+	return r.store.Append(embeddedTelemetrySeries(deviceUUID), []db.EmbeddedPoint{point}, dataTypes)
+}
+
+// BatchInsertTelemetry writes the points device by device. As with tablets, values whose Go
+// type does not match the measurement type are dropped.
+func (r *embeddedTelemetryRepository) BatchInsertTelemetry(telemetryData []TelemetryData) error {
+	type devicePoints struct {
+		points    []db.EmbeddedPoint
+		dataTypes map[string]client.TSDataType
+	}
+	devices := make(map[string]*devicePoints)
+	var order []string
+
+	for _, data := range telemetryData {
+		if len(data.DataTypes) != len(data.Measurements) {
+			return fmt.Errorf("telemetry of %s has %d measurements but %d data types", data.DeviceUUID, len(data.Measurements), len(data.DataTypes))
+		}
+		device, ok := devices[data.DeviceUUID]
+		if !ok {
+			device = &devicePoints{dataTypes: make(map[string]client.TSDataType)}
+			devices[data.DeviceUUID] = device
+			order = append(order, data.DeviceUUID)
+		}
+		point := db.EmbeddedPoint{Timestamp: data.Timestamp, Values: make(map[string]interface{}, len(data.Measurements))}
+		for i, measurement := range data.Measurements {
+			value := indirectValue(data.Values[measurement])
+			if value == nil || !matchesTSDataType(value, data.DataTypes[i]) {
+				continue
+			}
+			if bytes, ok := value.([]byte); ok {
+				value = string(bytes)
+			}
+			point.Values[measurement] = value
+			if _, ok := device.dataTypes[measurement]; !ok {
+				device.dataTypes[measurement] = data.DataTypes[i]
+			}
+		}
+		device.points = append(device.points, point)
+	}
+
+	for _, deviceUUID := range order {
+		device := devices[deviceUUID]
+		if err := r.store.Append(embeddedTelemetrySeries(deviceUUID), device.points, device.dataTypes); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *embeddedTelemetryRepository) QueryTelemetry(query TelemetryQuery) ([]TelemetryQueryResult, error) {
+	points, err := r.store.Range(embeddedTelemetrySeries(query.DeviceUUID), query.StartTime, query.EndTime)
+	if err != nil {
+		return nil, err
+	}
+
+	var results []TelemetryQueryResult
+	for _, point := range points {
+		values := point.Values
+		if len(query.Properties) > 0 {
+			values = make(map[string]interface{}, len(query.Properties))
+			found := false
+			for _, property := range query.Properties {
+				values[property] = point.Values[property]
+				found = found || point.Values[property] != nil
+			}
+			if !found {
+				continue
+			}
+		}
+		results = append(results, TelemetryQueryResult{
+			Timestamp:  point.Timestamp,
+			DeviceUUID: query.DeviceUUID,
+			Values:     values,
+		})
+	}
+	return pageTelemetry(results, query), nil
+}
+
+// AggregateTelemetry returns a row for every window, like GROUP BY in IoTDB: count is 0 and the
+// other aggregations nil for windows without data. avg and sum are float64, the others keep the
+// type of the property.
+func (r *embeddedTelemetryRepository) AggregateTelemetry(query TelemetryQuery, aggregation string, intervalMs int64) ([]TelemetryQueryResult, error) {
+	if _, ok := telemetryAggregations[aggregation]; !ok {
+		return nil, fmt.Errorf("unsupported aggregation: %s", aggregation)
+	}
+	if intervalMs <= 0 {
+		return nil, fmt.Errorf("aggregation interval must be positive")
+	}
+	if len(query.Properties) == 0 {
+		return nil, fmt.Errorf("aggregation requires at least one property")
+	}
+	points, err := r.store.Range(embeddedTelemetrySeries(query.DeviceUUID), query.StartTime, query.EndTime)
+	if err != nil {
+		return nil, err
+	}
+
+	var results []TelemetryQueryResult
+	next := 0
+	for windowStart := query.StartTime; windowStart < query.EndTime; windowStart += intervalMs {
+		windowEnd := windowStart + intervalMs
+		first := next
+		for next < len(points) && points[next].Timestamp < windowEnd {
+			next++
+		}
+		window := points[first:next]
+
+		values := make(map[string]interface{}, len(query.Properties))
+		for _, property := range query.Properties {
+			values[property] = aggregateWindow(window, property, aggregation)
+		}
+		results = append(results, TelemetryQueryResult{
+			Timestamp:  windowStart,
+			DeviceUUID: query.DeviceUUID,
+			Values:     values,
+		})
+	}
+	return pageTelemetry(results, query), nil
+}
+
+func aggregateWindow(points []db.EmbeddedPoint, property, aggregation string) interface{} {
+	var result interface{}
+	var count int64
+	var sum, extreme float64
+	for _, point := range points {
+		value := point.Values[property]
+		if value == nil {
+			continue
+		}
+		switch aggregation {
+		case "first":
+			if result == nil {
+				result = value
+			}
+			continue
+		case "last":
+			result = value
+			continue
+		}
+		number, ok := telemetryNumber(value)
+		if !ok {
+			continue
+		}
+		switch aggregation {
+		case "min":
+			if count == 0 || number < extreme {
+				extreme, result = number, value
+			}
+		case "max":
+			if count == 0 || number > extreme {
+				extreme, result = number, value
+			}
+		}
+		count++
+		sum += number
+	}
+
+	switch aggregation {
+	case "count":
+		return count
+	case "sum":
+		if count == 0 {
+			return nil
+		}
+		return sum
+	case "avg":
+		if count == 0 {
+			return nil
+		}
+		return sum / float64(count)
+	}
+	return result
+}
+
+func pageTelemetry(results []TelemetryQueryResult, query TelemetryQuery) []TelemetryQueryResult {
+	if query.Offset > 0 {
+		if query.Offset >= len(results) {
+			return nil
+		}
+		results = results[query.Offset:]
+	}
+	if query.Limit > 0 && query.Limit < len(results) {
+		results = results[:query.Limit]
+	}
+	return results
+}
+
+func (r *embeddedTelemetryRepository) QueryLatestTelemetry(deviceUUID string) (*TelemetryData, error) {
+	point, err := r.store.Latest(embeddedTelemetrySeries(deviceUUID))
+	if err != nil {
+		return &TelemetryData{}, err
+	}
+	if point == nil {
+		return &TelemetryData{}, fmt.Errorf("no data found for device %s", deviceUUID)
+	}
+	return &TelemetryData{
+		DeviceUUID: deviceUUID,
+		Timestamp:  point.Timestamp,
+		Values:     point.Values,
+	}, nil
+}
+
+func (r *embeddedTelemetryRepository) CreateTimeseries(deviceUUID string, propertyNames []string, dataTypes []client.TSDataType) error {
+	if len(dataTypes) != len(propertyNames) {
+		return fmt.Errorf("timeseries of %s have %d properties but %d data types", deviceUUID, len(propertyNames), len(dataTypes))
+	}
+	types := make(map[string]client.TSDataType, len(propertyNames))
+	for i, property := range propertyNames {
+		types[property] = dataTypes[i]
+	}
+	return r.store.DefineSeries(embeddedTelemetrySeries(deviceUUID), types)
+}
+
+func (r *embeddedTelemetryRepository) DeleteTimeseries(deviceUUID string, propertyNames []string) error {
+	return r.store.DropMeasurements(embeddedTelemetrySeries(deviceUUID), propertyNames)
+}
+
+func (r *embeddedTelemetryRepository) ArchiveTimeseries(deviceUUID, propertyName, archiveName string) error {
+	return r.store.RenameMeasurement(embeddedTelemetrySeries(deviceUUID), propertyName, archiveName)
+}
+
+func (r *embeddedTelemetryRepository) DeleteTelemetryBefore(deviceUUID string, propertyNames []string, before int64) error {
+	if len(propertyNames) == 0 {
+		return nil
+	}
+	return r.store.DeleteBefore(embeddedTelemetrySeries(deviceUUID), propertyNames, before)
+}
+
+func (r *embeddedTelemetryRepository) WriteMetric(name string, measurements []string, dataTypes []client.TSDataType, values []interface{}, timestamp int64) error {
+	if len(dataTypes) != len(measurements) || len(values) != len(measurements) {
+		return fmt.Errorf("metric %s has %d measurements, %d data types and %d values", name, len(measurements), len(dataTypes), len(values))
+	}
+	point := db.EmbeddedPoint{Timestamp: timestamp, Values: make(map[string]interface{}, len(values))}
+	types := make(map[string]client.TSDataType, len(values))
+	for i, measurement := range measurements {
+		point.Values[measurement] = values[i]
+		types[measurement] = dataTypes[i]
+	}
+	return r.store.Append("metrics/"+name, []db.EmbeddedPoint{point}, types)
+}
+
+// indirectValue dereferences pointer values, which tablets accept as well.
+func indirectValue(value interface{}) interface{} {
+	v := reflect.ValueOf(value)
+	if v.Kind() != reflect.Ptr {
+		return value
+	}
+	if v.IsNil() {
+		return nil
+	}
+	return v.Elem().Interface()
+}
+
+// matchesTSDataType reports whether a tablet would accept value for a measurement of dataType.
+func matchesTSDataType(value interface{}, dataType client.TSDataType) bool {
+	switch value.(type) {
+	case bool:
+		return dataType == client.BOOLEAN
+	case int32:
+		return dataType == client.INT32
+	case int64:
+		return dataType == client.INT64 || dataType == client.TIMESTAMP
+	case float32:
+		return dataType == client.FLOAT
+	case float64:
+		return dataType == client.DOUBLE
+	case string, []byte:
+		return dataType == client.TEXT || dataType == client.STRING || dataType == client.BLOB
+	}
+	return false
+}
+
+func inferTSDataType(value interface{}) (client.TSDataType, bool) {
+	switch value.(type) {
+	case bool:
+		return client.BOOLEAN, true
+	case int32:
+		return client.INT32, true
+	case int64:
+		return client.INT64, true
+	case float32:
+		return client.FLOAT, true
+	case float64:
+		return client.DOUBLE, true
+	case string:
+		return client.STRING, true
+	}
+	return client.UNKNOWN, false
+}
+
+func telemetryNumber(value interface{}) (float64, bool) {
+	switch v := value.(type) {
+	case int32:
+		return float64(v), true
+	case int64:
+		return float64(v), true
+	case float32:
+		return float64(v), true
+	case float64:
+		return v, true
+	}
+	return 0, false
+}
@@ -0,0 +1,117 @@
+package repository
+
+import (
+	"OMEGA3-IOT/internal/db"
+	"testing"
+
+	"github.com/apache/iotdb-client-go/client"
+)
+
+func newEmbeddedTestRepository(t *testing.T) TelemetryRepository {
+	t.Helper()
+	store, err := db.NewEmbeddedTSDB(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return NewEmbeddedTelemetryRepository(store)
+}
+
+func TestEmbeddedTelemetryRoundTrip(t *testing.T) {
+	repo := newEmbeddedTestRepository(t)
+	const device = "550e8400-e29b-41d4-a716-446655440000"
+	const day = int64(86400000)
+	base := int64(1700000000000)
+
+	measurements := []string{"temperature", "count", "on"}
+	dataTypes := []client.TSDataType{client.FLOAT, client.INT32, client.BOOLEAN}
+	err := repo.BatchInsertTelemetry([]TelemetryData{
+		{DeviceUUID: device, Measurements: measurements, DataTypes: dataTypes, Timestamp: base,
+			Values: map[string]interface{}{"temperature": float32(20.5), "count": int32(1), "on": true}},
+		{DeviceUUID: device, Measurements: measurements, DataTypes: dataTypes, Timestamp: base + 1000,
+			Values: map[string]interface{}{"temperature": float32(22.5), "count": "bad", "on": false}},
+		{DeviceUUID: device, Measurements: measurements, DataTypes: dataTypes, Timestamp: base + day,
+			Values: map[string]interface{}{"temperature": float32(30), "count": int32(3)}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rows, err := repo.QueryTelemetry(TelemetryQuery{DeviceUUID: device, Properties: []string{"count"}, StartTime: base, EndTime: base + 2*day})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 2 || rows[0].Values["count"] != int32(1) || rows[1].Values["count"] != int32(3) {
+		t.Fatalf("count rows = %+v, want 1 and 3 with the mistyped value dropped", rows)
+	}
+
+	rows, err = repo.AggregateTelemetry(TelemetryQuery{DeviceUUID: device, Properties: []string{"temperature", "count"}, StartTime: base, EndTime: base + 3*day}, "avg", day)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 3 {
+		t.Fatalf("got %d windows, want 3", len(rows))
+	}
+	if rows[0].Values["temperature"] != 21.5 || rows[1].Values["temperature"] != 30.0 || rows[2].Values["temperature"] != nil {
+		t.Errorf("avg windows = %+v", rows)
+	}
+	rows, _ = repo.AggregateTelemetry(TelemetryQuery{DeviceUUID: device, Properties: []string{"temperature"}, StartTime: base, EndTime: base + 3*day}, "count", day)
+	if rows[0].Values["temperature"] != int64(2) || rows[2].Values["temperature"] != int64(0) {
+		t.Errorf("count windows = %+v", rows)
+	}
+	rows, _ = repo.AggregateTelemetry(TelemetryQuery{DeviceUUID: device, Properties: []string{"temperature"}, StartTime: base, EndTime: base + 3*day}, "max", day)
+	if rows[0].Values["temperature"] != float32(22.5) {
+		t.Errorf("max keeps the property type, got %#v", rows[0].Values["temperature"])
+	}
+
+	latest, err := repo.QueryLatestTelemetry(device)
+	if err != nil || latest.Timestamp != base+day || latest.Values["temperature"] != float32(30) {
+		t.Fatalf("latest = %+v, %v", latest, err)
+	}
+
+	if err := repo.DeleteTelemetryBefore(device, []string{"temperature"}, base+day); err != nil {
+		t.Fatal(err)
+	}
+	rows, _ = repo.QueryTelemetry(TelemetryQuery{DeviceUUID: device, StartTime: base, EndTime: base + 2*day})
+	if len(rows) != 3 || rows[0].Values["temperature"] != nil || rows[0].Values["count"] != int32(1) {
+		t.Errorf("after deleting old temperature, rows = %+v", rows)
+	}
+
+	if err := repo.DeleteTimeseries(device, []string{"count", "on", "temperature"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := repo.QueryLatestTelemetry(device); err == nil {
+		t.Error("expected no data after dropping every property")
+	}
+}
+
+func TestEmbeddedArchiveTimeseries(t *testing.T) {
+	repo := newEmbeddedTestRepository(t)
+	const device = "550e8400-e29b-41d4-a716-446655440000"
+	base := int64(1700000000000)
+
+	if err := repo.InsertTelemetry(device, []string{"level", "on"}, []interface{}{int32(3), true}, base); err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.ArchiveTimeseries(device, "level", "level_v1_0_0"); err != nil {
+		t.Fatal(err)
+	}
+	// Repeating an archive that already completed is a no-op
+	if err := repo.ArchiveTimeseries(device, "level", "level_v1_0_0"); err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.CreateTimeseries(device, []string{"level"}, []client.TSDataType{client.DOUBLE}); err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.InsertTelemetry(device, []string{"level"}, []interface{}{3.5}, base+1000); err != nil {
+		t.Fatal(err)
+	}
+
+	rows, err := repo.QueryTelemetry(TelemetryQuery{DeviceUUID: device, StartTime: base, EndTime: base + 2000})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 2 || rows[0].Values["level_v1_0_0"] != int32(3) || rows[0].Values["level"] != nil ||
+		rows[0].Values["on"] != true || rows[1].Values["level"] != 3.5 {
+		t.Errorf("rows after archiving = %+v", rows)
+	}
+}
@@ -0,0 +1,104 @@
+package repository
+
+import (
+	"testing"
+
+	"github.com/apache/iotdb-client-go/client"
+)
+
+func TestBuildTelemetryQueries(t *testing.T) {
+	query := TelemetryQuery{
+		DeviceUUID: "550e8400-e29b-41d4-a716-446655440000",
+		Properties: []string{"temperature", "humidity"},
+		StartTime:  1700000000000,
+		EndTime:    1700086400000,
+		Limit:      101,
+	}
+
+	raw := buildTelemetrySelect(query)
+	wantRaw := "SELECT temperature, humidity FROM root.mm1.device_data.550e8400_e29b_41d4_a716_446655440000" +
+		" WHERE time >= 1700000000000 AND time < 1700086400000 ORDER BY time ASC LIMIT 101"
+	if raw != wantRaw {
+		t.Errorf("raw query:\n got  %s\n want %s", raw, wantRaw)
+	}
+
+	agg := buildTelemetryAggregate(query, telemetryAggregations["max"], 3600000)
+	wantAgg := "SELECT max_value(temperature), max_value(humidity) FROM root.mm1.device_data.550e8400_e29b_41d4_a716_446655440000" +
+		" GROUP BY ([1700000000000, 1700086400000), 3600000ms) LIMIT 101"
+	if agg != wantAgg {
+		t.Errorf("aggregate query:\n got  %s\n want %s", agg, wantAgg)
+	}
+
+	del := buildTelemetryDelete(query.DeviceUUID, query.Properties, 1700000000000)
+	wantDel := "DELETE FROM root.mm1.device_data.550e8400_e29b_41d4_a716_446655440000.temperature," +
+		" root.mm1.device_data.550e8400_e29b_41d4_a716_446655440000.humidity WHERE time < 1700000000000"
+	if del != wantDel {
+		t.Errorf("delete:\n got  %s\n want %s", del, wantDel)
+	}
+
+	copySQL := buildTelemetryCopy(query.DeviceUUID, "temperature", "temperature_v1_0_0")
+	wantCopy := "SELECT temperature INTO root.mm1.device_data.550e8400_e29b_41d4_a716_446655440000(temperature_v1_0_0)" +
+		" FROM root.mm1.device_data.550e8400_e29b_41d4_a716_446655440000"
+	if copySQL != wantCopy {
+		t.Errorf("copy:\n got  %s\n want %s", copySQL, wantCopy)
+	}
+
+	query.Properties, query.Limit, query.Offset = nil, 10, 20
+	if got := buildTelemetrySelect(query); got[:9] != "SELECT * " || got[len(got)-18:] != "LIMIT 10 OFFSET 20" {
+		t.Errorf("select all with offset: %s", got)
+	}
+}
+
+func TestTelemetryColumnProperty(t *testing.T) {
+	cases := map[string]string{
+		"root.mm1.device_data.abc.temperature":      "temperature",
+		"avg(root.mm1.device_data.abc.temperature)": "temperature",
+		"count(root.mm1.device_data.abc.online)":    "online",
+	}
+	for column, want := range cases {
+		if got := telemetryColumnProperty(column); got != want {
+			t.Errorf("telemetryColumnProperty(%q) = %q, want %q", column, got, want)
+		}
+	}
+}
+
+func TestBuildTelemetryTablets(t *testing.T) {
+	points := []TelemetryData{
+		{DeviceUUID: "a", Timestamp: 1000, Measurements: []string{"temperature"}, DataTypes: []client.TSDataType{client.FLOAT},
+			Values: map[string]interface{}{"temperature": float32(21.5)}},
+		{DeviceUUID: "b", Timestamp: 1000, Measurements: []string{"online"}, DataTypes: []client.TSDataType{client.BOOLEAN},
+			Values: map[string]interface{}{"online": true}},
+		{DeviceUUID: "a", Timestamp: 2000, Measurements: []string{"humidity", "temperature"}, DataTypes: []client.TSDataType{client.INT32, client.FLOAT},
+			Values: map[string]interface{}{"humidity": int32(40), "temperature": "bad"}},
+	}
+
+	tablets, err := buildTelemetryTablets(points)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tablets) != 2 {
+		t.Fatalf("got %d tablets, want 2", len(tablets))
+	}
+
+	a := tablets[0]
+	if a.RowSize != 2 || len(a.GetMeasurements()) != 2 || a.GetMeasurements()[0] != "temperature" || a.GetMeasurements()[1] != "humidity" {
+		t.Fatalf("device a: rows %d, measurements %v", a.RowSize, a.GetMeasurements())
+	}
+	want := [][]interface{}{{float32(21.5), nil}, {nil, int32(40)}}
+	for row := range want {
+		for column, wantValue := range want[row] {
+			got, err := a.GetValueAt(column, row)
+			if err != nil || got != wantValue {
+				t.Errorf("device a row %d column %d: got %v (%v), want %v", row, column, got, err, wantValue)
+			}
+		}
+	}
+	if b := tablets[1]; b.RowSize != 1 || b.GetMeasurements()[0] != "online" {
+		t.Errorf("device b: rows %d, measurements %v", b.RowSize, b.GetMeasurements())
+	}
+
+	points[0].DataTypes = nil
+	if _, err := buildTelemetryTablets(points); err == nil {
+		t.Error("expected an error for missing data types")
+	}
+}
@@ -0,0 +1,74 @@
+package repository
+
+import (
+	"OMEGA3-IOT/internal/model"
+
+	"gorm.io/gorm"
+)
+
+// WebhookDeliveryRepository defines the interface for webhook delivery log access.
+type WebhookDeliveryRepository interface {
+	Create(delivery *model.WebhookDelivery) error
+	FindByWebhookUUID(webhookUUID string, limit, offset int) ([]model.WebhookDelivery, int64, error)
+	// FindDue returns pending deliveries whose next attempt is due.
+	FindDue(now int64, limit int) ([]model.WebhookDelivery, error)
+	// Claim leases a due delivery until leaseUntil. It fails if another worker claimed it first.
+	Claim(deliveryUUID string, dueAt, leaseUntil int64) (bool, error)
+	UpdateFields(deliveryUUID string, fields map[string]interface{}) error
+	DeleteByWebhookUUID(webhookUUID string) error
+	WithTx(tx *gorm.DB) WebhookDeliveryRepository
+}
+
+type gormWebhookDeliveryRepository struct {
+	db *gorm.DB
+}
+
+// NewWebhookDeliveryRepository creates a new WebhookDeliveryRepository.
+func NewWebhookDeliveryRepository(db *gorm.DB) WebhookDeliveryRepository {
+	return &gormWebhookDeliveryRepository{db: db}
+}
+
+func (r *gormWebhookDeliveryRepository) Create(delivery *model.WebhookDelivery) error {
+	return r.db.Create(delivery).Error
+}
+
+func (r *gormWebhookDeliveryRepository) FindByWebhookUUID(webhookUUID string, limit, offset int) ([]model.WebhookDelivery, int64, error) {
+	var deliveries []model.WebhookDelivery
+	var total int64
+	query := r.db.Model(&model.WebhookDelivery{}).Where("webhook_uuid = ?", webhookUUID)
+	if err := query.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+	err := query.Order("created_at DESC, id DESC").Limit(limit).Offset(offset).Find(&deliveries).Error
+	return deliveries, total, err
+}
+
+func (r *gormWebhookDeliveryRepository) FindDue(now int64, limit int) ([]model.WebhookDelivery, error) {
+	var deliveries []model.WebhookDelivery
+	err := r.db.Where("status = ? AND next_attempt_at <= ?", model.WebhookDeliveryPending, now).
+		Order("next_attempt_at ASC").
+		Limit(limit).
+		Find(&deliveries).Error
+	return deliveries, err
+}
+
+func (r *gormWebhookDeliveryRepository) Claim(deliveryUUID string, dueAt, leaseUntil int64) (bool, error) {
+	result := r.db.Model(&model.WebhookDelivery{}).
+		Where("delivery_uuid = ? AND status = ? AND next_attempt_at = ?", deliveryUUID, model.WebhookDeliveryPending, dueAt).
+		Update("next_attempt_at", leaseUntil)
+	return result.RowsAffected > 0, result.Error
+}
+
+func (r *gormWebhookDeliveryRepository) UpdateFields(deliveryUUID string, fields map[string]interface{}) error {
+	return r.db.Model(&model.WebhookDelivery{}).
+		Where("delivery_uuid = ?", deliveryUUID).
+		Updates(fields).Error
+}
+
+func (r *gormWebhookDeliveryRepository) DeleteByWebhookUUID(webhookUUID string) error {
+	return r.db.Where("webhook_uuid = ?", webhookUUID).Delete(&model.WebhookDelivery{}).Error
+}
+
+func (r *gormWebhookDeliveryRepository) WithTx(tx *gorm.DB) WebhookDeliveryRepository {
+	return &gormWebhookDeliveryRepository{db: tx}
+}
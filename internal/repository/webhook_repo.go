@@ -0,0 +1,93 @@
+package repository
+
+import (
+	"OMEGA3-IOT/internal/model"
+
+	"gorm.io/gorm"
+)
+
+// WebhookRepository defines the interface for webhook data access.
+type WebhookRepository interface {
+	Create(webhook *model.Webhook) error
+	FindByWebhookUUID(webhookUUID string) (*model.Webhook, error)
+	// FindByOwner lists the user webhooks of ownerUUID, or all global webhooks if global is true.
+	FindByOwner(ownerUUID string, global bool, limit, offset int) ([]model.Webhook, int64, error)
+	FindEnabled() ([]model.Webhook, error)
+	Update(webhook *model.Webhook) error
+	// IncrementFailures bumps the consecutive failure counter and returns the new value.
+	IncrementFailures(webhookUUID string) (int, error)
+	ResetFailures(webhookUUID string) error
+	Delete(webhookUUID string) error
+	WithTx(tx *gorm.DB) WebhookRepository
+}
+
+type gormWebhookRepository struct {
+	db *gorm.DB
+}
+
+// NewWebhookRepository creates a new WebhookRepository.
+func NewWebhookRepository(db *gorm.DB) WebhookRepository {
+	return &gormWebhookRepository{db: db}
+}
+
+func (r *gormWebhookRepository) Create(webhook *model.Webhook) error {
+	return r.db.Create(webhook).Error
+}
+
+func (r *gormWebhookRepository) FindByWebhookUUID(webhookUUID string) (*model.Webhook, error) {
+	var webhook model.Webhook
+	err := r.db.Where("webhook_uuid = ?", webhookUUID).First(&webhook).Error
+	return &webhook, err
+}
+
+func (r *gormWebhookRepository) FindByOwner(ownerUUID string, global bool, limit, offset int) ([]model.Webhook, int64, error) {
+	var webhooks []model.Webhook
+	var total int64
+	query := r.db.Model(&model.Webhook{}).Where("global = ?", global)
+	if !global {
+		query = query.Where("owner_uuid = ?", ownerUUID)
+	}
+	if err := query.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+	err := query.Order("created_at DESC").Limit(limit).Offset(offset).Find(&webhooks).Error
+	return webhooks, total, err
+}
+
+func (r *gormWebhookRepository) FindEnabled() ([]model.Webhook, error) {
+	var webhooks []model.Webhook
+	err := r.db.Where("enabled = ?", true).Find(&webhooks).Error
+	return webhooks, err
+}
+
+func (r *gormWebhookRepository) Update(webhook *model.Webhook) error {
+	return r.db.Save(webhook).Error
+}
+
+func (r *gormWebhookRepository) IncrementFailures(webhookUUID string) (int, error) {
+	err := r.db.Model(&model.Webhook{}).
+		Where("webhook_uuid = ?", webhookUUID).
+		Update("consecutive_failures", gorm.Expr("consecutive_failures + 1")).Error
+	if err != nil {
+		return 0, err
+	}
+	var failures int
+	err = r.db.Model(&model.Webhook{}).
+		Where("webhook_uuid = ?", webhookUUID).
+		Pluck("consecutive_failures", &failures).Error
+	return failures, err
+}
+
+func (r *gormWebhookRepository) ResetFailures(webhookUUID string) error {
+	return r.db.Model(&model.Webhook{}).
+		Where("webhook_uuid = ? AND consecutive_failures > 0", webhookUUID).
+		Update("consecutive_failures", 0).Error
+}
+
+func (r *gormWebhookRepository) Delete(webhookUUID string) error {
+	return r.db.Where("webhook_uuid = ?", webhookUUID).Delete(&model.Webhook{}).Error
+}
+
+func (r *gormWebhookRepository) WithTx(tx *gorm.DB) WebhookRepository {
+	return &gormWebhookRepository{db: tx}
+}
@@ -0,0 +1,416 @@
+package service
+
+import (
+	"OMEGA3-IOT/internal/eventbus"
+	"OMEGA3-IOT/internal/logger"
+	"OMEGA3-IOT/internal/model"
+	"OMEGA3-IOT/internal/repository"
+	"OMEGA3-IOT/internal/spec"
+	"OMEGA3-IOT/internal/utils"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const (
+	actionTimeoutCheckInterval = 5 * time.Second
+	actionTimeoutBatchSize     = 100
+)
+
+var (
+	ErrDeviceNotFound    = errors.New("device not found")
+	ErrUnknownDeviceType = errors.New("unknown device type")
+	ErrInvalidAction     = errors.New("action validation failed")
+)
+
+// ActionService owns the lifecycle of actions sent to devices: it assigns each one an
+// action ID, persists its state, correlates device results and expires unanswered actions.
+// Actions for offline devices are queued and flushed in order when the device comes back online.
+type ActionService struct {
+	actionRepo      repository.ActionRecordRepository
+	instanceRepo    repository.InstanceRepository
+	publisher       Publisher
+	presenceService *PresenceService
+	eventBus        *eventbus.EventBus
+	stopCh          chan struct{}
+	wg              sync.WaitGroup
+
+	// per-device lock so that concurrent sends and flushes keep queue order
+	flushLocks sync.Map // map[string]*sync.Mutex
+}
+
+func NewActionService(
+	actionRepo repository.ActionRecordRepository,
+	instanceRepo repository.InstanceRepository,
+	publisher Publisher,
+	presenceService *PresenceService,
+	eventBus *eventbus.EventBus,
+) *ActionService {
+	return &ActionService{
+		actionRepo:      actionRepo,
+		instanceRepo:    instanceRepo,
+		publisher:       publisher,
+		presenceService: presenceService,
+		eventBus:        eventBus,
+		stopCh:          make(chan struct{}),
+	}
+}
+
+// Start subscribes to device action results and status changes, and launches the timeout checker.
+func (s *ActionService) Start() {
+	eventbus.SubscribeTyped(s.eventBus, eventbus.EventType(logger.LogEventDeviceActionResult), s.handleActionResult, eventbus.WithGroup("service.ActionService.handleActionResult"))
+	eventbus.SubscribeTyped(s.eventBus, eventbus.EventType(logger.LogEventDeviceStatusChange), s.handleStatusChange, eventbus.WithGroup("service.ActionService.handleStatusChange"))
+
+	s.wg.Add(1)
+	go s.run()
+	log.Printf("[ActionService] Started (timeout check interval: %v)", actionTimeoutCheckInterval)
+}
+
+// Stop gracefully shuts down the timeout checker.
+func (s *ActionService) Stop() {
+	close(s.stopCh)
+	s.wg.Wait()
+	log.Println("[ActionService] Stopped")
+}
+
+// SendAction validates an action against the device type spec, records it and publishes it to the device.
+// If the device is offline, or earlier actions are still queued, the action is queued instead;
+// queueTTLSec > 0 limits how long it may wait.
+// The returned record reflects the state after the publish attempt.
+func (s *ActionService) SendAction(instanceUUID, command string, params map[string]interface{}, requestedBy string, queueTTLSec int) (*model.ActionRecord, error) {
+	return s.SendActionWithID(utils.GenerateUUID().String(), instanceUUID, command, params, requestedBy, queueTTLSec)
+}
+
+// SendActionWithID is SendAction with an action ID chosen by the caller, so the caller can prepare
+// for the result before the device can answer.
+func (s *ActionService) SendActionWithID(actionID, instanceUUID, command string, params map[string]interface{}, requestedBy string, queueTTLSec int) (*model.ActionRecord, error) {
+	instance, err := s.instanceRepo.FindByUUID(instanceUUID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrDeviceNotFound
+		}
+		return nil, fmt.Errorf("failed to find device: %w", err)
+	}
+
+	typeDef, ok := model.GlobalDeviceTypeManager.GetByName(instance.Type)
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownDeviceType, instance.Type)
+	}
+	if err := spec.ValidateAction(typeDef, command, params); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidAction, err)
+	}
+
+	paramsJSON, err := json.Marshal(params)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal action params: %w", err)
+	}
+
+	// Hold the flush lock so a direct delivery cannot overtake actions still waiting in the queue
+	lock := s.deviceLock(instanceUUID)
+	lock.Lock()
+	defer lock.Unlock()
+
+	online := s.presenceService.IsOnline(instanceUUID)
+	queuedAhead := false
+	if online {
+		queued, err := s.actionRepo.FindQueuedByInstanceUUID(instanceUUID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to check action queue: %w", err)
+		}
+		queuedAhead = len(queued) > 0
+	}
+
+	actionMeta := typeDef.Actions[command]
+	record := model.NewActionRecord(actionID, instanceUUID, command, paramsJSON, requestedBy, actionMeta.TimeoutSec, actionMeta.Idempotent)
+	if online && !queuedAhead {
+		record.Attempts = 1
+		// Bound the pending state too, so an action whose publish never completed still times out
+		record.ExpiresAt = record.CreatedAt + int64(record.TimeoutSec)
+	} else {
+		record.Status = model.ActionStatusQueued
+		if queueTTLSec > 0 {
+			record.ExpiresAt = record.CreatedAt + int64(queueTTLSec)
+		}
+	}
+	if err := s.actionRepo.Create(record); err != nil {
+		return nil, fmt.Errorf("failed to create action record: %w", err)
+	}
+
+	received := logger.NewDeviceLogEvent(instanceUUID, logger.LogLevelInfo, fmt.Sprintf("Action requested: %s", command), logger.LogEventDeviceActionReceived)
+	received.ActionName = command
+	received.Metadata["action_id"] = record.ActionID
+	received.Metadata["requested_by"] = requestedBy
+	s.eventBus.Publish(context.Background(), received)
+
+	if queuedAhead {
+		// Deliver behind the earlier queued actions, keeping the order they were sent in
+		log.Printf("[ActionService] Device %s has queued actions, queued action %s (%s) behind them", instanceUUID, record.ActionID, command)
+		s.flushQueueLocked(instanceUUID)
+		if updated, err := s.actionRepo.FindByActionID(record.ActionID); err == nil {
+			record = updated
+		}
+		return record, nil
+	}
+	if !online {
+		log.Printf("[ActionService] Device %s is offline, queued action %s (%s)", instanceUUID, record.ActionID, command)
+		// The device may have come online between the presence check and the insert
+		if s.presenceService.IsOnline(instanceUUID) {
+			go s.FlushQueue(instanceUUID)
+		}
+		return record, nil
+	}
+
+	s.deliver(record, params, false)
+	return record, nil
+}
+
+// deviceLock returns the per-device mutex that serialises sends and flushes to one device.
+func (s *ActionService) deviceLock(instanceUUID string) *sync.Mutex {
+	lock, _ := s.flushLocks.LoadOrStore(instanceUUID, &sync.Mutex{})
+	return lock.(*sync.Mutex)
+}
+
+// FlushQueue delivers the queued actions of a device in the order they were sent.
+func (s *ActionService) FlushQueue(instanceUUID string) {
+	lock := s.deviceLock(instanceUUID)
+	lock.Lock()
+	defer lock.Unlock()
+	s.flushQueueLocked(instanceUUID)
+}
+
+// flushQueueLocked is FlushQueue for callers that already hold the device lock.
+func (s *ActionService) flushQueueLocked(instanceUUID string) {
+	records, err := s.actionRepo.FindQueuedByInstanceUUID(instanceUUID)
+	if err != nil {
+		log.Printf("[ActionService] Failed to load queued actions for device %s: %v", instanceUUID, err)
+		return
+	}
+	if len(records) == 0 {
+		return
+	}
+	log.Printf("[ActionService] Flushing %d queued action(s) to device %s", len(records), instanceUUID)
+
+	now := time.Now().Unix()
+	for i := range records {
+		record := &records[i]
+		if record.ExpiresAt > 0 && record.ExpiresAt <= now {
+			continue // left for the expiry checker
+		}
+
+		claimed, err := s.actionRepo.ClaimQueued(record.ActionID, now)
+		if err != nil {
+			log.Printf("[ActionService] Failed to claim queued action %s: %v", record.ActionID, err)
+			return
+		}
+		if !claimed {
+			continue
+		}
+		record.Status = model.ActionStatusPending
+		record.Attempts++
+		record.ExpiresAt = now + int64(record.TimeoutSec)
+
+		var params map[string]interface{}
+		if len(record.Params) > 0 {
+			if err := json.Unmarshal(record.Params, &params); err != nil {
+				s.complete(record, model.ActionStatusFailed, fmt.Sprintf("invalid stored params: %v", err))
+				continue
+			}
+		}
+		// Only idempotent actions may be retried on a later flush; ClaimQueued enforces the same rule
+		if !s.deliver(record, params, record.Idempotent) {
+			// Stop on publish failure so the remaining actions keep their order
+			return
+		}
+	}
+}
+
+// deliver publishes a pending action to the device and moves it to delivered.
+// On publish failure the action goes back to the queue if requeue is set, otherwise it fails.
+func (s *ActionService) deliver(record *model.ActionRecord, params map[string]interface{}, requeue bool) bool {
+	payload := model.Action{
+		ActionID:  record.ActionID,
+		Command:   record.Command,
+		Params:    params,
+		Timestamp: time.Now().Unix(),
+	}
+
+	if err := s.publisher.PublishActionToDevice(record.InstanceUUID, record.Command, payload); err != nil {
+		log.Printf("[ActionService] Failed to publish action %s to device %s: %v", record.ActionID, record.InstanceUUID, err)
+		if requeue {
+			if _, err := s.actionRepo.UpdateStatusIf(record.ActionID, []string{model.ActionStatusPending}, map[string]interface{}{"status": model.ActionStatusQueued}); err == nil {
+				record.Status = model.ActionStatusQueued
+				return false
+			}
+		}
+		s.complete(record, model.ActionStatusFailed, err.Error())
+		return false
+	}
+
+	now := time.Now().Unix()
+	fields := map[string]interface{}{
+		"status":       model.ActionStatusDelivered,
+		"delivered_at": now,
+		"expires_at":   now + int64(record.TimeoutSec),
+	}
+	updated, err := s.actionRepo.UpdateStatusIf(record.ActionID, []string{model.ActionStatusPending}, fields)
+	if err != nil {
+		log.Printf("[ActionService] Failed to mark action %s delivered: %v", record.ActionID, err)
+		return true
+	}
+	if updated {
+		record.Status = model.ActionStatusDelivered
+		record.DeliveredAt = now
+		record.ExpiresAt = now + int64(record.TimeoutSec)
+	}
+	return true
+}
+
+// complete moves an action into a terminal status, provided it is still in the status the caller last saw.
+func (s *ActionService) complete(record *model.ActionRecord, status, errMsg string) bool {
+	if record.IsFinal() {
+		return false
+	}
+	now := time.Now().Unix()
+	fields := map[string]interface{}{
+		"status":       status,
+		"error":        errMsg,
+		"completed_at": now,
+	}
+	updated, err := s.actionRepo.UpdateStatusIf(record.ActionID, []string{record.Status}, fields)
+	if err != nil {
+		log.Printf("[ActionService] Failed to mark action %s %s: %v", record.ActionID, status, err)
+		return false
+	}
+	if !updated {
+		return false
+	}
+	record.Status = status
+	record.Error = errMsg
+	record.CompletedAt = now
+
+	// Announce the correlated final state so push can route it back to whoever sent the action
+	event := logger.NewDeviceLogEvent(record.InstanceUUID, logger.LogLevelInfo, fmt.Sprintf("Action %s: %s", status, record.Command), logger.LogEventDeviceActionComplete)
+	event.ActionName = record.Command
+	event.Metadata["action_id"] = record.ActionID
+	event.Metadata["command"] = record.Command
+	event.Metadata["status"] = status
+	event.Metadata["success"] = status == model.ActionStatusSucceeded
+	event.Metadata["error"] = errMsg
+	event.Metadata["requested_by"] = record.RequestedBy
+	s.eventBus.Publish(context.Background(), event)
+	return true
+}
+
+// GetHistory returns the actions sent to a device, newest first, with the total count.
+func (s *ActionService) GetHistory(instanceUUID string, limit, offset int) ([]model.ActionRecord, int64, error) {
+	records, err := s.actionRepo.FindByInstanceUUID(instanceUUID, limit, offset)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to query action history: %w", err)
+	}
+	total, err := s.actionRepo.CountByInstanceUUID(instanceUUID)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to count action history: %w", err)
+	}
+	return records, total, nil
+}
+
+// handleActionResult correlates a device action_result with its record. Devices that do not
+// echo action_id yet are matched to the oldest outstanding action with the same command.
+func (s *ActionService) handleActionResult(ctx context.Context, event logger.DeviceLogEvent) error {
+	actionID, _ := event.Metadata["action_id"].(string)
+	command, _ := event.Metadata["command"].(string)
+	success, _ := event.Metadata["success"].(bool)
+	errMsg, _ := event.Metadata["error"].(string)
+
+	var record *model.ActionRecord
+	var err error
+	if actionID != "" {
+		record, err = s.actionRepo.FindByActionID(actionID)
+	} else {
+		record, err = s.actionRepo.FindOldestOutstandingByCommand(event.DeviceUUID, command)
+	}
+	if err != nil {
+		log.Printf("[ActionService] No outstanding action for result from device %s (action_id=%q, command=%s)", event.DeviceUUID, actionID, command)
+		return nil
+	}
+	if record.InstanceUUID != event.DeviceUUID {
+		log.Printf("[ActionService] Device %s reported result for action %s owned by another device", event.DeviceUUID, record.ActionID)
+		return nil
+	}
+	if record.Status == model.ActionStatusQueued {
+		log.Printf("[ActionService] Ignoring result for action %s that has not been delivered yet", record.ActionID)
+		return nil
+	}
+
+	status := model.ActionStatusSucceeded
+	if !success {
+		status = model.ActionStatusFailed
+	}
+	if !s.complete(record, status, errMsg) {
+		log.Printf("[ActionService] Ignoring late result for action %s (already final)", record.ActionID)
+	}
+	return nil
+}
+
+func (s *ActionService) run() {
+	defer s.wg.Done()
+	ticker := time.NewTicker(actionTimeoutCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.stopCh:
+			return
+		case <-ticker.C:
+			s.expireActions()
+		}
+	}
+}
+
+// expireActions marks pending and delivered actions whose timeout has passed as timed out,
+// and queued actions whose TTL has passed as expired.
+func (s *ActionService) expireActions() {
+	records, err := s.actionRepo.FindExpired(time.Now().Unix(), actionTimeoutBatchSize)
+	if err != nil {
+		log.Printf("[ActionService] Failed to query expired actions: %v", err)
+		return
+	}
+
+	for i := range records {
+		record := &records[i]
+		status, errMsg := model.ActionStatusTimedOut, "device did not respond in time"
+		switch record.Status {
+		case model.ActionStatusQueued:
+			status, errMsg = model.ActionStatusExpired, "device did not come online before the queue TTL"
+		case model.ActionStatusPending:
+			errMsg = "action was not delivered in time"
+		}
+		if !s.complete(record, status, errMsg) {
+			continue
+		}
+		log.Printf("[ActionService] Action %s (%s) to device %s is %s", record.ActionID, record.Command, record.InstanceUUID, status)
+
+		event := logger.NewDeviceLogEvent(record.InstanceUUID, logger.LogLevelWarning, fmt.Sprintf("Action %s: %s", status, record.Command), logger.LogEventDeviceActionTimeout)
+		event.ActionName = record.Command
+		event.Metadata["action_id"] = record.ActionID
+		event.Metadata["command"] = record.Command
+		event.Metadata["status"] = status
+		event.Metadata["error"] = errMsg
+		event.Metadata["requested_by"] = record.RequestedBy
+		s.eventBus.Publish(context.Background(), event)
+	}
+}
+
+// handleStatusChange flushes the action queue of a device that has just come online.
+func (s *ActionService) handleStatusChange(ctx context.Context, event logger.DeviceLogEvent) error {
+	if status, _ := event.Metadata["status"].(string); status == "online" {
+		s.FlushQueue(event.DeviceUUID)
+	}
+	return nil
+}
@@ -0,0 +1,382 @@
+package service
+
+import (
+	"OMEGA3-IOT/internal/eventbus"
+	"OMEGA3-IOT/internal/logger"
+	"OMEGA3-IOT/internal/model"
+	"OMEGA3-IOT/internal/repository"
+	"context"
+	"errors"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+type fakeActionRepo struct {
+	repository.ActionRecordRepository
+	mu      sync.Mutex
+	records []*model.ActionRecord
+}
+
+func (r *fakeActionRepo) Create(record *model.ActionRecord) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	record.ID = uint(len(r.records) + 1)
+	stored := *record
+	r.records = append(r.records, &stored)
+	return nil
+}
+
+func (r *fakeActionRepo) find(actionID string) *model.ActionRecord {
+	for _, record := range r.records {
+		if record.ActionID == actionID {
+			return record
+		}
+	}
+	return nil
+}
+
+func (r *fakeActionRepo) FindByActionID(actionID string) (*model.ActionRecord, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if record := r.find(actionID); record != nil {
+		stored := *record
+		return &stored, nil
+	}
+	return nil, gorm.ErrRecordNotFound
+}
+
+func (r *fakeActionRepo) FindByInstanceUUID(instanceUUID string, limit, offset int) ([]model.ActionRecord, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var records []model.ActionRecord
+	for i := len(r.records) - 1; i >= 0; i-- {
+		if r.records[i].InstanceUUID == instanceUUID {
+			records = append(records, *r.records[i])
+		}
+	}
+	if offset >= len(records) {
+		return nil, nil
+	}
+	records = records[offset:]
+	if limit < len(records) {
+		records = records[:limit]
+	}
+	return records, nil
+}
+
+func (r *fakeActionRepo) CountByInstanceUUID(instanceUUID string) (int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var count int64
+	for _, record := range r.records {
+		if record.InstanceUUID == instanceUUID {
+			count++
+		}
+	}
+	return count, nil
+}
+
+func (r *fakeActionRepo) FindOldestOutstandingByCommand(instanceUUID, command string) (*model.ActionRecord, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, record := range r.records {
+		if record.InstanceUUID == instanceUUID && record.Command == command &&
+			(record.Status == model.ActionStatusPending || record.Status == model.ActionStatusDelivered) {
+			stored := *record
+			return &stored, nil
+		}
+	}
+	return nil, gorm.ErrRecordNotFound
+}
+
+func (r *fakeActionRepo) FindExpired(now int64, limit int) ([]model.ActionRecord, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var records []model.ActionRecord
+	for _, record := range r.records {
+		if !record.IsFinal() && record.ExpiresAt > 0 && record.ExpiresAt <= now {
+			records = append(records, *record)
+		}
+	}
+	sort.SliceStable(records, func(i, j int) bool { return records[i].ExpiresAt < records[j].ExpiresAt })
+	if limit < len(records) {
+		records = records[:limit]
+	}
+	return records, nil
+}
+
+func (r *fakeActionRepo) FindQueuedByInstanceUUID(instanceUUID string) ([]model.ActionRecord, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var records []model.ActionRecord
+	for _, record := range r.records {
+		if record.InstanceUUID == instanceUUID && record.Status == model.ActionStatusQueued {
+			records = append(records, *record)
+		}
+	}
+	return records, nil
+}
+
+func (r *fakeActionRepo) ClaimQueued(actionID string, now int64) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	record := r.find(actionID)
+	if record == nil || record.Status != model.ActionStatusQueued || (!record.Idempotent && record.Attempts > 0) {
+		return false, nil
+	}
+	record.Status = model.ActionStatusPending
+	record.Attempts++
+	record.ExpiresAt = now + int64(record.TimeoutSec)
+	return true, nil
+}
+
+func (r *fakeActionRepo) UpdateStatusIf(actionID string, fromStatuses []string, fields map[string]interface{}) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	record := r.find(actionID)
+	if record == nil {
+		return false, nil
+	}
+	matched := false
+	for _, status := range fromStatuses {
+		matched = matched || record.Status == status
+	}
+	if !matched {
+		return false, nil
+	}
+	for field, value := range fields {
+		switch field {
+		case "status":
+			record.Status = value.(string)
+		case "error":
+			record.Error = value.(string)
+		case "delivered_at":
+			record.DeliveredAt = value.(int64)
+		case "completed_at":
+			record.CompletedAt = value.(int64)
+		case "expires_at":
+			record.ExpiresAt = value.(int64)
+		}
+	}
+	return true, nil
+}
+
+// expire moves the deadline of a stored action into the past.
+func (r *fakeActionRepo) expire(actionID string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.find(actionID).ExpiresAt = time.Now().Unix() - 1
+}
+
+type fakeActionInstanceRepo struct {
+	repository.InstanceRepository
+}
+
+func (fakeActionInstanceRepo) FindByUUID(instanceUUID string) (*model.Instance, error) {
+	if instanceUUID != "dev-1" {
+		return nil, gorm.ErrRecordNotFound
+	}
+	return &model.Instance{InstanceUUID: instanceUUID, Type: "action-test-lamp"}, nil
+}
+
+type fakePublisher struct {
+	mu       sync.Mutex
+	commands []string
+}
+
+func (p *fakePublisher) PublishActionToDevice(deviceUUID string, commandName string, payload model.Action) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.commands = append(p.commands, commandName)
+	return nil
+}
+
+func (p *fakePublisher) sent() []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]string(nil), p.commands...)
+}
+
+func newTestActionService(t *testing.T) (*ActionService, *fakeActionRepo, *fakePublisher, *PresenceService) {
+	t.Helper()
+	model.GlobalDeviceTypeManager.Replace([]model.DeviceType{{
+		ID:   9001,
+		Name: "action-test-lamp",
+		Actions: map[string]model.ActionMeta{
+			"on":     {Key: "on", TimeoutSec: 10},
+			"off":    {Key: "off", TimeoutSec: 10},
+			"reboot": {Key: "reboot", TimeoutSec: 60},
+		},
+	}})
+	eb := eventbus.New()
+	t.Cleanup(eb.Close)
+
+	repo := &fakeActionRepo{}
+	publisher := &fakePublisher{}
+	presence := NewPresenceService(nil, eb, 0, 0)
+	return NewActionService(repo, fakeActionInstanceRepo{}, publisher, presence, eb), repo, publisher, presence
+}
+
+func TestSendActionDeliversToOnlineDevice(t *testing.T) {
+	s, repo, publisher, presence := newTestActionService(t)
+	presence.onlineDevices.Store("dev-1", true)
+
+	record, err := s.SendAction("dev-1", "on", nil, "user-1", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if record.Status != model.ActionStatusDelivered || record.Attempts != 1 {
+		t.Fatalf("record %+v", record)
+	}
+	if got := publisher.sent(); len(got) != 1 || got[0] != "on" {
+		t.Fatalf("published %v", got)
+	}
+	stored, _ := repo.FindByActionID(record.ActionID)
+	if stored.ExpiresAt != stored.DeliveredAt+10 {
+		t.Errorf("expires_at %d, delivered_at %d", stored.ExpiresAt, stored.DeliveredAt)
+	}
+
+	if _, err := s.SendAction("dev-2", "on", nil, "user-1", 0); !errors.Is(err, ErrDeviceNotFound) {
+		t.Errorf("unknown device: %v", err)
+	}
+	if _, err := s.SendAction("dev-1", "dim", nil, "user-1", 0); !errors.Is(err, ErrInvalidAction) {
+		t.Errorf("unknown action: %v", err)
+	}
+}
+
+func TestSendActionQueuesBehindEarlierActions(t *testing.T) {
+	s, repo, publisher, presence := newTestActionService(t)
+
+	first, _ := s.SendAction("dev-1", "on", nil, "user-1", 0)
+	if first.Status != model.ActionStatusQueued || len(publisher.sent()) != 0 {
+		t.Fatalf("offline send %+v, published %v", first, publisher.sent())
+	}
+
+	// Online again, but the queue has not been flushed yet: the new action must wait its turn
+	presence.onlineDevices.Store("dev-1", true)
+	second, err := s.SendAction("dev-1", "off", nil, "user-1", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if second.Status != model.ActionStatusDelivered {
+		t.Errorf("second action %s", second.Status)
+	}
+	if got := publisher.sent(); len(got) != 2 || got[0] != "on" || got[1] != "off" {
+		t.Fatalf("published %v", got)
+	}
+	if stored, _ := repo.FindByActionID(first.ActionID); stored.Status != model.ActionStatusDelivered {
+		t.Errorf("first action %s", stored.Status)
+	}
+}
+
+func TestActionResultCompletesAction(t *testing.T) {
+	s, repo, _, presence := newTestActionService(t)
+	presence.onlineDevices.Store("dev-1", true)
+	ctx := context.Background()
+
+	byID, _ := s.SendAction("dev-1", "on", nil, "user-1", 0)
+	byCommand, _ := s.SendAction("dev-1", "reboot", nil, "user-1", 0)
+
+	result := logger.NewDeviceLogEvent("dev-1", logger.LogLevelInfo, "Action result", logger.LogEventDeviceActionResult)
+	result.Metadata["action_id"] = byID.ActionID
+	result.Metadata["success"] = true
+	s.handleActionResult(ctx, result)
+	if stored, _ := repo.FindByActionID(byID.ActionID); stored.Status != model.ActionStatusSucceeded || stored.CompletedAt == 0 {
+		t.Errorf("action by id %+v", stored)
+	}
+
+	// A late failure must not overwrite the final state
+	result.Metadata["success"] = false
+	s.handleActionResult(ctx, result)
+	if stored, _ := repo.FindByActionID(byID.ActionID); stored.Status != model.ActionStatusSucceeded {
+		t.Errorf("late result changed status to %s", stored.Status)
+	}
+
+	// Devices that do not echo action_id are matched by command
+	legacy := logger.NewDeviceLogEvent("dev-1", logger.LogLevelInfo, "Action result", logger.LogEventDeviceActionResult)
+	legacy.Metadata["command"] = "reboot"
+	legacy.Metadata["success"] = false
+	legacy.Metadata["error"] = "busy"
+	s.handleActionResult(ctx, legacy)
+	if stored, _ := repo.FindByActionID(byCommand.ActionID); stored.Status != model.ActionStatusFailed || stored.Error != "busy" {
+		t.Errorf("action by command %+v", stored)
+	}
+
+	// Results from another device are ignored
+	other, _ := s.SendAction("dev-1", "off", nil, "user-1", 0)
+	foreign := logger.NewDeviceLogEvent("dev-2", logger.LogLevelInfo, "Action result", logger.LogEventDeviceActionResult)
+	foreign.Metadata["action_id"] = other.ActionID
+	foreign.Metadata["success"] = true
+	s.handleActionResult(ctx, foreign)
+	if stored, _ := repo.FindByActionID(other.ActionID); stored.Status != model.ActionStatusDelivered {
+		t.Errorf("foreign result completed action: %s", stored.Status)
+	}
+}
+
+func TestExpireActions(t *testing.T) {
+	s, repo, _, presence := newTestActionService(t)
+
+	queued, _ := s.SendAction("dev-1", "on", nil, "user-1", 60)
+	if queued.ExpiresAt != queued.CreatedAt+60 {
+		t.Errorf("queue ttl %d", queued.ExpiresAt-queued.CreatedAt)
+	}
+	forever, _ := s.SendAction("dev-1", "off", nil, "user-1", 0)
+
+	// Simulate a publish that never completed: the record stays pending with a creation deadline
+	pending := model.NewActionRecord("stuck", "dev-1", "reboot", nil, "user-1", 60, false)
+	pending.ExpiresAt = pending.CreatedAt + 60
+	repo.Create(pending)
+
+	repo.expire(queued.ActionID)
+	repo.expire(pending.ActionID)
+	s.expireActions()
+
+	if stored, _ := repo.FindByActionID(queued.ActionID); stored.Status != model.ActionStatusExpired {
+		t.Errorf("queued action %s", stored.Status)
+	}
+	if stored, _ := repo.FindByActionID(forever.ActionID); stored.Status != model.ActionStatusQueued {
+		t.Errorf("queued action without ttl %s", stored.Status)
+	}
+	if stored, _ := repo.FindByActionID(pending.ActionID); stored.Status != model.ActionStatusTimedOut {
+		t.Errorf("pending action %s", stored.Status)
+	}
+
+	presence.onlineDevices.Store("dev-1", true)
+	s.FlushQueue("dev-1")
+	delivered, _ := repo.FindByActionID(forever.ActionID)
+	if delivered.Status != model.ActionStatusDelivered {
+		t.Fatalf("flushed action %s", delivered.Status)
+	}
+	repo.expire(delivered.ActionID)
+	s.expireActions()
+	if stored, _ := repo.FindByActionID(delivered.ActionID); stored.Status != model.ActionStatusTimedOut || stored.Error != "device did not respond in time" {
+		t.Errorf("delivered action %+v", stored)
+	}
+}
+
+func TestGetHistory(t *testing.T) {
+	s, _, _, presence := newTestActionService(t)
+	presence.onlineDevices.Store("dev-1", true)
+
+	var ids []string
+	for _, command := range []string{"on", "off", "reboot"} {
+		record, _ := s.SendAction("dev-1", command, nil, "user-1", 0)
+		ids = append(ids, record.ActionID)
+	}
+
+	records, total, err := s.GetHistory("dev-1", 2, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if total != 3 || len(records) != 2 || records[0].ActionID != ids[2] || records[1].ActionID != ids[1] {
+		t.Fatalf("first page %v (total %d)", records, total)
+	}
+	records, _, _ = s.GetHistory("dev-1", 2, 2)
+	if len(records) != 1 || records[0].ActionID != ids[0] {
+		t.Fatalf("second page %v", records)
+	}
+}
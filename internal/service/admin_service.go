@@ -650,6 +650,11 @@ func (s *AdminService) BootstrapAdmin(username string) error {
 	})
 }
 
+// LogAction records an admin operation that is implemented outside AdminService.
+func (s *AdminService) LogAction(adminUUID, action, targetType, targetUUID, detail, ip string) {
+	s.logAction(adminUUID, action, targetType, targetUUID, detail, ip)
+}
+
 // ==================== Internal Helpers ====================
 
 func (s *AdminService) logAction(adminUUID, action, targetType, targetUUID, detail, ip string) {
@@ -0,0 +1,439 @@
+package service
+
+import (
+	"OMEGA3-IOT/internal/eventbus"
+	"OMEGA3-IOT/internal/logger"
+	"OMEGA3-IOT/internal/model"
+	"OMEGA3-IOT/internal/repository"
+	"OMEGA3-IOT/internal/spec"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+var (
+	ErrAlertRuleNotFound = errors.New("alert rule not found")
+	ErrInvalidAlertRule  = errors.New("invalid alert rule")
+	ErrAlertRuleAccess   = errors.New("alert rule scope access denied")
+)
+
+// AlertRuleInput holds the user-editable fields of an alert rule.
+type AlertRuleInput struct {
+	Name        string  `json:"name" binding:"required,max=128"`
+	ScopeType   string  `json:"scope_type" binding:"required"`
+	ScopeValue  string  `json:"scope_value" binding:"required"`
+	Property    string  `json:"property" binding:"required"`
+	Operator    string  `json:"operator" binding:"required"`
+	Threshold   float64 `json:"threshold"`
+	Hysteresis  float64 `json:"hysteresis"`
+	DurationSec int64   `json:"duration_sec"`
+	DebounceSec int64   `json:"debounce_sec"`
+	Severity    string  `json:"severity"`
+}
+
+// alertNotificationTTL is how long a claimed notification keeps other processes from announcing it.
+const alertNotificationTTL = time.Hour
+
+// AlertRuleService manages user-defined alert rules and evaluates them on every property update.
+// Evaluation state (pending duration, firing, debounce) is kept in memory per rule and device,
+// so after a restart a condition has to hold for the full duration again before it fires.
+//
+// Every server process receives every property update and keeps the complete state; updates
+// are evaluated at their publish time so the processes agree, and each transition is claimed
+// in Redis so that only one of them announces it.
+type AlertRuleService struct {
+	ruleRepo           repository.AlertRuleRepository
+	instanceRepo       repository.InstanceRepository
+	folderRepo         repository.DeviceFolderRepository
+	notificationRepo   repository.AlertNotificationRepository
+	deviceShareService *DeviceShareService
+	eventBus           *eventbus.EventBus
+
+	mu     sync.Mutex
+	states map[string]*model.AlertState // key: rule_uuid + "|" + instance_uuid
+}
+
+func NewAlertRuleService(
+	ruleRepo repository.AlertRuleRepository,
+	instanceRepo repository.InstanceRepository,
+	folderRepo repository.DeviceFolderRepository,
+	notificationRepo repository.AlertNotificationRepository,
+	deviceShareService *DeviceShareService,
+	eventBus *eventbus.EventBus,
+) *AlertRuleService {
+	return &AlertRuleService{
+		ruleRepo:           ruleRepo,
+		instanceRepo:       instanceRepo,
+		folderRepo:         folderRepo,
+		notificationRepo:   notificationRepo,
+		deviceShareService: deviceShareService,
+		eventBus:           eventBus,
+		states:             make(map[string]*model.AlertState),
+	}
+}
+
+// Start subscribes to property updates.
+func (s *AlertRuleService) Start() {
+	eventbus.SubscribeTyped(s.eventBus, eventbus.EventType(logger.LogEventDevicePropertyUpdate), s.handlePropertyUpdate, eventbus.Broadcast())
+	log.Println("[AlertRuleService] Started")
+}
+
+// CreateRule validates and stores a new rule owned by ownerUUID.
+func (s *AlertRuleService) CreateRule(ownerUUID string, input AlertRuleInput) (*model.AlertRule, error) {
+	rule := model.NewAlertRule(ownerUUID, input.Name, input.ScopeType, input.ScopeValue, input.Property, input.Operator, input.Threshold)
+	applyAlertRuleInput(rule, input)
+	if err := s.validateRule(rule); err != nil {
+		return nil, err
+	}
+	if err := s.ruleRepo.Create(rule); err != nil {
+		return nil, fmt.Errorf("failed to create alert rule: %w", err)
+	}
+	log.Printf("[AlertRuleService] Rule %s created by %s (%s %s %s %s %g)", rule.RuleUUID, ownerUUID, rule.ScopeType, rule.ScopeValue, rule.Property, rule.Operator, rule.Threshold)
+	return rule, nil
+}
+
+// ListRules returns the rules owned by ownerUUID, newest first.
+func (s *AlertRuleService) ListRules(ownerUUID string, limit, offset int) ([]model.AlertRule, int64, error) {
+	return s.ruleRepo.FindByOwner(ownerUUID, limit, offset)
+}
+
+// GetRule returns a rule if it is owned by ownerUUID.
+func (s *AlertRuleService) GetRule(ownerUUID, ruleUUID string) (*model.AlertRule, error) {
+	rule, err := s.ruleRepo.FindByRuleUUID(ruleUUID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrAlertRuleNotFound
+		}
+		return nil, fmt.Errorf("failed to find alert rule: %w", err)
+	}
+	if rule.OwnerUUID != ownerUUID {
+		return nil, ErrAlertRuleNotFound
+	}
+	return rule, nil
+}
+
+// UpdateRule replaces the condition of a rule. Alerts firing under the old condition are resolved.
+func (s *AlertRuleService) UpdateRule(ownerUUID, ruleUUID string, input AlertRuleInput) (*model.AlertRule, error) {
+	rule, err := s.GetRule(ownerUUID, ruleUUID)
+	if err != nil {
+		return nil, err
+	}
+	rule.Name = input.Name
+	rule.ScopeType = input.ScopeType
+	rule.ScopeValue = input.ScopeValue
+	rule.Property = input.Property
+	rule.Operator = input.Operator
+	rule.Threshold = input.Threshold
+	applyAlertRuleInput(rule, input)
+	if err := s.validateRule(rule); err != nil {
+		return nil, err
+	}
+	rule.UpdatedAt = time.Now().Unix()
+	if err := s.ruleRepo.Update(rule); err != nil {
+		return nil, fmt.Errorf("failed to update alert rule: %w", err)
+	}
+	s.resetRuleState(rule, "rule updated")
+	return rule, nil
+}
+
+// DeleteRule removes a rule and resolves its firing alerts.
+func (s *AlertRuleService) DeleteRule(ownerUUID, ruleUUID string) error {
+	rule, err := s.GetRule(ownerUUID, ruleUUID)
+	if err != nil {
+		return err
+	}
+	if err := s.ruleRepo.Delete(ruleUUID); err != nil {
+		return fmt.Errorf("failed to delete alert rule: %w", err)
+	}
+	s.resetRuleState(rule, "rule deleted")
+	log.Printf("[AlertRuleService] Rule %s deleted by %s", ruleUUID, ownerUUID)
+	return nil
+}
+
+// SetEnabled enables or disables a rule. Disabling resolves its firing alerts.
+func (s *AlertRuleService) SetEnabled(ownerUUID, ruleUUID string, enabled bool) (*model.AlertRule, error) {
+	rule, err := s.GetRule(ownerUUID, ruleUUID)
+	if err != nil {
+		return nil, err
+	}
+	if rule.Enabled == enabled {
+		return rule, nil
+	}
+	rule.Enabled = enabled
+	rule.UpdatedAt = time.Now().Unix()
+	if err := s.ruleRepo.Update(rule); err != nil {
+		return nil, fmt.Errorf("failed to update alert rule: %w", err)
+	}
+	if !enabled {
+		s.resetRuleState(rule, "rule disabled")
+	}
+	return rule, nil
+}
+
+// Mute suppresses notifications of a rule for durationSec seconds, or until unmuted if durationSec is 0.
+// The rule keeps being evaluated while muted; alerts that fire during the mute are never announced.
+func (s *AlertRuleService) Mute(ownerUUID, ruleUUID string, durationSec int64) (*model.AlertRule, error) {
+	if durationSec < 0 {
+		return nil, fmt.Errorf("%w: duration_sec must not be negative", ErrInvalidAlertRule)
+	}
+	rule, err := s.GetRule(ownerUUID, ruleUUID)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now().Unix()
+	rule.Muted = true
+	rule.MutedUntil = 0
+	if durationSec > 0 {
+		rule.MutedUntil = now + durationSec
+	}
+	rule.UpdatedAt = now
+	if err := s.ruleRepo.Update(rule); err != nil {
+		return nil, fmt.Errorf("failed to update alert rule: %w", err)
+	}
+	return rule, nil
+}
+
+// Unmute lifts a mute.
+func (s *AlertRuleService) Unmute(ownerUUID, ruleUUID string) (*model.AlertRule, error) {
+	rule, err := s.GetRule(ownerUUID, ruleUUID)
+	if err != nil {
+		return nil, err
+	}
+	rule.Muted = false
+	rule.MutedUntil = 0
+	rule.UpdatedAt = time.Now().Unix()
+	if err := s.ruleRepo.Update(rule); err != nil {
+		return nil, fmt.Errorf("failed to update alert rule: %w", err)
+	}
+	return rule, nil
+}
+
+func applyAlertRuleInput(rule *model.AlertRule, input AlertRuleInput) {
+	rule.Hysteresis = input.Hysteresis
+	rule.DurationSec = input.DurationSec
+	rule.DebounceSec = input.DebounceSec
+	if input.Severity != "" {
+		rule.Severity = input.Severity
+	}
+}
+
+// validateRule checks the rule fields and that the owner may watch its scope.
+func (s *AlertRuleService) validateRule(rule *model.AlertRule) error {
+	if err := rule.Validate(); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidAlertRule, err)
+	}
+
+	switch rule.ScopeType {
+	case model.AlertScopeDevice:
+		instance, err := s.instanceRepo.FindByUUID(rule.ScopeValue)
+		if err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return ErrDeviceNotFound
+			}
+			return fmt.Errorf("failed to find device: %w", err)
+		}
+		allowed, err := s.deviceShareService.CheckDeviceAccess(instance.InstanceUUID, rule.OwnerUUID, repository.PermissionRead)
+		if err != nil {
+			return fmt.Errorf("failed to check device access: %w", err)
+		}
+		if !allowed {
+			return ErrAlertRuleAccess
+		}
+		return checkNumericProperty(instance.Type, rule.Property)
+	case model.AlertScopeFolder:
+		folder, err := s.folderRepo.GetFolderByUUID(rule.ScopeValue)
+		if err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return fmt.Errorf("%w: folder not found", ErrInvalidAlertRule)
+			}
+			return fmt.Errorf("failed to find folder: %w", err)
+		}
+		if folder.OwnerUUID != rule.OwnerUUID {
+			return ErrAlertRuleAccess
+		}
+		return nil
+	default:
+		return checkNumericProperty(rule.ScopeValue, rule.Property)
+	}
+}
+
+func checkNumericProperty(typeName, property string) error {
+	typeDef, ok := model.GlobalDeviceTypeManager.GetByName(typeName)
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrUnknownDeviceType, typeName)
+	}
+	meta, exists := typeDef.Properties[property]
+	if !exists {
+		return fmt.Errorf("%w: unknown property '%s' for type %s", ErrInvalidAlertRule, property, typeName)
+	}
+	if meta.Format != "int" && meta.Format != "float" {
+		return fmt.Errorf("%w: property '%s' is not numeric", ErrInvalidAlertRule, property)
+	}
+	return nil
+}
+
+// handlePropertyUpdate evaluates every enabled rule that covers the device and one of the updated properties.
+func (s *AlertRuleService) handlePropertyUpdate(ctx context.Context, event logger.DeviceLogEvent) error {
+	props, _ := event.Metadata["properties"].(map[string]interface{})
+	if len(props) == 0 {
+		return nil
+	}
+	instance, err := s.instanceRepo.FindByUUID(event.DeviceUUID)
+	if err != nil {
+		return nil
+	}
+
+	keys := make([]string, 0, len(props))
+	for key := range props {
+		keys = append(keys, key)
+	}
+	rules, err := s.ruleRepo.FindEnabledForDevice(instance.InstanceUUID, instance.Type, instance.OwnerUUID, keys)
+	if err != nil {
+		log.Printf("[AlertRuleService] Failed to load rules for %s: %v", instance.InstanceUUID, err)
+		return nil
+	}
+
+	now := event.Timestamp
+	if now <= 0 {
+		now = time.Now().Unix()
+	}
+	access := make(map[string]bool) // rule owner → may still read the device
+	for i := range rules {
+		rule := &rules[i]
+		value, err := spec.ConvertToFloat(props[rule.Property])
+		if err != nil {
+			continue
+		}
+		if rule.OwnerUUID != instance.OwnerUUID {
+			allowed, checked := access[rule.OwnerUUID]
+			if !checked {
+				allowed, _ = s.deviceShareService.CheckDeviceAccess(instance.InstanceUUID, rule.OwnerUUID, repository.PermissionRead)
+				access[rule.OwnerUUID] = allowed
+			}
+			if !allowed {
+				continue
+			}
+		}
+		s.evaluate(rule, instance.InstanceUUID, value, now)
+	}
+	return nil
+}
+
+func (s *AlertRuleService) evaluate(rule *model.AlertRule, instanceUUID string, value float64, now int64) {
+	key := rule.RuleUUID + "|" + instanceUUID
+
+	s.mu.Lock()
+	state, ok := s.states[key]
+	if !ok {
+		state = &model.AlertState{}
+		s.states[key] = state
+	}
+	transition := rule.Evaluate(state, value, now)
+	notify := false
+	firedAt := state.FiredAt
+	switch transition {
+	case model.AlertTransitionFire:
+		state.Notified = !rule.IsMuted(now)
+		notify = state.Notified
+	case model.AlertTransitionResolve:
+		notify = state.Notified
+		state.Notified = false
+	}
+	// Forget idle states once the debounce window has passed
+	if !state.Firing && state.PendingSince == 0 && now-state.LastFiredAt >= rule.DebounceSec {
+		delete(s.states, key)
+	}
+	s.mu.Unlock()
+
+	if !notify {
+		return
+	}
+	if transition == model.AlertTransitionFire {
+		s.emitAlert(rule, instanceUUID, logger.LogEventAlertFired, value, firedAt, 0, "")
+	} else {
+		s.emitAlert(rule, instanceUUID, logger.LogEventAlertResolved, value, firedAt, now, "")
+	}
+}
+
+// claimNotification reports whether this process announces a transition. A firing and its
+// resolution are identified by the time the alert fired, which all processes agree on. If Redis
+// is unavailable the alert is announced, possibly more than once.
+func (s *AlertRuleService) claimNotification(rule *model.AlertRule, instanceUUID string, eventType logger.LogEventType, firedAt int64) bool {
+	key := fmt.Sprintf("%s|%s|%s|%d", rule.RuleUUID, instanceUUID, eventType, firedAt)
+	claimed, err := s.notificationRepo.Claim(context.Background(), key, alertNotificationTTL)
+	if err != nil {
+		log.Printf("[AlertRuleService] Failed to claim notification %s: %v", key, err)
+		return true
+	}
+	return claimed
+}
+
+// resetRuleState drops the evaluation state of a rule and resolves its announced alerts.
+func (s *AlertRuleService) resetRuleState(rule *model.AlertRule, reason string) {
+	prefix := rule.RuleUUID + "|"
+	type firing struct {
+		instanceUUID string
+		firedAt      int64
+	}
+	var resolved []firing
+
+	s.mu.Lock()
+	for key, state := range s.states {
+		if !strings.HasPrefix(key, prefix) {
+			continue
+		}
+		if state.Firing && state.Notified {
+			resolved = append(resolved, firing{instanceUUID: strings.TrimPrefix(key, prefix), firedAt: state.FiredAt})
+		}
+		delete(s.states, key)
+	}
+	s.mu.Unlock()
+
+	now := time.Now().Unix()
+	for _, f := range resolved {
+		s.emitAlert(rule, f.instanceUUID, logger.LogEventAlertResolved, 0, f.firedAt, now, reason)
+	}
+}
+
+func (s *AlertRuleService) emitAlert(rule *model.AlertRule, instanceUUID string, eventType logger.LogEventType, value float64, firedAt, resolvedAt int64, reason string) {
+	if !s.claimNotification(rule, instanceUUID, eventType, firedAt) {
+		return
+	}
+	level := logger.LogLevelInfo
+	message := fmt.Sprintf("Alert '%s' resolved: %s", rule.Name, rule.Property)
+	if eventType == logger.LogEventAlertFired {
+		message = fmt.Sprintf("Alert '%s' fired: %s %s %g (value %g)", rule.Name, rule.Property, rule.Operator, rule.Threshold, value)
+		if rule.Severity != model.AlertSeverityInfo {
+			level = logger.LogLevelWarning
+		}
+	}
+	if reason != "" {
+		message += " (" + reason + ")"
+	}
+
+	event := logger.NewDeviceLogEvent(instanceUUID, level, message, eventType)
+	event.Metadata["rule_uuid"] = rule.RuleUUID
+	event.Metadata["rule_name"] = rule.Name
+	event.Metadata["owner_uuid"] = rule.OwnerUUID
+	event.Metadata["property"] = rule.Property
+	event.Metadata["operator"] = rule.Operator
+	event.Metadata["threshold"] = rule.Threshold
+	event.Metadata["severity"] = rule.Severity
+	event.Metadata["fired_at"] = firedAt
+	if reason == "" {
+		event.Metadata["value"] = value
+	}
+	if resolvedAt > 0 {
+		event.Metadata["resolved_at"] = resolvedAt
+	}
+	if reason != "" {
+		event.Metadata["reason"] = reason
+	}
+	s.eventBus.Publish(context.Background(), event)
+}
@@ -0,0 +1,212 @@
+package service
+
+import (
+	"OMEGA3-IOT/internal/eventbus"
+	"OMEGA3-IOT/internal/logger"
+	"OMEGA3-IOT/internal/model"
+	"OMEGA3-IOT/internal/pki"
+	"OMEGA3-IOT/internal/repository"
+	"context"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"gorm.io/gorm"
+	"log"
+	"time"
+)
+
+var (
+	ErrCertificateNotFound = errors.New("certificate not found")
+	ErrCertificateRevoked  = errors.New("certificate revoked")
+)
+
+// crlValidity is how long a published CRL is valid. It is regenerated on every request, so this
+// only bounds how long relying parties may cache it.
+const crlValidity = 24 * time.Hour
+
+// IssuedDeviceCertificate is returned once when a certificate is issued. PrivateKeyPEM is only set
+// when the server generated the key pair, it is not stored.
+type IssuedDeviceCertificate struct {
+	model.DeviceCertificate
+	PrivateKeyPEM    string `json:"private_key_pem,omitempty"`
+	CACertificatePEM string `json:"ca_certificate_pem"`
+}
+
+// DeviceCertificateService issues device client certificates from the built-in CA and keeps the
+// revocation list in MySQL.
+type DeviceCertificateService struct {
+	certRepo repository.DeviceCertificateRepository
+	ca       *pki.CA
+	validity time.Duration
+	eventBus *eventbus.EventBus
+}
+
+func NewDeviceCertificateService(certRepo repository.DeviceCertificateRepository, ca *pki.CA, validityDays int, eventBus *eventbus.EventBus) *DeviceCertificateService {
+	if validityDays <= 0 {
+		validityDays = 365
+	}
+	return &DeviceCertificateService{
+		certRepo: certRepo,
+		ca:       ca,
+		validity: time.Duration(validityDays) * 24 * time.Hour,
+		eventBus: eventBus,
+	}
+}
+
+// CACertificatePEM returns the CA certificate devices and brokers trust.
+func (s *DeviceCertificateService) CACertificatePEM() []byte {
+	return s.ca.CertificatePEM()
+}
+
+// CertPool returns the CA for verifying device client certificates.
+func (s *DeviceCertificateService) CertPool() *x509.CertPool {
+	return s.ca.CertPool()
+}
+
+// IssuedByCA reports whether cert was issued by the built-in CA rather than another CA trusted by
+// the broker.
+func (s *DeviceCertificateService) IssuedByCA(cert *x509.Certificate) bool {
+	return s.ca.Issued(cert)
+}
+
+// Issue issues a certificate for a device. Without csrPEM a key pair is generated and returned.
+// With revokePrevious the other valid certificates of the device are revoked as superseded.
+func (s *DeviceCertificateService) Issue(deviceUUID string, csrPEM string, issuedBy string, revokePrevious bool) (*IssuedDeviceCertificate, error) {
+	issued, err := s.ca.IssueDeviceCertificate(deviceUUID, []byte(csrPEM), s.validity)
+	if err != nil {
+		return nil, err
+	}
+	fingerprint := sha256.Sum256(issued.Certificate.Raw)
+	record := model.DeviceCertificate{
+		DeviceUUID:     deviceUUID,
+		SerialNumber:   pki.SerialNumber(issued.Certificate),
+		Fingerprint:    hex.EncodeToString(fingerprint[:]),
+		CertificatePEM: string(issued.CertificatePEM),
+		IssuedBy:       issuedBy,
+		NotBefore:      issued.Certificate.NotBefore.Unix(),
+		NotAfter:       issued.Certificate.NotAfter.Unix(),
+		CreatedAt:      time.Now().Unix(),
+	}
+	if err := s.certRepo.Create(&record); err != nil {
+		return nil, fmt.Errorf("failed to save certificate: %w", err)
+	}
+	log.Printf("[DeviceCertificateService] Issued certificate %s to device %s", record.SerialNumber, deviceUUID)
+
+	if revokePrevious {
+		serials, err := s.certRepo.RevokeByDevice(deviceUUID, record.SerialNumber, model.CertificateSuperseded, time.Now().Unix())
+		if err != nil {
+			return nil, fmt.Errorf("certificate issued but previous certificates not revoked: %w", err)
+		}
+		s.publishRevoked(deviceUUID, serials, model.CertificateSuperseded)
+	}
+
+	return &IssuedDeviceCertificate{
+		DeviceCertificate: record,
+		PrivateKeyPEM:     string(issued.PrivateKeyPEM),
+		CACertificatePEM:  string(s.ca.CertificatePEM()),
+	}, nil
+}
+
+// Rotate issues a new certificate to a device authenticated by the certificate with serial, which
+// is then revoked. Connections using the old certificate are closed, the device reconnects with
+// the new one.
+func (s *DeviceCertificateService) Rotate(deviceUUID string, serial string, csrPEM string) (*IssuedDeviceCertificate, error) {
+	issued, err := s.Issue(deviceUUID, csrPEM, "", false)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.Revoke(deviceUUID, serial, model.CertificateRevokedByDevice); err != nil && !errors.Is(err, ErrCertificateRevoked) {
+		return nil, fmt.Errorf("certificate issued but the previous one not revoked: %w", err)
+	}
+	return issued, nil
+}
+
+// List returns the certificates issued to a device, newest first.
+func (s *DeviceCertificateService) List(deviceUUID string) ([]model.DeviceCertificate, error) {
+	return s.certRepo.ListByDevice(deviceUUID)
+}
+
+// Revoke revokes a certificate of a device.
+func (s *DeviceCertificateService) Revoke(deviceUUID string, serial string, reason string) error {
+	cert, err := s.certRepo.FindBySerial(serial)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrCertificateNotFound
+		}
+		return err
+	}
+	if cert.DeviceUUID != deviceUUID {
+		return ErrCertificateNotFound
+	}
+	if cert.Revoked() {
+		return ErrCertificateRevoked
+	}
+	if err := s.certRepo.Revoke(serial, reason, time.Now().Unix()); err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrCertificateRevoked
+		}
+		return err
+	}
+	s.publishRevoked(deviceUUID, []string{serial}, reason)
+	return nil
+}
+
+// RevokeAll revokes every valid certificate of a device.
+func (s *DeviceCertificateService) RevokeAll(deviceUUID string, reason string) error {
+	serials, err := s.certRepo.RevokeByDevice(deviceUUID, "", reason, time.Now().Unix())
+	if err != nil {
+		return err
+	}
+	s.publishRevoked(deviceUUID, serials, reason)
+	return nil
+}
+
+// publishRevoked disconnects the device from the embedded broker, so connections using a revoked
+// certificate end; the device reconnects with a certificate that is still valid.
+func (s *DeviceCertificateService) publishRevoked(deviceUUID string, serials []string, reason string) {
+	if len(serials) == 0 {
+		return
+	}
+
+	event := logger.NewDeviceLogEvent(deviceUUID, logger.LogLevelWarning, fmt.Sprintf("Certificates revoked: %d (%s)", len(serials), reason), logger.LogEventDeviceCredentialsRevoked)
+	event.Metadata["serial_numbers"] = serials
+	event.Metadata["reason"] = reason
+	s.eventBus.Publish(context.Background(), event)
+}
+
+// VerifyCertificate checks a client certificate that verified against the CA: it must have been
+// issued by this server to the device it names and not be revoked. It returns the device. It is
+// called once per connection, so the revocation list is read from MySQL rather than cached.
+func (s *DeviceCertificateService) VerifyCertificate(cert *x509.Certificate) (string, error) {
+	record, err := s.certRepo.FindBySerial(pki.SerialNumber(cert))
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return "", ErrCertificateNotFound
+		}
+		return "", err
+	}
+	if record.Revoked() {
+		return "", ErrCertificateRevoked
+	}
+	if record.DeviceUUID != pki.DeviceUUID(cert) {
+		return "", ErrCertificateNotFound
+	}
+	return record.DeviceUUID, nil
+}
+
+// CRL returns the current revocation list, PEM encoded. Expired certificates are left out.
+func (s *DeviceCertificateService) CRL() ([]byte, error) {
+	now := time.Now()
+	certs, err := s.certRepo.ListRevoked(now.Unix())
+	if err != nil {
+		return nil, err
+	}
+	revoked := make([]pki.RevokedCertificate, 0, len(certs))
+	for _, cert := range certs {
+		revoked = append(revoked, pki.RevokedCertificate{SerialNumber: cert.SerialNumber, RevokedAt: time.Unix(cert.RevokedAt, 0)})
+	}
+	// The CRL number must increase; lists are generated on demand, so the time serves
+	return s.ca.CreateCRL(revoked, now.UnixNano(), crlValidity)
+}
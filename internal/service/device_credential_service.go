@@ -0,0 +1,313 @@
+package service
+
+import (
+	"OMEGA3-IOT/internal/eventbus"
+	"OMEGA3-IOT/internal/logger"
+	"OMEGA3-IOT/internal/model"
+	"OMEGA3-IOT/internal/repository"
+	"OMEGA3-IOT/internal/utils"
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// credentialSweepInterval is how often ended grace periods are cleaned up.
+const credentialSweepInterval = time.Minute
+
+var (
+	ErrCredentialDeviceNotFound  = errors.New("device not found")
+	ErrCredentialRotationActive  = errors.New("a credential rotation is still in its grace period")
+	ErrCredentialDeviceReset     = errors.New("device credentials were reset, re-provision the device first")
+	ErrCredentialNoSecureChannel = errors.New("the MQTT broker does not authenticate devices, credentials cannot be delivered")
+	ErrInvalidResetCode          = errors.New("invalid or expired reset code")
+)
+
+// CredentialRotation is the outcome of a verify code rotation. The new code is only delivered to
+// the device.
+type CredentialRotation struct {
+	DeviceUUID     string `json:"device_uuid"`
+	GraceExpiresAt int64  `json:"grace_expires_at"` // the previous verify code is accepted until then
+}
+
+// CredentialReset is the outcome of a hard reset. The reset code is shown once, to be passed to
+// the device while re-provisioning it (e.g. over BLE with the network settings).
+type CredentialReset struct {
+	DeviceUUID string `json:"device_uuid"`
+	ResetCode  string `json:"reset_code"`
+	ExpiresAt  int64  `json:"expires_at"`
+}
+
+// ProvisionedCredentials are the credentials a reset device receives when it is re-provisioned.
+type ProvisionedCredentials struct {
+	DeviceUUID  string                   `json:"device_uuid"`
+	VerifyCode  string                   `json:"verify_code"`
+	Certificate *IssuedDeviceCertificate `json:"certificate,omitempty"`
+}
+
+// DeviceCredentialService rotates and resets the verify codes of bound devices.
+//
+// A rotation replaces the verify code and delivers the new one on the device's credentials topic,
+// which only the authenticated device may subscribe to. The previous code keeps working during a
+// grace period, after which connections still using it are closed. A hard reset invalidates all
+// credentials of the device, including its certificates; the device is re-provisioned with a
+// one-time reset code and keeps its UUID, data and shares.
+type DeviceCredentialService struct {
+	instanceRepo repository.InstanceRepository
+	mqttService  *MQTTService
+	certService  *DeviceCertificateService // nil when the built-in CA is disabled
+	eventBus     *eventbus.EventBus
+	// secureChannel is set when the broker confines devices to their own topics, so the
+	// credentials topic can only be read by the device itself
+	secureChannel bool
+	gracePeriod   time.Duration
+	resetCodeTTL  time.Duration
+
+	mu     sync.Mutex // serialises credential changes
+	stopCh chan struct{}
+	wg     sync.WaitGroup
+}
+
+func NewDeviceCredentialService(
+	instanceRepo repository.InstanceRepository,
+	mqttService *MQTTService,
+	certService *DeviceCertificateService,
+	eventBus *eventbus.EventBus,
+	secureChannel bool,
+	gracePeriodHours int,
+	resetCodeTTLHours int,
+) *DeviceCredentialService {
+	if gracePeriodHours <= 0 {
+		gracePeriodHours = 24
+	}
+	if resetCodeTTLHours <= 0 {
+		resetCodeTTLHours = 24
+	}
+	return &DeviceCredentialService{
+		instanceRepo:  instanceRepo,
+		mqttService:   mqttService,
+		certService:   certService,
+		eventBus:      eventBus,
+		secureChannel: secureChannel,
+		gracePeriod:   time.Duration(gracePeriodHours) * time.Hour,
+		resetCodeTTL:  time.Duration(resetCodeTTLHours) * time.Hour,
+		stopCh:        make(chan struct{}),
+	}
+}
+
+// Start launches the loop ending grace periods.
+func (s *DeviceCredentialService) Start() {
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		ticker := time.NewTicker(credentialSweepInterval)
+		defer ticker.Stop()
+		for {
+			s.endGracePeriods()
+			select {
+			case <-ticker.C:
+			case <-s.stopCh:
+				return
+			}
+		}
+	}()
+	log.Printf("[DeviceCredentialService] Started (grace period: %v)", s.gracePeriod)
+}
+
+// Stop stops the grace period loop.
+func (s *DeviceCredentialService) Stop() {
+	close(s.stopCh)
+	s.wg.Wait()
+	log.Println("[DeviceCredentialService] Stopped")
+}
+
+// findDevice loads a device; with ownerUUID set the device must belong to that user, admins
+// pass an empty ownerUUID.
+func (s *DeviceCredentialService) findDevice(deviceUUID, ownerUUID string) (*model.Instance, error) {
+	instance, err := s.instanceRepo.FindByUUID(deviceUUID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrCredentialDeviceNotFound
+		}
+		return nil, err
+	}
+	if ownerUUID != "" && instance.OwnerUUID != ownerUUID {
+		return nil, ErrCredentialDeviceNotFound
+	}
+	return instance, nil
+}
+
+// Rotate replaces the verify code of a device and delivers the new one over MQTT. actor is the
+// user or admin UUID recorded in the device log.
+func (s *DeviceCredentialService) Rotate(deviceUUID, ownerUUID, actor string) (*CredentialRotation, error) {
+	if !s.secureChannel {
+		return nil, ErrCredentialNoSecureChannel
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	instance, err := s.findDevice(deviceUUID, ownerUUID)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	if instance.VerifyHash == "" {
+		return nil, ErrCredentialDeviceReset
+	}
+	if instance.PreviousVerifyHash != "" && now.Unix() < instance.PreviousVerifyExpiresAt {
+		// Rotating again would drop the code the device may still be using
+		return nil, ErrCredentialRotationActive
+	}
+
+	verifyCode, err := utils.GenerateVerifyCode()
+	if err != nil {
+		return nil, err
+	}
+	graceExpiresAt := now.Add(s.gracePeriod).Unix()
+	if err := s.instanceRepo.UpdateFields(deviceUUID, map[string]interface{}{
+		"verify_hash":                utils.HashVerifyCode(verifyCode),
+		"previous_verify_hash":       instance.VerifyHash,
+		"previous_verify_expires_at": graceExpiresAt,
+	}); err != nil {
+		return nil, fmt.Errorf("failed to store credentials: %w", err)
+	}
+
+	// The device keeps its previous code if delivery fails and the rotation can be retried once
+	// the grace period ends
+	if err := s.mqttService.PublishCredentials(deviceUUID, &model.DeviceCredentialsMessage{VerifyCode: verifyCode, PreviousExpiresAt: graceExpiresAt}); err != nil {
+		log.Printf("[DeviceCredentialService] Failed to deliver credentials to device %s: %v", deviceUUID, err)
+	}
+
+	event := logger.NewDeviceLogEvent(deviceUUID, logger.LogLevelInfo, "Verify code rotated", logger.LogEventDeviceCredentialsRotated)
+	event.Metadata["actor"] = actor
+	event.Metadata["grace_expires_at"] = graceExpiresAt
+	s.eventBus.Publish(context.Background(), event)
+	log.Printf("[DeviceCredentialService] Rotated verify code of device %s, previous code valid until %d", deviceUUID, graceExpiresAt)
+
+	return &CredentialRotation{DeviceUUID: deviceUUID, GraceExpiresAt: graceExpiresAt}, nil
+}
+
+// Reset invalidates every credential of a device and closes its connections. The device stays
+// bound but cannot connect until it is re-provisioned with the returned reset code.
+func (s *DeviceCredentialService) Reset(deviceUUID, ownerUUID, actor string) (*CredentialReset, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, err := s.findDevice(deviceUUID, ownerUUID); err != nil {
+		return nil, err
+	}
+	resetCode, err := utils.GenerateVerifyCode()
+	if err != nil {
+		return nil, err
+	}
+	expiresAt := time.Now().Add(s.resetCodeTTL).Unix()
+	if err := s.instanceRepo.UpdateFields(deviceUUID, map[string]interface{}{
+		"verify_hash":                "",
+		"previous_verify_hash":       "",
+		"previous_verify_expires_at": 0,
+		"reset_code_hash":            utils.HashVerifyCode(resetCode),
+		"reset_code_expires_at":      expiresAt,
+		"status":                     model.InstanceStatusReset,
+	}); err != nil {
+		return nil, fmt.Errorf("failed to reset credentials: %w", err)
+	}
+
+	if s.certService != nil {
+		if err := s.certService.RevokeAll(deviceUUID, model.CertificateDeviceReset); err != nil {
+			log.Printf("[DeviceCredentialService] Failed to revoke certificates of device %s: %v", deviceUUID, err)
+		}
+	}
+	if err := s.mqttService.PublishCredentials(deviceUUID, nil); err != nil {
+		log.Printf("[DeviceCredentialService] Failed to clear credentials of device %s: %v", deviceUUID, err)
+	}
+	s.publishRevoked(deviceUUID, "reset", actor)
+	log.Printf("[DeviceCredentialService] Reset credentials of device %s", deviceUUID)
+
+	return &CredentialReset{DeviceUUID: deviceUUID, ResetCode: resetCode, ExpiresAt: expiresAt}, nil
+}
+
+// Reprovision gives a reset device new credentials in exchange for its reset code. Without a
+// CSR the key pair of the device certificate is generated.
+func (s *DeviceCredentialService) Reprovision(deviceUUID, resetCode, csrPEM string) (*ProvisionedCredentials, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	instance, err := s.findDevice(deviceUUID, "")
+	if err != nil {
+		if errors.Is(err, ErrCredentialDeviceNotFound) {
+			return nil, ErrInvalidResetCode
+		}
+		return nil, err
+	}
+	if instance.ResetCodeHash == "" || time.Now().Unix() >= instance.ResetCodeExpiresAt ||
+		subtle.ConstantTimeCompare([]byte(instance.ResetCodeHash), []byte(utils.HashVerifyCode(resetCode))) != 1 {
+		return nil, ErrInvalidResetCode
+	}
+
+	verifyCode, err := utils.GenerateVerifyCode()
+	if err != nil {
+		return nil, err
+	}
+	provisioned := &ProvisionedCredentials{DeviceUUID: deviceUUID, VerifyCode: verifyCode}
+	if s.certService != nil {
+		// Issued first, so an invalid CSR leaves the reset code usable
+		if provisioned.Certificate, err = s.certService.Issue(deviceUUID, csrPEM, "", false); err != nil {
+			return nil, err
+		}
+	}
+	if err := s.instanceRepo.UpdateFields(deviceUUID, map[string]interface{}{
+		"verify_hash":           utils.HashVerifyCode(verifyCode),
+		"reset_code_hash":       "",
+		"reset_code_expires_at": 0,
+		"status":                model.InstanceStatusActive,
+	}); err != nil {
+		return nil, fmt.Errorf("failed to store credentials: %w", err)
+	}
+
+	event := logger.NewDeviceLogEvent(deviceUUID, logger.LogLevelInfo, "Device re-provisioned", logger.LogEventDeviceCredentialsRotated)
+	event.Metadata["reprovisioned"] = true
+	s.eventBus.Publish(context.Background(), event)
+	log.Printf("[DeviceCredentialService] Re-provisioned device %s", deviceUUID)
+
+	return provisioned, nil
+}
+
+// endGracePeriods drops the previous verify codes whose grace period ended, removes the
+// delivered credentials from the broker and closes connections that may still use the old code.
+func (s *DeviceCredentialService) endGracePeriods() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	instances, err := s.instanceRepo.FindCredentialGraceExpired(time.Now().Unix())
+	if err != nil {
+		log.Printf("[DeviceCredentialService] Failed to load ended grace periods: %v", err)
+		return
+	}
+	for _, instance := range instances {
+		if err := s.instanceRepo.UpdateFields(instance.InstanceUUID, map[string]interface{}{
+			"previous_verify_hash":       "",
+			"previous_verify_expires_at": 0,
+		}); err != nil {
+			log.Printf("[DeviceCredentialService] Failed to end grace period of device %s: %v", instance.InstanceUUID, err)
+			continue
+		}
+		if err := s.mqttService.PublishCredentials(instance.InstanceUUID, nil); err != nil {
+			log.Printf("[DeviceCredentialService] Failed to clear credentials of device %s: %v", instance.InstanceUUID, err)
+		}
+		s.publishRevoked(instance.InstanceUUID, "grace_period_ended", "")
+	}
+}
+
+func (s *DeviceCredentialService) publishRevoked(deviceUUID, reason, actor string) {
+	event := logger.NewDeviceLogEvent(deviceUUID, logger.LogLevelWarning, fmt.Sprintf("Verify code revoked (%s)", reason), logger.LogEventDeviceCredentialsRevoked)
+	event.Metadata["reason"] = reason
+	if actor != "" {
+		event.Metadata["actor"] = actor
+	}
+	s.eventBus.Publish(context.Background(), event)
+}
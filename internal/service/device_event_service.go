@@ -0,0 +1,210 @@
+package service
+
+import (
+	"OMEGA3-IOT/internal/model"
+	"OMEGA3-IOT/internal/repository"
+	"OMEGA3-IOT/internal/spec"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+)
+
+var (
+	ErrEventQuarantined  = errors.New("device event quarantined")
+	ErrInvalidEventQuery = errors.New("invalid event query")
+)
+
+var (
+	validEventSeverities  = map[string]bool{model.EventSeverityInfo: true, model.EventSeverityWarning: true, model.EventSeverityCritical: true}
+	validQuarantineReason = map[string]bool{
+		model.EventQuarantineUnknownType:      true,
+		model.EventQuarantineUnknownEvent:     true,
+		model.EventQuarantineInvalidPayload:   true,
+		model.EventQuarantineInvalidTimestamp: true,
+	}
+)
+
+// DeviceEventQuery selects recorded events or quarantined events. StartTime and EndTime are
+// Unix seconds, the range is [StartTime, EndTime) and either may be 0 to leave it open.
+// Severities does not apply to the quarantine, Reason only applies to it.
+type DeviceEventQuery struct {
+	InstanceUUID string
+	EventKeys    []string
+	Severities   []string
+	Reason       string
+	StartTime    int64
+	EndTime      int64
+	Limit        int
+	Offset       int
+}
+
+// DeviceEventService records the events devices report. Events are validated against the
+// EventMeta of the device type and stored with their output params as typed values; events
+// that cannot be recorded are quarantined with the reason, as received.
+type DeviceEventService struct {
+	eventRepo     repository.DeviceEventRepository
+	ingestService *TelemetryIngestService
+}
+
+func NewDeviceEventService(eventRepo repository.DeviceEventRepository, ingestService *TelemetryIngestService) *DeviceEventService {
+	return &DeviceEventService{
+		eventRepo:     eventRepo,
+		ingestService: ingestService,
+	}
+}
+
+// Record validates and stores an event of a device. The event time is its own timestamp,
+// else messageTime, else receivedAt, subject to the telemetry skew window. An event that
+// fails validation is quarantined and ErrEventQuarantined is returned.
+func (s *DeviceEventService) Record(instance *model.Instance, event model.DeviceEvent, messageTime int64, receivedAt time.Time) (*model.DeviceEventRecord, error) {
+	quarantine := func(reason string, cause error) error {
+		payload, _ := json.Marshal(event)
+		entry := model.NewQuarantinedEvent(instance.InstanceUUID, instance.Type, event.EventKey, reason, cause.Error(), string(payload))
+		if err := s.eventRepo.CreateQuarantined(entry); err != nil {
+			log.Printf("[DeviceEventService] Failed to quarantine event '%s' of device %s: %v", event.EventKey, instance.InstanceUUID, err)
+		}
+		return fmt.Errorf("%w (%s): %v", ErrEventQuarantined, reason, cause)
+	}
+
+	typeDef, ok := model.GlobalDeviceTypeManager.GetByName(instance.Type)
+	if !ok {
+		return nil, quarantine(model.EventQuarantineUnknownType, fmt.Errorf("device type '%s' is not registered", instance.Type))
+	}
+	meta, ok := typeDef.Events[event.EventKey]
+	if !ok {
+		return nil, quarantine(model.EventQuarantineUnknownEvent, fmt.Errorf("event '%s' is not defined for device type '%s'", event.EventKey, typeDef.Name))
+	}
+
+	payload := map[string]interface{}{}
+	if event.Content != "" {
+		if err := json.Unmarshal([]byte(event.Content), &payload); err != nil {
+			return nil, quarantine(model.EventQuarantineInvalidPayload, fmt.Errorf("content is not a JSON object: %v", err))
+		}
+	}
+	if err := spec.ValidateEvent(typeDef, event.EventKey, payload); err != nil {
+		return nil, quarantine(model.EventQuarantineInvalidPayload, err)
+	}
+
+	deviceTime := event.Timestamp
+	if deviceTime == 0 {
+		deviceTime = messageTime
+	}
+	timestamp, ok := s.ingestService.ResolveTimestamp(deviceTime, receivedAt)
+	if !ok {
+		return nil, quarantine(model.EventQuarantineInvalidTimestamp, fmt.Errorf("timestamp %d is outside the accepted window", deviceTime))
+	}
+
+	record := &model.DeviceEventRecord{
+		InstanceUUID: instance.InstanceUUID,
+		DeviceType:   typeDef.Name,
+		EventKey:     event.EventKey,
+		Severity:     meta.Severity,
+		Timestamp:    timestamp,
+		ReceivedAt:   receivedAt.UnixMilli(),
+	}
+	if record.Severity == "" {
+		record.Severity = model.EventSeverityInfo
+	}
+	for _, paramDef := range meta.OutputParams {
+		value, provided := payload[paramDef.Name]
+		delete(payload, paramDef.Name)
+		if !provided || value == nil {
+			continue
+		}
+		converted, err := spec.ConvertToTargetType(value, paramDef.Type)
+		if err != nil {
+			return nil, quarantine(model.EventQuarantineInvalidPayload, err)
+		}
+		param, err := model.NewDeviceEventParam(paramDef.Name, paramDef.Type, converted)
+		if err != nil {
+			return nil, quarantine(model.EventQuarantineInvalidPayload, err)
+		}
+		record.Params = append(record.Params, param)
+	}
+	if len(payload) > 0 {
+		record.Extra, _ = json.Marshal(payload)
+	}
+
+	if err := s.eventRepo.Create(record); err != nil {
+		return nil, fmt.Errorf("failed to record event: %w", err)
+	}
+	record.FillData()
+	return record, nil
+}
+
+// ListEvents returns the recorded events of a device, newest first, with the total count.
+func (s *DeviceEventService) ListEvents(query DeviceEventQuery) ([]model.DeviceEventRecord, int64, error) {
+	for _, severity := range query.Severities {
+		if !validEventSeverities[severity] {
+			return nil, 0, fmt.Errorf("%w: unknown severity '%s'", ErrInvalidEventQuery, severity)
+		}
+	}
+	if err := checkEventQueryRange(query); err != nil {
+		return nil, 0, err
+	}
+
+	filter := repository.DeviceEventFilter{
+		InstanceUUID: query.InstanceUUID,
+		EventKeys:    query.EventKeys,
+		Severities:   query.Severities,
+		Start:        query.StartTime * 1000,
+		End:          query.EndTime * 1000,
+	}
+	records, err := s.eventRepo.Find(filter, query.Limit, query.Offset)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to query events: %w", err)
+	}
+	total, err := s.eventRepo.Count(filter)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to count events: %w", err)
+	}
+	for i := range records {
+		records[i].FillData()
+	}
+	return records, total, nil
+}
+
+// ListQuarantined returns quarantined events, newest first, with the total count. An empty
+// InstanceUUID lists the quarantine of all devices.
+func (s *DeviceEventService) ListQuarantined(query DeviceEventQuery) ([]model.QuarantinedEvent, int64, error) {
+	if query.Reason != "" && !validQuarantineReason[query.Reason] {
+		return nil, 0, fmt.Errorf("%w: unknown reason '%s'", ErrInvalidEventQuery, query.Reason)
+	}
+	if len(query.EventKeys) > 1 {
+		return nil, 0, fmt.Errorf("%w: the quarantine is filtered by one event key", ErrInvalidEventQuery)
+	}
+	if err := checkEventQueryRange(query); err != nil {
+		return nil, 0, err
+	}
+
+	filter := repository.EventQuarantineFilter{
+		InstanceUUID: query.InstanceUUID,
+		Reason:       query.Reason,
+		Start:        query.StartTime * 1000,
+		End:          query.EndTime * 1000,
+	}
+	if len(query.EventKeys) == 1 {
+		filter.EventKey = query.EventKeys[0]
+	}
+	events, err := s.eventRepo.FindQuarantined(filter, query.Limit, query.Offset)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to query quarantined events: %w", err)
+	}
+	total, err := s.eventRepo.CountQuarantined(filter)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to count quarantined events: %w", err)
+	}
+	return events, total, nil
+}
+
+func checkEventQueryRange(query DeviceEventQuery) error {
+	if query.StartTime < 0 || query.EndTime < 0 {
+		return fmt.Errorf("%w: timestamps must not be negative", ErrInvalidEventQuery)
+	}
+	if query.StartTime > 0 && query.EndTime > 0 && query.StartTime >= query.EndTime {
+		return fmt.Errorf("%w: start must be before end", ErrInvalidEventQuery)
+	}
+	return nil
+}
@@ -1,7 +1,6 @@
 package service
 
 import (
-	"OMEGA3-IOT/internal/db"
 	"OMEGA3-IOT/internal/logger"
 	"OMEGA3-IOT/internal/model"
 	"OMEGA3-IOT/internal/repository"
@@ -21,18 +20,18 @@ type DeviceFolderService struct {
 	instanceRepo    repository.InstanceRepository
 	deviceShareRepo repository.DeviceShareRepository
 	loggerService   logger.LoggerInterface
-	iotdbClient     *db.IOTDBClient
+	telemetryRepo   repository.TelemetryRepository
 	db              *gorm.DB
 }
 
 // NewDeviceFolderService creates a new DeviceFolderService.
-func NewDeviceFolderService(db *gorm.DB, iotdbClient *db.IOTDBClient, loggerService logger.LoggerInterface) *DeviceFolderService {
+func NewDeviceFolderService(db *gorm.DB, telemetryRepo repository.TelemetryRepository, loggerService logger.LoggerInterface) *DeviceFolderService {
 	return &DeviceFolderService{
 		folderRepo:      repository.NewDeviceFolderRepository(db),
 		instanceRepo:    repository.NewInstanceRepository(db),
 		deviceShareRepo: repository.NewDeviceShareRepository(db),
 		loggerService:   loggerService,
-		iotdbClient:     iotdbClient,
+		telemetryRepo:   telemetryRepo,
 		db:              db,
 	}
 }
@@ -64,7 +63,7 @@ func (s *DeviceFolderService) CreateFolder(name, description string, ownerUUID s
 	}
 	s.loggerService.EmitUserLog(logEvent)
 
-	s.reportIotdbMetric("FOLDER_CREATED", utils.ParseUserIDFromUUID(ownerUUID))
+	s.reportMetric("FOLDER_CREATED", utils.ParseUserIDFromUUID(ownerUUID))
 
 	return folder, nil
 }
@@ -145,7 +144,7 @@ func (s *DeviceFolderService) AddDeviceToFolder(folderUUID string, deviceUUID st
 	}
 	s.loggerService.EmitUserLog(logEvent)
 
-	s.reportIotdbMetric("DEVICE_ADDED_TO_FOLDER", utils.ParseUserIDFromUUID(userUUID))
+	s.reportMetric("DEVICE_ADDED_TO_FOLDER", utils.ParseUserIDFromUUID(userUUID))
 
 	return nil
 }
@@ -198,7 +197,7 @@ func (s *DeviceFolderService) RemoveDeviceFromFolder(folderUUID string, deviceUU
 	}
 	s.loggerService.EmitUserLog(logEvent)
 
-	s.reportIotdbMetric("DEVICE_REMOVED_FROM_FOLDER", utils.ParseUserIDFromUUID(userUUID))
+	s.reportMetric("DEVICE_REMOVED_FROM_FOLDER", utils.ParseUserIDFromUUID(userUUID))
 
 	return nil
 }
@@ -292,7 +291,7 @@ func (s *DeviceFolderService) DeleteFolder(folderUUID string, userUUID string) e
 	}
 	s.loggerService.EmitUserLog(logEvent)
 
-	s.reportIotdbMetric("FOLDER_DELETED", utils.ParseUserIDFromUUID(userUUID))
+	s.reportMetric("FOLDER_DELETED", utils.ParseUserIDFromUUID(userUUID))
 
 	return nil
 }
@@ -329,28 +328,18 @@ func (s *DeviceFolderService) checkDeviceAccessByUUID(instanceUUID, userUUID, re
 	return false, nil
 }
 
-func (s *DeviceFolderService) reportIotdbMetric(operationType string, userID int64) {
-	if s.iotdbClient == nil {
+func (s *DeviceFolderService) reportMetric(operationType string, userID int64) {
+	if s.telemetryRepo == nil {
 		return
 	}
 
-	path := "root.mm1.metrics.folder_operations"
 	timestamp := time.Now().UnixNano() / int64(time.Millisecond)
-
 	measurements := []string{"operation_type", "user_id", "count"}
 	dataTypes := []client.TSDataType{client.STRING, client.INT64, client.INT64}
 	values := []interface{}{operationType, userID, int64(1)}
 
-	session, err := s.iotdbClient.SessionPool.GetSession()
-	if err != nil {
-		log.Printf("[DeviceFolderService] Failed to get IoTDB session for metrics: %v", err)
-		return
-	}
-	defer s.iotdbClient.SessionPool.PutBack(session)
-
-	_, err = session.InsertRecord(path, measurements, dataTypes, values, timestamp)
-	if err != nil {
-		log.Printf("[DeviceFolderService] Failed to write metrics to IoTDB: %v", err)
+	if err := s.telemetryRepo.WriteMetric("folder_operations", measurements, dataTypes, values, timestamp); err != nil {
+		log.Printf("[DeviceFolderService] Failed to write folder metrics: %v", err)
 	}
 }
 
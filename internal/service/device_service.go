@@ -1,16 +1,18 @@
 package service
 
 import (
-	"OMEGA3-IOT/internal/db"
 	"OMEGA3-IOT/internal/model"
 	"OMEGA3-IOT/internal/repository"
 	"OMEGA3-IOT/internal/spec"
 	"OMEGA3-IOT/internal/utils"
 	"context"
+	"encoding/base64"
+	"errors"
 	"fmt"
 	"github.com/apache/iotdb-client-go/client"
 	"gorm.io/gorm"
 	"log"
+	"sort"
 	"strconv"
 	"time"
 )
@@ -18,139 +20,407 @@ import (
 type DeviceService struct {
 	instanceRepo           repository.InstanceRepository
 	deviceRegistrationRepo repository.DeviceRegistrationRecordRepository
-	iotDBClient            *db.IOTDBClient
 	db                     *gorm.DB
-	iotDBRepo              repository.TelemetryRepository
+	telemetryRepo          repository.TelemetryRepository
+	ingestService          *TelemetryIngestService
 }
 
-func NewDeviceService(db *gorm.DB, iotDBClient *db.IOTDBClient) *DeviceService {
+func NewDeviceService(db *gorm.DB, telemetryRepo repository.TelemetryRepository, ingestService *TelemetryIngestService) *DeviceService {
 	return &DeviceService{
 		instanceRepo:           repository.NewInstanceRepository(db),
 		deviceRegistrationRepo: repository.NewDeviceRegistrationRecordRepository(db),
-		iotDBClient:            iotDBClient,
 		db:                     db,
-		iotDBRepo:              repository.NewTelemetryRepository(iotDBClient),
+		telemetryRepo:          telemetryRepo,
+		ingestService:          ingestService,
 	}
 }
 
-func (s *DeviceService) updateDeviceProperties(instance model.Instance, data map[string]model.TypedInstancePropertyItem) error {
+// maxPropertySamples limits the samples a device may upload in one message.
+const maxPropertySamples = 1000
+
+// PropertySample is a set of property values a device measured at one time. Timestamp is in
+// Unix milliseconds (values below 1e12 are read as seconds); 0 means the time the message was
+// received. A timestamp on a single value takes precedence over the sample timestamp.
+type PropertySample struct {
+	Timestamp  int64                                      `json:"timestamp"`
+	Properties map[string]model.TypedInstancePropertyItem `json:"properties"`
+}
+
+func (s *DeviceService) updateDeviceProperties(instance model.Instance, samples []PropertySample) (map[string]interface{}, error) {
+	if len(samples) > maxPropertySamples {
+		return nil, fmt.Errorf("failed to update device %s: %d samples in one message, at most %d are accepted", instance.InstanceUUID, len(samples), maxPropertySamples)
+	}
 	if instance.Properties.Items == nil {
 		instance.Properties.Items = make(map[string]*model.TypedInstancePropertyItem)
 	}
+	// Earlier reports may still be waiting to be written to MySQL
+	s.ingestService.Overlay(&instance)
 
 	// Look up the device type spec for validation
 	typeDef, _ := model.GlobalDeviceTypeManager.GetByName(instance.Type)
-
-	for key, value := range data {
-		valueCopy := value
-		va := valueCopy.Value
-
-		if instance.Properties.Items[key] == nil {
-			return fmt.Errorf("failed to update device %s: the key provided was not matched with the properties", instance.InstanceUUID)
-		}
-
-		// Validate against spec if device type is known
-		if typeDef != nil {
-			if propMeta, ok := typeDef.Properties[key]; ok {
+	// Devices not yet migrated to the loaded type version are validated against their own snapshot
+	useSnapshot := typeDef != nil && instance.TypeVersion != "" && instance.TypeVersion != typeDef.Version
+
+	receivedAt := time.Now()
+	receivedAtMs := receivedAt.UnixMilli()
+	receivedNow := false
+	rejected := 0
+	// Values measured earlier, grouped by timestamp
+	earlier := make(map[int64]map[string]*model.TypedInstancePropertyItem)
+	updated := make(map[string]interface{})
+
+	for _, sample := range samples {
+		for key, value := range sample.Properties {
+			current := instance.Properties.Items[key]
+			if current == nil {
+				return nil, fmt.Errorf("failed to update device %s: the key provided was not matched with the properties", instance.InstanceUUID)
+			}
+			va := value.Value
+
+			// Validate against spec if device type is known
+			var propMeta model.PropertyMeta
+			var ok bool
+			if useSnapshot {
+				propMeta, ok = current.Meta, true
+			} else if typeDef != nil {
+				propMeta, ok = typeDef.Properties[key]
+			}
+			if ok {
 				if err := spec.ValidatePropertyValue(propMeta, va.Raw()); err != nil {
 					log.Printf("[DeviceService] Property validation failed for device %s, key '%s': %v", instance.InstanceUUID, key, err)
 					continue // skip invalid property, don't block other updates
 				}
 			}
-		}
 
-		instance.Properties.Items[key].Value = va
+			deviceTime := va.Timestamp
+			if deviceTime == 0 {
+				deviceTime = sample.Timestamp
+			}
+			timestamp, ok := s.ingestService.ResolveTimestamp(deviceTime, receivedAt)
+			if !ok {
+				rejected++
+				continue
+			}
+			va.Timestamp = timestamp
+
+			if timestamp == receivedAtMs {
+				receivedNow = true
+			} else {
+				if earlier[timestamp] == nil {
+					earlier[timestamp] = make(map[string]*model.TypedInstancePropertyItem)
+				}
+				earlier[timestamp][key] = &model.TypedInstancePropertyItem{Value: va, Meta: current.Meta}
+			}
+
+			// The last value only moves forward; older samples go to the history only
+			if timestamp >= current.Value.Timestamp {
+				current.Value = va
+				updated[key] = va.V
+			}
+		}
 	}
-	instance.LastSeen = time.Now().Unix()
-	instance.UpdatedAt = time.Now()
+	if rejected > 0 {
+		log.Printf("[DeviceService] Rejected %d values of device %s with timestamps outside the accepted window", rejected, instance.InstanceUUID)
+	}
+	instance.LastSeen = receivedAt.Unix()
+	instance.UpdatedAt = receivedAt
 	instance.Online = true
 
-	if err := s.instanceRepo.Update(&instance); err != nil {
-		return fmt.Errorf("failed to save updated instance %s to database: %w", instance.InstanceUUID, err)
+	// Values without a device timestamp record the whole instance at the receive time, as a
+	// report always did; timestamped values only record what was measured.
+	var points []repository.TelemetryData
+	if receivedNow {
+		point, err := telemetryDataFromItems(instance.InstanceUUID, instance.Type, instance.Properties.Items, receivedAtMs)
+		if err != nil {
+			s.ingestService.Submit(&instance, nil)
+			return nil, err
+		}
+		if point != nil {
+			points = append(points, *point)
+		}
+	}
+	for timestamp, items := range earlier {
+		point, err := telemetryDataFromItems(instance.InstanceUUID, instance.Type, items, timestamp)
+		if err != nil {
+			s.ingestService.Submit(&instance, nil)
+			return nil, err
+		}
+		if point != nil {
+			points = append(points, *point)
+		}
 	}
 
-	log.Printf("Database record for device %s updated with new properties.", instance.InstanceUUID)
-
-	err := s.ProcessDeviceTelemetryFromInstance(&instance)
-	if err != nil {
+	if err := s.ingestService.Submit(&instance, points); err != nil {
 		log.Printf("[DeviceService] Failed to process device telemetry from instance %s to database: %v", instance.InstanceUUID, err)
-		return err
+		return updated, err
 	}
+	return updated, nil
+}
 
-	return nil
+// Telemetry history limits
+const (
+	telemetryDefaultLimit  = 1000
+	telemetryMaxLimit      = 5000
+	telemetryMaxRawRange   = 31 * 24 * time.Hour
+	telemetryMaxAggRange   = 366 * 24 * time.Hour
+	telemetryMinAggWindow  = time.Second
+	telemetryMaxAggWindows = 100000
+)
+
+var ErrInvalidTelemetryQuery = errors.New("invalid telemetry query")
+
+// numericFormats are the property formats that avg, sum, min and max apply to.
+var numericFormats = map[string]bool{
+	"int": true, "integer": true, "long": true, "float": true, "single": true, "double": true,
 }
 
-func (s *DeviceService) GetDeviceHistoryData(instanceUUID string, startTimestamp int64, endTimestamp int64, limit int, offset int, properties []string) (*[]model.DeviceHistoryData, error) {
-	session, err := s.iotDBClient.SessionPool.GetSession()
+// TelemetryHistoryQuery selects the history of one device. StartTime and EndTime are Unix
+// seconds; the range is [StartTime, EndTime). With an Aggregation, Interval is the window size.
+type TelemetryHistoryQuery struct {
+	StartTime   int64
+	EndTime     int64
+	Properties  []string
+	Aggregation string
+	Interval    time.Duration
+	Limit       int
+	Cursor      string
+}
+
+// TelemetryPoint is one raw point or one aggregation window. Timestamp is in milliseconds;
+// for a window it is the window start.
+type TelemetryPoint struct {
+	Timestamp int64                  `json:"timestamp"`
+	Values    map[string]interface{} `json:"values"`
+}
+
+// TelemetryHistory is one page of a history query.
+type TelemetryHistory struct {
+	InstanceUUID string           `json:"instance_uuid"`
+	Properties   []string         `json:"properties"`
+	Aggregation  string           `json:"aggregation,omitempty"`
+	IntervalMs   int64            `json:"interval_ms,omitempty"`
+	Points       []TelemetryPoint `json:"points"`
+	HasMore      bool             `json:"has_more"`
+	NextCursor   string           `json:"next_cursor,omitempty"`
+}
+
+// QueryTelemetryHistory returns raw or aggregated telemetry of a device in ascending time
+// order. Aggregations run in IoTDB as GROUP BY time windows, so long ranges are cheap to
+// chart. Pages are chained with NextCursor; windows without any data are left out.
+func (s *DeviceService) QueryTelemetryHistory(instanceUUID string, query TelemetryHistoryQuery) (*TelemetryHistory, error) {
+	instance, err := s.instanceRepo.FindByUUID(instanceUUID)
 	if err != nil {
-		return nil, fmt.Errorf("failed to get session: %w", err)
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrDeviceNotFound
+		}
+		return nil, err
 	}
-	defer s.iotDBClient.SessionPool.PutBack(session)
 
-	instance, err := s.GetDeviceByInstanceUUID(instanceUUID)
+	aggregated := query.Aggregation != ""
+	if aggregated && !repository.IsTelemetryAggregation(query.Aggregation) {
+		return nil, fmt.Errorf("%w: unsupported aggregation '%s'", ErrInvalidTelemetryQuery, query.Aggregation)
+	}
+	if query.StartTime >= query.EndTime {
+		return nil, fmt.Errorf("%w: start must be before end", ErrInvalidTelemetryQuery)
+	}
+	span := time.Duration(query.EndTime-query.StartTime) * time.Second
+	if !aggregated && span > telemetryMaxRawRange {
+		return nil, fmt.Errorf("%w: raw queries are limited to %v, use an aggregation for longer ranges", ErrInvalidTelemetryQuery, telemetryMaxRawRange)
+	}
+	intervalMs := query.Interval.Milliseconds()
+	if aggregated {
+		if span > telemetryMaxAggRange {
+			return nil, fmt.Errorf("%w: range is limited to %v", ErrInvalidTelemetryQuery, telemetryMaxAggRange)
+		}
+		if query.Interval < telemetryMinAggWindow {
+			return nil, fmt.Errorf("%w: interval must be at least %v", ErrInvalidTelemetryQuery, telemetryMinAggWindow)
+		}
+		if span/query.Interval > telemetryMaxAggWindows {
+			return nil, fmt.Errorf("%w: interval too small for the range (more than %d windows)", ErrInvalidTelemetryQuery, telemetryMaxAggWindows)
+		}
+	}
+
+	properties, err := telemetryProperties(instance, query.Properties, query.Aggregation)
 	if err != nil {
 		return nil, err
 	}
 
-	telemetry, err := s.iotDBRepo.QueryTelemetry(instanceUUID, startTimestamp, endTimestamp)
+	limit := query.Limit
+	if limit <= 0 {
+		limit = telemetryDefaultLimit
+	}
+	if limit > telemetryMaxLimit {
+		limit = telemetryMaxLimit
+	}
+
+	startMs, endMs := query.StartTime*1000, query.EndTime*1000
+	if query.Cursor != "" {
+		cursor, err := decodeTelemetryCursor(query.Cursor)
+		if err != nil || cursor < startMs || cursor >= endMs {
+			return nil, fmt.Errorf("%w: invalid cursor", ErrInvalidTelemetryQuery)
+		}
+		if aggregated {
+			startMs = cursor + intervalMs
+		} else {
+			startMs = cursor + 1
+		}
+	}
+
+	history := &TelemetryHistory{
+		InstanceUUID: instanceUUID,
+		Properties:   properties,
+		Aggregation:  query.Aggregation,
+		Points:       []TelemetryPoint{},
+	}
+	if aggregated {
+		history.IntervalMs = intervalMs
+	}
+	if startMs >= endMs {
+		return history, nil
+	}
+
+	// One extra row tells whether another page follows.
+	telemetryQuery := repository.TelemetryQuery{
+		DeviceUUID: instanceUUID,
+		Properties: properties,
+		StartTime:  startMs,
+		EndTime:    endMs,
+		Limit:      limit + 1,
+	}
+	var rows []repository.TelemetryQueryResult
+	if aggregated {
+		rows, err = s.telemetryRepo.AggregateTelemetry(telemetryQuery, query.Aggregation, intervalMs)
+	} else {
+		rows, err = s.telemetryRepo.QueryTelemetry(telemetryQuery)
+	}
 	if err != nil {
 		return nil, err
 	}
 
-	var historyData []model.DeviceHistoryData
+	if len(rows) > limit {
+		rows = rows[:limit]
+		history.HasMore = true
+		history.NextCursor = encodeTelemetryCursor(rows[len(rows)-1].Timestamp)
+	}
+	for _, row := range rows {
+		if aggregated && allNil(row.Values) {
+			continue
+		}
+		history.Points = append(history.Points, TelemetryPoint{Timestamp: row.Timestamp, Values: row.Values})
+	}
+	return history, nil
+}
 
-	for i := 0; i < len(telemetry.Values); i++ {
-		measurement := telemetry.Measurements[i]
+// telemetryProperties checks the requested properties against the device, or picks every
+// property the aggregation applies to when none are requested.
+func telemetryProperties(instance *model.Instance, requested []string, aggregation string) ([]string, error) {
+	numericOnly := aggregation == "avg" || aggregation == "sum" || aggregation == "min" || aggregation == "max"
+	if len(requested) == 0 {
+		for key, item := range instance.Properties.Items {
+			if !numericOnly || numericFormats[item.Meta.Format] {
+				requested = append(requested, key)
+			}
+		}
+		sort.Strings(requested)
+		if len(requested) == 0 {
+			return nil, fmt.Errorf("%w: device has no properties to query", ErrInvalidTelemetryQuery)
+		}
+		return requested, nil
+	}
 
-		value, ok := telemetry.Values[measurement]
+	seen := make(map[string]bool, len(requested))
+	properties := make([]string, 0, len(requested))
+	for _, key := range requested {
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		item, ok := instance.Properties.Items[key]
 		if !ok {
-			value = "unknown value"
+			return nil, fmt.Errorf("%w: unknown property '%s'", ErrInvalidTelemetryQuery, key)
+		}
+		if numericOnly && !numericFormats[item.Meta.Format] {
+			return nil, fmt.Errorf("%w: %s is not applicable to %s property '%s'", ErrInvalidTelemetryQuery, aggregation, item.Meta.Format, key)
 		}
+		properties = append(properties, key)
+	}
+	return properties, nil
+}
+
+func encodeTelemetryCursor(timestampMs int64) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(strconv.FormatInt(timestampMs, 10)))
+}
 
-		valStr := ""
+func decodeTelemetryCursor(cursor string) (int64, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseInt(string(raw), 10, 64)
+}
+
+func allNil(values map[string]interface{}) bool {
+	for _, value := range values {
 		if value != nil {
-			valStr = fmt.Sprintf("%v", value)
+			return false
 		}
+	}
+	return true
+}
 
-		var properties model.Properties
-		properties.Items = make(map[string]*model.TypedInstancePropertyItem)
-
-		instanceProp, propExists := instance.Properties.Items[measurement]
-		if !propExists {
-			availableKeys := make([]string, 0, len(instance.Properties.Items))
-			for k := range instance.Properties.Items {
-				availableKeys = append(availableKeys, k)
-			}
-			log.Printf("[DEBUG] getHistoryData - device: %s, missing measurement: '%s', value from IoTDB: '%v', available properties: %v",
-				instanceUUID, measurement, value, availableKeys)
-			continue
+// GetDeviceHistoryData returns raw points as property snapshots, one per timestamp.
+// Times are Unix seconds.
+func (s *DeviceService) GetDeviceHistoryData(instanceUUID string, startTimestamp int64, endTimestamp int64, limit int, offset int, properties []string) (*[]model.DeviceHistoryData, error) {
+	instance, err := s.GetDeviceByInstanceUUID(instanceUUID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrDeviceNotFound
 		}
+		return nil, err
+	}
+	for _, key := range properties {
+		if _, ok := instance.Properties.Items[key]; !ok {
+			return nil, fmt.Errorf("%w: unknown property '%s'", ErrInvalidTelemetryQuery, key)
+		}
+	}
 
-		// 如果 IoTDB 返回的值为空，使用设备当前存储的值
-		if valStr == "" {
-			propCopy := *instanceProp
-			properties.Items[measurement] = &propCopy
-		} else {
-			it, convertErr := model.NewTypedValueFromOld(valStr, instanceProp.Meta.Format)
+	rows, err := s.telemetryRepo.QueryTelemetry(repository.TelemetryQuery{
+		DeviceUUID: instanceUUID,
+		Properties: properties,
+		StartTime:  startTimestamp * 1000,
+		EndTime:    endTimestamp * 1000,
+		Limit:      limit,
+		Offset:     offset,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	historyData := make([]model.DeviceHistoryData, 0, len(rows))
+	for _, row := range rows {
+		items := make(map[string]*model.TypedInstancePropertyItem, len(row.Values))
+		for measurement, value := range row.Values {
+			instanceProp, ok := instance.Properties.Items[measurement]
+			if !ok || value == nil {
+				continue // removed from the device, or no value at this timestamp
+			}
+			it, convertErr := model.NewTypedValueFromOld(fmt.Sprintf("%v", value), instanceProp.Meta.Format)
 			if it == nil {
-				log.Printf("[DEBUG] getHistoryData TypedValue is nil - device: %s, measurement: '%s', valStr: '%s', format: '%s', convertErr: %v",
-					instanceUUID, measurement, valStr, instanceProp.Meta.Format, convertErr)
-				// 转换失败时也使用设备当前存储的值
-				propCopy := *instanceProp
-				properties.Items[measurement] = &propCopy
+				log.Printf("[DeviceService] getHistoryData: cannot convert %s.%s value '%v' as %s: %v",
+					instanceUUID, measurement, value, instanceProp.Meta.Format, convertErr)
 				continue
 			}
 			propCopy := *instanceProp
 			propCopy.Value = *it
-			properties.Items[measurement] = &propCopy
+			items[measurement] = &propCopy
 		}
-
-		historyItem := model.DeviceHistoryData{
-			Timestamp:  telemetry.Timestamp,
-			Properties: properties,
+		if len(items) == 0 {
+			continue
 		}
-
-		historyData = append(historyData, historyItem)
+		historyData = append(historyData, model.DeviceHistoryData{
+			Timestamp:  row.Timestamp,
+			Properties: model.Properties{Items: items},
+		})
 	}
 
 	return &historyData, nil
@@ -164,10 +434,13 @@ func (s *DeviceService) GetDeviceByInstanceUUID(instanceUUID string) (*model.Ins
 }
 
 func (s *DeviceService) RegisterDeviceAnonymously(deviceTypeID int, verifyCode string) (*model.DeviceRegistrationRecord, error) {
-	_, valid := model.GlobalDeviceTypeManager.GetById(deviceTypeID)
+	deviceType, valid := model.GlobalDeviceTypeManager.GetById(deviceTypeID)
 	if !valid {
 		return nil, fmt.Errorf("invalid device type ID: %d", deviceTypeID)
 	}
+	if deviceType.Deprecated {
+		return nil, fmt.Errorf("device type ID %d is deprecated", deviceTypeID)
+	}
 
 	hashedVerifyCode := utils.HashVerifyCode(verifyCode)
 	record, err := model.NewRegistrationRecord(deviceTypeID, hashedVerifyCode)
@@ -192,6 +465,9 @@ func (s *DeviceService) AddDevice(name string, deviceTypeID int, remark string,
 	if !valid {
 		return nil, fmt.Errorf("invalid device type ID: %d", deviceTypeID)
 	}
+	if deviceType.Deprecated {
+		return nil, fmt.Errorf("device type ID %d is deprecated", deviceTypeID)
+	}
 
 	instance, err := model.NewInstanceFromConfig(name, ownerUUID, deviceType, "", remark, utils.GenerateUUID().String(), model.BindByWiFi)
 	if err != nil {
@@ -215,19 +491,34 @@ func (s *DeviceService) PropertiesToTelemetryData(instance *model.Instance) erro
 	return nil
 }
 
+// ProcessDeviceTelemetryFromInstance queues the last values of an instance for MySQL and a
+// telemetry point of its properties at the current time for IoTDB. Both are written in
+// batches by the ingest pipeline.
 func (s *DeviceService) ProcessDeviceTelemetryFromInstance(instance *model.Instance) error {
-	historicalDevicePath := utils.ConvertHyphenIntoDash(fmt.Sprintf("root.mm1.device_data.%s", instance.InstanceUUID))
+	point, err := telemetryDataFromItems(instance.InstanceUUID, instance.Type, instance.Properties.Items, time.Now().UnixMilli())
+	if err != nil {
+		// The last values are still stored, only the history point is lost.
+		s.ingestService.Submit(instance, nil)
+		return err
+	}
+	var points []repository.TelemetryData
+	if point != nil {
+		points = append(points, *point)
+	}
+	return s.ingestService.Submit(instance, points)
+}
 
+// telemetryDataFromItems converts property values to a telemetry point at timestamp (ms). It
+// returns nil if there are no properties to record.
+func telemetryDataFromItems(instanceUUID, typeName string, items map[string]*model.TypedInstancePropertyItem, timestamp int64) (*repository.TelemetryData, error) {
 	// Only process properties defined in the device type
-	typeDef, _ := model.GlobalDeviceTypeManager.GetByName(instance.Type)
-
-	measurements := make([]string, 0, len(instance.Properties.Items))
-	dataTypes := make([]client.TSDataType, 0, len(instance.Properties.Items))
-	values := make([]interface{}, 0, len(instance.Properties.Items))
+	typeDef, _ := model.GlobalDeviceTypeManager.GetByName(typeName)
 
-	log.Printf("[DeviceService] Processing telemetry for %s: %d properties in instance", instance.InstanceUUID, len(instance.Properties.Items))
+	measurements := make([]string, 0, len(items))
+	dataTypes := make([]client.TSDataType, 0, len(items))
+	values := make([]interface{}, 0, len(items))
 
-	for propKey, propItem := range instance.Properties.Items {
+	for propKey, propItem := range items {
 		// Skip properties not in the device type definition
 		if typeDef != nil {
 			if _, ok := typeDef.Properties[propKey]; !ok {
@@ -239,35 +530,35 @@ func (s *DeviceService) ProcessDeviceTelemetryFromInstance(instance *model.Insta
 
 		tempValue, err := propItem.Value.ToString()
 		if err != nil {
-			return fmt.Errorf("failed to parse '%s' with value '%s': %w", propKey, tempValue, err)
+			return nil, fmt.Errorf("failed to parse '%s' with value '%s': %w", propKey, tempValue, err)
 		}
 
 		switch propItem.Meta.Format {
 		case "int", "integer":
 			intVal, err := strconv.ParseInt(tempValue, 10, 32)
 			if err != nil {
-				return fmt.Errorf("failed to parse int property '%s' with value '%s': %w", propKey, tempValue, err)
+				return nil, fmt.Errorf("failed to parse int property '%s' with value '%s': %w", propKey, tempValue, err)
 			}
 			dataTypes = append(dataTypes, client.INT32)
 			values = append(values, int32(intVal))
 		case "long":
 			intVal, err := strconv.ParseInt(tempValue, 10, 64)
 			if err != nil {
-				return fmt.Errorf("failed to parse long property '%s' with value '%s': %w", propKey, tempValue, err)
+				return nil, fmt.Errorf("failed to parse long property '%s' with value '%s': %w", propKey, tempValue, err)
 			}
 			dataTypes = append(dataTypes, client.INT64)
 			values = append(values, intVal)
 		case "float", "single":
 			floatVal, err := strconv.ParseFloat(tempValue, 32)
 			if err != nil {
-				return fmt.Errorf("failed to parse float property '%s' with value '%s': %w", propKey, tempValue, err)
+				return nil, fmt.Errorf("failed to parse float property '%s' with value '%s': %w", propKey, tempValue, err)
 			}
 			dataTypes = append(dataTypes, client.FLOAT)
 			values = append(values, float32(floatVal))
 		case "double":
 			floatVal, err := strconv.ParseFloat(tempValue, 64)
 			if err != nil {
-				return fmt.Errorf("failed to parse double property '%s' with value '%s': %w", propKey, tempValue, err)
+				return nil, fmt.Errorf("failed to parse double property '%s' with value '%s': %w", propKey, tempValue, err)
 			}
 			dataTypes = append(dataTypes, client.DOUBLE)
 			values = append(values, floatVal)
@@ -287,7 +578,7 @@ func (s *DeviceService) ProcessDeviceTelemetryFromInstance(instance *model.Insta
 			default:
 				intVal, err = strconv.ParseInt(tempValue, 10, 64)
 				if err != nil {
-					return fmt.Errorf("failed to parse time property '%s' with value '%v': %w", propKey, propItem.Value.V, err)
+					return nil, fmt.Errorf("failed to parse time property '%s' with value '%v': %w", propKey, propItem.Value.V, err)
 				}
 			}
 			dataTypes = append(dataTypes, client.INT64)
@@ -295,7 +586,7 @@ func (s *DeviceService) ProcessDeviceTelemetryFromInstance(instance *model.Insta
 		case "boolean":
 			boolVal, err := strconv.ParseBool(tempValue)
 			if err != nil {
-				return fmt.Errorf("failed to parse boolean property '%s' with value '%s': %w", propKey, tempValue, err)
+				return nil, fmt.Errorf("failed to parse boolean property '%s' with value '%s': %w", propKey, tempValue, err)
 			}
 			dataTypes = append(dataTypes, client.BOOLEAN)
 			values = append(values, boolVal)
@@ -308,24 +599,27 @@ func (s *DeviceService) ProcessDeviceTelemetryFromInstance(instance *model.Insta
 
 	// Safety check: arrays must have the same length
 	if len(measurements) != len(dataTypes) || len(measurements) != len(values) {
-		return fmt.Errorf("[DeviceService] array length mismatch for %s: measurements=%d, dataTypes=%d, values=%d",
-			instance.InstanceUUID, len(measurements), len(dataTypes), len(values))
+		return nil, fmt.Errorf("[DeviceService] array length mismatch for %s: measurements=%d, dataTypes=%d, values=%d",
+			instanceUUID, len(measurements), len(dataTypes), len(values))
 	}
 
 	if len(measurements) == 0 {
-		log.Printf("[DeviceService] No valid properties to insert for %s", instance.InstanceUUID)
-		return nil
+		log.Printf("[DeviceService] No valid properties to insert for %s", instanceUUID)
+		return nil, nil
 	}
 
-	timestamp := time.Now().UnixNano() / int64(time.Millisecond)
-
-	log.Printf("[DeviceService] Inserting %d measurements for %s: %v", len(measurements), instance.InstanceUUID, measurements)
-
-	if err := s.iotDBClient.InsertRecordTyped(historicalDevicePath, measurements, dataTypes, values, timestamp); err != nil {
-		return fmt.Errorf("[DeviceService] failed to save telemetry from instance %s to IoTDB: %w", instance.InstanceUUID, err)
+	valueMap := make(map[string]interface{}, len(measurements))
+	for i, measurement := range measurements {
+		valueMap[measurement] = values[i]
 	}
 
-	return nil
+	return &repository.TelemetryData{
+		DeviceUUID:   instanceUUID,
+		Timestamp:    timestamp,
+		Measurements: measurements,
+		DataTypes:    dataTypes,
+		Values:       valueMap,
+	}, nil
 }
 
 // UpdateInstanceProperties updates device properties and saves to database
@@ -376,19 +670,21 @@ func (s *DeviceService) GetDeviceActions(instanceUUID string) (map[string]model.
 	return actions, nil
 }
 
-// GetDeviceByUUIDAndVerifyHash retrieves a device by UUID and verifies the hash
+// GetDeviceByUUIDAndVerifyHash retrieves a device by UUID and verifies the hash, accepting the
+// previous one during the grace period of a credential rotation
 func (s *DeviceService) GetDeviceByUUIDAndVerifyHash(instanceUUID string, verifyHash string) (*model.Instance, error) {
 	instance, err := s.instanceRepo.FindByUUID(instanceUUID)
 	if err != nil {
 		return nil, err
 	}
-	if instance.VerifyHash != verifyHash {
+	if !instance.AcceptsVerifyHash(verifyHash, time.Now().Unix()) {
 		return nil, fmt.Errorf("invalid verify hash")
 	}
 	return instance, nil
 }
 
-// UpdateDeviceProperties updates device properties (used by MQTT service)
-func (s *DeviceService) UpdateDeviceProperties(instance model.Instance, data map[string]model.TypedInstancePropertyItem) error {
-	return s.updateDeviceProperties(instance, data)
+// UpdateDeviceProperties updates device properties (used by MQTT service). It returns the
+// properties whose last value changed.
+func (s *DeviceService) UpdateDeviceProperties(instance model.Instance, samples []PropertySample) (map[string]interface{}, error) {
+	return s.updateDeviceProperties(instance, samples)
 }
@@ -0,0 +1,749 @@
+package service
+
+import (
+	"OMEGA3-IOT/internal/db"
+	"OMEGA3-IOT/internal/eventbus"
+	"OMEGA3-IOT/internal/logger"
+	"OMEGA3-IOT/internal/model"
+	"OMEGA3-IOT/internal/repository"
+	"OMEGA3-IOT/internal/spec"
+	"OMEGA3-IOT/internal/utils"
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/apache/iotdb-client-go/client"
+	"gorm.io/gorm"
+)
+
+// Reload triggers
+const (
+	DeviceTypeReloadByFile  = "file"
+	DeviceTypeReloadByAdmin = "admin"
+)
+
+var (
+	ErrInvalidDeviceTypes = errors.New("invalid device type definitions")
+	ErrDeviceTypeExists   = errors.New("device type already exists")
+)
+
+// DeviceTypeService manages the device type definitions. They are stored in MySQL and edited
+// through the admin API; the YAML file seeds an empty table and is imported again when it
+// changes or on admin request. Every change is validated in full before it is stored and
+// swapped in, so a broken definition never replaces a working set.
+type DeviceTypeService struct {
+	db            *gorm.DB
+	typeRepo      repository.DeviceTypeRepository
+	instanceRepo  repository.InstanceRepository
+	telemetryRepo repository.TelemetryRepository
+	eventBus      *eventbus.EventBus
+	filePath      string
+	watchInterval time.Duration
+
+	reloadMu    sync.Mutex // serializes changes; guards the fields below
+	lastSum     [sha256.Size]byte
+	lastModTime time.Time
+
+	stopCh chan struct{}
+	wg     sync.WaitGroup
+}
+
+// NewDeviceTypeService creates a DeviceTypeService seeded from the given YAML file.
+// A watchIntervalSec of 0 disables file watching; admin-triggered reloads still work.
+func NewDeviceTypeService(
+	gormDB *gorm.DB,
+	typeRepo repository.DeviceTypeRepository,
+	instanceRepo repository.InstanceRepository,
+	telemetryRepo repository.TelemetryRepository,
+	eventBus *eventbus.EventBus,
+	filePath string,
+	watchIntervalSec int,
+) *DeviceTypeService {
+	return &DeviceTypeService{
+		db:            gormDB,
+		typeRepo:      typeRepo,
+		instanceRepo:  instanceRepo,
+		telemetryRepo: telemetryRepo,
+		eventBus:      eventBus,
+		filePath:      filePath,
+		watchInterval: time.Duration(watchIntervalSec) * time.Second,
+		stopCh:        make(chan struct{}),
+	}
+}
+
+// Start loads the stored definitions, seeding the table from the YAML file on first run,
+// subscribes to reload events and, if enabled, starts watching the file. Until the stored
+// definitions are loaded the ones read from the file at startup stay in effect.
+func (s *DeviceTypeService) Start() {
+	eventbus.SubscribeTyped(s.eventBus, eventbus.EventType(logger.LogEventSystemDeviceTypeReloaded), s.handleReloaded, eventbus.WithGroup("service.DeviceTypeService.handleReloaded"))
+	eventbus.SubscribeTyped(s.eventBus, eventbus.EventType(logger.LogEventSystemDeviceTypeReloaded), s.refreshLoaded, eventbus.Broadcast())
+
+	if info, err := os.Stat(s.filePath); err == nil {
+		if data, err := os.ReadFile(s.filePath); err == nil {
+			s.lastSum = sha256.Sum256(data)
+			s.lastModTime = info.ModTime()
+		}
+	}
+	if err := s.loadStored(); err != nil {
+		log.Printf("[DeviceTypeService] Failed to load stored device types, using %s: %v", s.filePath, err)
+	}
+
+	if s.watchInterval > 0 {
+		s.wg.Add(1)
+		go s.run()
+	}
+	log.Printf("[DeviceTypeService] Started (file: %s, watch interval: %v)", s.filePath, s.watchInterval)
+}
+
+// Stop stops the file watcher.
+func (s *DeviceTypeService) Stop() {
+	close(s.stopCh)
+	s.wg.Wait()
+	log.Println("[DeviceTypeService] Stopped")
+}
+
+// loadStored swaps in the definitions stored in MySQL. An empty table is seeded with the
+// definitions loaded from the YAML file at startup.
+func (s *DeviceTypeService) loadStored() error {
+	s.reloadMu.Lock()
+	defer s.reloadMu.Unlock()
+
+	count, err := s.typeRepo.Count()
+	if err != nil {
+		return err
+	}
+	if count == 0 {
+		seed := sortedDeviceTypes(model.GlobalDeviceTypeManager.Snapshot())
+		if err := spec.ValidateDeviceTypes(seed); err != nil {
+			return fmt.Errorf("%w: %v", ErrInvalidDeviceTypes, err)
+		}
+		if err := s.store(seed); err != nil {
+			return fmt.Errorf("failed to seed device types: %w", err)
+		}
+		log.Printf("[DeviceTypeService] Seeded %d device types from %s", len(seed), s.filePath)
+	}
+
+	records, err := s.typeRepo.FindAll()
+	if err != nil {
+		return err
+	}
+	deviceTypes := make([]model.DeviceType, 0, len(records))
+	for i := range records {
+		dt, err := records[i].DeviceType()
+		if err != nil {
+			return fmt.Errorf("device type '%s': %w", records[i].Name, err)
+		}
+		deviceTypes = append(deviceTypes, dt)
+	}
+	if err := spec.ValidateDeviceTypes(deviceTypes); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidDeviceTypes, err)
+	}
+	model.GlobalDeviceTypeManager.Replace(deviceTypes)
+	log.Printf("[DeviceTypeService] Loaded %d device types from database", len(deviceTypes))
+	return nil
+}
+
+// Reload re-reads the definition file and imports it. The returned diff is empty if nothing changed.
+func (s *DeviceTypeService) Reload(trigger string) (model.DeviceTypeDiff, error) {
+	s.reloadMu.Lock()
+	defer s.reloadMu.Unlock()
+
+	data, err := os.ReadFile(s.filePath)
+	if err != nil {
+		return model.DeviceTypeDiff{}, fmt.Errorf("failed to read device type file: %w", err)
+	}
+	s.lastSum = sha256.Sum256(data)
+	if info, err := os.Stat(s.filePath); err == nil {
+		s.lastModTime = info.ModTime()
+	}
+	return s.importYAML(data, trigger)
+}
+
+// Import adds or replaces the device types in a YAML document in the same format as the
+// definition file. Types not in the document are kept.
+func (s *DeviceTypeService) Import(data []byte) (model.DeviceTypeDiff, error) {
+	s.reloadMu.Lock()
+	defer s.reloadMu.Unlock()
+	return s.importYAML(data, DeviceTypeReloadByAdmin)
+}
+
+// Export encodes the named device types, or all of them if names is empty, as YAML.
+func (s *DeviceTypeService) Export(names []string) ([]byte, error) {
+	snapshot := model.GlobalDeviceTypeManager.Snapshot()
+	if len(names) > 0 {
+		selected := make(map[string]*model.DeviceType, len(names))
+		for _, name := range names {
+			dt, ok := snapshot[name]
+			if !ok {
+				return nil, fmt.Errorf("%w: %s", ErrUnknownDeviceType, name)
+			}
+			selected[name] = dt
+		}
+		snapshot = selected
+	}
+	return model.MarshalDeviceTypes(sortedDeviceTypes(snapshot))
+}
+
+// Create adds a new device type.
+func (s *DeviceTypeService) Create(dt model.DeviceType) (*model.DeviceType, error) {
+	s.reloadMu.Lock()
+	defer s.reloadMu.Unlock()
+
+	if _, ok := model.GlobalDeviceTypeManager.GetByName(dt.Name); ok {
+		return nil, fmt.Errorf("%w: %s", ErrDeviceTypeExists, dt.Name)
+	}
+	return s.commitOne(dt)
+}
+
+// Update replaces the definition of an existing type. The ID is kept if dt leaves it unset,
+// and the deprecation flag is only changed through SetDeprecated.
+func (s *DeviceTypeService) Update(name string, dt model.DeviceType) (*model.DeviceType, error) {
+	s.reloadMu.Lock()
+	defer s.reloadMu.Unlock()
+
+	old, ok := model.GlobalDeviceTypeManager.GetByName(name)
+	if !ok {
+		return nil, ErrUnknownDeviceType
+	}
+	if dt.Name == "" {
+		dt.Name = name
+	} else if dt.Name != name {
+		return nil, fmt.Errorf("%w: device type '%s' cannot be renamed", ErrInvalidDeviceTypes, name)
+	}
+	if dt.ID == 0 {
+		dt.ID = old.ID
+	}
+	dt.Deprecated = old.Deprecated
+	return s.commitOne(dt)
+}
+
+// Clone creates a new type from the definition of an existing one. An empty version keeps
+// the version of the source.
+func (s *DeviceTypeService) Clone(sourceName, name string, id int, version string) (*model.DeviceType, error) {
+	s.reloadMu.Lock()
+	defer s.reloadMu.Unlock()
+
+	source, ok := model.GlobalDeviceTypeManager.GetByName(sourceName)
+	if !ok {
+		return nil, ErrUnknownDeviceType
+	}
+	if _, ok := model.GlobalDeviceTypeManager.GetByName(name); ok {
+		return nil, fmt.Errorf("%w: %s", ErrDeviceTypeExists, name)
+	}
+	dt, err := model.CloneDeviceType(*source)
+	if err != nil {
+		return nil, err
+	}
+	dt.Name, dt.ID, dt.Deprecated = name, id, false
+	if version != "" {
+		dt.Version = version
+	}
+	return s.commitOne(dt)
+}
+
+// SetDeprecated marks a type as deprecated or lifts the deprecation. Devices of a deprecated
+// type keep working, but no new devices of the type can be created or registered.
+func (s *DeviceTypeService) SetDeprecated(name string, deprecated bool) (*model.DeviceType, error) {
+	s.reloadMu.Lock()
+	defer s.reloadMu.Unlock()
+
+	old, ok := model.GlobalDeviceTypeManager.GetByName(name)
+	if !ok {
+		return nil, ErrUnknownDeviceType
+	}
+	dt, err := model.CloneDeviceType(*old)
+	if err != nil {
+		return nil, err
+	}
+	dt.Deprecated = deprecated
+	return s.commitOne(dt)
+}
+
+// importYAML parses a YAML document and commits its types. Caller must hold reloadMu.
+func (s *DeviceTypeService) importYAML(data []byte, trigger string) (model.DeviceTypeDiff, error) {
+	deviceTypes, err := model.ParseDeviceTypes(data)
+	if err != nil {
+		return model.DeviceTypeDiff{}, fmt.Errorf("%w: %v", ErrInvalidDeviceTypes, err)
+	}
+	// Checked on its own as well, since merging by name would hide duplicates within the document.
+	if err := spec.ValidateDeviceTypes(deviceTypes); err != nil {
+		return model.DeviceTypeDiff{}, fmt.Errorf("%w: %v", ErrInvalidDeviceTypes, err)
+	}
+	return s.commit(deviceTypes, trigger)
+}
+
+// commitOne commits a single admin change and returns the stored definition. Caller must hold reloadMu.
+func (s *DeviceTypeService) commitOne(dt model.DeviceType) (*model.DeviceType, error) {
+	if _, err := s.commit([]model.DeviceType{dt}, DeviceTypeReloadByAdmin); err != nil {
+		return nil, err
+	}
+	stored, _ := model.GlobalDeviceTypeManager.GetByName(dt.Name)
+	return stored, nil
+}
+
+// commit adds or replaces the given types in the loaded set. The resulting set is validated
+// in full, the changed types are stored in one transaction, and only then is the set swapped
+// in and the reload event published. Caller must hold reloadMu.
+func (s *DeviceTypeService) commit(changed []model.DeviceType, trigger string) (model.DeviceTypeDiff, error) {
+	current := model.GlobalDeviceTypeManager.Snapshot()
+	next := sortedDeviceTypes(current)
+	index := make(map[string]int, len(next))
+	for i := range next {
+		index[next[i].Name] = i
+	}
+	for _, dt := range changed {
+		clone, err := model.CloneDeviceType(dt)
+		if err != nil {
+			return model.DeviceTypeDiff{}, fmt.Errorf("%w: device type '%s': %v", ErrInvalidDeviceTypes, dt.Name, err)
+		}
+		if i, ok := index[clone.Name]; ok {
+			next[i] = clone
+		} else {
+			index[clone.Name] = len(next)
+			next = append(next, clone)
+		}
+	}
+
+	if err := spec.ValidateDeviceTypes(next); err != nil {
+		return model.DeviceTypeDiff{}, fmt.Errorf("%w: %v", ErrInvalidDeviceTypes, err)
+	}
+	if err := s.checkCompatibility(current, next); err != nil {
+		return model.DeviceTypeDiff{}, fmt.Errorf("%w: %v", ErrInvalidDeviceTypes, err)
+	}
+
+	diff := model.DiffDeviceTypes(current, next)
+	if diff.IsEmpty() {
+		log.Printf("[DeviceTypeService] Reload (%s): no changes", trigger)
+		return diff, nil
+	}
+
+	toStore := make([]model.DeviceType, 0, len(diff.AddedTypes)+len(diff.ModifiedTypes))
+	for _, name := range diff.AddedTypes {
+		toStore = append(toStore, next[index[name]])
+	}
+	for _, change := range diff.ModifiedTypes {
+		toStore = append(toStore, next[index[change.Name]])
+	}
+	if err := s.store(toStore); err != nil {
+		return model.DeviceTypeDiff{}, fmt.Errorf("failed to store device types: %w", err)
+	}
+
+	model.GlobalDeviceTypeManager.Replace(next)
+	log.Printf("[DeviceTypeService] Reload (%s): %d added, %d removed, %d modified types",
+		trigger, len(diff.AddedTypes), len(diff.RemovedTypes), len(diff.ModifiedTypes))
+
+	event := logger.NewSystemLogEvent(logger.LogLevelInfo,
+		fmt.Sprintf("Device types reloaded (%s)", trigger), logger.LogEventSystemDeviceTypeReloaded)
+	event.Metadata["trigger"] = trigger
+	event.Metadata["diff"] = diff
+	s.eventBus.Publish(context.Background(), event)
+	return diff, nil
+}
+
+// store creates or updates the records of the given types in one transaction.
+func (s *DeviceTypeService) store(deviceTypes []model.DeviceType) error {
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		typeRepo := s.typeRepo.WithTx(tx)
+		for _, dt := range deviceTypes {
+			record, err := typeRepo.FindByName(dt.Name)
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				record, err = model.NewDeviceTypeRecord(dt)
+				if err != nil {
+					return err
+				}
+				if err := typeRepo.Create(record); err != nil {
+					return err
+				}
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			if err := record.SetDefinition(dt); err != nil {
+				return err
+			}
+			if err := typeRepo.Update(record); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// sortedDeviceTypes copies a snapshot into a slice ordered by type ID.
+func sortedDeviceTypes(snapshot map[string]*model.DeviceType) []model.DeviceType {
+	deviceTypes := make([]model.DeviceType, 0, len(snapshot))
+	for _, dt := range snapshot {
+		deviceTypes = append(deviceTypes, *dt)
+	}
+	sort.Slice(deviceTypes, func(i, j int) bool { return deviceTypes[i].ID < deviceTypes[j].ID })
+	return deviceTypes
+}
+
+// checkCompatibility rejects changes that would break existing devices: renumbering a type
+// (registration records refer to the ID), changing the format of a property within the same
+// version (instances are only re-typed by an explicit migration), and removing a type that
+// still has devices.
+func (s *DeviceTypeService) checkCompatibility(current map[string]*model.DeviceType, next []model.DeviceType) error {
+	var errs []error
+	seen := make(map[string]bool, len(next))
+
+	for _, dt := range next {
+		seen[dt.Name] = true
+		old, ok := current[dt.Name]
+		if !ok {
+			continue
+		}
+		if old.ID != dt.ID {
+			errs = append(errs, fmt.Errorf("device type '%s': id cannot change from %d to %d", dt.Name, old.ID, dt.ID))
+		}
+		for key, meta := range dt.Properties {
+			oldMeta, ok := old.Properties[key]
+			if !ok {
+				continue
+			}
+			if oldMeta.Format != meta.Format && old.Version == dt.Version {
+				errs = append(errs, fmt.Errorf("device type '%s': property '%s' cannot change format from '%s' to '%s' without a new version",
+					dt.Name, key, oldMeta.Format, meta.Format))
+			}
+		}
+	}
+
+	for name := range current {
+		if seen[name] {
+			continue
+		}
+		count, err := s.instanceRepo.CountByType(name)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("device type '%s': failed to count devices: %v", name, err))
+		} else if count > 0 {
+			errs = append(errs, fmt.Errorf("device type '%s' cannot be removed: %d devices still use it", name, count))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (s *DeviceTypeService) run() {
+	defer s.wg.Done()
+	ticker := time.NewTicker(s.watchInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.stopCh:
+			return
+		case <-ticker.C:
+			s.checkFile()
+		}
+	}
+}
+
+// checkFile reloads the definitions if the file content changed since the last load.
+// A file that fails validation is not retried until its content changes again.
+func (s *DeviceTypeService) checkFile() {
+	info, err := os.Stat(s.filePath)
+	if err != nil {
+		log.Printf("[DeviceTypeService] Failed to stat %s: %v", s.filePath, err)
+		return
+	}
+
+	s.reloadMu.Lock()
+	defer s.reloadMu.Unlock()
+
+	if info.ModTime().Equal(s.lastModTime) {
+		return
+	}
+	s.lastModTime = info.ModTime()
+
+	data, err := os.ReadFile(s.filePath)
+	if err != nil {
+		log.Printf("[DeviceTypeService] Failed to read %s: %v", s.filePath, err)
+		return
+	}
+	sum := sha256.Sum256(data)
+	if sum == s.lastSum {
+		return
+	}
+	s.lastSum = sum
+
+	if _, err := s.importYAML(data, DeviceTypeReloadByFile); err != nil {
+		log.Printf("[DeviceTypeService] Reload rejected, keeping current definitions: %v", err)
+	}
+}
+
+// refreshLoaded swaps in the stored definitions after a change, which may have been made by
+// another server process.
+func (s *DeviceTypeService) refreshLoaded(ctx context.Context, event logger.SystemLogEvent) error {
+	if err := s.loadStored(); err != nil {
+		return fmt.Errorf("failed to refresh device types: %w", err)
+	}
+	return nil
+}
+
+// handleReloaded brings existing devices in line with changed property definitions:
+// the per-instance property set is refreshed and timeseries are created for new properties.
+// Types whose version changed are left alone; their devices move via MigrateInstances.
+func (s *DeviceTypeService) handleReloaded(ctx context.Context, event logger.SystemLogEvent) error {
+	diff, ok := reloadedDiff(event.Metadata["diff"])
+	if !ok {
+		return nil
+	}
+	// The change may come from another process; sync against its definitions
+	if err := s.refreshLoaded(ctx, event); err != nil {
+		return err
+	}
+	for _, change := range diff.ModifiedTypes {
+		if change.PropertiesChanged() && !change.VersionChanged() {
+			s.syncInstances(change)
+		}
+	}
+	return nil
+}
+
+// reloadedDiff reads the diff of a reload event. Events delivered by a durable bus were decoded
+// from JSON, so the diff arrives as a map.
+func reloadedDiff(value interface{}) (model.DeviceTypeDiff, bool) {
+	if diff, ok := value.(model.DeviceTypeDiff); ok {
+		return diff, true
+	}
+	var diff model.DeviceTypeDiff
+	if value == nil {
+		return diff, false
+	}
+	data, err := json.Marshal(value)
+	if err != nil {
+		return diff, false
+	}
+	return diff, json.Unmarshal(data, &diff) == nil
+}
+
+func (s *DeviceTypeService) syncInstances(change model.DeviceTypeChange) {
+	typeDef, ok := model.GlobalDeviceTypeManager.GetByName(change.Name)
+	if !ok {
+		return
+	}
+	instances, err := s.instanceRepo.FindByType(change.Name)
+	if err != nil {
+		log.Printf("[DeviceTypeService] Failed to list devices of type %s: %v", change.Name, err)
+		return
+	}
+
+	synced := 0
+	for _, instance := range instances {
+		if instance.TypeVersion != "" && instance.TypeVersion != typeDef.Version {
+			continue // still on an older version, waiting for migration
+		}
+		items := make(map[string]*model.TypedInstancePropertyItem, len(typeDef.Properties))
+		for key, meta := range typeDef.Properties {
+			item := &model.TypedInstancePropertyItem{Meta: meta, Value: initialPropertyValue(meta)}
+			if old := instance.Properties.Items[key]; old != nil {
+				item.Value = old.Value
+			}
+			items[key] = item
+		}
+		if err := s.instanceRepo.UpdateProperties(instance.InstanceUUID, model.Properties{Items: items}); err != nil {
+			log.Printf("[DeviceTypeService] Failed to update properties of device %s: %v", instance.InstanceUUID, err)
+			continue
+		}
+		if err := s.createTimeseries(instance.InstanceUUID, typeDef, change.AddedProperties); err != nil {
+			log.Printf("[DeviceTypeService] Failed to create timeseries for device %s: %v", instance.InstanceUUID, err)
+		}
+		synced++
+	}
+	log.Printf("[DeviceTypeService] Synced %d devices of type %s", synced, change.Name)
+}
+
+func (s *DeviceTypeService) createTimeseries(instanceUUID string, typeDef *model.DeviceType, properties []string) error {
+	if len(properties) == 0 {
+		return nil
+	}
+	dataTypes := make([]client.TSDataType, 0, len(properties))
+	for _, key := range properties {
+		dataType, _, _ := db.MapPropertyType(typeDef.Properties[key])
+		dataTypes = append(dataTypes, dataType)
+	}
+	return s.telemetryRepo.CreateTimeseries(utils.ConvertHyphenIntoDash(instanceUUID), properties, dataTypes)
+}
+
+// MigrateInstances moves devices of a type to the currently loaded version of that type:
+// new properties are added with their defaults, removed ones are dropped, and properties
+// whose format changed are re-typed in MySQL and IoTDB. With dryRun nothing is written and
+// the report shows what would happen. instanceUUIDs limits the run to specific devices.
+func (s *DeviceTypeService) MigrateInstances(typeName string, instanceUUIDs []string, dryRun bool) (*model.DeviceTypeMigrationReport, error) {
+	s.reloadMu.Lock()
+	defer s.reloadMu.Unlock()
+
+	typeDef, ok := model.GlobalDeviceTypeManager.GetByName(typeName)
+	if !ok {
+		return nil, ErrUnknownDeviceType
+	}
+	instances, err := s.instanceRepo.FindByType(typeName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list devices: %w", err)
+	}
+
+	if len(instanceUUIDs) > 0 {
+		byUUID := make(map[string]model.Instance, len(instances))
+		for _, instance := range instances {
+			byUUID[instance.InstanceUUID] = instance
+		}
+		selected := make([]model.Instance, 0, len(instanceUUIDs))
+		for _, instanceUUID := range instanceUUIDs {
+			instance, ok := byUUID[instanceUUID]
+			if !ok {
+				return nil, fmt.Errorf("%w: %s is not a %s device", ErrDeviceNotFound, instanceUUID, typeName)
+			}
+			selected = append(selected, instance)
+		}
+		instances = selected
+	}
+
+	report := &model.DeviceTypeMigrationReport{
+		TypeName:      typeName,
+		TargetVersion: typeDef.Version,
+		DryRun:        dryRun,
+		Total:         len(instances),
+		Instances:     []model.InstanceMigration{},
+	}
+	for i := range instances {
+		instance := &instances[i]
+		plan := model.PlanInstanceMigration(instance, typeDef)
+		if plan.UpToDate() {
+			report.UpToDate++
+			continue
+		}
+		properties := s.migratedProperties(instance, typeDef, &plan)
+		if !dryRun {
+			if err := s.migrateInstance(instance, typeDef, plan, properties); err != nil {
+				plan.Error = err.Error()
+				report.Failed++
+			} else {
+				plan.Migrated = true
+				report.Migrated++
+			}
+		}
+		report.Instances = append(report.Instances, plan)
+	}
+
+	if !dryRun {
+		log.Printf("[DeviceTypeService] Migrated %d/%d devices of type %s to version %s (%d failed)",
+			report.Migrated, report.Total, typeName, typeDef.Version, report.Failed)
+	}
+	return report, nil
+}
+
+// migratedProperties builds the property set of an instance for the target type and fills in
+// the value and storage details of re-typed properties in plan.
+func (s *DeviceTypeService) migratedProperties(instance *model.Instance, typeDef *model.DeviceType, plan *model.InstanceMigration) model.Properties {
+	items := make(map[string]*model.TypedInstancePropertyItem, len(typeDef.Properties))
+	for key, meta := range typeDef.Properties {
+		item := &model.TypedInstancePropertyItem{Meta: meta, Value: initialPropertyValue(meta)}
+		if old := instance.Properties.Items[key]; old != nil && old.Meta.Format == meta.Format {
+			item.Value = old.Value
+		}
+		items[key] = item
+	}
+
+	for i := range plan.RetypedProperties {
+		retype := &plan.RetypedProperties[i]
+		old := instance.Properties.Items[retype.Key]
+		meta := typeDef.Properties[retype.Key]
+
+		if old.Value.V == nil {
+			retype.ValueKept = true
+		} else if value, ok := convertPropertyValue(old.Value.V, meta); ok {
+			items[retype.Key].Value = model.TypedValue{V: value, Type: valueTypeForFormat(meta.Format), Timestamp: old.Value.Timestamp}
+			retype.ValueKept = true
+		}
+
+		oldType, _, _ := db.MapPropertyType(old.Meta)
+		newType, _, _ := db.MapPropertyType(meta)
+		if oldType != newType {
+			retype.HistoryArchivedAs = archivedPropertyName(retype.Key, instance.TypeVersion)
+		}
+	}
+	return model.Properties{Items: items}
+}
+
+// archivedPropertyName is the series that keeps the history of a property recorded under an
+// older type version, e.g. signal_strength_v1_0_0.
+func archivedPropertyName(key, version string) string {
+	if version == "" {
+		version = "0"
+	}
+	return key + "_v" + strings.Map(func(r rune) rune {
+		if (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') {
+			return r
+		}
+		return '_'
+	}, version)
+}
+
+// migrateInstance applies a migration plan. IoTDB is changed first so that a failure leaves
+// the MySQL record on the old version and the migration can simply be retried. History of a
+// property whose storage type changes is archived, not deleted.
+func (s *DeviceTypeService) migrateInstance(instance *model.Instance, typeDef *model.DeviceType, plan model.InstanceMigration, properties model.Properties) error {
+	deviceUUID := utils.ConvertHyphenIntoDash(instance.InstanceUUID)
+	create := append([]string{}, plan.AddedProperties...)
+	for _, retype := range plan.RetypedProperties {
+		if retype.HistoryArchivedAs == "" {
+			continue
+		}
+		if err := s.telemetryRepo.ArchiveTimeseries(deviceUUID, retype.Key, retype.HistoryArchivedAs); err != nil {
+			return fmt.Errorf("failed to archive timeseries %s: %w", retype.Key, err)
+		}
+		create = append(create, retype.Key)
+	}
+	if err := s.createTimeseries(instance.InstanceUUID, typeDef, create); err != nil {
+		return fmt.Errorf("failed to create timeseries: %w", err)
+	}
+
+	return s.instanceRepo.UpdateFields(instance.InstanceUUID, map[string]interface{}{
+		"properties":   properties,
+		"type_version": typeDef.Version,
+	})
+}
+
+// valueTypeForFormat maps a property format to the value type used in device payloads.
+func valueTypeForFormat(format string) string {
+	switch format {
+	case "int", "integer", "long":
+		return "int"
+	case "float", "double":
+		return "float"
+	case "bool", "boolean":
+		return "bool"
+	case "time", "json":
+		return format
+	default:
+		return "string"
+	}
+}
+
+func convertPropertyValue(value interface{}, meta model.PropertyMeta) (interface{}, bool) {
+	converted, err := spec.ConvertToTargetType(value, valueTypeForFormat(meta.Format))
+	if err != nil {
+		return nil, false
+	}
+	return converted, true
+}
+
+// initialPropertyValue is the value of a property that has not been reported yet.
+func initialPropertyValue(meta model.PropertyMeta) model.TypedValue {
+	if meta.Default == nil {
+		return model.TypedValue{}
+	}
+	value, ok := convertPropertyValue(meta.Default, meta)
+	if !ok {
+		return model.TypedValue{}
+	}
+	return model.TypedValue{V: value, Type: valueTypeForFormat(meta.Format)}
+}
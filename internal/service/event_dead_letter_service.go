@@ -0,0 +1,110 @@
+package service
+
+import (
+	"OMEGA3-IOT/internal/eventbus"
+	"OMEGA3-IOT/internal/model"
+	"OMEGA3-IOT/internal/repository"
+	"context"
+	"errors"
+	"fmt"
+	"gorm.io/gorm"
+	"log"
+	"time"
+)
+
+var (
+	ErrDeadLetterNotFound      = errors.New("dead letter not found")
+	ErrDeadLetterNotReplayable = errors.New("event type is not registered, the dead letter cannot be replayed")
+	ErrDeadLetterReplayFailed  = errors.New("replay failed")
+)
+
+// maxDeadLetterError bounds the stored handler error.
+const maxDeadLetterError = 1000
+
+// EventDeadLetterService stores events whose handlers kept failing in MySQL and replays them. It
+// is the dead-letter sink of the event bus.
+type EventDeadLetterService struct {
+	letterRepo repository.EventDeadLetterRepository
+	eventBus   *eventbus.EventBus
+}
+
+func NewEventDeadLetterService(letterRepo repository.EventDeadLetterRepository, eventBus *eventbus.EventBus) *EventDeadLetterService {
+	return &EventDeadLetterService{letterRepo: letterRepo, eventBus: eventBus}
+}
+
+// StoreDeadLetter implements eventbus.DeadLetterSink.
+func (s *EventDeadLetterService) StoreDeadLetter(letter eventbus.DeadLetter) error {
+	errMsg := letter.Error
+	if len(errMsg) > maxDeadLetterError {
+		errMsg = errMsg[:maxDeadLetterError]
+	}
+	record := model.EventDeadLetter{
+		GroupName: letter.Group,
+		EventType: string(letter.EventType),
+		Kind:      letter.Kind,
+		Payload:   letter.Payload,
+		Error:     errMsg,
+		Attempts:  letter.Attempts,
+		FailedAt:  letter.FailedAt.Unix(),
+	}
+	if err := s.letterRepo.Create(&record); err != nil {
+		return err
+	}
+	log.Printf("[EventDeadLetterService] Stored dead letter %d: %s for group %s", record.ID, record.EventType, record.GroupName)
+	return nil
+}
+
+// List returns dead letters newest first, optionally filtered by event type and consumer group.
+func (s *EventDeadLetterService) List(eventType, group string, limit, offset int) ([]model.EventDeadLetter, int64, error) {
+	return s.letterRepo.List(eventType, group, limit, offset)
+}
+
+// Get returns a dead letter.
+func (s *EventDeadLetterService) Get(id uint) (*model.EventDeadLetter, error) {
+	letter, err := s.letterRepo.FindByID(id)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrDeadLetterNotFound
+		}
+		return nil, err
+	}
+	return letter, nil
+}
+
+// Replay runs the handlers of the dead letter's consumer group in this process again. The dead
+// letter is kept and marked as replayed, so a second replay is a deliberate duplicate.
+func (s *EventDeadLetterService) Replay(id uint) (*model.EventDeadLetter, error) {
+	letter, err := s.Get(id)
+	if err != nil {
+		return nil, err
+	}
+	if letter.Kind == "" {
+		return nil, ErrDeadLetterNotReplayable
+	}
+
+	if err := s.eventBus.Replay(context.Background(), letter.GroupName, letter.Kind, letter.Payload); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrDeadLetterReplayFailed, err)
+	}
+	letter.ReplayedAt = time.Now().Unix()
+	if err := s.letterRepo.MarkReplayed(letter.ID, letter.ReplayedAt); err != nil {
+		return nil, fmt.Errorf("replayed but not marked: %w", err)
+	}
+	return letter, nil
+}
+
+// Delete removes a dead letter.
+func (s *EventDeadLetterService) Delete(id uint) error {
+	if err := s.letterRepo.Delete(id); err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrDeadLetterNotFound
+		}
+		return err
+	}
+	return nil
+}
+
+// Subscriptions returns the queue state and counters of the event bus subscriptions in this
+// process.
+func (s *EventDeadLetterService) Subscriptions() []eventbus.SubscriptionStats {
+	return s.eventBus.Stats()
+}
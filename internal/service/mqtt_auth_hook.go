@@ -0,0 +1,86 @@
+package service
+
+import (
+	"OMEGA3-IOT/internal/mqttbroker"
+	"crypto/subtle"
+	"strings"
+)
+
+// MQTTAuthHook answers the authentication and ACL hooks of an external MQTT broker, so devices
+// authenticate once per connection there as well. The server's own client is recognised by the
+// mqtt.client credentials and may use every topic.
+type MQTTAuthHook struct {
+	authenticator  *DeviceBrokerAuthenticator
+	acl            mqttbroker.ACL
+	serverUsername string
+	serverPassword string
+}
+
+func NewMQTTAuthHook(authenticator *DeviceBrokerAuthenticator, serverUsername string, serverPassword string) *MQTTAuthHook {
+	return &MQTTAuthHook{
+		authenticator:  authenticator,
+		acl:            mqttbroker.DeviceTopicACL{},
+		serverUsername: serverUsername,
+		serverPassword: serverPassword,
+	}
+}
+
+// Authenticate checks a connecting client. certCommonName is the common name of a client
+// certificate the broker has verified, empty when none was presented.
+func (h *MQTTAuthHook) Authenticate(clientID, username, password, certCommonName string) (*mqttbroker.Principal, error) {
+	if h.isServer(username) {
+		if subtle.ConstantTimeCompare([]byte(password), []byte(h.serverPassword)) != 1 {
+			return nil, mqttbroker.ErrBadCredentials
+		}
+		return &mqttbroker.Principal{Superuser: true}, nil
+	}
+	if certCommonName != "" {
+		if _, err := h.authenticator.deviceCredentials(certCommonName); err != nil {
+			return nil, err
+		}
+		return &mqttbroker.Principal{DeviceUUID: certCommonName}, nil
+	}
+	return h.authenticator.Authenticate(clientID, username, []byte(password))
+}
+
+// Authorize checks a publish or subscribe of a connection the broker authenticated through
+// Authenticate, so the username (or certificate) identifies it.
+func (h *MQTTAuthHook) Authorize(username, certCommonName, topic string, subscribe bool) bool {
+	var principal *mqttbroker.Principal
+	switch {
+	case certCommonName != "":
+		principal = &mqttbroker.Principal{DeviceUUID: certCommonName}
+	case h.isServer(username):
+		principal = &mqttbroker.Principal{Superuser: true}
+	case username != "":
+		principal = &mqttbroker.Principal{DeviceUUID: username}
+	case h.authenticator.legacyVerifyCode:
+		// Legacy connections have no identity at the broker; their messages carry the
+		// verify_code, which the MQTT service checks
+		return legacyDeviceTopic(topic, subscribe)
+	default:
+		return false
+	}
+	if subscribe {
+		return h.acl.CanSubscribe(principal, topic)
+	}
+	return h.acl.CanPublish(principal, topic)
+}
+
+func (h *MQTTAuthHook) isServer(username string) bool {
+	return h.serverUsername != "" && username == h.serverUsername
+}
+
+// legacyDeviceTopic allows the device topics of the MQTT protocol for a single device, so a
+// legacy connection cannot subscribe to all devices with a wildcard.
+func legacyDeviceTopic(topic string, subscribe bool) bool {
+	parts := strings.Split(topic, "/")
+	if len(parts) < 4 || parts[0] != "data" || parts[1] != "device" || strings.ContainsAny(parts[2], "+#") || parts[2] == "" {
+		return false
+	}
+	suffix := strings.Join(parts[3:], "/")
+	if subscribe {
+		return suffix == "action" || suffix == "shadow/delta"
+	}
+	return suffix == "properties" || suffix == "action_result"
+}
@@ -0,0 +1,125 @@
+package service
+
+import (
+	"OMEGA3-IOT/internal/mqttbroker"
+	"OMEGA3-IOT/internal/pki"
+	"OMEGA3-IOT/internal/repository"
+	"OMEGA3-IOT/internal/utils"
+	"crypto/subtle"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"gorm.io/gorm"
+	"log"
+	"time"
+)
+
+// DeviceBrokerAuthenticator authenticates device connections, to the embedded MQTT broker and to
+// an external broker through the auth hook. The username is the device UUID and the password the
+// verify code issued at registration; with a client certificate, its common name is the UUID.
+type DeviceBrokerAuthenticator struct {
+	instanceRepo repository.InstanceRepository
+	regRepo      repository.DeviceRegistrationRecordRepository
+	certService  *DeviceCertificateService // nil when the built-in CA is disabled
+	// legacyVerifyCode admits connections without credentials, for firmware that still sends
+	// the verify_code in every message body.
+	legacyVerifyCode bool
+}
+
+func NewDeviceBrokerAuthenticator(instanceRepo repository.InstanceRepository, regRepo repository.DeviceRegistrationRecordRepository, certService *DeviceCertificateService, legacyVerifyCode bool) *DeviceBrokerAuthenticator {
+	return &DeviceBrokerAuthenticator{
+		instanceRepo:     instanceRepo,
+		regRepo:          regRepo,
+		certService:      certService,
+		legacyVerifyCode: legacyVerifyCode,
+	}
+}
+
+func (a *DeviceBrokerAuthenticator) Authenticate(clientID, username string, password []byte) (*mqttbroker.Principal, error) {
+	if username == "" && len(password) == 0 && a.legacyVerifyCode {
+		// Identified by the verify_code of its first message, see AuthenticatePayload
+		return &mqttbroker.Principal{}, nil
+	}
+	if username == "" || len(password) == 0 {
+		return nil, mqttbroker.ErrBadCredentials
+	}
+	return a.verifyDevice(username, utils.HashVerifyCode(string(password)))
+}
+
+// AuthenticateCertificate accepts a verified client certificate issued to a known device.
+// Certificates of the built-in CA must also not be revoked.
+func (a *DeviceBrokerAuthenticator) AuthenticateCertificate(clientID string, cert *x509.Certificate) (*mqttbroker.Principal, error) {
+	deviceUUID := pki.DeviceUUID(cert)
+	if a.certService != nil && a.certService.IssuedByCA(cert) {
+		var err error
+		if deviceUUID, err = a.certService.VerifyCertificate(cert); err != nil {
+			log.Printf("[MQTTBroker] Refused certificate %s of client '%s': %v", pki.SerialNumber(cert), clientID, err)
+			return nil, mqttbroker.ErrNotAuthorized
+		}
+	}
+	if _, err := a.deviceCredentials(deviceUUID); err != nil {
+		return nil, err
+	}
+	return &mqttbroker.Principal{DeviceUUID: deviceUUID}, nil
+}
+
+// AuthenticatePayload identifies a legacy connection by the verify_code in the body of a message
+// to its device topic.
+func (a *DeviceBrokerAuthenticator) AuthenticatePayload(topic string, payload []byte) (*mqttbroker.Principal, error) {
+	if !a.legacyVerifyCode {
+		return nil, mqttbroker.ErrNotAuthorized
+	}
+	deviceUUID, err := extractDeviceUUIDFromTopic(topic)
+	if err != nil {
+		return nil, mqttbroker.ErrNotAuthorized
+	}
+	var message struct {
+		VerifyCode string `json:"verify_code"`
+	}
+	if err := json.Unmarshal(payload, &message); err != nil || message.VerifyCode == "" {
+		return nil, mqttbroker.ErrBadCredentials
+	}
+	return a.verifyDevice(deviceUUID, utils.HashVerifyCode(message.VerifyCode))
+}
+
+func (a *DeviceBrokerAuthenticator) verifyDevice(deviceUUID string, verifyHash string) (*mqttbroker.Principal, error) {
+	accepts, err := a.deviceCredentials(deviceUUID)
+	if err != nil {
+		return nil, err
+	}
+	if !accepts(verifyHash) {
+		return nil, mqttbroker.ErrBadCredentials
+	}
+	return &mqttbroker.Principal{DeviceUUID: deviceUUID}, nil
+}
+
+// deviceCredentials returns the verify hash check of a bound device, or of a registered device
+// that is not bound yet: those connect before binding to receive the bind action. Bound devices
+// also accept their previous verify code during the grace period of a rotation; reset devices
+// have no valid credentials until they are re-provisioned.
+func (a *DeviceBrokerAuthenticator) deviceCredentials(deviceUUID string) (func(verifyHash string) bool, error) {
+	if deviceUUID == "" {
+		return nil, mqttbroker.ErrBadCredentials
+	}
+	instance, err := a.instanceRepo.FindByUUID(deviceUUID)
+	if err == nil {
+		if instance.VerifyHash == "" {
+			return nil, mqttbroker.ErrBadCredentials
+		}
+		return func(verifyHash string) bool {
+			return instance.AcceptsVerifyHash(verifyHash, time.Now().Unix())
+		}, nil
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		log.Printf("[MQTTBroker] Failed to load device %s: %v", deviceUUID, err)
+		return nil, mqttbroker.ErrNotAuthorized
+	}
+
+	record, err := a.regRepo.FindByDeviceUUID(deviceUUID)
+	if err != nil || record.IsBound || record.ExpiresAt < time.Now().Unix() || record.VerifyHash == "" {
+		return nil, mqttbroker.ErrBadCredentials
+	}
+	return func(verifyHash string) bool {
+		return subtle.ConstantTimeCompare([]byte(record.VerifyHash), []byte(verifyHash)) == 1
+	}, nil
+}
@@ -4,15 +4,17 @@ import (
 	"OMEGA3-IOT/internal/eventbus"
 	"OMEGA3-IOT/internal/logger"
 	"OMEGA3-IOT/internal/model"
-	"OMEGA3-IOT/internal/spec"
 	"OMEGA3-IOT/internal/utils"
 	"context"
+	"crypto/tls"
 	"encoding/json"
 	"errors"
 	"fmt"
 	mqtt "github.com/eclipse/paho.mqtt.golang"
 	"gorm.io/gorm"
 	"log"
+	"net"
+	"net/url"
 	"strings"
 	"time"
 )
@@ -21,30 +23,69 @@ type MQTTService struct {
 	broker          mqtt.Client
 	deviceService   *DeviceService
 	presenceService *PresenceService
+	eventService    *DeviceEventService
 	loggerService   logger.LoggerInterface
 	eventBus        *eventbus.EventBus
+
+	brokerAuthenticated bool
+}
+
+// MQTTConnection describes how the server's MQTT client reaches the broker.
+type MQTTConnection struct {
+	BrokerURL string
+	ClientID  string
+	Username  string
+	Password  string
+	// Dial, when set, opens the connection instead of dialing BrokerURL, e.g. in memory to the
+	// embedded broker.
+	Dial func() (net.Conn, error)
+	// TLSConfig is used for ssl:// and tls:// broker URLs (mqtt.tls), e.g. to present a client
+	// certificate to a broker requiring mTLS.
+	TLSConfig *tls.Config
+	// BrokerAuthenticated is set when the broker authenticates devices per connection (the
+	// embedded broker, or an external one using the auth hook) and confines them to their own
+	// topics, so the verify_code in payloads is not checked.
+	BrokerAuthenticated bool
 }
 
 type DeviceMessage struct {
+	// Deprecated: devices authenticate their connection; only checked when the broker does not
+	// authenticate devices, see MQTTConnection.BrokerAuthenticated.
 	VerifyCode string `json:"verify_code"`
-	TimeStamp  int64  `json:"timestamp"`
+	TimeStamp  int64  `json:"timestamp"` // measurement time of data.properties, see PropertySample
 	Data       Data   `json:"data"`
 }
 
 type Data struct {
 	Properties map[string]model.TypedInstancePropertyItem `json:"properties"`
+	Samples    []PropertySample                           `json:"samples"` // buffered historical readings
 	Event      model.DeviceEvent                          `json:"event"`
 	Action     model.Action                               `json:"action"`
 }
 type Publisher interface {
-	PublishActionToDevice(deviceUUID string, actionName string, payload interface{}) error
+	PublishActionToDevice(deviceUUID string, commandName string, payload model.Action) error
 }
 
-func NewMQTTService(brokerURL string, deviceService *DeviceService, loggerService logger.LoggerInterface, presenceService *PresenceService, eventBus *eventbus.EventBus) (*MQTTService, error) {
+func NewMQTTService(conn MQTTConnection, deviceService *DeviceService, loggerService logger.LoggerInterface, presenceService *PresenceService, eventService *DeviceEventService, eventBus *eventbus.EventBus) (*MQTTService, error) {
+	brokerURL := conn.BrokerURL
 	options := mqtt.NewClientOptions()
 	options.AddBroker(brokerURL)
 
-	options.SetClientID("omega3-iot-server")
+	clientID := conn.ClientID
+	if clientID == "" {
+		clientID = "omega3-iot-server"
+	}
+	options.SetClientID(clientID)
+	options.SetUsername(conn.Username)
+	options.SetPassword(conn.Password)
+	if conn.TLSConfig != nil {
+		options.SetTLSConfig(conn.TLSConfig)
+	}
+	if conn.Dial != nil {
+		options.SetCustomOpenConnectionFn(func(*url.URL, mqtt.ClientOptions) (net.Conn, error) {
+			return conn.Dial()
+		})
+	}
 	options.SetAutoReconnect(true)
 	options.SetConnectRetry(true)
 	options.SetOrderMatters(false) //无序处理消息
@@ -58,23 +99,32 @@ func NewMQTTService(brokerURL string, deviceService *DeviceService, loggerServic
 		log.Printf("MQTT Service disconnected from broker: %s for : %s", brokerURL, err)
 	})
 	client := mqtt.NewClient(options)
-	_, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-	defer cancel()
 
-	if token := client.Connect(); token.Wait() && token.Error() != nil {
-		log.Fatalf("Failed to connect MQTT broke for : %v", token.Error())
+	// ConnectRetry keeps retrying in the background, so give up waiting after a while
+	token := client.Connect()
+	if !token.WaitTimeout(10 * time.Second) {
+		client.Disconnect(0)
+		return nil, fmt.Errorf("timed out connecting to MQTT broker %s", brokerURL)
+	}
+	if token.Error() != nil {
+		return nil, fmt.Errorf("failed to connect MQTT broker %s: %w", brokerURL, token.Error())
 	}
 	log.Printf("MQTT Service connected to broker: %s successfully", brokerURL)
 
 	//deviceSvc := NewDeviceService()
 	service := &MQTTService{
-		broker:          client,
-		deviceService:   deviceService,
-		presenceService: presenceService,
-		loggerService:   loggerService,
-		eventBus:        eventBus,
+		broker:              client,
+		deviceService:       deviceService,
+		presenceService:     presenceService,
+		eventService:        eventService,
+		loggerService:       loggerService,
+		eventBus:            eventBus,
+		brokerAuthenticated: conn.BrokerAuthenticated,
+	}
+	if err := service.setupSubscription(); err != nil {
+		client.Disconnect(0)
+		return nil, err
 	}
-	service.setupSubscription()
 	return service, nil
 }
 func (m *MQTTService) PublishActionToDevice(deviceUUID string, commandName string, payload model.Action) error {
@@ -91,10 +141,49 @@ func (m *MQTTService) PublishActionToDevice(deviceUUID string, commandName strin
 	log.Printf("MQTT Service published action payload: %v to %v", string(payloadBytes), topic)
 	return nil
 }
-func (m *MQTTService) setupSubscription() {
+
+// PublishShadowDelta publishes the shadow delta as a retained message so a sleeping device
+// receives it when it next subscribes. A nil delta clears the retained message.
+func (m *MQTTService) PublishShadowDelta(deviceUUID string, delta *model.ShadowDeltaMessage) error {
+	topic := fmt.Sprintf("data/device/%s/shadow/delta", deviceUUID)
+	var payloadBytes []byte
+	if delta != nil {
+		var err error
+		if payloadBytes, err = json.Marshal(delta); err != nil {
+			return fmt.Errorf("failed to marshal shadow delta: %w", err)
+		}
+	}
+	token := m.broker.Publish(topic, 1, true, payloadBytes)
+	if token.Wait() && token.Error() != nil {
+		return fmt.Errorf("failed to publish shadow delta: %v", token.Error())
+	}
+	log.Printf("MQTT Service published shadow delta: %v to %v", string(payloadBytes), topic)
+	return nil
+}
+
+// PublishCredentials delivers rotated credentials to a device as a retained message, so a device
+// that is offline receives them when it next subscribes. A nil message clears it.
+func (m *MQTTService) PublishCredentials(deviceUUID string, message *model.DeviceCredentialsMessage) error {
+	topic := fmt.Sprintf("data/device/%s/credentials", deviceUUID)
+	var payloadBytes []byte
+	if message != nil {
+		var err error
+		if payloadBytes, err = json.Marshal(message); err != nil {
+			return fmt.Errorf("failed to marshal credentials: %w", err)
+		}
+	}
+	token := m.broker.Publish(topic, 1, true, payloadBytes)
+	if token.Wait() && token.Error() != nil {
+		return fmt.Errorf("failed to publish credentials: %v", token.Error())
+	}
+	log.Printf("MQTT Service published credentials to %v", topic)
+	return nil
+}
+
+func (m *MQTTService) setupSubscription() error {
 	log.Printf("MQTT Service setup subscription")
 	if token := m.broker.Subscribe("data/device/+/properties", 1, m.handlePropertiesData); token.Wait() && token.Error() != nil {
-		log.Fatalf("Failed to subscribe to data topic : %s", token.Error())
+		return fmt.Errorf("failed to subscribe to data topic: %w", token.Error())
 	} else {
 		log.Printf("Successfully subscribed to topic [data/device/+/properties]")
 	}
@@ -103,26 +192,38 @@ func (m *MQTTService) setupSubscription() {
 	} else {
 		log.Printf("Successfully subscribed to topic [data/device/+/action_result]")
 	}
+	return nil
+}
+
+// authenticateDevice loads the device a message was published for. When the broker
+// authenticates devices the connection is already authenticated and the topic confined to the
+// device; otherwise the verify_code of the payload is checked.
+func (m *MQTTService) authenticateDevice(deviceUUID string, verifyCode string) (*model.Instance, error) {
+	if m.brokerAuthenticated {
+		return m.deviceService.GetDeviceByInstanceUUID(deviceUUID)
+	}
+	return m.deviceService.GetDeviceByUUIDAndVerifyHash(deviceUUID, utils.HashVerifyCode(verifyCode))
 }
 func (m *MQTTService) handlePropertiesData(c mqtt.Client, msg mqtt.Message) {
 	topic := msg.Topic()
 	payload := msg.Payload()
 	log.Printf("Received property data from MQTT topic [%s] (QOS %d): %s", topic, msg.Qos(), string(payload))
 	deviceUUID, _ := extractDeviceUUIDFromTopic(topic)
+	receivedAt := time.Now()
 	var message DeviceMessage
 
 	if err := json.Unmarshal(payload, &message); err != nil {
-		fmt.Errorf("error unmarshalling device message: %v", err)
+		log.Printf("[MQTT] Failed to parse device message: %v", err)
+		return
 	}
 
-	hashedVerifyCode := utils.HashVerifyCode(message.VerifyCode)
 	rawPropsData := message.Data.Properties
 	fmt.Printf("Properties Object: %+v\n", rawPropsData)
 
-	instance, err := m.deviceService.GetDeviceByUUIDAndVerifyHash(deviceUUID, hashedVerifyCode)
+	instance, err := m.authenticateDevice(deviceUUID, message.VerifyCode)
 	if err != nil {
 		if errors.Is(err, gorm.ErrRecordNotFound) {
-			log.Printf("Unauthorized access attempt: No device found with UUID %s and provided verify code (hash: %s)", deviceUUID, hashedVerifyCode)
+			log.Printf("Unauthorized access attempt: No device found with UUID %s", deviceUUID)
 		} else {
 			log.Printf("Database error during authentication for device %s: %v", deviceUUID, err)
 		}
@@ -134,84 +235,86 @@ func (m *MQTTService) handlePropertiesData(c mqtt.Client, msg mqtt.Message) {
 		instance.Properties.Items = make(map[string]*model.TypedInstancePropertyItem)
 	}
 
-	if err := m.deviceService.UpdateDeviceProperties(*instance, rawPropsData); err != nil {
+	// Samples without their own timestamp were measured at the message time
+	samples := message.Data.Samples
+	for i := range samples {
+		if samples[i].Timestamp == 0 {
+			samples[i].Timestamp = message.TimeStamp
+		}
+	}
+	if len(rawPropsData) > 0 {
+		samples = append(samples, PropertySample{Timestamp: message.TimeStamp, Properties: rawPropsData})
+	}
+
+	updated, err := m.deviceService.UpdateDeviceProperties(*instance, samples)
+	if err != nil {
 		log.Printf("Failed to update device properties: %v", err)
-		return
+		// The last values are kept when telemetry points are dropped
+		if !errors.Is(err, ErrIngestOverloaded) {
+			return
+		}
 	}
 
 	// Mark device online via PresenceService
 	m.presenceService.MarkOnline(deviceUUID)
 
-	// Publish property.update event to EventBus for WebSocket push
-	propUpdateEvent := logger.NewDeviceLogEvent(deviceUUID, logger.LogLevelInfo, "Properties updated", logger.LogEventDevicePropertyUpdate)
-	propsMap := make(map[string]interface{})
-	for k, v := range rawPropsData {
-		propsMap[k] = v.Value.V
+	// Publish property.update event to EventBus for WebSocket push. Samples older than the
+	// current values only go to the history and are not published.
+	if len(updated) > 0 {
+		propUpdateEvent := logger.NewDeviceLogEvent(deviceUUID, logger.LogLevelInfo, "Properties updated", logger.LogEventDevicePropertyUpdate)
+		propUpdateEvent.Metadata["properties"] = updated
+		m.eventBus.Publish(context.Background(), propUpdateEvent)
 	}
-	propUpdateEvent.Metadata["properties"] = propsMap
-	m.eventBus.Publish(context.Background(), propUpdateEvent)
 
 	// Handle event if present
 	if message.Data.Event.EventKey != "" {
-		m.handleEvent(instance, message.Data.Event)
+		m.handleEvent(instance, message.Data.Event, message.TimeStamp, receivedAt)
 	}
 }
 
-func (m *MQTTService) handleEvent(instance *model.Instance, event model.DeviceEvent) {
-	// Check for shutdown/offline events — these take priority
+func (m *MQTTService) handleEvent(instance *model.Instance, event model.DeviceEvent, messageTime int64, receivedAt time.Time) {
+	// Check for shutdown/offline events — these take priority. They are built in, so they are
+	// only recorded when the device type defines them.
 	if event.EventKey == "shutdown" || event.EventKey == "offline" {
 		m.presenceService.HandleShutdownEvent(instance.InstanceUUID)
 		log.Printf("[MQTT] Device %s sent '%s' event — marked OFFLINE", instance.InstanceUUID, event.EventKey)
-		return
+		typeDef, ok := model.GlobalDeviceTypeManager.GetByName(instance.Type)
+		if !ok {
+			return
+		}
+		if _, defined := typeDef.Events[event.EventKey]; !defined {
+			return
+		}
 	}
 
-	typeDef, ok := model.GlobalDeviceTypeManager.GetByName(instance.Type)
-	if !ok {
-		log.Printf("[MQTT] Unknown device type '%s' for event validation", instance.Type)
+	// Validate against the spec and persist; invalid events are quarantined
+	record, err := m.eventService.Record(instance, event, messageTime, receivedAt)
+	if err != nil {
+		log.Printf("[MQTT] Event '%s' from device %s not recorded: %v", event.EventKey, instance.InstanceUUID, err)
 		return
 	}
+	log.Printf("[MQTT] Received validated event '%s' from device %s (severity: %s)", event.EventKey, instance.InstanceUUID, record.Severity)
 
-	// Validate event against spec
-	if _, exists := typeDef.Events[event.EventKey]; exists {
-		// Event key is defined in the spec — validate if there's a payload
-		if event.Content != "" {
-			var payload map[string]interface{}
-			if err := json.Unmarshal([]byte(event.Content), &payload); err == nil {
-				if err := spec.ValidateEvent(typeDef, event.EventKey, payload); err != nil {
-					log.Printf("[MQTT] Event validation failed for device %s, event '%s': %v", instance.InstanceUUID, event.EventKey, err)
-					return
-				}
-			}
-		}
-		severity := typeDef.Events[event.EventKey].Severity
-		log.Printf("[MQTT] Received validated event '%s' from device %s (severity: %s)", event.EventKey, instance.InstanceUUID, severity)
-
-		// Publish to EventBus for WebSocket push (only warning/critical)
-		if severity == "warning" || severity == "critical" {
-			var eventData interface{}
-			if event.Content != "" {
-				json.Unmarshal([]byte(event.Content), &eventData)
-			}
-			pushEvent := logger.NewDeviceLogEvent(instance.InstanceUUID, logger.LogLevelInfo, fmt.Sprintf("Event: %s", event.EventKey), logger.LogEventDeviceError)
-			pushEvent.Metadata["event_key"] = event.EventKey
-			pushEvent.Metadata["severity"] = severity
-			pushEvent.Metadata["data"] = eventData
-			// Use a custom event type for event push
-			pushEvent.BaseEvent.Type = eventbus.EventType("device.event.received")
-			m.eventBus.Publish(context.Background(), pushEvent)
-		}
-	} else {
-		log.Printf("[MQTT] Received unknown event '%s' from device %s", event.EventKey, instance.InstanceUUID)
+	// Publish to EventBus for WebSocket push (only warning/critical)
+	if record.Severity == model.EventSeverityWarning || record.Severity == model.EventSeverityCritical {
+		pushEvent := logger.NewDeviceLogEvent(instance.InstanceUUID, logger.LogLevelInfo, fmt.Sprintf("Event: %s", event.EventKey), logger.LogEventDeviceEventReceived)
+		pushEvent.Metadata["event_id"] = record.ID
+		pushEvent.Metadata["event_key"] = event.EventKey
+		pushEvent.Metadata["severity"] = record.Severity
+		pushEvent.Metadata["timestamp"] = record.Timestamp
+		pushEvent.Metadata["data"] = record.Data
+		m.eventBus.Publish(context.Background(), pushEvent)
 	}
 }
 
 type ActionResultMessage struct {
-	VerifyCode string `json:"verify_code"`
+	VerifyCode string `json:"verify_code"` // Deprecated: see DeviceMessage.VerifyCode
 	TimeStamp  int64  `json:"timestamp"`
 	Data       struct {
-		Command string `json:"command"`
-		Success bool   `json:"success"`
-		Error   string `json:"error,omitempty"`
+		ActionID string `json:"action_id,omitempty"`
+		Command  string `json:"command"`
+		Success  bool   `json:"success"`
+		Error    string `json:"error,omitempty"`
 	} `json:"data"`
 }
 
@@ -228,8 +331,7 @@ func (m *MQTTService) handleActionResult(c mqtt.Client, msg mqtt.Message) {
 	}
 
 	// Authenticate device
-	hashedVerifyCode := utils.HashVerifyCode(message.VerifyCode)
-	instance, err := m.deviceService.GetDeviceByUUIDAndVerifyHash(deviceUUID, hashedVerifyCode)
+	instance, err := m.authenticateDevice(deviceUUID, message.VerifyCode)
 	if err != nil {
 		log.Printf("[MQTT] Action result auth failed for device %s: %v", deviceUUID, err)
 		return
@@ -237,12 +339,14 @@ func (m *MQTTService) handleActionResult(c mqtt.Client, msg mqtt.Message) {
 
 	// Publish to EventBus for WebSocket push
 	resultEvent := logger.NewDeviceLogEvent(instance.InstanceUUID, logger.LogLevelInfo, fmt.Sprintf("Action result: %s", message.Data.Command), logger.LogEventDeviceActionResult)
+	resultEvent.ActionName = message.Data.Command
+	resultEvent.Metadata["action_id"] = message.Data.ActionID
 	resultEvent.Metadata["command"] = message.Data.Command
 	resultEvent.Metadata["success"] = message.Data.Success
 	resultEvent.Metadata["error"] = message.Data.Error
 	m.eventBus.Publish(context.Background(), resultEvent)
 
-	log.Printf("[MQTT] Action result from device %s: command=%s success=%v", deviceUUID, message.Data.Command, message.Data.Success)
+	log.Printf("[MQTT] Action result from device %s: action_id=%s command=%s success=%v", deviceUUID, message.Data.ActionID, message.Data.Command, message.Data.Success)
 }
 
 func extractDeviceUUIDFromTopic(topic string) (string, error) {
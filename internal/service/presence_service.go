@@ -95,6 +95,12 @@ func (ps *PresenceService) MarkOffline(deviceUUID string) {
 	ps.emitStatusChange(deviceUUID, false)
 }
 
+// IsOnline reports whether the device is currently known online.
+func (ps *PresenceService) IsOnline(deviceUUID string) bool {
+	_, ok := ps.onlineDevices.Load(deviceUUID)
+	return ok
+}
+
 // HandleShutdownEvent processes a device-initiated shutdown/offline event.
 func (ps *PresenceService) HandleShutdownEvent(deviceUUID string) {
 	ps.MarkOffline(deviceUUID)
@@ -0,0 +1,473 @@
+package service
+
+import (
+	"OMEGA3-IOT/internal/logger"
+	"OMEGA3-IOT/internal/model"
+	"OMEGA3-IOT/internal/repository"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const (
+	retentionMaxDays          = 36500
+	retentionProjectionSample = 20 // devices per type whose recent points are counted
+	retentionTimestampBytes   = 8
+	retentionStringBytes      = 32 // assumed average size of a string value
+)
+
+var (
+	ErrInvalidRetentionOverride  = errors.New("invalid retention override")
+	ErrRetentionOverrideNotFound = errors.New("retention override not found")
+)
+
+// RetentionRun is the outcome of one enforcement run.
+type RetentionRun struct {
+	StartedAt     int64    `json:"started_at"`
+	CompletedAt   int64    `json:"completed_at"`
+	Devices       int      `json:"devices"`
+	Deletes       int      `json:"deletes"` // DELETE statements sent to IoTDB
+	EventsDeleted int64    `json:"events_deleted"`
+	Errors        []string `json:"errors,omitempty"`
+}
+
+// PropertyProjection is the projected storage of one property across all devices of a type.
+type PropertyProjection struct {
+	Key                   string  `json:"key"`
+	RetentionDays         int     `json:"retention_days"`
+	PointsPerDeviceDay    float64 `json:"points_per_device_day"`
+	ProjectedPoints       int64   `json:"projected_points"`
+	ProjectedBytes        int64   `json:"projected_bytes"`
+	BytesPerPointEstimate int     `json:"bytes_per_point"`
+}
+
+// RetentionProjection estimates the telemetry storage of a device type once its retention
+// periods are reached, from the point rate of the last 24 hours of a sample of its devices.
+// Sizes are uncompressed; data kept forever is projected over one year.
+type RetentionProjection struct {
+	DeviceType      string               `json:"device_type"`
+	Devices         int64                `json:"devices"`
+	SampledDevices  int                  `json:"sampled_devices"`
+	Properties      []PropertyProjection `json:"properties"`
+	ProjectedPoints int64                `json:"projected_points"`
+	ProjectedBytes  int64                `json:"projected_bytes"`
+	Unbounded       bool                 `json:"unbounded"` // some data is kept forever
+}
+
+// RetentionService deletes telemetry, device events and device logs older than their retention period.
+// Periods are resolved per device type, property and event from the type definitions, admin
+// overrides and a global default (see model.ResolveRetention). IoTDB TTL only applies to a
+// whole database, so the periods are enforced by scheduled deletes.
+type RetentionService struct {
+	db             *gorm.DB
+	overrideRepo   repository.RetentionOverrideRepository
+	instanceRepo   repository.InstanceRepository
+	telemetryRepo  repository.TelemetryRepository
+	eventRepo      repository.DeviceEventRepository
+	loggerService  *logger.LoggerService
+	defaultDays    int
+	userLogDays    int
+	quarantineDays int
+	interval       time.Duration
+
+	runMu   sync.Mutex // one enforcement run at a time
+	mu      sync.Mutex
+	lastRun *RetentionRun
+
+	wakeCh chan struct{}
+	stopCh chan struct{}
+	wg     sync.WaitGroup
+}
+
+func NewRetentionService(
+	gormDB *gorm.DB,
+	overrideRepo repository.RetentionOverrideRepository,
+	instanceRepo repository.InstanceRepository,
+	telemetryRepo repository.TelemetryRepository,
+	eventRepo repository.DeviceEventRepository,
+	loggerService *logger.LoggerService,
+	defaultDays int,
+	userLogDays int,
+	quarantineDays int,
+	intervalHours int,
+) *RetentionService {
+	if defaultDays < 0 {
+		defaultDays = 0
+	}
+	if userLogDays < 0 {
+		userLogDays = 0
+	}
+	if quarantineDays < 0 {
+		quarantineDays = 0
+	}
+	if intervalHours <= 0 {
+		intervalHours = 24
+	}
+
+	return &RetentionService{
+		db:             gormDB,
+		overrideRepo:   overrideRepo,
+		instanceRepo:   instanceRepo,
+		telemetryRepo:  telemetryRepo,
+		eventRepo:      eventRepo,
+		loggerService:  loggerService,
+		defaultDays:    defaultDays,
+		userLogDays:    userLogDays,
+		quarantineDays: quarantineDays,
+		interval:       time.Duration(intervalHours) * time.Hour,
+		wakeCh:         make(chan struct{}, 1),
+		stopCh:         make(chan struct{}),
+	}
+}
+
+// Start launches the enforcement loop. The first run starts right away.
+func (s *RetentionService) Start() {
+	s.wg.Add(1)
+	go s.run()
+	log.Printf("[RetentionService] Started (default: %d days, user logs: %d days, quarantine: %d days, interval: %v)", s.defaultDays, s.userLogDays, s.quarantineDays, s.interval)
+}
+
+// Stop stops the enforcement loop after the current run.
+func (s *RetentionService) Stop() {
+	close(s.stopCh)
+	s.wg.Wait()
+	log.Println("[RetentionService] Stopped")
+}
+
+// Trigger starts an enforcement run in the background unless one is already queued.
+func (s *RetentionService) Trigger() {
+	select {
+	case s.wakeCh <- struct{}{}:
+	default:
+	}
+}
+
+// LastRun returns the outcome of the latest finished run, or nil before the first one.
+func (s *RetentionService) LastRun() *RetentionRun {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.lastRun
+}
+
+// DefaultDays returns the global default retention and the retention of user logs and
+// quarantined events.
+func (s *RetentionService) DefaultDays() (defaultDays, userLogDays, quarantineDays int) {
+	return s.defaultDays, s.userLogDays, s.quarantineDays
+}
+
+// ─── Policies ───
+
+// Policies returns the resolved retention of every loaded device type, ordered by type ID.
+func (s *RetentionService) Policies() ([]model.RetentionPolicy, error) {
+	overrides, err := s.overrideRepo.List()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load retention overrides: %w", err)
+	}
+	deviceTypes := sortedDeviceTypes(model.GlobalDeviceTypeManager.Snapshot())
+	policies := make([]model.RetentionPolicy, 0, len(deviceTypes))
+	for i := range deviceTypes {
+		policies = append(policies, model.ResolveRetention(&deviceTypes[i], overrides, s.defaultDays))
+	}
+	return policies, nil
+}
+
+// ListOverrides returns all admin overrides.
+func (s *RetentionService) ListOverrides() ([]model.RetentionOverride, error) {
+	return s.overrideRepo.List()
+}
+
+// SetOverride creates or replaces the override of one target.
+func (s *RetentionService) SetOverride(adminUUID string, input model.RetentionOverride) (*model.RetentionOverride, error) {
+	dt, ok := model.GlobalDeviceTypeManager.GetByName(input.DeviceType)
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownDeviceType, input.DeviceType)
+	}
+	if input.RetentionDays < 0 || input.RetentionDays > retentionMaxDays {
+		return nil, fmt.Errorf("%w: retention_days must be between 0 and %d", ErrInvalidRetentionOverride, retentionMaxDays)
+	}
+	switch input.Scope {
+	case model.RetentionScopeType, model.RetentionScopeLog:
+		if input.Key != "" {
+			return nil, fmt.Errorf("%w: scope %s takes no key", ErrInvalidRetentionOverride, input.Scope)
+		}
+	case model.RetentionScopeProperty:
+		if _, ok := dt.Properties[input.Key]; !ok {
+			return nil, fmt.Errorf("%w: device type %s has no property '%s'", ErrInvalidRetentionOverride, dt.Name, input.Key)
+		}
+	case model.RetentionScopeEvent:
+		if _, ok := dt.Events[input.Key]; !ok {
+			return nil, fmt.Errorf("%w: device type %s has no event '%s'", ErrInvalidRetentionOverride, dt.Name, input.Key)
+		}
+	default:
+		return nil, fmt.Errorf("%w: unknown scope '%s'", ErrInvalidRetentionOverride, input.Scope)
+	}
+
+	var saved *model.RetentionOverride
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		overrideRepo := s.overrideRepo.WithTx(tx)
+		now := time.Now().Unix()
+		existing, err := overrideRepo.FindByTarget(input.DeviceType, input.Scope, input.Key)
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			saved = &model.RetentionOverride{
+				DeviceType:    input.DeviceType,
+				Scope:         input.Scope,
+				Key:           input.Key,
+				RetentionDays: input.RetentionDays,
+				UpdatedBy:     adminUUID,
+				CreatedAt:     now,
+				UpdatedAt:     now,
+			}
+			return overrideRepo.Create(saved)
+		}
+		if err != nil {
+			return err
+		}
+		existing.RetentionDays = input.RetentionDays
+		existing.UpdatedBy = adminUUID
+		existing.UpdatedAt = now
+		saved = existing
+		return overrideRepo.Update(existing)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to save retention override: %w", err)
+	}
+	return saved, nil
+}
+
+// DeleteOverride removes an override; the target falls back to the definition.
+func (s *RetentionService) DeleteOverride(id uint) (*model.RetentionOverride, error) {
+	override, err := s.overrideRepo.FindByID(id)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrRetentionOverrideNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := s.overrideRepo.Delete(id); err != nil {
+		return nil, fmt.Errorf("failed to delete retention override: %w", err)
+	}
+	return override, nil
+}
+
+// ─── Projection ───
+
+// Projection estimates the telemetry storage of every loaded device type.
+func (s *RetentionService) Projection() ([]RetentionProjection, error) {
+	policies, err := s.Policies()
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	since := now.Add(-24 * time.Hour).UnixMilli()
+
+	projections := make([]RetentionProjection, 0, len(policies))
+	for _, policy := range policies {
+		dt, ok := model.GlobalDeviceTypeManager.GetByName(policy.DeviceType)
+		if !ok {
+			continue
+		}
+		projection := RetentionProjection{DeviceType: dt.Name}
+		instances, err := s.instanceRepo.FindByType(dt.Name)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load devices of type %s: %w", dt.Name, err)
+		}
+		projection.Devices = int64(len(instances))
+		if len(instances) > retentionProjectionSample {
+			instances = instances[:retentionProjectionSample]
+		}
+		projection.SampledDevices = len(instances)
+
+		keys := make([]string, 0, len(dt.Properties))
+		for key := range dt.Properties {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		counts := make(map[string]int64, len(keys))
+		if len(keys) > 0 {
+			for _, instance := range instances {
+				rows, err := s.telemetryRepo.AggregateTelemetry(repository.TelemetryQuery{
+					DeviceUUID: instance.InstanceUUID,
+					Properties: keys,
+					StartTime:  since,
+					EndTime:    now.UnixMilli(),
+				}, "count", now.UnixMilli()-since)
+				if err != nil {
+					log.Printf("[RetentionService] Failed to count points of %s: %v", instance.InstanceUUID, err)
+					continue
+				}
+				for _, row := range rows {
+					for key, value := range row.Values {
+						if n, ok := value.(int64); ok {
+							counts[key] += n
+						}
+					}
+				}
+			}
+		}
+
+		for _, key := range keys {
+			days := policy.PropertyDays(key)
+			if days == 0 {
+				projection.Unbounded = true
+				days = 365
+			}
+			property := PropertyProjection{
+				Key:                   key,
+				RetentionDays:         policy.PropertyDays(key),
+				BytesPerPointEstimate: retentionTimestampBytes + valueBytes(dt.Properties[key].Format),
+			}
+			if projection.SampledDevices > 0 {
+				property.PointsPerDeviceDay = float64(counts[key]) / float64(projection.SampledDevices)
+			}
+			property.ProjectedPoints = int64(property.PointsPerDeviceDay * float64(projection.Devices) * float64(days))
+			property.ProjectedBytes = property.ProjectedPoints * int64(property.BytesPerPointEstimate)
+			projection.ProjectedPoints += property.ProjectedPoints
+			projection.ProjectedBytes += property.ProjectedBytes
+			projection.Properties = append(projection.Properties, property)
+		}
+		projections = append(projections, projection)
+	}
+	return projections, nil
+}
+
+// valueBytes is the uncompressed size of a value of a property format.
+func valueBytes(format string) int {
+	switch format {
+	case "bool", "boolean":
+		return 1
+	case "int", "integer", "float", "single":
+		return 4
+	case "long", "time", "double":
+		return 8
+	default:
+		return retentionStringBytes
+	}
+}
+
+// ─── Enforcement ───
+
+func (s *RetentionService) run() {
+	defer s.wg.Done()
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+
+	s.enforce()
+	for {
+		select {
+		case <-ticker.C:
+			s.enforce()
+		case <-s.wakeCh:
+			s.enforce()
+		case <-s.stopCh:
+			return
+		}
+	}
+}
+
+// enforce deletes everything older than its retention period. Properties of a device are
+// grouped by period so each device needs one DELETE per distinct period.
+func (s *RetentionService) enforce() {
+	s.runMu.Lock()
+	defer s.runMu.Unlock()
+
+	now := time.Now()
+	result := &RetentionRun{StartedAt: now.Unix()}
+	fail := func(format string, args ...interface{}) {
+		message := fmt.Sprintf(format, args...)
+		log.Printf("[RetentionService] %s", message)
+		result.Errors = append(result.Errors, message)
+	}
+	cutoff := func(days int) int64 {
+		return now.AddDate(0, 0, -days).UnixMilli()
+	}
+
+	policies, err := s.Policies()
+	if err != nil {
+		fail("%v", err)
+	}
+	for _, policy := range policies {
+		select {
+		case <-s.stopCh:
+			fail("interrupted by shutdown")
+			s.finishRun(result)
+			return
+		default:
+		}
+
+		definedEvents := make([]string, 0, len(policy.Events))
+		for key, resolved := range policy.Events {
+			definedEvents = append(definedEvents, key)
+			if resolved.Days > 0 {
+				deleted, err := s.eventRepo.DeleteBefore(policy.DeviceType, key, cutoff(resolved.Days))
+				result.EventsDeleted += deleted
+				if err != nil {
+					fail("failed to delete events %s of type %s: %v", key, policy.DeviceType, err)
+				}
+			}
+		}
+		// Events no longer defined by the type follow the type retention
+		if policy.Type.Days > 0 {
+			deleted, err := s.eventRepo.DeleteUndefinedBefore(policy.DeviceType, definedEvents, cutoff(policy.Type.Days))
+			result.EventsDeleted += deleted
+			if err != nil {
+				fail("failed to delete events of type %s: %v", policy.DeviceType, err)
+			}
+		}
+
+		instances, err := s.instanceRepo.FindByType(policy.DeviceType)
+		if err != nil {
+			fail("failed to load devices of type %s: %v", policy.DeviceType, err)
+			continue
+		}
+		for _, instance := range instances {
+			result.Devices++
+			byDays := make(map[int][]string)
+			for key := range instance.Properties.Items {
+				if days := policy.PropertyDays(key); days > 0 {
+					byDays[days] = append(byDays[days], key)
+				}
+			}
+			for days, keys := range byDays {
+				sort.Strings(keys)
+				result.Deletes++
+				if err := s.telemetryRepo.DeleteTelemetryBefore(instance.InstanceUUID, keys, cutoff(days)); err != nil {
+					fail("failed to delete telemetry of %s: %v", instance.InstanceUUID, err)
+				}
+			}
+			if policy.Log.Days > 0 {
+				result.Deletes++
+				if err := s.loggerService.DeleteDeviceLogsBefore(instance.InstanceUUID, cutoff(policy.Log.Days)); err != nil {
+					fail("%v", err)
+				}
+			}
+		}
+	}
+
+	if s.quarantineDays > 0 {
+		deleted, err := s.eventRepo.DeleteQuarantinedBefore(cutoff(s.quarantineDays))
+		result.EventsDeleted += deleted
+		if err != nil {
+			fail("failed to delete quarantined events: %v", err)
+		}
+	}
+	if s.userLogDays > 0 {
+		result.Deletes++
+		if err := s.loggerService.DeleteUserLogsBefore(cutoff(s.userLogDays)); err != nil {
+			fail("%v", err)
+		}
+	}
+	s.finishRun(result)
+}
+
+func (s *RetentionService) finishRun(result *RetentionRun) {
+	result.CompletedAt = time.Now().Unix()
+	s.mu.Lock()
+	s.lastRun = result
+	s.mu.Unlock()
+	log.Printf("[RetentionService] Run finished: %d devices, %d deletes, %d events deleted, %d errors", result.Devices, result.Deletes, result.EventsDeleted, len(result.Errors))
+}
@@ -0,0 +1,300 @@
+package service
+
+import (
+	"OMEGA3-IOT/internal/eventbus"
+	"OMEGA3-IOT/internal/logger"
+	"OMEGA3-IOT/internal/model"
+	"OMEGA3-IOT/internal/repository"
+	"OMEGA3-IOT/internal/spec"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+var (
+	ErrInvalidShadow         = errors.New("invalid desired state")
+	ErrShadowVersionConflict = errors.New("shadow version conflict")
+)
+
+// ShadowPublisher publishes shadow deltas to devices. A nil delta clears the retained delta.
+type ShadowPublisher interface {
+	PublishShadowDelta(deviceUUID string, delta *model.ShadowDeltaMessage) error
+}
+
+// ShadowService maintains the desired/reported shadow of each instance's writable properties.
+// Users set desired values; the delta is published to the device as a retained MQTT message so
+// sleeping devices pick it up on wake, and desired keys are cleared once the device reports them.
+type ShadowService struct {
+	shadowRepo   repository.DeviceShadowRepository
+	instanceRepo repository.InstanceRepository
+	publisher    ShadowPublisher
+	eventBus     *eventbus.EventBus
+
+	// per-instance lock serializing desired updates and device reports
+	locks sync.Map // map[string]*sync.Mutex
+}
+
+func NewShadowService(
+	shadowRepo repository.DeviceShadowRepository,
+	instanceRepo repository.InstanceRepository,
+	publisher ShadowPublisher,
+	eventBus *eventbus.EventBus,
+) *ShadowService {
+	return &ShadowService{
+		shadowRepo:   shadowRepo,
+		instanceRepo: instanceRepo,
+		publisher:    publisher,
+		eventBus:     eventBus,
+	}
+}
+
+// Start subscribes to property updates to keep the reported document in sync.
+func (s *ShadowService) Start() {
+	eventbus.SubscribeTyped(s.eventBus, eventbus.EventType(logger.LogEventDevicePropertyUpdate), s.handlePropertyUpdate, eventbus.WithGroup("service.ShadowService.handlePropertyUpdate"))
+	log.Println("[ShadowService] Started")
+}
+
+func (s *ShadowService) lock(instanceUUID string) func() {
+	l, _ := s.locks.LoadOrStore(instanceUUID, &sync.Mutex{})
+	mu := l.(*sync.Mutex)
+	mu.Lock()
+	return mu.Unlock
+}
+
+// GetShadow returns the shadow of an instance, creating an empty one if none exists yet.
+func (s *ShadowService) GetShadow(instanceUUID string) (*model.DeviceShadow, error) {
+	if _, err := s.findInstance(instanceUUID); err != nil {
+		return nil, err
+	}
+	unlock := s.lock(instanceUUID)
+	defer unlock()
+	return s.getOrCreate(instanceUUID)
+}
+
+// SetDesired merges desired values into the shadow. A nil value removes the key from desired.
+// If expectedVersion is non-nil the update is rejected unless it matches the current version.
+func (s *ShadowService) SetDesired(instanceUUID string, desired map[string]interface{}, expectedVersion *int64, requestedBy string) (*model.DeviceShadow, error) {
+	if len(desired) == 0 {
+		return nil, fmt.Errorf("%w: no properties given", ErrInvalidShadow)
+	}
+	instance, err := s.findInstance(instanceUUID)
+	if err != nil {
+		return nil, err
+	}
+	typeDef, ok := model.GlobalDeviceTypeManager.GetByName(instance.Type)
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownDeviceType, instance.Type)
+	}
+
+	normalized := make(map[string]interface{}, len(desired))
+	for key, value := range desired {
+		meta, exists := typeDef.Properties[key]
+		if !exists {
+			return nil, fmt.Errorf("%w: unknown property '%s'", ErrInvalidShadow, key)
+		}
+		if !meta.Writable {
+			return nil, fmt.Errorf("%w: property '%s' is not writable", ErrInvalidShadow, key)
+		}
+		if value == nil {
+			normalized[key] = nil
+			continue
+		}
+		meta.Key = key
+		if err := spec.ValidatePropertyValue(meta, value); err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidShadow, err)
+		}
+		converted, err := spec.ConvertToTargetType(value, meta.Format)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidShadow, err)
+		}
+		normalized[key] = converted
+	}
+
+	unlock := s.lock(instanceUUID)
+	defer unlock()
+
+	shadow, err := s.getOrCreate(instanceUUID)
+	if err != nil {
+		return nil, err
+	}
+	if expectedVersion != nil && *expectedVersion != shadow.Version {
+		return nil, fmt.Errorf("%w: expected %d, current %d", ErrShadowVersionConflict, *expectedVersion, shadow.Version)
+	}
+
+	hadDelta := len(shadow.Delta()) > 0
+	for key, value := range normalized {
+		if value == nil {
+			delete(shadow.Desired, key)
+			continue
+		}
+		shadow.Desired[key] = value
+	}
+	s.dropSatisfied(shadow)
+
+	if err := s.save(shadow); err != nil {
+		return nil, err
+	}
+	log.Printf("[ShadowService] Desired state of %s updated by %s (version %d)", instanceUUID, requestedBy, shadow.Version)
+
+	s.publishDelta(shadow, hadDelta)
+	s.emitUpdate(shadow, "desired", requestedBy)
+	return shadow, nil
+}
+
+// handlePropertyUpdate records reported writable properties and clears satisfied desired keys.
+// Failing to load or save the shadow, including a version conflict with another process, is
+// returned so the event bus delivers the report again; the retry starts from the stored shadow.
+func (s *ShadowService) handlePropertyUpdate(ctx context.Context, event logger.DeviceLogEvent) error {
+	props, _ := event.Metadata["properties"].(map[string]interface{})
+	if len(props) == 0 {
+		return nil
+	}
+	instance, err := s.findInstance(event.DeviceUUID)
+	if errors.Is(err, ErrDeviceNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	typeDef, ok := model.GlobalDeviceTypeManager.GetByName(instance.Type)
+	if !ok {
+		return nil
+	}
+
+	reported := make(map[string]interface{})
+	for key, value := range props {
+		meta, exists := typeDef.Properties[key]
+		if !exists || !meta.Writable || value == nil {
+			continue
+		}
+		converted, err := spec.ConvertToTargetType(value, meta.Format)
+		if err != nil {
+			continue
+		}
+		reported[key] = converted
+	}
+	if len(reported) == 0 {
+		return nil
+	}
+
+	unlock := s.lock(event.DeviceUUID)
+	defer unlock()
+
+	shadow, err := s.getOrCreate(event.DeviceUUID)
+	if err != nil {
+		return fmt.Errorf("shadow of %s: %w", event.DeviceUUID, err)
+	}
+
+	hadDelta := len(shadow.Delta()) > 0
+	changed := false
+	for key, value := range reported {
+		if old, ok := shadow.Reported[key]; !ok || !model.ShadowValuesEqual(old, value) {
+			shadow.Reported[key] = value
+			changed = true
+		}
+	}
+	if s.dropSatisfied(shadow) {
+		changed = true
+	}
+	if !changed {
+		return nil
+	}
+
+	if err := s.save(shadow); err != nil {
+		return fmt.Errorf("reported state of %s: %w", event.DeviceUUID, err)
+	}
+	s.publishDelta(shadow, hadDelta)
+	s.emitUpdate(shadow, "reported", event.DeviceUUID)
+	return nil
+}
+
+// dropSatisfied removes desired keys the device has already reported, returning whether any were removed.
+func (s *ShadowService) dropSatisfied(shadow *model.DeviceShadow) bool {
+	dropped := false
+	for key, desired := range shadow.Desired {
+		if reported, ok := shadow.Reported[key]; ok && model.ShadowValuesEqual(desired, reported) {
+			delete(shadow.Desired, key)
+			dropped = true
+		}
+	}
+	return dropped
+}
+
+// save bumps the version and persists the shadow with an optimistic version check.
+func (s *ShadowService) save(shadow *model.DeviceShadow) error {
+	expected := shadow.Version
+	shadow.Version++
+	shadow.UpdatedAt = time.Now().Unix()
+	updated, err := s.shadowRepo.UpdateIfVersion(shadow, expected)
+	if err != nil {
+		shadow.Version = expected
+		return fmt.Errorf("failed to save shadow: %w", err)
+	}
+	if !updated {
+		shadow.Version = expected
+		return fmt.Errorf("%w: shadow of %s was modified concurrently", ErrShadowVersionConflict, shadow.InstanceUUID)
+	}
+	return nil
+}
+
+// publishDelta sends the current delta to the device, or clears the retained delta once it is empty.
+func (s *ShadowService) publishDelta(shadow *model.DeviceShadow, hadDelta bool) {
+	delta := shadow.Delta()
+	var msg *model.ShadowDeltaMessage
+	if len(delta) > 0 {
+		msg = &model.ShadowDeltaMessage{Version: shadow.Version, State: delta, Timestamp: time.Now().Unix()}
+	} else if !hadDelta {
+		return
+	}
+	if err := s.publisher.PublishShadowDelta(shadow.InstanceUUID, msg); err != nil {
+		log.Printf("[ShadowService] Failed to publish shadow delta for %s: %v", shadow.InstanceUUID, err)
+	}
+}
+
+func (s *ShadowService) emitUpdate(shadow *model.DeviceShadow, source, actor string) {
+	event := logger.NewDeviceLogEvent(shadow.InstanceUUID, logger.LogLevelInfo, fmt.Sprintf("Shadow %s updated (version %d)", source, shadow.Version), logger.LogEventDeviceShadowUpdate)
+	event.Metadata["source"] = source
+	event.Metadata["actor"] = actor
+	event.Metadata["version"] = shadow.Version
+	event.Metadata["desired"] = map[string]interface{}(shadow.Desired)
+	event.Metadata["reported"] = map[string]interface{}(shadow.Reported)
+	event.Metadata["delta"] = map[string]interface{}(shadow.Delta())
+	s.eventBus.Publish(context.Background(), event)
+}
+
+func (s *ShadowService) getOrCreate(instanceUUID string) (*model.DeviceShadow, error) {
+	shadow, err := s.shadowRepo.FindByInstanceUUID(instanceUUID)
+	if err == nil {
+		if shadow.Desired == nil {
+			shadow.Desired = make(model.ShadowDocument)
+		}
+		if shadow.Reported == nil {
+			shadow.Reported = make(model.ShadowDocument)
+		}
+		return shadow, nil
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, fmt.Errorf("failed to load shadow: %w", err)
+	}
+	shadow = model.NewDeviceShadow(instanceUUID)
+	if err := s.shadowRepo.Create(shadow); err != nil {
+		return nil, fmt.Errorf("failed to create shadow: %w", err)
+	}
+	return shadow, nil
+}
+
+func (s *ShadowService) findInstance(instanceUUID string) (*model.Instance, error) {
+	instance, err := s.instanceRepo.FindByUUID(instanceUUID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrDeviceNotFound
+		}
+		return nil, fmt.Errorf("failed to find device: %w", err)
+	}
+	return instance, nil
+}
@@ -0,0 +1,178 @@
+package service
+
+import (
+	"OMEGA3-IOT/internal/eventbus"
+	"OMEGA3-IOT/internal/logger"
+	"OMEGA3-IOT/internal/model"
+	"OMEGA3-IOT/internal/repository"
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// fakeShadowRepo stores one copy of each shadow. conflicts makes the next updates lose to a
+// concurrent writer, failures makes them fail.
+type fakeShadowRepo struct {
+	repository.DeviceShadowRepository
+	mu        sync.Mutex
+	shadows   map[string]*model.DeviceShadow
+	conflicts int
+	failures  int
+}
+
+func copyShadow(shadow *model.DeviceShadow) *model.DeviceShadow {
+	stored := *shadow
+	stored.Desired = make(model.ShadowDocument)
+	stored.Reported = make(model.ShadowDocument)
+	for key, value := range shadow.Desired {
+		stored.Desired[key] = value
+	}
+	for key, value := range shadow.Reported {
+		stored.Reported[key] = value
+	}
+	return &stored
+}
+
+func (r *fakeShadowRepo) Create(shadow *model.DeviceShadow) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.shadows[shadow.InstanceUUID] = copyShadow(shadow)
+	return nil
+}
+
+func (r *fakeShadowRepo) FindByInstanceUUID(instanceUUID string) (*model.DeviceShadow, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if shadow, ok := r.shadows[instanceUUID]; ok {
+		return copyShadow(shadow), nil
+	}
+	return nil, gorm.ErrRecordNotFound
+}
+
+func (r *fakeShadowRepo) UpdateIfVersion(shadow *model.DeviceShadow, expectedVersion int64) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	stored := r.shadows[shadow.InstanceUUID]
+	if r.failures > 0 {
+		r.failures--
+		return false, errors.New("connection reset")
+	}
+	if r.conflicts > 0 {
+		// Another process sets a desired value meanwhile
+		r.conflicts--
+		stored.Desired["mode"] = "eco"
+		stored.Version++
+	}
+	if stored.Version != expectedVersion {
+		return false, nil
+	}
+	r.shadows[shadow.InstanceUUID] = copyShadow(shadow)
+	return true, nil
+}
+
+func (r *fakeShadowRepo) stored(instanceUUID string) *model.DeviceShadow {
+	shadow, _ := r.FindByInstanceUUID(instanceUUID)
+	return shadow
+}
+
+type fakeShadowInstanceRepo struct {
+	repository.InstanceRepository
+}
+
+func (fakeShadowInstanceRepo) FindByUUID(instanceUUID string) (*model.Instance, error) {
+	if instanceUUID != "dev-1" {
+		return nil, gorm.ErrRecordNotFound
+	}
+	return &model.Instance{InstanceUUID: instanceUUID, Type: "shadow-test-lamp"}, nil
+}
+
+type fakeShadowPublisher struct{}
+
+func (fakeShadowPublisher) PublishShadowDelta(deviceUUID string, delta *model.ShadowDeltaMessage) error {
+	return nil
+}
+
+func newTestShadowService(t *testing.T, eb *eventbus.EventBus) (*ShadowService, *fakeShadowRepo) {
+	t.Helper()
+	model.GlobalDeviceTypeManager.Replace([]model.DeviceType{{
+		ID:   9002,
+		Name: "shadow-test-lamp",
+		Properties: map[string]model.PropertyMeta{
+			"brightness": {Writable: true, Format: "int"},
+			"mode":       {Writable: true, Format: "string"},
+		},
+	}})
+	t.Cleanup(eb.Close)
+	repo := &fakeShadowRepo{shadows: make(map[string]*model.DeviceShadow)}
+	return NewShadowService(repo, fakeShadowInstanceRepo{}, fakeShadowPublisher{}, eb), repo
+}
+
+func propertyReport(props map[string]interface{}) logger.DeviceLogEvent {
+	event := logger.NewDeviceLogEvent("dev-1", logger.LogLevelInfo, "Properties reported", logger.LogEventDevicePropertyUpdate)
+	event.Metadata["properties"] = props
+	return event
+}
+
+func TestShadowReportVersionConflictIsReturned(t *testing.T) {
+	s, repo := newTestShadowService(t, eventbus.New())
+	if _, err := s.SetDesired("dev-1", map[string]interface{}{"brightness": 80}, nil, "user-1"); err != nil {
+		t.Fatal(err)
+	}
+
+	repo.conflicts = 1
+	report := propertyReport(map[string]interface{}{"brightness": 80})
+	if err := s.handlePropertyUpdate(context.Background(), report); !errors.Is(err, ErrShadowVersionConflict) {
+		t.Fatalf("conflicting save returned %v, want ErrShadowVersionConflict", err)
+	}
+	if _, ok := repo.stored("dev-1").Reported["brightness"]; ok {
+		t.Fatal("conflicting report stored")
+	}
+
+	// The redelivered report is merged onto the concurrent change
+	if err := s.handlePropertyUpdate(context.Background(), report); err != nil {
+		t.Fatalf("redelivered report: %v", err)
+	}
+	shadow := repo.stored("dev-1")
+	if !model.ShadowValuesEqual(shadow.Reported["brightness"], 80) || shadow.Desired["mode"] != "eco" {
+		t.Fatalf("shadow after redelivery: reported %v, desired %v", shadow.Reported, shadow.Desired)
+	}
+	if _, ok := shadow.Desired["brightness"]; ok {
+		t.Error("satisfied desired value not cleared")
+	}
+
+	if err := s.handlePropertyUpdate(context.Background(), propertyReport(map[string]interface{}{"brightness": 80})); err != nil {
+		t.Errorf("unchanged report: %v", err)
+	}
+	unknown := propertyReport(map[string]interface{}{"brightness": 10})
+	unknown.DeviceUUID = "dev-2"
+	if err := s.handlePropertyUpdate(context.Background(), unknown); err != nil {
+		t.Errorf("report of a deleted device: %v", err)
+	}
+}
+
+func TestShadowReportRedeliveredAfterSaveError(t *testing.T) {
+	eb := eventbus.NewWithOptions(eventbus.Options{Delivery: eventbus.DeliveryOptions{MaxAttempts: 3, RetryBackoff: time.Millisecond}})
+	s, repo := newTestShadowService(t, eb)
+	s.Start()
+	if _, err := s.GetShadow("dev-1"); err != nil {
+		t.Fatal(err)
+	}
+
+	repo.failures = 1
+	repo.conflicts = 1
+	eb.Publish(context.Background(), propertyReport(map[string]interface{}{"brightness": 40}))
+	eb.Wait()
+
+	if got := repo.stored("dev-1").Reported["brightness"]; !model.ShadowValuesEqual(got, 40) {
+		t.Fatalf("reported brightness %v after redelivery, want 40", got)
+	}
+	for _, stats := range eb.Stats() {
+		if stats.Group == "service.ShadowService.handlePropertyUpdate" && (stats.Delivered != 1 || stats.DeadLettered != 0) {
+			t.Errorf("stats %+v", stats)
+		}
+	}
+}
@@ -0,0 +1,738 @@
+package service
+
+import (
+	"OMEGA3-IOT/internal/db"
+	"OMEGA3-IOT/internal/export"
+	"OMEGA3-IOT/internal/model"
+	"OMEGA3-IOT/internal/repository"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/apache/iotdb-client-go/client"
+	"gorm.io/gorm"
+)
+
+const (
+	telemetryExportPageSize      = 5000
+	telemetryExportMaxRange      = 366 * 24 * time.Hour
+	telemetryExportCheckInterval = 10 * time.Second
+	telemetryExportBatchSize     = 10
+)
+
+var (
+	ErrExportJobNotFound    = errors.New("export job not found")
+	ErrExportTargetNotFound = errors.New("export target not found")
+	ErrExportRangeTooLarge  = errors.New("range too large for a direct export")
+	ErrTooManyExportJobs    = errors.New("too many pending export jobs")
+	ErrExportNotReady       = errors.New("export not ready")
+	ErrExportExpired        = errors.New("export expired")
+	ErrExportJobRunning     = errors.New("export job is running")
+
+	errExportInterrupted = errors.New("export interrupted by shutdown")
+)
+
+// TelemetryExportRequest selects the telemetry to export. StartTime and EndTime are Unix
+// seconds, [start, end). An empty property list exports every property.
+type TelemetryExportRequest struct {
+	Scope      string   `json:"scope" binding:"required,oneof=device folder group"`
+	TargetUUID string   `json:"target_uuid" binding:"required"`
+	StartTime  int64    `json:"start_timestamp" binding:"required"`
+	EndTime    int64    `json:"end_timestamp" binding:"required"`
+	Properties []string `json:"properties"`
+	Format     string   `json:"format"`
+}
+
+// TelemetryExport is an export whose devices and columns have been resolved and checked,
+// ready to be written.
+type TelemetryExport struct {
+	service *TelemetryExportService
+	request TelemetryExportRequest
+	columns []export.Column
+	devices []exportDevice
+}
+
+// exportDevice is one device of an export and where its properties go in a row.
+type exportDevice struct {
+	instance   *model.Instance
+	properties []string
+	columns    []int
+}
+
+// TelemetryExportService exports telemetry of a device, a folder or the devices shared to a
+// group as CSV, NDJSON or Parquet. Short ranges are streamed to the caller; longer ones run
+// as background jobs whose result file can be downloaded until it expires.
+//
+// Rows are ordered by device, then by time. Multi-device exports add instance_uuid and
+// device_name columns; property columns are named "key (unit)" after the device type and
+// typed by the property format.
+type TelemetryExportService struct {
+	jobRepo            repository.TelemetryExportJobRepository
+	instanceRepo       repository.InstanceRepository
+	folderRepo         repository.DeviceFolderRepository
+	telemetryRepo      repository.TelemetryRepository
+	deviceShareService *DeviceShareService
+	groupService       *UserGroupService
+	dir                string
+	maxSyncRange       time.Duration
+	retention          time.Duration
+	maxPendingJobs     int
+
+	wakeCh chan struct{}
+	stopCh chan struct{}
+	wg     sync.WaitGroup
+}
+
+func NewTelemetryExportService(
+	jobRepo repository.TelemetryExportJobRepository,
+	instanceRepo repository.InstanceRepository,
+	folderRepo repository.DeviceFolderRepository,
+	deviceShareService *DeviceShareService,
+	groupService *UserGroupService,
+	telemetryRepo repository.TelemetryRepository,
+	dir string,
+	maxSyncRangeHours int,
+	retentionHours int,
+	maxPendingJobs int,
+) *TelemetryExportService {
+	if dir == "" {
+		dir = "./data/exports"
+	}
+	if maxSyncRangeHours <= 0 {
+		maxSyncRangeHours = 24
+	}
+	if retentionHours <= 0 {
+		retentionHours = 72
+	}
+	if maxPendingJobs <= 0 {
+		maxPendingJobs = 3
+	}
+
+	return &TelemetryExportService{
+		jobRepo:            jobRepo,
+		instanceRepo:       instanceRepo,
+		folderRepo:         folderRepo,
+		telemetryRepo:      telemetryRepo,
+		deviceShareService: deviceShareService,
+		groupService:       groupService,
+		dir:                dir,
+		maxSyncRange:       time.Duration(maxSyncRangeHours) * time.Hour,
+		retention:          time.Duration(retentionHours) * time.Hour,
+		maxPendingJobs:     maxPendingJobs,
+		wakeCh:             make(chan struct{}, 1),
+		stopCh:             make(chan struct{}),
+	}
+}
+
+// Start creates the export directory, requeues jobs interrupted by a restart and launches the worker.
+func (s *TelemetryExportService) Start() {
+	if err := os.MkdirAll(s.dir, 0o755); err != nil {
+		log.Printf("[TelemetryExportService] Failed to create export directory %s: %v", s.dir, err)
+	}
+	if n, err := s.jobRepo.ResetRunning(); err != nil {
+		log.Printf("[TelemetryExportService] Failed to requeue interrupted jobs: %v", err)
+	} else if n > 0 {
+		log.Printf("[TelemetryExportService] Requeued %d interrupted jobs", n)
+	}
+
+	s.wg.Add(1)
+	go s.run()
+	log.Printf("[TelemetryExportService] Started (dir: %s, max direct range: %v, retention: %v)", s.dir, s.maxSyncRange, s.retention)
+}
+
+// Stop stops the worker. A running job is interrupted and requeued.
+func (s *TelemetryExportService) Stop() {
+	close(s.stopCh)
+	s.wg.Wait()
+	log.Println("[TelemetryExportService] Stopped")
+}
+
+// ─── Direct export ───
+
+// PrepareExport checks a direct export for the user. Ranges longer than the direct export
+// limit are rejected with ErrExportRangeTooLarge and should be run as a job.
+func (s *TelemetryExportService) PrepareExport(userUUID string, request TelemetryExportRequest) (*TelemetryExport, error) {
+	if time.Duration(request.EndTime-request.StartTime)*time.Second > s.maxSyncRange {
+		return nil, fmt.Errorf("%w: limit is %v, create an export job instead", ErrExportRangeTooLarge, s.maxSyncRange)
+	}
+	return s.prepare(userUUID, request)
+}
+
+// Filename returns the suggested download file name.
+func (e *TelemetryExport) Filename() string {
+	return exportFilename(e.request.Scope, e.request.TargetUUID, e.request.StartTime, e.request.EndTime, e.request.Format)
+}
+
+// ContentType returns the MIME type of the export format.
+func (e *TelemetryExport) ContentType() string {
+	return export.ContentType(e.request.Format)
+}
+
+// WriteTo writes the export to w and returns the number of data rows.
+func (e *TelemetryExport) WriteTo(w io.Writer) (int64, error) {
+	return e.service.write(e, w)
+}
+
+// ─── Jobs ───
+
+// CreateJob validates the request and queues it as a background job.
+func (s *TelemetryExportService) CreateJob(userUUID string, request TelemetryExportRequest) (*model.TelemetryExportJob, error) {
+	prepared, err := s.prepare(userUUID, request)
+	if err != nil {
+		return nil, err
+	}
+	request = prepared.request
+
+	active, err := s.jobRepo.CountActive(userUUID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to count export jobs: %w", err)
+	}
+	if active >= int64(s.maxPendingJobs) {
+		return nil, fmt.Errorf("%w: at most %d jobs may be pending or running", ErrTooManyExportJobs, s.maxPendingJobs)
+	}
+
+	job := model.NewTelemetryExportJob(userUUID, request.Scope, request.TargetUUID, request.StartTime, request.EndTime, request.Properties, request.Format)
+	if err := s.jobRepo.Create(job); err != nil {
+		return nil, fmt.Errorf("failed to create export job: %w", err)
+	}
+	s.wake()
+	log.Printf("[TelemetryExportService] Job %s queued by %s (%s %s, %s)", job.JobUUID, userUUID, job.Scope, job.TargetUUID, job.Format)
+	return job, nil
+}
+
+// ListJobs returns the export jobs of a user, newest first.
+func (s *TelemetryExportService) ListJobs(userUUID string, limit, offset int) ([]model.TelemetryExportJob, int64, error) {
+	return s.jobRepo.FindByOwner(userUUID, limit, offset)
+}
+
+// GetJob returns an export job of the user.
+func (s *TelemetryExportService) GetJob(userUUID, jobUUID string) (*model.TelemetryExportJob, error) {
+	job, err := s.jobRepo.FindByJobUUID(jobUUID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrExportJobNotFound
+		}
+		return nil, fmt.Errorf("failed to find export job: %w", err)
+	}
+	if job.OwnerUUID != userUUID {
+		return nil, ErrExportJobNotFound
+	}
+	return job, nil
+}
+
+// OpenResult opens the result file of a succeeded job. The caller closes the file.
+func (s *TelemetryExportService) OpenResult(userUUID, jobUUID string) (*model.TelemetryExportJob, *os.File, error) {
+	job, err := s.GetJob(userUUID, jobUUID)
+	if err != nil {
+		return nil, nil, err
+	}
+	switch job.Status {
+	case model.TelemetryExportSucceeded:
+	case model.TelemetryExportExpired:
+		return nil, nil, ErrExportExpired
+	default:
+		return nil, nil, fmt.Errorf("%w: job is %s", ErrExportNotReady, job.Status)
+	}
+
+	file, err := os.Open(job.FilePath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil, ErrExportExpired
+		}
+		return nil, nil, fmt.Errorf("failed to open export file: %w", err)
+	}
+	return job, file, nil
+}
+
+// DeleteJob cancels a pending job or deletes a finished one together with its result file.
+func (s *TelemetryExportService) DeleteJob(userUUID, jobUUID string) error {
+	job, err := s.GetJob(userUUID, jobUUID)
+	if err != nil {
+		return err
+	}
+	if job.Status == model.TelemetryExportRunning {
+		return ErrExportJobRunning
+	}
+	if err := s.jobRepo.Delete(jobUUID); err != nil {
+		return fmt.Errorf("failed to delete export job: %w", err)
+	}
+	s.removeFile(job.FilePath)
+	return nil
+}
+
+// JobFilename returns the suggested download file name of a job result.
+func JobFilename(job *model.TelemetryExportJob) string {
+	return exportFilename(job.Scope, job.TargetUUID, job.StartTime, job.EndTime, job.Format)
+}
+
+// ─── Planning ───
+
+// prepare validates a request, resolves the devices the user may read and builds the columns.
+func (s *TelemetryExportService) prepare(userUUID string, request TelemetryExportRequest) (*TelemetryExport, error) {
+	if request.Format == "" {
+		request.Format = export.FormatCSV
+	}
+	if !export.IsFormat(request.Format) {
+		return nil, fmt.Errorf("%w: unsupported format '%s'", ErrInvalidTelemetryQuery, request.Format)
+	}
+	if request.StartTime >= request.EndTime {
+		return nil, fmt.Errorf("%w: start must be before end", ErrInvalidTelemetryQuery)
+	}
+	if time.Duration(request.EndTime-request.StartTime)*time.Second > telemetryExportMaxRange {
+		return nil, fmt.Errorf("%w: range is limited to %v", ErrInvalidTelemetryQuery, telemetryExportMaxRange)
+	}
+	request.Properties = dedupe(request.Properties)
+
+	instanceUUIDs, err := s.resolveTargets(userUUID, request.Scope, request.TargetUUID)
+	if err != nil {
+		return nil, err
+	}
+	var instances []*model.Instance
+	for _, instanceUUID := range instanceUUIDs {
+		instance, err := s.instanceRepo.FindByUUID(instanceUUID)
+		if err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				continue
+			}
+			return nil, err
+		}
+		if request.Scope != model.TelemetryExportScopeDevice && instance.Status != "active" {
+			continue
+		}
+		instances = append(instances, instance)
+	}
+	if request.Scope == model.TelemetryExportScopeDevice && len(instances) == 0 {
+		return nil, ErrExportTargetNotFound
+	}
+
+	prepared := &TelemetryExport{service: s, request: request}
+	if err := prepared.plan(instances); err != nil {
+		return nil, err
+	}
+	return prepared, nil
+}
+
+// resolveTargets returns the UUIDs of the devices in scope that the user may read.
+func (s *TelemetryExportService) resolveTargets(userUUID, scope, targetUUID string) ([]string, error) {
+	switch scope {
+	case model.TelemetryExportScopeDevice:
+		allowed, err := s.deviceShareService.CheckDeviceAccess(targetUUID, userUUID, repository.PermissionRead)
+		if err != nil {
+			return nil, err
+		}
+		if !allowed {
+			return nil, ErrExportTargetNotFound
+		}
+		return []string{targetUUID}, nil
+
+	case model.TelemetryExportScopeFolder:
+		folder, err := s.folderRepo.GetFolderByUUID(targetUUID)
+		if err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return nil, ErrExportTargetNotFound
+			}
+			return nil, err
+		}
+		if folder.OwnerUUID != userUUID || folder.Valid == 0 {
+			return nil, ErrExportTargetNotFound
+		}
+		deviceUUIDs, err := s.folderRepo.GetFolderDeviceUUIDs(targetUUID)
+		if err != nil {
+			return nil, err
+		}
+		// Folders may hold devices whose share has since been revoked.
+		var readable []string
+		for _, deviceUUID := range deviceUUIDs {
+			if allowed, err := s.deviceShareService.CheckDeviceAccess(deviceUUID, userUUID, repository.PermissionRead); err == nil && allowed {
+				readable = append(readable, deviceUUID)
+			}
+		}
+		return readable, nil
+
+	case model.TelemetryExportScopeGroup:
+		if err := s.groupService.CheckMembership(targetUUID, userUUID); err != nil {
+			return nil, ErrExportTargetNotFound
+		}
+		shares, err := s.groupService.GetGroupDevices(targetUUID, userUUID)
+		if err != nil {
+			return nil, err
+		}
+		deviceUUIDs := make([]string, 0, len(shares))
+		for _, share := range shares {
+			deviceUUIDs = append(deviceUUIDs, share.InstanceUUID)
+		}
+		return dedupe(deviceUUIDs), nil
+	}
+	return nil, fmt.Errorf("%w: unknown scope '%s'", ErrInvalidTelemetryQuery, scope)
+}
+
+// plan builds the columns of the export. Properties with the same key and unit share a
+// column; if their formats differ the column falls back to a wider type.
+func (e *TelemetryExport) plan(instances []*model.Instance) error {
+	multi := e.request.Scope != model.TelemetryExportScopeDevice
+	e.columns = []export.Column{{Name: "timestamp", Type: export.ColumnTimestamp}}
+	if multi {
+		e.columns = append(e.columns,
+			export.Column{Name: "instance_uuid", Type: export.ColumnString},
+			export.Column{Name: "device_name", Type: export.ColumnString})
+	}
+
+	requested := make(map[string]int, len(e.request.Properties))
+	for i, key := range e.request.Properties {
+		requested[key] = i
+	}
+	found := make(map[string]bool, len(requested))
+
+	type propertyColumn struct {
+		key, unit  string
+		columnType export.ColumnType
+	}
+	columnIndex := make(map[string]int) // "key\x00unit" -> index in properties
+	var properties []propertyColumn
+
+	for _, instance := range instances {
+		keys := make([]string, 0, len(instance.Properties.Items))
+		for key := range instance.Properties.Items {
+			if _, ok := requested[key]; ok || len(requested) == 0 {
+				keys = append(keys, key)
+			}
+		}
+		sort.Strings(keys)
+
+		device := exportDevice{instance: instance}
+		for _, key := range keys {
+			found[key] = true
+			meta := instance.Properties.Items[key].Meta
+			columnType := exportColumnType(meta)
+			id := key + "\x00" + meta.Unit
+			i, ok := columnIndex[id]
+			if !ok {
+				i = len(properties)
+				columnIndex[id] = i
+				properties = append(properties, propertyColumn{key: key, unit: meta.Unit, columnType: columnType})
+			} else {
+				properties[i].columnType = widenColumnType(properties[i].columnType, columnType)
+			}
+			device.properties = append(device.properties, key)
+			device.columns = append(device.columns, i) // remapped to the row position below
+		}
+		if len(device.properties) > 0 {
+			e.devices = append(e.devices, device)
+		}
+	}
+
+	for _, key := range e.request.Properties {
+		if !found[key] {
+			return fmt.Errorf("%w: unknown property '%s'", ErrInvalidTelemetryQuery, key)
+		}
+	}
+	if len(properties) == 0 {
+		return fmt.Errorf("%w: no properties to export", ErrInvalidTelemetryQuery)
+	}
+
+	// Requested properties keep the request order, otherwise columns are sorted by key.
+	order := make([]int, len(properties))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(a, b int) bool {
+		pa, pb := properties[order[a]], properties[order[b]]
+		if pa.key != pb.key {
+			if len(requested) > 0 {
+				return requested[pa.key] < requested[pb.key]
+			}
+			return pa.key < pb.key
+		}
+		return pa.unit < pb.unit
+	})
+	position := make([]int, len(properties))
+	for p, i := range order {
+		position[i] = len(e.columns) + p
+		name := properties[i].key
+		if properties[i].unit != "" {
+			name += " (" + properties[i].unit + ")"
+		}
+		e.columns = append(e.columns, export.Column{Name: name, Type: properties[i].columnType})
+	}
+	for _, device := range e.devices {
+		for j, i := range device.columns {
+			device.columns[j] = position[i]
+		}
+	}
+	return nil
+}
+
+// exportColumnType maps a property to the column type of the IoTDB series it is stored in.
+func exportColumnType(meta model.PropertyMeta) export.ColumnType {
+	dataType, _, _ := db.MapPropertyType(meta)
+	switch dataType {
+	case client.BOOLEAN:
+		return export.ColumnBool
+	case client.INT32:
+		return export.ColumnInt32
+	case client.INT64:
+		return export.ColumnInt64
+	case client.FLOAT:
+		return export.ColumnFloat
+	case client.DOUBLE:
+		return export.ColumnDouble
+	default:
+		return export.ColumnString
+	}
+}
+
+// widenColumnType returns a type that holds values of both a and b.
+func widenColumnType(a, b export.ColumnType) export.ColumnType {
+	if a == b {
+		return a
+	}
+	numeric := func(t export.ColumnType) bool {
+		return t == export.ColumnInt32 || t == export.ColumnInt64 || t == export.ColumnFloat || t == export.ColumnDouble
+	}
+	if numeric(a) && numeric(b) {
+		return export.ColumnDouble
+	}
+	return export.ColumnString
+}
+
+// ─── Writing ───
+
+// write pages through the telemetry of each device and writes one row per timestamp.
+func (s *TelemetryExportService) write(e *TelemetryExport, w io.Writer) (int64, error) {
+	writer, err := export.NewWriter(e.request.Format, w, e.columns)
+	if err != nil {
+		return 0, err
+	}
+
+	multi := e.request.Scope != model.TelemetryExportScopeDevice
+	startMs, endMs := e.request.StartTime*1000, e.request.EndTime*1000
+	row := make([]interface{}, len(e.columns))
+	var rows int64
+
+	for _, device := range e.devices {
+		cursor := startMs
+		for {
+			select {
+			case <-s.stopCh:
+				return rows, errExportInterrupted
+			default:
+			}
+
+			results, err := s.telemetryRepo.QueryTelemetry(repository.TelemetryQuery{
+				DeviceUUID: device.instance.InstanceUUID,
+				Properties: device.properties,
+				StartTime:  cursor,
+				EndTime:    endMs,
+				Limit:      telemetryExportPageSize,
+			})
+			if err != nil {
+				return rows, fmt.Errorf("failed to query telemetry of %s: %w", device.instance.InstanceUUID, err)
+			}
+
+			for _, result := range results {
+				for i := range row {
+					row[i] = nil
+				}
+				row[0] = result.Timestamp
+				if multi {
+					row[1] = device.instance.InstanceUUID
+					row[2] = device.instance.Name
+				}
+				for i, key := range device.properties {
+					row[device.columns[i]] = result.Values[key]
+				}
+				if err := writer.Write(row); err != nil {
+					return rows, err
+				}
+				rows++
+			}
+
+			if len(results) < telemetryExportPageSize {
+				break
+			}
+			cursor = results[len(results)-1].Timestamp + 1
+		}
+	}
+	return rows, writer.Close()
+}
+
+// ─── Worker ───
+
+func (s *TelemetryExportService) wake() {
+	select {
+	case s.wakeCh <- struct{}{}:
+	default:
+	}
+}
+
+func (s *TelemetryExportService) run() {
+	defer s.wg.Done()
+	ticker := time.NewTicker(telemetryExportCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		s.processPending()
+		s.expireResults()
+		select {
+		case <-s.stopCh:
+			return
+		case <-ticker.C:
+		case <-s.wakeCh:
+		}
+	}
+}
+
+func (s *TelemetryExportService) processPending() {
+	jobs, err := s.jobRepo.FindPending(telemetryExportBatchSize)
+	if err != nil {
+		log.Printf("[TelemetryExportService] Failed to query pending jobs: %v", err)
+		return
+	}
+	for i := range jobs {
+		select {
+		case <-s.stopCh:
+			return
+		default:
+		}
+		job := &jobs[i]
+		claimed, err := s.jobRepo.Claim(job.JobUUID, time.Now().Unix())
+		if err != nil || !claimed {
+			continue
+		}
+		s.execute(job)
+	}
+}
+
+// execute runs a claimed job. Access is checked again, as it may have changed since the job was queued.
+func (s *TelemetryExportService) execute(job *model.TelemetryExportJob) {
+	request := TelemetryExportRequest{
+		Scope:      job.Scope,
+		TargetUUID: job.TargetUUID,
+		StartTime:  job.StartTime,
+		EndTime:    job.EndTime,
+		Properties: job.Properties,
+		Format:     job.Format,
+	}
+	path := filepath.Join(s.dir, job.JobUUID+"."+job.Format)
+
+	rows, size, err := s.writeFile(job.OwnerUUID, request, path)
+	now := time.Now()
+	if errors.Is(err, errExportInterrupted) {
+		s.removeFile(path)
+		if updateErr := s.jobRepo.UpdateFields(job.JobUUID, map[string]interface{}{
+			"status":     model.TelemetryExportPending,
+			"started_at": 0,
+		}); updateErr != nil {
+			log.Printf("[TelemetryExportService] Failed to requeue job %s: %v", job.JobUUID, updateErr)
+		}
+		return
+	}
+
+	fields := map[string]interface{}{
+		"completed_at": now.Unix(),
+		"expires_at":   now.Add(s.retention).Unix(),
+	}
+	if err != nil {
+		s.removeFile(path)
+		message := err.Error()
+		if len(message) > 500 {
+			message = message[:500]
+		}
+		fields["status"] = model.TelemetryExportFailed
+		fields["error"] = message
+		log.Printf("[TelemetryExportService] Job %s failed: %v", job.JobUUID, err)
+	} else {
+		fields["status"] = model.TelemetryExportSucceeded
+		fields["rows"] = rows
+		fields["size_bytes"] = size
+		fields["file_path"] = path
+		log.Printf("[TelemetryExportService] Job %s finished: %d rows, %d bytes", job.JobUUID, rows, size)
+	}
+	if updateErr := s.jobRepo.UpdateFields(job.JobUUID, fields); updateErr != nil {
+		log.Printf("[TelemetryExportService] Failed to update job %s: %v", job.JobUUID, updateErr)
+	}
+}
+
+// writeFile writes the export to a temporary file and moves it to path when complete.
+func (s *TelemetryExportService) writeFile(userUUID string, request TelemetryExportRequest, path string) (int64, int64, error) {
+	prepared, err := s.prepare(userUUID, request)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	tmp := path + ".part"
+	file, err := os.Create(tmp)
+	if err != nil {
+		return 0, 0, err
+	}
+	rows, err := prepared.WriteTo(file)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp, path)
+	}
+	if err != nil {
+		s.removeFile(tmp)
+		return 0, 0, err
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return 0, 0, err
+	}
+	return rows, info.Size(), nil
+}
+
+// expireResults deletes the files of expired jobs and marks them expired.
+func (s *TelemetryExportService) expireResults() {
+	jobs, err := s.jobRepo.FindExpired(time.Now().Unix(), 100)
+	if err != nil {
+		log.Printf("[TelemetryExportService] Failed to query expired jobs: %v", err)
+		return
+	}
+	for _, job := range jobs {
+		s.removeFile(job.FilePath)
+		if err := s.jobRepo.UpdateFields(job.JobUUID, map[string]interface{}{
+			"status":    model.TelemetryExportExpired,
+			"file_path": "",
+		}); err != nil {
+			log.Printf("[TelemetryExportService] Failed to expire job %s: %v", job.JobUUID, err)
+		}
+	}
+}
+
+func (s *TelemetryExportService) removeFile(path string) {
+	if path == "" {
+		return
+	}
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		log.Printf("[TelemetryExportService] Failed to remove %s: %v", path, err)
+	}
+}
+
+func exportFilename(scope, targetUUID string, startTime, endTime int64, format string) string {
+	return fmt.Sprintf("telemetry_%s_%s_%d_%d.%s", scope, targetUUID, startTime, endTime, format)
+}
+
+func dedupe(values []string) []string {
+	if len(values) == 0 {
+		return nil
+	}
+	seen := make(map[string]bool, len(values))
+	out := make([]string, 0, len(values))
+	for _, value := range values {
+		if value == "" || seen[value] {
+			continue
+		}
+		seen[value] = true
+		out = append(out, value)
+	}
+	return out
+}
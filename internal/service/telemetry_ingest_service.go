@@ -0,0 +1,441 @@
+package service
+
+import (
+	"OMEGA3-IOT/internal/model"
+	"OMEGA3-IOT/internal/repository"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const telemetryIngestMaxAttempts = 3
+
+// Policies for values whose device timestamp is outside the skew window
+const (
+	OutOfWindowReject  = "reject"  // drop the value
+	OutOfWindowReplace = "replace" // store it at the receive time instead and count it
+)
+
+// ErrIngestOverloaded is returned when the ingest buffer stays full for the enqueue timeout
+// and a telemetry point is dropped.
+var ErrIngestOverloaded = errors.New("telemetry ingest buffer full")
+
+// IngestStats is a snapshot of the ingest pipeline counters since startup.
+type IngestStats struct {
+	PointsAccepted    int64 `json:"points_accepted"`
+	PointsWritten     int64 `json:"points_written"`
+	PointsDropped     int64 `json:"points_dropped"`  // buffer full, rejected at submit
+	PointsFailed      int64 `json:"points_failed"`   // IoTDB write failed after retries
+	ValuesRejected    int64 `json:"values_rejected"` // device timestamp outside the skew window
+	ValuesReplaced    int64 `json:"values_replaced"` // same, stored at the receive time instead
+	PointsBuffered    int   `json:"points_buffered"`
+	BufferCapacity    int   `json:"buffer_capacity"`
+	IoTDBFlushes      int64 `json:"iotdb_flushes"`
+	IoTDBFlushErrors  int64 `json:"iotdb_flush_errors"`
+	LastFlushPoints   int64 `json:"last_flush_points"`
+	LastFlushMs       int64 `json:"last_flush_ms"`
+	StateUpdates      int64 `json:"state_updates"`   // last-value updates submitted
+	StateCoalesced    int64 `json:"state_coalesced"` // updates merged into a pending write
+	StateWrites       int64 `json:"state_writes"`    // rows written to MySQL
+	StateWriteErrors  int64 `json:"state_write_errors"`
+	StatePendingCount int   `json:"state_pending"`
+}
+
+// deviceState is the latest last-value state of a device waiting to be written to MySQL.
+type deviceState struct {
+	values    map[string]model.TypedValue // property key → latest value
+	lastSeen  int64
+	updatedAt time.Time
+}
+
+// merge takes over the values that are at least as recent as the queued ones.
+func (st *deviceState) merge(values map[string]model.TypedValue, lastSeen int64, updatedAt time.Time) {
+	for key, value := range values {
+		if queued, ok := st.values[key]; !ok || value.Timestamp >= queued.Timestamp {
+			st.values[key] = value
+		}
+	}
+	if lastSeen > st.lastSeen {
+		st.lastSeen = lastSeen
+	}
+	if updatedAt.After(st.updatedAt) {
+		st.updatedAt = updatedAt
+	}
+}
+
+// apply writes the queued values onto properties loaded from MySQL. Only the values of properties
+// the stored row still has are changed, and never to an older value; metadata is left alone.
+func (st *deviceState) apply(properties *model.Properties) {
+	for key, value := range st.values {
+		if current := properties.Items[key]; current != nil && value.Timestamp >= current.Value.Timestamp {
+			current.Value = value
+		}
+	}
+}
+
+// TelemetryIngestService buffers property reports and writes them in batches. Telemetry
+// points are queued in a bounded buffer and written to IoTDB as one tablet per device when
+// the batch size is reached or the flush interval elapses. The MySQL last-value row of each
+// device is coalesced: the latest value of every reported property is merged into the stored
+// row once per flush interval. Online status is owned by PresenceService and not written here.
+//
+// When the buffer is full, Submit waits up to the enqueue timeout, which slows down the MQTT
+// consumer; if the buffer is still full the point is dropped and counted.
+//
+// Device timestamps are accepted from maxAge in the past to maxFuture ahead of the receive
+// time; see ResolveTimestamp.
+type TelemetryIngestService struct {
+	db            *gorm.DB
+	instanceRepo  repository.InstanceRepository
+	telemetryRepo repository.TelemetryRepository
+	batchSize     int
+	flushInterval time.Duration
+	enqueueWait   time.Duration
+	maxAge        time.Duration
+	maxFuture     time.Duration
+	outOfWindow   string
+
+	points chan repository.TelemetryData
+
+	mu       sync.Mutex
+	pending  map[string]*deviceState // waiting for the next flush
+	inflight map[string]*deviceState // being written
+
+	accepted, written, dropped, failed atomic.Int64
+	rejected, replaced                 atomic.Int64
+	flushes, flushErrors               atomic.Int64
+	lastFlushPoints, lastFlushMs       atomic.Int64
+	stateUpdates, stateCoalesced       atomic.Int64
+	stateWrites, stateWriteErrors      atomic.Int64
+	reportedDropped                    int64
+
+	stopCh chan struct{}
+	wg     sync.WaitGroup
+}
+
+func NewTelemetryIngestService(
+	gormDB *gorm.DB,
+	instanceRepo repository.InstanceRepository,
+	telemetryRepo repository.TelemetryRepository,
+	batchSize int,
+	flushIntervalMs int,
+	bufferSize int,
+	enqueueTimeoutMs int,
+	maxTimestampAgeSec int,
+	maxTimestampFutureSec int,
+	outOfWindow string,
+) *TelemetryIngestService {
+	if batchSize <= 0 {
+		batchSize = 1000
+	}
+	if flushIntervalMs <= 0 {
+		flushIntervalMs = 1000
+	}
+	if bufferSize < batchSize {
+		bufferSize = 20 * batchSize
+	}
+	if enqueueTimeoutMs < 0 {
+		enqueueTimeoutMs = 0
+	}
+	if maxTimestampAgeSec <= 0 {
+		maxTimestampAgeSec = 7 * 24 * 3600
+	}
+	if maxTimestampFutureSec <= 0 {
+		maxTimestampFutureSec = 60
+	}
+	if outOfWindow != OutOfWindowReplace {
+		outOfWindow = OutOfWindowReject
+	}
+
+	return &TelemetryIngestService{
+		db:            gormDB,
+		instanceRepo:  instanceRepo,
+		telemetryRepo: telemetryRepo,
+		batchSize:     batchSize,
+		flushInterval: time.Duration(flushIntervalMs) * time.Millisecond,
+		enqueueWait:   time.Duration(enqueueTimeoutMs) * time.Millisecond,
+		maxAge:        time.Duration(maxTimestampAgeSec) * time.Second,
+		maxFuture:     time.Duration(maxTimestampFutureSec) * time.Second,
+		outOfWindow:   outOfWindow,
+		points:        make(chan repository.TelemetryData, bufferSize),
+		pending:       make(map[string]*deviceState),
+		inflight:      make(map[string]*deviceState),
+		stopCh:        make(chan struct{}),
+	}
+}
+
+// Start launches the flush loop.
+func (s *TelemetryIngestService) Start() {
+	s.wg.Add(1)
+	go s.run()
+	log.Printf("[TelemetryIngestService] Started (batch: %d, interval: %v, buffer: %d, timestamp window: -%v/+%v, %s)",
+		s.batchSize, s.flushInterval, cap(s.points), s.maxAge, s.maxFuture, s.outOfWindow)
+}
+
+// Stop drains the buffer, writes everything still pending and stops the flush loop.
+func (s *TelemetryIngestService) Stop() {
+	close(s.stopCh)
+	s.wg.Wait()
+	log.Printf("[TelemetryIngestService] Stopped (written: %d, dropped: %d, failed: %d)", s.written.Load(), s.dropped.Load(), s.failed.Load())
+}
+
+// Submit queues the last-value state of an instance for MySQL and its telemetry points for
+// IoTDB. The state is always kept; ErrIngestOverloaded means points were dropped.
+func (s *TelemetryIngestService) Submit(instance *model.Instance, points []repository.TelemetryData) error {
+	s.mu.Lock()
+	state, ok := s.pending[instance.InstanceUUID]
+	if ok {
+		s.stateCoalesced.Add(1)
+	} else {
+		state = &deviceState{values: make(map[string]model.TypedValue)}
+		s.pending[instance.InstanceUUID] = state
+	}
+	values := make(map[string]model.TypedValue, len(instance.Properties.Items))
+	for key, item := range instance.Properties.Items {
+		if item != nil {
+			values[key] = item.Value
+		}
+	}
+	state.merge(values, instance.LastSeen, instance.UpdatedAt)
+	s.mu.Unlock()
+	s.stateUpdates.Add(1)
+
+	for i, point := range points {
+		if !s.enqueue(point) {
+			s.dropped.Add(int64(len(points) - i))
+			return fmt.Errorf("%w: %d telemetry points of %s dropped", ErrIngestOverloaded, len(points)-i, instance.InstanceUUID)
+		}
+		s.accepted.Add(1)
+	}
+	return nil
+}
+
+// enqueue adds a point to the buffer. When the buffer is full it waits up to the enqueue
+// timeout for the flush loop to catch up.
+func (s *TelemetryIngestService) enqueue(point repository.TelemetryData) bool {
+	select {
+	case s.points <- point:
+		return true
+	default:
+	}
+
+	timer := time.NewTimer(s.enqueueWait)
+	defer timer.Stop()
+	select {
+	case s.points <- point:
+		return true
+	case <-timer.C:
+		return false
+	}
+}
+
+// ResolveTimestamp returns the time in milliseconds a value with the device timestamp ts is
+// stored at. Timestamps below 1e12 are read as Unix seconds, 0 means receivedAt. A timestamp
+// outside the skew window is rejected (ok is false) or, with the replace policy, replaced by
+// receivedAt; both are counted.
+func (s *TelemetryIngestService) ResolveTimestamp(ts int64, receivedAt time.Time) (resolved int64, ok bool) {
+	if ts == 0 {
+		return receivedAt.UnixMilli(), true
+	}
+	if ts > 0 && ts < 1e12 {
+		ts *= 1000
+	}
+	if ts >= receivedAt.Add(-s.maxAge).UnixMilli() && ts <= receivedAt.Add(s.maxFuture).UnixMilli() {
+		return ts, true
+	}
+	if s.outOfWindow == OutOfWindowReplace {
+		s.replaced.Add(1)
+		return receivedAt.UnixMilli(), true
+	}
+	s.rejected.Add(1)
+	return 0, false
+}
+
+// Overlay applies property values that are queued but not yet written to MySQL to an
+// instance loaded from the database, so a new report is merged onto the latest state.
+func (s *TelemetryIngestService) Overlay(instance *model.Instance) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if instance.Properties.Items == nil {
+		return
+	}
+	for _, states := range []map[string]*deviceState{s.inflight, s.pending} {
+		if state, ok := states[instance.InstanceUUID]; ok {
+			state.apply(&instance.Properties)
+		}
+	}
+}
+
+// Stats returns the current counters.
+func (s *TelemetryIngestService) Stats() IngestStats {
+	s.mu.Lock()
+	pendingStates := len(s.pending) + len(s.inflight)
+	s.mu.Unlock()
+
+	return IngestStats{
+		PointsAccepted:    s.accepted.Load(),
+		PointsWritten:     s.written.Load(),
+		PointsDropped:     s.dropped.Load(),
+		PointsFailed:      s.failed.Load(),
+		ValuesRejected:    s.rejected.Load(),
+		ValuesReplaced:    s.replaced.Load(),
+		PointsBuffered:    len(s.points),
+		BufferCapacity:    cap(s.points),
+		IoTDBFlushes:      s.flushes.Load(),
+		IoTDBFlushErrors:  s.flushErrors.Load(),
+		LastFlushPoints:   s.lastFlushPoints.Load(),
+		LastFlushMs:       s.lastFlushMs.Load(),
+		StateUpdates:      s.stateUpdates.Load(),
+		StateCoalesced:    s.stateCoalesced.Load(),
+		StateWrites:       s.stateWrites.Load(),
+		StateWriteErrors:  s.stateWriteErrors.Load(),
+		StatePendingCount: pendingStates,
+	}
+}
+
+// ─── Flush loop ───
+
+func (s *TelemetryIngestService) run() {
+	defer s.wg.Done()
+	ticker := time.NewTicker(s.flushInterval)
+	defer ticker.Stop()
+
+	batch := make([]repository.TelemetryData, 0, s.batchSize)
+	for {
+		select {
+		case point := <-s.points:
+			batch = append(batch, point)
+			if len(batch) >= s.batchSize {
+				s.writePoints(batch)
+				batch = batch[:0]
+			}
+		case <-ticker.C:
+			s.writePoints(batch)
+			batch = batch[:0]
+			s.writeStates()
+			s.reportDrops()
+		case <-s.stopCh:
+			for drained := false; !drained; {
+				select {
+				case point := <-s.points:
+					batch = append(batch, point)
+					if len(batch) >= s.batchSize {
+						s.writePoints(batch)
+						batch = batch[:0]
+					}
+				default:
+					drained = true
+				}
+			}
+			s.writePoints(batch)
+			s.writeStates()
+			return
+		}
+	}
+}
+
+// writePoints writes a batch to IoTDB, retrying a few times before the points are given up.
+// While it runs the buffer fills up, which is what pushes back on Submit.
+func (s *TelemetryIngestService) writePoints(batch []repository.TelemetryData) {
+	if len(batch) == 0 {
+		return
+	}
+	start := time.Now()
+	for attempt := 1; ; attempt++ {
+		err := s.telemetryRepo.BatchInsertTelemetry(batch)
+		if err == nil {
+			break
+		}
+		s.flushErrors.Add(1)
+		if attempt == telemetryIngestMaxAttempts {
+			s.failed.Add(int64(len(batch)))
+			log.Printf("[TelemetryIngestService] Dropping %d points after %d failed writes: %v", len(batch), attempt, err)
+			return
+		}
+		log.Printf("[TelemetryIngestService] Failed to write %d points (attempt %d): %v", len(batch), attempt, err)
+		time.Sleep(time.Duration(attempt) * 500 * time.Millisecond)
+	}
+
+	s.flushes.Add(1)
+	s.written.Add(int64(len(batch)))
+	s.lastFlushPoints.Store(int64(len(batch)))
+	s.lastFlushMs.Store(time.Since(start).Milliseconds())
+}
+
+// writeStates merges the latest values of every device with pending updates into its row in one
+// transaction. The rows are locked while they are merged, so concurrent changes to other
+// properties or to the property metadata (e.g. a type migration) are kept. On failure the states
+// are merged back into the queue.
+func (s *TelemetryIngestService) writeStates() {
+	s.mu.Lock()
+	if len(s.pending) == 0 {
+		s.mu.Unlock()
+		return
+	}
+	s.inflight, s.pending = s.pending, make(map[string]*deviceState)
+	states := s.inflight
+	s.mu.Unlock()
+
+	instanceUUIDs := make([]string, 0, len(states))
+	for instanceUUID := range states {
+		instanceUUIDs = append(instanceUUIDs, instanceUUID)
+	}
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		instanceRepo := s.instanceRepo.WithTx(tx)
+		instances, err := instanceRepo.FindByUUIDsForUpdate(instanceUUIDs)
+		if err != nil {
+			return fmt.Errorf("failed to load instances: %w", err)
+		}
+		for i := range instances {
+			instance := &instances[i]
+			state := states[instance.InstanceUUID]
+			state.apply(&instance.Properties)
+			fields := map[string]interface{}{
+				"properties": instance.Properties,
+				"updated_at": state.updatedAt,
+			}
+			if state.lastSeen > instance.LastSeen {
+				fields["last_seen"] = state.lastSeen
+			}
+			if err := instanceRepo.UpdateFields(instance.InstanceUUID, fields); err != nil {
+				return fmt.Errorf("failed to update instance %s: %w", instance.InstanceUUID, err)
+			}
+		}
+		return nil
+	})
+
+	s.mu.Lock()
+	if err != nil {
+		for instanceUUID, state := range states {
+			// Values submitted meanwhile win over the failed ones
+			if newer, ok := s.pending[instanceUUID]; ok {
+				state.merge(newer.values, newer.lastSeen, newer.updatedAt)
+			}
+			s.pending[instanceUUID] = state
+		}
+	}
+	s.inflight = make(map[string]*deviceState)
+	s.mu.Unlock()
+
+	if err != nil {
+		s.stateWriteErrors.Add(1)
+		log.Printf("[TelemetryIngestService] Failed to write %d device states: %v", len(states), err)
+		return
+	}
+	s.stateWrites.Add(int64(len(states)))
+}
+
+// reportDrops logs when points were dropped since the last report.
+func (s *TelemetryIngestService) reportDrops() {
+	dropped := s.dropped.Load()
+	if dropped > s.reportedDropped {
+		log.Printf("[TelemetryIngestService] Buffer full: dropped %d points (total %d, buffered %d/%d)",
+			dropped-s.reportedDropped, dropped, len(s.points), cap(s.points))
+		s.reportedDropped = dropped
+	}
+}
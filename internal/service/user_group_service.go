@@ -1,8 +1,11 @@
 package service
 
 import (
+	"OMEGA3-IOT/internal/eventbus"
+	"OMEGA3-IOT/internal/logger"
 	"OMEGA3-IOT/internal/model"
 	"OMEGA3-IOT/internal/repository"
+	"context"
 	"fmt"
 	"time"
 
@@ -19,6 +22,7 @@ type UserGroupService struct {
 	deviceShareRepo     repository.GroupDeviceShareRepository
 	instanceRepo        repository.InstanceRepository
 	userRepo            repository.UserRepository
+	eventBus            *eventbus.EventBus
 }
 
 // NewUserGroupService creates a new UserGroupService.
@@ -31,6 +35,7 @@ func NewUserGroupService(
 	deviceShareRepo repository.GroupDeviceShareRepository,
 	instanceRepo repository.InstanceRepository,
 	userRepo repository.UserRepository,
+	eventBus *eventbus.EventBus,
 ) *UserGroupService {
 	return &UserGroupService{
 		db:              db,
@@ -41,6 +46,7 @@ func NewUserGroupService(
 		deviceShareRepo: deviceShareRepo,
 		instanceRepo:    instanceRepo,
 		userRepo:        userRepo,
+		eventBus:        eventBus,
 	}
 }
 
@@ -79,6 +85,7 @@ func (s *UserGroupService) CreateGroup(name, description, ownerUUID string, maxM
 	if err != nil {
 		return nil, err
 	}
+	s.emitGroupEvent(logger.LogEventGroupCreated, ownerUUID, group.GroupUUID, "", "")
 	return group, nil
 }
 
@@ -144,7 +151,7 @@ func (s *UserGroupService) DissolveGroup(groupUUID, callerUUID string) error {
 		return fmt.Errorf("permission denied")
 	}
 
-	return s.db.Transaction(func(tx *gorm.DB) error {
+	err = s.db.Transaction(func(tx *gorm.DB) error {
 		txGroupRepo := s.groupRepo.WithTx(tx)
 		txDeviceShareRepo := s.deviceShareRepo.WithTx(tx)
 
@@ -158,6 +165,11 @@ func (s *UserGroupService) DissolveGroup(groupUUID, callerUUID string) error {
 			"updated_at": time.Now().Unix(),
 		})
 	})
+	if err != nil {
+		return err
+	}
+	s.emitGroupEvent(logger.LogEventGroupDissolved, callerUUID, groupUUID, "", "")
+	return nil
 }
 
 // ==================== Member Management ====================
@@ -212,7 +224,13 @@ func (s *UserGroupService) AddMember(groupUUID, userUUID, inviterUUID string) er
 	member := model.NewGroupMember(groupUUID, userUUID, model.GroupRoleMember, inviterUUID)
 	member.Status = status
 
-	return s.memberRepo.Create(member)
+	if err := s.memberRepo.Create(member); err != nil {
+		return err
+	}
+	if status == model.GroupMemberStatusActive {
+		s.emitGroupEvent(logger.LogEventGroupMemberJoined, userUUID, groupUUID, userUUID, "")
+	}
+	return nil
 }
 
 // ApproveMember approves a pending member. Caller must be group_admin.
@@ -244,9 +262,13 @@ func (s *UserGroupService) ApproveMember(groupUUID, targetUUID, callerUUID strin
 		}
 	}
 
-	return s.memberRepo.UpdateFields(member.ID, map[string]interface{}{
+	if err := s.memberRepo.UpdateFields(member.ID, map[string]interface{}{
 		"status": model.GroupMemberStatusActive,
-	})
+	}); err != nil {
+		return err
+	}
+	s.emitGroupEvent(logger.LogEventGroupMemberJoined, callerUUID, groupUUID, targetUUID, "")
+	return nil
 }
 
 // RejectMember rejects a pending member. Caller must be group_admin.
@@ -286,9 +308,13 @@ func (s *UserGroupService) RemoveMember(groupUUID, targetUUID, callerUUID string
 		return fmt.Errorf("member not found: %w", err)
 	}
 
-	return s.memberRepo.UpdateFields(member.ID, map[string]interface{}{
+	if err := s.memberRepo.UpdateFields(member.ID, map[string]interface{}{
 		"status": model.GroupMemberStatusKicked,
-	})
+	}); err != nil {
+		return err
+	}
+	s.emitGroupEvent(logger.LogEventGroupMemberRemoved, callerUUID, groupUUID, targetUUID, "")
+	return nil
 }
 
 // LeaveGroup allows a member to leave a group. Auto-revokes device shares.
@@ -306,7 +332,7 @@ func (s *UserGroupService) LeaveGroup(groupUUID, userUUID string) error {
 		return fmt.Errorf("not a member of this group: %w", err)
 	}
 
-	return s.db.Transaction(func(tx *gorm.DB) error {
+	err = s.db.Transaction(func(tx *gorm.DB) error {
 		txMemberRepo := s.memberRepo.WithTx(tx)
 		txDeviceShareRepo := s.deviceShareRepo.WithTx(tx)
 
@@ -331,6 +357,11 @@ func (s *UserGroupService) LeaveGroup(groupUUID, userUUID string) error {
 		}
 		return nil
 	})
+	if err != nil {
+		return err
+	}
+	s.emitGroupEvent(logger.LogEventGroupMemberLeft, userUUID, groupUUID, userUUID, "")
+	return nil
 }
 
 // UpdateMemberRole changes a member's role. Only group_owner can do this.
@@ -415,7 +446,11 @@ func (s *UserGroupService) ShareDeviceToGroup(groupUUID, instanceUUID, callerUUI
 	}
 
 	share := model.NewGroupDeviceShare(groupUUID, instanceUUID, callerUUID, permission, callerUUID)
-	return s.deviceShareRepo.Create(share)
+	if err := s.deviceShareRepo.Create(share); err != nil {
+		return err
+	}
+	s.emitGroupEvent(logger.LogEventGroupDeviceShared, callerUUID, groupUUID, "", instanceUUID)
+	return nil
 }
 
 // RevokeGroupDeviceShare revokes a device share from a group.
@@ -432,7 +467,11 @@ func (s *UserGroupService) RevokeGroupDeviceShare(groupUUID, instanceUUID, calle
 		}
 	}
 
-	return s.deviceShareRepo.Revoke(share.ID)
+	if err := s.deviceShareRepo.Revoke(share.ID); err != nil {
+		return err
+	}
+	s.emitGroupEvent(logger.LogEventGroupDeviceUnshared, callerUUID, groupUUID, "", instanceUUID)
+	return nil
 }
 
 // SendGroupDeviceAction sends an action to a device shared in a group.
@@ -516,6 +555,20 @@ func (s *UserGroupService) UpdatePolicy(groupUUID, callerUUID string, req *model
 
 // ==================== Helpers ====================
 
+// emitGroupEvent publishes a group change as a user event of the acting user.
+// targetUUID is the affected member and instanceUUID the affected device, if any.
+func (s *UserGroupService) emitGroupEvent(eventType logger.LogEventType, actorUUID, groupUUID, targetUUID, instanceUUID string) {
+	event := logger.NewUserLogEvent(actorUUID, logger.LogLevelInfo, fmt.Sprintf("Group %s: %s", groupUUID, eventType), eventType)
+	event.Metadata["group_uuid"] = groupUUID
+	if targetUUID != "" {
+		event.Metadata["target_uuid"] = targetUUID
+	}
+	if instanceUUID != "" {
+		event.Metadata["instance_uuid"] = instanceUUID
+	}
+	s.eventBus.Publish(context.Background(), event)
+}
+
 // CheckMembership checks if a user is an active member of a group (public wrapper).
 func (s *UserGroupService) CheckMembership(groupUUID, userUUID string) error {
 	return s.requireMembership(groupUUID, userUUID)
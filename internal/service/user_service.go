@@ -8,8 +8,10 @@ import (
 	"OMEGA3-IOT/internal/utils"
 	"context"
 	"fmt"
+	"github.com/apache/iotdb-client-go/client"
 	"io"
 	"log"
+	"sort"
 	"time"
 )
 
@@ -18,7 +20,7 @@ type UserService struct {
 	userRepo               repository.UserRepository
 	instanceRepo           repository.InstanceRepository
 	deviceRegistrationRepo repository.DeviceRegistrationRecordRepository
-	iotDBClient            *db.IOTDBClient
+	telemetryRepo          repository.TelemetryRepository
 	loggerService          logger.LoggerInterface
 	avatarService          *AvatarService
 	dhService              *utils.DHService
@@ -40,7 +42,7 @@ func NewUserService(
 	userRepo repository.UserRepository,
 	instanceRepo repository.InstanceRepository,
 	deviceRegistrationRepo repository.DeviceRegistrationRecordRepository,
-	iotDBClient *db.IOTDBClient,
+	telemetryRepo repository.TelemetryRepository,
 	loggerService logger.LoggerInterface,
 	avatarService *AvatarService,
 	dhService *utils.DHService,
@@ -51,7 +53,7 @@ func NewUserService(
 		userRepo:               userRepo,
 		instanceRepo:           instanceRepo,
 		deviceRegistrationRepo: deviceRegistrationRepo,
-		iotDBClient:            iotDBClient,
+		telemetryRepo:          telemetryRepo,
 		loggerService:          loggerService,
 		avatarService:          avatarService,
 		dhService:              dhService,
@@ -272,9 +274,9 @@ func (s *UserService) BindDeviceByRegCode(userUUID string, regCode string, devic
 		return nil, fmt.Errorf("failed to create instance: %w", err)
 	}
 
-	// Create timeseries in IoTDB
-	if err := s.createDeviceTimeseriesInIoTDB(*instance); err != nil {
-		log.Printf("[UserService] failed to create device timeseries in IoTDB: %v", err)
+	// Create the telemetry timeseries
+	if err := s.createDeviceTimeseries(*instance); err != nil {
+		log.Printf("[UserService] failed to create device timeseries: %v", err)
 	}
 
 	// Mark as bound
@@ -303,24 +305,19 @@ func (s *UserService) BindDeviceByRegCode(userUUID string, regCode string, devic
 	return instance, nil
 }
 
-func (s *UserService) createDeviceTimeseriesInIoTDB(instance model.Instance) error {
-	session, err := s.iotDBClient.SessionPool.GetSession()
-	if err != nil {
-		return fmt.Errorf("[UserService] failed to get session: %w", err)
+func (s *UserService) createDeviceTimeseries(instance model.Instance) error {
+	propertyNames := make([]string, 0, len(instance.Properties.Items))
+	for propKey := range instance.Properties.Items {
+		propertyNames = append(propertyNames, propKey)
 	}
-	defer s.iotDBClient.SessionPool.PutBack(session)
-
-	for propKey, meta := range instance.Properties.Items {
-		dataType, encoding, compression := s.iotDBClient.MapConvertToIotDBType(meta.Meta)
+	sort.Strings(propertyNames)
 
-		historicalPath := fmt.Sprintf("root.mm1.device_data.%s.%s", instance.InstanceUUID, propKey)
-
-		sql := fmt.Sprintf("CREATE TIMESERIES %s WITH DATATYPE=%d, ENCODING=%d, COMPRESSION=%d",
-			historicalPath, dataType, encoding, compression)
-		status, err := s.iotDBClient.ExecuteNonQuery(sql)
-		if checkErr := s.iotDBClient.CheckError(status, err); checkErr != nil {
-			return fmt.Errorf("[UserService] failed to create timeseries %s: %w", propKey, checkErr)
-		}
+	dataTypes := make([]client.TSDataType, len(propertyNames))
+	for i, propKey := range propertyNames {
+		dataTypes[i], _, _ = db.MapPropertyType(instance.Properties.Items[propKey].Meta)
+	}
+	if err := s.telemetryRepo.CreateTimeseries(utils.ConvertHyphenIntoDash(instance.InstanceUUID), propertyNames, dataTypes); err != nil {
+		return fmt.Errorf("[UserService] failed to create timeseries: %w", err)
 	}
 	return nil
 }
@@ -0,0 +1,760 @@
+package service
+
+import (
+	"OMEGA3-IOT/internal/eventbus"
+	"OMEGA3-IOT/internal/logger"
+	"OMEGA3-IOT/internal/model"
+	"OMEGA3-IOT/internal/repository"
+	"OMEGA3-IOT/internal/utils"
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const (
+	webhookRetryCheckInterval = 5 * time.Second
+	webhookRetryBatchSize     = 50
+	webhookRetryBaseDelay     = 10 * time.Second
+	webhookRetryMaxDelay      = time.Hour
+	webhookWorkers            = 16   // deliveries sent concurrently
+	webhookQueueSize          = 1024 // deliveries waiting for a worker
+	webhookPerEndpointLimit   = 2    // deliveries sent concurrently to one webhook
+
+	// WebhookPingEvent is the event type of test deliveries.
+	WebhookPingEvent = "webhook.ping"
+)
+
+var (
+	ErrWebhookNotFound = errors.New("webhook not found")
+	ErrInvalidWebhook  = errors.New("invalid webhook")
+)
+
+// WebhookEventTypes are the event types webhooks can subscribe to.
+var WebhookEventTypes = []logger.LogEventType{
+	logger.LogEventDevicePropertyUpdate,
+	logger.LogEventDeviceStatusChange,
+	logger.LogEventDeviceActionResult,
+	logger.LogEventDeviceActionComplete,
+	logger.LogEventDeviceActionTimeout,
+	logger.LogEventDeviceEventReceived,
+	logger.LogEventDeviceShadowUpdate,
+	logger.LogEventAlertFired,
+	logger.LogEventAlertResolved,
+	logger.LogEventUserDeviceShare,
+	logger.LogEventUserDeviceUnshare,
+	logger.LogEventUserDeviceBind,
+	logger.LogEventGroupCreated,
+	logger.LogEventGroupDissolved,
+	logger.LogEventGroupMemberJoined,
+	logger.LogEventGroupMemberLeft,
+	logger.LogEventGroupMemberRemoved,
+	logger.LogEventGroupDeviceShared,
+	logger.LogEventGroupDeviceUnshared,
+	logger.LogEventSystemDeviceTypeReloaded,
+}
+
+// WebhookInput holds the user-editable fields of a webhook.
+type WebhookInput struct {
+	Name       string   `json:"name" binding:"required,max=128"`
+	URL        string   `json:"url" binding:"required,max=512"`
+	EventTypes []string `json:"event_types" binding:"required,min=1"`
+}
+
+// WebhookPayload is the JSON body POSTed to webhook endpoints.
+type WebhookPayload struct {
+	DeliveryUUID string      `json:"delivery_uuid"`
+	WebhookUUID  string      `json:"webhook_uuid"`
+	EventType    string      `json:"event_type"`
+	Timestamp    int64       `json:"timestamp"`
+	Data         interface{} `json:"data"`
+}
+
+// WebhookService delivers EventBus events to registered HTTP endpoints. Each delivery is
+// logged in MySQL and retried with exponential backoff until it succeeds or runs out of
+// attempts; a webhook is disabled after too many consecutive failed attempts.
+//
+// Deliveries are sent by a fixed pool of workers from a bounded queue, at most
+// webhookPerEndpointLimit at a time per webhook. A delivery that finds the queue full or its
+// webhook busy stays pending in MySQL and is sent by the retry loop once its lease expires.
+//
+// Requests carry X-Webhook-Timestamp and X-Webhook-Signature headers, where the signature
+// is "sha256=" + hex(HMAC-SHA256(secret, timestamp + "." + body)).
+type WebhookService struct {
+	webhookRepo        repository.WebhookRepository
+	deliveryRepo       repository.WebhookDeliveryRepository
+	deviceShareService *DeviceShareService
+	groupService       *UserGroupService
+	eventBus           *eventbus.EventBus
+	client             *http.Client // user webhooks: public addresses only
+	globalClient       *http.Client // global webhooks, configured by admins
+	maxAttempts        int
+	disableAfter       int
+	timeout            time.Duration
+
+	mu       sync.RWMutex
+	webhooks []model.Webhook // enabled webhooks, reloaded on every change
+
+	queue     chan webhookJob
+	endpoints sync.Map // webhookUUID → chan struct{}, the delivery slots of the webhook
+	stopCh    chan struct{}
+	wg        sync.WaitGroup
+}
+
+// webhookJob is a claimed delivery waiting for a worker.
+type webhookJob struct {
+	webhook  model.Webhook
+	delivery *model.WebhookDelivery
+}
+
+func NewWebhookService(
+	webhookRepo repository.WebhookRepository,
+	deliveryRepo repository.WebhookDeliveryRepository,
+	deviceShareService *DeviceShareService,
+	groupService *UserGroupService,
+	eventBus *eventbus.EventBus,
+	maxAttempts int,
+	timeoutSec int,
+	disableAfterFailures int,
+) *WebhookService {
+	if maxAttempts <= 0 {
+		maxAttempts = 8 // ~20 minutes of retries
+	}
+	if timeoutSec <= 0 {
+		timeoutSec = 10
+	}
+	if disableAfterFailures <= 0 {
+		disableAfterFailures = 20
+	}
+
+	return &WebhookService{
+		webhookRepo:        webhookRepo,
+		deliveryRepo:       deliveryRepo,
+		deviceShareService: deviceShareService,
+		groupService:       groupService,
+		eventBus:           eventBus,
+		client:             newWebhookClient(time.Duration(timeoutSec)*time.Second, true),
+		globalClient:       newWebhookClient(time.Duration(timeoutSec)*time.Second, false),
+		maxAttempts:        maxAttempts,
+		disableAfter:       disableAfterFailures,
+		timeout:            time.Duration(timeoutSec) * time.Second,
+		queue:              make(chan webhookJob, webhookQueueSize),
+		stopCh:             make(chan struct{}),
+	}
+}
+
+// Start loads enabled webhooks, subscribes to the supported event types and launches the retry loop.
+func (s *WebhookService) Start() {
+	s.reload()
+	for _, eventType := range WebhookEventTypes {
+		s.eventBus.Subscribe(eventbus.EventType(eventType), s.handleEvent, eventbus.WithGroup("service.WebhookService.handleEvent"))
+	}
+	eventbus.SubscribeTyped(s.eventBus, eventbus.EventType(logger.LogEventSystemWebhooksChanged), s.handleChanged, eventbus.Broadcast())
+
+	s.wg.Add(1 + webhookWorkers)
+	go s.run()
+	for i := 0; i < webhookWorkers; i++ {
+		go s.work()
+	}
+	log.Printf("[WebhookService] Started (max attempts: %d, disable after: %d failures)", s.maxAttempts, s.disableAfter)
+}
+
+// Stop stops the retry loop and the workers and waits for in-flight deliveries. Queued
+// deliveries stay pending and are sent by the retry loop after a restart.
+func (s *WebhookService) Stop() {
+	close(s.stopCh)
+	s.wg.Wait()
+	log.Println("[WebhookService] Stopped")
+}
+
+// ─── Management ───
+
+// CreateWebhook registers a webhook and returns it with its generated signing secret.
+func (s *WebhookService) CreateWebhook(ownerUUID string, global bool, input WebhookInput) (*model.Webhook, error) {
+	if err := validateWebhookInput(input, global); err != nil {
+		return nil, err
+	}
+	secret, err := generateWebhookSecret()
+	if err != nil {
+		return nil, err
+	}
+	webhook := model.NewWebhook(ownerUUID, global, input.Name, input.URL, secret, input.EventTypes)
+	if err := s.webhookRepo.Create(webhook); err != nil {
+		return nil, fmt.Errorf("failed to create webhook: %w", err)
+	}
+	s.changed()
+	log.Printf("[WebhookService] Webhook %s created by %s (global=%v, events=%v)", webhook.WebhookUUID, ownerUUID, global, webhook.EventTypes)
+	return webhook, nil
+}
+
+// ListWebhooks returns the user webhooks of ownerUUID, or all global webhooks if global is true.
+func (s *WebhookService) ListWebhooks(ownerUUID string, global bool, limit, offset int) ([]model.Webhook, int64, error) {
+	return s.webhookRepo.FindByOwner(ownerUUID, global, limit, offset)
+}
+
+// GetWebhook returns a webhook visible to the caller: its own user webhook, or any global one for admins.
+func (s *WebhookService) GetWebhook(ownerUUID string, global bool, webhookUUID string) (*model.Webhook, error) {
+	webhook, err := s.webhookRepo.FindByWebhookUUID(webhookUUID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrWebhookNotFound
+		}
+		return nil, fmt.Errorf("failed to find webhook: %w", err)
+	}
+	if webhook.Global != global || (!global && webhook.OwnerUUID != ownerUUID) {
+		return nil, ErrWebhookNotFound
+	}
+	return webhook, nil
+}
+
+// UpdateWebhook replaces the name, URL and subscribed events of a webhook.
+func (s *WebhookService) UpdateWebhook(ownerUUID string, global bool, webhookUUID string, input WebhookInput) (*model.Webhook, error) {
+	if err := validateWebhookInput(input, global); err != nil {
+		return nil, err
+	}
+	webhook, err := s.GetWebhook(ownerUUID, global, webhookUUID)
+	if err != nil {
+		return nil, err
+	}
+	webhook.Name = input.Name
+	webhook.URL = input.URL
+	webhook.EventTypes = input.EventTypes
+	webhook.UpdatedAt = time.Now().Unix()
+	if err := s.webhookRepo.Update(webhook); err != nil {
+		return nil, fmt.Errorf("failed to update webhook: %w", err)
+	}
+	s.changed()
+	return webhook, nil
+}
+
+// DeleteWebhook removes a webhook together with its delivery log.
+func (s *WebhookService) DeleteWebhook(ownerUUID string, global bool, webhookUUID string) error {
+	if _, err := s.GetWebhook(ownerUUID, global, webhookUUID); err != nil {
+		return err
+	}
+	if err := s.webhookRepo.Delete(webhookUUID); err != nil {
+		return fmt.Errorf("failed to delete webhook: %w", err)
+	}
+	if err := s.deliveryRepo.DeleteByWebhookUUID(webhookUUID); err != nil {
+		log.Printf("[WebhookService] Failed to delete deliveries of %s: %v", webhookUUID, err)
+	}
+	s.changed()
+	log.Printf("[WebhookService] Webhook %s deleted by %s", webhookUUID, ownerUUID)
+	return nil
+}
+
+// SetEnabled enables or disables a webhook. Enabling clears the failure counter.
+func (s *WebhookService) SetEnabled(ownerUUID string, global bool, webhookUUID string, enabled bool) (*model.Webhook, error) {
+	webhook, err := s.GetWebhook(ownerUUID, global, webhookUUID)
+	if err != nil {
+		return nil, err
+	}
+	webhook.Enabled = enabled
+	if enabled {
+		webhook.ConsecutiveFailures = 0
+		webhook.DisabledReason = ""
+	} else {
+		webhook.DisabledReason = "disabled by user"
+	}
+	webhook.UpdatedAt = time.Now().Unix()
+	if err := s.webhookRepo.Update(webhook); err != nil {
+		return nil, fmt.Errorf("failed to update webhook: %w", err)
+	}
+	s.changed()
+	return webhook, nil
+}
+
+// RotateSecret generates a new signing secret and returns it.
+func (s *WebhookService) RotateSecret(ownerUUID string, global bool, webhookUUID string) (*model.Webhook, error) {
+	webhook, err := s.GetWebhook(ownerUUID, global, webhookUUID)
+	if err != nil {
+		return nil, err
+	}
+	secret, err := generateWebhookSecret()
+	if err != nil {
+		return nil, err
+	}
+	webhook.Secret = secret
+	webhook.UpdatedAt = time.Now().Unix()
+	if err := s.webhookRepo.Update(webhook); err != nil {
+		return nil, fmt.Errorf("failed to update webhook: %w", err)
+	}
+	s.changed()
+	return webhook, nil
+}
+
+// ListDeliveries returns the delivery log of a webhook, newest first.
+func (s *WebhookService) ListDeliveries(ownerUUID string, global bool, webhookUUID string, limit, offset int) ([]model.WebhookDelivery, int64, error) {
+	if _, err := s.GetWebhook(ownerUUID, global, webhookUUID); err != nil {
+		return nil, 0, err
+	}
+	return s.deliveryRepo.FindByWebhookUUID(webhookUUID, limit, offset)
+}
+
+// Ping queues a webhook.ping delivery so integrators can verify their endpoint and signature check.
+func (s *WebhookService) Ping(ownerUUID string, global bool, webhookUUID string) (*model.WebhookDelivery, error) {
+	webhook, err := s.GetWebhook(ownerUUID, global, webhookUUID)
+	if err != nil {
+		return nil, err
+	}
+	delivery, err := s.enqueue(webhook, WebhookPingEvent, time.Now().Unix(), map[string]interface{}{"message": "ping"})
+	if err != nil {
+		return nil, err
+	}
+	s.dispatch(*webhook, delivery)
+	return delivery, nil
+}
+
+// validateWebhookInput checks a webhook definition. User webhooks must point at a public
+// address; global webhooks are configured by admins and may target internal services.
+func validateWebhookInput(input WebhookInput, global bool) error {
+	u, err := url.Parse(input.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("%w: url must be an absolute http(s) URL", ErrInvalidWebhook)
+	}
+	if !global {
+		if err := checkPublicHost(u.Hostname()); err != nil {
+			return fmt.Errorf("%w: %v", ErrInvalidWebhook, err)
+		}
+	}
+	if len(input.EventTypes) == 0 {
+		return fmt.Errorf("%w: event_types is required", ErrInvalidWebhook)
+	}
+	for _, eventType := range input.EventTypes {
+		if !isWebhookEventType(eventType) {
+			return fmt.Errorf("%w: unsupported event type '%s'", ErrInvalidWebhook, eventType)
+		}
+	}
+	return nil
+}
+
+// checkPublicHost rejects hosts that are, or resolve to, non-public addresses. A host that does
+// not resolve yet is accepted; the dialer checks the address again on every delivery.
+func checkPublicHost(host string) error {
+	host = strings.TrimSuffix(strings.ToLower(host), ".")
+	if host == "localhost" || strings.HasSuffix(host, ".localhost") {
+		return fmt.Errorf("url must not target a local address")
+	}
+	if addr, err := netip.ParseAddr(host); err == nil {
+		if !isPublicAddr(addr) {
+			return fmt.Errorf("url must not target a private, loopback or link-local address")
+		}
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	addrs, err := net.DefaultResolver.LookupNetIP(ctx, "ip", host)
+	if err != nil {
+		return nil
+	}
+	for _, addr := range addrs {
+		if !isPublicAddr(addr) {
+			return fmt.Errorf("url host '%s' resolves to a non-public address", host)
+		}
+	}
+	return nil
+}
+
+// nonPublicPrefixes are special-purpose ranges not covered by the netip.Addr predicates.
+var nonPublicPrefixes = []netip.Prefix{
+	netip.MustParsePrefix("0.0.0.0/8"),      // "this network"
+	netip.MustParsePrefix("100.64.0.0/10"),  // carrier-grade NAT
+	netip.MustParsePrefix("192.0.0.0/24"),   // IETF protocol assignments
+	netip.MustParsePrefix("198.18.0.0/15"),  // benchmarking
+	netip.MustParsePrefix("240.0.0.0/4"),    // reserved, incl. broadcast
+	netip.MustParsePrefix("64:ff9b::/96"),   // NAT64, may embed internal IPv4 addresses
+	netip.MustParsePrefix("64:ff9b:1::/48"), // local-use NAT64
+}
+
+// isPublicAddr reports whether addr is a globally routable unicast address. Loopback, private,
+// link-local (which includes cloud metadata endpoints such as 169.254.169.254) and other
+// special-purpose ranges are not.
+func isPublicAddr(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	if !addr.IsValid() || !addr.IsGlobalUnicast() || addr.IsPrivate() ||
+		addr.IsLoopback() || addr.IsLinkLocalUnicast() || addr.IsUnspecified() {
+		return false
+	}
+	for _, prefix := range nonPublicPrefixes {
+		if prefix.Contains(addr) {
+			return false
+		}
+	}
+	return true
+}
+
+// newWebhookClient returns the HTTP client used for deliveries. Redirects are not followed, so a
+// 3xx response counts as a failed delivery. With publicOnly set, the dialer refuses connections to
+// non-public addresses, which covers hostnames re-resolving to internal IPs after validation.
+func newWebhookClient(timeout time.Duration, publicOnly bool) *http.Client {
+	dialer := &net.Dialer{Timeout: timeout}
+	if publicOnly {
+		dialer.Control = func(network, address string, _ syscall.RawConn) error {
+			addrPort, err := netip.ParseAddrPort(address)
+			if err != nil {
+				return fmt.Errorf("webhook target %s: %w", address, err)
+			}
+			if !isPublicAddr(addrPort.Addr()) {
+				return fmt.Errorf("webhook target %s is not a public address", address)
+			}
+			return nil
+		}
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil // a proxy would dial the target on our behalf, bypassing the check
+	transport.DialContext = dialer.DialContext
+	return &http.Client{
+		Timeout:   timeout,
+		Transport: transport,
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
+
+func isWebhookEventType(eventType string) bool {
+	for _, t := range WebhookEventTypes {
+		if string(t) == eventType {
+			return true
+		}
+	}
+	return false
+}
+
+func generateWebhookSecret() (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", fmt.Errorf("failed to generate webhook secret: %w", err)
+	}
+	return hex.EncodeToString(buf), nil
+}
+
+// changed reloads the cache after a webhook was modified and tells the other server processes
+// to reload theirs.
+func (s *WebhookService) changed() {
+	s.reload()
+	s.eventBus.Publish(context.Background(), logger.NewSystemLogEvent(logger.LogLevelInfo, "Webhooks changed", logger.LogEventSystemWebhooksChanged))
+}
+
+func (s *WebhookService) handleChanged(ctx context.Context, event logger.SystemLogEvent) error {
+	s.reload()
+	return nil
+}
+
+// reload refreshes the cache of enabled webhooks used to route events.
+func (s *WebhookService) reload() {
+	webhooks, err := s.webhookRepo.FindEnabled()
+	if err != nil {
+		log.Printf("[WebhookService] Failed to load webhooks: %v", err)
+		return
+	}
+	s.mu.Lock()
+	s.webhooks = webhooks
+	s.mu.Unlock()
+}
+
+// ─── Event routing ───
+
+// handleEvent creates a delivery for every enabled webhook that subscribes to the event and may see it.
+func (s *WebhookService) handleEvent(ctx context.Context, event eventbus.Event) error {
+	eventType := string(event.GetType())
+
+	s.mu.RLock()
+	var targets []model.Webhook
+	for _, webhook := range s.webhooks {
+		if webhook.Subscribes(eventType) {
+			targets = append(targets, webhook)
+		}
+	}
+	s.mu.RUnlock()
+
+	for i := range targets {
+		webhook := &targets[i]
+		if !s.canSee(webhook, event) {
+			continue
+		}
+		delivery, err := s.enqueue(webhook, eventType, event.GetTimestamp(), event)
+		if err != nil {
+			log.Printf("[WebhookService] Failed to queue %s for webhook %s: %v", eventType, webhook.WebhookUUID, err)
+			continue
+		}
+		s.dispatch(*webhook, delivery)
+	}
+	return nil
+}
+
+// canSee reports whether the owner of a user webhook is allowed to receive the event.
+func (s *WebhookService) canSee(webhook *model.Webhook, event eventbus.Event) bool {
+	if webhook.Global {
+		return true
+	}
+	switch e := event.(type) {
+	case logger.DeviceLogEvent:
+		allowed, err := s.deviceShareService.CheckDeviceAccess(e.DeviceUUID, webhook.OwnerUUID, repository.PermissionRead)
+		return err == nil && allowed
+	case logger.UserLogEvent:
+		if e.UserUUID == webhook.OwnerUUID {
+			return true
+		}
+		if target, _ := e.Metadata["target_uuid"].(string); target == webhook.OwnerUUID {
+			return true
+		}
+		if groupUUID, _ := e.Metadata["group_uuid"].(string); groupUUID != "" {
+			return s.groupService.CheckMembership(groupUUID, webhook.OwnerUUID) == nil
+		}
+	}
+	return false
+}
+
+func (s *WebhookService) enqueue(webhook *model.Webhook, eventType string, timestamp int64, data interface{}) (*model.WebhookDelivery, error) {
+	deliveryUUID := utils.GenerateUUID().String()
+	payload, err := json.Marshal(WebhookPayload{
+		DeliveryUUID: deliveryUUID,
+		WebhookUUID:  webhook.WebhookUUID,
+		EventType:    eventType,
+		Timestamp:    timestamp,
+		Data:         data,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal payload: %w", err)
+	}
+	delivery := model.NewWebhookDelivery(deliveryUUID, webhook.WebhookUUID, eventType, payload, s.leaseUntil())
+	if err := s.deliveryRepo.Create(delivery); err != nil {
+		return nil, fmt.Errorf("failed to create delivery: %w", err)
+	}
+	return delivery, nil
+}
+
+// leaseUntil is how long a claimed delivery is hidden from the retry loop while it is being sent.
+func (s *WebhookService) leaseUntil() int64 {
+	return time.Now().Add(s.timeout + 30*time.Second).Unix()
+}
+
+// ─── Delivery ───
+
+// dispatch queues a delivery for the workers. When the queue is full the delivery is left to the
+// retry loop.
+func (s *WebhookService) dispatch(webhook model.Webhook, delivery *model.WebhookDelivery) {
+	select {
+	case s.queue <- webhookJob{webhook: webhook, delivery: delivery}:
+	default:
+		log.Printf("[WebhookService] Delivery queue full, delivery %s to %s left to the retry loop", delivery.DeliveryUUID, webhook.WebhookUUID)
+	}
+}
+
+func (s *WebhookService) work() {
+	defer s.wg.Done()
+	for {
+		select {
+		case <-s.stopCh:
+			return
+		case job := <-s.queue:
+			s.deliver(job)
+		}
+	}
+}
+
+// deliver sends a delivery if its webhook has a free slot. Otherwise the delivery is left to the
+// retry loop, so a slow endpoint cannot hold up the workers.
+func (s *WebhookService) deliver(job webhookJob) {
+	val, _ := s.endpoints.LoadOrStore(job.webhook.WebhookUUID, make(chan struct{}, webhookPerEndpointLimit))
+	slots := val.(chan struct{})
+	select {
+	case slots <- struct{}{}:
+	default:
+		log.Printf("[WebhookService] Webhook %s busy, delivery %s left to the retry loop", job.webhook.WebhookUUID, job.delivery.DeliveryUUID)
+		return
+	}
+	defer func() { <-slots }()
+	s.attempt(&job.webhook, job.delivery)
+}
+
+func (s *WebhookService) attempt(webhook *model.Webhook, delivery *model.WebhookDelivery) {
+	statusCode, err := s.send(webhook, delivery)
+	attempts := delivery.Attempts + 1
+	now := time.Now()
+
+	fields := map[string]interface{}{
+		"attempts":      attempts,
+		"response_code": statusCode,
+		"error":         "",
+	}
+	if err == nil {
+		fields["status"] = model.WebhookDeliverySucceeded
+		fields["completed_at"] = now.Unix()
+		fields["next_attempt_at"] = 0
+		if webhook.ConsecutiveFailures > 0 {
+			if resetErr := s.webhookRepo.ResetFailures(webhook.WebhookUUID); resetErr != nil {
+				log.Printf("[WebhookService] Failed to reset failures of %s: %v", webhook.WebhookUUID, resetErr)
+			}
+		}
+	} else {
+		fields["error"] = truncate(err.Error(), 497)
+		if attempts >= s.maxAttempts {
+			fields["status"] = model.WebhookDeliveryFailed
+			fields["completed_at"] = now.Unix()
+			fields["next_attempt_at"] = 0
+		} else {
+			fields["next_attempt_at"] = now.Add(webhookRetryDelay(attempts)).Unix()
+		}
+		s.recordFailure(webhook)
+	}
+
+	if updateErr := s.deliveryRepo.UpdateFields(delivery.DeliveryUUID, fields); updateErr != nil {
+		log.Printf("[WebhookService] Failed to update delivery %s: %v", delivery.DeliveryUUID, updateErr)
+	}
+	if err != nil {
+		log.Printf("[WebhookService] Delivery %s to %s failed (attempt %d/%d): %v", delivery.DeliveryUUID, webhook.WebhookUUID, attempts, s.maxAttempts, err)
+	}
+}
+
+// send POSTs the signed payload and returns the response status code.
+func (s *WebhookService) send(webhook *model.Webhook, delivery *model.WebhookDelivery) (int, error) {
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req, err := http.NewRequest(http.MethodPost, webhook.URL, bytes.NewReader(delivery.Payload))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "OMEGA3-IOT-Webhook/1.0")
+	req.Header.Set("X-Webhook-ID", webhook.WebhookUUID)
+	req.Header.Set("X-Webhook-Event", delivery.EventType)
+	req.Header.Set("X-Webhook-Delivery", delivery.DeliveryUUID)
+	req.Header.Set("X-Webhook-Timestamp", timestamp)
+	req.Header.Set("X-Webhook-Signature", SignWebhookPayload(webhook.Secret, timestamp, delivery.Payload))
+
+	client := s.client
+	if webhook.Global {
+		client = s.globalClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64*1024))
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return resp.StatusCode, fmt.Errorf("endpoint returned HTTP %d", resp.StatusCode)
+	}
+	return resp.StatusCode, nil
+}
+
+// SignWebhookPayload computes the X-Webhook-Signature header value.
+func SignWebhookPayload(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// recordFailure counts a failed attempt and disables the webhook once the limit is reached.
+func (s *WebhookService) recordFailure(webhook *model.Webhook) {
+	failures, err := s.webhookRepo.IncrementFailures(webhook.WebhookUUID)
+	if err != nil {
+		log.Printf("[WebhookService] Failed to record failure of %s: %v", webhook.WebhookUUID, err)
+		return
+	}
+	if failures < s.disableAfter {
+		return
+	}
+
+	current, err := s.webhookRepo.FindByWebhookUUID(webhook.WebhookUUID)
+	if err != nil || !current.Enabled {
+		return
+	}
+	current.Enabled = false
+	current.DisabledReason = fmt.Sprintf("disabled after %d consecutive failed deliveries", failures)
+	current.UpdatedAt = time.Now().Unix()
+	if err := s.webhookRepo.Update(current); err != nil {
+		log.Printf("[WebhookService] Failed to disable webhook %s: %v", webhook.WebhookUUID, err)
+		return
+	}
+	s.changed()
+	log.Printf("[WebhookService] Webhook %s disabled after %d consecutive failures", webhook.WebhookUUID, failures)
+}
+
+// webhookRetryDelay returns the backoff before the attempt following the given number of attempts.
+func webhookRetryDelay(attempts int) time.Duration {
+	delay := webhookRetryBaseDelay
+	for i := 1; i < attempts; i++ {
+		delay *= 2
+		if delay >= webhookRetryMaxDelay {
+			return webhookRetryMaxDelay
+		}
+	}
+	return delay
+}
+
+// ─── Retry loop ───
+
+func (s *WebhookService) run() {
+	defer s.wg.Done()
+	ticker := time.NewTicker(webhookRetryCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.stopCh:
+			return
+		case <-ticker.C:
+			s.retryDue()
+		}
+	}
+}
+
+// retryDue claims due deliveries and sends them again. Deliveries of disabled or deleted
+// webhooks are marked failed instead.
+func (s *WebhookService) retryDue() {
+	now := time.Now().Unix()
+	deliveries, err := s.deliveryRepo.FindDue(now, webhookRetryBatchSize)
+	if err != nil {
+		log.Printf("[WebhookService] Failed to query due deliveries: %v", err)
+		return
+	}
+
+	for i := range deliveries {
+		delivery := &deliveries[i]
+		claimed, err := s.deliveryRepo.Claim(delivery.DeliveryUUID, delivery.NextAttemptAt, s.leaseUntil())
+		if err != nil || !claimed {
+			continue
+		}
+
+		webhook, err := s.webhookRepo.FindByWebhookUUID(delivery.WebhookUUID)
+		if err != nil || !webhook.Enabled {
+			reason := "webhook disabled"
+			if err != nil {
+				reason = "webhook not found"
+			}
+			if updateErr := s.deliveryRepo.UpdateFields(delivery.DeliveryUUID, map[string]interface{}{
+				"status":          model.WebhookDeliveryFailed,
+				"error":           reason,
+				"completed_at":    now,
+				"next_attempt_at": 0,
+			}); updateErr != nil {
+				log.Printf("[WebhookService] Failed to update delivery %s: %v", delivery.DeliveryUUID, updateErr)
+			}
+			continue
+		}
+		s.dispatch(*webhook, delivery)
+	}
+}
@@ -0,0 +1,262 @@
+package service
+
+import (
+	"OMEGA3-IOT/internal/eventbus"
+	"OMEGA3-IOT/internal/model"
+	"OMEGA3-IOT/internal/repository"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type fakeWebhookRepo struct {
+	repository.WebhookRepository
+	mu       sync.Mutex
+	failures map[string]int
+}
+
+func (r *fakeWebhookRepo) FindEnabled() ([]model.Webhook, error) {
+	return nil, nil
+}
+
+func (r *fakeWebhookRepo) IncrementFailures(webhookUUID string) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.failures[webhookUUID]++
+	return r.failures[webhookUUID], nil
+}
+
+func (r *fakeWebhookRepo) ResetFailures(webhookUUID string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.failures, webhookUUID)
+	return nil
+}
+
+// fakeDeliveryRepo records the fields of the last update of each delivery.
+type fakeDeliveryRepo struct {
+	repository.WebhookDeliveryRepository
+	mu      sync.Mutex
+	updates map[string]map[string]interface{}
+}
+
+func (r *fakeDeliveryRepo) FindDue(now int64, limit int) ([]model.WebhookDelivery, error) {
+	return nil, nil
+}
+
+func (r *fakeDeliveryRepo) UpdateFields(deliveryUUID string, fields map[string]interface{}) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.updates[deliveryUUID] = fields
+	return nil
+}
+
+func (r *fakeDeliveryRepo) update(deliveryUUID string) map[string]interface{} {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.updates[deliveryUUID]
+}
+
+func newTestWebhookService(t *testing.T, maxAttempts int) (*WebhookService, *fakeDeliveryRepo) {
+	t.Helper()
+	deliveries := &fakeDeliveryRepo{updates: make(map[string]map[string]interface{})}
+	webhooks := &fakeWebhookRepo{failures: make(map[string]int)}
+	eb := eventbus.New()
+	t.Cleanup(eb.Close)
+	return NewWebhookService(webhooks, deliveries, nil, nil, eb, maxAttempts, 5, 100), deliveries
+}
+
+func testDelivery(deliveryUUID string) *model.WebhookDelivery {
+	payload := []byte(`{"delivery_uuid":"` + deliveryUUID + `","data":{"brightness":80}}`)
+	return model.NewWebhookDelivery(deliveryUUID, "wh-1", string(WebhookEventTypes[0]), payload, 0)
+}
+
+func TestWebhookRequestIsSigned(t *testing.T) {
+	const secret = "s3cret"
+	var verified atomic.Bool
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		timestamp := r.Header.Get("X-Webhook-Timestamp")
+		if _, err := strconv.ParseInt(timestamp, 10, 64); err != nil {
+			t.Errorf("timestamp header %q", timestamp)
+		}
+		mac := hmac.New(sha256.New, []byte(secret))
+		mac.Write([]byte(timestamp + "." + string(body)))
+		want := "sha256=" + hex.EncodeToString(mac.Sum(nil))
+		verified.Store(hmac.Equal([]byte(r.Header.Get("X-Webhook-Signature")), []byte(want)))
+	}))
+	defer srv.Close()
+
+	s, deliveries := newTestWebhookService(t, 3)
+	webhook := &model.Webhook{WebhookUUID: "wh-1", Global: true, URL: srv.URL, Secret: secret}
+	s.attempt(webhook, testDelivery("d-1"))
+
+	if !verified.Load() {
+		t.Error("signature does not match HMAC-SHA256(secret, timestamp.body)")
+	}
+	if status := deliveries.update("d-1")["status"]; status != model.WebhookDeliverySucceeded {
+		t.Errorf("status %v, want succeeded", status)
+	}
+	if SignWebhookPayload("other", "1", []byte("{}")) == SignWebhookPayload(secret, "1", []byte("{}")) {
+		t.Error("signature does not depend on the secret")
+	}
+}
+
+func TestWebhookRetryBackoff(t *testing.T) {
+	delays := map[int]time.Duration{
+		1:  10 * time.Second,
+		2:  20 * time.Second,
+		3:  40 * time.Second,
+		9:  2560 * time.Second,
+		10: time.Hour,
+		50: time.Hour,
+	}
+	for attempts, want := range delays {
+		if got := webhookRetryDelay(attempts); got != want {
+			t.Errorf("webhookRetryDelay(%d) = %v, want %v", attempts, got, want)
+		}
+	}
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+	s, deliveries := newTestWebhookService(t, 3)
+	webhook := &model.Webhook{WebhookUUID: "wh-1", Global: true, URL: srv.URL, Secret: "s"}
+
+	delivery := testDelivery("d-1")
+	for attempts := 1; attempts <= 3; attempts++ {
+		before := time.Now()
+		s.attempt(webhook, delivery)
+		fields := deliveries.update("d-1")
+		if fields["attempts"] != attempts || fields["response_code"] != http.StatusServiceUnavailable {
+			t.Fatalf("attempt %d recorded %v", attempts, fields)
+		}
+		if attempts < 3 {
+			next := fields["next_attempt_at"].(int64)
+			if want := before.Add(webhookRetryDelay(attempts)).Unix(); next < want || next > want+1 {
+				t.Errorf("attempt %d: next_attempt_at %d, want %d", attempts, next, want)
+			}
+			if _, ok := fields["status"]; ok {
+				t.Errorf("attempt %d: status changed to %v before the last attempt", attempts, fields["status"])
+			}
+		} else if fields["status"] != model.WebhookDeliveryFailed || fields["next_attempt_at"] != 0 {
+			t.Errorf("last attempt recorded %v, want failed", fields)
+		}
+		delivery.Attempts = attempts
+	}
+}
+
+func TestWebhookRejectsNonPublicTargets(t *testing.T) {
+	for _, target := range []string{
+		"http://127.0.0.1/hook",
+		"http://10.1.2.3/hook",
+		"http://192.168.0.10:8080/hook",
+		"http://169.254.169.254/latest/meta-data",
+		"http://[::1]/hook",
+		"http://[fd00::1]/hook",
+		"http://100.64.0.1/hook",
+		"http://localhost:9000/hook",
+		"http://api.localhost/hook",
+	} {
+		input := WebhookInput{Name: "n", URL: target, EventTypes: []string{string(WebhookEventTypes[0])}}
+		if err := validateWebhookInput(input, false); !errors.Is(err, ErrInvalidWebhook) {
+			t.Errorf("user webhook %s accepted (%v)", target, err)
+		}
+		if err := validateWebhookInput(input, true); err != nil {
+			t.Errorf("global webhook %s rejected: %v", target, err)
+		}
+	}
+	if err := validateWebhookInput(WebhookInput{URL: "http://93.184.216.34/hook", EventTypes: []string{string(WebhookEventTypes[0])}}, false); err != nil {
+		t.Errorf("public address rejected: %v", err)
+	}
+
+	// The dialer checks the address again, so a user webhook never reaches a loopback server
+	var hits atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+	}))
+	defer srv.Close()
+	s, deliveries := newTestWebhookService(t, 3)
+	s.attempt(&model.Webhook{WebhookUUID: "wh-1", URL: srv.URL, Secret: "s"}, testDelivery("d-1"))
+	if hits.Load() != 0 || deliveries.update("d-1")["error"] == "" {
+		t.Errorf("user webhook reached a loopback address: %v", deliveries.update("d-1"))
+	}
+}
+
+func TestWebhookDoesNotFollowRedirects(t *testing.T) {
+	var redirected atomic.Bool
+	mux := http.NewServeMux()
+	mux.HandleFunc("/hook", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/internal", http.StatusTemporaryRedirect)
+	})
+	mux.HandleFunc("/internal", func(w http.ResponseWriter, r *http.Request) {
+		redirected.Store(true)
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	s, deliveries := newTestWebhookService(t, 3)
+	s.attempt(&model.Webhook{WebhookUUID: "wh-1", Global: true, URL: srv.URL + "/hook", Secret: "s"}, testDelivery("d-1"))
+	if redirected.Load() {
+		t.Error("redirect followed")
+	}
+	if fields := deliveries.update("d-1"); fields["response_code"] != http.StatusTemporaryRedirect || fields["error"] == "" {
+		t.Errorf("redirect recorded as %v, want a failed attempt", fields)
+	}
+}
+
+func TestWebhookPerEndpointLimit(t *testing.T) {
+	var inFlight, peak, hits atomic.Int32
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		n := inFlight.Add(1)
+		for {
+			p := peak.Load()
+			if n <= p || peak.CompareAndSwap(p, n) {
+				break
+			}
+		}
+		<-release
+		inFlight.Add(-1)
+	}))
+	defer srv.Close()
+
+	s, deliveries := newTestWebhookService(t, 3)
+	s.Start()
+	webhook := model.Webhook{WebhookUUID: "wh-1", Global: true, URL: srv.URL, Secret: "s"}
+	for i := 0; i < 6; i++ {
+		s.dispatch(webhook, testDelivery("d-"+strconv.Itoa(i)))
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for hits.Load() < webhookPerEndpointLimit && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	s.Stop()
+
+	if hits.Load() != webhookPerEndpointLimit || peak.Load() != webhookPerEndpointLimit {
+		t.Errorf("%d requests, %d concurrent, want %d", hits.Load(), peak.Load(), webhookPerEndpointLimit)
+	}
+	// The deliveries that found the webhook busy were not attempted and stay pending for the retry loop
+	attempted := 0
+	for i := 0; i < 6; i++ {
+		if deliveries.update("d-"+strconv.Itoa(i)) != nil {
+			attempted++
+		}
+	}
+	if attempted != webhookPerEndpointLimit {
+		t.Errorf("%d deliveries attempted, want %d", attempted, webhookPerEndpointLimit)
+	}
+}
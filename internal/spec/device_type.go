@@ -0,0 +1,142 @@
+package spec
+
+import (
+	"OMEGA3-IOT/internal/model"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+)
+
+// knownFormats are the property formats understood by the converter and the IoTDB type mapping.
+var knownFormats = map[string]bool{
+	"int": true, "integer": true, "long": true,
+	"float": true, "double": true,
+	"bool": true, "boolean": true,
+	"string": true, "text": true, "markdown": true,
+	"time": true, "json": true,
+}
+
+var knownSeverities = map[string]bool{"": true, "info": true, "warning": true, "critical": true}
+
+// ValidateDeviceTypes checks a full set of device type definitions before it is loaded.
+// All problems are reported together so a broken file can be fixed in one pass.
+func ValidateDeviceTypes(deviceTypes []model.DeviceType) error {
+	var errs []error
+	names := make(map[string]bool, len(deviceTypes))
+	ids := make(map[int]string, len(deviceTypes))
+
+	for i, dt := range deviceTypes {
+		if dt.Name == "" {
+			errs = append(errs, fmt.Errorf("device type #%d: name is required", i))
+			continue
+		}
+		if names[dt.Name] {
+			errs = append(errs, fmt.Errorf("device type '%s': duplicate name", dt.Name))
+		}
+		names[dt.Name] = true
+		if dt.ID <= 0 {
+			errs = append(errs, fmt.Errorf("device type '%s': id must be positive", dt.Name))
+		} else if other, ok := ids[dt.ID]; ok {
+			errs = append(errs, fmt.Errorf("device type '%s': id %d already used by '%s'", dt.Name, dt.ID, other))
+		} else {
+			ids[dt.ID] = dt.Name
+		}
+		errs = append(errs, validateDeviceType(dt)...)
+	}
+	return errors.Join(errs...)
+}
+
+func validateDeviceType(dt model.DeviceType) []error {
+	var errs []error
+	fail := func(format string, args ...interface{}) {
+		errs = append(errs, fmt.Errorf("device type '%s': "+format, append([]interface{}{dt.Name}, args...)...))
+	}
+	if dt.RetentionDays < 0 {
+		fail("retention_days must not be negative")
+	}
+	checkCapabilities := func(kind, key string, required []string) {
+		for _, capability := range required {
+			if _, ok := dt.Capabilities[capability]; !ok {
+				fail("%s '%s' requires undeclared capability '%s'", kind, key, capability)
+			}
+		}
+	}
+
+	for _, key := range sortedKeys(dt.Properties) {
+		meta := dt.Properties[key]
+		if !knownFormats[meta.Format] {
+			fail("property '%s' has unknown format '%s'", key, meta.Format)
+		}
+		if len(meta.Range) != 0 {
+			if len(meta.Range) != 2 || meta.Range[0] > meta.Range[1] {
+				fail("property '%s' range must be [min, max]", key)
+			}
+		}
+		if meta.Pattern != "" {
+			if _, err := regexp.Compile(meta.Pattern); err != nil {
+				fail("property '%s' has invalid pattern: %v", key, err)
+			}
+		}
+		if meta.Default != nil && knownFormats[meta.Format] {
+			if err := ValidatePropertyValue(meta, meta.Default); err != nil {
+				fail("property '%s' has invalid default: %v", key, err)
+			}
+		}
+		if meta.RetentionDays < 0 {
+			fail("property '%s' retention_days must not be negative", key)
+		}
+		checkCapabilities("property", key, meta.RequiredCapabilities)
+	}
+
+	for _, key := range sortedKeys(dt.Actions) {
+		action := dt.Actions[key]
+		if action.TimeoutSec < 0 {
+			fail("action '%s' timeout_sec must not be negative", key)
+		}
+		params := make(map[string]bool, len(action.InputParams))
+		for _, param := range action.InputParams {
+			if param.Name == "" {
+				fail("action '%s' has an input param without name", key)
+				continue
+			}
+			if params[param.Name] {
+				fail("action '%s' has duplicate input param '%s'", key, param.Name)
+			}
+			params[param.Name] = true
+			if param.Type != "" && !knownFormats[param.Type] {
+				fail("action '%s' input param '%s' has unknown type '%s'", key, param.Name, param.Type)
+			}
+			if len(param.Range) != 0 && (len(param.Range) != 2 || param.Range[0] > param.Range[1]) {
+				fail("action '%s' input param '%s' range must be [min, max]", key, param.Name)
+			}
+		}
+	}
+
+	for _, key := range sortedKeys(dt.Events) {
+		event := dt.Events[key]
+		if !knownSeverities[event.Severity] {
+			fail("event '%s' has unknown severity '%s'", key, event.Severity)
+		}
+		if event.RetentionDays < 0 {
+			fail("event '%s' retention_days must not be negative", key)
+		}
+		for _, param := range event.OutputParams {
+			if param.Type != "" && !knownFormats[param.Type] {
+				fail("event '%s' output param '%s' has unknown type '%s'", key, param.Name, param.Type)
+			}
+		}
+		checkCapabilities("event", key, event.RequiredCapabilities)
+	}
+	return errs
+}
+
+// sortedKeys keeps error output stable across runs.
+func sortedKeys[T any](m map[string]T) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
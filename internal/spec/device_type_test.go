@@ -0,0 +1,44 @@
+package spec
+
+import (
+	"OMEGA3-IOT/internal/model"
+	"strings"
+	"testing"
+)
+
+func TestValidateDeviceTypes_Valid(t *testing.T) {
+	types := []model.DeviceType{{
+		ID:           1,
+		Name:         "Tracker",
+		Capabilities: map[string]model.Capability{"motion_sensor": {}},
+		Properties: map[string]model.PropertyMeta{
+			"battery": {Format: "int", Range: []float64{0, 100}},
+		},
+		Events: map[string]model.EventMeta{
+			"motion": {Severity: "info", RequiredCapabilities: []string{"motion_sensor"}},
+		},
+	}}
+	if err := ValidateDeviceTypes(types); err != nil {
+		t.Errorf("expected no error, got: %v", err)
+	}
+}
+
+func TestValidateDeviceTypes_ReportsAllProblems(t *testing.T) {
+	types := []model.DeviceType{
+		{ID: 1, Name: "Tracker", Properties: map[string]model.PropertyMeta{
+			"battery": {Format: "percent"},
+			"gps":     {Format: "string", Pattern: "("},
+		}},
+		{ID: 1, Name: "Lamp"},
+		{ID: 2, Name: "Lamp"},
+	}
+	err := ValidateDeviceTypes(types)
+	if err == nil {
+		t.Fatal("expected validation error, got nil")
+	}
+	for _, want := range []string{"unknown format 'percent'", "invalid pattern", "id 1 already used", "duplicate name"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("expected error to mention %q, got: %v", want, err)
+		}
+	}
+}
@@ -9,17 +9,26 @@ import (
 	"OMEGA3-IOT/internal/handler/MiddleWares"
 	"OMEGA3-IOT/internal/logger"
 	"OMEGA3-IOT/internal/model"
+	"OMEGA3-IOT/internal/mqttbroker"
+	"OMEGA3-IOT/internal/pki"
 	"OMEGA3-IOT/internal/push"
 	"OMEGA3-IOT/internal/repository"
 	"OMEGA3-IOT/internal/service"
 	"OMEGA3-IOT/internal/utils"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
 	"fmt"
+	"net"
+	"os"
+	"time"
 
 	"log"
+
+	"github.com/redis/go-redis/v9"
 )
 
 // var globalMQTTService *service.MQTTService // 全局 MQTT 服务变量 不用了 用依赖注入
-var iotdbClient *db.IOTDBClient
 
 var userService *service.UserService
 
@@ -28,7 +37,11 @@ func main() {
 	fmt.Printf("Hello and welcome, %s!\n", s)
 	//cfg, err := config.DeLoadConfig(".")
 	cfg, _ := config.LoadConfig("./internal/config")
-	if err := model.GlobalDeviceTypeManager.LoadDeviceTypeFromYAML("./internal/config/device_type_list.yaml"); err != nil {
+	deviceTypeFile := cfg.DeviceTypes.File
+	if deviceTypeFile == "" {
+		deviceTypeFile = "./internal/config/device_type_list.yaml"
+	}
+	if err := model.GlobalDeviceTypeManager.LoadDeviceTypeFromYAML(deviceTypeFile); err != nil {
 		log.Fatalf("Failed to load device types: %v", err)
 	}
 
@@ -39,32 +52,47 @@ func main() {
 	log.Println("[Main] Device types loaded successfully")
 	db.InitDB(cfg)
 	db.InitRedis(cfg)
-	iotdbClient, err := db.NewIotDBFromConfig(cfg)
-	if err != nil {
-		log.Fatalf("[Main] Failed to create IoTDB client: %v", err)
-	}
-	if err := iotdbClient.InitializeSchema(); err != nil {
-		log.Fatalf("[Main] Failed to initialize IoTDB schema: %v", err)
-	}
-	defer iotdbClient.Close()
+	telemetryRepo, logStore, closeTimeSeries := openTimeSeriesBackend(cfg)
+	defer closeTimeSeries()
 
 	// Initialize EventBus
-	eventBus := eventbus.New()
+	eventBus := openEventBus(cfg)
+	defer eventBus.Close()
+	deadLetterService := service.NewEventDeadLetterService(repository.NewEventDeadLetterRepository(db.DB), eventBus)
+	eventBus.SetDeadLetterSink(deadLetterService)
 	log.Println("[Main] EventBus initialized")
 
 	// Initialize LoggerService
-	loggerService := logger.NewLoggerService(iotdbClient, eventBus)
+	loggerService := logger.NewLoggerService(logStore, eventBus)
 	if err := loggerService.InitializeLogSchema(); err != nil {
 		log.Printf("[Main] Warning: Failed to initialize log schema: %v", err)
 	}
 	loggerService.Start()
 	log.Println("[Main] LoggerService started")
 
-	deviceService := service.NewDeviceService(db.DB, iotdbClient)
-
 	// Create repositories
 	instanceRepo := repository.NewInstanceRepository(db.DB)
 
+	// Initialize TelemetryIngestService (batched time-series and last-value writes). Stopped after
+	// the MQTT client disconnects so buffered reports are flushed.
+	ingestService := service.NewTelemetryIngestService(
+		db.DB,
+		instanceRepo,
+		telemetryRepo,
+		cfg.TelemetryIngest.BatchSize,
+		cfg.TelemetryIngest.FlushIntervalMs,
+		cfg.TelemetryIngest.BufferSize,
+		cfg.TelemetryIngest.EnqueueTimeoutMs,
+		cfg.TelemetryIngest.MaxTimestampAgeSec,
+		cfg.TelemetryIngest.MaxTimestampFutureSec,
+		cfg.TelemetryIngest.OutOfWindow,
+	)
+	ingestService.Start()
+	defer ingestService.Stop()
+	log.Println("[Main] TelemetryIngestService started")
+
+	deviceService := service.NewDeviceService(db.DB, telemetryRepo, ingestService)
+
 	// Initialize PresenceService
 	presenceService := service.NewPresenceService(
 		instanceRepo,
@@ -76,16 +104,58 @@ func main() {
 	defer presenceService.Stop()
 	log.Println("[Main] PresenceService started")
 
-	newURL := fmt.Sprintf("%s://%s:%d", cfg.MQTT.Broker.Protocol, cfg.MQTT.Broker.Host, cfg.MQTT.Broker.Port)
-	mqttService, err := service.NewMQTTService(newURL, deviceService, loggerService, presenceService, eventBus)
+	// Device types (stored in MySQL, seeded and reloaded from the YAML file)
+	deviceTypeService := service.NewDeviceTypeService(db.DB, repository.NewDeviceTypeRepository(db.DB), instanceRepo, telemetryRepo, eventBus, deviceTypeFile, cfg.DeviceTypes.WatchIntervalSec)
+	deviceTypeService.Start()
+	defer deviceTypeService.Stop()
+
+	// Device events (validated and recorded, invalid ones quarantined)
+	deviceEventRepo := repository.NewDeviceEventRepository(db.DB)
+	deviceEventService := service.NewDeviceEventService(deviceEventRepo, ingestService)
+	deviceEventHandler := handler.NewDeviceEventHandler(deviceEventService)
+
+	// Device certificates issued by the built-in CA
+	var deviceCertService *service.DeviceCertificateService
+	var deviceCertificateHandler *handler.DeviceCertificateHandler
+	if cfg.PKI.Enabled {
+		pkiDir := cfg.PKI.Dir
+		if pkiDir == "" {
+			pkiDir = "./data/pki"
+		}
+		ca, err := pki.LoadOrCreateCA(pkiDir)
+		if err != nil {
+			log.Fatalf("[Main] Failed to load device CA: %v", err)
+		}
+		deviceCertService = service.NewDeviceCertificateService(repository.NewDeviceCertificateRepository(db.DB), ca, cfg.PKI.ValidityDays, eventBus)
+		deviceCertificateHandler = handler.NewDeviceCertificateHandler(deviceCertService)
+		log.Printf("[Main] Device CA loaded from %s", pkiDir)
+	}
+
+	deviceRegistrationRepo := repository.NewDeviceRegistrationRecordRepository(db.DB)
+	deviceAuthenticator := service.NewDeviceBrokerAuthenticator(instanceRepo, deviceRegistrationRepo, deviceCertService, cfg.MQTT.LegacyVerifyCode)
+	mqttConnection, closeBroker := openMQTTBroker(cfg, deviceAuthenticator, deviceCertService, eventBus)
+	defer closeBroker()
+	mqttService, err := service.NewMQTTService(mqttConnection, deviceService, loggerService, presenceService, deviceEventService, eventBus)
 	if err != nil {
 		log.Fatalf("[Main] Failed to initialize MQTT service: %v", err)
 	}
 	defer mqttService.Disconnect(250)
 
+	// Initialize ActionService (action lifecycle tracking)
+	actionRecordRepo := repository.NewActionRecordRepository(db.DB)
+	actionService := service.NewActionService(actionRecordRepo, instanceRepo, mqttService, presenceService, eventBus)
+	actionService.Start()
+	defer actionService.Stop()
+	log.Println("[Main] ActionService started")
+
+	// Initialize ShadowService (desired/reported device state)
+	shadowRepo := repository.NewDeviceShadowRepository(db.DB)
+	shadowService := service.NewShadowService(shadowRepo, instanceRepo, mqttService, eventBus)
+	shadowService.Start()
+	log.Println("[Main] ShadowService started")
+
 	// Create repositories
 	userRepo := repository.NewUserRepository(db.DB)
-	deviceRegistrationRepo := repository.NewDeviceRegistrationRecordRepository(db.DB)
 
 	// Token blacklist
 	tokenBlacklistRepo := repository.NewTokenBlacklistRepository(db.RedisClient)
@@ -104,30 +174,37 @@ func main() {
 	avatarService := service.NewAvatarService("")
 	log.Println("[Main] AvatarService created")
 
-	userService = service.NewUserService(mqttService, userRepo, instanceRepo, deviceRegistrationRepo, iotdbClient, loggerService, avatarService, dhService, nonceRepo)
+	userService = service.NewUserService(mqttService, userRepo, instanceRepo, deviceRegistrationRepo, telemetryRepo, loggerService, avatarService, dhService, nonceRepo)
 	log.Println("[Main] UserService created")
 	userHandler := handler.NewUserHandler(userService, tokenBlacklistService)
 	log.Println("[Main] UserHandler created")
 	deviceShareService := service.NewDeviceShareService(db.DB, loggerService)
 	log.Println("[Main] DeviceShareService created")
-	deviceHandler := handler.NewDeviceHandler(db.DB, mqttService)
+	deviceHandler := handler.NewDeviceHandler(db.DB, mqttService, deviceCertService)
 
 	// Create LogHandler
 	logHandler := logger.NewLogHandler(loggerService)
 	log.Println("[Main] LogHandler created")
 
-	deviceFolderService := service.NewDeviceFolderService(db.DB, iotdbClient, loggerService)
+	deviceFolderService := service.NewDeviceFolderService(db.DB, telemetryRepo, loggerService)
 	log.Println("[Main] DeviceFolderService created")
 	deviceFolderHandler := handler.NewDeviceFolderHandler(deviceFolderService)
 	log.Println("[Main] DeviceFolderHandler created")
 
+	// Alert rule engine (evaluated on property updates)
+	alertRuleRepo := repository.NewAlertRuleRepository(db.DB)
+	alertRuleService := service.NewAlertRuleService(alertRuleRepo, instanceRepo, repository.NewDeviceFolderRepository(db.DB), repository.NewAlertNotificationRepository(db.RedisClient), deviceShareService, eventBus)
+	alertRuleService.Start()
+	alertRuleHandler := handler.NewAlertRuleHandler(alertRuleService)
+	log.Println("[Main] AlertRuleService started")
+
 	// User Group system
 	groupRepo := repository.NewUserGroupRepository(db.DB)
 	groupMemberRepo := repository.NewGroupMemberRepository(db.DB)
 	groupPolicyRepo := repository.NewGroupPolicyRepository(db.DB)
 	groupInviteRepo := repository.NewGroupInviteRepository(db.DB)
 	groupDeviceShareRepo := repository.NewGroupDeviceShareRepository(db.DB)
-	userGroupService := service.NewUserGroupService(db.DB, groupRepo, groupMemberRepo, groupPolicyRepo, groupInviteRepo, groupDeviceShareRepo, instanceRepo, userRepo)
+	userGroupService := service.NewUserGroupService(db.DB, groupRepo, groupMemberRepo, groupPolicyRepo, groupInviteRepo, groupDeviceShareRepo, instanceRepo, userRepo, eventBus)
 	groupInviteService := service.NewGroupInviteService(userGroupService, groupInviteRepo, groupMemberRepo, groupPolicyRepo, groupRepo, userRepo)
 	userGroupHandler := handler.NewUserGroupHandler(userGroupService, groupInviteService)
 	log.Println("[Main] UserGroupHandler created")
@@ -137,9 +214,89 @@ func main() {
 	adminDevRepo := repository.NewAdminDeviceRepository(db.DB)
 	adminLogRepo := repository.NewAdminLogRepository(db.DB)
 	adminService := service.NewAdminService(db.DB, userRepo, adminUserRepo, adminDevRepo, instanceRepo, groupRepo, groupMemberRepo, adminLogRepo, dhService, nonceRepo)
-	adminHandler := handler.NewAdminHandler(adminService)
+	adminHandler := handler.NewAdminHandler(adminService, ingestService)
 	log.Println("[Main] AdminHandler created")
 
+	// Outbound webhooks (EventBus → HTTP)
+	webhookService := service.NewWebhookService(
+		repository.NewWebhookRepository(db.DB),
+		repository.NewWebhookDeliveryRepository(db.DB),
+		deviceShareService,
+		userGroupService,
+		eventBus,
+		cfg.Webhook.MaxAttempts,
+		cfg.Webhook.TimeoutSec,
+		cfg.Webhook.DisableAfterFailures,
+	)
+	webhookService.Start()
+	defer webhookService.Stop()
+	webhookHandler := handler.NewWebhookHandler(webhookService)
+	adminWebhookHandler := handler.NewAdminWebhookHandler(webhookService, adminService)
+	log.Println("[Main] WebhookService started")
+	deviceTypeHandler := handler.NewDeviceTypeHandler(deviceTypeService, adminService)
+
+	// Telemetry export (direct downloads and background jobs)
+	telemetryExportService := service.NewTelemetryExportService(
+		repository.NewTelemetryExportJobRepository(db.DB),
+		instanceRepo,
+		repository.NewDeviceFolderRepository(db.DB),
+		deviceShareService,
+		userGroupService,
+		telemetryRepo,
+		cfg.TelemetryExport.Dir,
+		cfg.TelemetryExport.MaxSyncRangeHours,
+		cfg.TelemetryExport.RetentionHours,
+		cfg.TelemetryExport.MaxPendingJobs,
+	)
+	telemetryExportService.Start()
+	defer telemetryExportService.Stop()
+	telemetryExportHandler := handler.NewTelemetryExportHandler(telemetryExportService)
+	log.Println("[Main] TelemetryExportService started")
+
+	// Data retention (scheduled deletes of expired telemetry and logs)
+	retentionService := service.NewRetentionService(
+		db.DB,
+		repository.NewRetentionOverrideRepository(db.DB),
+		instanceRepo,
+		telemetryRepo,
+		deviceEventRepo,
+		loggerService,
+		cfg.Retention.DefaultDays,
+		cfg.Retention.UserLogDays,
+		cfg.Retention.QuarantineDays,
+		cfg.Retention.IntervalHours,
+	)
+	retentionService.Start()
+	defer retentionService.Stop()
+	retentionHandler := handler.NewRetentionHandler(retentionService, adminService)
+	log.Println("[Main] RetentionService started")
+
+	// Auth hook for external MQTT brokers (connection-level device authentication)
+	var mqttAuthHookHandler *handler.MQTTAuthHookHandler
+	if cfg.MQTT.AuthHook.Enabled {
+		if cfg.MQTT.AuthHook.Secret == "" {
+			log.Fatalf("[Main] mqtt.auth_hook.secret is required when the auth hook is enabled")
+		}
+		mqttAuthHook := service.NewMQTTAuthHook(deviceAuthenticator, cfg.MQTT.Client.Username, cfg.MQTT.Client.Password)
+		mqttAuthHookHandler = handler.NewMQTTAuthHookHandler(mqttAuthHook, cfg.MQTT.AuthHook.Secret)
+	}
+
+	// Device credential rotation and reset. The new verify code is delivered over MQTT, which is
+	// only private when the broker confines devices to their own topics.
+	deviceCredentialService := service.NewDeviceCredentialService(
+		instanceRepo,
+		mqttService,
+		deviceCertService,
+		eventBus,
+		cfg.MQTT.Embedded.Enabled || cfg.MQTT.AuthHook.Enabled,
+		cfg.DeviceCredentials.GracePeriodHours,
+		cfg.DeviceCredentials.ResetCodeTTLHours,
+	)
+	deviceCredentialService.Start()
+	defer deviceCredentialService.Stop()
+	deviceCredentialHandler := handler.NewDeviceCredentialHandler(deviceCredentialService, adminService)
+	eventBusHandler := handler.NewEventBusHandler(deadLetterService, adminService)
+
 	// Bootstrap admin
 	if err := adminService.BootstrapAdmin("admin"); err != nil {
 		log.Printf("[Main] Warning: Bootstrap admin failed: %v", err)
@@ -149,7 +306,7 @@ func main() {
 	log.Println("[Main] JWTAuth middleware created")
 
 	// Initialize PushService (WebSocket push channel)
-	pushService := push.NewPushService(eventBus, instanceRepo, userRepo)
+	pushService := push.NewPushService(eventBus, instanceRepo, userRepo, actionService, shadowService, deviceShareService, repository.NewOfflineMessageRepository(db.DB))
 	pushService.Start()
 	defer pushService.Stop()
 	pushHandler := push.NewPushHandler(pushService)
@@ -159,9 +316,246 @@ func main() {
 	publicInstanceService := service.NewPublicInstanceService(db.DB)
 	log.Println("[Main] PublicInstanceService created")
 
-	httpApiErr := http_api.Run(mqttService, userHandler, deviceHandler, logHandler, cfg, deviceService, deviceShareService, deviceFolderHandler, jwtAuth, pushHandler, userGroupHandler, adminHandler, publicInstanceService)
+	httpApiErr := http_api.Run(cfg, handler.Dependencies{
+		JWTAuth:                  jwtAuth,
+		UserHandler:              userHandler,
+		UserGroupHandler:         userGroupHandler,
+		AdminHandler:             adminHandler,
+		DeviceHandler:            deviceHandler,
+		DeviceFolderHandler:      deviceFolderHandler,
+		DeviceTypeHandler:        deviceTypeHandler,
+		DeviceEventHandler:       deviceEventHandler,
+		DeviceCertificateHandler: deviceCertificateHandler,
+		DeviceCredentialHandler:  deviceCredentialHandler,
+		AlertRuleHandler:         alertRuleHandler,
+		WebhookHandler:           webhookHandler,
+		AdminWebhookHandler:      adminWebhookHandler,
+		TelemetryExportHandler:   telemetryExportHandler,
+		RetentionHandler:         retentionHandler,
+		EventBusHandler:          eventBusHandler,
+		LogHandler:               logHandler,
+		PushHandler:              pushHandler,
+		MQTTAuthHookHandler:      mqttAuthHookHandler,
+		DeviceService:            deviceService,
+		DeviceShareService:       deviceShareService,
+		PublicInstanceService:    publicInstanceService,
+		ActionService:            actionService,
+		ShadowService:            shadowService,
+	}, deviceCertService)
 	log.Println("[Main] After calling http_api.Run")
 	if httpApiErr != nil {
 		log.Panicf("[Main] Error starting HTTP server: %v", httpApiErr)
 	}
 }
+
+// eventBusRedisPoolSize bounds the connections of the event bus: one blocking reader per consumer
+// group plus publishers and acknowledgements.
+const eventBusRedisPoolSize = 64
+
+// openEventBus selects the event bus backend: in memory (default) or durable on Redis Streams.
+func openEventBus(cfg config.Config) *eventbus.EventBus {
+	options := eventbus.Options{
+		Delivery: eventbus.DeliveryOptions{
+			QueueSize:    cfg.EventBus.QueueSize,
+			Workers:      cfg.EventBus.Workers,
+			MaxAttempts:  cfg.EventBus.MaxAttempts,
+			RetryBackoff: time.Duration(cfg.EventBus.RetryBackoffMs) * time.Millisecond,
+			BlockTimeout: time.Duration(cfg.EventBus.BlockTimeoutMs) * time.Millisecond,
+		},
+		DrainTimeout: time.Duration(cfg.EventBus.DrainTimeoutSec) * time.Second,
+	}
+
+	switch cfg.EventBus.Backend {
+	case "", "memory":
+		log.Println("[Main] EventBus backend: memory")
+		return eventbus.NewWithOptions(options)
+	case "redis":
+		// Stream readers hold a connection while they wait for entries; a separate client keeps
+		// them from starving token blacklist and nonce lookups
+		clientOptions := *db.RedisClient.Options()
+		clientOptions.PoolSize = eventBusRedisPoolSize
+		return eventbus.NewRedisStreams(redis.NewClient(&clientOptions), eventbus.RedisStreamsOptions{
+			Options:       options,
+			Prefix:        cfg.EventBus.StreamPrefix,
+			MaxLen:        cfg.EventBus.StreamMaxLen,
+			ClaimIdle:     time.Duration(cfg.EventBus.ClaimIdleSec) * time.Second,
+			MaxDeliveries: cfg.EventBus.MaxDeliveries,
+		})
+	default:
+		log.Fatalf("[Main] Unknown event bus backend %q (expected memory or redis)", cfg.EventBus.Backend)
+		return nil
+	}
+}
+
+// openTimeSeriesBackend opens the telemetry and log storage selected by timeseries.backend:
+// IoTDB, or the embedded file store for deployments without an IoTDB cluster.
+func openTimeSeriesBackend(cfg config.Config) (repository.TelemetryRepository, logger.LogStore, func()) {
+	switch cfg.TimeSeries.Backend {
+	case "", "iotdb":
+		if cfg.IoTDB.Host == "" {
+			log.Fatalf("[Main] IoTDB host not configured; set IoTDB.host or timeseries.backend: embedded")
+		}
+		iotdbClient, err := db.NewIotDBFromConfig(cfg)
+		if err != nil {
+			log.Fatalf("[Main] Failed to create IoTDB client: %v", err)
+		}
+		if err := iotdbClient.InitializeSchema(); err != nil {
+			log.Fatalf("[Main] Failed to initialize IoTDB schema: %v", err)
+		}
+		log.Println("[Main] Time-series backend: IoTDB")
+		return repository.NewTelemetryRepository(iotdbClient), logger.NewIoTDBLogStore(iotdbClient), iotdbClient.Close
+	case "embedded":
+		dir := cfg.TimeSeries.EmbeddedDir
+		if dir == "" {
+			dir = "./data/tsdb"
+		}
+		store, err := db.NewEmbeddedTSDB(dir)
+		if err != nil {
+			log.Fatalf("[Main] Failed to open embedded time-series store: %v", err)
+		}
+		log.Printf("[Main] Time-series backend: embedded (%s)", dir)
+		return repository.NewEmbeddedTelemetryRepository(store), logger.NewEmbeddedLogStore(store), func() { store.Close() }
+	default:
+		log.Fatalf("[Main] Unknown time-series backend %q (expected iotdb or embedded)", cfg.TimeSeries.Backend)
+		return nil, nil, nil
+	}
+}
+
+// openMQTTBroker returns how the MQTT service reaches the broker. With mqtt.embedded.enabled the
+// in-process broker is started and devices authenticate on CONNECT; otherwise the external
+// broker in mqtt.broker is used, which authenticates devices when it calls the auth hook.
+func openMQTTBroker(cfg config.Config, authenticator *service.DeviceBrokerAuthenticator, certService *service.DeviceCertificateService, eventBus *eventbus.EventBus) (service.MQTTConnection, func()) {
+	if !cfg.MQTT.Embedded.Enabled {
+		if cfg.MQTT.Broker.Host == "" {
+			log.Fatalf("[Main] MQTT broker not configured; set mqtt.broker.host or mqtt.embedded.enabled")
+		}
+		var tlsConfig *tls.Config
+		if cfg.MQTT.TLS.Enabled {
+			var err error
+			if tlsConfig, err = mqttClientTLSConfig(cfg.MQTT.TLS); err != nil {
+				log.Fatalf("[Main] Failed to prepare MQTT client TLS: %v", err)
+			}
+		}
+		// Legacy connections have no identity at an external broker, so their messages are
+		// checked by verify_code
+		return service.MQTTConnection{
+			BrokerURL:           cfg.MQTT.Broker.Address(),
+			ClientID:            cfg.MQTT.Client.ID,
+			Username:            cfg.MQTT.Client.Username,
+			Password:            cfg.MQTT.Client.Password,
+			TLSConfig:           tlsConfig,
+			BrokerAuthenticated: cfg.MQTT.AuthHook.Enabled && !cfg.MQTT.LegacyVerifyCode,
+		}, func() {}
+	}
+
+	embedded := cfg.MQTT.Embedded
+	broker := mqttbroker.New(authenticator, mqttbroker.DeviceTopicACL{}, embedded.MaxPacketSize)
+	// Connections authenticated with revoked credentials are closed, on every server process
+	eventbus.SubscribeTyped(eventBus, eventbus.EventType(logger.LogEventDeviceCredentialsRevoked), func(ctx context.Context, event logger.DeviceLogEvent) error {
+		broker.DisconnectDevice(event.DeviceUUID)
+		return nil
+	}, eventbus.Broadcast())
+	listener, err := net.Listen("tcp", embedded.Listen)
+	if err != nil {
+		log.Fatalf("[Main] Failed to listen for MQTT on %s: %v", embedded.Listen, err)
+	}
+	serve := func(l net.Listener) {
+		if err := broker.Serve(l); err != nil {
+			log.Printf("[Main] Embedded MQTT broker stopped: %v", err)
+		}
+	}
+	go serve(listener)
+	log.Printf("[Main] Embedded MQTT broker listening on %s", listener.Addr())
+
+	if embedded.TLSListen != "" {
+		tlsConfig, err := embeddedBrokerTLSConfig(embedded, certService)
+		if err != nil {
+			log.Fatalf("[Main] Failed to prepare MQTT TLS: %v", err)
+		}
+		tlsListener, err := tls.Listen("tcp", embedded.TLSListen, tlsConfig)
+		if err != nil {
+			log.Fatalf("[Main] Failed to listen for MQTT over TLS on %s: %v", embedded.TLSListen, err)
+		}
+		go serve(tlsListener)
+		log.Printf("[Main] Embedded MQTT broker listening on %s (TLS, client certificates: %v, required: %v)", tlsListener.Addr(), tlsConfig.ClientCAs != nil, embedded.RequireClientCert)
+	}
+
+	// The server's own client connects in memory and is not subject to the device ACL; devices may
+	// not use its client identifier
+	broker.ReserveClientID(cfg.MQTT.Client.ID)
+	return service.MQTTConnection{
+		BrokerURL:           "tcp://" + listener.Addr().String(),
+		ClientID:            cfg.MQTT.Client.ID,
+		Dial:                func() (net.Conn, error) { return broker.ConnectInternal(), nil },
+		BrokerAuthenticated: true,
+	}, func() { broker.Close() }
+}
+
+// embeddedBrokerTLSConfig loads the broker certificate, self-signed when none is configured.
+// Devices may log in with a client certificate of the client CA or the built-in CA instead of a
+// password; with require_client_cert they must.
+func embeddedBrokerTLSConfig(embedded config.EmbeddedBroker, certService *service.DeviceCertificateService) (*tls.Config, error) {
+	certFile, keyFile, err := utils.EnsureCertificates(embedded.CertFile, embedded.KeyFile)
+	if err != nil {
+		return nil, err
+	}
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, err
+	}
+	tlsConfig := &tls.Config{Certificates: []tls.Certificate{cert}, MinVersion: tls.VersionTLS12}
+
+	var pool *x509.CertPool
+	if certService != nil {
+		pool = certService.CertPool()
+	}
+	if embedded.ClientCAFile != "" {
+		caPEM, err := os.ReadFile(embedded.ClientCAFile)
+		if err != nil {
+			return nil, err
+		}
+		if pool == nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(caPEM) {
+			return nil, fmt.Errorf("no certificates in %s", embedded.ClientCAFile)
+		}
+	}
+	if pool == nil {
+		if embedded.RequireClientCert {
+			return nil, fmt.Errorf("require_client_cert needs pki.enabled or client_ca_file")
+		}
+		return tlsConfig, nil
+	}
+	tlsConfig.ClientCAs = pool
+	tlsConfig.ClientAuth = tls.VerifyClientCertIfGiven
+	if embedded.RequireClientCert {
+		tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return tlsConfig, nil
+}
+
+// mqttClientTLSConfig builds the TLS settings of the server's MQTT client from mqtt.tls: the CA
+// to verify the broker with and an optional client certificate for brokers requiring mTLS.
+func mqttClientTLSConfig(tlsCfg config.TLS) (*tls.Config, error) {
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+	if tlsCfg.CACertFile != "" {
+		caPEM, err := os.ReadFile(tlsCfg.CACertFile)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(caPEM) {
+			return nil, fmt.Errorf("no certificates in %s", tlsCfg.CACertFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+	if tlsCfg.ClientCertFile != "" || tlsCfg.ClientKeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(tlsCfg.ClientCertFile, tlsCfg.ClientKeyFile)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	return tlsConfig, nil
+}